@@ -0,0 +1,215 @@
+package iso9660
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// SetAlignment makes the extent of a staged file start at a multiple of the given number of sectors,
+// for example 32 for 64 KiB or 512 for 1 MiB. The gap before it is filled with zeroes.
+// If the file shares its extent with others, as hard links or because of SetDeduplicate,
+// the extent is aligned to satisfy all of them. A value of 0 or 1 removes the alignment.
+func (iw *ImageWriter) SetAlignment(isoPath string, sectors int) error {
+	if sectors < 0 || int64(sectors) > math.MaxUint32 {
+		return fmt.Errorf("invalid alignment of %d sectors", sectors)
+	}
+
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	entry := iw.lookup(isoPath)
+	if entry == nil {
+		return fmt.Errorf("aligning %q: %w", isoPath, os.ErrNotExist)
+	}
+	if entry.source == nil {
+		return fmt.Errorf("aligning %q: only the extents of regular files can be aligned, not of a %s", isoPath, entry.kind())
+	}
+
+	if sectors <= 1 {
+		delete(iw.alignments, entry)
+		return nil
+	}
+	if iw.alignments == nil {
+		iw.alignments = make(map[*stagedEntry]uint32)
+	}
+	iw.alignments[entry] = uint32(sectors)
+	return nil
+}
+
+// SetDefaultAlignment makes the extents of all files start at a multiple of the given number of sectors.
+// Files with an alignment of their own, see SetAlignment, are aligned to satisfy both.
+// The default is 0, which doesn't align the files.
+func (iw *ImageWriter) SetDefaultAlignment(sectors int) error {
+	if sectors < 0 || int64(sectors) > math.MaxUint32 {
+		return fmt.Errorf("invalid alignment of %d sectors", sectors)
+	}
+	iw.defaultAlignment = uint32(sectors)
+	return nil
+}
+
+// SetFixedLBA makes the extent of a staged file start at the given logical block address.
+// The other files are placed around it and the sectors left free before it are filled with zeroes.
+// The location must lie after the descriptors, path tables and directories, and the extents of two pinned files
+// must not overlap, otherwise WriteTo fails. A pinned file isn't aligned, see SetAlignment.
+// If the file shares its extent with others, as hard links or because of SetDeduplicate,
+// the extent is placed at the location and pinning them to different ones is an error.
+func (iw *ImageWriter) SetFixedLBA(isoPath string, lba uint32) error {
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	entry := iw.lookup(isoPath)
+	if entry == nil {
+		return fmt.Errorf("pinning %q: %w", isoPath, os.ErrNotExist)
+	}
+	if entry.source == nil {
+		return fmt.Errorf("pinning %q: only the extents of regular files can be pinned, not of a %s", isoPath, entry.kind())
+	}
+
+	if iw.fixedLBAs == nil {
+		iw.fixedLBAs = make(map[*stagedEntry]uint32)
+	}
+	iw.fixedLBAs[entry] = lba
+	return nil
+}
+
+// SetSortWeight sets a function giving the weight of every file, like the sort file of mkisofs -sort,
+// or removes it if fn is nil. It is called with the path of the file when the image is written.
+// The extents of the files are placed in the order of their weights, the heaviest first at the lowest sectors,
+// and in the order of their directories otherwise, which is also the default for all files.
+// Giving boot images and kernels the heaviest weight places them right after the directories,
+// for firmware which only reads the beginning of the disc. SetFixedLBA pins a file to a sector instead.
+// Files sharing an extent, as hard links or because of SetDeduplicate, place it with the heaviest of their weights.
+func (iw *ImageWriter) SetSortWeight(fn func(isoPath string) int) {
+	iw.sortWeight = fn
+}
+
+// allocationOrder returns the files in the order their extents are allocated, sorted by weight if there is a sort function.
+// owners maps the files sharing an extent to the file whose data is written.
+func (wc *writeContext) allocationOrder(owners map[*layoutNode]*layoutNode) []*layoutNode {
+	if wc.sortWeight == nil {
+		return wc.files
+	}
+
+	weights := make(map[*layoutNode]int, len(wc.files))
+	for _, file := range wc.files {
+		owner := file
+		if owners[file] != nil {
+			owner = owners[file]
+		}
+		weight := wc.sortWeight(file.entry.path())
+		if current, ok := weights[owner]; !ok || weight > current {
+			weights[owner] = weight
+		}
+	}
+
+	order := append([]*layoutNode(nil), wc.files...)
+	sort.SliceStable(order, func(i, j int) bool {
+		return weights[order[i]] > weights[order[j]]
+	})
+	return order
+}
+
+// fixedLBAError reports a pinned location which cannot be honored
+func fixedLBAError(path string, format string, args ...interface{}) error {
+	return &ValidationError{Findings: []Finding{{
+		Severity: SeverityError,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	}}}
+}
+
+// reserveFixedLBAs checks the pinned locations of the files against the directories and each other.
+// It returns the pinned files with data, ordered by location.
+func (wc *writeContext) reserveFixedLBAs(pins map[*layoutNode]uint32) ([]*layoutNode, error) {
+	var reserved []*layoutNode
+	for _, file := range wc.files {
+		lba, ok := pins[file]
+		if !ok {
+			continue
+		}
+		if lba < wc.dataStart {
+			return nil, fixedLBAError(file.entry.path(), "pinned sector %d lies before sector %d, where the directories end", lba, wc.dataStart)
+		}
+		sectors := sizeToSectors(file.length)
+		if uint64(lba)+uint64(sectors) > math.MaxUint32 {
+			return nil, fixedLBAError(file.entry.path(), "extent pinned to sector %d ends beyond the last addressable sector", lba)
+		}
+		if sectors > 0 {
+			file.location = lba
+			reserved = append(reserved, file)
+		}
+	}
+
+	sort.SliceStable(reserved, func(i, j int) bool {
+		return reserved[i].location < reserved[j].location
+	})
+	for i := 1; i < len(reserved); i++ {
+		previous, file := reserved[i-1], reserved[i]
+		if previous.location+sizeToSectors(previous.length) > file.location {
+			return nil, fixedLBAError(file.entry.path(), "extent pinned to sector %d overlaps the extent of %s, pinned to sector %d",
+				file.location, previous.entry.path(), previous.location)
+		}
+	}
+	return reserved, nil
+}
+
+// allocateAround assigns the sectors of an extent with the given alignment, skipping the reserved extents
+func (wc *writeContext) allocateAround(sectors uint32, alignment uint32, reserved []*layoutNode) uint32 {
+	if sectors == 0 {
+		return wc.freeSectorPointer
+	}
+
+	for {
+		start := wc.freeSectorPointer
+		if alignment > 1 {
+			if offset := start % alignment; offset != 0 {
+				start += alignment - offset
+			}
+		}
+
+		moved := false
+		for _, r := range reserved {
+			end := r.location + sizeToSectors(r.length)
+			if start < end && r.location < start+sectors {
+				wc.freeSectorPointer = end
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			wc.freeSectorPointer = start + sectors
+			return start
+		}
+	}
+}
+
+// fileAlignment returns the alignment the extent of the file requires, in sectors
+func (wc *writeContext) fileAlignment(e *stagedEntry) (uint32, error) {
+	return combineAlignments(wc.defaultAlignment, wc.alignments[e])
+}
+
+// combineAlignments returns the least alignment satisfying both a and b, which is their least common multiple
+func combineAlignments(a, b uint32) (uint32, error) {
+	if a <= 1 {
+		return b, nil
+	}
+	if b <= 1 {
+		return a, nil
+	}
+
+	x, y := a, b
+	for y != 0 {
+		x, y = y, x%y
+	}
+	lcm := uint64(a) / uint64(x) * uint64(b)
+	if lcm > math.MaxUint32 {
+		return 0, fmt.Errorf("the alignments of %d and %d sectors cannot be combined", a, b)
+	}
+	return uint32(lcm), nil
+}
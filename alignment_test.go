@@ -0,0 +1,246 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterAlignment(t *testing.T) {
+	iw, err := NewWriter()
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+
+	squashfs := strings.Repeat("S", 3*int(sectorSize))
+	disk := strings.Repeat("D", 100)
+	require.NoError(t, iw.AddFile(strings.NewReader("a"), "A.TXT"))
+	require.NoError(t, iw.AddFile(strings.NewReader(squashfs), "B.SQFS"))
+	require.NoError(t, iw.AddFile(strings.NewReader(disk), "C.IMG"))
+	require.NoError(t, iw.AddFile(strings.NewReader(""), "D.TXT"))
+	require.NoError(t, iw.SetAlignment("B.SQFS", 32))
+	require.NoError(t, iw.SetAlignment("C.IMG", 512))
+	require.NoError(t, iw.SetAlignment("D.TXT", 512))
+
+	assert.True(t, errors.Is(iw.SetAlignment("MISSING", 32), os.ErrNotExist))
+	require.NoError(t, iw.AddDirectory("DIR"))
+	assert.EqualError(t, iw.SetAlignment("DIR", 32), `aligning "DIR": only the extents of regular files can be aligned, not of a directory`)
+	assert.EqualError(t, iw.SetAlignment("A.TXT", -1), "invalid alignment of -1 sectors")
+
+	var buf bytes.Buffer
+	require.NoError(t, iw.WriteTo(&buf, "aligned"))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	pvd, err := img.primaryVolume()
+	require.NoError(t, err)
+	assert.Equal(t, int(pvd.VolumeSpaceSize)*int(sectorSize), buf.Len())
+
+	files := filesByPath(t, img)
+	assert.NotZero(t, files["/A.TXT"].de.ExtentLocation%32)
+	assert.Zero(t, files["/B.SQFS"].de.ExtentLocation%32)
+	assert.Zero(t, files["/C.IMG"].de.ExtentLocation%512)
+	// the empty file isn't aligned, so the disk image is the last extent
+	assert.Equal(t, files["/C.IMG"].de.ExtentLocation+1, pvd.VolumeSpaceSize)
+
+	for name, content := range map[string]string{"/A.TXT": "a", "/B.SQFS": squashfs, "/C.IMG": disk, "/D.TXT": ""} {
+		data, err := io.ReadAll(files[name].Reader())
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data), name)
+	}
+
+	// the gaps are filled with zeroes
+	gap := buf.Bytes()[(files["/B.SQFS"].de.ExtentLocation+3)*int32(sectorSize) : files["/C.IMG"].de.ExtentLocation*int32(sectorSize)]
+	assert.NotEmpty(t, gap)
+	assert.True(t, isZero(gap))
+}
+
+func TestWriterDefaultAlignment(t *testing.T) {
+	iw, err := NewWriterWithOptions(WriterOptions{DefaultAlignment: 4, Deduplicate: true})
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+
+	for _, name := range []string{"A.TXT", "B.TXT", "C.TXT"} {
+		require.NoError(t, iw.AddFile(strings.NewReader(name), name))
+	}
+	require.NoError(t, iw.AddFile(strings.NewReader("shared"), "SHARED1.TXT"))
+	require.NoError(t, iw.AddFile(strings.NewReader("shared"), "SHARED2.TXT"))
+	require.NoError(t, iw.SetAlignment("B.TXT", 6))
+	// the deduplicated extent satisfies the alignment of the file which isn't the first to use it
+	require.NoError(t, iw.SetAlignment("SHARED2.TXT", 32))
+
+	files := filesByPath(t, remaster(t, iw))
+	assert.Zero(t, files["/A.TXT"].de.ExtentLocation%4)
+	assert.Zero(t, files["/B.TXT"].de.ExtentLocation%12)
+	assert.Zero(t, files["/C.TXT"].de.ExtentLocation%4)
+	assert.Equal(t, files["/SHARED1.TXT"].de.ExtentLocation, files["/SHARED2.TXT"].de.ExtentLocation)
+	assert.Zero(t, files["/SHARED1.TXT"].de.ExtentLocation%32)
+
+	_, err = NewWriterWithOptions(WriterOptions{DefaultAlignment: -2})
+	assert.EqualError(t, err, "invalid alignment of -2 sectors")
+}
+
+func TestCombineAlignments(t *testing.T) {
+	for _, testcase := range []struct {
+		a, b, result uint32
+	}{
+		{0, 0, 0},
+		{0, 32, 32},
+		{1, 32, 32},
+		{32, 1, 32},
+		{32, 512, 512},
+		{4, 6, 12},
+	} {
+		result, err := combineAlignments(testcase.a, testcase.b)
+		require.NoError(t, err)
+		assert.Equal(t, testcase.result, result, "%d and %d", testcase.a, testcase.b)
+	}
+
+	_, err := combineAlignments(1<<31+1, 1<<30)
+	assert.EqualError(t, err, "the alignments of 2147483649 and 1073741824 sectors cannot be combined")
+}
+
+func TestWriterFixedLBA(t *testing.T) {
+	iw, err := NewWriter()
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+
+	payload := strings.Repeat("P", 2*int(sectorSize))
+	large := strings.Repeat("L", 20*int(sectorSize))
+	require.NoError(t, iw.AddFile(strings.NewReader("a"), "A.TXT"))
+	require.NoError(t, iw.AddFile(strings.NewReader(payload), "PAYLOAD.BIN"))
+	require.NoError(t, iw.AddFile(strings.NewReader(large), "LARGE.BIN"))
+	require.NoError(t, iw.AddFile(strings.NewReader("z"), "Z.TXT"))
+	require.NoError(t, iw.SetFixedLBA("PAYLOAD.BIN", 40))
+
+	assert.True(t, errors.Is(iw.SetFixedLBA("MISSING", 40), os.ErrNotExist))
+	require.NoError(t, iw.AddDirectory("DIR"))
+	assert.EqualError(t, iw.SetFixedLBA("DIR", 40), `pinning "DIR": only the extents of regular files can be pinned, not of a directory`)
+
+	var buf bytes.Buffer
+	require.NoError(t, iw.WriteTo(&buf, "pinned"))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	pvd, err := img.primaryVolume()
+	require.NoError(t, err)
+	assert.Equal(t, int(pvd.VolumeSpaceSize)*int(sectorSize), buf.Len())
+
+	files := filesByPath(t, img)
+	assert.Equal(t, int32(40), files["/PAYLOAD.BIN"].de.ExtentLocation)
+	// the large file doesn't fit before the pinned extent and is placed after it, followed by the rest
+	assert.Less(t, files["/A.TXT"].de.ExtentLocation, int32(40))
+	assert.Equal(t, int32(42), files["/LARGE.BIN"].de.ExtentLocation)
+	assert.Equal(t, int32(62), files["/Z.TXT"].de.ExtentLocation)
+	assert.Equal(t, int32(63), pvd.VolumeSpaceSize)
+
+	for name, content := range map[string]string{"/A.TXT": "a", "/PAYLOAD.BIN": payload, "/LARGE.BIN": large, "/Z.TXT": "z"} {
+		data, err := io.ReadAll(files[name].Reader())
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data), name)
+	}
+
+	// the gap before the pinned extent is filled with zeroes
+	gap := buf.Bytes()[(files["/A.TXT"].de.ExtentLocation+1)*int32(sectorSize) : 40*sectorSize]
+	assert.NotEmpty(t, gap)
+	assert.True(t, isZero(gap))
+}
+
+func TestWriterFixedLBAConflicts(t *testing.T) {
+	newWriter := func(t *testing.T, opts WriterOptions) *ImageWriter {
+		iw, err := NewWriterWithOptions(opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { iw.Cleanup() }) // nolint: errcheck
+		require.NoError(t, iw.AddFile(strings.NewReader(strings.Repeat("A", 3*int(sectorSize))), "A.BIN"))
+		require.NoError(t, iw.AddFile(strings.NewReader(strings.Repeat("A", 3*int(sectorSize))), "B.BIN"))
+		return iw
+	}
+
+	iw := newWriter(t, WriterOptions{})
+	require.NoError(t, iw.SetFixedLBA("A.BIN", 18))
+	assert.ErrorContains(t, iw.WriteTo(io.Discard, "early"), "/A.BIN: pinned sector 18 lies before sector")
+
+	iw = newWriter(t, WriterOptions{})
+	require.NoError(t, iw.SetFixedLBA("A.BIN", 50))
+	require.NoError(t, iw.SetFixedLBA("B.BIN", 52))
+	assert.ErrorContains(t, iw.WriteTo(io.Discard, "overlap"), "/B.BIN: extent pinned to sector 52 overlaps the extent of /A.BIN, pinned to sector 50")
+
+	iw = newWriter(t, WriterOptions{})
+	require.NoError(t, iw.SetFixedLBA("A.BIN", math.MaxUint32-1))
+	assert.ErrorContains(t, iw.WriteTo(io.Discard, "beyond"), "ends beyond the last addressable sector")
+
+	// deduplicated files share the pinned extent
+	iw = newWriter(t, WriterOptions{Deduplicate: true})
+	require.NoError(t, iw.SetFixedLBA("A.BIN", 50))
+	require.NoError(t, iw.SetFixedLBA("B.BIN", 60))
+	assert.ErrorContains(t, iw.WriteTo(io.Discard, "shared"), "/B.BIN: shares its extent with /A.BIN, which is pinned to sector 50")
+
+	iw = newWriter(t, WriterOptions{Deduplicate: true})
+	require.NoError(t, iw.SetFixedLBA("B.BIN", 50))
+	files := filesByPath(t, remaster(t, iw))
+	assert.Equal(t, int32(50), files["/A.BIN"].de.ExtentLocation)
+	assert.Equal(t, int32(50), files["/B.BIN"].de.ExtentLocation)
+}
+
+func TestWriterSortWeight(t *testing.T) {
+	weights := map[string]int{"/BOOT/KERNEL": 10, "/BOOT/INITRD": 5, "/Z.TXT": 5, "/LAST.DAT": -1}
+	iw, err := NewWriterWithOptions(WriterOptions{Deduplicate: true, SortWeight: func(isoPath string) int {
+		return weights[isoPath]
+	}})
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+
+	for _, name := range []string{"A.TXT", "LAST.DAT", "M.TXT", "Z.TXT", "BOOT/INITRD", "BOOT/KERNEL"} {
+		require.NoError(t, iw.AddFile(strings.NewReader(strings.Repeat(name, 1000)), name))
+	}
+	// the copy gives its weight to the extent it shares
+	require.NoError(t, iw.AddFile(strings.NewReader(strings.Repeat("A.TXT", 1000)), "BOOT/COPY"))
+	weights["/BOOT/COPY"] = 7
+	require.NoError(t, iw.AddFile(strings.NewReader("pinned"), "PINNED"))
+	require.NoError(t, iw.SetFixedLBA("PINNED", 100))
+
+	img := remaster(t, iw)
+	files := filesByPath(t, img)
+	location := func(name string) uint32 {
+		return uint32(files[name].de.ExtentLocation)
+	}
+	// the heaviest first, then in the order of their directories
+	order := []string{"/BOOT/KERNEL", "/BOOT/COPY", "/Z.TXT", "/BOOT/INITRD", "/M.TXT", "/LAST.DAT"}
+	for i := 1; i < len(order); i++ {
+		assert.Less(t, location(order[i-1]), location(order[i]), "%s before %s", order[i-1], order[i])
+	}
+	assert.Equal(t, location("/BOOT/COPY"), location("/A.TXT"))
+	assert.Equal(t, uint32(100), location("/PINNED"))
+	assert.Equal(t, strings.Repeat("BOOT/KERNEL", 1000), readPath(t, img, "/BOOT/KERNEL"))
+}
+
+func TestWriterSortWeightWithoutPins(t *testing.T) {
+	weights := map[string]int{"/Z.TXT": 10, "/M/DEEP.TXT": 5}
+	iw, err := NewWriterWithOptions(WriterOptions{SortWeight: func(isoPath string) int {
+		return weights[isoPath]
+	}})
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+
+	contents := map[string]string{}
+	for _, name := range []string{"A.TXT", "B.TXT", "M/DEEP.TXT", "Z.TXT"} {
+		contents["/"+name] = strings.Repeat(name, 1000)
+		require.NoError(t, iw.AddFile(strings.NewReader(contents["/"+name]), name))
+	}
+
+	img := remaster(t, iw)
+	files := filesByPath(t, img)
+	assert.Less(t, files["/Z.TXT"].de.ExtentLocation, files["/M/DEEP.TXT"].de.ExtentLocation)
+	assert.Less(t, files["/M/DEEP.TXT"].de.ExtentLocation, files["/A.TXT"].de.ExtentLocation)
+	// the data is written at the locations of the reordered extents
+	for name, content := range contents {
+		assert.Equal(t, content, readPath(t, img, name), name)
+	}
+}
@@ -0,0 +1,116 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Signatures of the Apple ISO 9660 extensions
+const (
+	appleExtensionSignature    = "AA"
+	appleOldExtensionSignature = "AB"
+)
+
+// System Use IDs of the "AA" entry, which take the place of the SUSP version
+const (
+	AppleProDOS byte = 1
+	AppleHFS    byte = 2
+)
+
+// AppleExtension holds the Apple ISO 9660 extensions of a file, as recorded by Mac mastering tools
+type AppleExtension struct {
+	// SystemUseID tells the format of the decoded fields, AppleHFS or AppleProDOS
+	SystemUseID byte
+	// Type and Creator are the HFS type and creator codes, e.g. "APPL" and "ttxt"
+	Type    string
+	Creator string
+	// FinderFlags are the HFS Finder flags
+	FinderFlags uint16
+	// ProDOSType and ProDOSAuxType are the ProDOS file type and auxiliary type
+	ProDOSType    byte
+	ProDOSAuxType uint16
+	// Raw holds the Apple entries which were not decoded: "AB" entries and "AA" entries of unknown formats
+	Raw []SystemUseEntry
+}
+
+// AppleExtension returns the Apple extensions of the file, or nil if it has none.
+// Apple discs don't necessarily use SUSP, so without it the System Use field is read as Apple entries.
+func (f *File) AppleExtension() (*AppleExtension, error) {
+	entries := f.de.SystemUseEntries
+	if f.susp == nil {
+		entries = appleSystemUseEntries(f.de.SystemUse)
+	}
+	return decodeAppleExtension(entries)
+}
+
+// appleSystemUseEntries splits a System Use field which doesn't use SUSP into entries with the same layout,
+// up to the first one which isn't well-formed
+func appleSystemUseEntries(systemUse []byte) []SystemUseEntry {
+	var entries []SystemUseEntry
+	for len(systemUse) >= 4 && int(systemUse[2]) >= 4 && int(systemUse[2]) <= len(systemUse) {
+		entries = append(entries, SystemUseEntry(systemUse[:systemUse[2]]))
+		systemUse = systemUse[systemUse[2]:]
+	}
+	return entries
+}
+
+// decodeAppleExtension decodes the Apple entries among the given ones
+func decodeAppleExtension(entries []SystemUseEntry) (*AppleExtension, error) {
+	var ext *AppleExtension
+	for _, e := range entries {
+		if e.Type() != appleExtensionSignature && e.Type() != appleOldExtensionSignature {
+			continue
+		}
+		if ext == nil {
+			ext = &AppleExtension{}
+		}
+
+		data := e.Data()
+		switch {
+		case e.Type() == appleOldExtensionSignature:
+			ext.Raw = append(ext.Raw, e)
+		case e.Version() == AppleHFS:
+			if len(data) < 10 {
+				return nil, fmt.Errorf("invalid Apple HFS extension with length %d instead of 14", e.Length())
+			}
+			ext.SystemUseID = AppleHFS
+			ext.Type = string(data[0:4])
+			ext.Creator = string(data[4:8])
+			ext.FinderFlags = binary.BigEndian.Uint16(data[8:10])
+		case e.Version() == AppleProDOS:
+			if len(data) < 3 {
+				return nil, fmt.Errorf("invalid Apple ProDOS extension with length %d instead of 7", e.Length())
+			}
+			ext.SystemUseID = AppleProDOS
+			ext.ProDOSType = data[0]
+			ext.ProDOSAuxType = binary.LittleEndian.Uint16(data[1:3])
+		default:
+			ext.Raw = append(ext.Raw, e)
+		}
+	}
+	return ext, nil
+}
+
+// IsAssociated reports whether the file is an associated file, such as the resource fork of a Mac file,
+// which has the same identifier as the file it belongs to
+func (f *File) IsAssociated() bool {
+	return f.de.FileFlags&dirFlagAssociated != 0
+}
+
+// AssociatedFile returns the associated file of a file, usually its resource fork, or nil if it has none.
+// For an associated file, it returns the file it belongs to.
+func (f *File) AssociatedFile() (*File, error) {
+	if f.parent == nil {
+		return nil, nil
+	}
+	siblings, err := f.parent.GetAllChildren()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range siblings {
+		if s != f && s.de.Identifier == f.de.Identifier && s.IsAssociated() != f.IsAssociated() {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
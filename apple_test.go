@@ -0,0 +1,95 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppleExtension(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("data fork"), "SIMPLETEXT"))
+	require.NoError(t, w.AddFile(strings.NewReader("resource fork"), "SIMPLETEXU"))
+	require.NoError(t, w.AddFile(strings.NewReader("plain"), "PLAIN.TXT"))
+	require.NoError(t, w.AddSystemUseEntry("SIMPLETEXT", "AA", AppleHFS, []byte("APPLttxt\x01\x00")))
+	require.NoError(t, w.AddSystemUseEntry("SIMPLETEXT", "AB", 1, []byte("unknown")))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "apple"))
+	image := buf.Bytes()
+
+	// turn the second file into the resource fork of the first
+	at := bytes.Index(image, []byte("\x0dSIMPLETEXU.;1"))
+	require.Greater(t, at, 32)
+	image[at+10] = 'T'
+	image[at-32+25] |= dirFlagAssociated
+
+	img, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+
+	var data, resource, plain *File
+	for _, c := range children {
+		switch {
+		case c.IsAssociated():
+			resource = c
+		case c.Name() == "SIMPLETEXT":
+			data = c
+		default:
+			plain = c
+		}
+	}
+	require.NotNil(t, data)
+	require.NotNil(t, resource)
+
+	ext, err := data.AppleExtension()
+	require.NoError(t, err)
+	require.NotNil(t, ext)
+	assert.Equal(t, AppleHFS, ext.SystemUseID)
+	assert.Equal(t, "APPL", ext.Type)
+	assert.Equal(t, "ttxt", ext.Creator)
+	assert.Equal(t, uint16(0x0100), ext.FinderFlags)
+	require.Len(t, ext.Raw, 1)
+	assert.Equal(t, "AB", ext.Raw[0].Type())
+	assert.Equal(t, "unknown", string(ext.Raw[0].Data()))
+
+	associated, err := data.AssociatedFile()
+	require.NoError(t, err)
+	assert.Same(t, resource, associated)
+	associated, err = resource.AssociatedFile()
+	require.NoError(t, err)
+	assert.Same(t, data, associated)
+
+	ext, err = plain.AppleExtension()
+	assert.NoError(t, err)
+	assert.Nil(t, ext)
+	associated, err = plain.AssociatedFile()
+	assert.NoError(t, err)
+	assert.Nil(t, associated)
+}
+
+func TestAppleExtensionWithoutSUSP(t *testing.T) {
+	systemUse := []byte("AA\x07\x01\x04\x00\x20" + "AA\x07\x09raw" + "\x00")
+	ext, err := decodeAppleExtension(appleSystemUseEntries(systemUse))
+	require.NoError(t, err)
+	assert.Equal(t, &AppleExtension{
+		SystemUseID:   AppleProDOS,
+		ProDOSType:    0x04,
+		ProDOSAuxType: 0x2000,
+		Raw:           []SystemUseEntry{SystemUseEntry("AA\x07\x09raw")},
+	}, ext)
+
+	_, err = decodeAppleExtension(appleSystemUseEntries([]byte("AA\x06\x02ab")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,61 @@
+package iso9660
+
+import "fmt"
+
+// minLogicalBlockSize is the smallest logical block size of ECMA-119 6.2.2, 2^9 bytes
+const minLogicalBlockSize = 512
+
+// validLogicalBlockSize reports whether a logical block size is a power of two of at least 512 bytes.
+// ECMA-119 6.2.2 doesn't allow blocks larger than the 2048 bytes of a sector, but images with 4096-byte blocks are read as well.
+func validLogicalBlockSize(size int16) bool {
+	return size >= minLogicalBlockSize && size&(size-1) == 0
+}
+
+// lengthToBlocks returns the number of logical blocks of the given size needed to hold length bytes
+func lengthToBlocks(length uint32, blockSize int64) int64 {
+	return (int64(length) + blockSize - 1) / blockSize
+}
+
+// LogicalBlockSize returns the size in bytes of the logical blocks of the volume read, which the locations
+// of the extents in its directory records and path tables count. It is the 2048 bytes of a sector in almost all images,
+// but ECMA-119 allows 512 and 1024 as well. The volume descriptors are always found at sector 16 and the following ones.
+func (i *Image) LogicalBlockSize() int64 {
+	if pvd, err := i.primaryVolume(); err == nil && validLogicalBlockSize(pvd.LogicalBlockSize) {
+		return int64(pvd.LogicalBlockSize)
+	}
+	return int64(sectorSize)
+}
+
+// logicalBlockSize returns the size in bytes of the logical blocks the entry's extents are counted in
+func (f *File) logicalBlockSize() int64 {
+	if f.blockSize == 0 {
+		return int64(sectorSize)
+	}
+	return f.blockSize
+}
+
+// SetLogicalBlockSize selects the size in bytes of the logical blocks the image records the locations of its extents in:
+// 512, 1024 or the default 2048, see ECMA-119 6.2.2. The extents are still laid out in whole 2048-byte sectors,
+// so the smaller blocks only serve specialized targets which expect them. Images written with them cannot be
+// appended to as a new session, nor edited in place.
+func (iw *ImageWriter) SetLogicalBlockSize(size int) error {
+	switch size {
+	case 512, 1024, int(sectorSize):
+		iw.blockSize = uint32(size)
+		return nil
+	}
+	return fmt.Errorf("invalid logical block size %d, expected 512, 1024 or %d", size, sectorSize)
+}
+
+// blocks converts a number of sectors, or the sector an extent starts at, to logical blocks
+func (wc *writeContext) blocks(sectors uint32) uint32 {
+	return sectors * (sectorSize / wc.logicalBlockSize())
+}
+
+// logicalBlockSize returns the size in bytes of the logical blocks the image is written with
+func (wc *writeContext) logicalBlockSize() uint32 {
+	if wc.blockSize == 0 {
+		return sectorSize
+	}
+	return wc.blockSize
+}
@@ -0,0 +1,144 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSmallLogicalBlocks(t *testing.T) {
+	longName := strings.Repeat("long-name-", 30)
+	for _, size := range []int{512, 1024} {
+		w, err := NewWriterWithOptions(WriterOptions{
+			EnableRockRidge:  true,
+			Joliet:           true,
+			LogicalBlockSize: size,
+		})
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+		require.NoError(t, w.AddFile(strings.NewReader("hello"), "a/b/c/d/e/f/g/h/i/hello.txt"))
+		require.NoError(t, w.AddFile(strings.NewReader(strings.Repeat("x", 5000)), "dir/"+longName))
+		require.NoError(t, w.AddFile(strings.NewReader("small"), "small.txt"))
+
+		img := remaster(t, w)
+		assert.Equal(t, int64(size), img.LogicalBlockSize())
+		assert.NoError(t, img.VerifyPathTables())
+
+		files := filesByPath(t, img)
+		for p, content := range map[string]string{
+			"/a/b/c/d/e/f/g/h/i/hello.txt": "hello",
+			"/dir/" + longName:             strings.Repeat("x", 5000),
+			"/small.txt":                   "small",
+		} {
+			require.Contains(t, files, p)
+			data, err := io.ReadAll(files[p].Reader())
+			require.NoError(t, err)
+			assert.Equal(t, content, string(data), "%d-byte blocks: %s", size, p)
+		}
+
+		// the image cannot be the base of an edit or a following session
+		var buf bytes.Buffer
+		require.NoError(t, w.WriteTo(&buf, ""))
+		_, err = OpenImageEditor(&memoryImage{data: buf.Bytes()})
+		assert.EqualError(t, err, fmt.Sprintf("images with %d-byte logical blocks cannot be edited in place", size))
+		_, err = NewSessionWriter(img, 0)
+		assert.Error(t, err)
+	}
+}
+
+func TestSetLogicalBlockSize(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	assert.EqualError(t, w.SetLogicalBlockSize(4096), "invalid logical block size 4096, expected 512, 1024 or 2048")
+	assert.EqualError(t, w.SetLogicalBlockSize(256), "invalid logical block size 256, expected 512, 1024 or 2048")
+	assert.NoError(t, w.SetLogicalBlockSize(2048))
+
+	_, err = NewWriterWithOptions(WriterOptions{LogicalBlockSize: 700})
+	assert.Error(t, err)
+}
+
+func TestReadLargeLogicalBlocks(t *testing.T) {
+	// the descriptors fill block 8, the root directory block 9 and the file block 10
+	const blockSize = 4096
+	image := make([]byte, 11*blockSize)
+
+	rootEntry := func(identifier string) []byte {
+		de := DirectoryEntry{
+			ExtentLocation:       9,
+			ExtentLength:         blockSize,
+			FileFlags:            dirFlagDir,
+			VolumeSequenceNumber: 1,
+			Identifier:           identifier,
+		}
+		data, err := de.MarshalBinary()
+		require.NoError(t, err)
+		return data
+	}
+	file := DirectoryEntry{
+		ExtentLocation:       10,
+		ExtentLength:         11,
+		VolumeSequenceNumber: 1,
+		Identifier:           "HELLO.TXT;1",
+	}
+	fileData, err := file.MarshalBinary()
+	require.NoError(t, err)
+	records := append(append(rootEntry(string([]byte{0})), rootEntry(string([]byte{1}))...), fileData...)
+	copy(image[9*blockSize:], records)
+	copy(image[10*blockSize:], "hello world")
+
+	pvd := volumeDescriptor{
+		Header: volumeDescriptorHeader{
+			Type:       volumeTypePrimary,
+			Identifier: standardIdentifierBytes,
+			Version:    1,
+		},
+		Primary: &PrimaryVolumeDescriptorBody{
+			VolumeIdentifier:     "LARGE",
+			VolumeSpaceSize:      11,
+			VolumeSetSize:        1,
+			VolumeSequenceNumber: 1,
+			LogicalBlockSize:     blockSize,
+			RootDirectoryEntry: &DirectoryEntry{
+				ExtentLocation:       9,
+				ExtentLength:         blockSize,
+				FileFlags:            dirFlagDir,
+				VolumeSequenceNumber: 1,
+				Identifier:           string([]byte{0}),
+			},
+			FileStructureVersion: 1,
+		},
+	}
+	data, err := pvd.MarshalBinary()
+	require.NoError(t, err)
+	copy(image[16*sectorSize:], data)
+	terminator := volumeDescriptor{
+		Header: volumeDescriptorHeader{
+			Type:       volumeTypeTerminator,
+			Identifier: standardIdentifierBytes,
+			Version:    1,
+		},
+	}
+	data, err = terminator.MarshalBinary()
+	require.NoError(t, err)
+	copy(image[17*sectorSize:], data)
+
+	img, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+	assert.Equal(t, int64(blockSize), img.LogicalBlockSize())
+
+	files := filesByPath(t, img)
+	require.Contains(t, files, "/HELLO.TXT")
+	content, err := io.ReadAll(files["/HELLO.TXT"].Reader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+}
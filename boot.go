@@ -0,0 +1,521 @@
+package iso9660
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// BootEmulation is the media type an El Torito boot image emulates
+type BootEmulation byte
+
+const (
+	BootNoEmulation BootEmulation = iota
+	BootFloppy12M
+	BootFloppy144M
+	BootFloppy288M
+	BootHardDisk
+)
+
+// Platform IDs of the El Torito validation and section header entries
+const (
+	BootPlatformX86     byte = 0
+	BootPlatformPowerPC byte = 1
+	BootPlatformMac     byte = 2
+	BootPlatformEFI     byte = 0xEF
+)
+
+// emulated sizes of the floppy images, in bytes
+var bootFloppySizes = map[BootEmulation]int64{
+	BootFloppy12M:  1200 * 1024,
+	BootFloppy144M: 1440 * 1024,
+	BootFloppy288M: 2880 * 1024,
+}
+
+// BootEntry is an entry of the El Torito boot catalog, see Image.BootEntries
+type BootEntry struct {
+	// PlatformID is the platform of the validation entry for the initial entry,
+	// and that of the section header for the others
+	PlatformID byte
+	Bootable   bool
+	Emulation  BootEmulation
+	// LoadSegment is the segment the image is loaded at by x86 BIOSes, 0 means the traditional 0x7C0
+	LoadSegment uint16
+	// SystemType is the partition type of a hard disk image
+	SystemType byte
+	// SectorCount is the number of 512-byte sectors loaded by the BIOS
+	SectorCount uint16
+	// LBA is the sector of the image
+	LBA uint32
+}
+
+// BootEntries parses the El Torito boot catalog. It returns nil if the image has no El Torito Boot Record.
+// The initial entry comes first, followed by the entries of the sections.
+func (i *Image) BootEntries() ([]BootEntry, error) {
+	location, ok := i.BootCatalogLocation()
+	if !ok {
+		return nil, nil
+	}
+
+	catalog := make([]byte, sectorSize)
+	if _, err := i.ra.ReadAt(catalog, int64(location)*int64(sectorSize)); err != nil {
+		return nil, fmt.Errorf("reading the boot catalog at sector %d: %w", location, err)
+	}
+
+	validation := catalog[:elToritoEntrySize]
+	if validation[0] != 1 || validation[30] != 0x55 || validation[31] != 0xAA {
+		return nil, fmt.Errorf("the boot catalog at sector %d doesn't start with a validation entry", location)
+	}
+
+	entries := []BootEntry{parseBootEntry(catalog[elToritoEntrySize:2*elToritoEntrySize], validation[1])}
+	for offset := 2 * elToritoEntrySize; offset+elToritoEntrySize <= len(catalog); {
+		header := catalog[offset : offset+elToritoEntrySize]
+		// 0x90 is a section header, 0x91 the final one
+		if header[0] != 0x90 && header[0] != 0x91 {
+			break
+		}
+		count := int(binary.LittleEndian.Uint16(header[2:4]))
+		offset += elToritoEntrySize
+		for n := 0; n < count && offset+elToritoEntrySize <= len(catalog); n++ {
+			entry := catalog[offset : offset+elToritoEntrySize]
+			// section entry extensions follow their entries
+			for offset += elToritoEntrySize; offset < len(catalog) && catalog[offset] == 0x44; offset += elToritoEntrySize {
+			}
+			entries = append(entries, parseBootEntry(entry, header[1]))
+		}
+		if header[0] == 0x91 {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// parseBootEntry decodes an initial, default or section entry
+func parseBootEntry(entry []byte, platformID byte) BootEntry {
+	return BootEntry{
+		PlatformID:  platformID,
+		Bootable:    entry[0] == 0x88,
+		Emulation:   BootEmulation(entry[1] & 0x0F),
+		LoadSegment: binary.LittleEndian.Uint16(entry[2:4]),
+		SystemType:  entry[4],
+		SectorCount: binary.LittleEndian.Uint16(entry[6:8]),
+		LBA:         binary.LittleEndian.Uint32(entry[8:12]),
+	}
+}
+
+// BootImageReader returns a reader of the boot image of the entry, sized like CloneMasteringConfig sizes images
+// which aren't listed in the directory tree
+func (i *Image) BootImageReader(e BootEntry) (*io.SectionReader, error) {
+	size, err := i.bootImageSize(e)
+	if err != nil {
+		return nil, fmt.Errorf("reading the boot image at sector %d: %w", e.LBA, err)
+	}
+	return io.NewSectionReader(i.ra, int64(e.LBA)*int64(sectorSize), size), nil
+}
+
+// BootFile is a boot image of an El Torito image with a reader of its data, see Image.BootImages
+type BootFile struct {
+	Entry BootEntry
+	// Path is the path of the file in the directory tree holding the image, empty if the image isn't listed
+	Path string
+	// Size is that of the file holding a no emulation image, and that returned by BootImageReader otherwise
+	Size   int64
+	Reader *io.SectionReader
+}
+
+// BootImages returns the boot images of the entries of the boot catalog for the given platforms,
+// or for all of them if none is given, in the order of the catalog. It returns nil if the image
+// has no El Torito Boot Record.
+func (i *Image) BootImages(platforms ...byte) ([]BootFile, error) {
+	entries, err := i.BootEntries()
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	files, err := i.filesByLocation()
+	if err != nil {
+		return nil, err
+	}
+	var images []BootFile
+	for _, e := range entries {
+		if len(platforms) > 0 && bytes.IndexByte(platforms, e.PlatformID) < 0 {
+			continue
+		}
+		size, err := i.bootImageSize(e)
+		if err != nil {
+			return nil, fmt.Errorf("reading the boot image at sector %d: %w", e.LBA, err)
+		}
+		boot := BootFile{Entry: e}
+		if f, ok := files[e.LBA]; ok {
+			boot.Path = f.path
+			if e.Emulation == BootNoEmulation {
+				size = f.size
+			}
+		}
+		boot.Size = size
+		boot.Reader = io.NewSectionReader(i.ra, int64(e.LBA)*int64(sectorSize), size)
+		images = append(images, boot)
+	}
+	return images, nil
+}
+
+// bootImageSize returns the size of the boot image of the entry: that of a floppy for floppy emulation,
+// that of the disk described by the partition table in the image for hard disk emulation,
+// and that of the loaded sectors otherwise.
+func (i *Image) bootImageSize(e BootEntry) (int64, error) {
+	if size, ok := bootFloppySizes[e.Emulation]; ok {
+		return size, nil
+	}
+	if e.Emulation == BootHardDisk {
+		mbr := make([]byte, 512)
+		if _, err := i.ra.ReadAt(mbr, int64(e.LBA)*int64(sectorSize)); err != nil {
+			return 0, err
+		}
+		// the image holds a single partition, the first entry of the table at byte 446
+		partition := mbr[446:462]
+		return int64(binary.LittleEndian.Uint32(partition[8:12])+binary.LittleEndian.Uint32(partition[12:16])) * 512, nil
+	}
+	return int64(e.SectorCount) * 512, nil
+}
+
+// maxBootEntries is the number of entries which fit into the boot catalog sector written by the ImageWriter,
+// counting the validation entry and a section header per entry
+const maxBootEntries = int(sectorSize/elToritoEntrySize) / 2
+
+// bootInfoTableOffset and bootInfoTableSize locate the boot info table in a boot image, see WithBootInfoTable
+const (
+	bootInfoTableOffset = 8
+	bootInfoTableSize   = 56
+)
+
+// BootOption configures an entry added with AddBootEntry
+type BootOption func(*stagedBootEntry)
+
+// WithBootLoadSize sets the number of 512-byte sectors the firmware loads from a no emulation image.
+// By default the whole image is loaded, up to 65535 sectors. BIOS boot loaders like ISOLINUX usually expect 4.
+func WithBootLoadSize(sectors uint16) BootOption {
+	return func(e *stagedBootEntry) {
+		e.SectorCount = sectors
+	}
+}
+
+// WithBootLoadSegment sets the segment x86 BIOSes load a no emulation image at, 0 selects the traditional 0x7C0
+func WithBootLoadSegment(segment uint16) BootOption {
+	return func(e *stagedBootEntry) {
+		e.LoadSegment = segment
+	}
+}
+
+// WithBootInfoTable patches a boot info table into bytes 8 to 63 of the boot image as it is written,
+// like mkisofs -boot-info-table: the sector of the Primary Volume Descriptor, the sector of the boot image,
+// its length in bytes and the sum of its 32-bit little-endian words from byte 64 on.
+// ISOLINUX and the El Torito images of GRUB read it to find the rest of themselves, so they don't boot without it.
+// Only the copy in the image is patched, and files sharing its data, see SetDeduplicate, get the patched copy too.
+// The boot image cannot be streamed, since it is read before it is written.
+func WithBootInfoTable() BootOption {
+	return func(e *stagedBootEntry) {
+		e.infoTable = true
+	}
+}
+
+// stagedBootEntry is an entry of the boot catalog added with AddBootEntry
+type stagedBootEntry struct {
+	BootEntry
+	isoPath string
+	// loadSize is set if the sector count was chosen with WithBootLoadSize
+	loadSize bool
+	// infoTable is set if a boot info table is patched into the image, see WithBootInfoTable
+	infoTable bool
+	entry     *stagedEntry
+}
+
+// AddBootEntry adds an entry to the El Torito boot catalog, which boots from the staged file at bootImagePath.
+// The first entry is the initial entry used by BIOSes, the others are recorded in sections by platform,
+// such as BootPlatformEFI for an EFI system partition image. Floppy images must have the exact size
+// of the emulated floppy, and hard disk images must start with an MBR holding a single partition.
+//
+// The boot catalog takes a sector of its own, which isn't listed in the directory tree.
+func (iw *ImageWriter) AddBootEntry(platformID byte, emulation BootEmulation, bootImagePath string, opts ...BootOption) error {
+	if emulation > BootHardDisk {
+		return fmt.Errorf("invalid boot media type %d", emulation)
+	}
+
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	entry := iw.lookup(bootImagePath)
+	if entry == nil {
+		return fmt.Errorf("adding the boot image %q: %w", bootImagePath, os.ErrNotExist)
+	}
+	if !entry.mode.IsRegular() {
+		return fmt.Errorf("adding the boot image %q: it is a %s, not a file", bootImagePath, entry.kind())
+	}
+	if len(iw.bootEntries) >= maxBootEntries {
+		return fmt.Errorf("adding the boot image %q: the boot catalog holds at most %d entries", bootImagePath, maxBootEntries)
+	}
+
+	boot := stagedBootEntry{
+		BootEntry: BootEntry{PlatformID: platformID, Bootable: true, Emulation: emulation},
+		isoPath:   bootImagePath,
+		entry:     entry,
+	}
+	for _, opt := range opts {
+		opt(&boot)
+	}
+	boot.loadSize = boot.SectorCount != 0
+	iw.bootEntries = append(iw.bootEntries, boot)
+	return nil
+}
+
+// ClearBootEntries removes all the entries of the El Torito boot catalog, including those carried over
+// by NewWriterFromImage. The boot images stay staged as files.
+func (iw *ImageWriter) ClearBootEntries() error {
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	iw.bootEntries = nil
+	return nil
+}
+
+// resolveBootEntries completes the entries of the boot catalog once the files have their locations
+func (wc *writeContext) resolveBootEntries() error {
+	nodes := make(map[*stagedEntry]*layoutNode, len(wc.files))
+	for _, file := range wc.files {
+		nodes[file.entry] = file
+	}
+
+	for n := range wc.boot {
+		boot := &wc.boot[n]
+		node := nodes[boot.entry]
+		if node == nil {
+			return fmt.Errorf("the boot image %q is no longer staged", boot.isoPath)
+		}
+		if node.zisofs != nil {
+			return fmt.Errorf("the boot image %q cannot be compressed with zisofs", boot.isoPath)
+		}
+		size := node.length
+		if node.source != nil {
+			size = node.source.Size()
+		}
+		// an Extended Attribute Record precedes the data
+		size -= int64(node.extendedAttributeSectors) * int64(sectorSize)
+
+		boot.LBA = node.location + node.extendedAttributeSectors
+		if boot.infoTable {
+			if err := patchBootInfoTable(node, boot.LBA); err != nil {
+				return fmt.Errorf("the boot info table of %q: %w", boot.isoPath, err)
+			}
+		}
+		switch boot.Emulation {
+		case BootNoEmulation:
+			if !boot.loadSize {
+				sectors := (size + 511) / 512
+				if sectors > 0xFFFF {
+					sectors = 0xFFFF
+				}
+				boot.SectorCount = uint16(sectors)
+			}
+		case BootHardDisk:
+			systemType, err := bootSystemType(node)
+			if err != nil {
+				return fmt.Errorf("the boot image %q: %w", boot.isoPath, err)
+			}
+			boot.SystemType = systemType
+			boot.SectorCount = 1
+		default:
+			if size != bootFloppySizes[boot.Emulation] {
+				return fmt.Errorf("the boot image %q has %d bytes instead of the %d of the emulated floppy",
+					boot.isoPath, size, bootFloppySizes[boot.Emulation])
+			}
+			boot.SectorCount = 1
+		}
+	}
+	return nil
+}
+
+// patchBootInfoTable replaces the data written for a boot image with a copy carrying a boot info table
+func patchBootInfoTable(node *layoutNode, lba uint32) error {
+	switch {
+	case node.inPlace:
+		return errors.New("the boot image is left in place in the edited image")
+	case node.source == nil:
+		return errors.New("the boot image shares the data of another file")
+	}
+	source := node.source
+	record, ok := source.(*extendedAttributeSource)
+	if ok {
+		source = record.stagedSource
+	}
+	if _, ok := source.(*streamSource); ok {
+		return errors.New("the boot image is streamed, so it cannot be read before it is written")
+	}
+	size := source.Size()
+	if size < bootInfoTableOffset+bootInfoTableSize || size > math.MaxUint32 {
+		return fmt.Errorf("the boot image has %d bytes, it must be at least %d bytes long and less than 4 GiB", size, bootInfoTableOffset+bootInfoTableSize)
+	}
+
+	r, err := source.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := io.CopyN(io.Discard, r, bootInfoTableOffset+bootInfoTableSize); err != nil {
+		return fmt.Errorf("reading the boot image: %w", err)
+	}
+	// the last word is padded with zeros
+	var checksum uint32
+	br := bufio.NewReader(r)
+	word := make([]byte, 4)
+	for {
+		n, err := io.ReadFull(br, word)
+		if n > 0 {
+			copy(word[n:], make([]byte, 4))
+			checksum += binary.LittleEndian.Uint32(word)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading the boot image: %w", err)
+		}
+	}
+
+	patched := &bootInfoTableSource{stagedSource: source}
+	binary.LittleEndian.PutUint32(patched.table[0:4], systemAreaSize/sectorSize)
+	binary.LittleEndian.PutUint32(patched.table[4:8], lba)
+	binary.LittleEndian.PutUint32(patched.table[8:12], uint32(size))
+	binary.LittleEndian.PutUint32(patched.table[12:16], checksum)
+	if record != nil {
+		node.source = &extendedAttributeSource{stagedSource: patched, record: record.record}
+	} else {
+		node.source = patched
+	}
+	return nil
+}
+
+// bootInfoTableSource is the data of a boot image with a boot info table, see WithBootInfoTable
+type bootInfoTableSource struct {
+	stagedSource
+	// table holds the 56 bytes replacing bytes 8 to 63 of the boot image
+	table [bootInfoTableSize]byte
+}
+
+func (s *bootInfoTableSource) Open() (io.ReadCloser, error) {
+	r, err := s.stagedSource.Open()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, bootInfoTableOffset)
+	if _, err = io.ReadFull(r, head); err == nil {
+		_, err = io.CopyN(io.Discard, r, bootInfoTableSize)
+	}
+	if err != nil {
+		r.Close() // nolint: errcheck
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), bytes.NewReader(s.table[:]), r), r}, nil
+}
+
+// bootSystemType returns the type of the partition of a hard disk image, read from its MBR
+func bootSystemType(node *layoutNode) (byte, error) {
+	if node.entry.source == nil {
+		return 0, errors.New("it has no contents")
+	}
+	if _, ok := node.entry.source.(*streamSource); ok {
+		return 0, errors.New("it is streamed, so its MBR cannot be read before it is written")
+	}
+	r, err := node.entry.source.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	mbr := make([]byte, 512)
+	if _, err := io.ReadFull(r, mbr); err != nil {
+		return 0, fmt.Errorf("reading the MBR: %w", err)
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xAA {
+		return 0, errors.New("it doesn't start with an MBR")
+	}
+	return mbr[446+4], nil
+}
+
+// marshalBootCatalog encodes the boot catalog: the validation entry, the initial entry
+// and a section for each run of following entries with the same platform
+func (wc *writeContext) marshalBootCatalog() []byte {
+	catalog := make([]byte, sectorSize)
+	marshalEntry := func(offset int, e BootEntry) {
+		entry := catalog[offset : offset+elToritoEntrySize]
+		if e.Bootable {
+			entry[0] = 0x88
+		}
+		entry[1] = byte(e.Emulation)
+		binary.LittleEndian.PutUint16(entry[2:4], e.LoadSegment)
+		entry[4] = e.SystemType
+		binary.LittleEndian.PutUint16(entry[6:8], e.SectorCount)
+		binary.LittleEndian.PutUint32(entry[8:12], e.LBA)
+	}
+
+	validation := catalog[:elToritoEntrySize]
+	validation[0] = 1
+	validation[1] = wc.boot[0].PlatformID
+	validation[30], validation[31] = 0x55, 0xAA
+	// the words of the validation entry sum up to zero
+	var sum uint16
+	for n := 0; n < elToritoEntrySize; n += 2 {
+		sum += binary.LittleEndian.Uint16(validation[n:])
+	}
+	binary.LittleEndian.PutUint16(validation[28:30], -sum)
+	marshalEntry(elToritoEntrySize, wc.boot[0].BootEntry)
+
+	offset := 2 * elToritoEntrySize
+	for n := 1; n < len(wc.boot); {
+		count := 1
+		for n+count < len(wc.boot) && wc.boot[n+count].PlatformID == wc.boot[n].PlatformID {
+			count++
+		}
+
+		header := catalog[offset : offset+elToritoEntrySize]
+		// 0x90 is a section header, 0x91 the final one
+		header[0] = 0x90
+		if n+count == len(wc.boot) {
+			header[0] = 0x91
+		}
+		header[1] = wc.boot[n].PlatformID
+		binary.LittleEndian.PutUint16(header[2:4], uint16(count))
+		offset += elToritoEntrySize
+
+		for ; count > 0; count-- {
+			marshalEntry(offset, wc.boot[n].BootEntry)
+			offset += elToritoEntrySize
+			n++
+		}
+	}
+	return catalog
+}
+
+// bootRecord returns the El Torito Boot Record pointing to the boot catalog
+func (wc *writeContext) bootRecord() volumeDescriptor {
+	body := &BootVolumeDescriptorBody{BootSystemIdentifier: elToritoSystemIdentifier}
+	binary.LittleEndian.PutUint32(body.BootSystemUse[0:4], wc.bootCatalogLocation)
+	return volumeDescriptor{
+		Header: volumeDescriptorHeader{
+			Type:       volumeTypeBoot,
+			Identifier: standardIdentifierBytes,
+			Version:    1,
+		},
+		Boot: body,
+	}
+}
@@ -0,0 +1,189 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterAddBootEntry(t *testing.T) {
+	loader := bytes.Repeat([]byte("isolinux"), 1000)
+	efi := bytes.Repeat([]byte("esp"), 100000)
+	floppy := make([]byte, bootFloppySizes[BootFloppy144M])
+	disk := make([]byte, 4096)
+	disk[446+4] = 0x0C
+	binary.LittleEndian.PutUint32(disk[446+12:], 7)
+	disk[510], disk[511] = 0x55, 0xAA
+
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Joliet: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	for p, data := range map[string][]byte{
+		"isolinux/isolinux.bin": loader,
+		"EFI/efiboot.img":       efi,
+		"images/floppy.img":     floppy,
+		"images/disk.img":       disk,
+		"README":                []byte("readme"),
+	} {
+		require.NoError(t, w.AddFile(bytes.NewReader(data), p))
+	}
+	require.NoError(t, w.AddBootEntry(BootPlatformX86, BootNoEmulation, "isolinux/isolinux.bin", WithBootLoadSize(4)))
+	require.NoError(t, w.AddBootEntry(BootPlatformEFI, BootNoEmulation, "EFI/efiboot.img"))
+	require.NoError(t, w.AddBootEntry(BootPlatformX86, BootFloppy144M, "images/floppy.img"))
+	require.NoError(t, w.AddBootEntry(BootPlatformX86, BootHardDisk, "images/disk.img", WithBootLoadSegment(0x1000)))
+
+	assert.True(t, errors.Is(w.AddBootEntry(BootPlatformX86, BootNoEmulation, "missing.bin"), os.ErrNotExist))
+	assert.ErrorContains(t, w.AddBootEntry(BootPlatformX86, BootNoEmulation, "images"), "not a file")
+	assert.Error(t, w.AddBootEntry(BootPlatformX86, BootHardDisk+1, "README"))
+
+	img := remaster(t, w)
+	vds := img.VolumeDescriptors()
+	require.Len(t, vds, 4)
+	assert.Equal(t, volumeTypeBoot, vds[1].Type)
+	assert.Equal(t, elToritoSystemIdentifier, vds[1].Boot.BootSystemIdentifier)
+	assert.Equal(t, 3, vds[2].JolietLevel)
+
+	byPath := filesByPath(t, img)
+	lba := func(p string) uint32 {
+		return uint32(byPath[p].de.ExtentLocation)
+	}
+	entries, err := img.BootEntries()
+	require.NoError(t, err)
+	assert.Equal(t, []BootEntry{
+		{PlatformID: BootPlatformX86, Bootable: true, SectorCount: 4, LBA: lba("/isolinux/isolinux.bin")},
+		{PlatformID: BootPlatformEFI, Bootable: true, SectorCount: uint16((len(efi) + 511) / 512), LBA: lba("/EFI/efiboot.img")},
+		{PlatformID: BootPlatformX86, Bootable: true, Emulation: BootFloppy144M, SectorCount: 1, LBA: lba("/images/floppy.img")},
+		{PlatformID: BootPlatformX86, Bootable: true, Emulation: BootHardDisk, LoadSegment: 0x1000, SystemType: 0x0C,
+			SectorCount: 1, LBA: lba("/images/disk.img")},
+	}, entries)
+
+	for i, expected := range [][]byte{loader[:4*512], efi, floppy, disk[:7*512]} {
+		r, err := img.BootImageReader(entries[i])
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		// the loaded sectors of the EFI image extend into the padding of its extent
+		padded := append(append([]byte(nil), expected...), make([]byte, len(data)-len(expected))...)
+		assert.Equal(t, padded, data, i)
+	}
+
+	images, err := img.BootImages(BootPlatformEFI)
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+	assert.Equal(t, entries[1], images[0].Entry)
+	assert.Equal(t, "/EFI/efiboot.img", images[0].Path)
+	assert.Equal(t, int64(len(efi)), images[0].Size)
+	data, err := io.ReadAll(images[0].Reader)
+	require.NoError(t, err)
+	assert.Equal(t, efi, data)
+
+	images, err = img.BootImages()
+	require.NoError(t, err)
+	require.Len(t, images, 4)
+	assert.Equal(t, "/isolinux/isolinux.bin", images[0].Path)
+	assert.Equal(t, int64(len(loader)), images[0].Size, "the whole file of a no emulation image is returned")
+	assert.Equal(t, int64(len(floppy)), images[2].Size)
+	assert.Equal(t, int64(7*512), images[3].Size)
+
+	images, err = img.BootImages(BootPlatformMac)
+	require.NoError(t, err)
+	assert.Empty(t, images)
+}
+
+func TestWriterBootEntryErrors(t *testing.T) {
+	for name, c := range map[string]struct {
+		emulation BootEmulation
+		data      []byte
+		problem   string
+	}{
+		"floppy size": {emulation: BootFloppy12M, data: make([]byte, 1000), problem: "instead of the 1228800 of the emulated floppy"},
+		"no MBR":      {emulation: BootHardDisk, data: make([]byte, 1000), problem: "doesn't start with an MBR"},
+	} {
+		w, err := NewWriter()
+		require.NoError(t, err)
+		require.NoError(t, w.AddFile(bytes.NewReader(c.data), "boot.img"))
+		require.NoError(t, w.AddBootEntry(BootPlatformX86, c.emulation, "boot.img"), name)
+		assert.ErrorContains(t, w.WriteTo(io.Discard, ""), c.problem, name)
+		require.NoError(t, w.Cleanup())
+	}
+
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("boot"), "boot.img"))
+	require.NoError(t, w.AddBootEntry(BootPlatformX86, BootNoEmulation, "boot.img"))
+	require.NoError(t, w.Remove("boot.img"))
+	assert.ErrorContains(t, w.WriteTo(io.Discard, ""), "is no longer staged")
+}
+
+func TestWriterBootInfoTable(t *testing.T) {
+	// 4099 bytes, so that the last word of the checksum is padded
+	loader := make([]byte, 4099)
+	for n := range loader {
+		loader[n] = byte(n * 7)
+	}
+	var checksum uint32
+	padded := append(append([]byte(nil), loader...), 0)
+	for n := 64; n < len(padded); n += 4 {
+		checksum += binary.LittleEndian.Uint32(padded[n:])
+	}
+
+	for _, extendedAttributes := range []bool{false, true} {
+		w, err := NewWriterWithOptions(WriterOptions{ExtendedAttributeRecords: extendedAttributes})
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+		require.NoError(t, w.AddFile(bytes.NewReader(loader), "ISOLINUX/ISOLINUX.BIN"))
+		require.NoError(t, w.AddBootEntry(BootPlatformX86, BootNoEmulation, "ISOLINUX/ISOLINUX.BIN", WithBootLoadSize(4), WithBootInfoTable()))
+
+		img := remaster(t, w)
+		entries, err := img.BootEntries()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		f := filesByPath(t, img)["/ISOLINUX/ISOLINUX.BIN"]
+		require.NotNil(t, f)
+		data, err := io.ReadAll(f.Reader())
+		require.NoError(t, err)
+		require.Len(t, data, len(loader))
+
+		table := make([]byte, bootInfoTableSize)
+		binary.LittleEndian.PutUint32(table[0:], 16)
+		binary.LittleEndian.PutUint32(table[4:], entries[0].LBA)
+		binary.LittleEndian.PutUint32(table[8:], uint32(len(loader)))
+		binary.LittleEndian.PutUint32(table[12:], checksum)
+		assert.Equal(t, loader[:8], data[:8], extendedAttributes)
+		assert.Equal(t, table, data[8:64], extendedAttributes)
+		assert.Equal(t, loader[64:], data[64:], extendedAttributes)
+
+		r, err := img.BootImageReader(entries[0])
+		require.NoError(t, err)
+		loaded, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, data[:4*512], loaded, extendedAttributes)
+	}
+
+	for name, add := range map[string]func(w *ImageWriter) error{
+		"too short": func(w *ImageWriter) error {
+			return w.AddFile(bytes.NewReader(make([]byte, 63)), "boot.bin")
+		},
+		"streamed": func(w *ImageWriter) error {
+			return w.AddStreamedFile(bytes.NewReader(loader), int64(len(loader)), "boot.bin")
+		},
+	} {
+		w, err := NewWriter()
+		require.NoError(t, err)
+		require.NoError(t, add(w), name)
+		require.NoError(t, w.AddBootEntry(BootPlatformX86, BootNoEmulation, "boot.bin", WithBootInfoTable()), name)
+		assert.ErrorContains(t, w.WriteTo(io.Discard, ""), "boot info table", name)
+		require.NoError(t, w.Cleanup())
+	}
+}
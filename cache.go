@@ -0,0 +1,146 @@
+package iso9660
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"sync"
+)
+
+// CacheStats counts the metadata reads served by the sector cache of an Image, see ReaderOptions.CacheSize
+type CacheStats struct {
+	// Hits and Misses count sectors, not read calls
+	Hits   uint64
+	Misses uint64
+}
+
+// sectorCache is a least-recently-used cache of whole sectors in front of an image.
+// It is safe for concurrent use.
+type sectorCache struct {
+	ra       io.ReaderAt
+	capacity int
+
+	mu      sync.Mutex
+	lru     *list.List // of *cachedSector, the most recently used first
+	sectors map[int64]*list.Element
+	stats   CacheStats
+}
+
+type cachedSector struct {
+	lba  int64
+	data []byte
+}
+
+// newSectorCache creates a cache holding up to size bytes of the image, rounded down to whole sectors.
+// It returns nil if the size is too small for a single sector.
+func newSectorCache(ra io.ReaderAt, size int64) *sectorCache {
+	capacity := size / int64(sectorSize)
+	if capacity < 1 {
+		return nil
+	}
+	return &sectorCache{
+		ra:       ra,
+		capacity: int(capacity),
+		lru:      list.New(),
+		sectors:  make(map[int64]*list.Element),
+	}
+}
+
+// bypassCache returns the image behind a sector cache, which file contents are read from
+// so that large copies don't evict the directories
+func bypassCache(ra io.ReaderAt) io.ReaderAt {
+	if c, ok := ra.(*sectorCache); ok {
+		return c.ra
+	}
+	return ra
+}
+
+// lookup returns a cached sector, or nil if it isn't cached.
+// The hit or miss is counted if count is set.
+func (c *sectorCache) lookup(lba int64, count bool) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.sectors[lba]
+	if !ok {
+		if count {
+			c.stats.Misses++
+		}
+		return nil
+	}
+	if count {
+		c.stats.Hits++
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cachedSector).data
+}
+
+func (c *sectorCache) insert(lba int64, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.sectors[lba]; ok {
+		// another reader got there first
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.sectors[lba] = c.lru.PushFront(&cachedSector{lba: lba, data: data})
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.sectors, oldest.Value.(*cachedSector).lba)
+	}
+}
+
+func (c *sectorCache) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("sectorCache.ReadAt: negative offset")
+	}
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		lba := pos / int64(sectorSize)
+		within := int(pos % int64(sectorSize))
+
+		if data := c.lookup(lba, true); data != nil {
+			n += copy(p[n:], data[within:])
+			continue
+		}
+
+		// read the missing sectors up to the end of the request, or the next cached sector, at once
+		last := (off + int64(len(p)) - 1) / int64(sectorSize)
+		count := int64(1)
+		for lba+count <= last && c.lookup(lba+count, false) == nil {
+			count++
+		}
+		c.mu.Lock()
+		c.stats.Misses += uint64(count - 1)
+		c.mu.Unlock()
+		buffer := make([]byte, count*int64(sectorSize))
+		read, err := c.ra.ReadAt(buffer, lba*int64(sectorSize))
+		for s := int64(0); s < int64(read)/int64(sectorSize); s++ {
+			c.insert(lba+s, append([]byte(nil), buffer[s*int64(sectorSize):(s+1)*int64(sectorSize)]...))
+		}
+
+		if read > within {
+			n += copy(p[n:], buffer[within:read])
+		}
+		if read < len(buffer) {
+			if n == len(p) {
+				return n, nil
+			}
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (c *sectorCache) statistics() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
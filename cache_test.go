@@ -0,0 +1,154 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingReaderAt counts the calls to ReadAt
+type countingReaderAt struct {
+	ra    io.ReaderAt
+	mu    sync.Mutex
+	reads int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.mu.Lock()
+	c.reads++
+	c.mu.Unlock()
+	return c.ra.ReadAt(p, off)
+}
+
+func TestSectorCache(t *testing.T) {
+	data := make([]byte, 5*sectorSize+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	backend := &countingReaderAt{ra: bytes.NewReader(data)}
+	cache := newSectorCache(backend, 3*int64(sectorSize)+1)
+	require.NotNil(t, cache)
+	assert.Nil(t, newSectorCache(backend, int64(sectorSize)-1))
+
+	read := func(off int64, length int) []byte {
+		buffer := make([]byte, length)
+		n, err := cache.ReadAt(buffer, off)
+		require.NoError(t, err)
+		require.Equal(t, length, n)
+		return buffer
+	}
+
+	// the two sectors are read at once
+	assert.Equal(t, data[1000:3000], read(1000, 2000))
+	assert.Equal(t, CacheStats{Misses: 2}, cache.statistics())
+	assert.Equal(t, 1, backend.reads)
+
+	assert.Equal(t, data[10:20], read(10, 10))
+	assert.Equal(t, data[2048:4096], read(2048, 2048))
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 2}, cache.statistics())
+	assert.Equal(t, 1, backend.reads)
+
+	// the sectors after the cached one are read at once, evicting the least recently used one
+	assert.Equal(t, data[3000:7000], read(3000, 4000))
+	assert.Equal(t, CacheStats{Hits: 3, Misses: 4}, cache.statistics())
+	assert.Len(t, cache.sectors, 3)
+	read(0, 1)
+	assert.Equal(t, CacheStats{Hits: 3, Misses: 5}, cache.statistics())
+
+	// the partial sector at the end isn't cached
+	buffer := make([]byte, 200)
+	n, err := cache.ReadAt(buffer, int64(len(data))-100)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, data[len(data)-100:], buffer[:n])
+	assert.Equal(t, data[len(data)-50:], read(int64(len(data))-50, 50))
+	assert.Equal(t, CacheStats{Hits: 3, Misses: 7}, cache.statistics())
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				off := int64((g*131 + i*977) % (len(data) - 300))
+				buffer := make([]byte, 300)
+				_, err := cache.ReadAt(buffer, off)
+				assert.NoError(t, err)
+				assert.Equal(t, data[off:off+300], buffer)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestImageCache(t *testing.T) {
+	f, err := os.Open("fixtures/test_rockridge.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	uncached, err := OpenImage(f)
+	require.NoError(t, err)
+	assert.Equal(t, CacheStats{}, uncached.CacheStats())
+
+	cached, err := OpenImageWithOptions(f, ReaderOptions{CacheSize: 1 << 20})
+	require.NoError(t, err)
+
+	var expected bytes.Buffer
+	require.NoError(t, uncached.ExportManifest(&expected, ManifestOptions{Lines: true}))
+	var first bytes.Buffer
+	require.NoError(t, cached.ExportManifest(&first, ManifestOptions{Lines: true}))
+	assert.Equal(t, expected.String(), first.String())
+
+	stats := cached.CacheStats()
+	assert.NotZero(t, stats.Misses)
+
+	// walking the hierarchy again is served from the cache
+	var second bytes.Buffer
+	require.NoError(t, cached.ExportManifest(&second, ManifestOptions{Lines: true}))
+	assert.Equal(t, expected.String(), second.String())
+	assert.Equal(t, stats.Misses, cached.CacheStats().Misses)
+	assert.Greater(t, cached.CacheStats().Hits, stats.Hits)
+
+	// file contents bypass the cache
+	stats = cached.CacheStats()
+	var hashed bytes.Buffer
+	require.NoError(t, cached.ExportManifest(&hashed, ManifestOptions{Lines: true, Hash: true}))
+	assert.Equal(t, stats.Misses, cached.CacheStats().Misses)
+}
+
+func TestDiscardChildren(t *testing.T) {
+	f, err := os.Open("fixtures/test_rockridge.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	kept, err := OpenImage(f)
+	require.NoError(t, err)
+	discarded, err := OpenImageWithOptions(f, ReaderOptions{DiscardChildren: true, CacheSize: 1 << 20})
+	require.NoError(t, err)
+
+	var expected bytes.Buffer
+	require.NoError(t, kept.ExportManifest(&expected, ManifestOptions{Lines: true}))
+	var listed bytes.Buffer
+	require.NoError(t, discarded.ExportManifest(&listed, ManifestOptions{Lines: true}))
+	assert.Equal(t, expected.String(), listed.String())
+
+	root, err := discarded.RootDir()
+	require.NoError(t, err)
+	first, err := root.GetChildren()
+	require.NoError(t, err)
+	stats := discarded.CacheStats()
+	second, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Equal(t, len(first), len(second))
+	assert.NotSame(t, first[0], second[0], "the children are listed again")
+	assert.Nil(t, root.children)
+	assert.Equal(t, stats.Misses, discarded.CacheStats().Misses, "the directory is read from the cache")
+}
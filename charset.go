@@ -0,0 +1,103 @@
+package iso9660
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DecodeLatin1 converts a name encoded in ISO-8859-1 to UTF-8, see SetInputCharset
+func DecodeLatin1(name string) (string, error) {
+	runes := make([]rune, len(name))
+	for i := 0; i < len(name); i++ {
+		runes[i] = rune(name[i])
+	}
+	return string(runes), nil
+}
+
+// DecodeUTF8 checks that a name is valid UTF-8, see SetInputCharset
+func DecodeUTF8(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("%q is not valid UTF-8", name)
+	}
+	return name, nil
+}
+
+// SetInputCharset sets the function converting the names given to the Add methods, Remove and Rename,
+// along with the targets of symbolic links, from the charset they are encoded in to UTF-8,
+// such as DecodeLatin1 for local files named in ISO-8859-1, or DecodeUTF8 to reject invalid names.
+// It applies to the entries staged afterwards, and staging a name it fails to convert fails.
+//
+// Nil, the default, takes the names as they are: the bytes which aren't valid UTF-8 become underscores
+// in the primary identifiers and U+FFFD in the Joliet ones, and are kept in the Rock Ridge names.
+func (iw *ImageWriter) SetInputCharset(decode func(name string) (string, error)) {
+	iw.inputCharset = decode
+}
+
+// SetTransliteration selects whether the Latin letters with diacritics are replaced by their base letters
+// in the primary identifiers, such as E for É or AE for Æ, instead of underscores.
+// The other characters outside of ASCII still become an underscore each.
+// Joliet identifiers keep the characters of the Basic Multilingual Plane as they are.
+func (iw *ImageWriter) SetTransliteration(enabled bool) {
+	iw.transliterate = enabled
+}
+
+// pathSegments splits a path given to the Add methods, Remove or Rename into the names of the staged entries
+func (iw *ImageWriter) pathSegments(isoPath string) ([]string, error) {
+	segments := splitPath(posixifyPath(isoPath))
+	if iw.inputCharset == nil {
+		return segments, nil
+	}
+	for i, segment := range segments {
+		decoded, err := iw.decodeName(segment)
+		if err != nil {
+			return nil, err
+		}
+		segments[i] = decoded
+	}
+	return segments, nil
+}
+
+// decodeName converts a name or a symlink target with the input charset
+func (iw *ImageWriter) decodeName(name string) (string, error) {
+	if iw.inputCharset == nil {
+		return name, nil
+	}
+	decoded, err := iw.inputCharset(name)
+	if err != nil {
+		return "", fmt.Errorf("converting the name %q: %w", name, err)
+	}
+	return decoded, nil
+}
+
+// latinBaseLetters are the base letters of the characters from U+00C0 to U+017F, '?' marking those which have none
+// or are replaced by two letters, see latinDigraphs
+const latinBaseLetters = "" +
+	"AAAAAA?CEEEEIIII" + "DNOOOOO?OUUUUY??" + "aaaaaa?ceeeeiiii" + "dnooooo?ouuuuy?y" +
+	"AaAaAaCcCcCcCcDd" + "DdEeEeEeEeEeGgGg" + "GgGgHhHhIiIiIiIi" + "Ii??JjKkkLlLlLlL" +
+	"lLlNnNnNnnNnOoOo" + "Oo??RrRrRrSsSsSs" + "SsTtTtTtUuUuUuUu" + "UuUuWwYyYZzZzZzs"
+
+// latinDigraphs are the characters from U+00C0 to U+017F replaced by two letters
+var latinDigraphs = map[rune]string{
+	'Æ': "AE", 'æ': "ae", 'Þ': "TH", 'þ': "th", 'ß': "ss",
+	'Ĳ': "IJ", 'ĳ': "ij", 'Œ': "OE", 'œ': "oe",
+}
+
+// transliterate replaces the Latin letters with diacritics by their base letters,
+// and the other characters outside of ASCII by an underscore each
+func transliterate(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r < utf8.RuneSelf:
+			b.WriteRune(r)
+		case latinDigraphs[r] != "":
+			b.WriteString(latinDigraphs[r])
+		case r >= 0xC0 && r < 0xC0+rune(len(latinBaseLetters)) && latinBaseLetters[r-0xC0] != '?':
+			b.WriteByte(latinBaseLetters[r-0xC0])
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransliterate(t *testing.T) {
+	for input, expected := range map[string]string{
+		"Café Ærø":     "Cafe AEro",
+		"Łódź":         "Lodz",
+		"Straße":       "Strasse",
+		"naïve × 2":    "naive _ 2",
+		"日本語.txt":      "___.txt",
+		"plain-ascii~": "plain-ascii~",
+	} {
+		assert.Equal(t, expected, transliterate(input), input)
+	}
+}
+
+func TestWriterInputCharset(t *testing.T) {
+	// "Crème/Brûlée.txt" in ISO-8859-1
+	latin1 := "Cr\xe8me/Br\xfbl\xe9e.txt"
+
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Joliet: true, InputCharset: DecodeLatin1, Transliterate: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("dessert"), latin1))
+	require.NoError(t, w.AddSymlink("Br\xfbl\xe9e.txt", "Cr\xe8me/link"))
+	require.NoError(t, w.Rename("Cr\xe8me/link", "Cr\xe8me/Caf\xe9"))
+	names, err := w.NameMap()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/Crème":            "/CREME",
+		"/Crème/Brûlée.txt": "/CREME/BRULEE.TXT;1",
+		"/Crème/Café":       "/CREME/CAFE.;1",
+	}, names)
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "charset"))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	r, err := img.OpenFile("/Crème/Brûlée.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "dessert", string(data))
+	// the target of the link is converted as well
+	target, err := img.Stat("/Crème/Café")
+	require.NoError(t, err)
+	assert.Equal(t, "Brûlée.txt", target.Name())
+
+	joliet, err := OpenImageWithOptions(bytes.NewReader(buf.Bytes()), ReaderOptions{PreferJoliet: true})
+	require.NoError(t, err)
+	assert.Equal(t, "dessert", readPath(t, joliet, "/Crème/Brûlée.txt"))
+
+	strict, err := NewWriterWithOptions(WriterOptions{InputCharset: DecodeUTF8})
+	require.NoError(t, err)
+	defer strict.Cleanup() // nolint: errcheck
+	assert.ErrorContains(t, strict.AddFile(strings.NewReader("dessert"), latin1), "is not valid UTF-8")
+	require.NoError(t, strict.AddFile(strings.NewReader("dessert"), "Brûlée.txt"))
+}
@@ -0,0 +1,166 @@
+package iso9660
+
+import (
+	"fmt"
+)
+
+// ChildIterator lists the children of a directory one at a time, see File.Children.
+// It is used like bufio.Scanner:
+//
+//	it, err := dir.Children()
+//	if err != nil {
+//		return err
+//	}
+//	for it.Next() {
+//		f := it.File()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type ChildIterator struct {
+	dir           *File
+	includeHidden bool
+
+	// listed holds the rest of the children of a directory which was already listed at once
+	listed []*File
+
+	// the extent is read one sector at a time, the children keep their records in the sector they were read from
+	sector, sectors int
+	buffer          []byte
+	position        int
+	// pending is the last child decoded, which is only returned once it is clear that the next record doesn't continue it
+	pending *File
+
+	file *File
+	err  error
+}
+
+// Children returns an iterator over the entries GetChildren returns, in the same order.
+// Unlike GetChildren, it reads the directory's extent one sector at a time as the entries are needed
+// and doesn't keep them, so that finding a few entries in a large directory takes little time and memory.
+// A directory which was already listed, as well as the root directory, which is listed at once to detect Rock Ridge,
+// and any directory of an image opened with ReaderOptions.Recover are iterated from their listing.
+func (f *File) Children() (*ChildIterator, error) {
+	if !f.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", f.Name())
+	}
+	it := &ChildIterator{
+		dir:           f,
+		includeHidden: f.options == nil || !f.options.SkipHidden,
+		sectors:       int(fileLengthToSectors(f.de.ExtentLength)),
+	}
+
+	f.childrenMu.Lock()
+	listed := f.children
+	f.childrenMu.Unlock()
+	if listed == nil && (f.isRootDir || f.recovering()) {
+		var err error
+		if listed, err = f.GetAllChildren(); err != nil {
+			return nil, err
+		}
+	}
+	if listed != nil {
+		// the iterator must not report the end of the directory for an empty slice
+		it.listed = append(make([]*File, 0, len(listed)), listed...)
+	}
+	return it, nil
+}
+
+// Next advances to the next entry, which is then returned by File. It returns false at the end of the directory
+// or at the first error, which is returned by Err.
+func (it *ChildIterator) Next() bool {
+	it.file = nil
+	if it.err != nil {
+		return false
+	}
+	for {
+		child, err := it.next()
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if child == nil {
+			return false
+		}
+		if it.dir.listsChild(child, it.includeHidden) {
+			it.file = child
+			return true
+		}
+	}
+}
+
+// File returns the entry Next advanced to
+func (it *ChildIterator) File() *File {
+	return it.file
+}
+
+// Err returns the error which stopped the iteration, or nil if it reached the end of the directory
+func (it *ChildIterator) Err() error {
+	return it.err
+}
+
+// next returns the next entry of the directory, including the "." and ".." entries, or nil at its end
+func (it *ChildIterator) next() (*File, error) {
+	if it.listed != nil {
+		if len(it.listed) == 0 {
+			return nil, nil
+		}
+		child := it.listed[0]
+		it.listed = it.listed[1:]
+		return child, nil
+	}
+
+	for {
+		offset, record, err := it.record()
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			child := it.pending
+			it.pending = nil
+			return child, nil
+		}
+
+		child, err := it.dir.decodeChild(offset, record, &DirectoryEntry{}, &File{}, it.pending)
+		if err != nil {
+			return nil, err
+		}
+		if child == nil {
+			continue
+		}
+		previous := it.pending
+		it.pending = child
+		if previous != nil {
+			return previous, nil
+		}
+	}
+}
+
+// record returns the next directory record and its offset in the extent, or nil at the end of the extent
+func (it *ChildIterator) record() (int, []byte, error) {
+	for {
+		if it.position < len(it.buffer) {
+			if length := int(it.buffer[it.position]); length > 0 {
+				if it.position+length > len(it.buffer) {
+					return 0, nil, fmt.Errorf("reading directory entries: DE outside of sector boundries")
+				}
+				offset := (it.sector-1)*int(sectorSize) + it.position
+				record := it.buffer[it.position : it.position+length]
+				it.position += length
+				return offset, record, nil
+			}
+		}
+		if it.sector == it.sectors {
+			return 0, nil, nil
+		}
+
+		// the records of the sector read before are still in use, so every sector gets its own buffer
+		it.buffer = make([]byte, sectorSize)
+		if _, err := it.dir.ra.ReadAt(it.buffer, it.dir.de.dataOffset(it.dir.logicalBlockSize())+int64(it.sector)*int64(sectorSize)); err != nil {
+			return 0, nil, err
+		}
+		it.sector++
+		it.position = 0
+	}
+}
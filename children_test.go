@@ -0,0 +1,104 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// iterate returns the names of the entries of the iterator
+func iterate(t *testing.T, it *ChildIterator) []string {
+	var names []string
+	for it.Next() {
+		names = append(names, it.File().Name())
+	}
+	require.NoError(t, it.Err())
+	assert.Nil(t, it.File())
+	assert.False(t, it.Next())
+	return names
+}
+
+func TestChildrenLargeDirectory(t *testing.T) {
+	backend := &countingReaderAt{ra: bytes.NewReader(largeDirectoryImage(t, 2000))}
+	img, err := OpenImage(backend)
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	it, err := root.Children()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"packages"}, iterate(t, it))
+
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	dir := children[0]
+
+	// the first entry only takes the first sector
+	backend.reads = 0
+	it, err = dir.Children()
+	require.NoError(t, err)
+	require.True(t, it.Next())
+	assert.Equal(t, "package-00000.rpm", it.File().Name())
+	assert.Equal(t, 1, backend.reads)
+
+	var listed []string
+	entries, err := uncachedCopy(dir).GetChildren()
+	require.NoError(t, err)
+	for _, e := range entries {
+		listed = append(listed, e.Name())
+	}
+
+	backend.reads = 0
+	it, err = dir.Children()
+	require.NoError(t, err)
+	assert.Equal(t, listed, iterate(t, it))
+	assert.Equal(t, int(fileLengthToSectors(dir.de.ExtentLength)), backend.reads)
+	assert.Nil(t, dir.children)
+
+	// a listed directory is iterated from its listing
+	_, err = dir.GetChildren()
+	require.NoError(t, err)
+	backend.reads = 0
+	it, err = dir.Children()
+	require.NoError(t, err)
+	assert.Equal(t, listed, iterate(t, it))
+	assert.Zero(t, backend.reads)
+
+	_, err = entries[0].Children()
+	assert.Error(t, err)
+}
+
+func TestChildrenMultiExtent(t *testing.T) {
+	withMaxExtentLength(t, 2*int64(sectorSize))
+
+	data := make([]byte, 5*sectorSize+100)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	w, err := NewWriterWithOptions(WriterOptions{InterchangeLevel: 3, EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(bytes.NewReader(data), "dir/large.bin"))
+	require.NoError(t, w.AddFile(bytes.NewReader([]byte("small")), "dir/small.txt"))
+
+	img := remaster(t, w)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+
+	it, err := children[0].Children()
+	require.NoError(t, err)
+	sizes := map[string]int64{}
+	for it.Next() {
+		sizes[it.File().Name()] = it.File().Size()
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, map[string]int64{"large.bin": int64(len(data)), "small.txt": 5}, sizes)
+}
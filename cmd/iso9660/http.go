@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/kdomanski/iso9660"
+)
+
+// remoteReadAhead fetches remote images in blocks of 256 KiB, with 4 MiB read ahead and 64 MiB kept in memory
+var remoteReadAhead = iso9660.ReadAheadOptions{BlockSize: 256 * 1024, ReadAhead: 16, Blocks: 256}
+
+// isURL tells whether an image is given as an HTTP or HTTPS URL
+func isURL(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// httpReaderAt reads a file served over HTTP with range requests
+type httpReaderAt struct {
+	url    string
+	client *http.Client
+}
+
+// openURL opens an image served by a server supporting range requests
+func openURL(url string) (*iso9660.Image, error) {
+	r := &httpReaderAt{url: url, client: http.DefaultClient}
+	size, err := r.size()
+	if err != nil {
+		return nil, err
+	}
+	ra, err := iso9660.NewReadAheadReaderAt(r, size, remoteReadAhead)
+	if err != nil {
+		return nil, err
+	}
+	return iso9660.OpenImage(ra)
+}
+
+// size asks the server for the length of the file
+func (r *httpReaderAt) size() (int64, error) {
+	resp, err := r.client.Head(r.url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New(resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return 0, errors.New("the server doesn't tell the size of the image")
+	}
+	return resp.ContentLength, nil
+}
+
+func (r *httpReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return 0, fmt.Errorf("%s: the server doesn't support range requests", r.url)
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF
+	default:
+		return 0, fmt.Errorf("%s: reading %d bytes at offset %d: %s", r.url, len(p), off, resp.Status)
+	}
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,1189 @@
+// Command iso9660 lists, extracts, creates and checks ISO9660 images.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kdomanski/iso9660"
+)
+
+const usage = `usage: %[1]s COMMAND [FLAGS] ARGS
+
+commands:
+  ls, list [--json] ISOFILE [PATH]             list the entries of the image recursively
+  extract [FLAGS] ISOFILE TARGET_DIR [PATH]    extract the image or a path within it
+  create [FLAGS] SOURCE_DIR ISOFILE            create an image from a local directory
+  info [--json] ISOFILE                        show the volume descriptors, the extensions and the boot entries
+  verify [--json] [FLAGS] ISOFILE              check the structure of the image
+  manifest [FLAGS] ISOFILE                     print an inventory of the image as JSON
+  diff [--json] [FLAGS] ISOFILE ISOFILE        compare the files and the boot catalogs of two images
+  implant-md5 [--force] ISOFILE                implant an MD5 checksum like implantisomd5, see verify --md5
+  repack [--sort FILE] ISOFILE OUTFILE         rewrite the image without the extents no longer in its directory tree
+
+Run "%[1]s COMMAND --help" for the flags of a command.
+`
+
+var commands = map[string]func(args []string) error{
+	"ls":          runLs,
+	"list":        runLs,
+	"extract":     runExtract,
+	"create":      runCreate,
+	"info":        runInfo,
+	"verify":      runVerify,
+	"manifest":    runManifest,
+	"diff":        runDiff,
+	"implant-md5": runImplantMD5,
+	"repack":      runRepack,
+}
+
+// errFailed makes the command exit with status 1 after it has already reported why
+var errFailed = errors.New("failed")
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		log.Fatalf(usage, os.Args[0])
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		log.Fatalf(usage, os.Args[0])
+	}
+
+	if err := run(os.Args[2:]); err == errFailed {
+		os.Exit(1)
+	} else if err != nil {
+		log.Fatalf("%s %s: %s", os.Args[0], os.Args[1], err)
+	}
+}
+
+// newFlagSet creates the flag set of a command, which exits with status 2 on invalid flags
+func newFlagSet(name, arguments string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s %s\n", os.Args[0], name, arguments)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses the flags and checks the number of the remaining arguments
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) []string {
+	_ = fs.Parse(args)
+	if fs.NArg() < min || fs.NArg() > max {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Args()
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// openImage opens an image file, the data track of a CUE sheet or an image served over HTTP. The returned image must be closed by the caller.
+func openImage(name string) (*iso9660.Image, error) {
+	if isURL(name) {
+		img, err := openURL(name)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		return img, nil
+	}
+	if strings.EqualFold(filepath.Ext(name), ".cue") {
+		img, err := iso9660.OpenCueSheet(name, iso9660.ReaderOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		return img, nil
+	}
+	img, err := iso9660.OpenImageMmap(name)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	return img, nil
+}
+
+// lookup finds the entry at a slash-separated path made of the names returned by File.Name
+func lookup(img *iso9660.Image, isoPath string) (*iso9660.File, error) {
+	f, err := img.RootDir()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+isoPath), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if !f.IsDir() {
+			return nil, fmt.Errorf("%s: %w", isoPath, os.ErrNotExist)
+		}
+		children, err := f.GetChildren()
+		if err != nil {
+			return nil, err
+		}
+		var next *iso9660.File
+		for _, c := range children {
+			if c.Name() == name {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%s: %w", isoPath, os.ErrNotExist)
+		}
+		f = next
+	}
+	return f, nil
+}
+
+// walk calls fn for f and, if it's a directory, for everything in it, in the order of the names
+func walk(f *iso9660.File, filePath string, fn func(f *iso9660.File, filePath string) error) error {
+	if err := fn(f, filePath); err != nil {
+		return err
+	}
+	if !f.IsDir() {
+		return nil
+	}
+
+	children, err := f.GetChildren()
+	if err != nil {
+		return fmt.Errorf("reading directory %s: %w", filePath, err)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Name() < children[j].Name() })
+	for _, c := range children {
+		if err := walk(c, path.Join(filePath, c.Name()), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listedEntry is an entry as printed by ls --json
+type listedEntry struct {
+	Path          string    `json:"path"`
+	Mode          string    `json:"mode"`
+	Size          int64     `json:"size"`
+	UID           *uint32   `json:"uid,omitempty"`
+	GID           *uint32   `json:"gid,omitempty"`
+	Links         uint32    `json:"nlink"`
+	ModTime       time.Time `json:"mtime"`
+	SymlinkTarget string    `json:"symlink_target,omitempty"`
+	// Device holds the major and minor numbers of a device node
+	Device *[2]uint32 `json:"device,omitempty"`
+}
+
+func runLs(args []string) error {
+	fs := newFlagSet("ls", "[--json] ISOFILE [PATH]")
+	asJSON := fs.Bool("json", false, "print the entries as a JSON array")
+	args = parseArgs(fs, args, 1, 2)
+
+	img, err := openImage(args[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close() // nolint: errcheck
+
+	start := "/"
+	if len(args) == 2 {
+		start = path.Clean("/" + args[1])
+	}
+	f, err := lookup(img, start)
+	if err != nil {
+		return err
+	}
+
+	entries := []listedEntry{}
+	err = walk(f, start, func(f *iso9660.File, filePath string) error {
+		f = attributes(f, filePath)
+		e := listedEntry{
+			Path:          filePath,
+			Mode:          f.Mode().String(),
+			Links:         f.Links(),
+			ModTime:       f.ModTime().UTC(),
+			SymlinkTarget: f.SymlinkTarget(),
+		}
+		if !f.IsDir() {
+			e.Size = f.Size()
+		}
+		if uid, gid, ok := f.Owner(); ok {
+			e.UID, e.GID = &uid, &gid
+		}
+		if major, minor, ok := f.DeviceNumber(); ok {
+			e.Device = &[2]uint32{major, minor}
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(entries)
+	}
+	for _, e := range entries {
+		owner := "-"
+		if e.UID != nil {
+			owner = fmt.Sprintf("%d:%d", *e.UID, *e.GID)
+		}
+		size := fmt.Sprint(e.Size)
+		if e.Device != nil {
+			size = fmt.Sprintf("%d, %d", e.Device[0], e.Device[1])
+		}
+		line := fmt.Sprintf("%s %3d %9s %10s %s %s", e.Mode, e.Links, owner, size, e.ModTime.Format(time.RFC3339), e.Path)
+		if e.SymlinkTarget != "" {
+			line += " -> " + e.SymlinkTarget
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func runExtract(args []string) error {
+	fs := newFlagSet("extract", "[FLAGS] ISOFILE TARGET_DIR [PATH]")
+	overwrite := fs.Bool("overwrite", false, "replace files which already exist in the target directory")
+	include := fs.String("include", "", "only extract the files whose path in the image, or name if the pattern has no slash, matches this pattern")
+	verbose := fs.Bool("v", false, "print the paths of the extracted entries")
+	skipTruncated := fs.Bool("skip-truncated", false, "report the files whose data lies beyond the end of a truncated image and extract the others")
+	args = parseArgs(fs, args, 2, 3)
+
+	if *include != "" {
+		if _, err := path.Match(*include, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", *include, err)
+		}
+	}
+
+	img, err := openImage(args[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close() // nolint: errcheck
+
+	start := "/"
+	if len(args) == 3 {
+		start = path.Clean("/" + args[2])
+	}
+	f, err := lookup(img, start)
+	if err != nil {
+		return err
+	}
+
+	if missing, truncated := img.IsTruncated(); truncated {
+		log.Printf("warning: the image is truncated, %d bytes are missing", missing)
+	}
+
+	target := args[1]
+	skipped := false
+	err = walk(f, start, func(f *iso9660.File, filePath string) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(filePath, start), "/")
+		if *include != "" {
+			// only the directories leading to matching files are created
+			if f.IsDir() || !matches(*include, filePath) {
+				return nil
+			}
+		}
+		localPath := filepath.Join(target, filepath.FromSlash(rel))
+		err := extractEntry(attributes(f, filePath), localPath, *overwrite)
+		var outOfRange *iso9660.ExtentOutOfRangeError
+		if errors.As(err, &outOfRange) {
+			outOfRange.Path = filePath
+			if *skipTruncated {
+				log.Printf("skipping %s", outOfRange)
+				skipped = true
+				return nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if *verbose {
+			fmt.Println(filePath)
+		}
+		return nil
+	})
+	if err == nil && skipped {
+		return errFailed
+	}
+	return err
+}
+
+// matches reports whether a path in the image matches the pattern of extract --include
+func matches(pattern, filePath string) bool {
+	if !strings.Contains(pattern, "/") {
+		filePath = path.Base(filePath)
+	}
+	matched, _ := path.Match(pattern, filePath)
+	return matched
+}
+
+// attributes returns the entry which holds the attributes of f.
+// Those of the root directory are only recorded in its "." entry.
+func attributes(f *iso9660.File, filePath string) *iso9660.File {
+	if filePath == "/" {
+		if dot, err := f.GetDotEntry(); err == nil && dot != nil {
+			return dot
+		}
+	}
+	return f
+}
+
+// extractEntry creates a directory or copies a regular file to the local path.
+// Symbolic links are recreated, other special files are skipped.
+func extractEntry(f *iso9660.File, localPath string, overwrite bool) error {
+	mode := f.Mode()
+	perm := mode.Perm()
+
+	switch {
+	case mode.IsDir():
+		if perm == 0 {
+			perm = 0755
+		}
+		if err := os.MkdirAll(localPath, perm|0700); err != nil {
+			return err
+		}
+		return nil
+	case mode&os.ModeSymlink != 0:
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+		if overwrite {
+			if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return os.Symlink(f.SymlinkTarget(), localPath)
+	case mode&os.ModeType != 0:
+		log.Printf("skipping %s, a %s", localPath, mode.Type())
+		return nil
+	}
+
+	if perm == 0 {
+		perm = 0644
+	}
+	data, err := f.OpenReader()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	out, err := os.OpenFile(localPath, flags, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, data); err != nil {
+		out.Close() // nolint: errcheck
+		return fmt.Errorf("extracting %s: %w", localPath, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(localPath, f.ModTime(), f.ModTime())
+}
+
+// nameTranslations are the values of create --names
+var nameTranslations = map[string]iso9660.NameTranslation{
+	"mangle":  iso9660.NamesMangle,
+	"strict":  iso9660.NamesStrict,
+	"relaxed": iso9660.NamesRelaxed,
+}
+
+func runCreate(args []string) error {
+	fs := newFlagSet("create", "[FLAGS] SOURCE_DIR ISOFILE")
+	var opts iso9660.WriterOptions
+	var volume iso9660.VolumeMetadata
+	fs.BoolVar(&opts.EnableRockRidge, "rock-ridge", false, "write Rock Ridge entries")
+	fs.StringVar(&opts.RockRidgeIdentifier, "rock-ridge-id", "", "the extension identifier of the Rock Ridge ER entry, RRIP_1991A for RRIP 1.09 or IEEE_P1282 for RRIP 1.12")
+	zisofs := fs.Bool("zisofs", false, "compress the files with zisofs, requires --rock-ridge")
+	var zisofsOpts iso9660.ZisofsOptions
+	fs.Int64Var(&zisofsOpts.MinSize, "zisofs-min-size", 0, "the size in bytes below which files aren't compressed")
+	fs.Float64Var(&zisofsOpts.MaxRatio, "zisofs-max-ratio", 0, "the largest compressed to original size ratio of files stored compressed")
+	fs.IntVar(&opts.InterchangeLevel, "level", 0, "the interchange level, 1, 2 or 3 for files larger than 4 GiB")
+	names := fs.String("names", "mangle", "how names become ISO 9660 identifiers: mangle, strict to fail on names which would change, or relaxed to keep their case and characters")
+	inputCharset := fs.String("input-charset", "", "the charset of the local file names, utf-8 to reject invalid names or iso-8859-1")
+	fs.BoolVar(&opts.Transliterate, "transliterate", false, "replace Latin letters with diacritics by their base letters in ISO 9660 identifiers")
+	fs.BoolVar(&opts.OmitVersionSuffix, "omit-version", false, `leave the ";1" version out of file identifiers`)
+	fs.BoolVar(&opts.FileVersions, "file-versions", false, `write files named like "SETUP.EXE;2" as that version of their identifier`)
+	fs.BoolVar(&opts.TransTables, "trans-tables", false, "write TRANS.TBL files to directories with renamed entries")
+	fs.BoolVar(&opts.PreserveDeviceNodes, "devices", false, "stage device nodes and FIFOs, requires --rock-ridge")
+	fs.BoolVar(&opts.PreserveOwnership, "owners", false, "record the owners of the local files")
+	fs.BoolVar(&opts.NormalizeLocalMetadata, "normalize", false, "record normalized permissions and owners instead of the local ones")
+	owner := fs.String("owner", "", "record UID:GID as the owner of every entry, such as 0:0")
+	fs.BoolVar(&opts.Deduplicate, "dedup", false, "store files with identical contents only once")
+	fs.BoolVar(&opts.ExtendedAttributeRecords, "xar", false, "record the owners, permissions and times of files in extended attribute records, for systems without Rock Ridge")
+	fs.BoolVar(&opts.ImplantMD5, "implant-md5", false, "implant an MD5 checksum of the image")
+	fs.BoolVar(&opts.DenseOutput, "dense", false, "write every sector, even to files which support holes")
+	fs.BoolVar(&opts.Reproducible, "reproducible", false, "produce the same image from the same files, dated by SOURCE_DATE_EPOCH")
+	pad := fs.Uint("pad", 0, "the number of zero sectors appended to the image")
+	fs.IntVar(&opts.LogicalBlockSize, "block-size", 0, "record the locations in logical blocks of 512 or 1024 bytes instead of 2048, for specialized targets")
+	fs.IntVar(&opts.DefaultAlignment, "align", 0, "align the extents of all files to a multiple of this number of sectors, such as 32 for 64 KiB")
+	var alignments fileAlignments
+	fs.Var(&alignments, "align-file", "align the extent of the file at PATH=SECTORS in the image, may be repeated")
+	sortFile := fs.String("sort", "", "the file with lines of PATTERN WEIGHT placing the files whose paths in the image match first, the heaviest first, like mkisofs -sort")
+	hybrid := fs.Bool("hybrid", false, "write a partition table, so the image boots when copied to a disk")
+	var hybridOpts iso9660.HybridOptions
+	mbrCode := fs.String("mbr-code", "", "the file with the MBR boot code of a hybrid image, such as isohdpfx.bin")
+	fs.StringVar(&hybridOpts.EFIImagePath, "efi-image", "", "the path in the image of the EFI system partition of a hybrid image")
+	fs.BoolVar(&hybridOpts.GPT, "gpt", false, "partition a hybrid image with a GPT")
+	fs.StringVar(&volume.VolumeIdentifier, "volume-id", "", "the volume identifier")
+	fs.StringVar(&volume.SystemIdentifier, "system-id", "", "the system identifier")
+	fs.StringVar(&volume.VolumeSetIdentifier, "volume-set-id", "", "the volume set identifier")
+	volumeSequence := fs.String("volume", "", "the place of the image in its volume set as N/SIZE, such as 2/3 for the second of three discs")
+	fs.StringVar(&volume.PublisherIdentifier, "publisher", "", "the publisher identifier")
+	fs.StringVar(&volume.DataPreparerIdentifier, "preparer", "", "the data preparer identifier")
+	fs.StringVar(&volume.ApplicationIdentifier, "application", "", "the application identifier")
+	fs.StringVar(&volume.CopyrightFileIdentifier, "copyright", "", "the identifier of the copyright file in the root directory")
+	fs.StringVar(&volume.AbstractFileIdentifier, "abstract", "", "the identifier of the abstract file in the root directory")
+	fs.StringVar(&volume.BibliographicFileIdentifier, "biblio", "", "the identifier of the bibliographic file in the root directory")
+	args = parseArgs(fs, args, 2, 2)
+
+	if *zisofs {
+		opts.Zisofs = &zisofsOpts
+	}
+	translation, ok := nameTranslations[*names]
+	if !ok {
+		return fmt.Errorf("unknown name translation %q, expected mangle, strict or relaxed", *names)
+	}
+	opts.Names = translation
+	switch strings.ToLower(*inputCharset) {
+	case "":
+	case "utf-8", "utf8":
+		opts.InputCharset = iso9660.DecodeUTF8
+	case "iso-8859-1", "latin1":
+		opts.InputCharset = iso9660.DecodeLatin1
+	default:
+		return fmt.Errorf("unsupported input charset %q, expected utf-8 or iso-8859-1", *inputCharset)
+	}
+	opts.PadSectors = uint32(*pad)
+	if *sortFile != "" {
+		weights, err := readSortFile(*sortFile)
+		if err != nil {
+			return err
+		}
+		opts.SortWeight = weights
+	}
+	if *owner != "" {
+		var uid, gid uint32
+		if _, err := fmt.Sscanf(*owner, "%d:%d", &uid, &gid); err != nil {
+			return fmt.Errorf("invalid owner %q, expected UID:GID", *owner)
+		}
+		opts.OwnerMap = func(string, uint32, uint32) (uint32, uint32) { return uid, gid }
+	}
+	if *hybrid {
+		if *mbrCode != "" {
+			code, err := os.ReadFile(*mbrCode)
+			if err != nil {
+				return err
+			}
+			hybridOpts.BootCode = code
+		}
+		opts.Hybrid = &hybridOpts
+	}
+
+	iw, err := iso9660.NewWriterWithOptions(opts)
+	if err != nil {
+		return err
+	}
+	defer iw.Cleanup() // nolint: errcheck
+
+	// keep the defaults of the fields which weren't given
+	metadata := iw.VolumeMetadata()
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&metadata.VolumeIdentifier, volume.VolumeIdentifier},
+		{&metadata.SystemIdentifier, volume.SystemIdentifier},
+		{&metadata.VolumeSetIdentifier, volume.VolumeSetIdentifier},
+		{&metadata.PublisherIdentifier, volume.PublisherIdentifier},
+		{&metadata.DataPreparerIdentifier, volume.DataPreparerIdentifier},
+		{&metadata.ApplicationIdentifier, volume.ApplicationIdentifier},
+		{&metadata.CopyrightFileIdentifier, volume.CopyrightFileIdentifier},
+		{&metadata.AbstractFileIdentifier, volume.AbstractFileIdentifier},
+		{&metadata.BibliographicFileIdentifier, volume.BibliographicFileIdentifier},
+	} {
+		if field.src != "" {
+			*field.dst = field.src
+		}
+	}
+	iw.SetVolumeMetadata(metadata)
+	if *volumeSequence != "" {
+		set := iso9660.VolumeSet{Identifier: metadata.VolumeSetIdentifier}
+		if _, err := fmt.Sscanf(*volumeSequence, "%d/%d", &set.SequenceNumber, &set.Size); err != nil {
+			return fmt.Errorf("invalid volume %q, expected N/SIZE", *volumeSequence)
+		}
+		if err := iw.SetVolumeSet(set); err != nil {
+			return err
+		}
+	}
+
+	if err := iw.AddLocalDirectory(args[0], "/"); err != nil {
+		return err
+	}
+	for _, a := range alignments {
+		if err := iw.SetAlignment(a.path, a.sectors); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.OpenFile(args[1], os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := iw.WriteTo(out, ""); err != nil {
+		out.Close() // nolint: errcheck
+		return err
+	}
+	return out.Close()
+}
+
+// readSortFile reads the weights of create --sort, with the pattern and the weight of a group of files on every line.
+// The files are given the weight of the first pattern their path matches, and 0 if none does.
+func readSortFile(name string) (func(isoPath string) int, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	type weight struct {
+		pattern string
+		weight  int
+	}
+	var weights []weight
+	for n, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var w weight
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected PATTERN WEIGHT", name, n+1)
+		}
+		if _, err := fmt.Sscanf(fields[1], "%d", &w.weight); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid weight %q", name, n+1, fields[1])
+		}
+		w.pattern = path.Clean("/" + fields[0])
+		if _, err := path.Match(w.pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n+1, err)
+		}
+		weights = append(weights, w)
+	}
+
+	return func(isoPath string) int {
+		for _, w := range weights {
+			if ok, _ := path.Match(w.pattern, isoPath); ok {
+				return w.weight
+			}
+		}
+		return 0
+	}, nil
+}
+
+// fileAlignment is a file aligned with create --align-file
+type fileAlignment struct {
+	path    string
+	sectors int
+}
+
+// fileAlignments collects the values of create --align-file
+type fileAlignments []fileAlignment
+
+func (a *fileAlignments) String() string {
+	values := make([]string, len(*a))
+	for n, alignment := range *a {
+		values[n] = fmt.Sprintf("%s=%d", alignment.path, alignment.sectors)
+	}
+	return strings.Join(values, ",")
+}
+
+func (a *fileAlignments) Set(value string) error {
+	sep := strings.LastIndexByte(value, '=')
+	if sep <= 0 {
+		return fmt.Errorf("invalid alignment %q, expected PATH=SECTORS", value)
+	}
+	var sectors int
+	if _, err := fmt.Sscanf(value[sep+1:], "%d", &sectors); err != nil || sectors < 0 {
+		return fmt.Errorf("invalid alignment %q, expected PATH=SECTORS", value)
+	}
+	*a = append(*a, fileAlignment{path: value[:sep], sectors: sectors})
+	return nil
+}
+
+// volumeInfo is the output of info --json
+type volumeInfo struct {
+	Volume    iso9660.VolumeMetadata `json:"volume"`
+	Times     iso9660.VolumeTimes    `json:"times"`
+	VolumeSet iso9660.VolumeSet      `json:"volume_set"`
+	RockRidge bool                   `json:"rock_ridge"`
+	// RockRidgeDetection tells how Rock Ridge was detected, if it is in use
+	RockRidgeDetection string `json:"rock_ridge_detection,omitempty"`
+	// RockRidgeRevision is the revision of RRIP the image follows, if it uses Rock Ridge
+	RockRidgeRevision string `json:"rock_ridge_revision,omitempty"`
+	// Extensions are the identifiers of the SUSP extensions in use
+	Extensions []string `json:"extensions"`
+	// HFS is the HFS or HFS+ volume of a hybrid disc
+	HFS *iso9660.HFSVolume `json:"hfs,omitempty"`
+	// UDF is the UDF volume of a UDF bridge image
+	UDF *udfInfo `json:"udf,omitempty"`
+	// Descriptors are the volume descriptors of the image, up to and including the terminator
+	Descriptors []descriptorInfo `json:"descriptors"`
+	// BootCatalog is the sector of the El Torito boot catalog, if there is one
+	BootCatalog *uint32 `json:"boot_catalog,omitempty"`
+	// Boot are the entries of the boot catalog
+	Boot []bootInfo `json:"boot,omitempty"`
+	// Warnings are the ambiguities found while opening the image
+	Warnings []iso9660.ReaderWarning `json:"warnings,omitempty"`
+	// MissingBytes is the number of bytes missing from a truncated image
+	MissingBytes int64 `json:"missing_bytes,omitempty"`
+}
+
+// udfInfo is the UDF volume of a UDF bridge image as printed by info
+type udfInfo struct {
+	Revision   string `json:"revision"`
+	Identifier string `json:"identifier"`
+}
+
+// descriptorInfo is a volume descriptor as printed by info
+type descriptorInfo struct {
+	Sector   uint32 `json:"sector"`
+	Kind     string `json:"kind"`
+	Selected bool   `json:"selected,omitempty"`
+	Problem  string `json:"problem,omitempty"`
+}
+
+// bootInfo is an El Torito boot entry as printed by info
+type bootInfo struct {
+	Platform  string `json:"platform"`
+	Bootable  bool   `json:"bootable"`
+	Emulation string `json:"emulation"`
+	LBA       uint32 `json:"lba"`
+	// Sectors is the number of 512-byte sectors loaded by the BIOS
+	Sectors uint16 `json:"sectors"`
+}
+
+// newBootInfo describes a boot entry with the names of its platform and media type
+func newBootInfo(e iso9660.BootEntry) bootInfo {
+	platform, ok := bootPlatforms[e.PlatformID]
+	if !ok {
+		platform = fmt.Sprintf("0x%02X", e.PlatformID)
+	}
+	emulation, ok := bootEmulations[e.Emulation]
+	if !ok {
+		emulation = fmt.Sprintf("media type %d", e.Emulation)
+	}
+	return bootInfo{Platform: platform, Bootable: e.Bootable, Emulation: emulation, LBA: e.LBA, Sectors: e.SectorCount}
+}
+
+// descriptorKind names the type of a volume descriptor
+func descriptorKind(vd iso9660.VolumeDescriptorInfo) string {
+	switch {
+	case vd.Type == 0:
+		return "Boot Record"
+	case vd.Type == 1:
+		return "Primary"
+	case vd.Enhanced:
+		return "Enhanced"
+	case vd.JolietLevel > 0:
+		return fmt.Sprintf("Joliet level %d", vd.JolietLevel)
+	case vd.Type == 2:
+		return "Supplementary"
+	case vd.Type == 3:
+		return "Volume Partition"
+	case vd.Type == 255:
+		return "Terminator"
+	}
+	return fmt.Sprintf("type %d", vd.Type)
+}
+
+// bootPlatforms names the El Torito platform IDs
+var bootPlatforms = map[byte]string{
+	iso9660.BootPlatformX86:     "x86",
+	iso9660.BootPlatformPowerPC: "PowerPC",
+	iso9660.BootPlatformMac:     "Mac",
+	iso9660.BootPlatformEFI:     "EFI",
+}
+
+// bootEmulations names the El Torito media types
+var bootEmulations = map[iso9660.BootEmulation]string{
+	iso9660.BootNoEmulation: "no emulation",
+	iso9660.BootFloppy12M:   "1.2M floppy",
+	iso9660.BootFloppy144M:  "1.44M floppy",
+	iso9660.BootFloppy288M:  "2.88M floppy",
+	iso9660.BootHardDisk:    "hard disk",
+}
+
+func runInfo(args []string) error {
+	fs := newFlagSet("info", "[--json] ISOFILE")
+	asJSON := fs.Bool("json", false, "print the information as a JSON object")
+	args = parseArgs(fs, args, 1, 1)
+
+	img, err := openImage(args[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close() // nolint: errcheck
+
+	var info volumeInfo
+	if info.Volume, err = img.VolumeMetadata(); err != nil {
+		return err
+	}
+	if info.Times, err = img.VolumeTimes(); err != nil {
+		return err
+	}
+	if info.VolumeSet, err = img.VolumeSet(); err != nil {
+		return err
+	}
+	detection, err := img.RockRidgeDetection()
+	if err != nil {
+		return err
+	}
+	if info.RockRidge = detection != iso9660.RockRidgeNotDetected; info.RockRidge {
+		info.RockRidgeDetection = detection.String()
+		revision, err := img.RockRidgeRevision()
+		if err != nil {
+			return err
+		}
+		info.RockRidgeRevision = revision.String()
+	}
+	if info.Extensions, err = img.Extensions(); err != nil {
+		return err
+	}
+	if info.Extensions == nil {
+		info.Extensions = []string{}
+	}
+	if info.HFS, err = img.HFSVolume(); err != nil {
+		return err
+	}
+	udf, err := img.UDF()
+	if err != nil {
+		return err
+	}
+	if udf != nil {
+		info.UDF = &udfInfo{Revision: udf.Revision, Identifier: udf.Identifier}
+	}
+	for _, vd := range img.VolumeDescriptors() {
+		info.Descriptors = append(info.Descriptors, descriptorInfo{Sector: vd.Sector, Kind: descriptorKind(vd), Selected: vd.Selected, Problem: vd.Problem})
+	}
+	if location, ok := img.BootCatalogLocation(); ok {
+		info.BootCatalog = &location
+	}
+	entries, err := img.BootEntries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info.Boot = append(info.Boot, newBootInfo(e))
+	}
+	info.Warnings = img.Warnings()
+	info.MissingBytes, _ = img.IsTruncated()
+
+	if *asJSON {
+		return printJSON(info)
+	}
+	for _, field := range []struct {
+		name, value string
+	}{
+		{"Volume identifier", info.Volume.VolumeIdentifier},
+		{"System identifier", info.Volume.SystemIdentifier},
+		{"Volume set identifier", info.Volume.VolumeSetIdentifier},
+		{"Publisher", info.Volume.PublisherIdentifier},
+		{"Data preparer", info.Volume.DataPreparerIdentifier},
+		{"Application", info.Volume.ApplicationIdentifier},
+		{"Copyright file", info.Volume.CopyrightFileIdentifier},
+		{"Abstract file", info.Volume.AbstractFileIdentifier},
+		{"Bibliographic file", info.Volume.BibliographicFileIdentifier},
+	} {
+		if field.value != "" {
+			fmt.Printf("%s: %s\n", field.name, field.value)
+		}
+	}
+	if info.VolumeSet.Size > 1 {
+		fmt.Printf("Volume: %d of %d\n", info.VolumeSet.SequenceNumber, info.VolumeSet.Size)
+	}
+	for _, field := range []struct {
+		name  string
+		value time.Time
+	}{
+		{"Created", info.Times.Creation},
+		{"Modified", info.Times.Modification},
+		{"Expires", info.Times.Expiration},
+		{"Effective", info.Times.Effective},
+	} {
+		if !field.value.IsZero() {
+			fmt.Printf("%s: %s\n", field.name, field.value.Format(time.RFC3339))
+		}
+	}
+	if info.RockRidge {
+		fmt.Printf("Rock Ridge: true, %s, detected by its %s\n", info.RockRidgeRevision, info.RockRidgeDetection)
+	} else {
+		fmt.Println("Rock Ridge: false")
+	}
+	if len(info.Extensions) > 0 {
+		fmt.Printf("Extensions: %s\n", strings.Join(info.Extensions, ", "))
+	}
+	if hfs := info.HFS; hfs != nil {
+		line := fmt.Sprintf("Hybrid: %s volume at byte %d, %d bytes", hfs.Kind, hfs.Offset, hfs.Size)
+		if hfs.Name != "" {
+			line += fmt.Sprintf(", named %q", hfs.Name)
+		}
+		if hfs.Embedded {
+			line += ", wrapped in HFS"
+		}
+		if hfs.Partition != nil {
+			line += fmt.Sprintf(", in partition %q", hfs.Partition.Name)
+		}
+		fmt.Println(line)
+	}
+	if info.UDF != nil {
+		fmt.Printf("UDF bridge: %s volume %q\n", info.UDF.Revision, info.UDF.Identifier)
+	}
+	for _, vd := range info.Descriptors {
+		line := fmt.Sprintf("Volume descriptor: sector %d, %s", vd.Sector, vd.Kind)
+		if vd.Selected {
+			line += ", read"
+		}
+		if vd.Problem != "" {
+			line += ", invalid: " + vd.Problem
+		}
+		fmt.Println(line)
+	}
+	if info.BootCatalog != nil {
+		fmt.Printf("El Torito boot catalog: sector %d\n", *info.BootCatalog)
+	} else {
+		fmt.Println("El Torito boot catalog: none")
+	}
+	for _, e := range info.Boot {
+		bootable := "bootable"
+		if !e.Bootable {
+			bootable = "not bootable"
+		}
+		fmt.Printf("Boot entry: %s, %s, %s, sector %d, %d sectors of 512 bytes\n", e.Platform, bootable, e.Emulation, e.LBA, e.Sectors)
+	}
+	if info.MissingBytes > 0 {
+		fmt.Printf("Truncated: %d bytes are missing\n", info.MissingBytes)
+	}
+	for _, warning := range info.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	return nil
+}
+
+// verifiedFinding is a finding as printed by verify --json
+type verifiedFinding struct {
+	Severity string `json:"severity"`
+	Path     string `json:"path,omitempty"`
+	LBA      uint32 `json:"lba,omitempty"`
+	Message  string `json:"message"`
+}
+
+// verifyResult is the output of verify --json
+type verifyResult struct {
+	Findings []verifiedFinding `json:"findings"`
+	// MD5 tells whether the implanted checksum matches, if it was checked
+	MD5 *bool `json:"md5,omitempty"`
+}
+
+func runVerify(args []string) error {
+	fs := newFlagSet("verify", "[--json] [FLAGS] ISOFILE")
+	asJSON := fs.Bool("json", false, "print the findings as a JSON object")
+	fileData := fs.Bool("data", false, "read the data of every file as well")
+	checkMD5 := fs.Bool("md5", false, "check the implanted MD5 checksum as well")
+	maxFindings := fs.Int("max", 0, "stop after this many findings, 0 for no limit")
+	level := fs.Int("level", 0, "check the identifiers against this interchange level, 1 to 3")
+	args = parseArgs(fs, args, 1, 1)
+
+	img, err := openImage(args[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close() // nolint: errcheck
+
+	var opts []iso9660.VerifyOption
+	if *fileData {
+		opts = append(opts, iso9660.WithFileData())
+	}
+	if *maxFindings > 0 {
+		opts = append(opts, iso9660.WithMaxFindings(*maxFindings))
+	}
+	if *level > 0 {
+		opts = append(opts, iso9660.WithInterchangeLevel(*level))
+	}
+	report, err := img.Verify(opts...)
+	if err != nil {
+		return err
+	}
+
+	result := verifyResult{Findings: []verifiedFinding{}}
+	for _, f := range report.Findings {
+		result.Findings = append(result.Findings, verifiedFinding{
+			Severity: f.Severity.String(),
+			Path:     f.Path,
+			LBA:      f.LBA,
+			Message:  f.Message,
+		})
+	}
+	if *checkMD5 {
+		matches, err := img.VerifyImplantedMD5(nil)
+		if err != nil {
+			return err
+		}
+		result.MD5 = &matches
+	}
+
+	if *asJSON {
+		if err := printJSON(result); err != nil {
+			return err
+		}
+	} else {
+		for _, f := range report.Findings {
+			fmt.Println(f)
+		}
+		if result.MD5 != nil && *result.MD5 {
+			fmt.Println("the implanted MD5 checksum matches")
+		}
+	}
+
+	if result.MD5 != nil && !*result.MD5 {
+		if !*asJSON {
+			fmt.Println("error: the implanted MD5 checksum doesn't match")
+		}
+		return errFailed
+	}
+	if len(report.Errors()) > 0 {
+		return errFailed
+	}
+	return nil
+}
+
+func runImplantMD5(args []string) error {
+	fs := newFlagSet("implant-md5", "[--force] ISOFILE")
+	force := fs.Bool("force", false, "replace a checksum which is already implanted")
+	args = parseArgs(fs, args, 1, 1)
+
+	f, err := os.OpenFile(args[0], os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := iso9660.ImplantMD5(f, *force); err != nil {
+		f.Close() // nolint: errcheck
+		if errors.Is(err, iso9660.ErrMD5AlreadyImplanted) {
+			return fmt.Errorf("%s: %w, use --force to replace it", args[0], err)
+		}
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return f.Close()
+}
+
+func runRepack(args []string) error {
+	fs := newFlagSet("repack", "[--sort FILE] ISOFILE OUTFILE")
+	sortFile := fs.String("sort", "", "the file with lines of PATTERN WEIGHT placing the files whose paths in the image match first, like create --sort")
+	args = parseArgs(fs, args, 2, 2)
+
+	var opts []iso9660.RepackOption
+	if *sortFile != "" {
+		weights, err := readSortFile(*sortFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, iso9660.WithRepackSortWeight(weights))
+	}
+
+	in, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer in.Close() // nolint: errcheck
+	if info, err := os.Stat(args[1]); err == nil {
+		if inInfo, err := in.Stat(); err == nil && os.SameFile(info, inInfo) {
+			return fmt.Errorf("%s cannot be repacked in place", args[0])
+		}
+	}
+
+	out, err := os.OpenFile(args[1], os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := iso9660.Repack(in, out, opts...); err != nil {
+		out.Close() // nolint: errcheck
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return out.Close()
+}
+
+func runManifest(args []string) error {
+	fs := newFlagSet("manifest", "[FLAGS] ISOFILE")
+	var opts iso9660.ManifestOptions
+	fs.BoolVar(&opts.Lines, "lines", false, "print one JSON object per entry and line")
+	fs.BoolVar(&opts.Hash, "hash", false, "record the SHA-256 digests of the files")
+	fs.BoolVar(&opts.Content, "content", false, "embed the contents of the files")
+	args = parseArgs(fs, args, 1, 1)
+
+	img, err := openImage(args[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close() // nolint: errcheck
+
+	return img.ExportManifest(os.Stdout, opts)
+}
+
+// diffedSide is an entry as it is in one of the images, as printed by diff --json
+type diffedSide struct {
+	Mode          string    `json:"mode"`
+	Size          int64     `json:"size"`
+	UID           uint32    `json:"uid"`
+	GID           uint32    `json:"gid"`
+	ModTime       time.Time `json:"mtime"`
+	SymlinkTarget string    `json:"symlink_target,omitempty"`
+	// Hash is the SHA-256 digest of the data, if the files had to be hashed
+	Hash string `json:"sha256,omitempty"`
+}
+
+// diffedEntry is a path which differs, as printed by diff --json
+type diffedEntry struct {
+	Path    string      `json:"path"`
+	Kind    string      `json:"kind"`
+	Changes []string    `json:"changes,omitempty"`
+	Old     *diffedSide `json:"old,omitempty"`
+	New     *diffedSide `json:"new,omitempty"`
+}
+
+// diffedBootSide is an entry of the boot catalog as it is in one of the images, as printed by diff --json
+type diffedBootSide struct {
+	bootInfo
+	Path string `json:"path,omitempty"`
+	Size int64  `json:"size"`
+	Hash string `json:"sha256,omitempty"`
+}
+
+// diffedBootEntry is an entry of the boot catalog which differs, as printed by diff --json
+type diffedBootEntry struct {
+	Index   int             `json:"index"`
+	Kind    string          `json:"kind"`
+	Changes []string        `json:"changes,omitempty"`
+	Old     *diffedBootSide `json:"old,omitempty"`
+	New     *diffedBootSide `json:"new,omitempty"`
+}
+
+// diffResult is the output of diff --json
+type diffResult struct {
+	Entries []diffedEntry     `json:"entries"`
+	Boot    []diffedBootEntry `json:"boot"`
+}
+
+// diffChanges lists the names of the changes of a modified entry
+func diffChanges(c iso9660.DiffChange) []string {
+	if c == 0 {
+		return nil
+	}
+	return strings.Split(c.String(), ", ")
+}
+
+func newDiffedSide(side *iso9660.DiffSide) *diffedSide {
+	if side == nil {
+		return nil
+	}
+	return &diffedSide{
+		Mode:          side.Mode.String(),
+		Size:          side.Size,
+		UID:           side.UID,
+		GID:           side.GID,
+		ModTime:       side.ModTime,
+		SymlinkTarget: side.SymlinkTarget,
+		Hash:          fmt.Sprintf("%x", side.Hash),
+	}
+}
+
+func newDiffedBootSide(side *iso9660.BootDiffSide) *diffedBootSide {
+	if side == nil {
+		return nil
+	}
+	return &diffedBootSide{bootInfo: newBootInfo(side.Entry), Path: side.Path, Size: side.Size, Hash: fmt.Sprintf("%x", side.Hash)}
+}
+
+func runDiff(args []string) error {
+	fs := newFlagSet("diff", "[--json] [FLAGS] ISOFILE ISOFILE")
+	asJSON := fs.Bool("json", false, "print the differences as a JSON object")
+	ignoreModTimes := fs.Bool("ignore-mtime", false, "ignore differences of the modification times")
+	args = parseArgs(fs, args, 2, 2)
+
+	a, err := openImage(args[0])
+	if err != nil {
+		return err
+	}
+	defer a.Close() // nolint: errcheck
+	b, err := openImage(args[1])
+	if err != nil {
+		return err
+	}
+	defer b.Close() // nolint: errcheck
+
+	var opts []iso9660.DiffOption
+	if *ignoreModTimes {
+		opts = append(opts, iso9660.WithIgnoreModTimes())
+	}
+	entries, err := iso9660.Diff(a, b, opts...)
+	if err != nil {
+		return err
+	}
+	boot, err := iso9660.DiffBoot(a, b)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		result := diffResult{Entries: []diffedEntry{}, Boot: []diffedBootEntry{}}
+		for _, e := range entries {
+			result.Entries = append(result.Entries, diffedEntry{
+				Path:    e.Path,
+				Kind:    e.Kind.String(),
+				Changes: diffChanges(e.Changes),
+				Old:     newDiffedSide(e.Old),
+				New:     newDiffedSide(e.New),
+			})
+		}
+		for _, e := range boot {
+			result.Boot = append(result.Boot, diffedBootEntry{
+				Index:   e.Index,
+				Kind:    e.Kind.String(),
+				Changes: diffChanges(e.Changes),
+				Old:     newDiffedBootSide(e.Old),
+				New:     newDiffedBootSide(e.New),
+			})
+		}
+		if err := printJSON(result); err != nil {
+			return err
+		}
+	} else {
+		for _, e := range entries {
+			fmt.Println(e)
+		}
+		for _, e := range boot {
+			fmt.Println(e)
+		}
+	}
+
+	// like diff(1), differences make the command exit with status 1
+	if len(entries) > 0 || len(boot) > 0 {
+		return errFailed
+	}
+	return nil
+}
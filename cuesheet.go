@@ -0,0 +1,266 @@
+package iso9660
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cueFramesPerSecond is the number of sectors of a CD per second of the MSF addresses of CUE sheets
+const cueFramesPerSecond = 75
+
+// CueSheet lists the tracks of a CD rip and the files holding them, as parsed by ParseCueSheet
+type CueSheet struct {
+	Files []CueFile
+}
+
+// CueFile is a FILE of a CUE sheet, with the tracks recorded in it
+type CueFile struct {
+	// Name is the path of the file, relative to the directory of the CUE sheet unless it is absolute
+	Name string
+	// Type is the format of the file, usually BINARY
+	Type   string
+	Tracks []CueTrack
+}
+
+// CueTrack is a TRACK of a CUE sheet
+type CueTrack struct {
+	Number int
+	// Mode is the data type of the track, such as AUDIO, MODE1/2048, MODE1/2352 or MODE2/2352
+	Mode    string
+	Indexes []CueIndex
+}
+
+// CueIndex is an INDEX of a track, whose position is given in sectors from the beginning of the file
+type CueIndex struct {
+	Number int
+	Frame  uint32
+}
+
+// cueSectorSizes are the sizes of the sectors of the track modes in a file
+var cueSectorSizes = map[string]int64{
+	"AUDIO":      2352,
+	"CDG":        2448,
+	"MODE1/2048": 2048,
+	"MODE1/2352": 2352,
+	"MODE2/2336": 2336,
+	"MODE2/2352": 2352,
+	"CDI/2336":   2336,
+	"CDI/2352":   2352,
+}
+
+// SectorSize returns the number of bytes the sectors of the track take in its file, false for an unknown mode
+func (t CueTrack) SectorSize() (int64, bool) {
+	size, ok := cueSectorSizes[t.Mode]
+	return size, ok
+}
+
+// start returns the position of INDEX 01, where the track starts, or false if it has none
+func (t CueTrack) start() (uint32, bool) {
+	for _, index := range t.Indexes {
+		if index.Number == 1 {
+			return index.Frame, true
+		}
+	}
+	return 0, false
+}
+
+// ParseCueSheet parses the FILE, TRACK and INDEX commands of a CUE sheet. Other commands are ignored.
+func ParseCueSheet(r io.Reader) (*CueSheet, error) {
+	sheet := &CueSheet{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := cueFields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "FILE":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: FILE expects a name and a type", line)
+			}
+			sheet.Files = append(sheet.Files, CueFile{Name: fields[1], Type: strings.ToUpper(fields[2])})
+		case "TRACK":
+			if len(sheet.Files) == 0 {
+				return nil, fmt.Errorf("line %d: TRACK before the first FILE", line)
+			}
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: TRACK expects a number and a mode", line)
+			}
+			number, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid track number %q", line, fields[1])
+			}
+			file := &sheet.Files[len(sheet.Files)-1]
+			file.Tracks = append(file.Tracks, CueTrack{Number: number, Mode: strings.ToUpper(fields[2])})
+		case "INDEX":
+			var track *CueTrack
+			if n := len(sheet.Files); n > 0 && len(sheet.Files[n-1].Tracks) > 0 {
+				tracks := sheet.Files[n-1].Tracks
+				track = &tracks[len(tracks)-1]
+			}
+			if track == nil {
+				return nil, fmt.Errorf("line %d: INDEX outside of a TRACK of the current FILE", line)
+			}
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: INDEX expects a number and a position", line)
+			}
+			number, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid index number %q", line, fields[1])
+			}
+			frame, err := parseMSF(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			track.Indexes = append(track.Indexes, CueIndex{Number: number, Frame: frame})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sheet, nil
+}
+
+// cueFields splits a line of a CUE sheet into words, keeping the quoted ones together
+func cueFields(line string) []string {
+	var fields []string
+	line = strings.TrimSpace(line)
+	for line != "" {
+		var field string
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				field, line = line[1:], ""
+			} else {
+				field, line = line[1:end+1], line[end+2:]
+			}
+		} else if end := strings.IndexAny(line, " \t"); end >= 0 {
+			field, line = line[:end], line[end:]
+		} else {
+			field, line = line, ""
+		}
+		fields = append(fields, field)
+		line = strings.TrimLeft(line, " \t")
+	}
+	return fields
+}
+
+// parseMSF converts a position in minutes, seconds and frames to a number of frames
+func parseMSF(s string) (uint32, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid position %q, expected MM:SS:FF", s)
+	}
+	var values [3]uint32
+	for i, part := range parts {
+		value, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid position %q, expected MM:SS:FF", s)
+		}
+		values[i] = uint32(value)
+	}
+	if values[1] >= 60 || values[2] >= cueFramesPerSecond {
+		return 0, fmt.Errorf("invalid position %q, expected MM:SS:FF", s)
+	}
+	return (values[0]*60+values[1])*cueFramesPerSecond + values[2], nil
+}
+
+// DataTrack returns the first track holding data that can be read with the mode of RawSectorReader,
+// along with the file it is recorded in
+func (c *CueSheet) DataTrack() (*CueFile, *CueTrack, error) {
+	for f := range c.Files {
+		for t := range c.Files[f].Tracks {
+			switch c.Files[f].Tracks[t].Mode {
+			case "MODE1/2048", "MODE1/2352", "MODE2/2352":
+				return &c.Files[f], &c.Files[f].Tracks[t], nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("the CUE sheet has no MODE1/2048, MODE1/2352 or MODE2/2352 track: %w", os.ErrNotExist)
+}
+
+// OpenCueSheet opens the first data track of the CUE sheet at the given path, see CueSheet.DataTrack.
+// The first track of its file is taken to start at the first sector of the medium, so that a data track
+// following audio tracks in the same file is read as the session it belongs to, see ReaderOptions.SessionStart,
+// unless opts select another one. The tracks before it in its file must have sectors of its size.
+// The returned image must be closed with Image.Close.
+func OpenCueSheet(path string, opts ReaderOptions) (*Image, error) {
+	cue, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := ParseCueSheet(cue)
+	cue.Close() // nolint: errcheck
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	file, track, err := sheet.DataTrack()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if file.Type != "BINARY" {
+		return nil, fmt.Errorf("%s: unsupported file type %s of %s", path, file.Type, file.Name)
+	}
+	size, _ := track.SectorSize()
+	first, ok := file.Tracks[0].start()
+	if !ok {
+		return nil, fmt.Errorf("%s: track %d has no INDEX 01", path, file.Tracks[0].Number)
+	}
+	start, ok := track.start()
+	if !ok {
+		return nil, fmt.Errorf("%s: track %d has no INDEX 01", path, track.Number)
+	}
+	for _, t := range file.Tracks {
+		if t.Number == track.Number {
+			break
+		}
+		if other, _ := t.SectorSize(); other != size {
+			return nil, fmt.Errorf("%s: track %d of %d-byte sectors precedes the data track of %d-byte sectors in %s",
+				path, t.Number, other, size, file.Name)
+		}
+	}
+
+	name := file.Name
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(path), name)
+	}
+	bin, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := bin.Stat()
+	if err != nil {
+		bin.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	var ra io.ReaderAt = io.NewSectionReader(bin, int64(first)*size, info.Size()-int64(first)*size)
+	if size == rawSectorSize {
+		mode := 1
+		if track.Mode == "MODE2/2352" {
+			mode = 2
+		}
+		if ra, err = NewRawSectorReader(ra, mode); err != nil {
+			bin.Close() // nolint: errcheck
+			return nil, err
+		}
+	}
+	if opts.SessionStart == 0 {
+		opts.SessionStart = start - first
+	}
+
+	img, err := OpenImageWithOptions(ra, opts)
+	if err != nil {
+		bin.Close() // nolint: errcheck
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	img.closer = bin
+	return img, nil
+}
@@ -0,0 +1,104 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCueSheet(t *testing.T) {
+	sheet, err := ParseCueSheet(strings.NewReader(`REM GENRE Game
+FILE "My Game (Track 1).bin" BINARY
+  TRACK 01 MODE2/2352
+    INDEX 01 00:00:00
+FILE "My Game (Track 2).bin" BINARY
+  TRACK 02 AUDIO
+    PREGAP 00:02:00
+    INDEX 00 00:00:00
+    INDEX 01 01:02:03
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &CueSheet{Files: []CueFile{
+		{Name: "My Game (Track 1).bin", Type: "BINARY", Tracks: []CueTrack{
+			{Number: 1, Mode: "MODE2/2352", Indexes: []CueIndex{{Number: 1}}},
+		}},
+		{Name: "My Game (Track 2).bin", Type: "BINARY", Tracks: []CueTrack{
+			{Number: 2, Mode: "AUDIO", Indexes: []CueIndex{{Number: 0}, {Number: 1, Frame: (60+2)*75 + 3}}},
+		}},
+	}}, sheet)
+
+	file, track, err := sheet.DataTrack()
+	require.NoError(t, err)
+	assert.Equal(t, "My Game (Track 1).bin", file.Name)
+	assert.Equal(t, 1, track.Number)
+	size, ok := track.SectorSize()
+	assert.True(t, ok)
+	assert.Equal(t, int64(2352), size)
+
+	for sheet, expected := range map[string]string{
+		"TRACK 01 AUDIO":                                      "line 1: TRACK before the first FILE",
+		"FILE a.bin BINARY\nINDEX 01 00:00:00":                "line 2: INDEX outside of a TRACK of the current FILE",
+		"FILE a.bin BINARY\nTRACK 1 AUDIO\nINDEX 01 00:60:00": `line 3: invalid position "00:60:00", expected MM:SS:FF`,
+		"FILE a.bin": "line 1: FILE expects a name and a type",
+	} {
+		_, err := ParseCueSheet(strings.NewReader(sheet))
+		assert.EqualError(t, err, expected, sheet)
+	}
+}
+
+func TestOpenCueSheet(t *testing.T) {
+	image := rawTestImage(t)
+	dir := t.TempDir()
+
+	// data as the first track, with a pregap in the file
+	pregap := make([]byte, 150*rawSectorSize)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "game data.bin"), append(pregap, toRawSectors(image, 2)...), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "game.cue"), []byte(`FILE "game data.bin" BINARY
+  TRACK 01 MODE2/2352
+    INDEX 00 00:00:00
+    INDEX 01 00:02:00
+`), 0644))
+	img, err := OpenCueSheet(filepath.Join(dir, "game.cue"), ReaderOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, loremIpsum, readPath(t, img, "/docs/lorem.txt"))
+	require.NoError(t, img.Close())
+
+	// data following an audio track in the same file, as a second session which records the locations on the medium
+	empty, err := NewWriter()
+	require.NoError(t, err)
+	defer empty.Cleanup() // nolint: errcheck
+	var first bytes.Buffer
+	require.NoError(t, empty.WriteTo(&first, "FIRST"))
+	previous, err := OpenImage(bytes.NewReader(first.Bytes()))
+	require.NoError(t, err)
+	s, err := NewSessionWriter(previous, 300)
+	require.NoError(t, err)
+	defer s.Cleanup() // nolint: errcheck
+	require.NoError(t, s.AddFile(strings.NewReader(loremIpsum), "lorem.txt"))
+	var second bytes.Buffer
+	require.NoError(t, s.WriteTo(&second, "SECOND"))
+
+	audio := make([]byte, 300*rawSectorSize)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "extra.bin"), append(audio, toRawSectors(second.Bytes(), 1)...), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "extra.cue"), []byte(`FILE "extra.bin" BINARY
+  TRACK 01 AUDIO
+    INDEX 01 00:00:00
+  TRACK 02 MODE1/2352
+    INDEX 01 00:04:00
+`), 0644))
+	img, err = OpenCueSheet(filepath.Join(dir, "extra.cue"), ReaderOptions{})
+	require.NoError(t, err)
+	defer img.Close() // nolint: errcheck
+	assert.Equal(t, loremIpsum, readPath(t, img, "/lorem.txt"))
+
+	_, err = OpenCueSheet(filepath.Join(dir, "missing.cue"), ReaderOptions{})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,244 @@
+package iso9660
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	defaultDataBufferSize = 4 * 1024 * 1024
+	defaultDataBuffers    = 4
+)
+
+// errDataAborted stops the readahead goroutine once the writes have failed
+var errDataAborted = errors.New("writing the file data was aborted")
+
+// dataBatcher collects the data of consecutive extents in a large buffer, so that small files
+// are written together and large ones in chunks of the buffer's size
+type dataBatcher struct {
+	buffer []byte
+	// flush hands over a full buffer and returns an empty one to continue with
+	flush func(full []byte) ([]byte, error)
+	// check is called before every file and flush, and stops the batching with its error
+	check func() error
+	// file is the file being appended, whose path is only built when it is reported
+	file *layoutNode
+}
+
+// path returns the path of the file being appended, or an empty string between the files
+func (b *dataBatcher) path() string {
+	if b.file == nil {
+		return ""
+	}
+	return b.file.entry.path()
+}
+
+// space returns the unused part of the buffer, flushing it first if it is full
+func (b *dataBatcher) space() ([]byte, error) {
+	if len(b.buffer) == cap(b.buffer) {
+		if b.check != nil {
+			if err := b.check(); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if b.buffer, err = b.flush(b.buffer); err != nil {
+			return nil, err
+		}
+	}
+	return b.buffer[len(b.buffer):cap(b.buffer)], nil
+}
+
+// zero appends n zero bytes
+func (b *dataBatcher) zero(n int64) error {
+	for n > 0 {
+		space, err := b.space()
+		if err != nil {
+			return err
+		}
+		if int64(len(space)) > n {
+			space = space[:n]
+		}
+		for i := range space {
+			space[i] = 0
+		}
+		b.buffer = b.buffer[:len(b.buffer)+len(space)]
+		n -= int64(len(space))
+	}
+	return nil
+}
+
+// readFrom appends exactly n bytes read from r.
+// The errors of flush are returned as they are, those of r are wrapped in a readError.
+func (b *dataBatcher) readFrom(r io.Reader, n int64) error {
+	for n > 0 {
+		space, err := b.space()
+		if err != nil {
+			return err
+		}
+		if int64(len(space)) > n {
+			space = space[:n]
+		}
+		if _, err := io.ReadFull(r, space); err != nil {
+			return readError{err}
+		}
+		b.buffer = b.buffer[:len(b.buffer)+len(space)]
+		n -= int64(len(space))
+	}
+	return nil
+}
+
+// readError marks a failure to read a file, which is reported along with its path
+type readError struct {
+	err error
+}
+
+func (e readError) Error() string { return e.err.Error() }
+
+func (e readError) Unwrap() error { return e.err }
+
+// fillData appends the extents of the files, which are ordered by their locations, and the zeroes in the gaps
+// between them, starting at the sector position. It returns the position after the last extent.
+func fillData(b *dataBatcher, files []*layoutNode, position uint32) (uint32, error) {
+	for _, file := range files {
+		b.file = file
+		if b.check != nil {
+			if err := b.check(); err != nil {
+				return 0, err
+			}
+		}
+		if file.location > position {
+			if err := b.zero(int64(file.location-position) * int64(sectorSize)); err != nil {
+				return 0, err
+			}
+			position = file.location
+		}
+		if err := fillFile(b, file.source); err != nil {
+			var read readError
+			if errors.As(err, &read) {
+				return 0, fmt.Errorf("%s: %w", b.path(), read.err)
+			}
+			return 0, err
+		}
+		position += sizeToSectors(file.source.Size())
+	}
+	return position, nil
+}
+
+// fillFile appends the file's contents, padded to whole sectors
+func fillFile(b *dataBatcher, source stagedSource) error {
+	size := source.Size()
+
+	f, err := source.Open()
+	if err != nil {
+		return readError{err}
+	}
+
+	if err := b.readFrom(f, size); err != nil {
+		_ = f.Close()
+		return err
+	}
+	// closing a streamed file checks that it holds no more than its declared size
+	if err := f.Close(); err != nil {
+		return readError{err}
+	}
+	return b.zero(int64(sizeToSectors(size))*int64(sectorSize) - size)
+}
+
+// writeData writes the extents of the files and the zeroes between them up to the sector end.
+// Without readahead, the files are read into a single buffer, which is written whenever it is full.
+// With readahead, a goroutine reads the files into a pool of buffers while the full ones are being written.
+func (wc *writeContext) writeData(w io.Writer, files []*layoutNode, position, end uint32) error {
+	fill := func(b *dataBatcher) error {
+		b.check = func() error {
+			// the path is only built for the error
+			if wc.ctx == nil || wc.ctx.Err() == nil {
+				return nil
+			}
+			return wc.interrupted(PhaseFileData, b.path())
+		}
+		position, err := fillData(b, files, position)
+		if err != nil {
+			return err
+		}
+		if end > position {
+			b.file = nil
+			if err := b.zero(int64(end-position) * int64(sectorSize)); err != nil {
+				return err
+			}
+		}
+		if len(b.buffer) > 0 {
+			_, err = b.flush(b.buffer)
+		}
+		return err
+	}
+
+	if !wc.readahead {
+		b := &dataBatcher{
+			buffer: make([]byte, 0, wc.dataBufferSize),
+			flush: func(full []byte) ([]byte, error) {
+				_, err := w.Write(full)
+				return full[:0], err
+			},
+		}
+		return fill(b)
+	}
+
+	free := make(chan []byte, wc.dataBuffers)
+	for i := 0; i < wc.dataBuffers; i++ {
+		free <- make([]byte, 0, wc.dataBufferSize)
+	}
+	full := make(chan []byte, wc.dataBuffers)
+	aborted := make(chan struct{})
+
+	var done <-chan struct{}
+	if wc.ctx != nil {
+		done = wc.ctx.Done()
+	}
+
+	var readErr error
+	go func() {
+		defer close(full)
+		b := &dataBatcher{buffer: <-free}
+		b.flush = func(buffer []byte) ([]byte, error) {
+			select {
+			case full <- buffer:
+			case <-aborted:
+				return nil, errDataAborted
+			case <-done:
+				return nil, wc.interrupted(PhaseFileData, b.path())
+			}
+			select {
+			case next := <-free:
+				return next[:0], nil
+			case <-aborted:
+				return nil, errDataAborted
+			case <-done:
+				return nil, wc.interrupted(PhaseFileData, b.path())
+			}
+		}
+		readErr = fill(b)
+	}()
+
+	var writeErr error
+	for buffer := range full {
+		if writeErr == nil {
+			if writeErr = wc.interrupted(PhaseFileData, ""); writeErr == nil {
+				_, writeErr = w.Write(buffer)
+			}
+			if writeErr != nil {
+				close(aborted)
+			}
+		}
+		// there are never more buffers than free can hold
+		free <- buffer
+	}
+
+	var interrupted *IncompleteImageError
+	if writeErr != nil && !(errors.As(writeErr, &interrupted) && errors.As(readErr, &interrupted)) {
+		return writeErr
+	}
+	// the reads tell which file was in progress
+	return readErr
+}
@@ -0,0 +1,197 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dataTree creates local files whose sizes fall on and around the boundaries of sectors and small buffers,
+// and returns their checksums by path within the image
+func dataTree(t testing.TB, dir string) map[string][sha256.Size]byte {
+	sums := make(map[string][sha256.Size]byte)
+	sizes := []int{0, 1, 100, 2047, 2048, 2049, 3 * 2048, 4*2048 + 1, 10000, 64 * 1024, 64*1024 + 3, 300000}
+	for i, size := range sizes {
+		data := make([]byte, size)
+		for j := range data {
+			data[j] = byte(i*31 + j*7 + j/2048)
+		}
+		name := fmt.Sprintf("file%02d.bin", i)
+		sub := filepath.Join(dir, fmt.Sprintf("dir%d", i%3))
+		require.NoError(t, os.MkdirAll(sub, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sub, name), data, 0644))
+		sums[fmt.Sprintf("/tree/dir%d/%s", i%3, name)] = sha256.Sum256(data)
+	}
+	return sums
+}
+
+func TestWriterDataBuffers(t *testing.T) {
+	origin := t.TempDir()
+	sums := dataTree(t, origin)
+
+	write := func(opts WriterOptions) []byte {
+		opts.EnableRockRidge = true
+		w, err := NewWriterWithOptions(opts)
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+		require.NoError(t, w.AddLocalDirectory(origin, "tree"))
+		// a gap in front of a file
+		require.NoError(t, w.SetAlignment("tree/dir1/file10.bin", 64))
+
+		var buf bytes.Buffer
+		require.NoError(t, w.WriteTo(&buf, "buffers"))
+		return buf.Bytes()
+	}
+
+	fixed := WriterOptions{FixedTimestamp: time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)}
+	expected := write(fixed)
+
+	img, err := OpenImage(bytes.NewReader(expected))
+	require.NoError(t, err)
+	files := filesByPath(t, img)
+	for name, sum := range sums {
+		require.Contains(t, files, name)
+		h := sha256.New()
+		_, err := io.Copy(h, files[name].Reader())
+		require.NoError(t, err)
+		assert.Equal(t, sum[:], h.Sum(nil), name)
+	}
+
+	for _, opts := range []WriterOptions{
+		{DataBufferSize: 1},
+		{DataBufferSize: 3 * 2048},
+		{DataBufferSize: 10000},
+		{DataBufferSize: 3 * 2048, Readahead: true},
+		{DataBufferSize: 3 * 2048, Readahead: true, DataBuffers: 1},
+		{Readahead: true},
+	} {
+		opts.FixedTimestamp = fixed.FixedTimestamp
+		assert.True(t, bytes.Equal(expected, write(opts)), "%+v", opts)
+	}
+}
+
+// failingWriter fails once more than limit bytes have been written
+type failingWriter struct {
+	limit   int
+	written int
+	writes  int
+}
+
+var errWriterFull = errors.New("writer full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.written+len(p) > w.limit {
+		return 0, errWriterFull
+	}
+	w.written += len(p)
+	return len(p), nil
+}
+
+func TestWriterDataErrors(t *testing.T) {
+	origin := t.TempDir()
+	dataTree(t, origin)
+
+	for _, readahead := range []bool{false, true} {
+		w, err := NewWriterWithOptions(WriterOptions{DataBufferSize: 4096, DataBuffers: 2, Readahead: readahead})
+		require.NoError(t, err)
+		require.NoError(t, w.AddLocalDirectory(origin, "tree"))
+
+		// the writes fail in the middle of the data
+		var buf bytes.Buffer
+		require.NoError(t, w.WriteTo(&buf, "errors"))
+		err = w.WriteTo(&failingWriter{limit: buf.Len() / 2}, "errors")
+		assert.ErrorIs(t, err, errWriterFull)
+
+		// the file shrank since it was staged
+		require.NoError(t, os.Truncate(filepath.Join(origin, "dir2", "file11.bin"), 1000))
+		err = w.WriteTo(io.Discard, "errors")
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Contains(t, err.Error(), "/tree/dir2/file11.bin")
+		require.NoError(t, os.Truncate(filepath.Join(origin, "dir2", "file11.bin"), 300000))
+
+		require.NoError(t, w.Cleanup())
+	}
+}
+
+func TestWriterDataLargeWrites(t *testing.T) {
+	origin := t.TempDir()
+	dataTree(t, origin)
+
+	writes := func(opts WriterOptions) *failingWriter {
+		w, err := NewWriterWithOptions(opts)
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+		require.NoError(t, w.AddLocalDirectory(origin, "tree"))
+
+		counter := &failingWriter{limit: 1 << 30}
+		require.NoError(t, w.WriteTo(counter, "large"))
+		return counter
+	}
+
+	// the small files are written together, rather than sector by sector
+	sectors := writes(WriterOptions{DataBufferSize: 1})
+	buffered := writes(WriterOptions{DataBufferSize: 64 * 1024})
+	assert.Equal(t, sectors.written, buffered.written)
+	dataSectors := 0
+	for _, size := range []int{0, 1, 100, 2047, 2048, 2049, 3 * 2048, 4*2048 + 1, 10000, 64 * 1024, 64*1024 + 3, 300000} {
+		dataSectors += int(fileLengthToSectors(uint32(size)))
+	}
+	// the metadata is written the same way, the data in one write per sector or in chunks of 32 sectors
+	assert.Equal(t, dataSectors-(dataSectors+31)/32, sectors.writes-buffered.writes)
+}
+
+func BenchmarkWriterLocalTree(b *testing.B) {
+	if testing.Short() {
+		b.Skip("writes hundreds of megabytes")
+	}
+
+	origin := b.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 8*1024)
+	var total int64
+	for i := 0; i < 2000; i++ {
+		dir := filepath.Join(origin, fmt.Sprintf("dir%d", i%20))
+		require.NoError(b, os.MkdirAll(dir, 0755))
+		size := (i%8 + 1) * len(content) / 8
+		require.NoError(b, os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d", i)), content[:size], 0644))
+		total += int64(size)
+	}
+	output := filepath.Join(b.TempDir(), "image.iso")
+
+	for _, bench := range []struct {
+		name string
+		opts WriterOptions
+	}{
+		{"sector", WriterOptions{DataBufferSize: 2048}},
+		{"buffered", WriterOptions{}},
+		{"readahead", WriterOptions{Readahead: true}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			w, err := NewWriterWithOptions(bench.opts)
+			require.NoError(b, err)
+			defer w.Cleanup() // nolint: errcheck
+			require.NoError(b, w.AddLocalDirectory(origin, "tree"))
+
+			b.SetBytes(total)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f, err := os.Create(output)
+				require.NoError(b, err)
+				require.NoError(b, w.WriteTo(f, "bench"))
+				require.NoError(b, f.Close())
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package iso9660
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileOwner returns the user and group IDs of a local file
+func fileOwner(info os.FileInfo) (uid, gid uint32, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Uid, st.Gid, true
+}
+
+// fileTimes returns the access and attribute change times of a local file
+func fileTimes(info os.FileInfo) (access, change time.Time, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return time.Unix(st.Atim.Unix()), time.Unix(st.Ctim.Unix()), true
+}
+
+// fileIdentity returns the device and inode numbers of a local file, ok is false unless it has other hard links
+func fileIdentity(info os.FileInfo) (id localFileID, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return localFileID{}, false
+	}
+	return localFileID{dev: uint64(st.Dev), ino: st.Ino}, true // nolint: unconvert
+}
+
+// deviceNumber returns the major and minor numbers of a local device node
+func deviceNumber(info os.FileInfo) (major, minor uint32, err error) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, fmt.Errorf("no stat information")
+	}
+
+	// the encoding of dev_t used by glibc, see gnu_dev_major and gnu_dev_minor
+	dev := uint64(st.Rdev) // nolint: unconvert
+	major = uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+	minor = uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+	return major, minor, nil
+}
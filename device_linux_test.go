@@ -0,0 +1,144 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterAddLocalDirectorySpecialFiles(t *testing.T) {
+	origin := t.TempDir()
+	require.NoError(t, syscall.Mkfifo(path.Join(origin, "fifo"), 0640))
+
+	withDevice := os.Getuid() == 0
+	if withDevice {
+		// 259:65536 in the glibc encoding
+		dev := (259&0xfff)<<8 | (65536 & 0xff) | (65536&^0xff)<<12
+		require.NoError(t, syscall.Mknod(path.Join(origin, "disk"), syscall.S_IFBLK|0600, dev))
+	}
+
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	assert.ErrorContains(t, w.AddLocalDirectory(origin, "root"), "is a special file")
+
+	w.SetPreserveDeviceNodes(true)
+	require.NoError(t, w.AddLocalDirectory(origin, "root"))
+
+	fifo := w.lookup("root/fifo")
+	require.NotNil(t, fifo)
+	assert.Equal(t, fs.ModeNamedPipe|0640, fifo.mode)
+
+	if withDevice {
+		disk := w.lookup("root/disk")
+		require.NotNil(t, disk)
+		assert.Equal(t, fs.ModeDevice|0600, disk.mode)
+		assert.Equal(t, [2]uint32{259, 65536}, [2]uint32{disk.info().devMajor, disk.info().devMinor})
+	}
+}
+
+func TestWriterPreserveOwnership(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of files requires root")
+	}
+
+	origin := t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(origin, "dir"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(origin, "dir", "file"), []byte("owned"), 0644))
+	require.NoError(t, os.Chown(path.Join(origin, "dir"), 1234, 5678))
+	require.NoError(t, os.Chown(path.Join(origin, "dir", "file"), 4321, 8765))
+
+	for _, testcase := range []struct {
+		preserve  bool
+		normalize bool
+		owners    map[string][2]uint32
+	}{
+		{preserve: true, owners: map[string][2]uint32{"/root/dir": {1234, 5678}, "/root/dir/file": {4321, 8765}}},
+		{preserve: false, owners: map[string][2]uint32{"/root/dir": {0, 0}, "/root/dir/file": {0, 0}}},
+		{preserve: true, normalize: true, owners: map[string][2]uint32{"/root/dir": {0, 0}, "/root/dir/file": {0, 0}}},
+	} {
+		w, err := NewWriterWithOptions(WriterOptions{
+			EnableRockRidge:        true,
+			PreserveOwnership:      testcase.preserve,
+			NormalizeLocalMetadata: testcase.normalize,
+		})
+		require.NoError(t, err)
+		require.NoError(t, w.AddLocalDirectory(origin, "root"))
+
+		files := filesByPath(t, remaster(t, w))
+		for p, owner := range testcase.owners {
+			px, err := files[p].de.SystemUseEntries.getPosixEntry()
+			require.NoError(t, err)
+			assert.Equal(t, owner, [2]uint32{px.uid, px.gid}, p)
+		}
+		require.NoError(t, w.Cleanup())
+	}
+}
+
+func TestWriterPreserveTimes(t *testing.T) {
+	origin := t.TempDir()
+	file := path.Join(origin, "file")
+	require.NoError(t, os.WriteFile(file, []byte("touched"), 0644))
+	accessed := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	modified := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	require.NoError(t, os.Chtimes(file, accessed, modified))
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	_, changed, ok := fileTimes(info)
+	require.True(t, ok)
+
+	for _, preserve := range []bool{true, false} {
+		w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, PreserveTimes: preserve})
+		require.NoError(t, err)
+		require.NoError(t, w.AddLocalDirectory(origin, "root"))
+
+		rec, err := filesByPath(t, remaster(t, w))["/root/file"].de.SystemUseEntries.GetTimestamps()
+		require.NoError(t, err)
+		assert.True(t, modified.Equal(rec.Modification))
+		if preserve {
+			assert.True(t, accessed.Equal(rec.Access))
+			// directory records have a resolution of one second
+			assert.True(t, changed.Truncate(time.Second).Equal(rec.Change))
+		} else {
+			assert.True(t, rec.Access.IsZero())
+			assert.True(t, rec.Change.IsZero())
+		}
+		require.NoError(t, w.Cleanup())
+	}
+}
+
+func TestWriterAddLocalDirectoryHardLinks(t *testing.T) {
+	origin := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(origin, "a"), []byte("linked"), 0644))
+	require.NoError(t, os.Link(path.Join(origin, "a"), path.Join(origin, "b")))
+	require.NoError(t, os.WriteFile(path.Join(origin, "c"), []byte("linked"), 0644))
+
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddLocalDirectory(origin, "root"))
+
+	files := filesByPath(t, remaster(t, w))
+	a, b, c := files["/root/a"], files["/root/b"], files["/root/c"]
+	assert.Equal(t, uint32(2), a.Links())
+	assert.Equal(t, uint32(2), b.Links())
+	assert.Equal(t, uint32(1), c.Links())
+
+	aID, ok := a.HardLinkID()
+	require.True(t, ok)
+	bID, ok := b.HardLinkID()
+	require.True(t, ok)
+	assert.Equal(t, aID, bID)
+	_, ok = c.HardLinkID()
+	assert.False(t, ok)
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package iso9660
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// fileOwner returns the user and group IDs of a local file
+func fileOwner(info os.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
+
+// fileTimes returns the access and attribute change times of a local file
+func fileTimes(info os.FileInfo) (access, change time.Time, ok bool) {
+	return time.Time{}, time.Time{}, false
+}
+
+// fileIdentity returns the device and inode numbers of a local file, ok is false unless it has other hard links
+func fileIdentity(info os.FileInfo) (id localFileID, ok bool) {
+	return localFileID{}, false
+}
+
+// deviceNumber returns the major and minor numbers of a local device node
+func deviceNumber(info os.FileInfo) (major, minor uint32, err error) {
+	return 0, 0, errors.New("device numbers can only be read on Linux")
+}
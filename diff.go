@@ -0,0 +1,487 @@
+package iso9660
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DiffKind tells whether an entry was added, removed or modified
+type DiffKind int
+
+const (
+	// DiffAdded marks an entry which is only in the second image
+	DiffAdded DiffKind = iota
+	// DiffRemoved marks an entry which is only in the first image
+	DiffRemoved
+	// DiffModified marks an entry which is in both images, but differs
+	DiffModified
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	default:
+		return "modified"
+	}
+}
+
+// DiffChange is a set of flags telling what differs about a modified entry
+type DiffChange uint
+
+const (
+	// DiffType means that the entry changed its type, e.g. from a file to a directory.
+	// Nothing else is compared then.
+	DiffType DiffChange = 1 << iota
+	// DiffContent means that the data of a file differs
+	DiffContent
+	// DiffMode means that the permission bits differ
+	DiffMode
+	// DiffOwner means that the user or group ID differs
+	DiffOwner
+	// DiffModTime means that the modification time differs
+	DiffModTime
+	// DiffSymlinkTarget means that the target of a symbolic link differs
+	DiffSymlinkTarget
+	// DiffBootEntry means that an entry of the boot catalog differs in anything but the location of its image,
+	// see DiffBoot
+	DiffBootEntry
+)
+
+func (c DiffChange) String() string {
+	var names []string
+	for _, change := range []struct {
+		flag DiffChange
+		name string
+	}{
+		{DiffType, "type"},
+		{DiffContent, "content"},
+		{DiffMode, "mode"},
+		{DiffOwner, "owner"},
+		{DiffModTime, "mtime"},
+		{DiffSymlinkTarget, "symlink target"},
+		{DiffBootEntry, "boot entry"},
+	} {
+		if c&change.flag != 0 {
+			names = append(names, change.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// DiffSide describes an entry as it is in one of the images
+type DiffSide struct {
+	Mode          fs.FileMode
+	Size          int64
+	UID           uint32
+	GID           uint32
+	ModTime       time.Time
+	SymlinkTarget string
+	// Hash is the digest of the data, set only if the files had to be hashed to compare them
+	Hash []byte
+}
+
+// DiffEntry is a path which differs between two images
+type DiffEntry struct {
+	Path string
+	Kind DiffKind
+	// Changes tells what differs about a modified entry
+	Changes DiffChange
+	// Old is nil for an added entry, New is nil for a removed one
+	Old *DiffSide
+	New *DiffSide
+}
+
+func (e DiffEntry) String() string {
+	switch e.Kind {
+	case DiffAdded:
+		return "added " + e.Path
+	case DiffRemoved:
+		return "removed " + e.Path
+	}
+
+	var details []string
+	if e.Changes&DiffType != 0 {
+		details = append(details, fmt.Sprintf("type %s -> %s", typeName(e.Old.Mode), typeName(e.New.Mode)))
+	}
+	if e.Changes&DiffContent != 0 {
+		if e.Old.Size != e.New.Size {
+			details = append(details, fmt.Sprintf("size %d -> %d", e.Old.Size, e.New.Size))
+		} else {
+			details = append(details, fmt.Sprintf("content %x -> %x", e.Old.Hash, e.New.Hash))
+		}
+	}
+	if e.Changes&DiffMode != 0 {
+		details = append(details, fmt.Sprintf("mode %s -> %s", e.Old.Mode, e.New.Mode))
+	}
+	if e.Changes&DiffOwner != 0 {
+		details = append(details, fmt.Sprintf("owner %d:%d -> %d:%d", e.Old.UID, e.Old.GID, e.New.UID, e.New.GID))
+	}
+	if e.Changes&DiffModTime != 0 {
+		details = append(details, fmt.Sprintf("mtime %s -> %s", e.Old.ModTime.Format(time.RFC3339), e.New.ModTime.Format(time.RFC3339)))
+	}
+	if e.Changes&DiffSymlinkTarget != 0 {
+		details = append(details, fmt.Sprintf("symlink target %q -> %q", e.Old.SymlinkTarget, e.New.SymlinkTarget))
+	}
+	return fmt.Sprintf("modified %s: %s", e.Path, strings.Join(details, ", "))
+}
+
+// typeName names the type of an entry for DiffEntry.String
+func typeName(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	case mode&fs.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	default:
+		return "file"
+	}
+}
+
+// DiffOption configures Diff
+type DiffOption func(*diffOptions)
+
+type diffOptions struct {
+	ignoreModTimes bool
+	newHash        func() hash.Hash
+}
+
+// WithIgnoreModTimes makes Diff ignore differences of the modification times
+func WithIgnoreModTimes() DiffOption {
+	return func(o *diffOptions) {
+		o.ignoreModTimes = true
+	}
+}
+
+// WithDiffHash sets the hash Diff compares the data of files of the same size with, SHA-256 by default
+func WithDiffHash(newHash func() hash.Hash) DiffOption {
+	return func(o *diffOptions) {
+		o.newHash = newHash
+	}
+}
+
+// Diff compares the directory hierarchies of two images and returns the paths which differ, ordered by path.
+// The paths are made of the names of the entries, as returned by File.Name. If a directory is added or removed,
+// so are all the entries in it. Files of the same size are hashed to compare their data, which is streamed.
+// The boot catalogs are compared by DiffBoot.
+func Diff(a, b *Image, opts ...DiffOption) ([]DiffEntry, error) {
+	o := diffOptions{newHash: sha256.New}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	rootA, err := a.RootDir()
+	if err != nil {
+		return nil, err
+	}
+	rootB, err := b.RootDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []DiffEntry
+	if err := o.diffDirectories(&entries, "/", rootA, rootB); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// diffChildren returns the children of a directory by name, along with the sorted names
+func diffChildren(dir *File, dirPath string) (map[string]*File, []string, error) {
+	// hidden entries are compared regardless of the reader options
+	children, err := dir.getChildren(true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading directory %s: %w", dirPath, err)
+	}
+
+	byName := make(map[string]*File, len(children))
+	names := make([]string, 0, len(children))
+	for _, c := range children {
+		name := c.Name()
+		if _, exists := byName[name]; exists {
+			return nil, nil, fmt.Errorf("directory %s contains %q more than once", dirPath, name)
+		}
+		byName[name] = c
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return byName, names, nil
+}
+
+func (o *diffOptions) diffDirectories(entries *[]DiffEntry, dirPath string, a, b *File) error {
+	childrenA, namesA, err := diffChildren(a, dirPath)
+	if err != nil {
+		return err
+	}
+	childrenB, namesB, err := diffChildren(b, dirPath)
+	if err != nil {
+		return err
+	}
+
+	for len(namesA) > 0 || len(namesB) > 0 {
+		switch {
+		case len(namesB) == 0 || len(namesA) > 0 && namesA[0] < namesB[0]:
+			if err := o.diffOneSided(entries, DiffRemoved, path.Join(dirPath, namesA[0]), childrenA[namesA[0]]); err != nil {
+				return err
+			}
+			namesA = namesA[1:]
+		case len(namesA) == 0 || namesB[0] < namesA[0]:
+			if err := o.diffOneSided(entries, DiffAdded, path.Join(dirPath, namesB[0]), childrenB[namesB[0]]); err != nil {
+				return err
+			}
+			namesB = namesB[1:]
+		default:
+			if err := o.diffFiles(entries, path.Join(dirPath, namesA[0]), childrenA[namesA[0]], childrenB[namesB[0]]); err != nil {
+				return err
+			}
+			namesA, namesB = namesA[1:], namesB[1:]
+		}
+	}
+	return nil
+}
+
+// diffOneSided reports an entry which is only in one of the images, along with all the entries in it
+func (o *diffOptions) diffOneSided(entries *[]DiffEntry, kind DiffKind, filePath string, f *File) error {
+	side := diffSide(f)
+	entry := DiffEntry{Path: filePath, Kind: kind}
+	if kind == DiffAdded {
+		entry.New = side
+	} else {
+		entry.Old = side
+	}
+	*entries = append(*entries, entry)
+
+	if !side.Mode.IsDir() {
+		return nil
+	}
+	return o.diffSubtree(entries, kind, filePath, f)
+}
+
+// diffSubtree reports all the entries in a directory which is only in one of the images
+func (o *diffOptions) diffSubtree(entries *[]DiffEntry, kind DiffKind, dirPath string, dir *File) error {
+	children, names, err := diffChildren(dir, dirPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := o.diffOneSided(entries, kind, path.Join(dirPath, name), children[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffFiles compares an entry which is in both images
+func (o *diffOptions) diffFiles(entries *[]DiffEntry, filePath string, a, b *File) error {
+	before, after := diffSide(a), diffSide(b)
+	var changes DiffChange
+
+	if before.Mode&fs.ModeType != after.Mode&fs.ModeType {
+		*entries = append(*entries, DiffEntry{Path: filePath, Kind: DiffModified, Changes: DiffType, Old: before, New: after})
+		// the entries of a directory which became something else are gone
+		if before.Mode.IsDir() {
+			return o.diffSubtree(entries, DiffRemoved, filePath, a)
+		}
+		if after.Mode.IsDir() {
+			return o.diffSubtree(entries, DiffAdded, filePath, b)
+		}
+		return nil
+	}
+
+	if before.Mode.Perm() != after.Mode.Perm() || before.Mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) != after.Mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) {
+		changes |= DiffMode
+	}
+	if before.UID != after.UID || before.GID != after.GID {
+		changes |= DiffOwner
+	}
+	if !o.ignoreModTimes && !before.ModTime.Equal(after.ModTime) {
+		changes |= DiffModTime
+	}
+	if before.SymlinkTarget != after.SymlinkTarget {
+		changes |= DiffSymlinkTarget
+	}
+
+	if before.Mode.IsRegular() {
+		if before.Size != after.Size {
+			changes |= DiffContent
+		} else if before.Size > 0 {
+			var err error
+			if before.Hash, err = o.hashFile(a, filePath); err != nil {
+				return err
+			}
+			if after.Hash, err = o.hashFile(b, filePath); err != nil {
+				return err
+			}
+			if !bytes.Equal(before.Hash, after.Hash) {
+				changes |= DiffContent
+			}
+		}
+	}
+
+	if changes != 0 {
+		*entries = append(*entries, DiffEntry{Path: filePath, Kind: DiffModified, Changes: changes, Old: before, New: after})
+	}
+	if before.Mode.IsDir() {
+		return o.diffDirectories(entries, filePath, a, b)
+	}
+	return nil
+}
+
+func (o *diffOptions) hashFile(f *File, filePath string) ([]byte, error) {
+	h := o.newHash()
+	if _, err := io.Copy(h, f.Reader()); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filePath, err)
+	}
+	return h.Sum(nil), nil
+}
+
+// diffSide collects the attributes of an entry which Diff compares
+func diffSide(f *File) *DiffSide {
+	side := &DiffSide{
+		Mode:    f.Mode(),
+		ModTime: f.ModTime(),
+	}
+	if side.Mode.IsRegular() {
+		side.Size = f.Size()
+	}
+	side.UID, side.GID, _ = f.Owner()
+	side.SymlinkTarget = f.SymlinkTarget()
+	return side
+}
+
+// BootDiffSide describes an entry of the boot catalog as it is in one of the images
+type BootDiffSide struct {
+	Entry BootEntry
+	// Path and Size are those of BootFile
+	Path string
+	Size int64
+	// Hash is the digest of the boot image, set only if the images had to be hashed to compare them
+	Hash []byte
+}
+
+// BootDiffEntry is an entry of the boot catalog which differs between two images
+type BootDiffEntry struct {
+	// Index is the position of the entry in the catalog, as returned by Image.BootEntries
+	Index int
+	Kind  DiffKind
+	// Changes tells what differs about a modified entry, DiffBootEntry and DiffContent
+	Changes DiffChange
+	// Old is nil for an added entry, New is nil for a removed one
+	Old *BootDiffSide
+	New *BootDiffSide
+}
+
+func (e BootDiffEntry) String() string {
+	switch e.Kind {
+	case DiffAdded:
+		return fmt.Sprintf("added boot entry %d", e.Index)
+	case DiffRemoved:
+		return fmt.Sprintf("removed boot entry %d", e.Index)
+	}
+
+	var details []string
+	if e.Changes&DiffBootEntry != 0 {
+		details = append(details, fmt.Sprintf("entry %+v -> %+v", e.Old.Entry, e.New.Entry))
+	}
+	if e.Changes&DiffContent != 0 {
+		if e.Old.Size != e.New.Size {
+			details = append(details, fmt.Sprintf("size %d -> %d", e.Old.Size, e.New.Size))
+		} else {
+			details = append(details, fmt.Sprintf("content %x -> %x", e.Old.Hash, e.New.Hash))
+		}
+	}
+	return fmt.Sprintf("modified boot entry %d: %s", e.Index, strings.Join(details, ", "))
+}
+
+// DiffBoot compares the El Torito boot catalogs of two images entry by entry, in the order of Image.BootEntries.
+// The locations of the boot images aren't compared, as they move whenever an image is rebuilt, but their data is,
+// sized as by Image.BootImages. WithDiffHash sets the hash it is compared with.
+func DiffBoot(a, b *Image, opts ...DiffOption) ([]BootDiffEntry, error) {
+	o := diffOptions{newHash: sha256.New}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	imagesA, err := a.BootImages()
+	if err != nil {
+		return nil, err
+	}
+	imagesB, err := b.BootImages()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []BootDiffEntry
+	for n := 0; n < len(imagesA) || n < len(imagesB); n++ {
+		switch {
+		case n >= len(imagesB):
+			entries = append(entries, BootDiffEntry{Index: n, Kind: DiffRemoved, Old: bootDiffSide(imagesA[n])})
+		case n >= len(imagesA):
+			entries = append(entries, BootDiffEntry{Index: n, Kind: DiffAdded, New: bootDiffSide(imagesB[n])})
+		default:
+			entry, err := o.diffBootImages(n, imagesA[n], imagesB[n])
+			if err != nil {
+				return nil, err
+			}
+			if entry != nil {
+				entries = append(entries, *entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// diffBootImages compares an entry which is in both boot catalogs, nil if it doesn't differ
+func (o *diffOptions) diffBootImages(index int, a, b BootFile) (*BootDiffEntry, error) {
+	before, after := bootDiffSide(a), bootDiffSide(b)
+	var changes DiffChange
+
+	entryA, entryB := a.Entry, b.Entry
+	entryA.LBA, entryB.LBA = 0, 0
+	if entryA != entryB {
+		changes |= DiffBootEntry
+	}
+	if before.Size != after.Size {
+		changes |= DiffContent
+	} else if before.Size > 0 {
+		for _, side := range []struct {
+			boot BootFile
+			hash *[]byte
+		}{{a, &before.Hash}, {b, &after.Hash}} {
+			h := o.newHash()
+			if _, err := io.Copy(h, side.boot.Reader); err != nil {
+				return nil, fmt.Errorf("reading the boot image of entry %d: %w", index, err)
+			}
+			*side.hash = h.Sum(nil)
+		}
+		if !bytes.Equal(before.Hash, after.Hash) {
+			changes |= DiffContent
+		}
+	}
+
+	if changes == 0 {
+		return nil, nil
+	}
+	return &BootDiffEntry{Index: index, Kind: DiffModified, Changes: changes, Old: before, New: after}, nil
+}
+
+// bootDiffSide collects the attributes of a boot image which DiffBoot compares
+func bootDiffSide(boot BootFile) *BootDiffSide {
+	return &BootDiffSide{Entry: boot.Entry, Path: boot.Path, Size: boot.Size}
+}
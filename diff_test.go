@@ -0,0 +1,178 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"crypto/md5"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildDiffImage(t *testing.T, entries []ManifestEntry) *Image {
+	var buf bytes.Buffer
+	require.NoError(t, BuildImage(&buf, Manifest{RockRidge: true, Entries: entries}))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	return img
+}
+
+func TestDiff(t *testing.T) {
+	before := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	after := before.Add(time.Hour)
+
+	a := buildDiffImage(t, []ManifestEntry{
+		{ISOPath: "same.txt", Content: []byte("same"), MTime: before},
+		{ISOPath: "content.txt", Content: []byte("aaaa"), MTime: before},
+		{ISOPath: "size.txt", Content: []byte("a"), MTime: before},
+		{ISOPath: "mode.sh", Content: []byte("#!"), Mode: 0644, MTime: before},
+		{ISOPath: "owner.txt", Content: []byte("o"), UID: 1, GID: 2, MTime: before},
+		{ISOPath: "touched.txt", Content: []byte("t"), MTime: before},
+		{ISOPath: "link", Type: ManifestSymlink, SymlinkTarget: "same.txt", MTime: before},
+		{ISOPath: "removed/file.txt", Content: []byte("r"), MTime: before},
+		{ISOPath: "removed", Type: ManifestDirectory, MTime: before},
+		{ISOPath: "retyped/child.txt", Content: []byte("c"), MTime: before},
+		{ISOPath: "retyped", Type: ManifestDirectory, MTime: before},
+	})
+	b := buildDiffImage(t, []ManifestEntry{
+		{ISOPath: "same.txt", Content: []byte("same"), MTime: before},
+		{ISOPath: "content.txt", Content: []byte("bbbb"), MTime: before},
+		{ISOPath: "size.txt", Content: []byte("bb"), MTime: before},
+		{ISOPath: "mode.sh", Content: []byte("#!"), Mode: 0755, MTime: before},
+		{ISOPath: "owner.txt", Content: []byte("o"), UID: 3, GID: 4, MTime: before},
+		{ISOPath: "touched.txt", Content: []byte("t"), MTime: after},
+		{ISOPath: "link", Type: ManifestSymlink, SymlinkTarget: "content.txt", MTime: before},
+		{ISOPath: "added.txt", Content: []byte("new"), MTime: before},
+		{ISOPath: "retyped", Content: []byte("now a file"), MTime: before},
+	})
+
+	entries, err := Diff(a, b, WithIgnoreModTimes())
+	require.NoError(t, err)
+
+	var described []string
+	for _, e := range entries {
+		described = append(described, e.String())
+	}
+	assert.Equal(t, []string{
+		"added /added.txt",
+		"modified /content.txt: content 61be55a8e2f6b4e172338bddf184d6dbee29c98853e0a0485ecee7f27b9af0b4 -> 81cc5b17018674b401b42f35ba07bb79e211239c23bffe658da1577e3e646877",
+		"modified /link: symlink target \"same.txt\" -> \"content.txt\"",
+		"modified /mode.sh: mode -rw-r--r-- -> -rwxr-xr-x",
+		"modified /owner.txt: owner 1:2 -> 3:4",
+		"removed /removed",
+		"removed /removed/file.txt",
+		"modified /retyped: type directory -> file",
+		"removed /retyped/child.txt",
+		"modified /size.txt: size 1 -> 2",
+	}, described)
+
+	assert.Equal(t, DiffModified, entries[1].Kind)
+	assert.Equal(t, DiffContent, entries[1].Changes)
+	assert.Nil(t, entries[0].Old)
+	assert.Equal(t, int64(3), entries[0].New.Size)
+	assert.Nil(t, entries[5].New)
+	assert.True(t, entries[5].Old.Mode.IsDir())
+
+	// the modification times are compared unless ignored
+	entries, err = Diff(a, b, WithDiffHash(md5.New))
+	require.NoError(t, err)
+	var touched *DiffEntry
+	for i := range entries {
+		if entries[i].Path == "/touched.txt" {
+			touched = &entries[i]
+		}
+		if entries[i].Path == "/content.txt" {
+			assert.Len(t, entries[i].Old.Hash, md5.Size)
+		}
+	}
+	require.NotNil(t, touched)
+	assert.Equal(t, DiffModTime, touched.Changes)
+	assert.Equal(t, "modified /touched.txt: mtime 2022-03-04T05:06:07Z -> 2022-03-04T06:06:07Z", touched.String())
+}
+
+func TestDiffIdentical(t *testing.T) {
+	f, err := os.Open("fixtures/test_rockridge.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	a, err := OpenImage(f)
+	require.NoError(t, err)
+	b, err := OpenImage(f)
+	require.NoError(t, err)
+
+	entries, err := Diff(a, b)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// the plain image has neither the names nor the attributes of the Rock Ridge one
+	g, err := os.Open("fixtures/test.iso")
+	require.NoError(t, err)
+	defer g.Close() // nolint: errcheck
+	plain, err := OpenImage(g)
+	require.NoError(t, err)
+
+	entries, err = Diff(plain, a)
+	require.NoError(t, err)
+	assert.NotEmpty(t, entries)
+}
+
+func TestDiffBoot(t *testing.T) {
+	build := func(files map[string][]byte, configure func(w *ImageWriter)) *Image {
+		w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+		for p, data := range files {
+			require.NoError(t, w.AddFile(bytes.NewReader(data), p))
+		}
+		configure(w)
+		return remaster(t, w)
+	}
+
+	loader := bytes.Repeat([]byte("isolinux"), 1000)
+	a := build(map[string][]byte{"isolinux.bin": loader, "efiboot.img": []byte("esp")}, func(w *ImageWriter) {
+		require.NoError(t, w.AddBootEntry(BootPlatformX86, BootNoEmulation, "isolinux.bin", WithBootLoadSize(4)))
+		require.NoError(t, w.AddBootEntry(BootPlatformEFI, BootNoEmulation, "efiboot.img"))
+	})
+	// the padding moves the boot images, which isn't a difference
+	b := build(map[string][]byte{"a-padding": make([]byte, 10000), "isolinux.bin": loader, "efiboot.img": []byte("ESP")}, func(w *ImageWriter) {
+		require.NoError(t, w.AddBootEntry(BootPlatformX86, BootNoEmulation, "isolinux.bin", WithBootLoadSize(4)))
+		require.NoError(t, w.AddBootEntry(BootPlatformEFI, BootNoEmulation, "efiboot.img"))
+	})
+	c := build(map[string][]byte{"isolinux.bin": loader}, func(w *ImageWriter) {
+		require.NoError(t, w.AddBootEntry(BootPlatformX86, BootNoEmulation, "isolinux.bin", WithBootLoadSize(8)))
+	})
+
+	entries, err := DiffBoot(a, a)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	entries, err = DiffBoot(a, b)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 1, entries[0].Index)
+	assert.Equal(t, DiffModified, entries[0].Kind)
+	assert.Equal(t, DiffContent, entries[0].Changes)
+	assert.Equal(t, "/efiboot.img", entries[0].New.Path)
+	assert.NotEqual(t, entries[0].Old.Entry.LBA, entries[0].New.Entry.LBA)
+
+	entries, err = DiffBoot(a, c)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, DiffBootEntry, entries[0].Changes)
+	assert.Equal(t, uint16(8), entries[0].New.Entry.SectorCount)
+	assert.Equal(t, DiffRemoved, entries[1].Kind)
+	assert.Nil(t, entries[1].New)
+	assert.Equal(t, "removed boot entry 1", entries[1].String())
+
+	// an image without a boot catalog has no entries
+	plain := build(map[string][]byte{"README": []byte("readme")}, func(*ImageWriter) {})
+	entries, err = DiffBoot(plain, c)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, DiffAdded, entries[0].Kind)
+}
@@ -0,0 +1,205 @@
+package iso9660
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ImageEditor modifies an existing image in place. Entries are added, replaced, removed or renamed
+// with the methods of the embedded ImageWriter, whose staging area is seeded like with NewWriterFromImage.
+//
+// Commit appends the data of the new files and the new directories, path tables and hierarchies
+// after the end of the image, leaving the data of the image's files where it is,
+// and then rewrites the volume descriptors to point to the new structures.
+// The data of removed and replaced files stays behind unreferenced, so the image only grows.
+// Until the volume descriptors are rewritten, the image still reads as before,
+// so a Commit which fails half-way leaves at most some unreferenced sectors at its end.
+//
+// The Primary Volume Descriptor is rewritten, and so are the first Joliet and Enhanced Volume Descriptors
+// if the image has them. Their hierarchies name the image's files as the primary one does,
+// like with NewWriterFromImage. Other descriptors, like the Boot Record, are left as they are.
+// Boot images must therefore not be replaced, and new boot entries cannot be added.
+type ImageEditor struct {
+	*ImageWriter
+
+	rw ReadWriterAt
+	// end is the first sector after the image, where the new data is appended
+	end uint32
+	// the sectors of the volume descriptors which are rewritten, 0 if the image doesn't have one
+	primarySector, jolietSector, enhancedSector uint32
+	// created is the creation time of the volume, which is kept
+	created VolumeDescriptorTimestamp
+	// inPlace maps the sources of the image's files to the first sectors of their data
+	inPlace   map[stagedSource]uint32
+	committed bool
+}
+
+// OpenImageEditor opens the image in rw for modification, see ImageEditor
+func OpenImageEditor(rw ReadWriterAt) (*ImageEditor, error) {
+	img, err := OpenImage(rw)
+	if err != nil {
+		return nil, err
+	}
+	if img.primary < 0 || img.volumeDescriptors[img.primary].Type() != volumeTypePrimary {
+		return nil, errors.New("the image has no Primary Volume Descriptor to update")
+	}
+	if size := img.LogicalBlockSize(); size != int64(sectorSize) {
+		return nil, fmt.Errorf("images with %d-byte logical blocks cannot be edited in place", size)
+	}
+
+	iw, err := newWriterFromImage(img)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &ImageEditor{
+		ImageWriter:   iw,
+		rw:            rw,
+		primarySector: img.descriptorSector(img.primary),
+		created:       img.volumeDescriptors[img.primary].Primary.VolumeCreationDateAndTime,
+		inPlace:       make(map[stagedSource]uint32),
+	}
+
+	// the data is appended after all the volumes and whatever else the image holds
+	e.end = sizeToSectors(img.size)
+	for index, vd := range img.volumeDescriptors {
+		if vd.Primary == nil {
+			continue
+		}
+		if size := uint32(vd.Primary.VolumeSpaceSize); size > e.end {
+			e.end = size
+		}
+		switch {
+		case vd.Type() == volumeTypeSupplementary && vd.joliet > 0 && vd.problem == "" && e.jolietSector == 0:
+			e.jolietSector = img.descriptorSector(index)
+		case vd.isEnhanced() && e.enhancedSector == 0:
+			e.enhancedSector = img.descriptorSector(index)
+		}
+	}
+	iw.joliet = e.jolietSector != 0
+	iw.enhancedVolume = e.enhancedSector != 0
+
+	collectInPlaceSources(iw.root, e.inPlace)
+	return e, nil
+}
+
+// collectInPlaceSources records the locations of the staged files read from contiguous extents of the image
+func collectInPlaceSources(entry *stagedEntry, inPlace map[stagedSource]uint32) {
+	if s, ok := entry.source.(*imageExtentSource); ok && s.location != 0 {
+		inPlace[s] = s.location
+	}
+	for _, c := range entry.children {
+		collectInPlaceSources(c, inPlace)
+	}
+}
+
+// Commit writes the changes to the image, see ImageEditor.
+// If volumeIdentifier is empty, the identifier from the VolumeMetadata is used.
+// The creation date of the volume is kept unless SetVolumeTimes gives another one.
+// The editor cannot be used to commit again afterwards, and the image should be opened anew to read it.
+func (e *ImageEditor) Commit(volumeIdentifier string) error {
+	return e.CommitContext(context.Background(), volumeIdentifier)
+}
+
+// CommitContext writes the changes like Commit, but stops when the context is done, see WriteToContext.
+// The image is left unchanged if the context is done before the volume descriptors are rewritten.
+func (e *ImageEditor) CommitContext(ctx context.Context, volumeIdentifier string) error {
+	if e.committed {
+		return errors.New("the changes have already been committed")
+	}
+	root, err := e.startWrite()
+	if err != nil {
+		return err
+	}
+	defer e.endWrite()
+
+	if e.implantMD5 {
+		return errors.New("the MD5 checksum of the whole image cannot be implanted in place")
+	}
+	if e.blockSize != 0 && e.blockSize != sectorSize {
+		return errors.New("the logical block size cannot be changed in place")
+	}
+	if len(e.bootEntries) > 0 {
+		return errors.New("boot entries cannot be added in place")
+	}
+	if e.hybrid != nil {
+		return errors.New("the system area isn't rewritten in place")
+	}
+	if e.joliet && e.jolietSector == 0 {
+		return errors.New("the image has no Joliet Volume Descriptor to update")
+	}
+	if e.enhancedVolume && e.enhancedSector == 0 {
+		return errors.New("the image has no Enhanced Volume Descriptor to update")
+	}
+
+	now := e.now()
+	wc := e.newWriteContext(now)
+	wc.ctx = ctx
+	wc.freeSectorPointer = e.end
+	wc.inPlace = e.inPlace
+	defer wc.removeTemporaryFiles()
+
+	pvd, descriptors, err := e.layoutImage(wc, root, volumeIdentifier, now)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = &offsetWriter{wa: e.rw, offset: int64(e.end) * int64(sectorSize)}
+	if e.progress != nil {
+		out = wc.newProgressReporter(e.progress, e.end, 1).pass(out, e.end)
+	}
+	w := &countingWriter{w: out}
+	if err = wc.writeAll(w); err != nil {
+		var interrupted *IncompleteImageError
+		if errors.As(err, &interrupted) {
+			interrupted.Written = w.written
+			return err
+		}
+		return fmt.Errorf("writing files: %w", err)
+	}
+
+	// the new structures are only referenced once they have been written
+	if err = wc.interrupted(PhaseVolumeDescriptor, ""); err != nil {
+		return err
+	}
+	for _, vd := range append([]volumeDescriptor{pvd}, descriptors...) {
+		if vd.Primary == nil {
+			continue
+		}
+		if e.volumeTimes.Creation.IsZero() {
+			vd.Primary.VolumeCreationDateAndTime = e.created
+		}
+		sector := e.primarySector
+		switch {
+		case vd.isEnhanced():
+			sector = e.enhancedSector
+		case vd.Type() == volumeTypeSupplementary:
+			sector = e.jolietSector
+		}
+
+		buffer, err := vd.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err = e.rw.WriteAt(buffer, int64(sector)*int64(sectorSize)); err != nil {
+			return fmt.Errorf("writing volume descriptor: %w", err)
+		}
+	}
+
+	e.committed = true
+	return nil
+}
+
+// offsetWriter writes sequentially to a WriterAt from the given offset
+type offsetWriter struct {
+	wa     io.WriterAt
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.wa.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,167 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// copyFixture copies a fixture image into a temporary file opened for reading and writing
+func copyFixture(t *testing.T, fixture string) *os.File {
+	data, err := os.ReadFile(fixture)
+	require.NoError(t, err)
+	imagePath := path.Join(t.TempDir(), path.Base(fixture))
+	require.NoError(t, os.WriteFile(imagePath, data, 0644))
+
+	f, err := os.OpenFile(imagePath, os.O_RDWR, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() }) // nolint: errcheck
+	return f
+}
+
+// fileLocation returns the first sector of a file's data
+func fileLocation(t *testing.T, img *Image, name string) int32 {
+	f, err := resolvePath(mustRoot(t, img), name, false, false)
+	require.NoError(t, err)
+	return f.de.ExtentLocation
+}
+
+func sha256String(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func mustRoot(t *testing.T, img *Image) *File {
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	return root
+}
+
+func TestImageEditor(t *testing.T) {
+	f := copyFixture(t, "fixtures/test_rockridge.iso")
+	before, err := OpenImage(f)
+	require.NoError(t, err)
+	expected := snapshotImage(t, before)
+	location := fileLocation(t, before, "dir2/dir3/data.bin")
+	info, err := f.Stat()
+	require.NoError(t, err)
+	size := info.Size()
+
+	e, err := OpenImageEditor(f)
+	require.NoError(t, err)
+	defer e.Cleanup() // nolint: errcheck
+	require.NoError(t, e.AddFile(strings.NewReader("new"), "dir1/new.txt"))
+	require.NoError(t, e.Remove("dir1/lorem_ipsum.txt"))
+	require.NoError(t, e.AddFile(strings.NewReader("replaced"), "dir1/lorem_ipsum.txt"))
+	require.NoError(t, e.Remove("this-is-a-symlink"))
+	require.NoError(t, e.Commit(""))
+	assert.Error(t, e.Commit(""))
+
+	after, err := OpenImage(f)
+	require.NoError(t, err)
+	report, err := after.Verify(WithFileData())
+	require.NoError(t, err)
+	assert.Empty(t, report.Findings)
+
+	delete(expected, "/this-is-a-symlink")
+	expected["/dir1/new.txt"] = snapshotEntry{Mode: 0644, Size: 3, SHA256: sha256String("new")}
+	expected["/dir1/lorem_ipsum.txt"] = snapshotEntry{Mode: 0644, Size: 8, SHA256: sha256String("replaced")}
+	assert.Equal(t, expected, snapshotImage(t, after))
+
+	// the unchanged data stays in place, the rest is appended
+	assert.Equal(t, location, fileLocation(t, after, "dir2/dir3/data.bin"))
+	assert.Greater(t, int64(fileLocation(t, after, "dir1/new.txt"))*int64(sectorSize), size-1)
+	pvd, err := after.primaryVolume()
+	require.NoError(t, err)
+	original, err := before.primaryVolume()
+	require.NoError(t, err)
+	assert.Equal(t, original.VolumeCreationDateAndTime, pvd.VolumeCreationDateAndTime)
+}
+
+func TestImageEditorJoliet(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{Joliet: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("kept"), "Kept File.txt"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "EDITED"))
+
+	imagePath := path.Join(t.TempDir(), "joliet.iso")
+	require.NoError(t, os.WriteFile(imagePath, buf.Bytes(), 0644))
+	f, err := os.OpenFile(imagePath, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	e, err := OpenImageEditor(f)
+	require.NoError(t, err)
+	defer e.Cleanup() // nolint: errcheck
+	require.NoError(t, e.AddFile(strings.NewReader("added"), "Added File.txt"))
+	require.NoError(t, e.Commit(""))
+
+	img, err := OpenImageWithOptions(f, ReaderOptions{PreferJoliet: true})
+	require.NoError(t, err)
+	label, err := img.Label()
+	require.NoError(t, err)
+	assert.Equal(t, "EDITED", label)
+	names := map[string]int64{}
+	children, err := mustRoot(t, img).GetChildren()
+	require.NoError(t, err)
+	for _, c := range children {
+		names[c.Name()] = c.Size()
+	}
+	// the kept files are staged with the names of the primary hierarchy
+	assert.Equal(t, map[string]int64{"KEPT_FILE.TXT": 4, "Added File.txt": 5}, names)
+}
+
+func TestImageEditorInterrupted(t *testing.T) {
+	f := copyFixture(t, "fixtures/test.iso")
+	before, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+
+	e, err := OpenImageEditor(f)
+	require.NoError(t, err)
+	defer e.Cleanup() // nolint: errcheck
+	require.NoError(t, e.AddFile(strings.NewReader("new"), "NEW.TXT"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = e.CommitContext(ctx, "")
+	var interrupted *IncompleteImageError
+	require.True(t, errors.As(err, &interrupted), "%v", err)
+
+	// the original part of the image isn't touched
+	after, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, before, after[:len(before)])
+
+	require.NoError(t, e.Commit(""))
+	img, err := OpenImage(f)
+	require.NoError(t, err)
+	_, err = resolvePath(mustRoot(t, img), "NEW.TXT", false, false)
+	assert.NoError(t, err)
+}
+
+func TestImageEditorErrors(t *testing.T) {
+	e, err := OpenImageEditor(copyFixture(t, "fixtures/test.iso"))
+	require.NoError(t, err)
+	defer e.Cleanup() // nolint: errcheck
+
+	e.SetImplantMD5(true)
+	assert.Error(t, e.Commit(""))
+	e.SetImplantMD5(false)
+
+	e.SetJoliet(true)
+	assert.Error(t, e.Commit(""))
+}
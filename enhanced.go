@@ -0,0 +1,55 @@
+package iso9660
+
+// maxEnhancedIdentifierLength is the longest identifier of the ISO 9660:1999 hierarchy, see ISO 9660:1999 7.5.1
+const maxEnhancedIdentifierLength = 207
+
+// newEnhancedHierarchy returns the hierarchy written with WriterOptions.EnhancedVolume.
+// ISO 9660:1999 has no depth limit, so relocated directories are recorded in their original place.
+func newEnhancedHierarchy() *hierarchy {
+	return &hierarchy{
+		identifier: func(e *stagedEntry, tail string) string {
+			return enhancedIdentifier(e.name, maxEnhancedIdentifierLength-len(tail)) + tail
+		},
+		compare: compareIdentifiers,
+	}
+}
+
+// enhancedIdentifier converts a name to an identifier of the ISO 9660:1999 hierarchy.
+// Apart from the bytes 0x00 and 0x01 of the "." and ".." entries and the ';' of file versions, which are replaced
+// by underscores, any character is kept. Names longer than max bytes are truncated at a character boundary.
+func enhancedIdentifier(name string, max int) string {
+	identifier := []byte(name)
+	for i, c := range identifier {
+		if c == 0 || c == 1 || c == ';' {
+			identifier[i] = '_'
+		}
+	}
+	if len(identifier) <= max {
+		return string(identifier)
+	}
+
+	// cut before the last character starting within the limit
+	end := 0
+	for i := range string(identifier) {
+		if i > max {
+			break
+		}
+		end = i
+	}
+	return string(identifier[:end])
+}
+
+// enhancedVolumeDescriptor derives the Enhanced Volume Descriptor from the Primary one
+func (wc *writeContext) enhancedVolumeDescriptor(pvd *PrimaryVolumeDescriptorBody) volumeDescriptor {
+	body := wc.hierarchyVolumeDescriptor(wc.enhanced, pvd)
+	body.FileStructureVersion = enhancedVolumeDescriptorVersion
+
+	return volumeDescriptor{
+		Header: volumeDescriptorHeader{
+			Type:       volumeTypeSupplementary,
+			Identifier: standardIdentifierBytes,
+			Version:    enhancedVolumeDescriptorVersion,
+		},
+		Primary: body,
+	}
+}
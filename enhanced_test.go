@@ -0,0 +1,107 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readTree maps the paths of the files of the image to their contents, and those of the directories to nil
+func readTree(t *testing.T, img *Image) map[string][]byte {
+	tree := map[string][]byte{}
+	for p, f := range filesByPath(t, img) {
+		switch {
+		case p == "/":
+		case f.IsDir():
+			tree[p] = nil
+		default:
+			data, err := io.ReadAll(f.Reader())
+			require.NoError(t, err)
+			tree[p] = data
+		}
+	}
+	return tree
+}
+
+func TestEnhancedVolume(t *testing.T) {
+	long := strings.Repeat("long name é ", 20) + ".tar.gz"
+	deep := "a/b/c/d/e/f/g/h/i/j"
+	files := map[string]string{
+		"/Mixed Case; and spaces.txt":            "versioned",
+		"/" + long:                               "truncated",
+		"/" + strings.Repeat("x", 300):           "first",
+		"/" + strings.Repeat("x", 301):           "second",
+		"/" + deep + "/deep file with long name": "deep",
+		"/README":                                "readme",
+	}
+
+	for _, rockRidge := range []bool{false, true} {
+		w, err := NewWriterWithOptions(WriterOptions{EnhancedVolume: true, EnableRockRidge: rockRidge, TransTables: !rockRidge})
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+		for name, data := range files {
+			require.NoError(t, w.AddFile(strings.NewReader(data), name))
+		}
+		var buf bytes.Buffer
+		require.NoError(t, w.WriteTo(&buf, "enhanced"))
+
+		img, err := OpenImageWithOptions(bytes.NewReader(buf.Bytes()), ReaderOptions{PreferEnhanced: true})
+		require.NoError(t, err)
+		vds := img.VolumeDescriptors()
+		require.Len(t, vds, 3)
+		assert.True(t, vds[1].Enhanced)
+		assert.True(t, vds[1].Selected)
+		assert.Empty(t, vds[1].Problem)
+		assert.Equal(t, byte(2), vds[1].Primary.FileStructureVersion)
+
+		tree := readTree(t, img)
+		assert.Equal(t, "versioned", string(tree["/Mixed Case_ and spaces.txt"]))
+		assert.Equal(t, "readme", string(tree["/README"]))
+		assert.Equal(t, "deep", string(tree["/"+deep+"/deep file with long name"]))
+		assert.Equal(t, "first", string(tree["/"+strings.Repeat("x", 207)]))
+		assert.Equal(t, "second", string(tree["/"+strings.Repeat("x", 205)+"_1"]))
+		var truncated string
+		for p := range tree {
+			if strings.HasPrefix(p, "/long name") {
+				truncated = p
+			}
+		}
+		assert.True(t, strings.HasPrefix("/"+long, truncated), truncated)
+		assert.LessOrEqual(t, len(truncated), 1+maxEnhancedIdentifierLength)
+		assert.Equal(t, "truncated", string(tree[truncated]))
+		// neither RR_MOVED nor TRANS.TBL
+		assert.Len(t, tree, len(files)+len(strings.Split(deep, "/")))
+
+		// the primary hierarchy is unchanged
+		img, err = OpenImage(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		assert.False(t, img.VolumeDescriptors()[1].Selected)
+		primary := readTree(t, img)
+		if rockRidge {
+			assert.Equal(t, "versioned", string(primary["/Mixed Case; and spaces.txt"]))
+		} else {
+			assert.Equal(t, "readme", string(primary["/README"]))
+			assert.Contains(t, primary, "/TRANS.TBL")
+		}
+
+		report, err := img.Verify()
+		require.NoError(t, err)
+		assert.Empty(t, report.Findings)
+	}
+}
+
+func TestEnhancedVolumeNotPreferred(t *testing.T) {
+	image, _ := streamImage(t)
+	img, err := OpenImageWithOptions(bytes.NewReader(image), ReaderOptions{PreferEnhanced: true})
+	require.NoError(t, err)
+	assert.True(t, img.VolumeDescriptors()[0].Selected)
+	_, err = img.RootDir()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,99 @@
+package iso9660
+
+import (
+	"io"
+)
+
+// copyChunkSize is the amount of data fileReader.WriteTo reads at once, a whole number of sectors
+const copyChunkSize = 512 * int64(sectorSize)
+
+// fileReader reads the data of a file which isn't compressed.
+// WriteTo copies it in large chunks, writes it straight from the memory mapping of OpenImageMmap,
+// or lets the kernel copy it if both the image and the destination are files.
+type fileReader struct {
+	*io.SectionReader
+	ra io.ReaderAt
+	// offset is the position of the extent within the image
+	offset int64
+}
+
+var _ io.WriterTo = &fileReader{}
+
+func newFileReader(ra io.ReaderAt, offset, size int64) *fileReader {
+	return &fileReader{
+		SectionReader: io.NewSectionReader(ra, offset, size),
+		ra:            ra,
+		offset:        offset,
+	}
+}
+
+// WriteTo writes the rest of the file's data to w
+func (r *fileReader) WriteTo(w io.Writer) (int64, error) {
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	remaining := r.Size() - pos
+	if remaining <= 0 {
+		return 0, nil
+	}
+
+	var written int64
+	handled := false
+	if m, ok := r.ra.(*mappedFile); ok {
+		written, err = m.writeRange(w, r.offset+pos, remaining)
+		handled = true
+	} else {
+		written, handled, err = sendFile(w, r.ra, r.offset+pos, remaining)
+	}
+	if !handled {
+		written, err = r.copyChunks(w, pos, remaining)
+	}
+
+	if _, seekErr := r.Seek(pos+written, io.SeekStart); err == nil {
+		err = seekErr
+	}
+	return written, err
+}
+
+// copyChunks copies size bytes starting at pos through a buffer of up to copyChunkSize bytes.
+// The last chunk is trimmed to the end of the data.
+func (r *fileReader) copyChunks(w io.Writer, pos, size int64) (int64, error) {
+	bufferSize := copyChunkSize
+	if size < bufferSize {
+		bufferSize = size
+	}
+	buffer := make([]byte, bufferSize)
+
+	var written int64
+	for written < size {
+		chunk := buffer
+		if rest := size - written; rest < int64(len(chunk)) {
+			chunk = chunk[:rest]
+		}
+
+		n, err := r.ra.ReadAt(chunk, r.offset+pos+written)
+		if n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+		} else {
+			err = nil
+		}
+
+		if n > 0 {
+			m, werr := w.Write(chunk[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+			if m < n {
+				return written, io.ErrShortWrite
+			}
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
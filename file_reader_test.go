@@ -0,0 +1,166 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// onlyReader hides the WriterTo of a reader, so that io.Copy uses its generic loop
+type onlyReader struct {
+	io.Reader
+}
+
+func TestFileReaderWriteTo(t *testing.T) {
+	data := make([]byte, 3*copyChunkSize+100)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	// the file starts after a sector of something else
+	image := append(make([]byte, sectorSize), data...)
+	path := filepath.Join(t.TempDir(), "image")
+	require.NoError(t, os.WriteFile(path, image, 0644))
+	imageFile, err := os.Open(path)
+	require.NoError(t, err)
+	defer imageFile.Close() // nolint: errcheck
+	sources := map[string]io.ReaderAt{
+		"bytes":  bytes.NewReader(image),
+		"file":   imageFile,
+		"mapped": &mappedFile{data: image},
+	}
+	for name, ra := range sources {
+		t.Run(name, func(t *testing.T) {
+			for _, skip := range []int{0, 1, 4000} {
+				r := newFileReader(ra, int64(sectorSize), int64(len(data)))
+				head := make([]byte, skip)
+				_, err := io.ReadFull(r, head)
+				require.NoError(t, err)
+
+				var buf bytes.Buffer
+				n, err := io.Copy(&buf, r)
+				require.NoError(t, err)
+				assert.Equal(t, int64(len(data)-skip), n)
+				assert.True(t, bytes.Equal(data[skip:], buf.Bytes()))
+
+				// the reader is at the end afterwards
+				rest, err := io.ReadAll(r)
+				assert.NoError(t, err)
+				assert.Empty(t, rest)
+			}
+
+			out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+			require.NoError(t, err)
+			defer out.Close() // nolint: errcheck
+			n, err := io.Copy(out, newFileReader(ra, int64(sectorSize), int64(len(data))))
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), n)
+			written, err := os.ReadFile(out.Name())
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(data, written))
+
+			// a truncated image
+			var buf bytes.Buffer
+			n, err = io.Copy(&buf, newFileReader(ra, int64(sectorSize), int64(len(data))+10))
+			assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+			assert.Equal(t, int64(len(data)), n)
+
+			n, err = io.Copy(out, newFileReader(ra, int64(sectorSize), int64(len(data))+10))
+			assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+			assert.Equal(t, int64(len(data)), n)
+		})
+	}
+}
+
+func TestFileReaderImage(t *testing.T) {
+	for _, open := range []func(string) (*Image, error){OpenImageMmap, openImageFile} {
+		img, err := open("fixtures/test.iso")
+		require.NoError(t, err)
+		defer img.Close() // nolint: errcheck
+
+		root, err := img.RootDir()
+		require.NoError(t, err)
+		children, err := root.GetChildren()
+		require.NoError(t, err)
+		cicero := children[0]
+		require.Equal(t, "CICERO.TXT", cicero.Name())
+
+		expected, err := io.ReadAll(onlyReader{cicero.Reader()})
+		require.NoError(t, err)
+		require.Len(t, expected, int(cicero.Size()))
+
+		out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+		require.NoError(t, err)
+		defer out.Close() // nolint: errcheck
+		n, err := io.Copy(out, cicero.Reader())
+		require.NoError(t, err)
+		assert.Equal(t, cicero.Size(), n)
+		written, err := os.ReadFile(out.Name())
+		require.NoError(t, err)
+		assert.Equal(t, expected, written)
+	}
+}
+
+// openImageFile opens an image without mapping it
+func openImageFile(path string) (*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := OpenImage(f)
+	if err != nil {
+		f.Close() // nolint: errcheck
+		return nil, err
+	}
+	img.closer = f
+	return img, nil
+}
+
+func BenchmarkExtractLargeFile(b *testing.B) {
+	if testing.Short() {
+		b.Skip("copying 2 GiB takes seconds")
+	}
+
+	const size = 2 << 30
+	dir := b.TempDir()
+	image, err := os.Create(filepath.Join(dir, "image"))
+	require.NoError(b, err)
+	defer image.Close() // nolint: errcheck
+	// the file is sparse, so that the data comes from the page cache
+	require.NoError(b, image.Truncate(size+int64(sectorSize)))
+
+	f := &File{ra: image, de: &DirectoryEntry{ExtentLocation: 1, ExtentLength: size}}
+	out, err := os.Create(filepath.Join(dir, "out"))
+	require.NoError(b, err)
+	defer out.Close() // nolint: errcheck
+
+	for name, copyTo := range map[string]func() (int64, error){
+		// io.Copy as it was before the reader implemented io.WriterTo
+		"generic": func() (int64, error) { return io.Copy(out, onlyReader{f.Reader()}) },
+		"chunks":  func() (int64, error) { return io.Copy(onlyWriter{out}, f.Reader()) },
+		"kernel":  func() (int64, error) { return io.Copy(out, f.Reader()) },
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				_, err := out.Seek(0, io.SeekStart)
+				require.NoError(b, err)
+				n, err := copyTo()
+				require.NoError(b, err)
+				require.Equal(b, int64(size), n)
+			}
+		})
+	}
+}
+
+// onlyWriter hides the ReaderFrom of a writer and the type of *os.File
+type onlyWriter struct {
+	io.Writer
+}
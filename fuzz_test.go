@@ -0,0 +1,119 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func FuzzDirectoryEntry(f *testing.F) {
+	de := DirectoryEntry{
+		ExtentLocation:       18,
+		ExtentLength:         2048,
+		RecordingDateTime:    RecordingTimestamp{},
+		FileFlags:            dirFlagDir,
+		VolumeSequenceNumber: 1,
+		Identifier:           "README.TXT;1",
+		SystemUse:            joinSystemUseEntries(marshalRockRidgeNameEntries("readme.txt")),
+	}
+	data, err := de.MarshalBinary()
+	require.NoError(f, err)
+	f.Add(data)
+	f.Add(data[:33])
+	f.Add([]byte{34})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var de DirectoryEntry
+		if err := de.UnmarshalBinary(data); err != nil {
+			return
+		}
+		entries, _ := parseSystemUse(de.SystemUse, &noopReaderAt{}, int64(sectorSize))
+		_, _ = UnmarshalRockRidge(entries)
+	})
+}
+
+func FuzzSystemUse(f *testing.F) {
+	er, err := rockRidgeExtensionRecord(RockRidgeIdentifier1991A)
+	require.NoError(f, err)
+	f.Add(joinSystemUseEntries([]SystemUseEntry{marshalSPEntry(0), marshalEREntry(er)}))
+	f.Add(joinSystemUseEntries(append(marshalRockRidgeNameEntries(strings.Repeat("n", 300)),
+		marshalRockRidgePosixEntry(0o755, 1, 0, 0, 0),
+		marshalRockRidgeDeviceEntry(8, 1),
+		marshalRockRidgeTimestampEntry(RecordTimes{}),
+	)))
+	f.Add([]byte{'C', 'E', 28, 1})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entries, _ := parseSystemUse(data, &noopReaderAt{}, int64(sectorSize))
+		s := SystemUseEntrySlice(entries)
+		for _, e := range entries {
+			_, _ = ExtensionRecordDecode(e)
+			_, _ = SPRecordDecode(e)
+			_, _ = umarshalContinuationEntry(e)
+			_, _ = unmarshalSparseEntry(e)
+		}
+		_, _ = UnmarshalRockRidge(s)
+		_, _ = s.rockRidgeName()
+		_, _ = s.symlinkTarget()
+		_, _ = s.getPosixEntry()
+		_, _, _ = s.GetDeviceNumber()
+		_, _ = s.GetTimestamps()
+		_, _ = s.getZisofsInfo()
+	})
+}
+
+// FuzzOpenImage is best run with -fuzzminimizetime 0, minimizing the inputs the size of an image takes long
+func FuzzOpenImage(f *testing.F) {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(f, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(f, w.AddFile(strings.NewReader("hello"), "dir/hello.txt"))
+	var buf bytes.Buffer
+	require.NoError(f, w.WriteTo(&buf, "fuzz"))
+	f.Add(buf.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		img, err := OpenImage(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		_ = img.Walk(func(path string, f *File, attrs RockRidgeAttrs) error {
+			return nil
+		})
+	})
+}
+
+func TestTypedParserErrors(t *testing.T) {
+	var de DirectoryEntry
+	err := de.UnmarshalBinary([]byte{20, 0})
+	assert.ErrorIs(t, err, ErrInvalidRecordLength)
+	err = de.UnmarshalBinary(append([]byte{40}, make([]byte, 33)...))
+	assert.ErrorIs(t, err, ErrTruncatedRecord)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = SPRecordDecode(SystemUseEntry{'S', 'P', 7, 1, 0xBE, 0xEE, 0})
+	assert.ErrorIs(t, err, ErrBadSignature)
+	_, err = SPRecordDecode(SystemUseEntry{'E', 'R', 7, 1, 0xBE, 0xEF, 0})
+	assert.ErrorIs(t, err, ErrBadSignature)
+	_, err = ExtensionRecordDecode(SystemUseEntry{'E', 'R', 12, 1, 4, 4, 4, 1})
+	assert.ErrorIs(t, err, ErrTruncatedRecord)
+
+	_, err = splitSystemUseEntries([]byte{'N', 'M', 2, 1}, &noopReaderAt{})
+	assert.ErrorIs(t, err, ErrInvalidRecordLength)
+	_, err = splitSystemUseEntries([]byte{'N', 'M', 9, 1, 0}, &noopReaderAt{})
+	assert.ErrorIs(t, err, ErrTruncatedRecord)
+
+	_, _, err = SystemUseEntrySlice{{'P', 'N', 8, 1, 0, 0, 0, 0}}.GetDeviceNumber()
+	assert.ErrorIs(t, err, ErrTruncatedRecord)
+	_, err = SystemUseEntrySlice{{'T', 'F', 7, 1, tfFlagModify, 0, 0}}.GetTimestamps()
+	assert.ErrorIs(t, err, ErrTruncatedRecord)
+	assert.False(t, errors.Is(err, ErrBadSignature))
+}
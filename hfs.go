@@ -0,0 +1,207 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Hybrid discs mastered for Macs carry an HFS or HFS+ volume next to the ISO 9660 one. The system area
+// either starts with an Apple Partition Map, which has a partition of type Apple_HFS holding the volume,
+// or the HFS volume starts at the beginning of the disc. Like the ISO 9660 volume, the HFS one describes
+// the files of the disc, whose data both share.
+
+const (
+	// the Driver Descriptor Map and the entries of the partition map start with these signatures
+	appleDriverDescriptorSignature = "ER"
+	applePartitionSignature        = "PM"
+	appleHFSPartitionType          = "Apple_HFS"
+	applePartitionEntrySize        = 512
+
+	// the Master Directory Block of HFS and the volume header of HFS+ follow two boot blocks of 512 bytes
+	hfsHeaderOffset = 1024
+	hfsHeaderSize   = 512
+)
+
+// HFSKind tells the flavour of an HFS volume
+type HFSKind string
+
+const (
+	HFS     HFSKind = "HFS"
+	HFSPlus HFSKind = "HFS+"
+	// HFSX is HFS+ with case-sensitive names
+	HFSX HFSKind = "HFSX"
+)
+
+// hfsEpoch is the origin of the dates of HFS, which are recorded in seconds.
+// Some of them are in local time, they are returned as if they were UTC.
+var hfsEpoch = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ApplePartition is an entry of an Apple Partition Map
+type ApplePartition struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Offset and Size locate the partition in the image, in bytes
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
+// HFSVolume describes the HFS or HFS+ volume of a hybrid disc, as found by Image.HFSVolume
+type HFSVolume struct {
+	Kind HFSKind `json:"kind"`
+	// Name is the name of an HFS volume. HFS+ records it in the catalog of the volume, it is empty then.
+	Name string `json:"name,omitempty"`
+	// Offset locates the volume in the image, in bytes, and Size is the space its allocation blocks span
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	BlockSize uint32 `json:"block_size"`
+	// Files and Folders are the numbers recorded in an HFS+ volume header, zero for HFS
+	Files   uint32 `json:"files,omitempty"`
+	Folders uint32 `json:"folders,omitempty"`
+	// Created and Modified are the dates of the volume
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+	// Embedded is set on an HFS+ volume wrapped in an HFS volume, which older Macs see instead
+	Embedded bool `json:"embedded,omitempty"`
+	// Partition is the entry of the Apple Partition Map holding the volume, nil if there is no partition map
+	Partition *ApplePartition `json:"partition,omitempty"`
+}
+
+// ApplePartitions returns the partitions of the Apple Partition Map in the system area,
+// or nil if the image has none
+func (i *Image) ApplePartitions() ([]ApplePartition, error) {
+	ddm := make([]byte, applePartitionEntrySize)
+	if _, err := i.ra.ReadAt(ddm, 0); err != nil {
+		return nil, fmt.Errorf("reading the driver descriptor map: %w", err)
+	}
+	if string(ddm[0:2]) != appleDriverDescriptorSignature {
+		return nil, nil
+	}
+	// the entries fill the blocks after the descriptor, whose size gives the unit of their locations
+	blockSize := int64(binary.BigEndian.Uint16(ddm[2:4]))
+	if blockSize < applePartitionEntrySize {
+		blockSize = applePartitionEntrySize
+	}
+
+	var partitions []ApplePartition
+	entry := make([]byte, applePartitionEntrySize)
+	for n, count := int64(1), int64(1); n <= count; n++ {
+		if _, err := i.ra.ReadAt(entry, n*blockSize); err != nil {
+			return partitions, fmt.Errorf("reading the partition map entry %d: %w", n, err)
+		}
+		if string(entry[0:2]) != applePartitionSignature {
+			if n == 1 {
+				return nil, nil
+			}
+			return partitions, fmt.Errorf("the partition map entry %d has no %s signature", n, applePartitionSignature)
+		}
+		if n == 1 {
+			// every entry records the number of entries, which the first one is trusted with
+			count = int64(binary.BigEndian.Uint32(entry[4:8]))
+		}
+		partitions = append(partitions, ApplePartition{
+			Name:   cString(entry[16:48]),
+			Type:   cString(entry[48:80]),
+			Offset: int64(binary.BigEndian.Uint32(entry[8:12])) * blockSize,
+			Size:   int64(binary.BigEndian.Uint32(entry[12:16])) * blockSize,
+		})
+	}
+	return partitions, nil
+}
+
+// cString returns the text of a field padded with NUL bytes
+func cString(field []byte) string {
+	if end := bytes.IndexByte(field, 0); end >= 0 {
+		field = field[:end]
+	}
+	return string(field)
+}
+
+// HFSVolume returns the HFS or HFS+ volume of a hybrid disc, from the first Apple_HFS partition
+// of the Apple Partition Map or from the beginning of the image, or nil if there is none.
+// An HFS volume wrapping an HFS+ one is returned as the embedded HFS+ volume.
+func (i *Image) HFSVolume() (*HFSVolume, error) {
+	partitions, err := i.ApplePartitions()
+	if err != nil {
+		return nil, err
+	}
+	for n := range partitions {
+		if !strings.EqualFold(partitions[n].Type, appleHFSPartitionType) {
+			continue
+		}
+		volume, err := readHFSVolume(i.ra, partitions[n].Offset)
+		if err != nil || volume == nil {
+			return nil, err
+		}
+		volume.Partition = &partitions[n]
+		return volume, nil
+	}
+	if partitions != nil {
+		return nil, nil
+	}
+	return readHFSVolume(i.ra, 0)
+}
+
+// readHFSVolume reads the header of the HFS or HFS+ volume starting at the given offset, nil if there is none
+func readHFSVolume(ra io.ReaderAt, offset int64) (*HFSVolume, error) {
+	header := make([]byte, hfsHeaderSize)
+	if _, err := ra.ReadAt(header, offset+hfsHeaderOffset); err != nil {
+		return nil, fmt.Errorf("reading the HFS volume header: %w", err)
+	}
+
+	switch string(header[0:2]) {
+	case "BD":
+		volume := &HFSVolume{
+			Kind:      HFS,
+			Offset:    offset,
+			BlockSize: binary.BigEndian.Uint32(header[20:24]),
+			Created:   hfsTime(binary.BigEndian.Uint32(header[2:6])),
+			Modified:  hfsTime(binary.BigEndian.Uint32(header[6:10])),
+		}
+		// the allocation blocks start drAlBlSt 512-byte blocks into the volume
+		blocksStart := int64(binary.BigEndian.Uint16(header[28:30])) * 512
+		volume.Size = blocksStart + int64(binary.BigEndian.Uint16(header[18:20]))*int64(volume.BlockSize)
+		if length := int(header[36]); length <= 27 {
+			volume.Name = string(header[37 : 37+length])
+		}
+
+		// a wrapper records the extent of the embedded HFS+ volume in allocation blocks
+		if embedded := string(header[124:126]); embedded == "H+" || embedded == "HX" {
+			start := int64(binary.BigEndian.Uint16(header[126:128])) * int64(volume.BlockSize)
+			plus, err := readHFSVolume(ra, offset+blocksStart+start)
+			if err != nil || plus == nil {
+				return nil, err
+			}
+			plus.Embedded = true
+			return plus, nil
+		}
+		return volume, nil
+	case "H+", "HX":
+		volume := &HFSVolume{
+			Kind:      HFSPlus,
+			Offset:    offset,
+			Created:   hfsTime(binary.BigEndian.Uint32(header[16:20])),
+			Modified:  hfsTime(binary.BigEndian.Uint32(header[20:24])),
+			Files:     binary.BigEndian.Uint32(header[32:36]),
+			Folders:   binary.BigEndian.Uint32(header[36:40]),
+			BlockSize: binary.BigEndian.Uint32(header[40:44]),
+		}
+		if string(header[0:2]) == "HX" {
+			volume.Kind = HFSX
+		}
+		volume.Size = int64(binary.BigEndian.Uint32(header[44:48])) * int64(volume.BlockSize)
+		return volume, nil
+	}
+	return nil, nil
+}
+
+// hfsTime converts a date of HFS, zero if it isn't set
+func hfsTime(seconds uint32) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return hfsEpoch.Add(time.Duration(seconds) * time.Second)
+}
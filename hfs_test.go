@@ -0,0 +1,127 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hfsTestImage writes an image with the given system area
+func hfsTestImage(t *testing.T, systemArea []byte) *Image {
+	w, err := NewWriterWithOptions(WriterOptions{SystemArea: systemArea})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("data"), "FILE.TXT"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "hybrid"))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	return img
+}
+
+// putPartition records an entry of an Apple Partition Map of 512-byte blocks
+func putPartition(systemArea []byte, index, count, start, blocks uint32, name, kind string) {
+	entry := systemArea[512*index:]
+	copy(entry[0:2], "PM")
+	binary.BigEndian.PutUint32(entry[4:8], count)
+	binary.BigEndian.PutUint32(entry[8:12], start)
+	binary.BigEndian.PutUint32(entry[12:16], blocks)
+	copy(entry[16:48], name)
+	copy(entry[48:80], kind)
+}
+
+// putHFSPlusHeader records an HFS+ volume header for a volume starting at the given offset
+func putHFSPlusHeader(systemArea []byte, offset int, created uint32) {
+	header := systemArea[offset+1024:]
+	copy(header[0:2], "H+")
+	binary.BigEndian.PutUint16(header[2:4], 4)
+	binary.BigEndian.PutUint32(header[16:20], created)
+	binary.BigEndian.PutUint32(header[32:36], 5)
+	binary.BigEndian.PutUint32(header[36:40], 2)
+	binary.BigEndian.PutUint32(header[40:44], 4096)
+	binary.BigEndian.PutUint32(header[44:48], 100)
+}
+
+func TestHFSVolume(t *testing.T) {
+	// seconds from 1904 to 2001
+	const created = 3061152000
+	createdTime := time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// an Apple Partition Map with an HFS partition, whose Master Directory Block follows the boot blocks
+	mapped := make([]byte, systemAreaSize)
+	copy(mapped[0:2], "ER")
+	binary.BigEndian.PutUint16(mapped[2:4], 512)
+	putPartition(mapped, 1, 2, 1, 3, "Apple", "Apple_partition_map")
+	putPartition(mapped, 2, 2, 4, 100, "MyDisc", "Apple_HFS")
+	mdb := mapped[2048+1024:]
+	copy(mdb[0:2], "BD")
+	binary.BigEndian.PutUint32(mdb[2:6], created)
+	binary.BigEndian.PutUint16(mdb[18:20], 10)
+	binary.BigEndian.PutUint32(mdb[20:24], 4096)
+	binary.BigEndian.PutUint16(mdb[28:30], 8)
+	mdb[36] = 7
+	copy(mdb[37:], "My Disc")
+
+	img := hfsTestImage(t, mapped)
+	partitions, err := img.ApplePartitions()
+	require.NoError(t, err)
+	require.Len(t, partitions, 2)
+	assert.Equal(t, ApplePartition{Name: "Apple", Type: "Apple_partition_map", Offset: 512, Size: 3 * 512}, partitions[0])
+	volume, err := img.HFSVolume()
+	require.NoError(t, err)
+	require.NotNil(t, volume)
+	assert.Equal(t, &HFSVolume{
+		Kind:      HFS,
+		Name:      "My Disc",
+		Offset:    2048,
+		Size:      8*512 + 10*4096,
+		BlockSize: 4096,
+		Created:   createdTime,
+		Partition: &partitions[1],
+	}, volume)
+
+	// an HFS+ volume at the beginning of the image
+	plain := make([]byte, systemAreaSize)
+	putHFSPlusHeader(plain, 0, created)
+	volume, err = hfsTestImage(t, plain).HFSVolume()
+	require.NoError(t, err)
+	assert.Equal(t, &HFSVolume{
+		Kind:      HFSPlus,
+		Size:      100 * 4096,
+		BlockSize: 4096,
+		Files:     5,
+		Folders:   2,
+		Created:   createdTime,
+	}, volume)
+
+	// an HFS wrapper with an HFS+ volume in its second allocation block
+	wrapper := make([]byte, systemAreaSize)
+	copy(wrapper[1024:1026], "BD")
+	binary.BigEndian.PutUint32(wrapper[1024+20:], 2048)
+	binary.BigEndian.PutUint16(wrapper[1024+28:], 4)
+	copy(wrapper[1024+124:], "H+")
+	binary.BigEndian.PutUint16(wrapper[1024+126:], 1)
+	putHFSPlusHeader(wrapper, 4*512+2048, created)
+	volume, err = hfsTestImage(t, wrapper).HFSVolume()
+	require.NoError(t, err)
+	require.NotNil(t, volume)
+	assert.Equal(t, HFSPlus, volume.Kind)
+	assert.Equal(t, int64(4*512+2048), volume.Offset)
+	assert.True(t, volume.Embedded)
+
+	img = hfsTestImage(t, nil)
+	partitions, err = img.ApplePartitions()
+	require.NoError(t, err)
+	assert.Nil(t, partitions)
+	volume, err = img.HFSVolume()
+	require.NoError(t, err)
+	assert.Nil(t, volume)
+}
@@ -0,0 +1,227 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// hierarchyNode is a directory or file of a hierarchy written besides the primary one, like the Joliet tree.
+// Files share their extents with the primary hierarchy, directories have extents of their own.
+type hierarchyNode struct {
+	// node is the node of the primary hierarchy, for a relocated directory the one in RR_MOVED
+	node       *layoutNode
+	parent     *hierarchyNode
+	children   []*hierarchyNode
+	identifier string
+	location   uint32
+	length     uint32
+}
+
+// hierarchy is a directory tree described by a Supplementary or an Enhanced Volume Descriptor
+type hierarchy struct {
+	// identifier returns the identifier of an entry with the given name, which ends with tail if it isn't empty.
+	// The tail makes the identifier unique among its siblings.
+	identifier func(e *stagedEntry, tail string) string
+	// compare orders the identifiers of a directory
+	compare func(a, b string) int
+
+	root *hierarchyNode
+	// directories are in breadth-first order, which is also the order of the path table
+	directories        []*hierarchyNode
+	pathTableSize      uint32
+	lPathTableLocation uint32
+	mPathTableLocation uint32
+}
+
+// build mirrors the primary hierarchy with identifiers derived from the original names.
+// Directories relocated by Rock Ridge are recorded in their original place,
+// and the generated RR_MOVED and TRANS.TBL entries are left out.
+func (h *hierarchy) build(root *layoutNode) {
+	h.root = &hierarchyNode{node: root, identifier: string([]byte{0})}
+	h.root.parent = h.root
+	h.directories = []*hierarchyNode{h.root}
+
+	for i := 0; i < len(h.directories); i++ {
+		dir := h.directories[i]
+		// the identifiers are made unique in the order of the original names, like the primary ones
+		children := append([]*layoutNode(nil), dir.node.children...)
+		sort.SliceStable(children, func(i, j int) bool { return children[i].entry.name < children[j].entry.name })
+
+		used := make(map[string]bool)
+		for _, c := range children {
+			if c.generated {
+				continue
+			}
+			node := c
+			if c.childLink != nil {
+				node = c.childLink
+			}
+
+			identifier := h.identifier(c.entry, "")
+			for n := 1; used[identifier]; n++ {
+				identifier = h.identifier(c.entry, "_"+strconv.Itoa(n))
+			}
+			used[identifier] = true
+			dir.children = append(dir.children, &hierarchyNode{node: node, parent: dir, identifier: identifier})
+		}
+
+		sort.SliceStable(dir.children, func(i, j int) bool {
+			return h.compare(dir.children[i].identifier, dir.children[j].identifier) < 0
+		})
+		for _, c := range dir.children {
+			if c.node.entry.isDir() {
+				h.directories = append(h.directories, c)
+			}
+		}
+	}
+
+	h.pathTableSize = 0
+	for _, dir := range h.directories {
+		r := pathTableRecord{identifier: dir.identifier}
+		h.pathTableSize += uint32(r.length())
+	}
+}
+
+// allocateHierarchy builds the hierarchy and assigns sectors to its path tables and directories
+func (wc *writeContext) allocateHierarchy(h *hierarchy) error {
+	h.build(wc.root)
+	if len(h.directories) > maxPathTableDirectories {
+		return fmt.Errorf("%d directories don't fit into a path table", len(h.directories))
+	}
+
+	pathTableSectors := fileLengthToSectors(h.pathTableSize)
+	h.lPathTableLocation = wc.allocateSectors(pathTableSectors)
+	h.mPathTableLocation = wc.allocateSectors(pathTableSectors)
+
+	for _, dir := range h.directories {
+		var extent directoryExtent
+		err := wc.hierarchyRecords(dir, func(record []byte) error {
+			extent.place(uint32(len(record)))
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("processing %s: %w", dir.node.entry.path(), err)
+		}
+		dir.location = wc.allocateSectors(extent.sectorCount())
+		dir.length = extent.sectorCount() * sectorSize
+	}
+	return nil
+}
+
+// hierarchyEntry creates the record describing the node in its hierarchy
+func (wc *writeContext) hierarchyEntry(n *hierarchyNode, identifier string) *DirectoryEntry {
+	de := wc.directoryEntry(n.node, identifier)
+	if n.node.entry.isDir() {
+		de.ExtentLocation = int32(wc.blocks(n.location))
+		de.ExtentLength = n.length
+	}
+	return de
+}
+
+// hierarchyEntries creates the records describing the node in its hierarchy, one per extent of a large file
+func (wc *writeContext) hierarchyEntries(n *hierarchyNode, identifier string) []*DirectoryEntry {
+	if n.node.entry.isDir() {
+		return []*DirectoryEntry{wc.hierarchyEntry(n, identifier)}
+	}
+	return wc.directoryEntries(n.node, identifier)
+}
+
+// hierarchyRecords marshals the records of a directory of a secondary hierarchy, which have no System Use fields
+func (wc *writeContext) hierarchyRecords(dir *hierarchyNode, fn func(record []byte) error) error {
+	record := func(de *DirectoryEntry) error {
+		data, err := de.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return fn(data)
+	}
+
+	if err := record(wc.hierarchyEntry(dir, string([]byte{0}))); err != nil {
+		return err
+	}
+	if err := record(wc.hierarchyEntry(dir.parent, string([]byte{1}))); err != nil {
+		return err
+	}
+	for _, c := range dir.children {
+		for _, de := range wc.hierarchyEntries(c, c.identifier) {
+			if err := record(de); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// marshalPathTable encodes the path table of the hierarchy, padded to whole sectors,
+// with the locations converted to logical blocks by blocks
+func (h *hierarchy) marshalPathTable(order binary.ByteOrder, blocks func(sectors uint32) uint32) []byte {
+	numbers := make(map[*hierarchyNode]uint16, len(h.directories))
+	for i, dir := range h.directories {
+		numbers[dir] = uint16(i + 1)
+	}
+
+	output := make([]byte, 0, fileLengthToSectors(h.pathTableSize)*sectorSize)
+	for _, dir := range h.directories {
+		r := pathTableRecord{identifier: dir.identifier, location: blocks(dir.location), parent: numbers[dir.parent]}
+		output = append(output, r.marshal(order)...)
+	}
+	return output[:cap(output)]
+}
+
+// writeHierarchy writes the path tables and the directories of the hierarchy
+func (wc *writeContext) writeHierarchy(w io.Writer, h *hierarchy) error {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if _, err := w.Write(h.marshalPathTable(order, wc.blocks)); err != nil {
+			return err
+		}
+	}
+
+	zeros := make([]byte, sectorSize)
+	for _, dir := range h.directories {
+		if err := wc.interrupted(PhaseDirectories, dir.node.entry.path()); err != nil {
+			return err
+		}
+
+		var extent directoryExtent
+		err := wc.hierarchyRecords(dir, func(record []byte) error {
+			if padding := extent.place(uint32(len(record))); padding > 0 {
+				if _, err := w.Write(zeros[:padding]); err != nil {
+					return err
+				}
+			}
+			_, err := w.Write(record)
+			return err
+		})
+		if err == nil {
+			_, err = w.Write(zeros[:extent.remainder()])
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir.node.entry.path(), err)
+		}
+	}
+	return nil
+}
+
+// hierarchies returns the secondary hierarchies which are written, in the order of their volume descriptors
+func (wc *writeContext) hierarchies() []*hierarchy {
+	var result []*hierarchy
+	for _, h := range []*hierarchy{wc.joliet, wc.enhanced} {
+		if h != nil {
+			result = append(result, h)
+		}
+	}
+	return result
+}
+
+// hierarchyVolumeDescriptor derives the body of the volume descriptor of the hierarchy from the Primary one
+func (wc *writeContext) hierarchyVolumeDescriptor(h *hierarchy, pvd *PrimaryVolumeDescriptorBody) *PrimaryVolumeDescriptorBody {
+	body := *pvd
+	body.PathTableSize = int32(h.pathTableSize)
+	body.TypeLPathTableLoc = int32(wc.blocks(h.lPathTableLocation))
+	body.TypeMPathTableLoc = int32(wc.blocks(h.mPathTableLocation))
+	body.RootDirectoryEntry = wc.hierarchyEntry(h.root, string([]byte{0}))
+	return &body
+}
@@ -0,0 +1,241 @@
+package iso9660
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+// HybridOptions make an image bootable from a disk or a USB stick it is copied to, like the isohybrid tool
+// of syslinux does, by writing a partition table into the system area, see SetHybrid
+type HybridOptions struct {
+	// BootCode is the x86 code at the beginning of the MBR, at most 440 bytes, such as isohdpfx.bin of syslinux.
+	// Without it, BIOSes don't boot from the disk, but UEFI firmware can still use the EFI system partition.
+	BootCode []byte
+	// EFIImagePath is the path of a staged FAT image of an EFI system partition, which gets a partition of its own.
+	// The partition overlaps the data of the file, which usually also is the image of an El Torito entry
+	// for BootPlatformEFI. Empty adds no such partition.
+	EFIImagePath string
+	// GPT partitions the disk with a GUID Partition Table behind a protective MBR instead of with the MBR alone.
+	// The backup of the table is written to sectors appended to the image.
+	GPT bool
+	// DiskID is the disk signature of the MBR, which the GUIDs of the GPT are derived from as well.
+	// Zero derives it from the volume identifier and the time of the image, so FixedTimestamp keeps it stable.
+	DiskID uint32
+}
+
+const (
+	diskSectorSize       = 512
+	diskSectorsPerSector = uint64(sectorSize / diskSectorSize)
+	mbrBootCodeSize      = 440
+	// the isohybrid geometry, for the CHS addresses of the MBR partitions
+	mbrHeads   = 64
+	mbrSectors = 32
+
+	mbrTypeHybrid     = 0x17
+	mbrTypeEFI        = 0xEF
+	mbrTypeProtective = 0xEE
+
+	gptHeaderSize   = 92
+	gptEntryCount   = 128
+	gptEntrySize    = 128
+	gptTableSectors = gptEntryCount * gptEntrySize / diskSectorSize
+	// gptBackupSectors holds the backup partition entries and header at the end of the image, in whole sectors of the image
+	gptBackupSectors = ((gptTableSectors+1)*diskSectorSize + sectorSize - 1) / sectorSize
+	// gptISOStart is the first disk sector of the partition of the ISO 9660 data, after the system area
+	gptISOStart = uint64(systemAreaSize / diskSectorSize)
+)
+
+var (
+	gptBasicDataType = parseGUID("EBD0A0A2-B9E5-4433-87C0-68B6B72699C7")
+	gptEFISystemType = parseGUID("C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+)
+
+// SetHybrid makes the image bootable from a disk with a partition table in the system area, see HybridOptions,
+// or writes a plain system area again if opts is nil. It cannot be combined with SetSystemArea.
+func (iw *ImageWriter) SetHybrid(opts *HybridOptions) error {
+	if opts != nil {
+		if len(opts.BootCode) > mbrBootCodeSize {
+			return fmt.Errorf("MBR boot code of %d bytes exceeds the maximum of %d bytes", len(opts.BootCode), mbrBootCodeSize)
+		}
+		copied := *opts
+		copied.BootCode = append([]byte(nil), opts.BootCode...)
+		opts = &copied
+	}
+
+	iw.hybrid = opts
+	return nil
+}
+
+// hybridLayout holds the partition tables of a hybrid image
+type hybridLayout struct {
+	// systemArea starts with the MBR, followed by the primary GPT if there is one
+	systemArea []byte
+	// backup is the backup GPT written to the last sectors of the image, starting at backupLocation
+	backup         []byte
+	backupLocation uint32
+}
+
+// layoutHybrid appends the sectors of the backup GPT to the image and creates its partition tables.
+// It is called once all the other sectors are allocated.
+func (wc *writeContext) layoutHybrid(opts *HybridOptions, efiImage *stagedEntry, volumeIdentifier string) (*hybridLayout, error) {
+	var efiStart, efiSectors uint64
+	if opts.EFIImagePath != "" {
+		var node *layoutNode
+		for _, file := range wc.files {
+			if efiImage != nil && file.entry == efiImage {
+				node = file
+			}
+		}
+		switch {
+		case node == nil:
+			return nil, fmt.Errorf("the EFI system partition image %q isn't a staged file", opts.EFIImagePath)
+		case node.zisofs != nil:
+			return nil, fmt.Errorf("the EFI system partition image %q cannot be compressed with zisofs", opts.EFIImagePath)
+		case node.length == 0:
+			return nil, fmt.Errorf("the EFI system partition image %q is empty", opts.EFIImagePath)
+		}
+		efiStart = uint64(node.location) * diskSectorsPerSector
+		efiSectors = uint64(node.length+diskSectorSize-1) / diskSectorSize
+	}
+
+	h := &hybridLayout{systemArea: make([]byte, systemAreaSize)}
+	if opts.GPT {
+		h.backupLocation = wc.allocateSectors(gptBackupSectors)
+	}
+	diskSectors := uint64(wc.freeSectorPointer) * diskSectorsPerSector
+
+	diskID := opts.DiskID
+	if diskID == 0 {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s %d", volumeIdentifier, wc.timestamp.UnixNano())))
+		diskID = binary.LittleEndian.Uint32(sum[:4])
+	}
+
+	mbr := h.systemArea[:diskSectorSize]
+	copy(mbr, opts.BootCode)
+	binary.LittleEndian.PutUint32(mbr[440:444], diskID)
+	if opts.GPT {
+		putMBRPartition(mbr, 0, false, mbrTypeProtective, 1, diskSectors-1)
+	} else {
+		putMBRPartition(mbr, 0, true, mbrTypeHybrid, 0, diskSectors)
+		if efiSectors > 0 {
+			putMBRPartition(mbr, 1, false, mbrTypeEFI, efiStart, efiSectors)
+		}
+	}
+	mbr[510], mbr[511] = 0x55, 0xAA
+
+	if !opts.GPT {
+		return h, nil
+	}
+
+	entries := make([]byte, gptEntryCount*gptEntrySize)
+	isoEnd := uint64(h.backupLocation)*diskSectorsPerSector - 1
+	putGPTEntry(entries[0:gptEntrySize], gptBasicDataType, deriveGUID(diskID, 1), gptISOStart, isoEnd, "ISO9660")
+	if efiSectors > 0 {
+		putGPTEntry(entries[gptEntrySize:2*gptEntrySize], gptEFISystemType, deriveGUID(diskID, 2),
+			efiStart, efiStart+efiSectors-1, "EFI System Partition")
+	}
+	entriesCRC := crc32.ChecksumIEEE(entries)
+
+	last := diskSectors - 1
+	backupEntries := last - gptTableSectors
+	header := func(current, backup, entriesLBA uint64) []byte {
+		data := make([]byte, diskSectorSize)
+		copy(data[0:8], "EFI PART")
+		binary.LittleEndian.PutUint32(data[8:12], 0x00010000)
+		binary.LittleEndian.PutUint32(data[12:16], gptHeaderSize)
+		binary.LittleEndian.PutUint64(data[24:32], current)
+		binary.LittleEndian.PutUint64(data[32:40], backup)
+		binary.LittleEndian.PutUint64(data[40:48], 2+gptTableSectors)
+		binary.LittleEndian.PutUint64(data[48:56], backupEntries-1)
+		diskGUID := deriveGUID(diskID, 0)
+		copy(data[56:72], diskGUID[:])
+		binary.LittleEndian.PutUint64(data[72:80], entriesLBA)
+		binary.LittleEndian.PutUint32(data[80:84], gptEntryCount)
+		binary.LittleEndian.PutUint32(data[84:88], gptEntrySize)
+		binary.LittleEndian.PutUint32(data[88:92], entriesCRC)
+		binary.LittleEndian.PutUint32(data[16:20], crc32.ChecksumIEEE(data[:gptHeaderSize]))
+		return data
+	}
+
+	copy(h.systemArea[diskSectorSize:], header(1, last, 2))
+	copy(h.systemArea[2*diskSectorSize:], entries)
+
+	h.backup = make([]byte, gptBackupSectors*sectorSize)
+	copy(h.backup[len(h.backup)-(gptTableSectors+1)*diskSectorSize:], entries)
+	copy(h.backup[len(h.backup)-diskSectorSize:], header(last, 1, backupEntries))
+	return h, nil
+}
+
+// putMBRPartition fills the entry of the MBR partition table with the given index.
+// Partitions beyond 2 TiB are cut short, as the MBR cannot describe them.
+func putMBRPartition(mbr []byte, index int, active bool, partitionType byte, start, sectors uint64) {
+	if start > math.MaxUint32 {
+		start, sectors = math.MaxUint32, 0
+	}
+	if start+sectors > math.MaxUint32 {
+		sectors = math.MaxUint32 - start
+	}
+
+	entry := mbr[446+16*index : 446+16*(index+1)]
+	if active {
+		entry[0] = 0x80
+	}
+	copy(entry[1:4], chsAddress(start))
+	entry[4] = partitionType
+	copy(entry[5:8], chsAddress(start+sectors-1))
+	binary.LittleEndian.PutUint32(entry[8:12], uint32(start))
+	binary.LittleEndian.PutUint32(entry[12:16], uint32(sectors))
+}
+
+// chsAddress encodes a disk sector in the isohybrid geometry, clamped to the largest address
+func chsAddress(lba uint64) []byte {
+	cylinder := lba / (mbrHeads * mbrSectors)
+	head := (lba / mbrSectors) % mbrHeads
+	sector := lba%mbrSectors + 1
+	if cylinder > 1023 {
+		cylinder, head, sector = 1023, mbrHeads-1, mbrSectors
+	}
+	return []byte{byte(head), byte(sector) | byte(cylinder>>8)<<6, byte(cylinder)}
+}
+
+// putGPTEntry fills a GPT partition entry covering the disk sectors from first to last inclusive
+func putGPTEntry(entry []byte, partitionType, guid [16]byte, first, last uint64, name string) {
+	copy(entry[0:16], partitionType[:])
+	copy(entry[16:32], guid[:])
+	binary.LittleEndian.PutUint64(entry[32:40], first)
+	binary.LittleEndian.PutUint64(entry[40:48], last)
+	for i, c := range utf16.Encode([]rune(name)) {
+		binary.LittleEndian.PutUint16(entry[56+2*i:], c)
+	}
+}
+
+// parseGUID encodes a GUID in its textual form as stored in a GPT, with the first three fields little-endian
+func parseGUID(s string) [16]byte {
+	raw, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(raw) != 16 {
+		panic(errors.New("invalid GUID " + s))
+	}
+	var guid [16]byte
+	copy(guid[:], raw)
+	guid[0], guid[1], guid[2], guid[3] = raw[3], raw[2], raw[1], raw[0]
+	guid[4], guid[5] = raw[5], raw[4]
+	guid[6], guid[7] = raw[7], raw[6]
+	return guid
+}
+
+// deriveGUID returns a random-looking version 4 GUID for the disk or one of its partitions
+func deriveGUID(diskID uint32, index int) [16]byte {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("iso9660 GPT %08x %d", diskID, index)))
+	var guid [16]byte
+	copy(guid[:], sum[:16])
+	guid[7] = guid[7]&0x0f | 0x40
+	guid[8] = guid[8]&0x3f | 0x80
+	return guid
+}
@@ -0,0 +1,125 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeHybrid writes an image with an EFI system partition image using the given options
+func writeHybrid(t *testing.T, opts HybridOptions) (data []byte, efiLocation uint32) {
+	w, err := NewWriterWithOptions(WriterOptions{FixedTimestamp: time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	require.NoError(t, w.AddFile(bytes.NewReader(make([]byte, 3*sectorSize+100)), "efi.img"))
+	require.NoError(t, w.AddFile(strings.NewReader("text"), "readme.txt"))
+	opts.EFIImagePath = "efi.img"
+	require.NoError(t, w.SetHybrid(&opts))
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "HYBRID"))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	report, err := img.Verify(WithFileData())
+	require.NoError(t, err)
+	assert.Empty(t, report.Findings)
+
+	efi, err := resolvePath(mustRoot(t, img), "EFI.IMG", false, false)
+	require.NoError(t, err)
+	return buf.Bytes(), uint32(efi.de.ExtentLocation)
+}
+
+func TestWriterHybridMBR(t *testing.T) {
+	code := bytes.Repeat([]byte{0x90}, 432)
+	data, efiLocation := writeHybrid(t, HybridOptions{BootCode: code, DiskID: 0x12345678})
+
+	mbr := data[:512]
+	assert.Equal(t, code, mbr[:432])
+	assert.Equal(t, uint32(0x12345678), binary.LittleEndian.Uint32(mbr[440:444]))
+	assert.Equal(t, []byte{0x55, 0xAA}, mbr[510:512])
+
+	whole := mbr[446:462]
+	assert.Equal(t, byte(0x80), whole[0])
+	assert.Equal(t, byte(mbrTypeHybrid), whole[4])
+	assert.Equal(t, uint32(0), binary.LittleEndian.Uint32(whole[8:12]))
+	assert.Equal(t, uint32(len(data)/512), binary.LittleEndian.Uint32(whole[12:16]))
+
+	efi := mbr[462:478]
+	assert.Equal(t, byte(mbrTypeEFI), efi[4])
+	assert.Equal(t, efiLocation*4, binary.LittleEndian.Uint32(efi[8:12]))
+	assert.Equal(t, uint32(13), binary.LittleEndian.Uint32(efi[12:16]))
+
+	// the rest of the system area is left empty
+	assert.True(t, isZero(data[512:systemAreaSize]))
+}
+
+func TestWriterHybridGPT(t *testing.T) {
+	data, efiLocation := writeHybrid(t, HybridOptions{GPT: true})
+	diskSectors := uint64(len(data) / 512)
+
+	mbr := data[:512]
+	assert.NotZero(t, binary.LittleEndian.Uint32(mbr[440:444]), "the disk ID is derived")
+	assert.Equal(t, byte(mbrTypeProtective), mbr[446+4])
+	assert.Equal(t, uint32(1), binary.LittleEndian.Uint32(mbr[446+8:]))
+	assert.Equal(t, uint32(diskSectors-1), binary.LittleEndian.Uint32(mbr[446+12:]))
+
+	checkHeader := func(lba uint64) []byte {
+		header := data[lba*512 : lba*512+512]
+		require.Equal(t, "EFI PART", string(header[:8]))
+		raw := append([]byte(nil), header[:gptHeaderSize]...)
+		binary.LittleEndian.PutUint32(raw[16:20], 0)
+		assert.Equal(t, crc32.ChecksumIEEE(raw), binary.LittleEndian.Uint32(header[16:20]))
+		assert.Equal(t, lba, binary.LittleEndian.Uint64(header[24:32]))
+		assert.Equal(t, uint64(34), binary.LittleEndian.Uint64(header[40:48]))
+		assert.Equal(t, diskSectors-34, binary.LittleEndian.Uint64(header[48:56]))
+
+		start := binary.LittleEndian.Uint64(header[72:80]) * 512
+		entries := data[start : start+gptEntryCount*gptEntrySize]
+		assert.Equal(t, crc32.ChecksumIEEE(entries), binary.LittleEndian.Uint32(header[88:92]))
+		return entries
+	}
+	primary := checkHeader(1)
+	backup := checkHeader(diskSectors - 1)
+	assert.Equal(t, primary, backup)
+	assert.Equal(t, uint64(diskSectors-1), binary.LittleEndian.Uint64(data[512+32:]))
+
+	assert.Equal(t, gptBasicDataType[:], primary[0:16])
+	assert.Equal(t, uint64(64), binary.LittleEndian.Uint64(primary[32:40]))
+	assert.Less(t, binary.LittleEndian.Uint64(primary[40:48]), diskSectors-34)
+	efi := primary[gptEntrySize : 2*gptEntrySize]
+	assert.Equal(t, gptEFISystemType[:], efi[0:16])
+	assert.Equal(t, uint64(efiLocation)*4, binary.LittleEndian.Uint64(efi[32:40]))
+	assert.Equal(t, uint64(efiLocation)*4+12, binary.LittleEndian.Uint64(efi[40:48]))
+
+	// the same options produce the same tables
+	again, _ := writeHybrid(t, HybridOptions{GPT: true})
+	assert.Equal(t, data, again)
+}
+
+func TestWriterHybridErrors(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	assert.Error(t, w.SetHybrid(&HybridOptions{BootCode: make([]byte, 441)}))
+
+	require.NoError(t, w.SetHybrid(&HybridOptions{EFIImagePath: "missing.img"}))
+	assert.Error(t, w.WriteTo(&bytes.Buffer{}, ""))
+
+	require.NoError(t, w.SetHybrid(&HybridOptions{}))
+	require.NoError(t, w.SetSystemArea([]byte{1}))
+	assert.Error(t, w.WriteTo(&bytes.Buffer{}, ""))
+
+	_, err = NewWriterWithOptions(WriterOptions{SystemArea: []byte{1}, Hybrid: &HybridOptions{}})
+	assert.Error(t, err)
+}
@@ -1,10 +1,12 @@
 package iso9660
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,15 +14,104 @@ import (
 type Image struct {
 	ra                io.ReaderAt
 	volumeDescriptors []volumeDescriptor
+	options           *ReaderOptions
+	cache             *sectorCache
+	// closer releases the image opened by OpenImageMmap or OpenImageFile
+	closer io.Closer
+	// primary is the index of the Primary Volume Descriptor which is read, -1 if there is none
+	primary  int
+	warnings *warningLog
+	// size is the number of bytes in the image, 0 if it cannot be told
+	size int64
 }
 
-// OpenImage returns an Image reader reating from a given file
+// ReaderOptions controls how an Image is read
+type ReaderOptions struct {
+	// SkipHidden excludes the entries with the existence (hidden) flag set from GetChildren
+	SkipHidden bool
+	// CacheSize is the number of bytes of the image to cache in memory, in whole sectors, 0 disables the cache.
+	// The cache serves the reads of volume descriptors, directories, path tables and continuation areas,
+	// but not those of file contents, so that copying large files doesn't evict the metadata.
+	// It is especially worth it when reading from a network or another source with high latency.
+	CacheSize int64
+	// VolumeDescriptorIndex selects the Primary Volume Descriptor to read by its index in the volume descriptor set,
+	// see Image.VolumeDescriptors. 0 selects the first valid one, which is also the first one in well-formed images.
+	VolumeDescriptorIndex int
+	// PreferEnhanced reads the hierarchy of the first valid Enhanced Volume Descriptor of ISO 9660:1999
+	// instead of the primary one, for its long names. Images without one are read through the selected Primary one.
+	PreferEnhanced bool
+	// PreferJoliet reads the hierarchy of the first valid Joliet Supplementary Volume Descriptor
+	// instead of the primary one, for its Unicode names. PreferEnhanced takes precedence over it.
+	PreferJoliet bool
+
+	// StrictSUSP makes the listing of a directory fail on the first System Use field with unreadable
+	// or invalid entries. By default they are skipped and recorded in Image.Warnings.
+	StrictSUSP bool
+	// MaxWarnings limits the number of warnings kept by Image.Warnings, 0 selects 1000
+	MaxWarnings int
+
+	// StreamDirectories reads directories one sector at a time into a reused buffer and keeps only their records.
+	// By default the extent of a directory is read with one ReadAt per MiB and kept in memory with the entries.
+	// Streaming saves memory on sparsely filled directories, at the cost of a ReadAt per sector.
+	StreamDirectories bool
+	// DiscardChildren makes GetAllChildren read the directory again on every call instead of keeping
+	// the children with the directory. Directories are always read on demand, but by default the entries
+	// listed stay in memory as long as their directory does, so walking a whole image holds all of them.
+	// Discarding them bounds the memory in use to the entries held by the caller, along with a CacheSize
+	// serving the directories read again.
+	DiscardChildren bool
+
+	// SessionStart is the first sector of the session to read on a multisession medium, such as the last one
+	// reported by the drive. Its volume descriptors follow the 16 sectors of its system area.
+	// The image must hold the whole medium, as the sessions record their locations from its beginning.
+	SessionStart uint32
+
+	// Size is the number of bytes of the image, for readers which cannot tell it themselves with a Size method
+	// or as an os.File, such as those of network backends. It is needed to tell whether the image is truncated.
+	Size int64
+
+	// Recover reads a damaged image as far as it can, see OpenImageRecover: the volume descriptors which cannot be
+	// decoded and the directory sectors which cannot be read are skipped, as are malformed directory records,
+	// and all of them are recorded in Image.Warnings. Directories are read one sector at a time then.
+	Recover bool
+}
+
+// OpenImage returns an Image reader reating from a given file.
+// Any io.ReaderAt works, such as a bytes.Reader or a ReadAheadReaderAt in front of a remote backend.
 func OpenImage(ra io.ReaderAt) (*Image, error) {
-	i := &Image{ra: ra}
+	return OpenImageWithOptions(ra, ReaderOptions{})
+}
+
+// OpenImageWithOptions returns an Image reader reading from a given file with the given options.
+// Dumps of raw 2352-byte CD sectors are detected and read through a RawSectorReader.
+func OpenImageWithOptions(ra io.ReaderAt, opts ReaderOptions) (*Image, error) {
+	if raw, ok := DetectRawSectors(ra); ok {
+		ra = raw
+	}
+	i := &Image{ra: ra, options: &opts, warnings: newWarningLog(opts.MaxWarnings)}
+	if size, ok := readerSize(ra); ok {
+		i.size = size
+	} else {
+		i.size = opts.Size
+	}
+	if cache := newSectorCache(ra, opts.CacheSize); cache != nil {
+		i.ra, i.cache = cache, cache
+	}
 
 	if err := i.readVolumes(); err != nil {
 		return nil, err
 	}
+	if err := i.selectPrimaryVolume(opts.VolumeDescriptorIndex); err != nil {
+		return nil, err
+	}
+	// the Joliet descriptors found invalid are not selected
+	i.checkJolietVolumes()
+	if opts.PreferJoliet {
+		i.selectJolietVolume()
+	}
+	if opts.PreferEnhanced {
+		i.selectEnhancedVolume()
+	}
 
 	return i, nil
 }
@@ -28,14 +119,32 @@ func OpenImage(ra io.ReaderAt) (*Image, error) {
 func (i *Image) readVolumes() error {
 	buffer := make([]byte, sectorSize)
 	// skip the 16 sectors of system area
-	for sector := 16; ; sector++ {
-		if _, err := i.ra.ReadAt(buffer, int64(sector)*int64(sectorSize)); err != nil {
+	for index := 0; ; index++ {
+		if _, err := i.ra.ReadAt(buffer, int64(i.descriptorSector(index))*int64(sectorSize)); err != nil {
+			if i.options.Recover && len(i.volumeDescriptors) > 0 {
+				i.warnings.add(ReaderWarning{LBA: i.descriptorSector(index), Reason: fmt.Sprintf("the volume descriptor set ends without a terminator: %v", err)})
+				return nil
+			}
 			return err
 		}
 
 		var vd volumeDescriptor
 		if err := vd.UnmarshalBinary(buffer); err != nil {
-			return err
+			if i.options.Recover && len(i.volumeDescriptors) > 0 && vd.Header.Identifier != standardIdentifierBytes {
+				i.warnings.add(ReaderWarning{LBA: i.descriptorSector(index), Reason: fmt.Sprintf("the volume descriptor set ends without a terminator: %v", err)})
+				return nil
+			}
+			if i.options.Recover && vd.Header.Identifier == standardIdentifierBytes {
+				// the descriptor keeps its place in the set, but isn't read
+				i.warnings.add(ReaderWarning{LBA: i.descriptorSector(index), Reason: fmt.Sprintf("skipping a damaged volume descriptor: %v", err)})
+				i.volumeDescriptors = append(i.volumeDescriptors, volumeDescriptor{Header: volumeDescriptorHeader{Type: volumeTypeDamaged}, problem: err.Error()})
+				continue
+			}
+			if vd.Type() != volumeTypeSupplementary {
+				return err
+			}
+			// the primary tree can still be read
+			vd.Primary, vd.problem = nil, err.Error()
 		}
 
 		// NOTE: the instance of the root Directory Record that appears
@@ -51,34 +160,197 @@ func (i *Image) readVolumes() error {
 	return nil
 }
 
+// descriptorSector returns the sector of the volume descriptor with the given index in the set
+func (i *Image) descriptorSector(index int) uint32 {
+	return i.options.SessionStart + 16 + uint32(index)
+}
+
+// CacheStats returns the hits and misses of the sector cache, which are zero if it's disabled
+func (i *Image) CacheStats() CacheStats {
+	if i.cache == nil {
+		return CacheStats{}
+	}
+	return i.cache.statistics()
+}
+
 // RootDir returns the File structure corresponding to the root directory
-// of the first primary volume
+// of the selected primary volume
 func (i *Image) RootDir() (*File, error) {
+	pvd, err := i.primaryVolume()
+	if err != nil {
+		return nil, err
+	}
+	joliet := i.volumeDescriptors[i.primary].joliet > 0
+	return &File{de: pvd.RootDirectoryEntry, ra: i.ra, options: i.options, children: nil, isRootDir: true, imageSize: i.size, warnings: i.warnings, joliet: joliet,
+		blockSize: i.LogicalBlockSize()}, nil
+}
+
+// primaryVolume returns the body of the selected Primary, Joliet or Enhanced Volume Descriptor
+func (i *Image) primaryVolume() (*PrimaryVolumeDescriptorBody, error) {
+	if i.primary < 0 || i.primary >= len(i.volumeDescriptors) {
+		return nil, os.ErrNotExist
+	}
+	if vd := i.volumeDescriptors[i.primary]; vd.Type() != volumeTypePrimary && !vd.isEnhanced() && vd.joliet == 0 {
+		return nil, os.ErrNotExist
+	}
+	return i.volumeDescriptors[i.primary].Primary, nil
+}
+
+// HasRockRidge reports whether the root directory of the selected primary volume
+// announces Rock Ridge through SUSP SP and ER entries, or uses it without an ER entry, see RockRidgeDetection.
+func (i *Image) HasRockRidge() (bool, error) {
+	detection, err := i.RockRidgeDetection()
+	return detection != RockRidgeNotDetected, err
+}
+
+// RockRidgeDetection tells how Rock Ridge was found to be in use. Without an ER entry in the root's "." record,
+// an RR entry or well-formed NM or PX entries in the records of the root directory are enough.
+// All of them require the SP entry of SUSP.
+func (i *Image) RockRidgeDetection() (RockRidgeDetection, error) {
+	root, err := i.RootDir()
+	if err != nil {
+		return RockRidgeNotDetected, err
+	}
+
+	dot, err := root.GetDotEntry()
+	if err != nil || dot == nil || !dot.hasRockRidge() {
+		return RockRidgeNotDetected, err
+	}
+	return dot.susp.RockRidgeDetection, nil
+}
+
+// RockRidgeRevision tells which revision of RRIP the image follows, from the identifier of its ER entry,
+// or without one from its entries, see RockRidgeRevision. It is RockRidgeRevisionUnknown if Rock Ridge isn't in use.
+// Both revisions are read alike, the entries they don't share are used where they are present.
+func (i *Image) RockRidgeRevision() (RockRidgeRevision, error) {
+	root, err := i.RootDir()
+	if err != nil {
+		return RockRidgeRevisionUnknown, err
+	}
+
+	dot, err := root.GetDotEntry()
+	if err != nil || dot == nil || !dot.hasRockRidge() {
+		return RockRidgeRevisionUnknown, err
+	}
+	return dot.susp.RockRidgeRevision, nil
+}
+
+// VolumeMetadata returns the descriptive fields of the selected Primary Volume Descriptor
+func (i *Image) VolumeMetadata() (VolumeMetadata, error) {
+	pvd, err := i.primaryVolume()
+	if err != nil {
+		return VolumeMetadata{}, err
+	}
+
+	return VolumeMetadata{
+		SystemIdentifier:            pvd.SystemIdentifier,
+		VolumeIdentifier:            pvd.VolumeIdentifier,
+		VolumeSetIdentifier:         pvd.VolumeSetIdentifier,
+		PublisherIdentifier:         pvd.PublisherIdentifier,
+		DataPreparerIdentifier:      pvd.DataPreparerIdentifier,
+		ApplicationIdentifier:       pvd.ApplicationIdentifier,
+		CopyrightFileIdentifier:     pvd.CopyrightFileIdentifier,
+		AbstractFileIdentifier:      pvd.AbstractFileIdentifier,
+		BibliographicFileIdentifier: pvd.BibliographicFileIdentifier,
+	}, nil
+}
+
+// VolumeTimes returns the dates of the selected Primary Volume Descriptor.
+// Unspecified dates are returned as zero times.
+func (i *Image) VolumeTimes() (VolumeTimes, error) {
+	pvd, err := i.primaryVolume()
+	if err != nil {
+		return VolumeTimes{}, err
+	}
+
+	return VolumeTimes{
+		Creation:     pvd.VolumeCreationDateAndTime.Time(),
+		Modification: pvd.VolumeModificationDateAndTime.Time(),
+		Expiration:   pvd.VolumeExpirationDateAndTime.Time(),
+		Effective:    pvd.VolumeEffectiveDateAndTime.Time(),
+	}, nil
+}
+
+// Extensions returns the identifiers of the extensions announced by SUSP ER entries
+// in the root directory, e.g. "RRIP_1991A". It returns nil if the image doesn't use SUSP.
+func (i *Image) Extensions() ([]string, error) {
+	records, err := i.ExtensionRecords()
+	if err != nil {
+		return nil, err
+	}
+	var identifiers []string
+	for _, er := range records {
+		identifiers = append(identifiers, er.Identifier)
+	}
+	return identifiers, nil
+}
+
+// ExtensionRecords returns the SUSP extensions announced by the ER entries of the root's "." record,
+// including Rock Ridge. It returns nil if the image doesn't use SUSP.
+func (i *Image) ExtensionRecords() ([]*ExtensionRecord, error) {
+	root, err := i.RootDir()
+	if err != nil {
+		return nil, err
+	}
+
+	dot, err := root.GetDotEntry()
+	if err != nil || dot == nil || dot.susp == nil {
+		return nil, err
+	}
+	return dot.de.SystemUseEntries.GetExtensionRecords()
+}
+
+// BootCatalogLocation returns the sector of the El Torito boot catalog.
+// The last return value is false if the image has no El Torito Boot Record.
+func (i *Image) BootCatalogLocation() (uint32, bool) {
 	for _, vd := range i.volumeDescriptors {
-		if vd.Type() == volumeTypePrimary {
-			return &File{de: vd.Primary.RootDirectoryEntry, ra: i.ra, children: nil, isRootDir: true}, nil
+		if vd.Type() == volumeTypeBoot && vd.Boot.BootSystemIdentifier == elToritoSystemIdentifier {
+			return binary.LittleEndian.Uint32(vd.Boot.BootSystemUse[0:4]), true
 		}
 	}
-	return nil, os.ErrNotExist
+	return 0, false
 }
 
-// RootDir returns the label of the first Primary Volume
+// Label returns the label of the selected Primary Volume
 func (i *Image) Label() (string, error) {
-	for _, vd := range i.volumeDescriptors {
-		if vd.Type() == volumeTypePrimary {
-			return string(vd.Primary.VolumeIdentifier), nil
-		}
+	pvd, err := i.primaryVolume()
+	if err != nil {
+		return "", err
 	}
-	return "", os.ErrNotExist
+	return pvd.VolumeIdentifier, nil
 }
 
-// File is a os.FileInfo-compatible wrapper around an ISO9660 directory entry
+// File is a os.FileInfo-compatible wrapper around an ISO9660 directory entry.
+// The entries of an image may be listed and read by several goroutines at once,
+// provided that the ReaderAt of the image supports concurrent calls, like *os.File does.
+// The root directory learns whether the image uses SUSP when it is first listed,
+// so its own metadata should only be read after that.
 type File struct {
-	ra        io.ReaderAt
-	de        *DirectoryEntry
-	children  []*File
-	isRootDir bool
-	susp      *SUSPMetadata
+	ra io.ReaderAt
+	de *DirectoryEntry
+	// childrenMu guards the children, which are read on first use, so that they can be listed concurrently
+	childrenMu sync.Mutex
+	children   []*File
+	isRootDir  bool
+	susp       *SUSPMetadata
+	options    *ReaderOptions
+	// imageSize is the number of bytes in the image, 0 if it cannot be told
+	imageSize int64
+	// blockSize is the logical block size of the volume, 0 for the size of a sector, see logicalBlockSize
+	blockSize int64
+	// parent is the directory listing the file, nil for the root
+	parent   *File
+	warnings *warningLog
+	// joliet marks the entries of a Joliet hierarchy, whose identifiers are decoded from UCS-2
+	joliet bool
+	// sections are the records of the following extents of a file with more than one, see IsMultiExtent
+	sections []*DirectoryEntry
+	// record is the position of the directory record in the image, in bytes, 0 for the root
+	record int64
+	// xar is the Extended Attribute Record, read on first use, see ExtendedAttributes
+	xarOnce sync.Once
+	xar     *ExtendedAttributeRecord
+	xarErr  error
 }
 
 var _ os.FileInfo = &File{}
@@ -98,12 +370,18 @@ func (f *File) IsDir() bool {
 	return f.de.FileFlags&dirFlagDir != 0
 }
 
+// IsHidden returns true if the entry has the existence flag set,
+// which asks for it to be hidden from the user
+func (f *File) IsHidden() bool {
+	return f.de.FileFlags&dirFlagHidden != 0
+}
+
 // ModTime returns the entry's recording time
 func (f *File) ModTime() time.Time {
 	return time.Time(f.de.RecordingDateTime)
 }
 
-// Mode returns file mode when available.
+// Mode returns file mode when available, from Rock Ridge or else from an Extended Attribute Record.
 // Otherwise it returns os.FileMode flag set with the os.ModeDir flag enabled in case of directories.
 func (f *File) Mode() os.FileMode {
 	if f.hasRockRidge() {
@@ -113,12 +391,109 @@ func (f *File) Mode() os.FileMode {
 	}
 
 	var mode os.FileMode
+	if xar := f.extendedAttributes(); xar != nil {
+		mode = xar.Mode()
+	}
 	if f.IsDir() {
 		mode |= os.ModeDir
 	}
 	return mode
 }
 
+// DeviceNumber returns the major and minor numbers of a block or character device.
+// The last return value is false if the entry isn't a device or has no Rock Ridge PN entry.
+func (f *File) DeviceNumber() (major, minor uint32, ok bool) {
+	if !f.hasRockRidge() || f.Mode()&os.ModeDevice == 0 {
+		return 0, 0, false
+	}
+	major, minor, err := f.de.SystemUseEntries.GetDeviceNumber()
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// Owner returns the user and group IDs of the entry, from its Rock Ridge PX entry or its Extended Attribute Record.
+// The last return value is false if the entry has neither.
+func (f *File) Owner() (uid, gid uint32, ok bool) {
+	if xar := f.extendedAttributes(); xar != nil {
+		return uint32(xar.OwnerID), uint32(xar.GroupID), true
+	}
+	if !f.hasRockRidge() {
+		return 0, 0, false
+	}
+	px, err := f.de.SystemUseEntries.getPosixEntry()
+	if err != nil {
+		return 0, 0, false
+	}
+	return px.uid, px.gid, true
+}
+
+// Links returns the number of hard links to the entry recorded in its Rock Ridge PX entry, or 1 without one
+func (f *File) Links() uint32 {
+	if !f.hasRockRidge() {
+		return 1
+	}
+	px, err := f.de.SystemUseEntries.getPosixEntry()
+	if err != nil || px.nlink == 0 {
+		return 1
+	}
+	return px.nlink
+}
+
+// SerialNumber returns the file serial number recorded in the Rock Ridge PX entry, which only RRIP 1.12 records.
+// The last return value is false if there is none.
+func (f *File) SerialNumber() (uint32, bool) {
+	if !f.hasRockRidge() {
+		return 0, false
+	}
+	px, err := f.de.SystemUseEntries.getPosixEntry()
+	if err != nil || !px.hasSerial {
+		return 0, false
+	}
+	return px.serial, true
+}
+
+// HardLinkID identifies the data of a regular file with several hard links, which is the first sector of its data.
+// Files with the same ID are hard links to each other, so extracting them can recreate the links.
+// The last return value is false if the file is empty or isn't known to have other links.
+func (f *File) HardLinkID() (uint32, bool) {
+	if !f.Mode().IsRegular() || f.Links() < 2 || f.dataLength() == 0 {
+		return 0, false
+	}
+	return uint32(f.de.ExtentLocation), true
+}
+
+// FileID returns a number identifying the entry within its hierarchy, which is the same every time the image is opened,
+// such as for the inode numbers of a FUSE filesystem or the file handles of an NFS export.
+// It is the position in bytes of the directory record of a file, and that of the "." record for a directory,
+// so that the "." and ".." entries share the IDs of their directories. Hard links to the same data,
+// as told by HardLinkID, share the position of the data. The Joliet and primary records of a file have different IDs.
+func (f *File) FileID() uint64 {
+	if f.IsDir() {
+		return uint64(f.de.ExtentLocation) * uint64(f.logicalBlockSize())
+	}
+	if lba, ok := f.HardLinkID(); ok {
+		return uint64(lba) * uint64(f.logicalBlockSize())
+	}
+	return uint64(f.record)
+}
+
+// SymlinkTarget returns the target of a symbolic link
+// or an empty string if the entry isn't one.
+func (f *File) SymlinkTarget() string {
+	if f.Mode()&os.ModeSymlink == 0 {
+		return ""
+	}
+	return f.de.SystemUseEntries.GetSymlinkTarget()
+}
+
+// Identifier returns the identifier of the entry's directory record as it is stored in the image,
+// including the version of file identifiers if there's one, e.g. "README.TXT;1".
+func (f *File) Identifier() string {
+	return f.de.Identifier
+}
+
 // Name returns the base name of the given entry
 func (f *File) Name() string {
 	if f.hasRockRidge() {
@@ -133,29 +508,54 @@ func (f *File) Name() string {
 
 	// drop the version part
 	// assume only one ';'
-	fileIdentifier := strings.Split(f.de.Identifier, ";")[0]
+	fileIdentifier, _, _ := strings.Cut(f.de.Identifier, ";")
 
 	// split into filename and extension
 	// assume only only one '.'
-	splitFileIdentifier := strings.Split(fileIdentifier, ".")
+	name, extension, found := strings.Cut(fileIdentifier, ".")
 
 	// there's no dot in the name, thus no extension
-	if len(splitFileIdentifier) == 1 {
-		return splitFileIdentifier[0]
+	if !found {
+		return name
 	}
 
 	// extension is empty, return just the name without a dot
-	if len(splitFileIdentifier[1]) == 0 {
-		return splitFileIdentifier[0]
+	if extension, _, _ = strings.Cut(extension, "."); len(extension) == 0 {
+		return name
 	}
 
 	// return file with extension
 	return fileIdentifier
 }
 
-// Size returns the size in bytes of the extent occupied by the file or directory
+// Size returns the size in bytes of the extents occupied by the file or directory.
+// For zisofs-compressed files it returns the uncompressed size, for sparse files the size including the holes.
 func (f *File) Size() int64 {
-	return int64(f.de.ExtentLength)
+	if sf := f.sparseInfo(); sf != nil {
+		return int64(sf.size)
+	}
+	if zf := f.zisofsInfo(); zf != nil {
+		return int64(zf.uncompressedSize)
+	}
+	return f.dataLength()
+}
+
+// zisofsInfo returns the parameters of zisofs compression or nil if the file isn't compressed
+func (f *File) zisofsInfo() *zisofsInfo {
+	if !f.hasRockRidge() || f.IsDir() {
+		return nil
+	}
+	zf, err := f.de.SystemUseEntries.getZisofsInfo()
+	if err != nil {
+		return nil
+	}
+	return zf
+}
+
+// SystemUseEntries returns the SUSP entries of the file's directory record, including those in continuation areas.
+// It returns nil if the image doesn't use SUSP.
+func (f *File) SystemUseEntries() SystemUseEntrySlice {
+	return f.de.SystemUseEntries
 }
 
 // Sys returns nil
@@ -166,103 +566,360 @@ func (f *File) Sys() interface{} {
 // GetAllChildren returns the children entries in case of a directory
 // or an error in case of a file. It includes the "." and ".." entries.
 func (f *File) GetAllChildren() ([]*File, error) {
+	// the root directory's SUSP metadata is only set while listing it
+	f.childrenMu.Lock()
+	defer f.childrenMu.Unlock()
 	if !f.IsDir() {
 		return nil, fmt.Errorf("%s is not a directory", f.Name())
 	}
-
 	if f.children != nil {
 		return f.children, nil
 	}
 
-	baseOffset := uint32(f.de.ExtentLocation) * sectorSize
-
-	buffer := make([]byte, sectorSize)
-	for bytesProcessed := uint32(0); bytesProcessed < uint32(f.de.ExtentLength); bytesProcessed += sectorSize {
-		if _, err := f.ra.ReadAt(buffer, int64(baseOffset+bytesProcessed)); err != nil {
-			return nil, nil
+	// The records and their System Use fields are slices of the data read,
+	// so it stays in memory as long as any of the children is in use.
+	// They are counted first, so that the entries can be allocated together.
+	records, count, err := f.readDirectoryRecords()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]DirectoryEntry, count)
+	files := make([]File, count)
+	children := make([]*File, 0, count)
+
+	err = records(func(offset int, record []byte) error {
+		var previous *File
+		if n := len(children); n > 0 {
+			previous = children[n-1]
+		}
+		child, err := f.decodeChild(offset, record, &entries[len(children)], &files[len(children)], previous)
+		if err != nil || child == nil {
+			return err
+		}
+		children = append(children, child)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if f.isRootDir && f.susp != nil {
+		if err := f.detectRockRidge(children); err != nil {
+			return nil, err
 		}
+	}
 
-		for i := uint32(0); i < sectorSize; {
-			entryLength := uint32(buffer[i])
-			if entryLength == 0 {
-				break
-			}
+	if f.options != nil && f.options.DiscardChildren {
+		return children, nil
+	}
+	f.children = children
+	return f.children, nil
+}
 
-			if i+entryLength > sectorSize {
-				return nil, fmt.Errorf("reading directory entries: DE outside of sector boundries")
+// decodeChild decodes the record at the given offset of the directory's extent into newDE and newFile.
+// It returns nil if the record continues the last extent of previous, or is skipped while recovering.
+func (f *File) decodeChild(offset int, record []byte, newDE *DirectoryEntry, newFile *File, previous *File) (*File, error) {
+	if err := newDE.unmarshalShared(record); err != nil {
+		if f.recovering() {
+			f.warnings.add(ReaderWarning{Path: f.recordPath(), LBA: uint32((f.de.dataOffset(f.logicalBlockSize()) + int64(offset)) / f.logicalBlockSize()),
+				Reason: fmt.Sprintf("skipping a malformed directory record: %v", err)})
+			return nil, nil
+		}
+		return nil, err
+	}
+	if f.joliet && newDE.Identifier != string([]byte{0}) && newDE.Identifier != string([]byte{1}) {
+		newDE.Identifier = decodeJolietIdentifier(newDE.Identifier)
+	}
+	// the records of the following extents of a file continue the previous record
+	if previous != nil {
+		if last := previous.records()[len(previous.sections)]; last.FileFlags&dirFlagMultiExtent != 0 && last.Identifier == newDE.Identifier {
+			section := *newDE
+			previous.sections = append(previous.sections, &section)
+			return nil, nil
+		}
+	}
+	*newFile = File{ra: f.ra,
+		de:       newDE,
+		children: nil,
+		options:  f.options,
+		// the image is shared by all the entries
+		imageSize: f.imageSize,
+		blockSize: f.blockSize,
+		parent:    f,
+		warnings:  f.warnings,
+		joliet:    f.joliet,
+		record:    f.de.dataOffset(f.logicalBlockSize()) + int64(offset),
+	}
+	report := func(err error) error {
+		return f.reportSystemUse(newFile, offset, err)
+	}
+	parse := func(systemUse []byte) error {
+		var anomalies []suspAnomaly
+		newDE.SystemUseEntries, anomalies = parseSystemUse(systemUse, f.ra, f.logicalBlockSize())
+		for _, a := range anomalies {
+			if err := report(a.err); err != nil {
+				return err
 			}
+		}
+		return nil
+	}
 
-			newDE := &DirectoryEntry{}
-			if err := newDE.UnmarshalBinary(buffer[i : i+entryLength]); err != nil {
-				return nil, err
-			}
+	// Is this a root directory '.' record?
+	if f.isRootDir && newDE.Identifier == string([]byte{0}) {
+		if err := parse(newDE.SystemUse); err != nil {
+			return nil, err
+		}
 
-			// Is this a root directory '.' record?
-			if f.isRootDir && newDE.Identifier == string([]byte{0}) {
-				newDE.SystemUseEntries, _ = splitSystemUseEntries(newDE.SystemUse, f.ra)
-
-				// get the SP record
-				if len(newDE.SystemUseEntries) > 0 && newDE.SystemUseEntries[0].Type() == "SP" {
-					sprecord, err := SPRecordDecode(newDE.SystemUseEntries[0])
-					if err != nil {
-						return nil, fmt.Errorf("invalid SP record: %w", err)
-					}
-
-					hasRockRidge, err := suspHasRockRidge(newDE.SystemUseEntries)
-					if err != nil {
-						return nil, fmt.Errorf("failed to check for Rock Ridge extension: %w", err)
-					}
-
-					// save SUSP offset from the SP record
-					f.susp = &SUSPMetadata{
-						Offset:       sprecord.BytesSkipped,
-						HasRockRidge: hasRockRidge,
-					}
+		// get the SP record
+		if len(newDE.SystemUseEntries) > 0 && newDE.SystemUseEntries[0].Type() == "SP" {
+			sprecord, err := SPRecordDecode(newDE.SystemUseEntries[0])
+			if err != nil {
+				// without it, the image is read without SUSP
+				if err := report(fmt.Errorf("invalid SP record: %w", err)); err != nil {
+					return nil, err
 				}
 			} else {
-				// are we on a volume with SUSP?
-				if f.susp != nil {
-					// Ignore error if some of the SUSP data is malformed. Just take the valid part.
-					offsetSystemUse := newDE.SystemUse[f.susp.Offset:]
-					newDE.SystemUseEntries, _ = splitSystemUseEntries(offsetSystemUse, f.ra)
+				// save SUSP offset from the SP record, Rock Ridge is detected once all the records are read
+				f.susp = &SUSPMetadata{Offset: sprecord.BytesSkipped}
+			}
+		}
+	} else {
+		// are we on a volume with SUSP?
+		if f.susp != nil {
+			if int(f.susp.Offset) > len(newDE.SystemUse) {
+				if err := report(fmt.Errorf("the System Use field is shorter than the %d bytes skipped by SUSP", f.susp.Offset)); err != nil {
+					return nil, err
 				}
+			} else if err := parse(newDE.SystemUse[f.susp.Offset:]); err != nil {
+				return nil, err
 			}
+		}
 
-			i += entryLength
-
-			newFile := &File{ra: f.ra,
-				de:       newDE,
-				children: nil,
-				susp:     f.susp.Clone(),
+		if f.hasRockRidge() {
+			if err := resolveRelocation(newDE, f.ra, f.logicalBlockSize()); err != nil {
+				if !f.recovering() {
+					return nil, err
+				}
+				f.warnings.add(ReaderWarning{Path: newFile.recordPath(), Reason: err.Error()})
 			}
-
-			f.children = append(f.children, newFile)
 		}
 	}
 
-	return f.children, nil
+	// the metadata is never modified, so all the entries of a directory share it
+	newFile.susp = f.susp
+	return newFile, nil
 }
 
 // GetChildren returns the children entries in case of a directory
 // or an error in case of a file. It does NOT include the "." and ".." entries.
+// Hidden entries are excluded if the image was opened with ReaderOptions.SkipHidden.
 func (f *File) GetChildren() ([]*File, error) {
+	return f.getChildren(f.options == nil || !f.options.SkipHidden)
+}
+
+func (f *File) getChildren(includeHidden bool) ([]*File, error) {
 	children, err := f.GetAllChildren()
 	if err != nil {
 		return nil, err
 	}
 
-	filteredChildren := make([]*File, 0, len(children)-2)
+	filteredChildren := make([]*File, 0, len(children))
 	for _, child := range children {
-		if child.de.Identifier == string([]byte{0}) || child.de.Identifier == string([]byte{1}) {
-			continue
+		if f.listsChild(child, includeHidden) {
+			filteredChildren = append(filteredChildren, child)
 		}
-
-		filteredChildren = append(filteredChildren, child)
 	}
 
 	return filteredChildren, nil
 }
 
+// listsChild reports whether GetChildren lists the child, which is neither the "." and ".." entries nor a relocated directory
+func (f *File) listsChild(child *File, includeHidden bool) bool {
+	if child.de.Identifier == string([]byte{0}) || child.de.Identifier == string([]byte{1}) {
+		return false
+	}
+	if !includeHidden && child.IsHidden() {
+		return false
+	}
+	// relocated directories are listed in their original place
+	return !child.hasRockRidge() || !child.de.SystemUseEntries.isRelocated() && !(f.isRootDir && child.isRelocationDirectory())
+}
+
+// isRelocationDirectory reports whether the entry is the RR_MOVED directory created by Rock Ridge deep directory relocation,
+// which only holds relocated directories
+func (f *File) isRelocationDirectory() bool {
+	if !strings.EqualFold(f.de.Identifier, relocationDirectoryIdentifier) || !f.IsDir() {
+		return false
+	}
+
+	children, err := f.GetAllChildren()
+	if err != nil || len(children) <= 2 {
+		return false
+	}
+	for _, c := range children[2:] {
+		if !c.de.SystemUseEntries.isRelocated() {
+			return false
+		}
+	}
+	return true
+}
+
+// directoryRecords calls fn with every record of a directory and its offset in the extent
+type directoryRecords func(fn func(offset int, record []byte) error) error
+
+// readDirectoryRecords reads the records of the directory and returns them with their number.
+// The extent is read at once, or one sector at a time with ReaderOptions.StreamDirectories or Recover.
+func (f *File) readDirectoryRecords() (directoryRecords, int, error) {
+	if f.recovering() {
+		return f.recoverDirectoryRecords()
+	}
+	if f.options != nil && f.options.StreamDirectories {
+		return streamDirectoryRecords(f.ra, f.de, f.logicalBlockSize())
+	}
+
+	extent, err := readDirectoryExtent(f.ra, f.de, f.logicalBlockSize())
+	if err != nil {
+		return nil, 0, err
+	}
+	count := 0
+	err = forEachDirectoryRecord(extent, func(int, []byte) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return func(fn func(offset int, record []byte) error) error {
+		return forEachDirectoryRecord(extent, fn)
+	}, count, nil
+}
+
+// streamDirectoryRecords reads the extent of a directory one sector at a time into the same buffer
+// and copies the records out of it, leaving out the zeroes at the ends of the sectors
+func streamDirectoryRecords(ra io.ReaderAt, de *DirectoryEntry, blockSize int64) (directoryRecords, int, error) {
+	sectors := int(fileLengthToSectors(de.ExtentLength))
+	location := de.dataOffset(blockSize)
+	buffer := make([]byte, sectorSize)
+
+	var packed []byte
+	var offsets []int
+	for sector := 0; sector < sectors; sector++ {
+		if _, err := ra.ReadAt(buffer, location+int64(sector)*int64(sectorSize)); err != nil {
+			return nil, 0, err
+		}
+		err := forEachDirectoryRecord(buffer, func(offset int, record []byte) error {
+			offsets = append(offsets, sector*int(sectorSize)+offset)
+			packed = append(packed, record...)
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return func(fn func(offset int, record []byte) error) error {
+		start := 0
+		for _, offset := range offsets {
+			end := start + int(packed[start])
+			if err := fn(offset, packed[start:end]); err != nil {
+				return err
+			}
+			start = end
+		}
+		return nil
+	}, len(offsets), nil
+}
+
+// readDirectoryExtent reads the extent of a directory in chunks,
+// so that a corrupted length cannot make it allocate more than the image holds
+func readDirectoryExtent(ra io.ReaderAt, de *DirectoryEntry, blockSize int64) ([]byte, error) {
+	const chunkSize = 1024 * 1024
+	size := int64(fileLengthToSectors(de.ExtentLength)) * int64(sectorSize)
+	offset := de.dataOffset(blockSize)
+
+	capacity := size
+	if capacity > chunkSize {
+		capacity = chunkSize
+	}
+	extent := make([]byte, 0, capacity)
+	for int64(len(extent)) < size {
+		n := size - int64(len(extent))
+		if n > chunkSize {
+			n = chunkSize
+		}
+		start := len(extent)
+		extent = append(extent, make([]byte, n)...)
+		if _, err := ra.ReadAt(extent[start:], offset+int64(start)); err != nil {
+			return nil, err
+		}
+	}
+	return extent, nil
+}
+
+// forEachDirectoryRecord calls fn with every directory record in an extent.
+// Records don't cross sector boundaries, the rest of a sector after the last one is zero.
+func forEachDirectoryRecord(extent []byte, fn func(offset int, record []byte) error) error {
+	for sector := 0; sector < len(extent); sector += int(sectorSize) {
+		buffer := extent[sector : sector+int(sectorSize)]
+		for i := uint32(0); i < sectorSize; {
+			entryLength := uint32(buffer[i])
+			if entryLength == 0 {
+				break
+			}
+
+			if i+entryLength > sectorSize {
+				return fmt.Errorf("reading directory entries: %w: DE outside of sector boundries", ErrTruncatedRecord)
+			}
+
+			if err := fn(sector+int(i), buffer[i:i+entryLength]); err != nil {
+				return err
+			}
+			i += entryLength
+		}
+	}
+	return nil
+}
+
+// resolveRelocation points a CL entry's record at the relocated directory
+// and the ".." record of a relocated directory at its original parent, see RRIP 4.1.5
+func resolveRelocation(de *DirectoryEntry, ra io.ReaderAt, blockSize int64) error {
+	signature := "CL"
+	if de.Identifier == string([]byte{1}) {
+		signature = "PL"
+	}
+
+	location, found, err := de.SystemUseEntries.getLocationEntry(signature)
+	if err != nil || !found {
+		return err
+	}
+
+	dot, err := readDotEntry(ra, location, blockSize)
+	if err != nil {
+		return fmt.Errorf("following the %s entry of %q: %w", signature, de.Identifier, err)
+	}
+
+	de.ExtentLocation = dot.ExtentLocation
+	de.ExtentLength = dot.ExtentLength
+	de.FileFlags |= dirFlagDir
+	return nil
+}
+
+// readDotEntry reads the "." record of the directory at the given logical block
+func readDotEntry(ra io.ReaderAt, location uint32, blockSize int64) (*DirectoryEntry, error) {
+	buffer := make([]byte, sectorSize)
+	if _, err := ra.ReadAt(buffer, int64(location)*blockSize); err != nil {
+		return nil, err
+	}
+
+	dot := &DirectoryEntry{}
+	if err := dot.UnmarshalBinary(buffer[:buffer[0]]); err != nil {
+		return nil, err
+	}
+	if dot.Identifier != string([]byte{0}) {
+		return nil, fmt.Errorf("sector %d doesn't start with a directory", location)
+	}
+	return dot, nil
+}
+
 // GetDotEntry returns the "." entry of a directory
 // or an error in case of a file.
 func (f *File) GetDotEntry() (*File, error) {
@@ -281,12 +938,85 @@ func (f *File) GetDotEntry() (*File, error) {
 }
 
 // Reader returns a reader that allows to read the file's data.
+// zisofs-compressed files are decompressed transparently.
 // If File is a directory, it returns nil.
+// The reads fail with the error of OpenReader if the data cannot be read at all.
+//
+// The reader of an uncompressed file implements io.WriterTo, so io.Copy reads the data in chunks of 1 MiB.
+// On Linux, the kernel copies the data if the image was opened from an *os.File and the destination is a regular file.
 func (f *File) Reader() io.Reader {
 	if f.IsDir() {
 		return nil
 	}
+	r, err := f.OpenReader()
+	if err != nil {
+		return &errorReader{err: err}
+	}
+	return r
+}
+
+// OpenReader returns the reader of Reader, but fails upfront if the data cannot be read:
+// with an *ExtentOutOfRangeError if the extent lies beyond the end of a truncated image,
+// or if the header of a zisofs-compressed file is invalid.
+func (f *File) OpenReader() (io.Reader, error) {
+	return f.OpenReaderAt()
+}
+
+// FileReader reads the data of a file sequentially or at random, see OpenReaderAt
+type FileReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	// Size returns the size of the data, which is uncompressed for zisofs-compressed files
+	// and includes the holes of sparse files
+	Size() int64
+}
+
+// OpenReaderAt returns the reader of OpenReader, which also reads at random and seeks, failing like OpenReader.
+// Only the data read is fetched, so the header of a large file can be inspected without reading all of it.
+// zisofs-compressed files are decompressed a block at a time, the holes of sparse files read as zeroes.
+func (f *File) OpenReaderAt() (FileReader, error) {
+	if f.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", f.Name())
+	}
+	if err := f.checkExtent(); err != nil {
+		return nil, err
+	}
+
+	if sf := f.sparseInfo(); sf != nil {
+		if f.logicalBlockSize() != int64(sectorSize) {
+			return nil, fmt.Errorf("%s: sparse files are only read from images with %d-byte logical blocks", f.Name(), sectorSize)
+		}
+		sr, err := newSparseReader(bypassCache(f.ra), uint32(f.de.ExtentLocation), sf)
+		if err != nil {
+			return nil, err
+		}
+		return sr, nil
+	}
+
+	var extent *fileReader
+	if len(f.sections) > 0 {
+		extent = newFileReader(f.dataReaderAt(bypassCache(f.ra)), 0, f.dataLength())
+	} else {
+		extent = newFileReader(bypassCache(f.ra), f.de.dataOffset(f.logicalBlockSize()), int64(f.de.ExtentLength))
+	}
+
+	if zf := f.zisofsInfo(); zf != nil {
+		zr, err := newZisofsReader(extent.SectionReader, zf)
+		if err != nil {
+			return nil, err
+		}
+		return zr, nil
+	}
+
+	return extent, nil
+}
+
+// errorReader is returned by Reader when the file's data cannot be read at all
+type errorReader struct {
+	err error
+}
 
-	baseOffset := int64(f.de.ExtentLocation) * int64(sectorSize)
-	return io.NewSectionReader(f.ra, baseOffset, int64(f.de.ExtentLength))
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
 }
@@ -4,13 +4,18 @@
 package iso9660
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const loremIpsum = `Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat. Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim id est laborum.
@@ -120,6 +125,63 @@ func TestImage(t *testing.T) {
 	assert.Error(t, os.ErrNotExist, err)
 }
 
+func TestImageHasRockRidge(t *testing.T) {
+	for fixture, expected := range map[string]bool{
+		"fixtures/test.iso":           false,
+		"fixtures/test_rockridge.iso": true,
+	} {
+		f, err := os.Open(fixture)
+		assert.NoError(t, err)
+		defer f.Close() // nolint: errcheck
+
+		image, err := OpenImage(f)
+		assert.NoError(t, err)
+		hasRockRidge, err := image.HasRockRidge()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, hasRockRidge, fixture)
+	}
+}
+
+func TestImageMetadata(t *testing.T) {
+	for fixture, extensions := range map[string][]string{
+		"fixtures/test.iso":           nil,
+		"fixtures/test_rockridge.iso": {"RRIP_1991A"},
+	} {
+		f, err := os.Open(fixture)
+		require.NoError(t, err)
+		defer f.Close() // nolint: errcheck
+
+		image, err := OpenImage(f)
+		require.NoError(t, err)
+
+		found, err := image.Extensions()
+		assert.NoError(t, err)
+		assert.Equal(t, extensions, found, fixture)
+
+		_, hasBootCatalog := image.BootCatalogLocation()
+		assert.False(t, hasBootCatalog, fixture)
+	}
+
+	f, err := os.Open("fixtures/test.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	image, err := OpenImage(f)
+	require.NoError(t, err)
+	volume, err := image.VolumeMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, "my-vol-id", volume.VolumeIdentifier)
+	assert.Equal(t, "test-volset-id", volume.VolumeSetIdentifier)
+	assert.Equal(t, "gopher", volume.PublisherIdentifier)
+
+	root, err := image.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	_, _, ok := children[0].Owner()
+	assert.False(t, ok)
+}
+
 func TestImageReaderSUSP(t *testing.T) {
 	f, err := os.Open("fixtures/test_rockridge.iso")
 	assert.NoError(t, err)
@@ -152,6 +214,7 @@ func TestImageReaderSUSP(t *testing.T) {
 	symlink := children[4]
 	assert.Equal(t, "this-is-a-symlink", symlink.Name())
 	assert.Equal(t, os.ModeSymlink, symlink.Mode()&os.ModeSymlink)
+	assert.Equal(t, "/usr/share/some-random-directory/even-deeper-path/symlink-target", symlink.SymlinkTarget())
 
 	dir1 := children[1]
 	assert.Equal(t, "dir1", dir1.Name())
@@ -167,6 +230,11 @@ func TestImageReaderSUSP(t *testing.T) {
 	assert.Equal(t, fs.FileMode(0640), loremFile.Mode().Perm(), "expected mode %o, got %o", 0640, loremFile.Mode().Perm())
 	assert.NotNil(t, loremFile.susp)
 	assert.True(t, loremFile.susp.HasRockRidge)
+	assert.Empty(t, loremFile.SymlinkTarget())
+	uid, gid, ok := loremFile.Owner()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1000), uid)
+	assert.Equal(t, uint32(1000), gid)
 
 	data, err := io.ReadAll(loremFile.Reader())
 	assert.NoError(t, err)
@@ -177,4 +245,268 @@ func TestImageReaderSUSP(t *testing.T) {
 	assert.Equal(t, "RR", loremFile.de.SystemUseEntries[0].Type())
 	assert.Equal(t, "PX", loremFile.de.SystemUseEntries[2].Type())
 	assert.Equal(t, "TF", loremFile.de.SystemUseEntries[3].Type())
+	flags, ok := loremFile.SystemUseEntries().GetRockRidgeFlags()
+	assert.True(t, ok)
+	assert.Equal(t, RockRidgeFlagPX|RockRidgeFlagNM|RockRidgeFlagTF, flags)
+	assert.Equal(t, RockRidge109, loremFile.susp.RockRidgeRevision)
+}
+
+// benchmarkImage builds a Rock Ridge image with the given number of files spread over 100 directories
+func benchmarkImage(b *testing.B, files int) *Image {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(b, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	for f := 0; f < files; f++ {
+		require.NoError(b, w.AddFile(strings.NewReader("x"), fmt.Sprintf("directory-%d/file-with-a-long-name-%d.txt", f%100, f)))
+	}
+	var buf bytes.Buffer
+	require.NoError(b, w.WriteTo(&buf, "bench"))
+
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(b, err)
+	return img
+}
+
+func BenchmarkWalkLargeImage(b *testing.B) {
+	img := benchmarkImage(b, 50000)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		root, err := img.RootDir()
+		require.NoError(b, err)
+
+		var walk func(dir *File)
+		walk = func(dir *File) {
+			children, err := dir.GetChildren()
+			require.NoError(b, err)
+			for _, c := range children {
+				_ = c.Name()
+				_ = c.Mode()
+				_ = c.Size()
+				if c.IsDir() {
+					walk(c)
+				}
+			}
+		}
+		walk(root)
+	}
+}
+
+func BenchmarkGetChildren(b *testing.B) {
+	img := benchmarkImage(b, 5000)
+	root, err := img.RootDir()
+	require.NoError(b, err)
+	children, err := root.GetChildren()
+	require.NoError(b, err)
+	dir := children[0]
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		// a fresh File doesn't have its children cached
+		fresh := uncachedCopy(dir)
+		entries, err := fresh.GetChildren()
+		require.NoError(b, err)
+		require.Len(b, entries, 50)
+	}
+}
+
+func TestImageConcurrentReads(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	for f := 0; f < 40; f++ {
+		require.NoError(t, w.AddFile(strings.NewReader(strings.Repeat(fmt.Sprint(f), 1000)), fmt.Sprintf("dir-%d/file-%d.txt", f%4, f)))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "concurrent"))
+
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+
+	// the goroutines share the entries of the image, whose children are read by whichever comes first
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- func() error {
+				dirs, err := root.GetChildren()
+				if err != nil {
+					return err
+				}
+				for _, dir := range dirs {
+					files, err := dir.GetChildren()
+					if err != nil {
+						return err
+					}
+					for _, f := range files {
+						reader, err := f.OpenReader()
+						if err != nil {
+							return err
+						}
+						data, err := io.ReadAll(reader)
+						if err != nil {
+							return err
+						}
+						var n int
+						fmt.Sscanf(f.Name(), "file-%d.txt", &n) // nolint: errcheck
+						if string(data) != strings.Repeat(fmt.Sprint(n), 1000) {
+							return fmt.Errorf("%s has the wrong contents", f.Name())
+						}
+					}
+				}
+				return nil
+			}()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+// largeDirectoryImage builds a Rock Ridge image with the given number of files in a single directory
+func largeDirectoryImage(tb testing.TB, files int) []byte {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(tb, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	for f := 0; f < files; f++ {
+		require.NoError(tb, w.AddFile(strings.NewReader("x"), fmt.Sprintf("packages/package-%05d.rpm", f)))
+	}
+	var buf bytes.Buffer
+	require.NoError(tb, w.WriteTo(&buf, "large"))
+	return buf.Bytes()
+}
+
+func TestReadLargeDirectory(t *testing.T) {
+	image := largeDirectoryImage(t, 2000)
+
+	var listings [][]string
+	for _, stream := range []bool{false, true} {
+		backend := &countingReaderAt{ra: bytes.NewReader(image)}
+		img, err := OpenImageWithOptions(backend, ReaderOptions{StreamDirectories: stream})
+		require.NoError(t, err)
+		root, err := img.RootDir()
+		require.NoError(t, err)
+		children, err := root.GetChildren()
+		require.NoError(t, err)
+		require.Len(t, children, 1)
+		dir := children[0]
+
+		backend.reads = 0
+		entries, err := dir.GetChildren()
+		require.NoError(t, err)
+		require.Len(t, entries, 2000)
+		sectors := int(fileLengthToSectors(dir.de.ExtentLength))
+		require.Greater(t, sectors, 100)
+		if stream {
+			assert.Equal(t, sectors, backend.reads)
+		} else {
+			assert.Equal(t, 1, backend.reads)
+		}
+
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		listings = append(listings, names)
+	}
+	assert.Equal(t, listings[0], listings[1])
+}
+
+func BenchmarkGetChildrenLargeDirectory(b *testing.B) {
+	image := largeDirectoryImage(b, 50000)
+
+	for name, opts := range map[string]ReaderOptions{"extent": {}, "stream": {StreamDirectories: true}} {
+		b.Run(name, func(b *testing.B) {
+			backend := &countingReaderAt{ra: bytes.NewReader(image)}
+			img, err := OpenImageWithOptions(backend, opts)
+			require.NoError(b, err)
+			root, err := img.RootDir()
+			require.NoError(b, err)
+			children, err := root.GetChildren()
+			require.NoError(b, err)
+			dir := children[0]
+			backend.reads = 0
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				fresh := uncachedCopy(dir)
+				entries, err := fresh.GetChildren()
+				require.NoError(b, err)
+				require.Len(b, entries, 50000)
+			}
+			b.ReportMetric(float64(backend.reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+// uncachedCopy returns a copy of the entry which doesn't have its children cached
+func uncachedCopy(f *File) *File {
+	return &File{ra: f.ra, de: f.de, isRootDir: f.isRootDir, susp: f.susp, options: f.options, imageSize: f.imageSize,
+		parent: f.parent, warnings: f.warnings, joliet: f.joliet, sections: f.sections}
+}
+
+func TestFileID(t *testing.T) {
+	origin := t.TempDir()
+	require.NoError(t, os.MkdirAll(origin+"/a/b/c/d/e/f/g/h/i", 0755))
+	require.NoError(t, os.WriteFile(origin+"/a/b/c/d/e/f/g/h/i/deep.txt", []byte("deep"), 0644))
+	require.NoError(t, os.WriteFile(origin+"/linked", []byte("linked"), 0644))
+	require.NoError(t, os.Link(origin+"/linked", origin+"/a/link"))
+	require.NoError(t, os.WriteFile(origin+"/copy", []byte("linked"), 0644))
+	require.NoError(t, os.WriteFile(origin+"/empty1", nil, 0644))
+	require.NoError(t, os.WriteFile(origin+"/empty2", nil, 0644))
+
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Deduplicate: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddLocalDirectory(origin, "/"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "ids"))
+
+	ids := func(opts ReaderOptions) map[string]uint64 {
+		img, err := OpenImageWithOptions(bytes.NewReader(buf.Bytes()), opts)
+		require.NoError(t, err)
+		result := make(map[string]uint64)
+		for p, f := range filesByPath(t, img) {
+			result[p] = f.FileID()
+
+			if f.IsDir() {
+				children, err := f.GetAllChildren()
+				require.NoError(t, err)
+				assert.Equal(t, f.FileID(), children[0].FileID(), "the . entry of %s", p)
+				if p != "/" {
+					assert.Equal(t, f.parent.FileID(), children[1].FileID(), "the .. entry of %s", p)
+				}
+			}
+		}
+		root, err := img.RootDir()
+		require.NoError(t, err)
+		assert.Equal(t, result["/"], root.FileID())
+		return result
+	}
+
+	byID := make(map[uint64]string)
+	first := ids(ReaderOptions{})
+	for p, id := range first {
+		if other, ok := byID[id]; ok && !(p == "/linked" && other == "/a/link" || p == "/a/link" && other == "/linked") {
+			t.Errorf("%s and %s share the ID %d", p, other, id)
+		}
+		byID[id] = p
+	}
+	assert.Equal(t, first["/linked"], first["/a/link"], "hard links share their ID")
+	assert.Contains(t, first, "/a/b/c/d/e/f/g/h/i/deep.txt")
+
+	// the IDs don't depend on how the directories are read
+	assert.Equal(t, first, ids(ReaderOptions{}))
+	assert.Equal(t, first, ids(ReaderOptions{StreamDirectories: true, DiscardChildren: true}))
 }
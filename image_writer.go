@@ -1,17 +1,21 @@
 package iso9660
 
 import (
-	"bytes"
-	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"math"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -19,35 +23,206 @@ import (
 const (
 	primaryVolumeDirectoryIdentifierMaxLength = 31 // ECMA-119 7.6.3
 	primaryVolumeFileIdentifierMaxLength      = 30 // ECMA-119 7.5
+	maxDirectoryDepth                         = 8  // ECMA-119 6.8.2.1
+
+	// the directory holding relocated deep directories, named like by mkisofs
+	relocationDirectoryIdentifier = "RR_MOVED"
+	relocationDirectoryName       = "rr_moved"
+)
+
+// DeepDirectoryPolicy selects how directories nested deeper than the 8 levels allowed by ECMA-119 are written
+type DeepDirectoryPolicy int
+
+const (
+	// DeepDirectoriesDefault relocates deep directories if Rock Ridge is enabled and keeps them in place otherwise
+	DeepDirectoriesDefault DeepDirectoryPolicy = iota
+	// DeepDirectoriesRelocate moves deep directories into a hidden RR_MOVED directory in the root,
+	// like mkisofs does. Rock Ridge CL, PL and RE entries link them to their original place,
+	// so readers with Rock Ridge support see the original hierarchy. Directories nested too deeply
+	// within a relocated one are relocated in turn, so trees of any depth can be written.
+	// It requires Rock Ridge to be enabled.
+	DeepDirectoriesRelocate
+	// DeepDirectoriesKeep writes the hierarchy as it is. Such an image doesn't comply with ECMA-119,
+	// but most systems, including Linux, read it without problems.
+	DeepDirectoriesKeep
 )
 
 var (
 	// ErrFileTooLarge is returned when trying to process a file of size greater
 	// than 4GB, which due to the 32-bit address limitation is not possible
-	// except with ISO 9660-Level 3
+	// except with ISO 9660-Level 3, see SetInterchangeLevel
 	ErrFileTooLarge = errors.New("file is exceeding the maximum file size of 4GB")
+
+	// ErrWriteInProgress is returned when the staging area is modified while WriteTo is running
+	ErrWriteInProgress = errors.New("the image is being written, the staging area cannot be modified")
+
+	// ErrAlreadyStaged is returned when adding an entry at a path that is already taken,
+	// unless overwriting has been allowed with SetAllowOverwrite
+	ErrAlreadyStaged = errors.New("the path is already staged")
 )
 
 // ImageWriter is responsible for staging an image's contents
 // and writing them to an image.
+//
+// The Add methods, Remove and Rename are safe for concurrent use.
+// WriteTo must not run concurrently with them; while it runs, they fail with ErrWriteInProgress.
 type ImageWriter struct {
 	stagingDir string
+	rockRidge  bool
+	rrip       string
+	// extensions are the SUSP extensions announced next to Rock Ridge, see RegisterExtension
+	extensions []ExtensionRecord
+	zisofs     *ZisofsOptions
+	volume     VolumeMetadata
+	// volumeTimes are the dates of the volume descriptors, see SetVolumeTimes
+	volumeTimes VolumeTimes
+	systemArea  []byte
+	// hybrid holds the partition tables written to the system area, see SetHybrid
+	hybrid *HybridOptions
+	// progress is called as the image is written, see SetProgressFunc
+	progress   func(Progress)
+	implantMD5 bool
+	deepDirs   DeepDirectoryPolicy
+	dense      bool
+
+	allowOverwrite bool
+	deviceNodes    bool
+	transTables    bool
+
+	normalizeLocal    bool
+	preserveOwnership bool
+	preserveTimes     bool
+	ownerMap          func(isoPath string, uid, gid uint32) (uint32, uint32)
+
+	interchangeLevel int
+	names            NameTranslation
+	omitVersion      bool
+	fileVersions     bool
+	transliterate    bool
+	enhancedVolume   bool
+	joliet           bool
+	// inputCharset converts the staged names to UTF-8, see SetInputCharset
+	inputCharset func(name string) (string, error)
+
+	// volumeSetSize and volumeSequence place the image in a volume set, see SetVolumeSet
+	volumeSetSize  uint16
+	volumeSequence uint16
+	// blockSize is the logical block size, 0 for the size of a sector, see SetLogicalBlockSize
+	blockSize uint32
+
+	// bootEntries are the entries of the El Torito boot catalog, see AddBootEntry
+	bootEntries []stagedBootEntry
+
+	// files up to memoryThreshold bytes are staged in memory, as long as memoryStaged stays within memoryBudget
+	memoryThreshold int64
+	memoryBudget    int64
+	memoryStaged    atomic.Int64
+	buffers         sync.Pool
+	// the small files which aren't staged in memory are appended to pack, which packMu guards
+	packMu   sync.Mutex
+	pack     *os.File
+	packSize int64
+
+	fileMode    fs.FileMode
+	dirMode     fs.FileMode
+	padSectors  uint32
+	deduplicate bool
+	fixedTime   time.Time
+	dirTimes    DirectoryTimePolicy
+	dirTime     time.Time
+
+	defaultAlignment uint32
+
+	// extendedAttributes writes Extended Attribute Records, see SetExtendedAttributeRecords
+	extendedAttributes bool
+
+	dataBufferSize int
+	dataBuffers    int
+	readahead      bool
+
+	// mu guards the staged tree and the writing flag
+	mu         sync.Mutex
+	root       *stagedEntry
+	alignments map[*stagedEntry]uint32
+	fixedLBAs  map[*stagedEntry]uint32
+	sortWeight func(isoPath string) int
+	// localFiles maps the local files with several hard links which were staged to their sources
+	localFiles  map[localFileID]stagedSource
+	writing     bool
+	stagedFiles uint64
+}
+
+// VolumeMetadata holds the descriptive fields of the Primary Volume Descriptor
+// as defined in ECMA-119 8.4
+type VolumeMetadata struct {
+	SystemIdentifier            string `json:"system_identifier,omitempty"`
+	VolumeIdentifier            string `json:"volume_identifier,omitempty"`
+	VolumeSetIdentifier         string `json:"volume_set_identifier,omitempty"`
+	PublisherIdentifier         string `json:"publisher_identifier,omitempty"`
+	DataPreparerIdentifier      string `json:"data_preparer_identifier,omitempty"`
+	ApplicationIdentifier       string `json:"application_identifier,omitempty"`
+	CopyrightFileIdentifier     string `json:"copyright_file_identifier,omitempty"`
+	AbstractFileIdentifier      string `json:"abstract_file_identifier,omitempty"`
+	BibliographicFileIdentifier string `json:"bibliographic_file_identifier,omitempty"`
+}
+
+// VolumeTimes holds the dates of the Primary Volume Descriptor as defined in ECMA-119 8.4.26 to 8.4.29.
+// Zero times are replaced with the time of the image, except the expiration, which is left unspecified.
+type VolumeTimes struct {
+	Creation     time.Time `json:"creation"`
+	Modification time.Time `json:"modification"`
+	Expiration   time.Time `json:"expiration"`
+	Effective    time.Time `json:"effective"`
 }
 
 // NewWriter creates a new ImageWrite and initializes its temporary staging dir.
 // Cleanup should be called after the ImageWriter is no longer needed.
 func NewWriter() (*ImageWriter, error) {
+	return NewWriterWithOptions(WriterOptions{})
+}
+
+func newWriter() (*ImageWriter, error) {
 	tmp, err := os.MkdirTemp("", "")
 	if err != nil {
 		return nil, err
 	}
 
-	return &ImageWriter{stagingDir: tmp}, nil
+	iw := &ImageWriter{
+		stagingDir:      tmp,
+		memoryThreshold: defaultMemoryStagingThreshold,
+		memoryBudget:    defaultMemoryStagingBudget,
+		dataBufferSize:  defaultDataBufferSize,
+		dataBuffers:     defaultDataBuffers,
+		volumeSetSize:   1,
+		volumeSequence:  1,
+		volume: VolumeMetadata{
+			SystemIdentifier:      runtime.GOOS,
+			ApplicationIdentifier: "github.com/kdomanski/iso9660",
+		},
+	}
+	return iw, nil
 }
 
-// Cleanup deletes the underlying temporary staging directory of an ImageWriter.
+// Cleanup deletes the underlying temporary staging directory of an ImageWriter
+// and releases the contents staged in memory, leaving the ImageWriter empty.
 // It can be called multiple times without issues.
 func (iw *ImageWriter) Cleanup() error {
+	iw.mu.Lock()
+	iw.root = nil
+	iw.alignments = nil
+	iw.fixedLBAs = nil
+	iw.localFiles = nil
+	iw.memoryStaged.Store(0)
+	iw.mu.Unlock()
+
+	iw.packMu.Lock()
+	if iw.pack != nil {
+		_ = iw.pack.Close()
+		iw.pack = nil
+		iw.packSize = 0
+	}
+	iw.packMu.Unlock()
+
 	if iw.stagingDir == "" {
 		return nil
 	}
@@ -60,422 +235,591 @@ func (iw *ImageWriter) Cleanup() error {
 	return nil
 }
 
-// AddFile adds a file to the ImageWriter's staging area.
-// All path components are mangled to match basic ISO9660 filename requirements.
-func (iw *ImageWriter) AddFile(data io.Reader, filePath string) error {
-	directoryPath, fileName := manglePath(filePath)
-
-	if err := os.MkdirAll(path.Join(iw.stagingDir, directoryPath), 0755); err != nil {
-		return err
-	}
+// SetRockRidge enables or disables writing Rock Ridge entries,
+// which preserve the original names, modes, ownership and times of the staged files.
+// It is disabled by default.
+func (iw *ImageWriter) SetRockRidge(enabled bool) {
+	iw.rockRidge = enabled
+}
 
-	f, err := os.OpenFile(path.Join(iw.stagingDir, directoryPath, fileName), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
+// SetRockRidgeIdentifier selects the extension identifier recorded in the ER entry of the root directory,
+// RockRidgeIdentifier1991A (the default, like mkisofs) or RockRidgeIdentifierP1282, and with it the revision of RRIP
+// the entries follow, see RockRidgeRevision: RR entries are recorded for RRIP 1.09, file serial numbers for RRIP 1.12.
+func (iw *ImageWriter) SetRockRidgeIdentifier(identifier string) error {
+	if _, err := rockRidgeExtensionRecord(identifier); err != nil {
 		return err
 	}
-	defer f.Close()
 
-	_, err = io.Copy(f, data)
-	return err
+	iw.rrip = identifier
+	return nil
 }
 
-func failIfSymlink(path string) error {
-	info, err := os.Lstat(path)
-	if err != nil {
-		return err
-	}
-
-	if info.Mode()&os.ModeSymlink != 0 {
-		return fmt.Errorf("%q is a symlink - these are not yet supported", path)
+// SetZisofs enables zisofs compression of file data with the given options, or disables it if opts is nil.
+// Files below ZisofsOptions.MinSize, those of 4 GiB and more and those which don't become small enough are stored uncompressed.
+// Compressed files are marked with a ZF entry, so Rock Ridge must be enabled as well.
+func (iw *ImageWriter) SetZisofs(opts *ZisofsOptions) error {
+	if opts != nil {
+		if err := opts.validate(); err != nil {
+			return err
+		}
 	}
 
+	iw.zisofs = opts
 	return nil
 }
 
-// AddLocalFile adds a file identified by its path to the ImageWriter's staging area.
-func (iw *ImageWriter) AddLocalFile(origin, target string) error {
-	if err := failIfSymlink(origin); err != nil {
-		return err
+// SetSystemArea sets the data written verbatim at the beginning of the image, such as an MBR produced by external tooling.
+// It can be at most 32 KiB long and is padded with zeroes. A nil or empty slice restores the default of all zeroes.
+//
+// The system area set here is written as given. Options which generate the system area themselves
+// are mutually exclusive with it and WriteTo fails if both are used.
+func (iw *ImageWriter) SetSystemArea(data []byte) error {
+	if len(data) > int(systemAreaSize) {
+		return fmt.Errorf("system area of %d bytes exceeds the maximum of %d bytes", len(data), systemAreaSize)
 	}
 
-	directoryPath, fileName := manglePath(target)
-
-	if err := os.MkdirAll(path.Join(iw.stagingDir, directoryPath), 0755); err != nil {
-		return err
-	}
+	iw.systemArea = append([]byte(nil), data...)
+	return nil
+}
 
-	// try to hardlink file to staging area before copying.
-	stagedFile := path.Join(iw.stagingDir, directoryPath, fileName)
-	if err := os.Remove(stagedFile); err != nil && !os.IsNotExist(err) {
-		return err
-	}
+// SetAllowOverwrite selects whether adding a file or symlink at an already staged path replaces the staged entry.
+// It is disabled by default and the Add methods fail with ErrAlreadyStaged instead.
+// Directories are always merged, and replacing a directory with a file or vice versa is always an error.
+func (iw *ImageWriter) SetAllowOverwrite(enabled bool) {
+	iw.allowOverwrite = enabled
+}
 
-	if err := os.Link(origin, stagedFile); err == nil {
-		return nil
-	}
+// SetPreserveDeviceNodes selects whether AddLocalDirectory stages the device nodes and FIFOs it finds.
+// Reading device numbers is only supported on Linux. When disabled, which is the default,
+// AddLocalDirectory fails on such files.
+func (iw *ImageWriter) SetPreserveDeviceNodes(enabled bool) {
+	iw.deviceNodes = enabled
+}
 
-	f, err := os.Open(origin)
-	if err != nil {
-		return err
-	}
+// SetNormalizeLocalMetadata selects whether AddLocalFile and AddLocalDirectory normalize the metadata
+// of local files like mkisofs -r, instead of recording their permissions. When enabled, entries get
+// the default modes and are owned by root, while files executable by anyone become executable by everyone.
+// Modification times are recorded either way. The default is disabled.
+func (iw *ImageWriter) SetNormalizeLocalMetadata(enabled bool) {
+	iw.normalizeLocal = enabled
+}
 
-	defer f.Close()
+// SetPreserveOwnership selects whether AddLocalFile and AddLocalDirectory record the user and group IDs
+// of local files, which is only supported on Linux. It has no effect while local metadata is normalized.
+// The default is disabled, so that everything is owned by root.
+func (iw *ImageWriter) SetPreserveOwnership(enabled bool) {
+	iw.preserveOwnership = enabled
+}
 
-	return iw.AddFile(f, target)
+// SetOwnerMap sets a function mapping the user and group IDs of every entry, however it was staged,
+// to the IDs recorded in its Rock Ridge PX entry, or removes it if fn is nil. It is called with the path
+// of the entry when the image is written, so returning 0, 0 makes everything owned by root.
+func (iw *ImageWriter) SetOwnerMap(fn func(isoPath string, uid, gid uint32) (newUID, newGID uint32)) {
+	iw.ownerMap = fn
 }
 
-func ensureIsDirectory(path string) error {
-	f, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+// SetPreserveTimes selects whether AddLocalFile and AddLocalDirectory record the access and attribute change
+// times of local files in the Rock Ridge TF entry besides their modification time, which is only supported on Linux.
+// Like the times set with SetTimes, they are kept with a fixed timestamp. The default is disabled.
+func (iw *ImageWriter) SetPreserveTimes(enabled bool) {
+	iw.preserveTimes = enabled
+}
 
-	fileinfo, err := f.Stat()
-	if err != nil {
-		return err
-	}
+// SetDeepDirectoryPolicy selects how directories nested deeper than 8 levels are written.
+// The default is DeepDirectoriesDefault.
+func (iw *ImageWriter) SetDeepDirectoryPolicy(policy DeepDirectoryPolicy) {
+	iw.deepDirs = policy
+}
 
-	if !fileinfo.IsDir() {
-		return fmt.Errorf("%q is not a directory", path)
-	}
+// SetImplantMD5 enables or disables implanting an MD5 checksum of the image into the application use area
+// of the Primary Volume Descriptor, in the format of implantisomd5, so that installers can verify the medium.
+// The checksum is computed in a separate pass before writing, so the file data is read twice.
+func (iw *ImageWriter) SetImplantMD5(enabled bool) {
+	iw.implantMD5 = enabled
+}
 
-	return nil
+// SetEnhancedVolume enables or disables writing an Enhanced Volume Descriptor of ISO 9660:1999 after the Primary one.
+// It records a second directory hierarchy with the original names of up to 207 bytes, with any characters but ';',
+// and without the depth limit of 8 levels. The files share their extents with the primary hierarchy.
+// It is disabled by default.
+func (iw *ImageWriter) SetEnhancedVolume(enabled bool) {
+	iw.enhancedVolume = enabled
 }
 
-// AddLocalDirectory adds a directory recursively to the ImageWriter's staging area.
-func (iw *ImageWriter) AddLocalDirectory(origin, target string) error {
-	if err := ensureIsDirectory(origin); err != nil {
-		return err
-	}
+// SetJoliet enables or disables writing a Joliet Supplementary Volume Descriptor after the Primary one.
+// It records a second directory hierarchy with the original names in UCS-2, as read by Windows, truncated
+// to 64 characters. Characters outside the BMP and those Joliet reserves are replaced by underscores.
+// The files share their extents with the primary hierarchy. It is disabled by default.
+func (iw *ImageWriter) SetJoliet(enabled bool) {
+	iw.joliet = enabled
+}
 
-	walkfn := func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		relPath := path[len(origin):] // We need the path to be relative to the origin.
-		return iw.AddLocalFile(path, filepath.Join(target, relPath))
-	}
+// SetDeduplicate enables or disables storing files with identical contents only once. The files keep their own
+// directory records, which point to the same extent, so that duplicated locale files or firmware blobs take
+// the space of one copy. Only the contents of files of the same size are hashed to find the duplicates.
+// It is disabled by default.
+func (iw *ImageWriter) SetDeduplicate(enabled bool) {
+	iw.deduplicate = enabled
+}
 
-	return filepath.Walk(origin, walkfn)
+// SetExtendedAttributeRecords enables or disables writing an Extended Attribute Record of ECMA-119 9.5 in front of
+// the data of every file, which records its owner, permissions and times for systems that read them instead of
+// Rock Ridge, such as VMS. Directories and files split into several extents don't get one.
+// Files deduplicated with SetDeduplicate only share an extent if their records are identical as well.
+// It is disabled by default.
+func (iw *ImageWriter) SetExtendedAttributeRecords(enabled bool) {
+	iw.extendedAttributes = enabled
 }
 
-func manglePath(input string) (string, string) {
-	input = posixifyPath(input)
+// SetDenseOutput selects whether WriteTo writes every sector of the image, including those that only contain zeroes.
+// By default, when the destination is a file positioned at its end, runs of zero sectors are seeked over
+// and become holes on file systems which support them. Dense output should be used for destinations
+// where this is undesirable, such as block devices.
+func (iw *ImageWriter) SetDenseOutput(enabled bool) {
+	iw.dense = enabled
+}
 
-	nonEmptySegments := splitPath(input)
+// VolumeMetadata returns the metadata that will be written to the Primary Volume Descriptor
+func (iw *ImageWriter) VolumeMetadata() VolumeMetadata {
+	return iw.volume
+}
 
-	dirSegments := nonEmptySegments[:len(nonEmptySegments)-1]
-	name := nonEmptySegments[len(nonEmptySegments)-1]
+// SetVolumeMetadata replaces the metadata that will be written to the Primary Volume Descriptor
+func (iw *ImageWriter) SetVolumeMetadata(m VolumeMetadata) {
+	iw.volume = m
+}
 
-	for i := 0; i < len(dirSegments); i++ {
-		dirSegments[i] = mangleDirectoryName(dirSegments[i])
-	}
-	name = mangleFileName(name)
+// VolumeTimes returns the dates that will be written to the Primary Volume Descriptor
+func (iw *ImageWriter) VolumeTimes() VolumeTimes {
+	return iw.volumeTimes
+}
 
-	return path.Join(dirSegments...), name
+// SetVolumeTimes replaces the dates that will be written to the Primary Volume Descriptor
+// and the supplementary ones, see VolumeTimes
+func (iw *ImageWriter) SetVolumeTimes(t VolumeTimes) {
+	iw.volumeTimes = t
 }
 
-// Converts given path to Posix (replacing \ with /)
-//
-// @param {string} givenPath Path to convert
-//
-// @returns {string} Converted filepath
-func posixifyPath(path string) string {
-	if runtime.GOOS == "windows" {
-		return strings.ReplaceAll(path, "\\", "/")
+// newStagingFile returns a unique path in the staging directory for holding a file's data
+func (iw *ImageWriter) newStagingFile() (string, error) {
+	if err := os.MkdirAll(iw.stagingDir, 0755); err != nil {
+		return "", err
 	}
-	return path
+
+	n := atomic.AddUint64(&iw.stagedFiles, 1)
+	return path.Join(iw.stagingDir, fmt.Sprintf("%08d", n)), nil
 }
 
-func splitPath(input string) []string {
-	rawSegments := strings.Split(input, "/")
-	var nonEmptySegments []string
-	for _, s := range rawSegments {
-		if len(s) > 0 {
-			nonEmptySegments = append(nonEmptySegments, s)
+// copyToStaging keeps the data in memory if it's small enough and the memory budget allows it.
+// Otherwise small data is appended to the pack file and larger data is copied into a new file in the staging directory.
+func (iw *ImageWriter) copyToStaging(data io.Reader) (stagedSource, error) {
+	buf := iw.getBuffer()
+	defer iw.buffers.Put(buf)
+
+	// the data read ahead to decide whether it fits into memory
+	var head []byte
+	complete := false
+	if iw.memoryThreshold > 0 {
+		n, err := io.ReadFull(data, *buf)
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			complete = true
+		default:
+			return nil, err
 		}
-	}
-	return nonEmptySegments
-}
+		head = (*buf)[:n]
 
-// See ECMA-119 7.5
-func mangleFileName(input string) string {
-	// https://github.com/torvalds/linux/blob/v5.6/fs/isofs/dir.c#L29
-	input = strings.ToLower(input)
-	split := strings.Split(input, ".")
+		if complete && iw.reserveMemory(int64(n)) {
+			return &memorySource{data: append([]byte(nil), head...)}, nil
+		}
+		if complete {
+			return iw.appendToPack(head)
+		}
+	}
 
-	version := "1"
-	var filename, extension string
-	if len(split) == 1 {
-		filename = split[0]
-	} else {
-		filename = strings.Join(split[:len(split)-1], "_")
-		extension = split[len(split)-1]
+	stagedFile, err := iw.newStagingFile()
+	if err != nil {
+		return nil, err
 	}
 
-	// enough characters for the `.ignition` extension
-	extension = mangleD1String(extension, 8)
+	f, err := os.OpenFile(stagedFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-	maxRemainingFilenameLength := primaryVolumeFileIdentifierMaxLength - (1 + len(version))
-	if len(extension) > 0 {
-		maxRemainingFilenameLength -= (1 + len(extension))
+	if _, err = f.Write(head); err != nil {
+		return nil, err
+	}
+	size := int64(len(head))
+	if !complete {
+		// hide ReadFrom of the file, which would allocate its own buffer
+		n, err := io.CopyBuffer(struct{ io.Writer }{f}, data, *buf)
+		if err != nil {
+			return nil, err
+		}
+		size += n
 	}
 
-	filename = mangleD1String(filename, maxRemainingFilenameLength)
+	return &localFileSource{path: stagedFile, size: size}, nil
+}
+
+// appendToPack appends the data to the pack file in the staging directory, creating it on first use.
+// A million small files then take a single file instead of a million, and their sources don't hold paths.
+func (iw *ImageWriter) appendToPack(data []byte) (stagedSource, error) {
+	iw.packMu.Lock()
+	defer iw.packMu.Unlock()
 
-	if len(extension) > 0 {
-		return filename + "." + extension + ";" + version
+	if iw.pack == nil {
+		stagedFile, err := iw.newStagingFile()
+		if err != nil {
+			return nil, err
+		}
+		if iw.pack, err = os.OpenFile(stagedFile, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); err != nil {
+			return nil, err
+		}
 	}
 
-	return filename + ";" + version
+	if _, err := iw.pack.WriteAt(data, iw.packSize); err != nil {
+		return nil, err
+	}
+	source := &packSource{pack: iw.pack, offset: iw.packSize, size: int64(len(data))}
+	iw.packSize += int64(len(data))
+	return source, nil
 }
 
-// See ECMA-119 7.6
-func mangleDirectoryName(input string) string {
-	return mangleD1String(input, primaryVolumeDirectoryIdentifierMaxLength)
+// getBuffer returns a buffer for copyToStaging, which holds one byte more than the memory staging threshold
+// so that it tells whether the data fits
+func (iw *ImageWriter) getBuffer() *[]byte {
+	size := 32 * 1024
+	if iw.memoryThreshold > 0 {
+		size = int(iw.memoryThreshold) + 1
+	}
+	if buf, ok := iw.buffers.Get().(*[]byte); ok && len(*buf) == size {
+		return buf
+	}
+	buf := make([]byte, size)
+	return &buf
 }
 
-func mangleD1String(input string, maxCharacters int) string {
-	// https://github.com/torvalds/linux/blob/v5.6/fs/isofs/dir.c#L29
-	input = strings.ToLower(input)
+// reserveMemory accounts for n bytes staged in memory, unless they would exceed the budget
+func (iw *ImageWriter) reserveMemory(n int64) bool {
+	if iw.memoryStaged.Add(n) > iw.memoryBudget {
+		iw.memoryStaged.Add(-n)
+		return false
+	}
+	return true
+}
 
-	var mangledString string
-	for i := 0; i < len(input) && i < maxCharacters; i++ {
-		r := rune(input[i])
-		if strings.ContainsRune(d1Characters, r) {
-			mangledString += string(r)
-		} else {
-			mangledString += "_"
-		}
+// discard releases the storage of a source created by copyToStaging, which didn't end up staged.
+// Data appended to the pack file stays there until Cleanup.
+func (iw *ImageWriter) discard(source stagedSource) {
+	switch s := source.(type) {
+	case *localFileSource:
+		_ = os.Remove(s.path)
+	case *memorySource:
+		iw.memoryStaged.Add(-s.Size())
 	}
+}
 
-	return mangledString
+// EntryOption sets metadata of a staged entry, which is written with Rock Ridge
+type EntryOption func(e *stagedEntry)
+
+// WithMode sets the permission bits, along with the setuid, setgid and sticky bits, of a staged entry
+func WithMode(mode fs.FileMode) EntryOption {
+	return func(e *stagedEntry) {
+		e.mode = e.mode&fs.ModeType | mode&^fs.ModeType
+	}
 }
 
-// calculateDirChildrenSectors calculates the total mashalled size of all DirectoryEntries
-// within a directory. The size of each entry depends of the length of the filename.
-func calculateDirChildrenSectors(path string) (uint32, error) {
-	contents, err := os.ReadDir(path)
-	if err != nil {
-		return 0, err
+// WithModTime sets the modification time of a staged entry
+func WithModTime(t time.Time) EntryOption {
+	return func(e *stagedEntry) {
+		e.modTime = t
 	}
+}
 
-	var sectors uint32
-	var currentSectorOccupied uint32 = 68 // the 0x00 and 0x01 entries
+// WithOwner sets the user and group IDs of a staged entry
+func WithOwner(uid, gid uint32) EntryOption {
+	return func(e *stagedEntry) {
+		e.uid = uid
+		e.gid = gid
+	}
+}
 
-	for _, c := range contents {
-		identifierLen := len(c.Name())
-		idPaddingLen := (identifierLen + 1) % 2
-		entryLength := uint32(33 + identifierLen + idPaddingLen)
+// AddDirectory adds a directory to the ImageWriter's staging area, creating any missing parent directories.
+// This allows staging empty directories, such as mount points.
+// If the directory already exists, only the given options are applied to it.
+func (iw *ImageWriter) AddDirectory(isoPath string, opts ...EntryOption) error {
+	return iw.addDirectory(isoPath, "AddDirectory", opts)
+}
 
-		if currentSectorOccupied+entryLength > sectorSize {
-			sectors++
-			currentSectorOccupied = entryLength
-		} else {
-			currentSectorOccupied += entryLength
-		}
+// AddDeviceNode adds a block or character device to the ImageWriter's staging area.
+// The mode must include fs.ModeDevice, and fs.ModeCharDevice for character devices.
+// The device number is written in a Rock Ridge PN entry, so Rock Ridge must be enabled for it to be kept.
+func (iw *ImageWriter) AddDeviceNode(isoPath string, mode fs.FileMode, major, minor uint32) error {
+	if mode&fs.ModeDevice == 0 || mode&fs.ModeType&^(fs.ModeDevice|fs.ModeCharDevice) != 0 {
+		return fmt.Errorf("cannot stage %q: mode %s is not a device", isoPath, mode)
 	}
+	if err := checkDeviceNumber(major, minor); err != nil {
+		return fmt.Errorf("cannot stage %q: %w", isoPath, err)
+	}
+
+	entry := newStagedSpecialFile("", mode, iw.now())
+	d := entry.edit()
+	d.devMajor, d.devMinor = major, minor
+	entry.origin = "AddDeviceNode"
+	return iw.stage(isoPath, entry)
+}
 
-	if currentSectorOccupied > 0 {
-		sectors++
+// AddFifo adds a named pipe with the given permissions to the ImageWriter's staging area.
+// Rock Ridge must be enabled for it to be recorded as a FIFO.
+func (iw *ImageWriter) AddFifo(isoPath string, mode fs.FileMode) error {
+	if mode.Type()&^fs.ModeNamedPipe != 0 {
+		return fmt.Errorf("cannot stage %q: mode %s is not a FIFO", isoPath, mode)
 	}
 
-	return sectors, nil
+	entry := newStagedSpecialFile("", mode|fs.ModeNamedPipe, iw.now())
+	entry.origin = "AddFifo"
+	return iw.stage(isoPath, entry)
 }
 
-func fileLengthToSectors(l uint32) uint32 {
-	if (l % sectorSize) == 0 {
-		return l / sectorSize
+// AddSymlink adds a symbolic link pointing to target to the ImageWriter's staging area.
+// The target is written in Rock Ridge SL entries, so Rock Ridge must be enabled for it to be kept.
+func (iw *ImageWriter) AddSymlink(target, linkPath string) error {
+	if target == "" {
+		return fmt.Errorf("cannot stage %q: the symlink target is empty", linkPath)
 	}
 
-	return (l / sectorSize) + 1
+	entry := newStagedSymlink("", target, iw.now())
+	entry.origin = "AddSymlink"
+	return iw.stage(linkPath, entry)
 }
 
-type writeContext struct {
-	stagingDir        string
-	timestamp         RecordingTimestamp
-	freeSectorPointer uint32
+// AddFile adds a file to the ImageWriter's staging area.
+// All path components are mangled to match basic ISO9660 filename requirements.
+func (iw *ImageWriter) AddFile(data io.Reader, filePath string) error {
+	return iw.addReader(data, filePath, "reader")
 }
 
-func (wc *writeContext) allocateSectors(n uint32) uint32 {
-	return atomic.AddUint32(&wc.freeSectorPointer, n) - n
+// EntryInfo is the metadata of a file added with AddFileWithInfo.
+// The mode, the owner and the times are written with Rock Ridge.
+type EntryInfo struct {
+	// Mode holds the permission bits, along with the setuid, setgid and sticky bits.
+	// 0 keeps the default, see WriterOptions.DefaultFileMode.
+	Mode fs.FileMode
+	// ModTime is the modification time, the zero time keeps the time the file is added at
+	ModTime time.Time
+	UID     uint32
+	GID     uint32
+	// Times, if set, overrides the times recorded for the file, see SetTimes
+	Times  *RecordTimes
+	Hidden bool
+	// Identifier, if set, is written verbatim as the ISO 9660 file identifier, e.g. "SETUP.EXE;1", instead of
+	// the one derived from the last element of the path, which stays the name recorded with Rock Ridge and Joliet.
+	// It must be a valid file identifier of interchange level 2, or of level 1 if the ImageWriter is limited to it.
+	Identifier string
 }
 
-func (wc *writeContext) createDEForRoot() (*DirectoryEntry, error) {
-	extentLengthInSectors, err := calculateDirChildrenSectors(wc.stagingDir)
-	if err != nil {
-		return nil, err
+// apply sets the metadata of a staged entry, keeping the defaults of the zero fields
+func (info EntryInfo) apply(e *stagedEntry) {
+	if info.Mode&^fs.ModeType != 0 {
+		WithMode(info.Mode)(e)
+	}
+	if !info.ModTime.IsZero() {
+		e.modTime = info.ModTime
+	}
+	e.uid = info.UID
+	e.gid = info.GID
+	if info.Times != nil {
+		WithRecordTimes(*info.Times)(e)
 	}
+	e.hidden = info.Hidden
+	if info.Identifier != "" {
+		e.edit().identifier = info.Identifier
+	}
+}
 
-	extentLocation := wc.allocateSectors(extentLengthInSectors)
-	de := &DirectoryEntry{
-		ExtendedAtributeRecordLength: 0,
-		ExtentLocation:               int32(extentLocation),
-		ExtentLength:                 uint32(extentLengthInSectors * sectorSize),
-		RecordingDateTime:            wc.timestamp,
-		FileFlags:                    dirFlagDir,
-		FileUnitSize:                 0, // 0 for non-interleaved write
-		InterleaveGap:                0, // not interleaved
-		VolumeSequenceNumber:         1, // we only have one volume
-		Identifier:                   string([]byte{0}),
-		SystemUse:                    []byte{},
+// AddFileWithInfo adds a file to the ImageWriter's staging area like AddFile, recording the given metadata
+// instead of the defaults, so that the files of archives, databases or generated content can keep theirs
+// without being written to the local filesystem first.
+func (iw *ImageWriter) AddFileWithInfo(data io.Reader, filePath string, info EntryInfo) error {
+	if info.Identifier != "" {
+		level := iw.interchangeLevel
+		if level != 1 {
+			level = 2
+		}
+		if problem := identifierProblem(info.Identifier, false, level); problem != "" {
+			return fmt.Errorf("cannot stage %q with the identifier %q: %s", filePath, info.Identifier, problem)
+		}
 	}
-	return de, nil
+	return iw.addReader(data, filePath, "reader", info.apply)
 }
 
-type itemToWrite struct {
-	isDirectory     bool
-	dirPath         string
-	ownEntry        *DirectoryEntry
-	parentEntery    *DirectoryEntry
-	childrenEntries []*DirectoryEntry
-	targetSector    uint32
+// AddStreamedFile adds a file of the given size to the ImageWriter's staging area without copying its data.
+// The data is read from r while the image is written, so images can be built with constant memory
+// from data which is produced on the fly or too large to be staged. The reader must provide exactly size bytes,
+// otherwise WriteTo fails, leaving an incomplete image behind.
+//
+// As the data is read only once, an ImageWriter with streamed files can write a single image,
+// and cannot implant an MD5 checksum, deduplicate files or compress them with zisofs.
+func (iw *ImageWriter) AddStreamedFile(r io.Reader, size int64, filePath string, opts ...EntryOption) error {
+	if size < 0 {
+		return fmt.Errorf("cannot stage %q: negative size %d", filePath, size)
+	}
+
+	entry := iw.newFileEntry(&streamSource{r: r, size: size})
+	entry.origin = "stream"
+	for _, opt := range opts {
+		opt(entry)
+	}
+	return iw.stage(filePath, entry)
 }
 
-// scanDirectory reads the directory's contents and adds them to the queue, as well as stores all their DirectoryEntries in the item,
-// because we'll need them to write this item's descriptor.
-func (wc *writeContext) scanDirectory(item *itemToWrite, dirPath string, ownEntry *DirectoryEntry, parentEntery *DirectoryEntry, targetSector uint32) (*list.List, error) {
-	contents, err := os.ReadDir(dirPath)
+func (iw *ImageWriter) addReader(data io.Reader, filePath, origin string, opts ...EntryOption) error {
+	source, err := iw.copyToStaging(data)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	itemsToWrite := list.New()
+	entry := iw.newFileEntry(source)
+	entry.origin = origin
+	for _, opt := range opts {
+		opt(entry)
+	}
+	if err = iw.stage(filePath, entry); err != nil {
+		iw.discard(source)
+		return err
+	}
+	return nil
+}
 
-	for _, c := range contents {
-		var (
-			fileFlags             byte
-			extentLengthInSectors uint32
-			extentLength          uint32
-		)
-		if c.IsDir() {
-			extentLengthInSectors, err = calculateDirChildrenSectors(path.Join(dirPath, c.Name()))
-			if err != nil {
-				return nil, err
-			}
-			fileFlags = dirFlagDir
-			extentLength = extentLengthInSectors * sectorSize
-		} else {
-			fileinfo, err := c.Info()
-			if err != nil {
-				return nil, err
-			}
-			if fileinfo.Size() > int64(math.MaxUint32) {
-				return nil, ErrFileTooLarge
-			}
-			extentLength = uint32(fileinfo.Size())
-			extentLengthInSectors = fileLengthToSectors(extentLength)
-
-			fileFlags = 0
-		}
-
-		extentLocation := wc.allocateSectors(extentLengthInSectors)
-		de := &DirectoryEntry{
-			ExtendedAtributeRecordLength: 0,
-			ExtentLocation:               int32(extentLocation),
-			ExtentLength:                 uint32(extentLength),
-			RecordingDateTime:            wc.timestamp,
-			FileFlags:                    fileFlags,
-			FileUnitSize:                 0, // 0 for non-interleaved write
-			InterleaveGap:                0, // not interleaved
-			VolumeSequenceNumber:         1, // we only have one volume
-			Identifier:                   c.Name(),
-			SystemUse:                    []byte{},
-		}
-
-		// Add this child's descriptor to the currently scanned directory's list of children,
-		// so that later we can use it for writing the current item.
-		if item.childrenEntries == nil {
-			item.childrenEntries = []*DirectoryEntry{de}
-		} else {
-			item.childrenEntries = append(item.childrenEntries, de)
+// localMetadata returns the options recording the metadata of a local file.
+// Its modification time is kept, along with its other times if SetPreserveTimes is enabled.
+// Unless SetNormalizeLocalMetadata is enabled, its permissions are kept as well,
+// and its owner if SetPreserveOwnership is enabled.
+func (iw *ImageWriter) localMetadata(info os.FileInfo) []EntryOption {
+	opts := []EntryOption{WithModTime(info.ModTime())}
+	if iw.preserveTimes {
+		if access, change, ok := fileTimes(info); ok {
+			opts = append(opts, WithRecordTimes(RecordTimes{Access: access, Change: change}))
 		}
+	}
 
-		// queue this child for processing
-		itemsToWrite.PushBack(itemToWrite{
-			isDirectory:  c.IsDir(),
-			dirPath:      path.Join(dirPath, c.Name()),
-			ownEntry:     de,
-			parentEntery: ownEntry,
-			targetSector: uint32(de.ExtentLocation),
+	if iw.normalizeLocal {
+		// like mkisofs -r, everything is readable and files executable by anyone are executable by everyone
+		executable := !info.IsDir() && info.Mode()&0111 != 0
+		return append(opts, func(e *stagedEntry) {
+			if executable {
+				e.mode |= (e.mode & 0444) >> 2
+			}
 		})
 	}
 
-	return itemsToWrite, nil
+	opts = append(opts, WithMode(info.Mode()&^fs.ModeType))
+	if iw.preserveOwnership {
+		if uid, gid, ok := fileOwner(info); ok {
+			opts = append(opts, WithOwner(uid, gid))
+		}
+	}
+	return opts
 }
 
-// processDirectory writes a given directory item to the destination sectors
-func processDirectory(w io.Writer, children []*DirectoryEntry, ownEntry *DirectoryEntry, parentEntry *DirectoryEntry) error {
-	var currentOffset uint32
-
-	currentDE := ownEntry.Clone()
-	currentDE.Identifier = string([]byte{0})
-	parentDE := parentEntry.Clone()
-	parentDE.Identifier = string([]byte{1})
-
-	currentDEData, err := currentDE.MarshalBinary()
+// AddLocalFile adds a file identified by its path to the ImageWriter's staging area.
+// The metadata of the file is recorded as well, see SetNormalizeLocalMetadata.
+// A symbolic link is staged as a symlink with the same target, like AddLocalDirectory stages them, and isn't followed.
+func (iw *ImageWriter) AddLocalFile(origin, target string) error {
+	info, err := os.Lstat(origin)
 	if err != nil {
 		return err
 	}
-	parentDEData, err := parentDE.MarshalBinary()
-	if err != nil {
-		return err
+	if info.Mode()&os.ModeSymlink != 0 {
+		return iw.addLocalSymlink(origin, target, info, iw.localMetadata(info))
 	}
+	return iw.addLocalFile(origin, target, iw.localMetadata(info))
+}
+
+// localFileID identifies a local file by its device and inode numbers
+type localFileID struct {
+	dev, ino uint64
+}
 
-	n, err := w.Write(currentDEData)
+// addLocalFile stages a regular file from the local filesystem with the given options applied
+func (iw *ImageWriter) addLocalFile(origin, target string, opts []EntryOption) error {
+	info, err := os.Stat(origin)
 	if err != nil {
 		return err
 	}
-	currentOffset += uint32(n)
-	n, err = w.Write(parentDEData)
-	if err != nil {
-		return err
+	// a file hard linked to one staged before shares its extent
+	id, linked := fileIdentity(info)
+	if linked {
+		iw.mu.Lock()
+		existing := iw.localFiles[id]
+		iw.mu.Unlock()
+		if existing != nil {
+			entry := iw.newFileEntry(&hardLinkSource{sourceIdentity(existing)})
+			entry.origin = strconv.Quote(origin)
+			for _, opt := range opts {
+				opt(entry)
+			}
+			return iw.stage(target, entry)
+		}
 	}
-	currentOffset += uint32(n)
 
-	for _, childDescriptor := range children {
-		data, err := childDescriptor.MarshalBinary()
-		if err != nil {
-			return err
+	source, err := iw.stageLocalFile(origin, target, opts)
+	if err == nil && linked {
+		iw.mu.Lock()
+		if iw.localFiles == nil {
+			iw.localFiles = make(map[localFileID]stagedSource)
 		}
+		iw.localFiles[id] = source
+		iw.mu.Unlock()
+	}
+	return err
+}
 
-		remainingSectorSpace := sectorSize - (currentOffset % sectorSize)
-		if remainingSectorSpace < uint32(len(data)) {
-			// ECMA-119 6.8.1.1 If the body of the next descriptor won't fit into the sector,
-			// we fill the rest of space with zeros and skip to the next sector.
-			zeros := bytes.Repeat([]byte{0}, int(remainingSectorSpace))
-			_, err = w.Write(zeros)
-			if err != nil {
-				return err
-			}
+// stageLocalFile stages a copy of a local file and returns its source
+func (iw *ImageWriter) stageLocalFile(origin, target string, opts []EntryOption) (stagedSource, error) {
+	// try to hardlink file to staging area before copying.
+	stagedFile, err := iw.newStagingFile()
+	if err != nil {
+		return nil, err
+	}
 
-			// skip to the next sector
-			currentOffset = 0
+	var source stagedSource
+	if err = os.Link(origin, stagedFile); err == nil {
+		staged, err := os.Stat(stagedFile)
+		if err != nil {
+			return nil, err
 		}
-
-		n, err = w.Write(data)
+		source = &localFileSource{path: stagedFile, size: staged.Size()}
+	} else {
+		f, err := os.Open(origin)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		currentOffset += uint32(n)
-	}
+		defer f.Close()
 
-	// fill with zeros to the end of the sector
-	remainingSectorSpace := sectorSize - (currentOffset % sectorSize)
-	if remainingSectorSpace != 0 {
-		zeros := bytes.Repeat([]byte{0}, int(remainingSectorSpace))
-		_, err = w.Write(zeros)
-		if err != nil {
-			return err
+		if source, err = iw.copyToStaging(f); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	entry := iw.newFileEntry(source)
+	entry.origin = strconv.Quote(origin)
+	for _, opt := range opts {
+		opt(entry)
+	}
+	if err = iw.stage(target, entry); err != nil {
+		iw.discard(source)
+		return nil, err
+	}
+	return source, nil
 }
 
-func processFile(w io.Writer, dirPath string) error {
-	f, err := os.Open(dirPath)
+func ensureIsDirectory(path string) error {
+	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
@@ -486,103 +830,1292 @@ func processFile(w io.Writer, dirPath string) error {
 		return err
 	}
 
-	if fileinfo.Size() > int64(math.MaxUint32) {
-		return ErrFileTooLarge
+	if !fileinfo.IsDir() {
+		return fmt.Errorf("%q is not a directory", path)
 	}
 
-	buffer := make([]byte, sectorSize)
-
-	for bytesLeft := uint32(fileinfo.Size()); bytesLeft > 0; {
-		var toRead uint32
-		if bytesLeft < sectorSize {
-			toRead = bytesLeft
-		} else {
-			toRead = sectorSize
-		}
+	return nil
+}
 
-		if _, err = io.ReadAtLeast(f, buffer, int(toRead)); err != nil {
-			return err
+// addLocalSpecialFile stages a device node or FIFO from the local filesystem with the given options applied
+func (iw *ImageWriter) addLocalSpecialFile(origin, target string, info os.FileInfo, opts []EntryOption) error {
+	entry := newStagedSpecialFile("", info.Mode(), info.ModTime())
+	entry.origin = strconv.Quote(origin)
+	for _, opt := range opts {
+		opt(entry)
+	}
+	if info.Mode()&fs.ModeDevice != 0 {
+		major, minor, err := deviceNumber(info)
+		if err != nil {
+			return fmt.Errorf("reading the device number of %q: %w", origin, err)
 		}
+		d := entry.edit()
+		d.devMajor, d.devMinor = major, minor
+	}
+	return iw.stage(target, entry)
+}
 
-		if _, err = w.Write(buffer); err != nil {
-			return err
-		}
+// addLocalSymlink stages a symbolic link from the local filesystem with the given options applied.
+// The link isn't followed, its target is recorded as is.
+func (iw *ImageWriter) addLocalSymlink(origin, target string, info os.FileInfo, opts []EntryOption) error {
+	linkTarget, err := os.Readlink(origin)
+	if err != nil {
+		return err
+	}
 
-		bytesLeft -= toRead
+	entry := newStagedSymlink("", linkTarget, info.ModTime())
+	entry.origin = strconv.Quote(origin)
+	for _, opt := range opts {
+		opt(entry)
 	}
-	// We already write a whole sector-sized buffer, so there's need to fill with zeroes.
+	return iw.stage(target, entry)
+}
 
-	return nil
+// StagedEntry describes an entry found by AddLocalDirectory as it is about to be staged.
+// A hook set with WithEntryHook can change it.
+type StagedEntry struct {
+	// ISOPath is the path the entry is staged at. The contents of a directory follow it if it changes.
+	ISOPath string
+	// Content, if set, replaces the data of a regular file
+	Content io.Reader
+
+	// Mode holds the permission bits, along with the setuid, setgid and sticky bits.
+	// The type of the entry cannot be changed.
+	Mode    fs.FileMode
+	ModTime time.Time
+	UID     uint32
+	GID     uint32
+	Hidden  bool
+	// Times, if set, overrides the times recorded for the entry, see SetTimes
+	Times *RecordTimes
+
+	// Skip suppresses the entry, along with the contents of a directory
+	Skip bool
 }
 
-// traverseStagingDir creates a new queue of items to write by traversing the staging directory
-func (wc *writeContext) traverseStagingDir(rootItem itemToWrite) (*list.List, error) {
-	itemsToWrite := list.New()
-	itemsToWrite.PushBack(rootItem)
+// apply sets the metadata of a staged entry as described
+func (s *StagedEntry) apply(e *stagedEntry) {
+	e.mode = e.mode&fs.ModeType | s.Mode&^fs.ModeType
+	e.modTime = s.ModTime
+	e.uid = s.UID
+	e.gid = s.GID
+	e.hidden = s.Hidden
+	if s.Times != nil {
+		WithRecordTimes(*s.Times)(e)
+	}
+}
 
-	for item := itemsToWrite.Front(); item != nil; item = item.Next() {
-		it := item.Value.(itemToWrite)
+// LocalDirectoryOption configures AddLocalDirectory
+type LocalDirectoryOption func(*localDirectoryOptions)
 
-		if it.isDirectory {
-			newItems, err := wc.scanDirectory(&it, it.dirPath, it.ownEntry, it.parentEntery, it.targetSector)
+type localDirectoryOptions struct {
+	hook func(localPath string, info fs.FileInfo, staged *StagedEntry) error
+}
+
+// WithEntryHook sets a function which AddLocalDirectory calls for every entry it finds, before staging it.
+// The function may change where and how the entry is staged, or suppress it.
+// If it returns an error, AddLocalDirectory stops and returns it.
+func WithEntryHook(fn func(localPath string, info fs.FileInfo, staged *StagedEntry) error) LocalDirectoryOption {
+	return func(o *localDirectoryOptions) {
+		o.hook = fn
+	}
+}
+
+// describeLocal returns how a local file would be staged at isoPath without a hook
+func (iw *ImageWriter) describeLocal(info os.FileInfo, isoPath string) *StagedEntry {
+	var e *stagedEntry
+	switch {
+	case info.IsDir():
+		e = iw.newDirectoryEntry("")
+	case info.Mode().IsRegular():
+		e = iw.newFileEntry(nil)
+	default:
+		e = newStagedSpecialFile("", info.Mode(), info.ModTime())
+	}
+	for _, opt := range iw.localMetadata(info) {
+		opt(e)
+	}
+
+	return &StagedEntry{
+		ISOPath: isoPath,
+		Mode:    e.mode &^ fs.ModeType,
+		ModTime: e.modTime,
+		UID:     e.uid,
+		GID:     e.gid,
+		Times:   e.info().times,
+	}
+}
+
+// AddLocalDirectory adds a directory recursively to the ImageWriter's staging area,
+// recording the metadata of its contents like AddLocalFile.
+// Symbolic links are staged as symlinks with their targets unchanged, see AddSymlink.
+// Directories which already exist in the staging area are merged with it.
+func (iw *ImageWriter) AddLocalDirectory(origin, target string, opts ...LocalDirectoryOption) error {
+	var options localDirectoryOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if err := ensureIsDirectory(origin); err != nil {
+		return err
+	}
+
+	// targets holds the paths the contents of each local directory are staged under
+	targets := make(map[string]string)
+
+	walkfn := func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		isoPath := target
+		if parent, ok := targets[filepath.Dir(localPath)]; ok {
+			isoPath = path.Join(parent, info.Name())
+		}
+
+		mode := info.Mode()
+		if mode&(fs.ModeDevice|fs.ModeNamedPipe) != 0 && !iw.deviceNodes {
+			return fmt.Errorf("%q is a special file, see SetPreserveDeviceNodes", localPath)
+		}
+
+		staged := iw.describeLocal(info, isoPath)
+		if options.hook != nil {
+			if err := options.hook(localPath, info, staged); err != nil {
+				return fmt.Errorf("%q: %w", localPath, err)
+			}
+		}
+
+		switch {
+		case staged.Skip && mode.IsDir():
+			return filepath.SkipDir
+		case staged.Skip:
+			return nil
+		case staged.Content != nil && !mode.IsRegular():
+			return fmt.Errorf("%q: only the contents of regular files can be replaced", localPath)
+		}
+
+		entryOpts := []EntryOption{staged.apply}
+		switch {
+		case mode.IsDir():
+			// stage directories as well, so that empty ones are preserved
+			targets[filepath.Clean(localPath)] = staged.ISOPath
+			return iw.addDirectory(staged.ISOPath, strconv.Quote(localPath), entryOpts)
+		case mode.IsRegular() && staged.Content != nil:
+			return iw.addReader(staged.Content, staged.ISOPath, strconv.Quote(localPath), entryOpts...)
+		case mode.IsRegular():
+			return iw.addLocalFile(localPath, staged.ISOPath, entryOpts)
+		case mode&fs.ModeSymlink != 0:
+			return iw.addLocalSymlink(localPath, staged.ISOPath, info, entryOpts)
+		}
+		return iw.addLocalSpecialFile(localPath, staged.ISOPath, info, entryOpts)
+	}
+
+	return filepath.Walk(origin, walkfn)
+}
+
+// readLinkFS is a file system that can read symbolic links, like fs.ReadLinkFS in Go 1.25 and newer
+type readLinkFS interface {
+	fs.FS
+	ReadLink(name string) (string, error)
+}
+
+// AddFS adds the contents of a file system recursively to the ImageWriter's staging area under isoRoot.
+// The modes and modification times of regular files and directories are preserved,
+// except that entries without any permission bits get the default ones, see WriterOptions.DefaultFileMode.
+// Symbolic links are staged as symlinks (written with Rock Ridge),
+// if the file system can read them by implementing ReadLink(name string) (string, error) like fs.ReadLinkFS.
+func (iw *ImageWriter) AddFS(fsys fs.FS, isoRoot string) error {
+	linkFS, canReadLinks := fsys.(readLinkFS)
+
+	walkfn := func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("adding %s: %w", name, err)
+		}
+
+		target := path.Join(isoRoot, name)
+		if name == "." && path.Join("/", posixifyPath(target)) == "/" {
+			// the root of the image keeps its own attributes
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("adding %s: %w", name, err)
+		}
+
+		var entry *stagedEntry
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			entry = iw.newDirectoryEntry("")
+			entry.modTime = info.ModTime()
+		case mode&fs.ModeSymlink != 0:
+			if !canReadLinks {
+				return fmt.Errorf("adding %s: the file system doesn't support reading symlinks", name)
+			}
+			linkTarget, err := linkFS.ReadLink(name)
+			if err != nil {
+				return fmt.Errorf("adding %s: %w", name, err)
+			}
+			entry = newStagedSymlink("", linkTarget, info.ModTime())
+		case mode.IsRegular():
+			source, err := iw.copyFromFS(fsys, name)
 			if err != nil {
-				relativePath := it.dirPath[len(wc.stagingDir):]
-				return nil, fmt.Errorf("processing %s: %s", relativePath, err)
+				return fmt.Errorf("adding %s: %w", name, err)
 			}
-			itemsToWrite.PushBackList(newItems)
+			entry = iw.newFileEntry(source)
+			entry.modTime = info.ModTime()
+		default:
+			return fmt.Errorf("adding %s: unsupported file type %s", name, mode.Type())
+		}
+		// file systems like fstest.MapFS may leave the permissions out, the entries get the default ones then
+		if info.Mode()&^fs.ModeType != 0 {
+			entry.mode = info.Mode()
 		}
+		entry.origin = fmt.Sprintf("%q in the file system", name)
 
-		item.Value = it
+		if err = iw.stage(target, entry); err != nil {
+			if entry.source != nil {
+				iw.discard(entry.source)
+			}
+			return fmt.Errorf("adding %s: %w", name, err)
+		}
+		return nil
 	}
 
-	return itemsToWrite, nil
+	return fs.WalkDir(fsys, ".", walkfn)
 }
 
-func writeAll(w io.Writer, itemsToWrite *list.List) error {
-	for item := itemsToWrite.Front(); item != nil; item = item.Next() {
-		it := item.Value.(itemToWrite)
-		var err error
-		if it.isDirectory {
-			err = processDirectory(w, it.childrenEntries, it.ownEntry, it.parentEntery)
-		} else {
-			err = processFile(w, it.dirPath)
+func (iw *ImageWriter) copyFromFS(fsys fs.FS, name string) (stagedSource, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return iw.copyToStaging(f)
+}
+
+// Converts given path to Posix (replacing \ with /)
+//
+// @param {string} givenPath Path to convert
+//
+// @returns {string} Converted filepath
+func posixifyPath(path string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ReplaceAll(path, "\\", "/")
+	}
+	return path
+}
+
+func splitPath(input string) []string {
+	rawSegments := strings.Split(input, "/")
+	var nonEmptySegments []string
+	for _, s := range rawSegments {
+		if len(s) > 0 {
+			nonEmptySegments = append(nonEmptySegments, s)
+		}
+	}
+	return nonEmptySegments
+}
+
+// compareIdentifiers orders file identifiers as required by ECMA-119 9.3:
+// by name, then by extension, both padded with spaces, then by descending version.
+func compareIdentifiers(a, b string) int {
+	splitIdentifier := func(id string) (string, string, string) {
+		name, version, _ := strings.Cut(id, ";")
+		name, extension, _ := strings.Cut(name, ".")
+		return name, extension, version
+	}
+	comparePadded := func(a, b string) int {
+		for len(a) < len(b) {
+			a += " "
+		}
+		for len(b) < len(a) {
+			b += " "
+		}
+		return strings.Compare(a, b)
+	}
+
+	aName, aExt, aVersion := splitIdentifier(a)
+	bName, bExt, bVersion := splitIdentifier(b)
+
+	if c := comparePadded(aName, bName); c != 0 {
+		return c
+	}
+	if c := comparePadded(aExt, bExt); c != 0 {
+		return c
+	}
+	// the versions are in descending order of their values, see ECMA-119 9.3
+	if a, err := strconv.Atoi(aVersion); err == nil {
+		if b, err := strconv.Atoi(bVersion); err == nil {
+			return b - a
+		}
+	}
+	return -comparePadded(aVersion, bVersion)
+}
+
+func fileLengthToSectors(l uint32) uint32 {
+	if (l % sectorSize) == 0 {
+		return l / sectorSize
+	}
+
+	return (l / sectorSize) + 1
+}
+
+// sizeToSectors returns the number of sectors holding data of the given size, which can exceed 4 GiB
+func sizeToSectors(size int64) uint32 {
+	return uint32((size + int64(sectorSize) - 1) / int64(sectorSize))
+}
+
+// layoutNode is a staged entry together with its placement in the image
+type layoutNode struct {
+	entry      *stagedEntry
+	parent     *layoutNode
+	children   []*layoutNode
+	identifier string
+	location   uint32
+	depth      int32
+	// length is the size of the data of a file, which is split into several extents if it exceeds maxExtentLength
+	length int64
+
+	// childLink is set on the placeholder left in the original place of a relocated directory
+	childLink *layoutNode
+	// relocatedFrom is the original parent of a relocated directory
+	relocatedFrom *layoutNode
+
+	// source is the data written for the file, which differs from the entry's source if it was compressed
+	source stagedSource
+	zisofs *zisofsInfo
+
+	// continuationLocation is the first sector of the directory's continuation area, if it has one
+	continuationLocation uint32
+
+	// generated is set on nodes which aren't part of the staged tree, like RR_MOVED and TRANS.TBL
+	generated bool
+	// inPlace is set on files whose data is left where it is in the image being edited, see ImageEditor
+	inPlace bool
+
+	// links caches nlink of directories and holds the number of hard links to a file
+	links uint32
+	// extendedAttributeSectors is the length of the Extended Attribute Record in front of the data of a file
+	extendedAttributeSectors uint32
+}
+
+type writeContext struct {
+	// ctx interrupts the write when it is done, see WriteToContext
+	ctx context.Context
+
+	rockRidge        bool
+	relocateDeepDirs bool
+	interchangeLevel int
+	names            NameTranslation
+	omitVersion      bool
+	fileVersions     bool
+	transliterate    bool
+	volumeSequence   uint16
+	// blockSize is the logical block size the locations are recorded in, 0 for the size of a sector
+	blockSize         uint32
+	zisofs            *ZisofsOptions
+	deduplicate       bool
+	padSectors        uint32
+	timestamp         time.Time
+	freeSectorPointer uint32
+
+	// extendedAttributes writes Extended Attribute Records in front of the data of files, see SetExtendedAttributeRecords
+	extendedAttributes bool
+
+	defaultAlignment uint32
+	alignments       map[*stagedEntry]uint32
+	fixedLBAs        map[*stagedEntry]uint32
+	// sortWeight orders the extents of the files, see SetSortWeight
+	sortWeight func(isoPath string) int
+	// inPlace maps the sources of an edited image's files to the first sectors of their data, see ImageEditor
+	inPlace map[stagedSource]uint32
+
+	// dataStart is the first sector after the directories, where the file data begins
+	dataStart uint32
+
+	// pathTable lists the directories in path table order, set by allocate
+	pathTable          []*layoutNode
+	pathTableSize      uint32
+	lPathTableLocation uint32
+	mPathTableLocation uint32
+
+	// fixedTime replaces the staged times with the timestamp, see WriterOptions.FixedTimestamp
+	fixedTime        bool
+	dirTimes         DirectoryTimePolicy
+	dirTime          time.Time
+	newestChildTimes map[*stagedEntry]time.Time
+
+	// ownerMap maps the owners recorded in the PX entries, see SetOwnerMap
+	ownerMap func(isoPath string, uid, gid uint32) (uint32, uint32)
+
+	// newStagingFile provides paths for the temporary files created while writing
+	newStagingFile func() (string, error)
+
+	// the file data is written in buffers of dataBufferSize bytes, up to dataBuffers of which are read ahead
+	dataBufferSize int
+	dataBuffers    int
+	readahead      bool
+	temporaryFiles []string
+
+	root        *layoutNode
+	directories []*layoutNode // in breadth-first order
+	files       []*layoutNode
+
+	// relocationDir is the RR_MOVED directory, created when the first deep directory is relocated
+	relocationDir        *layoutNode
+	relocatedIdentifiers identifierSet
+
+	// rockRidgeExtension is recorded in the ER entry of the root directory, followed by the registered extensions
+	rockRidgeExtension *ExtensionRecord
+	extensions         []ExtensionRecord
+	// serialNumbers holds the file serial numbers of the PX entries if RRIP 1.12 is written,
+	// keyed by staged entry or, for hard links, by their shared source; RRIP 1.09 records RR entries instead
+	serialNumbers map[interface{}]uint32
+
+	// transTables are the generated TRANS.TBL files, if enabled
+	generateTransTables bool
+	transTables         []*layoutNode
+
+	// joliet and enhanced are the Joliet and the ISO 9660:1999 hierarchies, if enabled
+	joliet   *hierarchy
+	enhanced *hierarchy
+
+	// boot holds the entries of the El Torito boot catalog, if there is one
+	boot                []stagedBootEntry
+	bootCatalogLocation uint32
+
+	// hybrid holds the partition tables of the system area, if enabled with SetHybrid
+	hybrid *hybridLayout
+}
+
+func (wc *writeContext) allocateSectors(n uint32) uint32 {
+	return atomic.AddUint32(&wc.freeSectorPointer, n) - n
+}
+
+// buildLayout converts the staged tree into layout nodes and assigns sectors to them.
+// Directories are placed first in breadth-first order, followed by file data.
+func (wc *writeContext) buildLayout(root *stagedEntry) error {
+	if err := wc.buildTree(root); err != nil {
+		return err
+	}
+	return wc.allocate()
+}
+
+// buildTree converts the staged tree into layout nodes with their identifiers
+func (wc *writeContext) buildTree(root *stagedEntry) error {
+	wc.root = &layoutNode{entry: root, identifier: string([]byte{0}), depth: 1}
+	wc.root.parent = wc.root
+	wc.directories = []*layoutNode{wc.root}
+	if len(wc.extensions) > 0 && !wc.rockRidge {
+		return errors.New("registered SUSP extensions require Rock Ridge to be enabled")
+	}
+	if len(root.info().systemUse) > 0 && !wc.rockRidge {
+		return errors.New("the root directory has System Use entries, which require Rock Ridge to be enabled")
+	}
+
+	for i := 0; i < len(wc.directories); i++ {
+		dir := wc.directories[i]
+		if dir == wc.relocationDir {
+			// its children are added by relocate, with identifiers unique within RR_MOVED
+			continue
+		}
+
+		// the nodes of the children are allocated together, which saves memory on huge trees
+		nodes := make([]layoutNode, len(dir.entry.children))
+		dir.children = make([]*layoutNode, len(nodes))
+		for n, c := range dir.entry.children {
+			nodes[n] = layoutNode{entry: c, parent: dir, depth: dir.depth + 1}
+			dir.children[n] = &nodes[n]
+		}
+		if err := wc.assignIdentifiers(dir.children); err != nil {
+			return err
 		}
 
+		for _, node := range dir.children {
+			c := node.entry
+			if len(c.info().systemUse) > 0 && !wc.rockRidge {
+				return fmt.Errorf("%s has System Use entries, which require Rock Ridge to be enabled", c.path())
+			}
+			if c.isDir() {
+				if node.depth > maxDirectoryDepth && wc.relocateDeepDirs {
+					if err := wc.relocate(node); err != nil {
+						return err
+					}
+					continue
+				}
+				wc.directories = append(wc.directories, node)
+				continue
+			}
+
+			node.source = c.source
+			wc.files = append(wc.files, node)
+		}
+
+		if wc.generateTransTables {
+			if err := wc.addTransTable(dir); err != nil {
+				return err
+			}
+		}
+	}
+
+	// relocation can add children to RR_MOVED after it has been visited, so sort at the end
+	for _, dir := range wc.directories {
+		sort.SliceStable(dir.children, func(i, j int) bool {
+			return compareIdentifiers(dir.children[i].identifier, dir.children[j].identifier) < 0
+		})
+	}
+	wc.fillTransTables()
+
+	return nil
+}
+
+// allocate assigns sectors to the directories and files of the tree
+func (wc *writeContext) allocate() error {
+	// compression has to happen before sizing the directories, as it adds ZF entries
+	if wc.zisofs != nil {
+		if err := wc.compressFiles(); err != nil {
+			return err
+		}
+	}
+	if wc.extendedAttributes {
+		if err := wc.addExtendedAttributeRecords(); err != nil {
+			return err
+		}
+	}
+
+	// hard linked files share the staged source, count them first for the PX entries of the directories
+	links := make(map[stagedSource]uint32)
+	for _, file := range wc.files {
+		if link, ok := file.entry.source.(*hardLinkSource); ok {
+			if links[link.stagedSource] == 0 {
+				links[link.stagedSource] = 1
+			}
+			links[link.stagedSource]++
+		}
+	}
+	for _, file := range wc.files {
+		if file.entry.source != nil {
+			file.links = links[sourceIdentity(file.entry.source)]
+		}
+	}
+
+	// the path tables precede the directories
+	wc.buildPathTable()
+	pathTableSectors := fileLengthToSectors(wc.pathTableSize)
+	wc.lPathTableLocation = wc.allocateSectors(pathTableSectors)
+	wc.mPathTableLocation = wc.allocateSectors(pathTableSectors)
+
+	for _, dir := range wc.directories {
+		var extent directoryExtent
+		continuation, err := wc.directoryRecords(dir, func(record []byte) error {
+			extent.place(uint32(len(record)))
+			return nil
+		})
 		if err != nil {
+			return fmt.Errorf("processing %s: %w", dir.entry.path(), err)
+		}
+		sectors := extent.sectorCount()
+		if f := directorySizeFinding(dir, sectors); f != nil {
+			return &ValidationError{Findings: []Finding{*f}}
+		}
+		dir.location = wc.allocateSectors(sectors)
+		dir.length = int64(sectors) * int64(sectorSize)
+		// the continuation area follows the directory extent, its size doesn't depend on the locations
+		dir.continuationLocation = wc.allocateSectors(continuation.sectors())
+	}
+	for _, h := range wc.hierarchies() {
+		if err := wc.allocateHierarchy(h); err != nil {
 			return err
 		}
 	}
+	if len(wc.boot) > 0 {
+		wc.bootCatalogLocation = wc.allocateSectors(1)
+	}
+
+	var extents map[contentKey]*layoutNode
+	// sizes counts the files of every size, a file of a size no other file has cannot be a duplicate
+	sizes := make(map[int64]int)
+	if wc.deduplicate {
+		extents = make(map[contentKey]*layoutNode)
+		for _, file := range wc.files {
+			if file.source != nil {
+				sizes[file.source.Size()]++
+			}
+		}
+	}
+	linked := make(map[stagedSource]*layoutNode)
+	// owners maps the files sharing an extent to the file whose data is written,
+	// alignments holds the alignment the extents of those files require and pins their fixed locations
+	owners := make(map[*layoutNode]*layoutNode)
+	alignments := make(map[*layoutNode]uint32)
+	pins := make(map[*layoutNode]uint32)
+
+	for _, file := range wc.files {
+		owner := file
+		if file.links > 0 {
+			identity := sourceIdentity(file.entry.source)
+			if first := linked[identity]; first != nil {
+				// the data is written once, for the first of the linked files
+				file.length = first.length
+				file.zisofs = first.zisofs
+				owner = first
+			} else {
+				linked[identity] = file
+			}
+		}
+
+		if owner == file && file.source != nil {
+			file.length = file.source.Size()
+		}
+		if lba, ok := wc.inPlace[sourceIdentity(file.source)]; ok && owner == file {
+			file.location, file.inPlace = lba, true
+			continue
+		}
+
+		if owner == file && extents != nil && file.length > 0 && sizes[file.length] > 1 {
+			key, err := sourceContentKey(file.source)
+			if err != nil {
+				return fmt.Errorf("processing %s: %w", file.entry.path(), err)
+			}
+			if first := extents[key]; first != nil {
+				// the data is written once, for the first file
+				owner = first
+			} else {
+				extents[key] = file
+			}
+		}
+
+		if owner != file {
+			file.source = nil
+			for owners[owner] != nil {
+				owner = owners[owner]
+			}
+			owners[file] = owner
+		}
+
+		alignment, err := wc.fileAlignment(file.entry)
+		if err == nil {
+			alignment, err = combineAlignments(alignments[owner], alignment)
+		}
+		if err != nil {
+			return fmt.Errorf("processing %s: %w", file.entry.path(), err)
+		}
+		if alignment > 1 {
+			alignments[owner] = alignment
+		}
+
+		if lba, ok := wc.fixedLBAs[file.entry]; ok {
+			if pinned, ok := pins[owner]; ok && pinned != lba {
+				return fixedLBAError(file.entry.path(), "shares its extent with %s, which is pinned to sector %d", owner.entry.path(), pinned)
+			}
+			pins[owner] = lba
+		}
+	}
+
+	wc.dataStart = wc.freeSectorPointer
+	reserved, err := wc.reserveFixedLBAs(pins)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range wc.allocationOrder(owners) {
+		if owners[file] != nil || file.inPlace {
+			continue
+		}
+		if lba, ok := pins[file]; ok {
+			file.location = lba
+			continue
+		}
+		file.location = wc.allocateAround(sizeToSectors(file.length), alignments[file], reserved)
+	}
+	// the pinned extents can lie beyond all others
+	for _, file := range reserved {
+		if end := file.location + sizeToSectors(file.length); end > wc.freeSectorPointer {
+			wc.freeSectorPointer = end
+		}
+	}
+	for _, file := range wc.files {
+		if owner := owners[file]; owner != nil {
+			file.location = owner.location
+		}
+	}
+
+	if len(wc.boot) > 0 {
+		if err := wc.resolveBootEntries(); err != nil {
+			return err
+		}
+	}
+
+	// the padding follows all the data and is written by writeAll
+	wc.allocateSectors(wc.padSectors)
 
 	return nil
 }
 
-// WriteTo writes the image to the given WriterAt
-func (iw *ImageWriter) WriteTo(w io.Writer, volumeIdentifier string) error {
-	now := time.Now()
+// contentKey identifies the contents of a file for deduplication
+type contentKey struct {
+	size   int64
+	sha256 [sha256.Size]byte
+}
 
-	wc := writeContext{
-		stagingDir:        iw.stagingDir,
-		timestamp:         RecordingTimestamp{},
-		freeSectorPointer: 18, // system area (16) + 2 volume descriptors
+func sourceContentKey(source stagedSource) (contentKey, error) {
+	f, err := source.Open()
+	if err != nil {
+		return contentKey{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return contentKey{}, err
 	}
 
-	rootDE, err := wc.createDEForRoot()
+	key := contentKey{size: source.Size()}
+	h.Sum(key.sha256[:0])
+	return key, nil
+}
+
+// relocate moves a deep directory into RR_MOVED and turns the node in its original place into a placeholder
+func (wc *writeContext) relocate(placeholder *layoutNode) error {
+	if wc.relocationDir == nil {
+		for _, c := range wc.root.children {
+			if strings.EqualFold(c.identifier, relocationDirectoryIdentifier) {
+				return fmt.Errorf("cannot relocate %s: %s already exists", placeholder.entry.path(), c.entry.path())
+			}
+		}
+
+		entry := newStagedDirectory(relocationDirectoryName, wc.timestamp)
+		entry.edit().identifier = relocationDirectoryIdentifier
+		entry.hidden = true
+		entry.parent = wc.root.entry
+
+		wc.relocationDir = &layoutNode{
+			entry:      entry,
+			parent:     wc.root,
+			identifier: relocationDirectoryIdentifier,
+			depth:      2,
+			generated:  true,
+		}
+		wc.relocatedIdentifiers = make(identifierSet)
+		wc.root.children = append(wc.root.children, wc.relocationDir)
+		wc.directories = append(wc.directories, wc.relocationDir)
+	}
+
+	// the identifiers of relocated directories have to be unique within RR_MOVED
+	identifier, err := wc.relocatedIdentifiers.add(placeholder.entry, mangledName{
+		base:    placeholder.identifier,
+		maxBase: limitsForLevel(wc.interchangeLevel).directory,
+		isDir:   true,
+	})
 	if err != nil {
-		return fmt.Errorf("creating root directory descriptor: %s", err)
+		return err
+	}
+
+	moved := &layoutNode{
+		entry:         placeholder.entry,
+		parent:        wc.relocationDir,
+		identifier:    identifier,
+		depth:         wc.relocationDir.depth + 1,
+		relocatedFrom: placeholder.parent,
+	}
+	placeholder.childLink = moved
+	wc.relocationDir.children = append(wc.relocationDir.children, moved)
+	wc.directories = append(wc.directories, moved)
+	return nil
+}
+
+// checkStreamedFiles rejects the features which need the data of streamed files before it is written
+// or more than once, and the files streamed by a previous write
+func (wc *writeContext) checkStreamedFiles(implantMD5 bool) error {
+	for _, file := range wc.files {
+		stream, ok := file.source.(*streamSource)
+		if !ok {
+			continue
+		}
+
+		var feature string
+		switch {
+		case implantMD5:
+			feature = "an implanted MD5 checksum"
+		case wc.deduplicate:
+			feature = "deduplication"
+		case wc.zisofs != nil:
+			feature = "zisofs compression"
+		}
+		if feature != "" {
+			return fmt.Errorf("%s is streamed, which rules out %s", file.entry.path(), feature)
+		}
+
+		stream.mu.Lock()
+		consumed := stream.consumed
+		stream.mu.Unlock()
+		if consumed {
+			return fmt.Errorf("%s: %w", file.entry.path(), errStreamedFileConsumed)
+		}
+	}
+	return nil
+}
+
+// compressFiles replaces the sources of files which shrink with zisofs by their compressed form.
+// Files of 4 GiB and more, whose size zisofs cannot record, are stored uncompressed.
+func (wc *writeContext) compressFiles() error {
+	for _, file := range wc.files {
+		if file.source == nil || file.source.Size() == 0 || file.source.Size() < wc.zisofs.MinSize || file.source.Size() > zisofsMaxSize {
+			continue
+		}
+		if err := wc.interrupted(PhaseCompressing, file.entry.path()); err != nil {
+			return err
+		}
+
+		stagingPath, err := wc.newStagingFile()
+		if err != nil {
+			return err
+		}
+
+		compressed, info, err := compressToStaging(stagingPath, file.source, wc.zisofs)
+		if err != nil {
+			return fmt.Errorf("compressing %s: %w", file.entry.path(), err)
+		}
+		if compressed == nil {
+			continue
+		}
+
+		wc.temporaryFiles = append(wc.temporaryFiles, stagingPath)
+		file.source = compressed
+		file.zisofs = info
 	}
 
-	rootItem := itemToWrite{
-		isDirectory:  true,
-		dirPath:      wc.stagingDir,
-		ownEntry:     rootDE,
-		parentEntery: rootDE,
-		targetSector: uint32(rootDE.ExtentLocation),
+	return nil
+}
+
+// removeTemporaryFiles deletes the files created during writing
+func (wc *writeContext) removeTemporaryFiles() {
+	for _, p := range wc.temporaryFiles {
+		_ = os.Remove(p)
+	}
+	wc.temporaryFiles = nil
+}
+
+// nlink returns the number of links to the entry, as reported in the RR PX entry.
+// The count of a directory is cached, as it is needed for the ".." entry of every subdirectory and the tree is final by then.
+func (n *layoutNode) nlink() uint32 {
+	if !n.entry.isDir() {
+		if n.links > 0 {
+			return n.links
+		}
+		return 1
 	}
 
-	itemsToWrite, err := wc.traverseStagingDir(rootItem)
+	if n.links == 0 {
+		n.links = 2
+		for _, c := range n.children {
+			if c.entry.isDir() {
+				n.links++
+			}
+		}
+	}
+	return n.links
+}
+
+// rockRidgeEntries returns the Rock Ridge attributes of the entry
+func (wc *writeContext) rockRidgeEntries(n *layoutNode) []SystemUseEntry {
+	e := n.entry
+	uid, gid := e.uid, e.gid
+	if wc.ownerMap != nil {
+		uid, gid = wc.ownerMap(e.path(), uid, gid)
+	}
+	entries := []SystemUseEntry{
+		marshalRockRidgePosixEntry(e.mode, n.nlink(), uid, gid, wc.serialNumber(n)),
+		marshalRockRidgeTimestampEntry(wc.recordTimes(e)),
+	}
+	if e.mode&os.ModeSymlink != 0 {
+		entries = append(entries, marshalRockRidgeSymlinkEntries(e.info().symlinkTarget)...)
+	}
+	if e.mode&os.ModeDevice != 0 {
+		entries = append(entries, marshalRockRidgeDeviceEntry(e.info().devMajor, e.info().devMinor))
+	}
+	if n.zisofs != nil {
+		entries = append(entries, marshalZisofsEntry(n.zisofs))
+	}
+	return entries
+}
+
+// serialNumber returns the file serial number of the entry, numbered in the order the entries are first recorded,
+// or 0 if RRIP 1.09 is written. Hard links share their number.
+func (wc *writeContext) serialNumber(n *layoutNode) uint32 {
+	if wc.serialNumbers == nil {
+		return 0
+	}
+	var key interface{} = n.entry
+	if !n.entry.isDir() && n.links > 0 {
+		key = sourceIdentity(n.entry.source)
+	}
+	serial, ok := wc.serialNumbers[key]
+	if !ok {
+		serial = uint32(len(wc.serialNumbers) + 1)
+		wc.serialNumbers[key] = serial
+	}
+	return serial
+}
+
+// withRockRidgeFlags puts the RR entry of RRIP 1.09 before the entries of a record, after the SP entry of the root.
+// RRIP 1.12 has no RR entries, the entries are returned unchanged then.
+func (wc *writeContext) withRockRidgeFlags(su []SystemUseEntry) []SystemUseEntry {
+	if wc.serialNumbers != nil {
+		return su
+	}
+	at := 0
+	if len(su) > 0 && su[0].Type() == "SP" {
+		at = 1
+	}
+	entries := make([]SystemUseEntry, 0, len(su)+1)
+	entries = append(entries, su[:at]...)
+	entries = append(entries, marshalRockRidgeFlagsEntry(su))
+	return append(entries, su[at:]...)
+}
+
+// directoryEntry creates the record describing the node
+func (wc *writeContext) directoryEntry(n *layoutNode, identifier string) *DirectoryEntry {
+	var fileFlags byte
+	// the placeholder of a relocated directory is recorded as a file
+	if n.entry.isDir() && n.childLink == nil {
+		fileFlags |= dirFlagDir
+	}
+	if n.entry.hidden && identifier != string([]byte{0}) && identifier != string([]byte{1}) {
+		fileFlags |= dirFlagHidden
+	}
+
+	recordingTime := wc.recordTimes(n.entry).Recording
+
+	return &DirectoryEntry{
+		ExtendedAtributeRecordLength: 0,
+		ExtentLocation:               int32(wc.blocks(n.location)),
+		ExtentLength:                 uint32(n.length),
+		RecordingDateTime:            RecordingTimestamp(recordingTime),
+		FileFlags:                    fileFlags,
+		FileUnitSize:                 0, // 0 for non-interleaved write
+		InterleaveGap:                0, // not interleaved
+		VolumeSequenceNumber:         int16(wc.volumeSequence),
+		Identifier:                   identifier,
+	}
+}
+
+// directoryRecords marshals the records of a directory one at a time and passes them to fn,
+// starting with the "." and ".." entries, so that large directories aren't held in memory.
+// It returns the continuation area holding the System Use entries which don't fit into the records.
+func (wc *writeContext) directoryRecords(dir *layoutNode, fn func(record []byte) error) (*continuationArea, error) {
+	var dotSU, dotdotSU []SystemUseEntry
+	if wc.rockRidge {
+		if dir == wc.root {
+			// SUSP-112 5.3 requires the SP entry to be the first one in the root's "." entry
+			dotSU = append(dotSU, marshalSPEntry(0))
+		}
+		dotSU = append(dotSU, wc.rockRidgeEntries(dir)...)
+		if dir == wc.root {
+			dotSU = append(dotSU, marshalEREntry(wc.rockRidgeExtension))
+			for n := range wc.extensions {
+				dotSU = append(dotSU, marshalEREntry(&wc.extensions[n]))
+			}
+			dotSU = append(dotSU, dir.entry.info().systemUse...)
+		}
+		if dir.relocatedFrom != nil {
+			dotdotSU = append(wc.rockRidgeEntries(dir.relocatedFrom), marshalRockRidgeLocationEntry("PL", wc.blocks(dir.relocatedFrom.location)))
+		} else {
+			dotdotSU = wc.rockRidgeEntries(dir.parent)
+		}
+		dotSU, dotdotSU = wc.withRockRidgeFlags(dotSU), wc.withRockRidgeFlags(dotdotSU)
+	}
+
+	continuation := &continuationArea{location: dir.continuationLocation, blockSize: wc.blockSize}
+	record := func(de *DirectoryEntry, su []SystemUseEntry) error {
+		systemUse := continuation.fitSystemUse(su, maxDirectoryRecordLength-directoryRecordLength(de.Identifier, 0))
+		de.SystemUse = joinSystemUseEntries(systemUse)
+		data, err := de.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return fn(data)
+	}
+
+	if err := record(wc.directoryEntry(dir, string([]byte{0})), dotSU); err != nil {
+		return nil, err
+	}
+	if err := record(wc.directoryEntry(dir.parent, string([]byte{1})), dotdotSU); err != nil {
+		return nil, err
+	}
+
+	for _, c := range dir.children {
+		var su []SystemUseEntry
+		if wc.rockRidge {
+			su = marshalRockRidgeNameEntries(c.entry.name)
+			switch {
+			case c.childLink != nil:
+				su = append(su, wc.rockRidgeEntries(c.childLink)...)
+				su = append(su, marshalRockRidgeLocationEntry("CL", wc.blocks(c.childLink.location)))
+				su = append(su, c.entry.info().systemUse...)
+			case c.relocatedFrom != nil:
+				su = append(su, wc.rockRidgeEntries(c)...)
+				su = append(su, marshalRockRidgeRelocatedEntry())
+			default:
+				su = append(su, wc.rockRidgeEntries(c)...)
+				su = append(su, c.entry.info().systemUse...)
+			}
+			su = wc.withRockRidgeFlags(su)
+		}
+		// every extent of a large file gets the entries, as readers look at either the first or the last record
+		for _, de := range wc.directoryEntries(c, c.identifier) {
+			if err := record(de, su); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return continuation, nil
+}
+
+// directoryExtent places the records of a directory into sectors.
+// ECMA-119 6.8.1.1: if a record won't fit into the rest of a sector,
+// the rest is filled with zeros and the record starts in the next sector.
+type directoryExtent struct {
+	sectors  uint32
+	occupied uint32
+}
+
+// place adds a record of the given length and returns the number of zeros to write before it
+func (e *directoryExtent) place(length uint32) uint32 {
+	if e.sectors == 0 {
+		e.sectors = 1
+	}
+	if e.occupied+length > sectorSize {
+		padding := sectorSize - e.occupied
+		e.sectors++
+		e.occupied = length
+		return padding
+	}
+	e.occupied += length
+	return 0
+}
+
+// sectorCount returns the number of sectors occupied by the records
+func (e *directoryExtent) sectorCount() uint32 {
+	if e.sectors == 0 {
+		return 1
+	}
+	return e.sectors
+}
+
+// remainder returns the number of zeros which fill the last sector after the records
+func (e *directoryExtent) remainder() uint32 {
+	return sectorSize - e.occupied
+}
+
+// processDirectory writes the records of a directory to the destination sectors and returns its continuation area
+func (wc *writeContext) processDirectory(w io.Writer, dir *layoutNode) (*continuationArea, error) {
+	var extent directoryExtent
+	zeros := make([]byte, sectorSize)
+
+	continuation, err := wc.directoryRecords(dir, func(record []byte) error {
+		if padding := extent.place(uint32(len(record))); padding > 0 {
+			if _, err := w.Write(zeros[:padding]); err != nil {
+				return err
+			}
+		}
+		_, err := w.Write(record)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("tranversing staging directory: %s", err)
+		return nil, err
+	}
+
+	if _, err = w.Write(zeros[:extent.remainder()]); err != nil {
+		return nil, err
+	}
+	return continuation, nil
+}
+
+func (wc *writeContext) writeAll(w io.Writer) error {
+	if err := wc.interrupted(PhasePathTables, ""); err != nil {
+		return err
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		table, err := wc.marshalPathTable(order)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(table); err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range wc.directories {
+		if err := wc.interrupted(PhaseDirectories, dir.entry.path()); err != nil {
+			return err
+		}
+		continuation, err := wc.processDirectory(w, dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir.entry.path(), err)
+		}
+		if _, err = w.Write(continuation.bytes()); err != nil {
+			return fmt.Errorf("%s: %w", dir.entry.path(), err)
+		}
+	}
+	for _, h := range wc.hierarchies() {
+		if err := wc.writeHierarchy(w, h); err != nil {
+			return err
+		}
+	}
+	if len(wc.boot) > 0 {
+		if _, err := w.Write(wc.marshalBootCatalog()); err != nil {
+			return err
+		}
+	}
+
+	// the extents are written in the order of their locations, with zeroes in the gaps left by alignment and pinning
+	written := wc.writtenFiles()
+
+	if wc.hybrid == nil || wc.hybrid.backup == nil {
+		return wc.writeData(w, written, wc.dataStart, wc.freeSectorPointer)
+	}
+	// the backup GPT takes the last sectors of the image
+	if err := wc.writeData(w, written, wc.dataStart, wc.hybrid.backupLocation); err != nil {
+		return err
 	}
+	_, err := w.Write(wc.hybrid.backup)
+	return err
+}
+
+// newWriteContext creates the context for laying out and writing the staged tree with the writer's options
+func (iw *ImageWriter) newWriteContext(now time.Time) *writeContext {
+	rrip := iw.rrip
+	if rrip == "" {
+		rrip = RockRidgeIdentifier1991A
+	}
+	// the identifier has been checked by SetRockRidgeIdentifier
+	extension, _ := rockRidgeExtensionRecord(rrip)
+
+	wc := &writeContext{
+		rockRidge:           iw.rockRidge,
+		relocateDeepDirs:    iw.rockRidge && iw.deepDirs != DeepDirectoriesKeep,
+		interchangeLevel:    iw.interchangeLevel,
+		names:               iw.names,
+		transliterate:       iw.transliterate,
+		omitVersion:         iw.omitVersion,
+		fileVersions:        iw.fileVersions,
+		volumeSequence:      iw.volumeSequence,
+		blockSize:           iw.blockSize,
+		deduplicate:         iw.deduplicate,
+		extendedAttributes:  iw.extendedAttributes,
+		padSectors:          iw.padSectors,
+		zisofs:              iw.zisofs,
+		timestamp:           now,
+		fixedTime:           !iw.fixedTime.IsZero(),
+		dirTimes:            iw.dirTimes,
+		dirTime:             iw.dirTime,
+		defaultAlignment:    iw.defaultAlignment,
+		alignments:          iw.alignments,
+		fixedLBAs:           iw.fixedLBAs,
+		sortWeight:          iw.sortWeight,
+		freeSectorPointer:   18, // system area (16) + 2 volume descriptors
+		newStagingFile:      iw.newStagingFile,
+		rockRidgeExtension:  extension,
+		extensions:          iw.extensions,
+		generateTransTables: iw.transTables,
+		dataBufferSize:      iw.dataBufferSize,
+		dataBuffers:         iw.dataBuffers,
+		readahead:           iw.readahead,
+		ownerMap:            iw.ownerMap,
+	}
+	if rrip == RockRidgeIdentifierP1282 {
+		wc.serialNumbers = make(map[interface{}]uint32)
+	}
+	// the Boot Record, the Joliet and the Enhanced Volume Descriptors follow the Primary one
+	if len(iw.bootEntries) > 0 {
+		wc.boot = append([]stagedBootEntry(nil), iw.bootEntries...)
+		wc.freeSectorPointer++
+	}
+	if iw.joliet {
+		wc.joliet = newJolietHierarchy(iw.omitVersion, iw.fileVersions)
+		wc.freeSectorPointer++
+	}
+	if iw.enhancedVolume {
+		wc.enhanced = newEnhancedHierarchy()
+		wc.freeSectorPointer++
+	}
+	return wc
+}
+
+// startWrite prevents modifications of the staged tree until endWrite and returns its root
+func (iw *ImageWriter) startWrite() (*stagedEntry, error) {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	if iw.writing {
+		return nil, ErrWriteInProgress
+	}
+	iw.writing = true
+	return iw.rootEntry(), nil
+}
+
+func (iw *ImageWriter) endWrite() {
+	iw.mu.Lock()
+	iw.writing = false
+	iw.mu.Unlock()
+}
+
+// layoutImage lays out the staged tree in the write context and returns the Primary Volume Descriptor
+// and the descriptors following it, up to and including the terminator
+func (iw *ImageWriter) layoutImage(wc *writeContext, root *stagedEntry, volumeIdentifier string, now time.Time) (volumeDescriptor, []volumeDescriptor, error) {
+	if iw.zisofs != nil && !iw.rockRidge {
+		return volumeDescriptor{}, nil, errors.New("zisofs compression requires Rock Ridge to be enabled")
+	}
+	if iw.deepDirs == DeepDirectoriesRelocate && !iw.rockRidge {
+		return volumeDescriptor{}, nil, errors.New("relocating deep directories requires Rock Ridge to be enabled")
+	}
+
+	if err := wc.buildTree(root); err != nil {
+		return volumeDescriptor{}, nil, fmt.Errorf("tranversing staging directory: %s", err)
+	}
+	if err := wc.checkStreamedFiles(iw.implantMD5); err != nil {
+		return volumeDescriptor{}, nil, err
+	}
+
+	if volumeIdentifier == "" {
+		volumeIdentifier = iw.volume.VolumeIdentifier
+	}
+
+	// fail before writing anything on the errors Validate reports
+	volume := iw.volume
+	volume.VolumeIdentifier = volumeIdentifier
+	if errs := (Report{Findings: wc.validate(volume, SeverityError)}).Errors(); len(errs) > 0 {
+		return volumeDescriptor{}, nil, &ValidationError{Findings: errs}
+	}
+
+	if err := wc.allocate(); err != nil {
+		var invalid *ValidationError
+		var interrupted *IncompleteImageError
+		if errors.As(err, &invalid) || errors.As(err, &interrupted) {
+			return volumeDescriptor{}, nil, err
+		}
+		return volumeDescriptor{}, nil, fmt.Errorf("tranversing staging directory: %s", err)
+	}
+
+	if iw.hybrid != nil {
+		if len(iw.systemArea) > 0 {
+			return volumeDescriptor{}, nil, errors.New("a hybrid partition table cannot be combined with a custom system area")
+		}
+		var efiImage *stagedEntry
+		if iw.hybrid.EFIImagePath != "" {
+			efiImage = iw.lookup(iw.hybrid.EFIImagePath)
+		}
+		hybrid, err := wc.layoutHybrid(iw.hybrid, efiImage, volumeIdentifier)
+		if err != nil {
+			return volumeDescriptor{}, nil, err
+		}
+		wc.hybrid = hybrid
+	}
+
+	rootDE := wc.directoryEntry(wc.root, string([]byte{0}))
 
 	pvd := volumeDescriptor{
 		Header: volumeDescriptorHeader{
@@ -591,68 +2124,211 @@ func (iw *ImageWriter) WriteTo(w io.Writer, volumeIdentifier string) error {
 			Version:    1,
 		},
 		Primary: &PrimaryVolumeDescriptorBody{
-			SystemIdentifier:              runtime.GOOS,
+			SystemIdentifier:              iw.volume.SystemIdentifier,
 			VolumeIdentifier:              volumeIdentifier,
-			VolumeSpaceSize:               int32(wc.freeSectorPointer),
-			VolumeSetSize:                 1,
-			VolumeSequenceNumber:          1,
-			LogicalBlockSize:              int16(sectorSize),
-			PathTableSize:                 0,
-			TypeLPathTableLoc:             0,
+			VolumeSpaceSize:               int32(wc.blocks(wc.freeSectorPointer)),
+			VolumeSetSize:                 int16(iw.volumeSetSize),
+			VolumeSequenceNumber:          int16(iw.volumeSequence),
+			LogicalBlockSize:              int16(wc.logicalBlockSize()),
+			PathTableSize:                 int32(wc.pathTableSize),
+			TypeLPathTableLoc:             int32(wc.blocks(wc.lPathTableLocation)),
 			OptTypeLPathTableLoc:          0,
-			TypeMPathTableLoc:             0,
+			TypeMPathTableLoc:             int32(wc.blocks(wc.mPathTableLocation)),
 			OptTypeMPathTableLoc:          0,
 			RootDirectoryEntry:            rootDE,
-			VolumeSetIdentifier:           "",
-			PublisherIdentifier:           "",
-			DataPreparerIdentifier:        "",
-			ApplicationIdentifier:         "github.com/kdomanski/iso9660",
-			CopyrightFileIdentifier:       "",
-			AbstractFileIdentifier:        "",
-			BibliographicFileIdentifier:   "",
-			VolumeCreationDateAndTime:     VolumeDescriptorTimestampFromTime(now),
-			VolumeModificationDateAndTime: VolumeDescriptorTimestampFromTime(now),
-			VolumeExpirationDateAndTime:   VolumeDescriptorTimestamp{},
-			VolumeEffectiveDateAndTime:    VolumeDescriptorTimestampFromTime(now),
+			VolumeSetIdentifier:           iw.volume.VolumeSetIdentifier,
+			PublisherIdentifier:           iw.volume.PublisherIdentifier,
+			DataPreparerIdentifier:        iw.volume.DataPreparerIdentifier,
+			ApplicationIdentifier:         iw.volume.ApplicationIdentifier,
+			CopyrightFileIdentifier:       iw.volume.CopyrightFileIdentifier,
+			AbstractFileIdentifier:        iw.volume.AbstractFileIdentifier,
+			BibliographicFileIdentifier:   iw.volume.BibliographicFileIdentifier,
+			VolumeCreationDateAndTime:     volumeTimestamp(iw.volumeTimes.Creation, now),
+			VolumeModificationDateAndTime: volumeTimestamp(iw.volumeTimes.Modification, now),
+			VolumeExpirationDateAndTime:   volumeTimestamp(iw.volumeTimes.Expiration, time.Time{}),
+			VolumeEffectiveDateAndTime:    volumeTimestamp(iw.volumeTimes.Effective, now),
 			FileStructureVersion:          1,
 			ApplicationUsed:               [512]byte{},
 		},
 	}
 
-	terminator := volumeDescriptor{
+	var descriptors []volumeDescriptor
+	if len(wc.boot) > 0 {
+		descriptors = append(descriptors, wc.bootRecord())
+	}
+	if wc.joliet != nil {
+		descriptors = append(descriptors, wc.jolietVolumeDescriptor(pvd.Primary))
+	}
+	if wc.enhanced != nil {
+		descriptors = append(descriptors, wc.enhancedVolumeDescriptor(pvd.Primary))
+	}
+	descriptors = append(descriptors, volumeDescriptor{
 		Header: volumeDescriptorHeader{
 			Type:       volumeTypeTerminator,
 			Identifier: standardIdentifierBytes,
 			Version:    1,
 		},
+	})
+
+	return pvd, descriptors, nil
+}
+
+// WriteTo writes the image to the given WriterAt.
+// If volumeIdentifier is empty, the identifier from the VolumeMetadata is used.
+//
+// The children of every directory are sorted by their identifiers,
+// so the layout doesn't depend on the order in which the entries were added.
+func (iw *ImageWriter) WriteTo(w io.Writer, volumeIdentifier string) error {
+	return iw.WriteToContext(context.Background(), w, volumeIdentifier)
+}
+
+// WriteToContext writes the image like WriteTo, but stops when the context is done.
+// It checks the context before every staged file it compresses, every directory,
+// every file and every batch of file data it writes, see WriterOptions.DataBufferSize.
+//
+// The write then fails with an *IncompleteImageError, which wraps the error of the context
+// and tells the phase and the path in progress. What was written to w until then isn't a valid image.
+// The temporary files of the write are removed, and Cleanup releases the staging area as usual.
+func (iw *ImageWriter) WriteToContext(ctx context.Context, w io.Writer, volumeIdentifier string) error {
+	root, err := iw.startWrite()
+	if err != nil {
+		return err
 	}
+	defer iw.endWrite()
 
-	// write 16 sectors of zeroes
-	zeroSector := bytes.Repeat([]byte{0}, int(sectorSize))
-	for i := uint32(0); i < 16; i++ {
-		if _, err = w.Write(zeroSector); err != nil {
+	now := iw.now()
+	wc := iw.newWriteContext(now)
+	wc.ctx = ctx
+	defer wc.removeTemporaryFiles()
+
+	pvd, descriptors, err := iw.layoutImage(wc, root, volumeIdentifier, now)
+	if err != nil {
+		return err
+	}
+
+	produceImage := func(w io.Writer) error {
+		if err := wc.interrupted(PhaseVolumeDescriptor, ""); err != nil {
 			return err
 		}
+
+		// write the system area, padded to 16 sectors with zeroes
+		systemArea := make([]byte, systemAreaSize)
+		copy(systemArea, iw.systemArea)
+		if wc.hybrid != nil {
+			systemArea = wc.hybrid.systemArea
+		}
+		if _, err := w.Write(systemArea); err != nil {
+			return err
+		}
+
+		buffer, err := pvd.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(buffer); err != nil {
+			return err
+		}
+
+		for _, vd := range descriptors {
+			if buffer, err = vd.MarshalBinary(); err != nil {
+				return err
+			}
+			if _, err = w.Write(buffer); err != nil {
+				return err
+			}
+		}
+
+		if err = wc.writeAll(w); err != nil {
+			var interrupted *IncompleteImageError
+			if errors.As(err, &interrupted) {
+				return err
+			}
+			return fmt.Errorf("writing files: %w", err)
+		}
+
+		return nil
 	}
 
-	buffer, err := pvd.MarshalBinary()
-	if err != nil {
-		return err
+	var reporter *progressReporter
+	if iw.progress != nil {
+		passes := 1
+		if iw.implantMD5 {
+			passes = 2
+		}
+		reporter = wc.newProgressReporter(iw.progress, 0, passes)
 	}
-	if _, err = w.Write(buffer); err != nil {
-		return err
+	reported := func(w io.Writer) io.Writer {
+		if reporter == nil {
+			return w
+		}
+		return reporter.pass(w, 0)
 	}
 
-	if buffer, err = terminator.MarshalBinary(); err != nil {
+	// an interrupted write reports how much of the image was written
+	writeImage := func(dst io.Writer) error {
+		w := &countingWriter{w: reported(dst)}
+		err := produceImage(w)
+		var interrupted *IncompleteImageError
+		if errors.As(err, &interrupted) {
+			interrupted.Written = w.written
+		}
 		return err
 	}
-	if _, err = w.Write(buffer); err != nil {
-		return err
+
+	if iw.implantMD5 {
+		// the checksum covers the whole image, which is produced once more to compute it
+		length := int64(wc.freeSectorPointer-isoMD5SkipSectors) * int64(sectorSize)
+		hasher := newISOMD5Hasher(length, isoMD5FragmentCount)
+		// nothing reaches w in this pass
+		if err := produceImage(reported(hasher)); err != nil {
+			return fmt.Errorf("computing the MD5 checksum: %w", err)
+		}
+		pvd.Primary.ApplicationUsed = hasher.result(isoMD5SkipSectors).marshal()
 	}
 
-	if err = writeAll(w, itemsToWrite); err != nil {
-		return fmt.Errorf("writing files: %s", err)
+	if !iw.dense {
+		if sparse, ok := newSparseWriter(w); ok {
+			if err := writeImage(sparse); err != nil {
+				return err
+			}
+			return sparse.Close()
+		}
 	}
 
-	return nil
+	return writeImage(w)
+}
+
+// EstimateSize returns the size in bytes of the image WriteTo would write with the staged entries and the options,
+// which includes the directories, the path tables, the continuation areas, the alignment of the files and the padding.
+// The image is laid out without writing anything, so that the output can be preallocated or checked against
+// the capacity of the media. Only the files compressed with zisofs and, with SetDeduplicate, the files which
+// have the same size as others are read, to tell their compressed sizes and which of them are identical.
+// It fails where WriteTo would fail before writing, e.g. with a *ValidationError.
+func (iw *ImageWriter) EstimateSize() (int64, error) {
+	root, err := iw.startWrite()
+	if err != nil {
+		return 0, err
+	}
+	defer iw.endWrite()
+
+	now := iw.now()
+	wc := iw.newWriteContext(now)
+	defer wc.removeTemporaryFiles()
+
+	if _, _, err := iw.layoutImage(wc, root, "", now); err != nil {
+		return 0, err
+	}
+	return int64(wc.freeSectorPointer) * int64(sectorSize), nil
+}
+
+// volumeTimestamp encodes the time of a volume descriptor, or the fallback if it is zero.
+// A zero fallback is encoded as an unspecified date.
+func volumeTimestamp(t, fallback time.Time) VolumeDescriptorTimestamp {
+	if t.IsZero() {
+		t = fallback
+	}
+	if t.IsZero() {
+		return VolumeDescriptorTimestamp{}
+	}
+	return VolumeDescriptorTimestampFromTime(t)
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 
@@ -172,3 +173,159 @@ func TestWriterAndMountWithAddLocal(t *testing.T) {
 	output, err = umountCmd.CombinedOutput()
 	assert.NoError(t, err, "failed to unmount the ISO image: %v\n%s", err, string(output))
 }
+
+// TestWriterAndMountZisofs checks that the kernel transparently decompresses zisofs files
+func TestWriterAndMountZisofs(t *testing.T) {
+	contents := strings.Repeat("compressible contents ", 10000)
+
+	w, err := NewWriter()
+	assert.NoError(t, err)
+	defer func() {
+		if cleanupErr := w.Cleanup(); cleanupErr != nil {
+			t.Fatalf("failed to cleanup writer: %v", cleanupErr)
+		}
+	}()
+
+	w.SetRockRidge(true)
+	assert.NoError(t, w.SetZisofs(&ZisofsOptions{BlockSize: 64 * 1024}))
+
+	for i := 0; i < 10; i++ {
+		err = w.AddFile(strings.NewReader(contents), fmt.Sprintf("dir/file%d.txt", i))
+		assert.NoError(t, err)
+	}
+
+	f, err := os.CreateTemp(os.TempDir(), "iso9660_golang_test")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	err = w.WriteTo(f, "testvolume")
+	assert.NoError(t, err)
+
+	mountDir, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer func() {
+		if removeErr := os.RemoveAll(mountDir); removeErr != nil {
+			t.Fatalf("failed to delete mount directory: %v", removeErr)
+		}
+	}()
+
+	mountCmd := exec.Command("mount", "-t", "iso9660", f.Name(), mountDir)
+	output, err := mountCmd.CombinedOutput()
+	assert.NoError(t, err, "failed to mount the ISO image: %v\n%s", err, string(output))
+
+	for i := 0; i < 10; i++ {
+		data, err := os.ReadFile(filepath.Join(mountDir, "dir", fmt.Sprintf("file%d.txt", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, contents, string(data))
+	}
+
+	umountCmd := exec.Command("umount", mountDir)
+	output, err = umountCmd.CombinedOutput()
+	assert.NoError(t, err, "failed to unmount the ISO image: %v\n%s", err, string(output))
+}
+
+// TestWriterAndMountDeepDirectories checks that the kernel follows relocated deep directories
+func TestWriterAndMountDeepDirectories(t *testing.T) {
+	w, err := NewWriter()
+	assert.NoError(t, err)
+	defer func() {
+		if cleanupErr := w.Cleanup(); cleanupErr != nil {
+			t.Fatalf("failed to cleanup writer: %v", cleanupErr)
+		}
+	}()
+
+	w.SetRockRidge(true)
+	deepPath := "a/b/c/d/e/f/g/h/i/j/k/l/deep.txt"
+	assert.NoError(t, w.AddFile(strings.NewReader("deep"), deepPath))
+
+	f, err := os.CreateTemp(os.TempDir(), "iso9660_golang_test")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	err = w.WriteTo(f, "testvolume")
+	assert.NoError(t, err)
+
+	mountDir, err := os.MkdirTemp("", "")
+	assert.NoError(t, err)
+	defer func() {
+		if removeErr := os.RemoveAll(mountDir); removeErr != nil {
+			t.Fatalf("failed to delete mount directory: %v", removeErr)
+		}
+	}()
+
+	mountCmd := exec.Command("mount", "-t", "iso9660", f.Name(), mountDir)
+	output, err := mountCmd.CombinedOutput()
+	assert.NoError(t, err, "failed to mount the ISO image: %v\n%s", err, string(output))
+
+	data, err := os.ReadFile(filepath.Join(mountDir, deepPath))
+	assert.NoError(t, err)
+	assert.Equal(t, "deep", string(data))
+
+	umountCmd := exec.Command("umount", mountDir)
+	output, err = umountCmd.CombinedOutput()
+	assert.NoError(t, err, "failed to unmount the ISO image: %v\n%s", err, string(output))
+}
+
+// enhancedNames lists the paths of the ISO 9660:1999 hierarchy of an image
+func enhancedNames(t *testing.T, image string) []string {
+	f, err := os.Open(image)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	img, err := OpenImageWithOptions(f, ReaderOptions{PreferEnhanced: true})
+	assert.NoError(t, err)
+	vds := img.VolumeDescriptors()
+	assert.True(t, len(vds) > 1 && vds[1].Enhanced && vds[1].Selected, "the image has no Enhanced Volume Descriptor")
+
+	root, err := img.RootDir()
+	assert.NoError(t, err)
+	var names []string
+	var walk func(dir *File, prefix string)
+	walk = func(dir *File, prefix string) {
+		children, err := dir.GetChildren()
+		assert.NoError(t, err)
+		for _, c := range children {
+			names = append(names, prefix+c.Name())
+			if c.IsDir() {
+				walk(c, prefix+c.Name()+"/")
+			}
+		}
+	}
+	walk(root, "/")
+	sort.Strings(names)
+	return names
+}
+
+// TestWriterEnhancedVolumeLikeXorriso compares the ISO 9660:1999 hierarchy with the one of xorriso -iso-level 4
+func TestWriterEnhancedVolumeLikeXorriso(t *testing.T) {
+	if _, err := exec.LookPath("xorriso"); err != nil {
+		t.Skip("xorriso is not installed")
+	}
+
+	source := t.TempDir()
+	for _, name := range []string{
+		"Some Document (final).txt",
+		strings.Repeat("long-name-", 15) + ".iso",
+		"a/b/c/d/e/f/g/h/i/nested deeper than 8 levels.txt",
+	} {
+		p := filepath.Join(source, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NoError(t, os.WriteFile(p, []byte(name), 0644))
+	}
+
+	w, err := NewWriterWithOptions(WriterOptions{EnhancedVolume: true})
+	assert.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	assert.NoError(t, w.AddLocalDirectory(source, ""))
+	ours := filepath.Join(t.TempDir(), "ours.iso")
+	f, err := os.Create(ours)
+	assert.NoError(t, err)
+	assert.NoError(t, w.WriteTo(f, "ENHANCED"))
+	assert.NoError(t, f.Close())
+
+	theirs := filepath.Join(t.TempDir(), "xorriso.iso")
+	output, err := exec.Command("xorriso", "-as", "mkisofs", "-iso-level", "4", "-o", theirs, source).CombinedOutput()
+	assert.NoError(t, err, "%s", output)
+
+	assert.Equal(t, enhancedNames(t, theirs), enhancedNames(t, ours))
+}
@@ -0,0 +1,40 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// BenchmarkWriterMillionFiles reports the peak RSS of the process, so it's only meaningful when run on its own:
+// go test -run '^$' -bench WriterMillionFiles -benchtime 1x
+func BenchmarkWriterMillionFiles(b *testing.B) {
+	if testing.Short() {
+		b.Skip("staging a million files takes a while")
+	}
+
+	const files = 1000000
+	content := bytes.Repeat([]byte{'x'}, 1024)
+
+	for i := 0; i < b.N; i++ {
+		w, err := NewWriter()
+		require.NoError(b, err)
+		for f := 0; f < files; f++ {
+			require.NoError(b, w.AddFile(bytes.NewReader(content), fmt.Sprintf("dir%d/sub%d/file%d", f%100, f%1000, f)))
+		}
+		require.NoError(b, w.WriteTo(io.Discard, "bench"))
+		require.NoError(b, w.Cleanup())
+	}
+
+	var usage syscall.Rusage
+	require.NoError(b, syscall.Getrusage(syscall.RUSAGE_SELF, &usage))
+	// Maxrss is in kilobytes on Linux
+	b.ReportMetric(float64(usage.Maxrss)/1024, "MiB-peak-rss")
+}
@@ -4,12 +4,25 @@
 package iso9660
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMangleDirectoryName(t *testing.T) {
@@ -19,15 +32,15 @@ func TestMangleDirectoryName(t *testing.T) {
 	}{
 		{
 			input:  "ThisStringIsFarTooLongToBeWritten",
-			output: "thisstringisfartoolongtobewritt",
+			output: "THISSTRINGISFARTOOLONGTOBEWRITT",
 		},
 		{
 			input:  "ThisStringHasUnicodeCharacterŁ",
-			output: "thisstringhasunicodecharacter__",
+			output: "THISSTRINGHASUNICODECHARACTER__",
 		},
 		{
 			input:  "ThisStringHasItByteBeforeThEndŁ",
-			output: "thisstringhasitbytebeforethend_",
+			output: "THISSTRINGHASITBYTEBEFORETHEND_",
 		},
 	} {
 		t.Run(testcase.input, func(t *testing.T) {
@@ -44,19 +57,19 @@ func TestMangleFileName(t *testing.T) {
 	}{
 		{
 			input:  "ThisStringIsFarTooLongToBeWritten",
-			output: "thisstringisfartoolongtobewr;1",
+			output: "THISSTRINGISFARTOOLONGTOBEW.;1",
 		},
 		{
 			input:  "ThisStringHasUnicodeCharacŁ",
-			output: "thisstringhasunicodecharac__;1",
+			output: "THISSTRINGHASUNICODECHARAC_.;1",
 		},
 		{
 			input:  "ThisStringHasAFileExtensionAndItIsVery.Long",
-			output: "thisstringhasafileexten.long;1",
+			output: "THISSTRINGHASAFILEEXTEN.LONG;1",
 		},
 		{
 			input:  "ThisStringHasAFileExtensionThats.FarTooLong",
-			output: "thisstringhasafilee.fartoolo;1",
+			output: "THISSTRINGHASAFILEE.FARTOOLO;1",
 		},
 	} {
 		t.Run(testcase.input, func(t *testing.T) {
@@ -73,16 +86,126 @@ func TestWriterStaging(t *testing.T) {
 
 	testFileContents := "hrh2309hr320h"
 	testFilePath := "FarTooLongFilePathThatWillBeTrimmed/dirø1/somefile.dat"
-	testFileMangledPath := "fartoolongfilepaththatwillbetri/dir__1/somefile.dat;1"
+	testFileMangledPath := "/FARTOOLONGFILEPATHTHATWILLBETRI/DIR__1/SOMEFILE.DAT;1"
 
 	r := strings.NewReader(testFileContents)
 	err = w.AddFile(r, testFilePath)
 	assert.NoError(t, err)
 
-	readData, err := os.ReadFile(path.Join(w.stagingDir, testFileMangledPath))
+	names, err := w.NameMap()
 	assert.NoError(t, err)
+	assert.Equal(t, testFileMangledPath, names["/"+testFilePath])
 
-	assert.Equal(t, testFileContents, string(readData))
+	// small files are kept in memory
+	staged := w.lookup(testFilePath)
+	if assert.NotNil(t, staged) {
+		source := staged.source.(*memorySource)
+		assert.Equal(t, testFileContents, string(source.data))
+	}
+
+	w, err = NewWriterWithOptions(WriterOptions{MemoryStagingThreshold: -1})
+	assert.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	err = w.AddFile(strings.NewReader(testFileContents), testFilePath)
+	assert.NoError(t, err)
+
+	staged = w.lookup(testFilePath)
+	if assert.NotNil(t, staged) {
+
+		// every file gets its own staging file
+		source := staged.source.(*localFileSource)
+		assert.Equal(t, w.stagingDir, path.Dir(source.path))
+
+		readData, err := os.ReadFile(source.path)
+		assert.NoError(t, err)
+
+		assert.Equal(t, testFileContents, string(readData))
+	}
+}
+
+func TestWriterConcurrentAdd(t *testing.T) {
+	const goroutines = 32
+	const filesPerGoroutine = 1000
+
+	// layoutOf stages the same files in a different order and returns the resulting placement
+	layoutOf := func(reverse bool) []string {
+		w, err := NewWriter()
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+
+		var wg sync.WaitGroup
+		errs := make(chan error, goroutines)
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < filesPerGoroutine; i++ {
+					n := i
+					if reverse {
+						n = filesPerGoroutine - 1 - i
+					}
+					p := fmt.Sprintf("dir%d/file%d.txt", n%10, g*filesPerGoroutine+n)
+					if err := w.AddFile(strings.NewReader(p), p); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		wc := writeContext{freeSectorPointer: 18}
+		require.NoError(t, wc.buildLayout(w.root))
+		assert.Len(t, wc.files, goroutines*filesPerGoroutine)
+
+		var placement []string
+		for _, n := range append(wc.directories, wc.files...) {
+			placement = append(placement, fmt.Sprintf("%s@%d+%d", n.entry.path(), n.location, n.length))
+		}
+		return placement
+	}
+
+	assert.Equal(t, layoutOf(false), layoutOf(true))
+}
+
+func TestWriterModificationDuringWrite(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	require.NoError(t, w.AddFile(strings.NewReader("data"), "file"))
+
+	// the destination blocks the writer until the staging area has been probed
+	done := make(chan struct{})
+	var addErr, removeErr error
+	dst := writerFunc(func(p []byte) (int, error) {
+		select {
+		case <-done:
+		default:
+			addErr = w.AddFile(strings.NewReader("more data"), "other")
+			removeErr = w.Remove("file")
+			close(done)
+		}
+		return len(p), nil
+	})
+
+	assert.NoError(t, w.WriteTo(dst, "test"))
+	assert.ErrorIs(t, addErr, ErrWriteInProgress)
+	assert.ErrorIs(t, removeErr, ErrWriteInProgress)
+
+	// adding works again once the image has been written
+	assert.NoError(t, w.AddFile(strings.NewReader("more data"), "other"))
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
 }
 
 func TestWriterAddLocalDirectory(t *testing.T) {
@@ -120,12 +243,12 @@ func TestWriterAddLocalDirectory(t *testing.T) {
 	children, err := root.GetChildren()
 	assert.NoError(t, err)
 	assert.Len(t, children, 1)
-	assert.Equal(t, "foo", children[0].Name())
+	assert.Equal(t, "FOO", children[0].Name())
 
 	children, err = children[0].GetChildren()
 	assert.NoError(t, err)
 	assert.Len(t, children, 4)
-	assert.Equal(t, "dir4", children[3].Name())
+	assert.Equal(t, "DIR4", children[3].Name())
 
 	children, err = children[3].GetChildren()
 	assert.NoError(t, err)
@@ -162,6 +285,466 @@ func TestWriterAddLocalDirectoryWithFile(t *testing.T) {
 	assert.False(t, os.IsNotExist(err))
 }
 
+func TestWriterConflicts(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	require.NoError(t, w.AddFile(strings.NewReader("first"), "dir/file"))
+
+	err = w.AddLocalFile("fixtures/test.iso_source/cicero.txt", "dir/file")
+	assert.ErrorIs(t, err, ErrAlreadyStaged)
+	assert.EqualError(t, err, `cannot stage "dir/file" from "fixtures/test.iso_source/cicero.txt": the path is already staged from reader`)
+
+	err = w.AddFile(strings.NewReader("nested"), "dir/file/nested")
+	assert.EqualError(t, err, `cannot stage "dir/file/nested" from reader: /dir/file is not a directory, it is a file staged from reader`)
+
+	err = w.AddFS(fstest.MapFS{"dir": {Mode: fs.ModeDir | 0755}, "dir/file": {Mode: fs.ModeDir | 0755}}, "")
+	assert.ErrorIs(t, err, ErrAlreadyStaged)
+	assert.ErrorContains(t, err, `cannot stage directory "dir/file" from "dir/file" in the file system: the path is already staged as a file from reader`)
+
+	err = w.AddFile(strings.NewReader("file"), "dir")
+	assert.ErrorIs(t, err, ErrAlreadyStaged)
+	assert.EqualError(t, err, `cannot stage file "dir" from reader: the path is already staged as a directory from reader`)
+
+	// the rejected data doesn't stay in the staging directory or in memory
+	staged, err := os.ReadDir(w.stagingDir)
+	require.NoError(t, err)
+	assert.Empty(t, staged)
+	assert.Equal(t, int64(len("first")), w.memoryStaged.Load())
+
+	w.SetAllowOverwrite(true)
+	require.NoError(t, w.AddFile(strings.NewReader("second"), "dir/file"))
+	assert.Equal(t, int64(len("second")), w.lookup("dir/file").size())
+	assert.Equal(t, int64(len("second")), w.memoryStaged.Load())
+	assert.Error(t, w.AddFile(strings.NewReader("file"), "dir"))
+}
+
+func TestWriterAddLocalDirectoryMerge(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	first := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(first, "sub"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(first, "sub", "a"), []byte("a"), 0644))
+	second := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(second, "sub"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(second, "sub", "b"), []byte("b"), 0644))
+
+	require.NoError(t, w.AddLocalDirectory(first, "merged"))
+	require.NoError(t, w.AddLocalDirectory(second, "merged"))
+	assert.NotNil(t, w.lookup("merged/sub/a"))
+	assert.NotNil(t, w.lookup("merged/sub/b"))
+
+	err = w.AddLocalDirectory(first, "merged")
+	assert.ErrorIs(t, err, ErrAlreadyStaged)
+	assert.ErrorContains(t, err, fmt.Sprintf("from %q: the path is already staged from %q", path.Join(first, "sub", "a"), path.Join(first, "sub", "a")))
+}
+
+func TestWriterAddDirectory(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetRockRidge(true)
+
+	modTime := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+	require.NoError(t, w.AddDirectory("proc", WithMode(0555), WithOwner(1, 2), WithModTime(modTime)))
+	require.NoError(t, w.AddDirectory("/var/lib/empty"))
+	// adding an existing directory again keeps its metadata
+	require.NoError(t, w.AddDirectory("proc"))
+	require.NoError(t, w.AddDirectory(""))
+
+	require.NoError(t, w.AddFile(strings.NewReader("data"), "file"))
+	err = w.AddDirectory("file")
+	assert.ErrorIs(t, err, ErrAlreadyStaged)
+	assert.EqualError(t, err, `cannot stage directory "file" from AddDirectory: the path is already staged as a file from reader`)
+	assert.Error(t, w.AddDirectory("file/sub"))
+
+	img := remaster(t, w)
+	snapshot := snapshotImage(t, img)
+	assert.Equal(t, fs.ModeDir|0555, snapshot["/proc"].Mode)
+	assert.Equal(t, fs.ModeDir|0755, snapshot["/var/lib/empty"].Mode)
+
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+	proc := children[1]
+	require.Equal(t, "proc", proc.Name())
+	assert.True(t, modTime.Equal(proc.ModTime()))
+	px, err := proc.de.SystemUseEntries.getPosixEntry()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), px.uid)
+	assert.Equal(t, uint32(2), px.gid)
+}
+
+func TestWriterAddLocalDirectoryEmptyDirectories(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	origin := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(origin, "empty", "nested"), 0755))
+
+	require.NoError(t, w.AddLocalDirectory(origin, "root"))
+	nested := w.lookup("root/empty/nested")
+	require.NotNil(t, nested)
+	assert.True(t, nested.isDir())
+	assert.Empty(t, nested.children)
+}
+
+func TestWriterAddLocalDirectoryMetadata(t *testing.T) {
+	mtime := time.Date(2018, 7, 8, 9, 10, 11, 0, time.UTC)
+	origin := t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(origin, "private"), 0700))
+	require.NoError(t, os.WriteFile(path.Join(origin, "private", "data.txt"), []byte("data"), 0600))
+	require.NoError(t, os.WriteFile(path.Join(origin, "script.sh"), []byte("#!/bin/sh\n"), 0750))
+	// the permissions are set explicitly, as the umask applies on creation
+	for p, mode := range map[string]fs.FileMode{"private/data.txt": 0600, "script.sh": 0750, "private": 0700} {
+		require.NoError(t, os.Chmod(path.Join(origin, p), mode))
+		require.NoError(t, os.Chtimes(path.Join(origin, p), mtime, mtime))
+	}
+
+	for _, testcase := range []struct {
+		normalize bool
+		modes     map[string]fs.FileMode
+	}{
+		{
+			normalize: false,
+			modes: map[string]fs.FileMode{
+				"/root/private":          fs.ModeDir | 0700,
+				"/root/private/data.txt": 0600,
+				"/root/script.sh":        0750,
+			},
+		},
+		{
+			normalize: true,
+			modes: map[string]fs.FileMode{
+				"/root/private":          fs.ModeDir | 0755,
+				"/root/private/data.txt": 0644,
+				"/root/script.sh":        0755,
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("normalize=%v", testcase.normalize), func(t *testing.T) {
+			w, err := NewWriter()
+			require.NoError(t, err)
+			defer w.Cleanup() // nolint: errcheck
+			w.SetRockRidge(true)
+			w.SetNormalizeLocalMetadata(testcase.normalize)
+
+			require.NoError(t, w.AddLocalDirectory(origin, "root"))
+			require.NoError(t, w.AddLocalFile(path.Join(origin, "script.sh"), "bin/script.sh"))
+			testcase.modes["/bin/script.sh"] = testcase.modes["/root/script.sh"]
+
+			files := filesByPath(t, remaster(t, w))
+			for p, mode := range testcase.modes {
+				f, ok := files[p]
+				require.True(t, ok, p)
+				assert.Equal(t, mode, f.Mode(), p)
+				assert.True(t, mtime.Equal(f.ModTime()), p)
+
+				px, err := f.de.SystemUseEntries.getPosixEntry()
+				require.NoError(t, err)
+				assert.Equal(t, [2]uint32{0, 0}, [2]uint32{px.uid, px.gid}, p)
+			}
+		})
+	}
+}
+
+func TestWriterOwnerMap(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{
+		EnableRockRidge: true,
+		OwnerMap: func(isoPath string, uid, gid uint32) (uint32, uint32) {
+			if isoPath == "/home/user/file" {
+				return uid + 1000, gid + 2000
+			}
+			return 0, 0
+		},
+	})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddStreamedFile(strings.NewReader("data"), 4, "home/user/file", WithOwner(1, 2)))
+	require.NoError(t, w.AddStreamedFile(strings.NewReader("data"), 4, "etc/file", WithOwner(3, 4)))
+
+	files := filesByPath(t, remaster(t, w))
+	for p, owner := range map[string][2]uint32{"/home/user/file": {1001, 2002}, "/etc/file": {0, 0}, "/home": {0, 0}} {
+		uid, gid, ok := files[p].Owner()
+		require.True(t, ok, p)
+		assert.Equal(t, owner, [2]uint32{uid, gid}, p)
+	}
+}
+
+func TestWriterVolumeTimes(t *testing.T) {
+	now := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 60000000, time.UTC)
+	expires := time.Date(2030, 6, 7, 8, 9, 10, 0, time.UTC)
+	w, err := NewWriterWithOptions(WriterOptions{
+		FixedTimestamp: now,
+		VolumeTimes:    VolumeTimes{Creation: created, Expiration: expires},
+	})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	assert.Equal(t, created, w.VolumeTimes().Creation)
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "TIMES"))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	times, err := img.VolumeTimes()
+	require.NoError(t, err)
+	assert.Equal(t, VolumeTimes{Creation: created, Modification: now, Expiration: expires, Effective: now}, times)
+
+	// without an expiration date, it is left unspecified
+	w.SetVolumeTimes(VolumeTimes{})
+	buf.Reset()
+	require.NoError(t, w.WriteTo(&buf, "TIMES"))
+	img, err = OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	times, err = img.VolumeTimes()
+	require.NoError(t, err)
+	assert.Equal(t, VolumeTimes{Creation: now, Modification: now, Effective: now}, times)
+}
+
+func TestWriterAddLocalDirectoryHook(t *testing.T) {
+	origin := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(origin, "docs"), 0755))
+	require.NoError(t, os.MkdirAll(path.Join(origin, ".git", "objects"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(origin, "docs", "README.md"), []byte("readme"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(origin, "app.conf"), []byte("debug=true"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(origin, "payload.bin"), []byte("payload"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(origin, ".git", "HEAD"), []byte("ref"), 0644))
+
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetRockRidge(true)
+
+	var visited []string
+	hook := func(localPath string, info fs.FileInfo, staged *StagedEntry) error {
+		rel, err := filepath.Rel(origin, localPath)
+		require.NoError(t, err)
+		visited = append(visited, rel)
+
+		switch rel {
+		case ".git":
+			staged.Skip = true
+		case "docs":
+			staged.ISOPath = "/documentation"
+			staged.Mode = 0700
+		case "docs/README.md":
+			assert.Equal(t, "/documentation/README.md", staged.ISOPath)
+			staged.ISOPath = path.Join(path.Dir(staged.ISOPath), "README.TXT")
+		case "app.conf":
+			staged.Content = strings.NewReader("debug=false")
+			staged.UID = 1000
+		case "payload.bin":
+			sum := sha256.Sum256([]byte("payload"))
+			return w.AddFile(strings.NewReader(hex.EncodeToString(sum[:])), "/root/payload.sha256")
+		}
+		return nil
+	}
+	require.NoError(t, w.AddLocalDirectory(origin, "root", WithEntryHook(hook)))
+	assert.ElementsMatch(t, []string{".", ".git", "app.conf", "docs", "docs/README.md", "payload.bin"}, visited)
+
+	files := filesByPath(t, remaster(t, w))
+	var paths []string
+	for p := range files {
+		paths = append(paths, p)
+	}
+	assert.ElementsMatch(t, []string{
+		"/", "/root", "/root/app.conf", "/root/payload.bin", "/root/payload.sha256",
+		"/documentation", "/documentation/README.TXT",
+	}, paths)
+
+	assert.Equal(t, fs.ModeDir|0700, files["/documentation"].Mode())
+	readme, err := io.ReadAll(files["/documentation/README.TXT"].Reader())
+	require.NoError(t, err)
+	assert.Equal(t, "readme", string(readme))
+
+	conf := files["/root/app.conf"]
+	data, err := io.ReadAll(conf.Reader())
+	require.NoError(t, err)
+	assert.Equal(t, "debug=false", string(data))
+	px, err := conf.de.SystemUseEntries.getPosixEntry()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1000), px.uid)
+}
+
+func TestWriterAddLocalDirectoryHookErrors(t *testing.T) {
+	origin := t.TempDir()
+	require.NoError(t, os.Mkdir(path.Join(origin, "dir"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(origin, "dir", "file"), []byte("data"), 0644))
+
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	errBroken := errors.New("broken")
+	err = w.AddLocalDirectory(origin, "root", WithEntryHook(func(localPath string, info fs.FileInfo, staged *StagedEntry) error {
+		if info.Name() == "file" {
+			return errBroken
+		}
+		return nil
+	}))
+	assert.True(t, errors.Is(err, errBroken))
+	assert.Contains(t, err.Error(), strconv.Quote(path.Join(origin, "dir", "file")))
+
+	err = w.AddLocalDirectory(origin, "other", WithEntryHook(func(localPath string, info fs.FileInfo, staged *StagedEntry) error {
+		staged.Content = strings.NewReader("data")
+		return nil
+	}))
+	assert.ErrorContains(t, err, "only the contents of regular files can be replaced")
+}
+
+func TestWriterSpecialFiles(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetRockRidge(true)
+
+	require.NoError(t, w.AddDeviceNode("dev/sda1", fs.ModeDevice|0660, 8, 1))
+	require.NoError(t, w.AddDeviceNode("dev/null", fs.ModeDevice|fs.ModeCharDevice|0666, 1, 3))
+	require.NoError(t, w.AddDeviceNode("dev/nvme", fs.ModeDevice|0660, 259, 65536))
+	require.NoError(t, w.AddFifo("run/initctl", 0600))
+
+	assert.Error(t, w.AddDeviceNode("dev/bad", 0644, 1, 1))
+	assert.Error(t, w.AddDeviceNode("dev/bad", fs.ModeDevice|fs.ModeDir|0644, 1, 1))
+	assert.Error(t, w.AddFifo("run/bad", fs.ModeDevice|0600))
+
+	img := remaster(t, w)
+	snapshot := snapshotImage(t, img)
+	assert.Equal(t, fs.ModeDevice|0660, snapshot["/dev/sda1"].Mode)
+	assert.Equal(t, fs.ModeDevice|fs.ModeCharDevice|0666, snapshot["/dev/null"].Mode)
+	assert.Equal(t, fs.ModeNamedPipe|0600, snapshot["/run/initctl"].Mode)
+	assert.Equal(t, int64(0), snapshot["/run/initctl"].Size)
+
+	devices := make(map[string][2]uint32)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	for _, dir := range children {
+		entries, err := dir.GetChildren()
+		require.NoError(t, err)
+		for _, e := range entries {
+			if major, minor, ok := e.DeviceNumber(); ok {
+				devices[e.Name()] = [2]uint32{major, minor}
+				assert.Equal(t, uint32(0), e.de.ExtentLength)
+			}
+		}
+	}
+	assert.Equal(t, map[string][2]uint32{
+		"sda1": {8, 1},
+		"null": {1, 3},
+		"nvme": {259, 65536},
+	}, devices)
+
+	// the device numbers are carried over when remastering
+	iw, err := NewWriterFromImage(img)
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+	entry := iw.lookup("dev/nvme")
+	require.NotNil(t, entry)
+	assert.Equal(t, [2]uint32{259, 65536}, [2]uint32{entry.info().devMajor, entry.info().devMinor})
+}
+
+func TestWriterLongRockRidgeEntries(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetRockRidge(true)
+
+	longName := strings.Repeat("n", 250)
+	target := strings.Repeat("t/", 500)[:999] + "x"
+	require.NoError(t, w.AddFile(strings.NewReader("data"), longName))
+	require.NoError(t, w.AddFS(linkMapFS{fstest.MapFS{"link": {Data: []byte(target), Mode: fs.ModeSymlink | 0777}}}, ""))
+	// enough long names to need more than one sector of continuation areas
+	for i := 0; i < 20; i++ {
+		require.NoError(t, w.AddFile(strings.NewReader("x"), fmt.Sprintf("dir/%03d-%s", i, strings.Repeat("m", 200))))
+	}
+
+	img := remaster(t, w)
+	snapshot := snapshotImage(t, img)
+	assert.Contains(t, snapshot, "/"+longName)
+	assert.Equal(t, target, snapshot["/link"].Target)
+	for i := 0; i < 20; i++ {
+		assert.Contains(t, snapshot, fmt.Sprintf("/dir/%03d-%s", i, strings.Repeat("m", 200)))
+	}
+
+	wc := w.newWriteContext(time.Now())
+	require.NoError(t, wc.buildLayout(w.rootEntry()))
+	dir := wc.root.children[0]
+	require.Equal(t, "DIR", dir.identifier)
+	continuation, err := wc.directoryRecords(dir, func([]byte) error { return nil })
+	require.NoError(t, err)
+	assert.Greater(t, continuation.sectors(), uint32(1))
+	assert.Equal(t, dir.location+sizeToSectors(dir.length), dir.continuationLocation)
+}
+
+func TestWriterRockRidgeExtensionRecord(t *testing.T) {
+	for _, identifier := range []string{"", RockRidgeIdentifier1991A, RockRidgeIdentifierP1282} {
+		w, err := NewWriter()
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+		w.SetRockRidge(true)
+		if identifier != "" {
+			require.NoError(t, w.SetRockRidgeIdentifier(identifier))
+		}
+		require.NoError(t, w.AddFile(strings.NewReader("data"), "file"))
+
+		img := remaster(t, w)
+		hasRockRidge, err := img.HasRockRidge()
+		require.NoError(t, err)
+		assert.True(t, hasRockRidge)
+
+		root, err := img.RootDir()
+		require.NoError(t, err)
+		dot, err := root.GetDotEntry()
+		require.NoError(t, err)
+		assert.Equal(t, SUEType_SharingProtocolIndicator, dot.de.SystemUseEntries[0].Type())
+		ers, err := dot.de.SystemUseEntries.GetExtensionRecords()
+		require.NoError(t, err)
+		require.Len(t, ers, 1)
+
+		expected := identifier
+		if expected == "" {
+			expected = RockRidgeIdentifier1991A
+		}
+		assert.Equal(t, expected, ers[0].Identifier)
+		assert.Equal(t, RockRidgeVersion, ers[0].Version)
+		assert.Contains(t, ers[0].Descriptor, "POSIX FILE SYSTEM SEMANTICS")
+		assert.NotEmpty(t, ers[0].Source)
+
+		// the identifier is carried over when remastering
+		iw, err := NewWriterFromImage(img)
+		require.NoError(t, err)
+		defer iw.Cleanup() // nolint: errcheck
+		ers, err = func() ([]*ExtensionRecord, error) {
+			root, err := remaster(t, iw).RootDir()
+			require.NoError(t, err)
+			dot, err := root.GetDotEntry()
+			require.NoError(t, err)
+			return dot.de.SystemUseEntries.GetExtensionRecords()
+		}()
+		require.NoError(t, err)
+		assert.Equal(t, expected, ers[0].Identifier)
+	}
+
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	assert.Error(t, w.SetRockRidgeIdentifier("RRIP_1.12"))
+
+	// without Rock Ridge there is no SUSP at all
+	require.NoError(t, w.AddFile(strings.NewReader("data"), "file"))
+	hasRockRidge, err := remaster(t, w).HasRockRidge()
+	require.NoError(t, err)
+	assert.False(t, hasRockRidge)
+}
+
 func TestWriter_DeniedStagingDir(t *testing.T) {
 	w := &ImageWriter{stagingDir: "/usr/access_denied"}
 
@@ -193,8 +776,26 @@ func TestWriter_AddSymlink(t *testing.T) {
 	err = os.Symlink("/etc/hosts", symlinkPath)
 	assert.NoError(t, err)
 
-	err = w.AddLocalFile(symlinkPath, "foo")
-	assert.ErrorContains(t, err, " is a symlink - these are not yet supported")
+	// the link itself is staged, not the file it points to
+	require.NoError(t, w.AddLocalFile(symlinkPath, "foo"))
+
+	// a target too long for the System Use field continues in a continuation area
+	longTarget := strings.Repeat("../long-directory-name/", 40) + "file"
+	require.NoError(t, os.Mkdir(path.Join(tmpdir, "dir"), 0755))
+	require.NoError(t, os.Symlink(longTarget, path.Join(tmpdir, "dir", "long")))
+	require.NoError(t, os.Symlink("../symlink", path.Join(tmpdir, "dir", "relative")))
+
+	w.SetRockRidge(true)
+	require.NoError(t, w.AddLocalDirectory(tmpdir, "local"))
+	require.NoError(t, w.AddSymlink("local/dir", "shortcut"))
+	assert.Error(t, w.AddSymlink("", "empty"))
+
+	snapshot := snapshotImage(t, remaster(t, w))
+	assert.Equal(t, "/etc/hosts", snapshot["/foo"].Target)
+	assert.Equal(t, "/etc/hosts", snapshot["/local/symlink"].Target)
+	assert.Equal(t, longTarget, snapshot["/local/dir/long"].Target)
+	assert.Equal(t, "../symlink", snapshot["/local/dir/relative"].Target)
+	assert.Equal(t, "local/dir", snapshot["/shortcut"].Target)
 }
 
 func TestWriter_CleanupInvalid(t *testing.T) {
@@ -212,9 +813,772 @@ func TestWriter_CleanupInvalid(t *testing.T) {
 	assert.Error(t, err)
 }
 
-func TestWriteContextScanDirectory(t *testing.T) {
+func TestWriteContextMissingSource(t *testing.T) {
 	wc := writeContext{}
-	_, err := wc.scanDirectory(nil, "", nil, nil, 0)
-	// assert.ErrorIs(t, err, )
-	assert.EqualError(t, err, "open : no such file or directory")
+	root := newStagedDirectory("", time.Now())
+	root.addChild(newStagedFile("missing", &localFileSource{path: ""}, time.Now()))
+
+	err := wc.buildLayout(root)
+	assert.NoError(t, err)
+
+	err = wc.writeAll(io.Discard)
+	assert.EqualError(t, err, "/missing: open : no such file or directory")
+}
+
+// linkMapFS is a MapFS whose symlinks can be read regardless of the Go version
+type linkMapFS struct {
+	fstest.MapFS
+}
+
+func (m linkMapFS) ReadLink(name string) (string, error) {
+	f, ok := m.MapFS[name]
+	if !ok || f.Mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return string(f.Data), nil
+}
+
+func TestWriterAddFS(t *testing.T) {
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	fsys := linkMapFS{fstest.MapFS{
+		"bin":           {Mode: fs.ModeDir | 0700, ModTime: mtime},
+		"bin/tool":      {Data: []byte("#!/bin/sh\n"), Mode: 0755, ModTime: mtime},
+		"etc/motd":      {Data: []byte("hello"), Mode: 0600, ModTime: mtime},
+		"etc/motd.link": {Data: []byte("motd"), Mode: fs.ModeSymlink | 0777, ModTime: mtime},
+		"etc/plain":     {Data: []byte("no permissions"), ModTime: mtime},
+		"var":           {Mode: fs.ModeDir, ModTime: mtime},
+	}}
+
+	w, err := NewWriterWithOptions(WriterOptions{DefaultFileMode: 0640})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetRockRidge(true)
+
+	require.NoError(t, w.AddFS(fsys, "/imported"))
+
+	img := remaster(t, w)
+	snapshot := snapshotImage(t, img)
+
+	assert.Equal(t, fs.ModeDir|0700, snapshot["/imported/bin"].Mode)
+	assert.Equal(t, os.FileMode(0755), snapshot["/imported/bin/tool"].Mode)
+	assert.Equal(t, os.FileMode(0600), snapshot["/imported/etc/motd"].Mode)
+	assert.Equal(t, int64(5), snapshot["/imported/etc/motd"].Size)
+	assert.Equal(t, "motd", snapshot["/imported/etc/motd.link"].Target)
+	// the entries without permissions get the default ones
+	assert.Equal(t, os.FileMode(0640), snapshot["/imported/etc/plain"].Mode)
+	assert.Equal(t, fs.ModeDir|0755, snapshot["/imported/var"].Mode)
+
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	imported, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, imported, 1)
+	children, err := imported[0].GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+	assert.Equal(t, "bin", children[0].Name())
+	assert.Equal(t, mtime, children[0].ModTime().UTC())
+}
+
+func TestWriterAddFSErrors(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	// hide the ReadLink method of MapFS
+	noLinks := struct{ fs.FS }{fstest.MapFS{
+		"link": {Data: []byte("target"), Mode: fs.ModeSymlink},
+	}}
+	assert.EqualError(t, w.AddFS(noLinks, ""), "adding link: the file system doesn't support reading symlinks")
+
+	assert.NoError(t, w.AddFile(strings.NewReader("data"), "file"))
+	conflicting := fstest.MapFS{
+		"file/nested": {Data: []byte("data")},
+	}
+	assert.ErrorContains(t, w.AddFS(conflicting, ""), "adding file: ")
+}
+
+func TestWriterHidden(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	require.NoError(t, w.AddFile(strings.NewReader("checksums"), "SHA256SUMS"))
+	require.NoError(t, w.AddFile(strings.NewReader("payload"), "boot/payload.bin"))
+	require.NoError(t, w.AddFile(strings.NewReader("visible"), "visible.txt"))
+
+	assert.NoError(t, w.SetHidden("SHA256SUMS", true))
+	assert.NoError(t, w.SetHidden("boot", true))
+	assert.NoError(t, w.SetHidden("visible.txt", true))
+	assert.NoError(t, w.SetHidden("visible.txt", false))
+	assert.ErrorIs(t, w.SetHidden("missing", true), os.ErrNotExist)
+	assert.Error(t, w.SetHidden("/", true))
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "hidden"))
+
+	names := func(opts ReaderOptions) map[string]bool {
+		img, err := OpenImageWithOptions(bytes.NewReader(buf.Bytes()), opts)
+		require.NoError(t, err)
+		root, err := img.RootDir()
+		require.NoError(t, err)
+		children, err := root.GetChildren()
+		require.NoError(t, err)
+
+		result := make(map[string]bool)
+		for _, c := range children {
+			result[c.Name()] = c.IsHidden()
+			if c.IsDir() {
+				// hiding a directory doesn't hide its contents
+				grandchildren, err := c.GetChildren()
+				require.NoError(t, err)
+				require.Len(t, grandchildren, 1)
+				assert.False(t, grandchildren[0].IsHidden())
+
+				dot, err := c.GetDotEntry()
+				require.NoError(t, err)
+				assert.False(t, dot.IsHidden())
+			}
+		}
+		return result
+	}
+
+	assert.Equal(t, map[string]bool{"SHA256SUMS": true, "BOOT": true, "VISIBLE.TXT": false}, names(ReaderOptions{}))
+	assert.Equal(t, map[string]bool{"VISIBLE.TXT": false}, names(ReaderOptions{SkipHidden: true}))
+
+	// re-mastering keeps hidden entries hidden
+	img, err := OpenImageWithOptions(bytes.NewReader(buf.Bytes()), ReaderOptions{SkipHidden: true})
+	require.NoError(t, err)
+	remastered, err := NewWriterFromImage(img)
+	require.NoError(t, err)
+	defer remastered.Cleanup() // nolint: errcheck
+	assert.True(t, remastered.lookup("BOOT").hidden)
+	assert.False(t, remastered.lookup("BOOT/PAYLOAD.BIN").hidden)
+}
+
+func TestWriterSystemArea(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	assert.Error(t, w.SetSystemArea(make([]byte, 32*1024+1)))
+
+	mbr := make([]byte, 512)
+	mbr[0] = 0xEB
+	mbr[510], mbr[511] = 0x55, 0xAA
+	require.NoError(t, w.SetSystemArea(mbr))
+	// the writer keeps its own copy
+	mbr[0] = 0
+
+	require.NoError(t, w.AddFile(strings.NewReader("data"), "file.txt"))
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "sysarea"))
+
+	image := buf.Bytes()
+	assert.Equal(t, byte(0xEB), image[0])
+	assert.Equal(t, []byte{0x55, 0xAA}, image[510:512])
+	assert.Equal(t, make([]byte, 32*1024-512), image[512:32*1024])
+
+	img, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+	label, err := img.Label()
+	require.NoError(t, err)
+	assert.Equal(t, "sysarea", label)
+
+	// resetting restores the zeroed system area
+	require.NoError(t, w.SetSystemArea(nil))
+	buf.Reset()
+	require.NoError(t, w.WriteTo(&buf, "sysarea"))
+	assert.Equal(t, make([]byte, 32*1024), buf.Bytes()[:32*1024])
+}
+
+func TestWriterDeepDirectories(t *testing.T) {
+	deepPath := "1/2/3/4/5/6/7/8/9/10/11/12"
+
+	writeDeep := func(t *testing.T, rockRidge bool, policy DeepDirectoryPolicy) (*Image, error) {
+		w, err := NewWriter()
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+
+		w.SetRockRidge(rockRidge)
+		w.SetDeepDirectoryPolicy(policy)
+		require.NoError(t, w.AddFile(strings.NewReader("deep"), deepPath+"/deep.txt"))
+		require.NoError(t, w.AddFile(strings.NewReader("other"), "1/2/3/4/5/6/7/8/9b/other.txt"))
+
+		var buf bytes.Buffer
+		if err := w.WriteTo(&buf, "deep"); err != nil {
+			return nil, err
+		}
+		img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		return img, nil
+	}
+
+	expected := map[string]snapshotEntry{}
+	prefix := ""
+	for _, segment := range strings.Split(deepPath, "/") {
+		prefix += "/" + segment
+		expected[prefix] = snapshotEntry{Mode: fs.ModeDir | 0755}
+	}
+	expected["/1/2/3/4/5/6/7/8/9b"] = snapshotEntry{Mode: fs.ModeDir | 0755}
+	expected["/"+deepPath+"/deep.txt"] = snapshotEntry{Mode: 0644, Size: 4, SHA256: "74611c1d6455b534323a21f8133a6f43dc3a8188e7b946f96dcc28dde932fcb2"}
+	expected["/1/2/3/4/5/6/7/8/9b/other.txt"] = snapshotEntry{Mode: 0644, Size: 5, SHA256: "d9298a10d1b0735837dc4bd85dac641b0f3cef27a47e5d53a54f2f3f5b2fcffa"}
+
+	t.Run("relocate", func(t *testing.T) {
+		img, err := writeDeep(t, true, DeepDirectoriesDefault)
+		require.NoError(t, err)
+		assert.Equal(t, expected, snapshotImage(t, img))
+
+		root, err := img.RootDir()
+		require.NoError(t, err)
+		all, err := root.GetAllChildren()
+		require.NoError(t, err)
+		var moved *File
+		for _, c := range all {
+			if c.de.Identifier == "RR_MOVED" {
+				moved = c
+			}
+		}
+		require.NotNil(t, moved, "RR_MOVED is missing")
+		assert.True(t, moved.IsHidden())
+
+		// only 8 is relocated, as its descendants are no longer too deep after that
+		relocated, err := moved.GetAllChildren()
+		require.NoError(t, err)
+		var identifiers []string
+		for _, c := range relocated[2:] {
+			assert.True(t, c.de.SystemUseEntries.isRelocated())
+			identifiers = append(identifiers, c.de.Identifier)
+		}
+		assert.Equal(t, []string{"8"}, identifiers)
+
+		// the primary hierarchy doesn't exceed 8 levels
+		var maxDepth func(dir *File) int
+		maxDepth = func(dir *File) int {
+			children, err := dir.GetAllChildren()
+			require.NoError(t, err)
+			depth := 1
+			for _, c := range children[2:] {
+				if _, isLink, _ := c.de.SystemUseEntries.getLocationEntry("CL"); isLink || !c.IsDir() {
+					continue
+				}
+				if d := maxDepth(c) + 1; d > depth {
+					depth = d
+				}
+			}
+			return depth
+		}
+		assert.LessOrEqual(t, maxDepth(root), 8)
+
+		// the ".." of a relocated directory leads to its original parent
+		dotdot, err := relocated[2].GetAllChildren()
+		require.NoError(t, err)
+		seven := root
+		for _, segment := range strings.Split("1/2/3/4/5/6/7", "/") {
+			children, err := seven.GetChildren()
+			require.NoError(t, err)
+			for _, c := range children {
+				if c.Name() == segment {
+					seven = c
+				}
+			}
+		}
+		assert.Equal(t, "7", seven.Name())
+		assert.Equal(t, seven.de.ExtentLocation, dotdot[1].de.ExtentLocation)
+
+		// re-mastering keeps the original shape
+		iw, err := NewWriterFromImage(img)
+		require.NoError(t, err)
+		defer iw.Cleanup() // nolint: errcheck
+		assert.Equal(t, expected, snapshotImage(t, remaster(t, iw)))
+	})
+
+	t.Run("keep", func(t *testing.T) {
+		img, err := writeDeep(t, true, DeepDirectoriesKeep)
+		require.NoError(t, err)
+		assert.Equal(t, expected, snapshotImage(t, img))
+
+		root, err := img.RootDir()
+		require.NoError(t, err)
+		all, err := root.GetAllChildren()
+		require.NoError(t, err)
+		assert.Len(t, all, 3)
+	})
+
+	t.Run("relocate without Rock Ridge", func(t *testing.T) {
+		_, err := writeDeep(t, false, DeepDirectoriesRelocate)
+		assert.Error(t, err)
+
+		img, err := writeDeep(t, false, DeepDirectoriesDefault)
+		require.NoError(t, err)
+		root, err := img.RootDir()
+		require.NoError(t, err)
+		all, err := root.GetAllChildren()
+		require.NoError(t, err)
+		assert.Len(t, all, 3)
+	})
+}
+
+func TestWriterVeryDeepDirectories(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetRockRidge(true)
+
+	// the relocated directories are nested too deeply within RR_MOVED again, and again
+	var segments []string
+	for i := 1; i <= 40; i++ {
+		segments = append(segments, "level"+strconv.Itoa(i))
+	}
+	deepPath := "/" + strings.Join(segments, "/")
+	middlePath := "/" + strings.Join(segments[:20], "/")
+	require.NoError(t, w.AddFile(strings.NewReader("deep"), deepPath+"/deep.txt"))
+	require.NoError(t, w.AddFile(strings.NewReader("middle"), middlePath+"/middle.txt"))
+
+	img := remaster(t, w)
+	snapshot := snapshotImage(t, img)
+	assert.Equal(t, int64(4), snapshot[deepPath+"/deep.txt"].Size)
+	assert.Equal(t, int64(6), snapshot[middlePath+"/middle.txt"].Size)
+	assert.Equal(t, fs.ModeDir|0755, snapshot[deepPath].Mode)
+
+	data, err := fs.ReadFile(img.FS(), strings.TrimPrefix(deepPath, "/")+"/deep.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "deep", string(data))
+
+	iw, err := NewWriterFromImage(img)
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+	assert.Equal(t, snapshot, snapshotImage(t, remaster(t, iw)))
+}
+
+func TestWriterRelocatedIdentifiersAreUnique(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetRockRidge(true)
+
+	require.NoError(t, w.AddFile(strings.NewReader("a"), "a/2/3/4/5/6/7/deep/a.txt"))
+	require.NoError(t, w.AddFile(strings.NewReader("b"), "b/2/3/4/5/6/7/deep/b.txt"))
+
+	snapshot := snapshotImage(t, remaster(t, w))
+	assert.Equal(t, int64(1), snapshot["/a/2/3/4/5/6/7/deep/a.txt"].Size)
+	assert.Equal(t, int64(1), snapshot["/b/2/3/4/5/6/7/deep/b.txt"].Size)
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, ""))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	all, err := root.GetAllChildren()
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+	require.Equal(t, "RR_MOVED", all[4].de.Identifier)
+	relocated, err := all[4].GetAllChildren()
+	require.NoError(t, err)
+	require.Len(t, relocated, 4)
+	assert.Equal(t, "DEEP", relocated[2].de.Identifier)
+	assert.Equal(t, "DEEP1", relocated[3].de.Identifier)
+}
+
+func TestWriterMemoryStaging(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{MemoryStagingThreshold: 10, MemoryStagingBudget: 25})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	contents := map[string]string{
+		"a": "0123456789",
+		"b": "abcdefghij",
+		// over the budget
+		"c": "ABCDEFGHIJ",
+		// over the threshold
+		"d": "01234567890",
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, w.AddFile(strings.NewReader(contents[name]), name))
+	}
+
+	inMemory := func(name string) bool {
+		_, ok := w.lookup(name).source.(*memorySource)
+		return ok
+	}
+	assert.True(t, inMemory("a"))
+	assert.True(t, inMemory("b"))
+	assert.False(t, inMemory("c"))
+	assert.False(t, inMemory("d"))
+	assert.Equal(t, int64(20), w.memoryStaged.Load())
+
+	// removing a file returns its memory to the budget
+	require.NoError(t, w.Remove("a"))
+	assert.Equal(t, int64(10), w.memoryStaged.Load())
+	require.NoError(t, w.AddFile(strings.NewReader(contents["a"]), "a"))
+	assert.True(t, inMemory("a"))
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "memory"))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 4)
+	for _, c := range children {
+		data, err := io.ReadAll(c.Reader())
+		require.NoError(t, err)
+		assert.Equal(t, contents[strings.ToLower(c.Name())], string(data))
+	}
+
+	require.NoError(t, w.Cleanup())
+	assert.Equal(t, int64(0), w.memoryStaged.Load())
+	assert.Nil(t, w.lookup("a"))
+}
+
+func BenchmarkWriterSmallFiles(b *testing.B) {
+	const files = 50000
+	content := bytes.Repeat([]byte{'x'}, 1024)
+
+	for name, opts := range map[string]WriterOptions{
+		"disk":   {MemoryStagingThreshold: -1},
+		"memory": {},
+	} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				w, err := NewWriterWithOptions(opts)
+				require.NoError(b, err)
+				for f := 0; f < files; f++ {
+					require.NoError(b, w.AddFile(bytes.NewReader(content), fmt.Sprintf("dir%d/file%d", f%100, f)))
+				}
+				require.NoError(b, w.WriteTo(io.Discard, "bench"))
+				require.NoError(b, w.Cleanup())
+			}
+		})
+	}
+}
+
+func TestDirectoryExtent(t *testing.T) {
+	var extent directoryExtent
+	assert.Equal(t, uint32(1), extent.sectorCount())
+
+	assert.Equal(t, uint32(0), extent.place(1000))
+	assert.Equal(t, uint32(0), extent.place(1048))
+	// the sector is exactly full
+	assert.Equal(t, uint32(0), extent.remainder())
+	assert.Equal(t, uint32(1), extent.sectorCount())
+
+	assert.Equal(t, uint32(0), extent.place(2000))
+	assert.Equal(t, uint32(48), extent.place(100))
+	assert.Equal(t, uint32(3), extent.sectorCount())
+	assert.Equal(t, uint32(sectorSize-100), extent.remainder())
+}
+
+func TestWriterFullDirectorySector(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	// "." and ".." take 68 bytes, leaving room for 45 records of 44 bytes with an 11 character identifier
+	for i := 0; i < 45; i++ {
+		name := fmt.Sprintf("F%06d.X", i)
+		require.NoError(t, w.AddFile(strings.NewReader(name), name))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "full"))
+
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	assert.Equal(t, int64(sectorSize), root.Size())
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 45)
+	for _, c := range children {
+		data, err := io.ReadAll(c.Reader())
+		require.NoError(t, err)
+		assert.Equal(t, c.Name(), string(data))
+	}
+}
+
+func TestWriterSystemUseEntries(t *testing.T) {
+	provenance := make([]byte, 3*1024)
+	for i := range provenance {
+		provenance[i] = byte(i * 13)
+	}
+
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader(loremIpsum), "dir/lorem.txt"))
+	require.NoError(t, w.AddFile(strings.NewReader("other"), "other.txt"))
+	require.NoError(t, w.AddSystemUseEntry("dir/lorem.txt", "XP", 2, provenance))
+	require.NoError(t, w.AddSystemUseEntry("dir", "XB", 1, []byte("build 42")))
+	require.NoError(t, w.AddSystemUseEntry("/", "XB", 1, []byte("volume")))
+
+	assert.Error(t, w.AddSystemUseEntry("other.txt", "X", 1, nil))
+	assert.Error(t, w.AddSystemUseEntry("other.txt", "xp", 1, nil))
+	assert.Error(t, w.AddSystemUseEntry("other.txt", "NM", 1, nil))
+	assert.ErrorIs(t, w.AddSystemUseEntry("missing.txt", "XP", 1, nil), os.ErrNotExist)
+	assert.Error(t, w.AddSystemUseEntry("other.txt", "XP", 1, make([]byte, maxAddedSystemUseLength)))
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "susp"))
+
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Empty(t, img.Warnings())
+	data, err := io.ReadAll(filesByPath(t, img)["/dir/lorem.txt"].Reader())
+	require.NoError(t, err)
+	assert.Equal(t, loremIpsum, string(data))
+
+	added := func(f *File, signature string) []byte {
+		var data []byte
+		for _, e := range f.SystemUseEntries() {
+			if e.Type() == signature {
+				data = append(data, e.Data()...)
+			}
+		}
+		return data
+	}
+	byPath := filesByPath(t, img)
+	lorem := byPath["/dir/lorem.txt"]
+	assert.Equal(t, provenance, added(lorem, "XP"))
+	assert.Equal(t, "lorem.txt", lorem.Name())
+	assert.Equal(t, byte(2), lorem.SystemUseEntries()[len(lorem.SystemUseEntries())-1].Version())
+	assert.Equal(t, "build 42", string(added(byPath["/dir"], "XB")))
+	assert.Empty(t, added(byPath["/other.txt"], "XP"))
+
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	dot, err := root.GetDotEntry()
+	require.NoError(t, err)
+	assert.Equal(t, "volume", string(added(dot, "XB")))
+	rockRidge, err := img.HasRockRidge()
+	require.NoError(t, err)
+	assert.True(t, rockRidge)
+
+	w.SetRockRidge(false)
+	assert.Error(t, w.WriteTo(io.Discard, "susp"))
+}
+
+func TestWriterRegisterExtension(t *testing.T) {
+	acme := ExtensionRecord{Version: 2, Identifier: "ACME_META_1", Descriptor: "BUILD METADATA", Source: "SEE THE ACME DOCUMENTATION"}
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Extensions: []ExtensionRecord{acme}})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	assert.Error(t, w.RegisterExtension(acme), "already registered")
+	assert.Error(t, w.RegisterExtension(ExtensionRecord{Version: 1, Identifier: RockRidgeIdentifierP1282}))
+	assert.Error(t, w.RegisterExtension(ExtensionRecord{Version: 256, Identifier: "ACME_2"}))
+	assert.Error(t, w.RegisterExtension(ExtensionRecord{Version: 1, Identifier: "ACME_2", Descriptor: strings.Repeat("x", 250)}))
+
+	provenance := bytes.Repeat([]byte("provenance "), 50)
+	require.NoError(t, w.AddFile(strings.NewReader("data"), "dir/file.txt"))
+	require.NoError(t, w.AddSystemUseEntry("dir/file.txt", "AP", 2, provenance))
+	require.NoError(t, w.AddSystemUseEntry("/", "XB", 1, []byte("build 42")))
+
+	for _, img := range []*Image{remaster(t, w), func() *Image {
+		// the extension and its entries are carried over
+		iw, err := NewWriterFromImage(remaster(t, w))
+		require.NoError(t, err)
+		defer iw.Cleanup() // nolint: errcheck
+		return remaster(t, iw)
+	}()} {
+		records, err := img.ExtensionRecords()
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, RockRidgeIdentifier1991A, records[0].Identifier)
+		assert.Equal(t, acme, *records[1])
+		extensions, err := img.Extensions()
+		require.NoError(t, err)
+		assert.Equal(t, []string{RockRidgeIdentifier1991A, acme.Identifier}, extensions)
+
+		f, err := img.LookupPath("/dir/file.txt")
+		require.NoError(t, err)
+		unknown := f.SystemUseEntries().Unknown()
+		require.Len(t, unknown, 3)
+		for _, e := range unknown {
+			assert.Equal(t, "AP", e.Type())
+			assert.Equal(t, byte(2), e.Version())
+		}
+		data, ok := f.SystemUseEntries().Lookup("AP")
+		assert.True(t, ok)
+		assert.Equal(t, provenance, data)
+		_, ok = f.SystemUseEntries().Lookup("XB")
+		assert.False(t, ok)
+
+		root, err := img.RootDir()
+		require.NoError(t, err)
+		dot, err := root.GetDotEntry()
+		require.NoError(t, err)
+		data, ok = dot.SystemUseEntries().Lookup("XB")
+		assert.True(t, ok)
+		assert.Equal(t, "build 42", string(data))
+	}
+
+	w.SetRockRidge(false)
+	assert.ErrorContains(t, w.WriteTo(io.Discard, ""), "registered SUSP extensions require Rock Ridge to be enabled")
+}
+
+func TestWriterAddStreamedFile(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetRockRidge(true)
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	// the pipe only provides the data once WriteTo reads it
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := pw.Write(large)
+		pw.CloseWithError(err)
+	}()
+	require.NoError(t, w.AddStreamedFile(pr, int64(len(large)), "data/large.bin", WithMode(0600)))
+	require.NoError(t, w.AddStreamedFile(strings.NewReader(""), 0, "empty"))
+	require.NoError(t, w.AddFile(strings.NewReader("staged"), "staged.txt"))
+	assert.Error(t, w.AddStreamedFile(strings.NewReader(""), -1, "negative"))
+
+	fsys := remaster(t, w).FS()
+	data, err := fs.ReadFile(fsys, "data/large.bin")
+	require.NoError(t, err)
+	assert.Equal(t, large, data)
+	info, err := fs.Stat(fsys, "data/large.bin")
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0600), info.Mode())
+	empty, err := fs.ReadFile(fsys, "empty")
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	assert.ErrorContains(t, w.WriteTo(io.Discard, ""), "the data of a streamed file has already been read")
+}
+
+func TestWriterAddStreamedFileErrors(t *testing.T) {
+	for name, c := range map[string]struct {
+		data  string
+		size  int64
+		opts  WriterOptions
+		error string
+	}{
+		"short":     {data: "abc", size: 4, error: "the stream ended 1 bytes before its declared size"},
+		"long":      {data: "abcde", size: 4, error: "the stream is longer than its declared size"},
+		"md5":       {data: "abcd", size: 4, opts: WriterOptions{ImplantMD5: true}, error: "rules out an implanted MD5 checksum"},
+		"dedup":     {data: "abcd", size: 4, opts: WriterOptions{Deduplicate: true}, error: "rules out deduplication"},
+		"readahead": {data: "abc", size: 4, opts: WriterOptions{Readahead: true}, error: "unexpected EOF"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w, err := NewWriterWithOptions(c.opts)
+			require.NoError(t, err)
+			defer w.Cleanup() // nolint: errcheck
+
+			require.NoError(t, w.AddStreamedFile(strings.NewReader(c.data), c.size, "file"))
+			err = w.WriteTo(io.Discard, "")
+			assert.ErrorContains(t, err, c.error)
+		})
+	}
+}
+
+func TestWriterSetDeduplicate(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetDeduplicate(true)
+	for name, data := range map[string]string{"de/LC_MESSAGES.MO": "same", "fr/LC_MESSAGES.MO": "same", "OTHER.MO": "diff", "UNIQUE.BIN": "unique"} {
+		require.NoError(t, w.AddFile(strings.NewReader(data), name))
+	}
+
+	img := remaster(t, w)
+	locations := map[string]int32{}
+	err = img.Walk(func(path string, f *File, _ RockRidgeAttrs) error {
+		if !f.IsDir() {
+			locations[path] = f.de.ExtentLocation
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, locations, 4)
+	assert.Equal(t, locations["/DE/LC_MESSAGES.MO"], locations["/FR/LC_MESSAGES.MO"])
+	// the file of the same size with other contents gets its own extent
+	assert.NotEqual(t, locations["/DE/LC_MESSAGES.MO"], locations["/OTHER.MO"])
+	assert.NotEqual(t, locations["/OTHER.MO"], locations["/UNIQUE.BIN"])
+}
+
+func TestWriterEstimateSize(t *testing.T) {
+	for name, opts := range map[string]WriterOptions{
+		"plain":     {},
+		"rockridge": {EnableRockRidge: true, Joliet: true, EnhancedVolume: true, TransTables: true},
+		"zisofs":    {EnableRockRidge: true, Zisofs: &ZisofsOptions{BlockSize: 32 * 1024}},
+		"layout":    {DefaultAlignment: 16, PadSectors: 150, Deduplicate: true, ExtendedAttributeRecords: true},
+		"hybrid":    {ImplantMD5: true, Hybrid: &HybridOptions{GPT: true}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w, err := NewWriterWithOptions(opts)
+			require.NoError(t, err)
+			defer w.Cleanup() // nolint: errcheck
+			for n := 0; n < 200; n++ {
+				name := fmt.Sprintf("dir%d/a-file-with-a-rather-long-name-%03d.txt", n%7, n)
+				require.NoError(t, w.AddFile(strings.NewReader(strings.Repeat("data", n*50)), name))
+			}
+			require.NoError(t, w.AddFile(strings.NewReader(strings.Repeat("data", 50)), "copy.txt"))
+
+			size, err := w.EstimateSize()
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			require.NoError(t, w.WriteTo(&buf, "estimate"))
+			assert.Equal(t, int64(buf.Len()), size)
+		})
+	}
+
+	// the same errors as WriteTo
+	w, err := NewWriterWithOptions(WriterOptions{ImplantMD5: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddStreamedFile(strings.NewReader("abcd"), 4, "file"))
+	_, err = w.EstimateSize()
+	assert.ErrorContains(t, err, "rules out an implanted MD5 checksum")
+}
+
+func TestWriterAddFileWithInfo(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Joliet: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	modTime := time.Date(2021, 5, 6, 7, 8, 9, 0, time.UTC)
+	require.NoError(t, w.AddFileWithInfo(strings.NewReader("#!/bin/sh"), "bin/run.sh", EntryInfo{
+		Mode: 0750 | fs.ModeSetuid, ModTime: modTime, UID: 1000, GID: 100, Identifier: "RUN.SH;1",
+	}))
+	require.NoError(t, w.AddFileWithInfo(strings.NewReader("secret"), "data/.hidden", EntryInfo{Hidden: true}))
+	require.NoError(t, w.AddFileWithInfo(strings.NewReader("setup"), "setup program.exe", EntryInfo{Identifier: "SETUP.EXE;1"}))
+
+	for _, identifier := range []string{"lower.txt;1", "NOEXT;1", "SETUP.EXE;0", "A_VERY_LONG_NAME_OF_MORE_THAN_30.TXT"} {
+		assert.Error(t, w.AddFileWithInfo(strings.NewReader(""), "invalid", EntryInfo{Identifier: identifier}), identifier)
+	}
+	names, err := w.NameMap()
+	require.NoError(t, err)
+	assert.NotContains(t, names, "/invalid")
+
+	img := remaster(t, w)
+	snapshot := snapshotImage(t, img)
+	assert.Equal(t, 0750|fs.ModeSetuid, snapshot["/bin/run.sh"].Mode)
+	assert.Equal(t, fs.FileMode(0644), snapshot["/setup program.exe"].Mode)
+
+	files := filesByPath(t, img)
+	run := files["/bin/run.sh"]
+	require.NotNil(t, run)
+	assert.Equal(t, "RUN.SH;1", run.Identifier())
+	assert.True(t, modTime.Equal(run.ModTime()))
+	uid, gid, ok := run.Owner()
+	assert.True(t, ok)
+	assert.Equal(t, [2]uint32{1000, 100}, [2]uint32{uid, gid})
+	assert.Equal(t, "SETUP.EXE;1", files["/setup program.exe"].Identifier())
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, ""))
+	img, err = OpenImageWithOptions(bytes.NewReader(buf.Bytes()), ReaderOptions{PreferJoliet: true})
+	require.NoError(t, err)
+	files = filesByPath(t, img)
+	assert.Contains(t, files, "/setup program.exe")
+	require.Contains(t, files, "/data/.hidden")
+	assert.True(t, files["/data/.hidden"].IsHidden())
 }
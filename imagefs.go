@@ -0,0 +1,359 @@
+package iso9660
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// maxSymlinkHops limits the number of symbolic links followed when resolving a path, as in Linux
+const maxSymlinkHops = 40
+
+// imageFS is the fs.FS returned by Image.FS
+type imageFS struct {
+	image *Image
+}
+
+var (
+	_ fs.FS         = &imageFS{}
+	_ fs.ReadDirFS  = &imageFS{}
+	_ fs.ReadFileFS = &imageFS{}
+	_ fs.StatFS     = &imageFS{}
+)
+
+// FS returns a read-only fs.FS over the selected hierarchy of the image, which also implements
+// fs.ReadDirFS, fs.ReadFileFS and fs.StatFS. The entries are named, typed and given modes as by File,
+// so Rock Ridge names, modes and symbolic links are reflected in the results.
+//
+// Symbolic links are followed within the image by Open, Stat and ReadFile, absolute targets from its root.
+// Lstat and ReadLink, as in fs.ReadLinkFS, don't follow the last element of the path.
+// The files opened implement io.Seeker and io.ReaderAt, see File.OpenReaderAt.
+func (i *Image) FS() fs.FS {
+	return &imageFS{image: i}
+}
+
+// Stat returns the entry at a slash-separated path made of the names returned by File.Name, such as
+// "/isolinux/isolinux.bin", following symbolic links within the image like FS does. Rock Ridge names
+// are case-sensitive, the names of Joliet and plain ISO 9660 volumes are compared ignoring case.
+// It returns an error wrapping fs.ErrNotExist if the path isn't there.
+//
+// Unlike LookupPath, which resolves recorded identifiers through the path table, every directory
+// along the path is read.
+func (i *Image) Stat(name string) (*File, error) {
+	return i.stat("stat", name)
+}
+
+func (i *Image) stat(op, name string) (*File, error) {
+	root, err := i.RootDir()
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	f, err := resolvePath(root, name, true, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return f, nil
+}
+
+// OpenFile opens the data of the file at a path resolved like Stat does, see File.OpenReaderAt
+func (i *Image) OpenFile(name string) (FileReader, error) {
+	f, err := i.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if f.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	r, err := f.OpenReaderAt()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return r, nil
+}
+
+// Open opens the named file or directory
+func (ifs *imageFS) Open(name string) (fs.File, error) {
+	f, err := ifs.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info := entryInfo(f, name)
+	if f.IsDir() {
+		return &imageDir{file: f, info: info}, nil
+	}
+
+	r, err := f.OpenReaderAt()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &imageFile{reader: r, info: info}, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name
+func (ifs *imageFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := ifs.resolve("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readDirEntries(f)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+// ReadFile reads the contents of the named file
+func (ifs *imageFS) ReadFile(name string) ([]byte, error) {
+	f, err := ifs.resolve("read", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if f.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+
+	r, err := f.OpenReader()
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// Stat returns the information about the named file, following symbolic links
+func (ifs *imageFS) Stat(name string) (fs.FileInfo, error) {
+	f, err := ifs.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return entryInfo(f, name), nil
+}
+
+// Lstat returns the information about the named file without following a symbolic link
+func (ifs *imageFS) Lstat(name string) (fs.FileInfo, error) {
+	f, err := ifs.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return entryInfo(f, name), nil
+}
+
+// ReadLink returns the target of the named symbolic link
+func (ifs *imageFS) ReadLink(name string) (string, error) {
+	f, err := ifs.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if f.Mode()&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.New("not a symbolic link")}
+	}
+	return f.SymlinkTarget(), nil
+}
+
+// resolve looks up the entry of a valid fs.FS path, following the symbolic links on the way
+// and, if follow is set, the one named by the path
+func (ifs *imageFS) resolve(op, name string, follow bool) (*File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	root, err := ifs.image.RootDir()
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	f, err := resolvePath(root, name, follow, false)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return f, nil
+}
+
+// resolvePath looks up the slash-separated path relative to the root directory.
+// With foldCase, the names of the directories without Rock Ridge are compared ignoring case.
+func resolvePath(root *File, name string, follow, foldCase bool) (*File, error) {
+	var stack []*File // the directories from the root to the current one
+	current := root
+	pending := splitPath(name)
+	hops := 0
+
+	for len(pending) > 0 {
+		element := pending[0]
+		pending = pending[1:]
+
+		switch element {
+		case ".":
+			continue
+		case "..":
+			// like in Linux, ".." of the root is the root
+			if len(stack) > 0 {
+				current, stack = stack[len(stack)-1], stack[:len(stack)-1]
+			}
+			continue
+		}
+
+		if !current.IsDir() {
+			return nil, fs.ErrNotExist
+		}
+		child, err := lookupChild(current, element, foldCase)
+		if err != nil {
+			return nil, err
+		}
+
+		if child.Mode()&fs.ModeSymlink != 0 && (follow || len(pending) > 0) {
+			if hops++; hops > maxSymlinkHops {
+				return nil, errors.New("too many levels of symbolic links")
+			}
+			target := child.SymlinkTarget()
+			if strings.HasPrefix(target, "/") {
+				current, stack = root, nil
+			}
+			pending = append(splitPath(target), pending...)
+			continue
+		}
+
+		stack = append(stack, current)
+		current = child
+	}
+	return current, nil
+}
+
+// lookupChild returns the child of a directory with the given name, an exact match coming first with foldCase
+func lookupChild(dir *File, name string, foldCase bool) (*File, error) {
+	children, err := dir.GetChildren()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range children {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	// listing the root tells whether the volume uses Rock Ridge
+	if foldCase && !dir.hasRockRidge() {
+		for _, c := range children {
+			if strings.EqualFold(c.Name(), name) {
+				return c, nil
+			}
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+// readDirEntries returns the entries of a directory sorted by name
+func readDirEntries(dir *File) ([]fs.DirEntry, error) {
+	children, err := dir.GetChildren()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, len(children))
+	for i, c := range children {
+		entries[i] = fs.FileInfoToDirEntry(c)
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].Name() < entries[b].Name()
+	})
+	return entries, nil
+}
+
+// namedInfo renames the information of an entry
+type namedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (n *namedInfo) Name() string {
+	return n.name
+}
+
+// entryInfo returns the information of an entry found at the given path,
+// named after the path's last element like with os.DirFS, which differs for the root and symbolic links
+func entryInfo(f *File, name string) fs.FileInfo {
+	base := path.Base(name)
+	if f.Name() == base {
+		return f
+	}
+	return &namedInfo{FileInfo: f, name: base}
+}
+
+// imageFile is a file opened by imageFS
+type imageFile struct {
+	reader FileReader
+	info   fs.FileInfo
+}
+
+func (f *imageFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *imageFile) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+// Seek sets the offset for the next Read
+func (f *imageFile) Seek(offset int64, whence int) (int64, error) {
+	return f.reader.Seek(offset, whence)
+}
+
+// ReadAt reads the data at the given offset
+func (f *imageFile) ReadAt(p []byte, offset int64) (int, error) {
+	return f.reader.ReadAt(p, offset)
+}
+
+func (f *imageFile) Close() error {
+	return nil
+}
+
+// imageDir is a directory opened by imageFS
+type imageDir struct {
+	file *File
+	info fs.FileInfo
+	// entries are read by the first call to ReadDir
+	entries []fs.DirEntry
+	read    bool
+	offset  int
+}
+
+var _ fs.ReadDirFile = &imageDir{}
+
+func (d *imageDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *imageDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries, or all of the remaining ones if n <= 0, as described by fs.ReadDirFile
+func (d *imageDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := readDirEntries(d.file)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.info.Name(), Err: err}
+		}
+		d.entries, d.read = entries, true
+	}
+
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
+
+func (d *imageDir) Close() error {
+	return nil
+}
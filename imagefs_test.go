@@ -0,0 +1,158 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageFS(t *testing.T) {
+	f, err := os.Open("fixtures/test.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	image, err := OpenImage(f)
+	require.NoError(t, err)
+	fsys := image.FS()
+	assert.NoError(t, fstest.TestFS(fsys, "CICERO.TXT", "DIR1/LOREM_IP.TXT", "DIR2/DIR3/DATA.BIN"))
+
+	data, err := fs.ReadFile(fsys, "DIR1/LOREM_IP.TXT")
+	require.NoError(t, err)
+	assert.Equal(t, loremIpsum, string(data))
+
+	_, err = fs.Stat(fsys, "missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = fsys.Open("/CICERO.TXT")
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+	_, err = fsys.Open("CICERO.TXT/..")
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+}
+
+func TestImageFS_RockRidge(t *testing.T) {
+	f, err := os.Open("fixtures/test_rockridge.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	image, err := OpenImage(f)
+	require.NoError(t, err)
+	fsys := image.FS()
+
+	// the symlink in the root points outside of the image, so only the directories can be tested as a whole
+	for dir, expected := range map[string]string{"dir1": "lorem_ipsum.txt", "dir2": "dir3/data.bin"} {
+		sub, err := fs.Sub(fsys, dir)
+		require.NoError(t, err)
+		assert.NoError(t, fstest.TestFS(sub, expected), dir)
+	}
+
+	info, err := fs.Stat(fsys, "dir1/lorem_ipsum.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "lorem_ipsum.txt", info.Name())
+	assert.Equal(t, fs.FileMode(0640), info.Mode())
+
+	entries, err := fs.ReadDir(fsys, ".")
+	require.NoError(t, err)
+	var link fs.DirEntry
+	for _, e := range entries {
+		if e.Name() == "this-is-a-symlink" {
+			link = e
+		}
+	}
+	require.NotNil(t, link)
+	assert.Equal(t, fs.ModeSymlink, link.Type())
+
+	lstat, err := fsys.(interface {
+		Lstat(name string) (fs.FileInfo, error)
+	}).Lstat("this-is-a-symlink")
+	require.NoError(t, err)
+	assert.Equal(t, fs.ModeSymlink, lstat.Mode().Type())
+	target, err := fsys.(interface {
+		ReadLink(name string) (string, error)
+	}).ReadLink("this-is-a-symlink")
+	require.NoError(t, err)
+	assert.Equal(t, "/usr/share/some-random-directory/even-deeper-path/symlink-target", target)
+	_, err = fs.Stat(fsys, "this-is-a-symlink")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestImageFS_FollowsSymlinks(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetRockRidge(true)
+
+	require.NoError(t, w.AddFile(strings.NewReader("hello"), "a/b/file.txt"))
+	require.NoError(t, w.AddSymlink("b/file.txt", "a/relative"))
+	require.NoError(t, w.AddSymlink("/a/b", "absolute"))
+	require.NoError(t, w.AddSymlink("../../a/relative", "a/b/up"))
+	fsys := remaster(t, w).FS()
+	assert.NoError(t, fstest.TestFS(fsys, "a/b/file.txt", "a/relative", "absolute", "a/b/up"))
+
+	for _, name := range []string{"a/relative", "absolute/file.txt", "a/b/up"} {
+		data, err := fs.ReadFile(fsys, name)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, "hello", string(data), name)
+		}
+	}
+
+	info, err := fs.Stat(fsys, "absolute")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.Equal(t, "absolute", info.Name())
+
+	file, err := fsys.Open("a/relative")
+	require.NoError(t, err)
+	defer file.Close() // nolint: errcheck
+	_, err = file.(io.Seeker).Seek(1, io.SeekStart)
+	require.NoError(t, err)
+	rest, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "ello", string(rest))
+}
+
+func TestImageStatAndOpenFile(t *testing.T) {
+	f, err := os.Open("fixtures/test.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+	image, err := OpenImage(f)
+	require.NoError(t, err)
+
+	// ISO 9660 names are compared ignoring case
+	info, err := image.Stat("/dir1/lorem_ip.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "LOREM_IP.TXT", info.Name())
+	r, err := image.OpenFile("DIR1/Lorem_Ip.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, loremIpsum, string(data))
+
+	dir, err := image.Stat("/dir2/dir3/")
+	require.NoError(t, err)
+	assert.True(t, dir.IsDir())
+	_, err = image.OpenFile("/DIR2")
+	assert.ErrorContains(t, err, "is a directory")
+	_, err = image.OpenFile("/DIR1/missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	rr, err := os.Open("fixtures/test_rockridge.iso")
+	require.NoError(t, err)
+	defer rr.Close() // nolint: errcheck
+	image, err = OpenImage(rr)
+	require.NoError(t, err)
+
+	// Rock Ridge names are case-sensitive
+	info, err = image.Stat("/dir1/lorem_ipsum.txt")
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0640), info.Mode())
+	_, err = image.Stat("/DIR1/lorem_ipsum.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
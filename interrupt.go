@@ -0,0 +1,63 @@
+package iso9660
+
+import (
+	"fmt"
+	"io"
+)
+
+// Phases of WriteToContext reported by IncompleteImageError
+const (
+	PhaseCompressing      = "compressing"
+	PhasePathTables       = "writing the path tables"
+	PhaseDirectories      = "writing the directory"
+	PhaseFileData         = "writing the file data"
+	PhaseVolumeDescriptor = "writing the volume descriptors"
+)
+
+// IncompleteImageError is returned by WriteToContext when its context is done before the image is complete.
+// Whatever was written to the writer up to then isn't a valid image and should be discarded.
+type IncompleteImageError struct {
+	// Phase is what WriteToContext was doing, one of the Phase constants
+	Phase string
+	// Path is the staged file or directory in progress, if any
+	Path string
+	// Written is the number of bytes of the image written to the writer
+	Written int64
+	// Err is the error of the context
+	Err error
+}
+
+func (e *IncompleteImageError) Error() string {
+	in := ""
+	if e.Path != "" {
+		in = " " + e.Path
+	}
+	return fmt.Sprintf("the image is incomplete, writing was interrupted while %s%s after %d bytes: %s", e.Phase, in, e.Written, e.Err)
+}
+
+func (e *IncompleteImageError) Unwrap() error {
+	return e.Err
+}
+
+// interrupted returns an *IncompleteImageError if the context of the write is done
+func (wc *writeContext) interrupted(phase, path string) error {
+	if wc.ctx == nil {
+		return nil
+	}
+	if err := wc.ctx.Err(); err != nil {
+		return &IncompleteImageError{Phase: phase, Path: path, Err: err}
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
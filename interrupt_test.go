@@ -0,0 +1,114 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cancelingWriter cancels the context once more than after bytes have been written
+type cancelingWriter struct {
+	w      io.Writer
+	after  int
+	cancel context.CancelFunc
+}
+
+func (c *cancelingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if c.after -= n; c.after < 0 {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestWriteToContextCanceled(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Zisofs: &ZisofsOptions{}})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader(strings.Repeat(loremIpsum, 20)), "lorem.txt"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	err = w.WriteToContext(ctx, &buf, "canceled")
+	var interrupted *IncompleteImageError
+	require.True(t, errors.As(err, &interrupted), err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, PhaseCompressing, interrupted.Phase)
+	assert.Equal(t, "/lorem.txt", interrupted.Path)
+	assert.Zero(t, interrupted.Written)
+	assert.Zero(t, buf.Len())
+
+	// the writer can still be used
+	require.NoError(t, w.WriteToContext(context.Background(), &buf, "complete"))
+}
+
+func TestWriteToContextFileData(t *testing.T) {
+	origin := t.TempDir()
+	dataTree(t, origin)
+
+	for _, opts := range []WriterOptions{
+		{DataBufferSize: 8 * 1024},
+		{DataBufferSize: 8 * 1024, Readahead: true, DataBuffers: 2},
+	} {
+		opts.MemoryStagingThreshold = -1
+		opts.FixedTimestamp = time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+		opts.EnableRockRidge = true
+		w, err := NewWriterWithOptions(opts)
+		require.NoError(t, err)
+		require.NoError(t, w.AddLocalDirectory(origin, "tree"))
+
+		var complete bytes.Buffer
+		require.NoError(t, w.WriteTo(&complete, "tree"))
+		staged, err := os.ReadDir(w.stagingDir)
+		require.NoError(t, err)
+
+		// cancel within the data of the first files
+		img, err := OpenImage(bytes.NewReader(complete.Bytes()))
+		require.NoError(t, err)
+		first := filesByPath(t, img)["/tree/dir0/file03.bin"]
+		require.NotNil(t, first)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var buf bytes.Buffer
+		out := &cancelingWriter{w: &buf, after: int(first.de.ExtentLocation)*int(sectorSize) + 1000, cancel: cancel}
+		err = w.WriteToContext(ctx, out, "tree")
+		cancel()
+
+		var interrupted *IncompleteImageError
+		require.True(t, errors.As(err, &interrupted), err)
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equal(t, PhaseFileData, interrupted.Phase)
+		assert.Contains(t, err.Error(), "the image is incomplete")
+		assert.Equal(t, int64(buf.Len()), interrupted.Written)
+		assert.Less(t, buf.Len(), complete.Len())
+		assert.Equal(t, complete.Bytes()[:buf.Len()], buf.Bytes())
+		if opts.Readahead {
+			// the buffers which were read ahead are dropped
+			assert.LessOrEqual(t, buf.Len(), int(first.de.ExtentLocation)*int(sectorSize)+3*opts.DataBufferSize)
+		} else {
+			assert.True(t, strings.HasPrefix(interrupted.Path, "/tree/dir"), interrupted.Path)
+		}
+
+		after, err := os.ReadDir(w.stagingDir)
+		require.NoError(t, err)
+		assert.Equal(t, len(staged), len(after))
+
+		stagingDir := w.stagingDir
+		require.NoError(t, w.Cleanup())
+		_, err = os.Stat(stagingDir)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -49,8 +50,22 @@ const (
 
 var standardIdentifierBytes = [5]byte{'C', 'D', '0', '0', '1'}
 
+// ErrUDFNotSupported is returned by OpenImage for UDF images without an ISO 9660 volume, which OpenUDF reads
 var ErrUDFNotSupported = errors.New("UDF volumes are not supported")
 
+// The errors of malformed directory records and System Use entries, which the decoders wrap with the details.
+// Images are often untrusted, so the decoders check every length against the data holding the record.
+var (
+	// ErrTruncatedRecord is returned for records and entries whose fields extend beyond the data holding them.
+	// It wraps io.ErrUnexpectedEOF.
+	ErrTruncatedRecord = fmt.Errorf("truncated record: %w", io.ErrUnexpectedEOF)
+	// ErrInvalidRecordLength is returned for records and entries whose length is too small for their fixed fields
+	ErrInvalidRecordLength = errors.New("invalid record length")
+	// ErrBadSignature is returned for System Use entries decoded as another type than their signature tells,
+	// and for SP entries without the check bytes
+	ErrBadSignature = errors.New("bad signature")
+)
+
 // volumeDescriptorHeader represents the data in bytes 0-6
 // of a Volume Descriptor as defined in ECMA-119 8.1
 type volumeDescriptorHeader struct {
@@ -92,6 +107,53 @@ type BootVolumeDescriptorBody struct {
 
 var _ encoding.BinaryUnmarshaler = &BootVolumeDescriptorBody{}
 
+// PartitionVolumeDescriptorBody represents the data in bytes 7-2047
+// of a Volume Partition Descriptor as defined in ECMA-119 8.6.
+// It describes a range of sectors of the volume set aside for the system it identifies, outside of the file structure.
+type PartitionVolumeDescriptorBody struct {
+	SystemIdentifier          string
+	VolumePartitionIdentifier string
+	// Location is the first sector of the partition and Size its number of sectors
+	Location  uint32
+	Size      uint32
+	SystemUse [1960]byte
+}
+
+var _ encoding.BinaryUnmarshaler = &PartitionVolumeDescriptorBody{}
+
+// UnmarshalBinary decodes a PartitionVolumeDescriptorBody from binary form
+func (pvd *PartitionVolumeDescriptorBody) UnmarshalBinary(data []byte) error {
+	if uint32(len(data)) < sectorSize {
+		return io.ErrUnexpectedEOF
+	}
+
+	var err error
+	if pvd.Location, err = UnmarshalUint32LSBMSB(data[72:80]); err != nil {
+		return fmt.Errorf("volume partition location: %w", err)
+	}
+	if pvd.Size, err = UnmarshalUint32LSBMSB(data[80:88]); err != nil {
+		return fmt.Errorf("volume partition size: %w", err)
+	}
+	pvd.SystemIdentifier = strings.TrimRight(string(data[8:40]), " ")
+	pvd.VolumePartitionIdentifier = strings.TrimRight(string(data[40:72]), " ")
+	copy(pvd.SystemUse[:], data[88:2048])
+	return nil
+}
+
+// MarshalBinary encodes the body of a Volume Partition Descriptor, with the identifiers padded with spaces
+func (pvd *PartitionVolumeDescriptorBody) MarshalBinary() ([]byte, error) {
+	if len(pvd.SystemIdentifier) > 32 || len(pvd.VolumePartitionIdentifier) > 32 {
+		return nil, errors.New("PartitionVolumeDescriptorBody.MarshalBinary: the identifiers are limited to 32 bytes")
+	}
+	output := make([]byte, sectorSize)
+	copy(output[8:40], MarshalString(pvd.SystemIdentifier, 32))
+	copy(output[40:72], MarshalString(pvd.VolumePartitionIdentifier, 32))
+	WriteInt32LSBMSB(output[72:80], int32(pvd.Location))
+	WriteInt32LSBMSB(output[80:88], int32(pvd.Size))
+	copy(output[88:2048], pvd.SystemUse[:])
+	return output, nil
+}
+
 // PrimaryVolumeDescriptorBody represents the data in bytes 7-2047
 // of a Primary Volume Descriptor as defined in ECMA-119 8.4
 type PrimaryVolumeDescriptorBody struct {
@@ -146,10 +208,26 @@ var _ encoding.BinaryMarshaler = &DirectoryEntry{}
 
 // UnmarshalBinary decodes a DirectoryEntry from binary form
 func (de *DirectoryEntry) UnmarshalBinary(data []byte) error {
-	length := data[0]
-	if length == 0 {
+	if err := de.unmarshalShared(data); err != nil {
+		return err
+	}
+	de.SystemUse = append([]byte(nil), de.SystemUse...)
+	return nil
+}
+
+// unmarshalShared decodes a DirectoryEntry like UnmarshalBinary, except that the System Use field
+// is a slice of data instead of a copy, so data must not be modified as long as the entry is in use
+func (de *DirectoryEntry) unmarshalShared(data []byte) error {
+	if len(data) == 0 || data[0] == 0 {
 		return io.EOF
 	}
+	length := int(data[0])
+	switch {
+	case length < minDirectoryRecordLength:
+		return fmt.Errorf("%w: the directory record of %d bytes is shorter than %d bytes", ErrInvalidRecordLength, length, minDirectoryRecordLength)
+	case length > len(data):
+		return fmt.Errorf("%w: the directory record of %d bytes exceeds the %d bytes holding it", ErrTruncatedRecord, length, len(data))
+	}
 
 	var err error
 
@@ -175,14 +253,19 @@ func (de *DirectoryEntry) UnmarshalBinary(data []byte) error {
 		return err
 	}
 
-	identifierLen := data[32]
+	identifierLen := int(data[32])
+	if 33+identifierLen > length {
+		return fmt.Errorf("%w: the identifier of %d bytes exceeds the directory record of %d bytes", ErrTruncatedRecord, identifierLen, length)
+	}
 	de.Identifier = string(data[33 : 33+identifierLen])
 
-	// add padding if identifier length was even]
-	idPaddingLen := (identifierLen + 1) % 2
-	systemUseData := data[33+identifierLen+idPaddingLen : length]
-	de.SystemUse = make([]byte, len(systemUseData))
-	copy(de.SystemUse, systemUseData)
+	// add padding if identifier length was even, some writers leave it out at the end of the record
+	systemUse := 33 + identifierLen + (identifierLen+1)%2
+	if systemUse > length {
+		systemUse = length
+	}
+	// the capacity is limited, so that appending to the field cannot overwrite the next record
+	de.SystemUse = data[systemUse:length:length]
 
 	return nil
 }
@@ -191,8 +274,8 @@ func (de *DirectoryEntry) UnmarshalBinary(data []byte) error {
 func (de *DirectoryEntry) MarshalBinary() ([]byte, error) {
 	identifierLen := len(de.Identifier)
 	idPaddingLen := (identifierLen + 1) % 2
-	totalLen := 33 + identifierLen + idPaddingLen + len(de.SystemUse)
-	if totalLen > 255 {
+	totalLen := directoryRecordLength(de.Identifier, len(de.SystemUse))
+	if totalLen > maxDirectoryRecordLength {
 		return nil, fmt.Errorf("identifier %q is too long", de.Identifier)
 	}
 
@@ -216,6 +299,19 @@ func (de *DirectoryEntry) MarshalBinary() ([]byte, error) {
 	return data, nil
 }
 
+// minDirectoryRecordLength is the length of the fixed fields of a directory record, and maxDirectoryRecordLength
+// follows from its one-byte length field
+const (
+	minDirectoryRecordLength = 33
+	maxDirectoryRecordLength = 255
+)
+
+// directoryRecordLength returns the length of a directory record with the given identifier
+// and System Use field length, see ECMA-119 9.1
+func directoryRecordLength(identifier string, systemUseLength int) int {
+	return 33 + len(identifier) + (len(identifier)+1)%2 + systemUseLength
+}
+
 // Clone creates a copy of the DirectoryEntry
 func (de *DirectoryEntry) Clone() DirectoryEntry {
 	newDE := DirectoryEntry{
@@ -281,8 +377,8 @@ func (pvd *PrimaryVolumeDescriptorBody) UnmarshalBinary(data []byte) error {
 	pvd.PublisherIdentifier = strings.TrimRight(string(data[318:446]), " ")
 	pvd.DataPreparerIdentifier = strings.TrimRight(string(data[446:574]), " ")
 	pvd.ApplicationIdentifier = strings.TrimRight(string(data[574:702]), " ")
-	pvd.CopyrightFileIdentifier = strings.TrimRight(string(data[702:740]), " ")
-	pvd.AbstractFileIdentifier = strings.TrimRight(string(data[740:776]), " ")
+	pvd.CopyrightFileIdentifier = strings.TrimRight(string(data[702:739]), " ")
+	pvd.AbstractFileIdentifier = strings.TrimRight(string(data[739:776]), " ")
 	pvd.BibliographicFileIdentifier = strings.TrimRight(string(data[776:813]), " ")
 
 	if pvd.VolumeCreationDateAndTime.UnmarshalBinary(data[813:830]) != nil {
@@ -338,8 +434,8 @@ func (pvd PrimaryVolumeDescriptorBody) MarshalBinary() ([]byte, error) {
 	copy(output[318:446], MarshalString(pvd.PublisherIdentifier, 128))
 	copy(output[446:574], MarshalString(pvd.DataPreparerIdentifier, 128))
 	copy(output[574:702], MarshalString(pvd.ApplicationIdentifier, 128))
-	copy(output[702:740], MarshalString(pvd.CopyrightFileIdentifier, 38))
-	copy(output[740:776], MarshalString(pvd.AbstractFileIdentifier, 36))
+	copy(output[702:739], MarshalString(pvd.CopyrightFileIdentifier, 37))
+	copy(output[739:776], MarshalString(pvd.AbstractFileIdentifier, 37))
 	copy(output[776:813], MarshalString(pvd.BibliographicFileIdentifier, 37))
 
 	d, err = pvd.VolumeCreationDateAndTime.MarshalBinary()
@@ -378,18 +474,37 @@ func (pvd PrimaryVolumeDescriptorBody) MarshalBinary() ([]byte, error) {
 
 // UnmarshalBinary decodes a BootVolumeDescriptorBody from binary form
 func (bvd *BootVolumeDescriptorBody) UnmarshalBinary(data []byte) error {
-	bvd.BootSystemIdentifier = strings.TrimRight(string(data[7:39]), " ")
-	bvd.BootIdentifier = strings.TrimRight(string(data[39:71]), " ")
+	// El Torito pads the identifiers with zeroes, other boot systems may use spaces
+	bvd.BootSystemIdentifier = strings.TrimRight(string(data[7:39]), " \x00")
+	bvd.BootIdentifier = strings.TrimRight(string(data[39:71]), " \x00")
 	if n := copy(bvd.BootSystemUse[:], data[71:2048]); n != 1977 {
 		return fmt.Errorf("BootVolumeDescriptorBody.UnmarshalBinary: copied %d bytes", n)
 	}
 	return nil
 }
 
+// MarshalBinary encodes the body of a Boot Record, with the identifiers padded with zeroes
+func (bvd *BootVolumeDescriptorBody) MarshalBinary() ([]byte, error) {
+	if len(bvd.BootSystemIdentifier) > 32 || len(bvd.BootIdentifier) > 32 {
+		return nil, errors.New("BootVolumeDescriptorBody.MarshalBinary: the identifiers are limited to 32 bytes")
+	}
+	output := make([]byte, sectorSize)
+	copy(output[7:39], bvd.BootSystemIdentifier)
+	copy(output[39:71], bvd.BootIdentifier)
+	copy(output[71:2048], bvd.BootSystemUse[:])
+	return output, nil
+}
+
 type volumeDescriptor struct {
-	Header  volumeDescriptorHeader
-	Boot    *BootVolumeDescriptorBody
-	Primary *PrimaryVolumeDescriptorBody
+	Header    volumeDescriptorHeader
+	Boot      *BootVolumeDescriptorBody
+	Primary   *PrimaryVolumeDescriptorBody
+	Partition *PartitionVolumeDescriptorBody
+
+	// joliet is the UCS-2 level of a Joliet Supplementary Volume Descriptor, 0 for other descriptors
+	joliet int
+	// problem tells why a Supplementary Volume Descriptor cannot be read
+	problem string
 }
 
 var _ encoding.BinaryUnmarshaler = &volumeDescriptor{}
@@ -423,10 +538,20 @@ func (vd *volumeDescriptor) UnmarshalBinary(data []byte) error {
 		vd.Boot = &BootVolumeDescriptorBody{}
 		return vd.Boot.UnmarshalBinary(data)
 	case volumeTypePartition:
-		return errors.New("partition volumes are not yet supported")
+		vd.Partition = &PartitionVolumeDescriptorBody{}
+		return vd.Partition.UnmarshalBinary(data)
 	case volumeTypePrimary, volumeTypeSupplementary:
+		if isJolietDescriptor(data) {
+			vd.joliet = jolietLevel(data[88:120])
+		}
 		vd.Primary = &PrimaryVolumeDescriptorBody{}
-		return vd.Primary.UnmarshalBinary(data)
+		if err := vd.Primary.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		if vd.joliet > 0 {
+			vd.Primary.decodeJolietFields(data)
+		}
+		return nil
 	case volumeTypeTerminator:
 		return nil
 	}
@@ -441,13 +566,20 @@ func (vd volumeDescriptor) MarshalBinary() ([]byte, error) {
 
 	switch vd.Header.Type {
 	case volumeTypeBoot:
-		return nil, errors.New("boot volumes are not yet supported")
+		if output, err = vd.Boot.MarshalBinary(); err != nil {
+			return nil, err
+		}
 	case volumeTypePartition:
-		return nil, errors.New("partition volumes are not yet supported")
+		if output, err = vd.Partition.MarshalBinary(); err != nil {
+			return nil, err
+		}
 	case volumeTypePrimary, volumeTypeSupplementary:
 		if output, err = vd.Primary.MarshalBinary(); err != nil {
 			return nil, err
 		}
+		if vd.joliet > 0 {
+			copy(output[88:120], jolietEscapeSequences[vd.joliet-1])
+		}
 	case volumeTypeTerminator:
 		output = make([]byte, sectorSize)
 	}
@@ -543,6 +675,21 @@ func (ts *VolumeDescriptorTimestamp) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// Time converts the timestamp to time.Time in its time zone, or returns the zero time
+// if the date is unspecified, see ECMA-119 8.4.26.1
+func (ts VolumeDescriptorTimestamp) Time() time.Time {
+	if ts.Year == 0 && ts.Month == 0 && ts.Day == 0 {
+		return time.Time{}
+	}
+	// the offset from GMT is a signed number of 15 minute intervals
+	offset := int(int8(ts.Offset)) * 15 * 60
+	loc := time.UTC
+	if offset != 0 {
+		loc = time.FixedZone("", offset)
+	}
+	return time.Date(ts.Year, time.Month(ts.Month), ts.Day, ts.Hour, ts.Minute, ts.Second, ts.Hundredth*10000000, loc)
+}
+
 // RecordingTimestamp represents a time and date format
 // that can be encoded according to ECMA-119 9.1.5
 type RecordingTimestamp time.Time
@@ -562,13 +709,28 @@ func (ts *RecordingTimestamp) UnmarshalBinary(data []byte) error {
 	min := int(data[4])
 	sec := int(data[5])
 	tzOffset := int(data[6])
-	secondsInAQuarter := 60 * 15
 
-	tz := time.FixedZone("", tzOffset*secondsInAQuarter)
-	*ts = RecordingTimestamp(time.Date(year, time.Month(month), day, hour, min, sec, 0, tz))
+	*ts = RecordingTimestamp(time.Date(year, time.Month(month), day, hour, min, sec, 0, recordingZone(tzOffset)))
 	return nil
 }
 
+var (
+	recordingZonesOnce sync.Once
+	recordingZones     [256]*time.Location
+)
+
+// recordingZone returns the time zone of a recording timestamp offset in units of 15 minutes.
+// The zones are created once, as every directory record has a timestamp.
+func recordingZone(offset int) *time.Location {
+	recordingZonesOnce.Do(func() {
+		secondsInAQuarter := 60 * 15
+		for n := range recordingZones {
+			recordingZones[n] = time.FixedZone("", n*secondsInAQuarter)
+		}
+	})
+	return recordingZones[offset]
+}
+
 // MarshalBinary encodes the RecordingTimestamp in its binary form to a buffer
 // of the length of 7 or more bytes
 func (ts RecordingTimestamp) MarshalBinary(dst []byte) {
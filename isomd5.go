@@ -0,0 +1,313 @@
+package iso9660
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The implanted MD5 checksum is compatible with implantisomd5 and checkisomd5 from isomd5sum,
+// see https://github.com/rhinstaller/isomd5sum
+//
+// The checksum covers the image up to the last SKIPSECTORS sectors, with the application use area
+// of the Primary Volume Descriptor filled with spaces. The image is hashed in chunks of 16 sectors
+// and at the beginning of every fragment, a few characters of the running digest are recorded,
+// so that a corrupted medium can be detected early.
+
+const (
+	isoMD5AppDataOffset   = int64(16*sectorSize + 883) // the application use area of the PVD at sector 16
+	isoMD5AppDataSize     = 512
+	isoMD5ChunkSize       = 16 * sectorSize
+	isoMD5FragmentSumSize = 60
+	isoMD5FragmentCount   = 20
+	isoMD5SkipSectors     = 15
+)
+
+// ErrNoImplantedMD5 is returned when verifying an image which doesn't have an implanted MD5 checksum
+var ErrNoImplantedMD5 = errors.New("the image has no implanted MD5 checksum")
+
+// ErrMD5AlreadyImplanted is returned by ImplantMD5 for an image which already has an implanted checksum
+var ErrMD5AlreadyImplanted = errors.New("the image already has an implanted MD5 checksum")
+
+// ImplantedMD5 is the checksum information stored in the application use area, see Image.ImplantedMD5
+type ImplantedMD5 struct {
+	// MD5 is the digest of the image up to the skipped sectors, in lowercase hex
+	MD5         string
+	SkipSectors int64
+	// FragmentSums holds a few hex digits of the running digest at the end of each of the FragmentCount fragments
+	FragmentSums  string
+	FragmentCount int64
+}
+
+// parseImplantedMD5 extracts the checksum information from the application use area.
+// It returns ErrNoImplantedMD5 if there is none.
+func parseImplantedMD5(appData []byte) (*ImplantedMD5, error) {
+	fields := make(map[string]string)
+	for _, field := range strings.Split(string(appData), ";") {
+		key, value, found := strings.Cut(field, "=")
+		if found {
+			fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	sum, ok := fields["ISO MD5SUM"]
+	if !ok {
+		return nil, ErrNoImplantedMD5
+	}
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != 2*md5.Size {
+		return nil, fmt.Errorf("invalid implanted MD5 checksum %q", sum)
+	}
+
+	result := &ImplantedMD5{MD5: strings.ToLower(sum)}
+
+	if skip, ok := fields["SKIPSECTORS"]; ok {
+		n, err := strconv.ParseInt(skip, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid SKIPSECTORS value %q", skip)
+		}
+		result.SkipSectors = n
+	}
+
+	if count, ok := fields["FRAGMENT COUNT"]; ok {
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil || n < 0 || n > isoMD5FragmentSumSize {
+			return nil, fmt.Errorf("invalid FRAGMENT COUNT value %q", count)
+		}
+		result.FragmentCount = n
+		result.FragmentSums = fields["FRAGMENT SUMS"]
+	}
+
+	return result, nil
+}
+
+// marshal formats the checksum information like implantisomd5, padded with spaces
+func (m *ImplantedMD5) marshal() [isoMD5AppDataSize]byte {
+	var appData [isoMD5AppDataSize]byte
+	for i := range appData {
+		appData[i] = ' '
+	}
+
+	s := fmt.Sprintf("ISO MD5SUM = %s;SKIPSECTORS = %d;RHLISOSTATUS=0;FRAGMENT SUMS = %s;FRAGMENT COUNT = %d;THIS IS NOT THE SAME AS RUNNING MD5SUM ON THIS ISO!!",
+		m.MD5, m.SkipSectors, m.FragmentSums, m.FragmentCount)
+	copy(appData[:], s)
+	return appData
+}
+
+// isoMD5Hasher computes the implanted checksum over the data written to it.
+// The data beyond the hashed length is ignored.
+type isoMD5Hasher struct {
+	md5           hash.Hash
+	length        int64
+	fragmentSize  int64
+	fragmentCount int64
+
+	chunk            []byte
+	chunkOffset      int64
+	previousFragment int64
+	fragmentSums     []byte
+	visited          []bool
+}
+
+func newISOMD5Hasher(length int64, fragmentCount int64) *isoMD5Hasher {
+	h := &isoMD5Hasher{
+		md5:           md5.New(),
+		length:        length,
+		fragmentCount: fragmentCount,
+		chunk:         make([]byte, 0, isoMD5ChunkSize),
+	}
+
+	if fragmentCount > 0 {
+		h.fragmentSize = length / (fragmentCount + 1)
+		h.fragmentSums = []byte(strings.Repeat("0", isoMD5FragmentSumSize))
+		h.visited = make([]bool, fragmentCount)
+	}
+
+	return h
+}
+
+func (h *isoMD5Hasher) Write(p []byte) (int, error) {
+	written := len(p)
+
+	for len(p) > 0 && h.chunkOffset+int64(len(h.chunk)) < h.length {
+		space := min64(int64(cap(h.chunk)-len(h.chunk)), h.length-h.chunkOffset-int64(len(h.chunk)))
+		n := int(min64(space, int64(len(p))))
+		h.chunk = append(h.chunk, p[:n]...)
+		p = p[n:]
+
+		if len(h.chunk) == cap(h.chunk) || h.chunkOffset+int64(len(h.chunk)) == h.length {
+			h.processChunk()
+		}
+	}
+
+	return written, nil
+}
+
+func (h *isoMD5Hasher) processChunk() {
+	// the application use area holding the checksum is hashed as spaces
+	for i := range h.chunk {
+		offset := h.chunkOffset + int64(i)
+		if offset >= isoMD5AppDataOffset && offset < isoMD5AppDataOffset+isoMD5AppDataSize {
+			h.chunk[i] = ' '
+		}
+	}
+	h.md5.Write(h.chunk) // nolint: errcheck
+
+	if h.fragmentSize > 0 {
+		// like isomd5sum, look at the offset of the chunk after it has been hashed
+		fragment := h.chunkOffset / h.fragmentSize
+		if fragment != h.previousFragment && fragment <= h.fragmentCount {
+			digest := h.md5.Sum(nil)
+			charsPerFragment := int64(isoMD5FragmentSumSize) / h.fragmentCount
+			for i := int64(0); i < charsPerFragment && i < md5.Size; i++ {
+				// isomd5sum keeps the first character of the minimal-width hex representation
+				h.fragmentSums[(fragment-1)*charsPerFragment+i] = strconv.FormatInt(int64(digest[i]), 16)[0]
+			}
+			h.visited[fragment-1] = true
+			h.previousFragment = fragment
+		}
+	}
+
+	h.chunkOffset += int64(len(h.chunk))
+	h.chunk = h.chunk[:0]
+}
+
+// result returns the checksum information, once all the hashed data has been written
+func (h *isoMD5Hasher) result(skipSectors int64) *ImplantedMD5 {
+	return &ImplantedMD5{
+		MD5:           hex.EncodeToString(h.md5.Sum(nil)),
+		SkipSectors:   skipSectors,
+		FragmentSums:  string(h.fragmentSums),
+		FragmentCount: h.fragmentCount,
+	}
+}
+
+// matches reports whether the computed checksum matches the implanted one.
+// Only the fragments the hasher has seen are compared.
+func (h *isoMD5Hasher) matches(expected *ImplantedMD5) bool {
+	if hex.EncodeToString(h.md5.Sum(nil)) != expected.MD5 {
+		return false
+	}
+
+	charsPerFragment := int64(0)
+	if h.fragmentCount > 0 {
+		charsPerFragment = int64(isoMD5FragmentSumSize) / h.fragmentCount
+	}
+	for fragment, seen := range h.visited {
+		if !seen {
+			continue
+		}
+		start := int64(fragment) * charsPerFragment
+		end := start + charsPerFragment
+		if end > int64(len(expected.FragmentSums)) || string(h.fragmentSums[start:end]) != expected.FragmentSums[start:end] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ImplantedMD5 returns the checksum information implanted in the application use area of the Primary Volume Descriptor.
+// It returns ErrNoImplantedMD5 if the image has no implanted checksum.
+func (i *Image) ImplantedMD5() (*ImplantedMD5, error) {
+	pvd, err := i.primaryVolume()
+	if err != nil {
+		return nil, err
+	}
+	return parseImplantedMD5(pvd.ApplicationUsed[:])
+}
+
+// ImplantMD5 implants the MD5 checksum of an existing image in place, like implantisomd5 does,
+// with the fragment sums and the skipped sectors ImageWriter.SetImplantMD5 uses. The checksum covers
+// the volume space of the Primary Volume Descriptor at sector 16, so that an image padded after it still verifies.
+// It returns ErrMD5AlreadyImplanted if the image already has a checksum, unless force is set,
+// in which case the checksum is replaced. The application use area of the descriptor is overwritten.
+func ImplantMD5(rw ReadWriterAt, force bool) error {
+	pvd := make([]byte, sectorSize)
+	if _, err := rw.ReadAt(pvd, 16*int64(sectorSize)); err != nil {
+		return fmt.Errorf("reading the primary volume descriptor: %w", err)
+	}
+	if pvd[0] != volumeTypePrimary || !bytes.Equal(pvd[1:6], standardIdentifierBytes[:]) {
+		return errors.New("sector 16 is not a primary volume descriptor")
+	}
+	appData := pvd[isoMD5AppDataOffset-16*int64(sectorSize):][:isoMD5AppDataSize]
+	if _, err := parseImplantedMD5(appData); err != ErrNoImplantedMD5 && !force {
+		return ErrMD5AlreadyImplanted
+	}
+
+	sectors, err := UnmarshalUint32LSBMSB(pvd[80:88])
+	if err != nil {
+		return fmt.Errorf("reading the volume space size: %w", err)
+	}
+	length := (int64(sectors) - isoMD5SkipSectors) * int64(sectorSize)
+	if length <= 0 {
+		return fmt.Errorf("the image of %d sectors is smaller than the %d skipped sectors", sectors, isoMD5SkipSectors)
+	}
+
+	hasher := newISOMD5Hasher(length, isoMD5FragmentCount)
+	if _, err := io.Copy(hasher, io.NewSectionReader(rw, 0, length)); err != nil {
+		return err
+	}
+	if hasher.chunkOffset < length {
+		return fmt.Errorf("the image is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	implanted := hasher.result(isoMD5SkipSectors).marshal()
+	_, err = rw.WriteAt(implanted[:], isoMD5AppDataOffset)
+	return err
+}
+
+// VerifyImplantedMD5 recomputes the MD5 checksum implanted by implantisomd5
+// or ImageWriter.SetImplantMD5 and reports whether the image matches it.
+// If progress isn't nil, it is called as the image is read with the number of bytes hashed so far
+// and the number of bytes to hash in total.
+// It returns ErrNoImplantedMD5 if the image has no implanted checksum.
+func (i *Image) VerifyImplantedMD5(progress func(done, total int64)) (bool, error) {
+	pvd, err := i.primaryVolume()
+	if err != nil {
+		return false, err
+	}
+
+	implanted, err := parseImplantedMD5(pvd.ApplicationUsed[:])
+	if err != nil {
+		return false, err
+	}
+
+	length := int64(pvd.VolumeSpaceSize)*int64(sectorSize) - implanted.SkipSectors*int64(sectorSize)
+	if length <= 0 {
+		return false, fmt.Errorf("the image of %d sectors is smaller than the %d skipped sectors", pvd.VolumeSpaceSize, implanted.SkipSectors)
+	}
+
+	hasher := newISOMD5Hasher(length, implanted.FragmentCount)
+	var w io.Writer = hasher
+	if progress != nil {
+		w = &progressWriter{w: hasher, total: length, progress: progress}
+	}
+	if _, err = io.Copy(w, io.NewSectionReader(bypassCache(i.ra), 0, length)); err != nil {
+		return false, err
+	}
+	if hasher.chunkOffset < length {
+		return false, fmt.Errorf("the image is truncated: %w", io.ErrUnexpectedEOF)
+	}
+
+	return hasher.matches(implanted), nil
+}
+
+// progressWriter reports the number of bytes written through it
+type progressWriter struct {
+	w        io.Writer
+	done     int64
+	total    int64
+	progress func(done, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	p.progress(p.done, p.total)
+	return n, err
+}
@@ -0,0 +1,198 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImplantedMD5(t *testing.T) {
+	appData := []byte("ISO MD5SUM = 6e4fba6ee3ea5ecf0d4d7a3c2fdd3a93;SKIPSECTORS = 15;RHLISOSTATUS=1;" +
+		"FRAGMENT SUMS = 3d8e6e4ec3e8b3d4b1b8c1ab8c7d637f3cd4d5f6b8acb2fa4ebc4a7fb4c7;FRAGMENT COUNT = 20;" +
+		"THIS IS NOT THE SAME AS RUNNING MD5SUM ON THIS ISO!!     ")
+
+	implanted, err := parseImplantedMD5(appData)
+	require.NoError(t, err)
+	assert.Equal(t, &ImplantedMD5{
+		MD5:           "6e4fba6ee3ea5ecf0d4d7a3c2fdd3a93",
+		SkipSectors:   15,
+		FragmentSums:  "3d8e6e4ec3e8b3d4b1b8c1ab8c7d637f3cd4d5f6b8acb2fa4ebc4a7fb4c7",
+		FragmentCount: 20,
+	}, implanted)
+
+	_, err = parseImplantedMD5(bytes.Repeat([]byte{' '}, 512))
+	assert.ErrorIs(t, err, ErrNoImplantedMD5)
+
+	_, err = parseImplantedMD5([]byte("ISO MD5SUM = nothex;"))
+	assert.Error(t, err)
+}
+
+// referenceFragmentSums follows the loop of implantisomd5, reading 32 KiB at a time
+func referenceFragmentSums(data []byte) string {
+	fragmentSize := len(data) / (isoMD5FragmentCount + 1)
+	h := md5.New()
+	sums := ""
+	previous := 0
+	for offset := 0; offset < len(data); offset += int(isoMD5ChunkSize) {
+		end := offset + int(isoMD5ChunkSize)
+		if end > len(data) {
+			end = len(data)
+		}
+		h.Write(data[offset:end])
+
+		if current := offset / fragmentSize; current != previous {
+			digest := h.Sum(nil)
+			for i := 0; i < isoMD5FragmentSumSize/isoMD5FragmentCount; i++ {
+				sums += fmt.Sprintf("%01x", digest[i])[:1]
+			}
+			previous = current
+		}
+	}
+	return sums
+}
+
+func TestWriterImplantMD5(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	payload := make([]byte, 2*1024*1024)
+	rand.New(rand.NewSource(1)).Read(payload)
+	require.NoError(t, w.AddFile(bytes.NewReader(payload), "payload.bin"))
+	w.SetImplantMD5(true)
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "checked"))
+	image := buf.Bytes()
+
+	img, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+	pvd, err := img.primaryVolume()
+	require.NoError(t, err)
+	implanted, err := parseImplantedMD5(pvd.ApplicationUsed[:])
+	require.NoError(t, err)
+
+	// compute the checksum independently over a copy with a blank application use area
+	hashed := append([]byte(nil), image[:len(image)-isoMD5SkipSectors*int(sectorSize)]...)
+	copy(hashed[isoMD5AppDataOffset:isoMD5AppDataOffset+isoMD5AppDataSize], bytes.Repeat([]byte{' '}, isoMD5AppDataSize))
+	sum := md5.Sum(hashed)
+	assert.Equal(t, hex.EncodeToString(sum[:]), implanted.MD5)
+	assert.Equal(t, int64(isoMD5SkipSectors), implanted.SkipSectors)
+	assert.Equal(t, int64(isoMD5FragmentCount), implanted.FragmentCount)
+	assert.Equal(t, referenceFragmentSums(hashed), implanted.FragmentSums)
+
+	var done, total int64
+	ok, err := img.VerifyImplantedMD5(func(d, t int64) {
+		done, total = d, t
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(len(hashed)), total)
+	assert.Equal(t, total, done)
+
+	// the file data isn't part of the tail that is skipped
+	corrupted := append([]byte(nil), image...)
+	corrupted[20*sectorSize] ^= 0xFF
+	img, err = OpenImage(bytes.NewReader(corrupted))
+	require.NoError(t, err)
+	ok, err = img.VerifyImplantedMD5(nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestVerifyImplantedMD5Missing(t *testing.T) {
+	f, err := os.Open("fixtures/test.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	img, err := OpenImage(f)
+	require.NoError(t, err)
+
+	_, err = img.VerifyImplantedMD5(nil)
+	assert.ErrorIs(t, err, ErrNoImplantedMD5)
+}
+
+func TestVerifyImplantedMD5WithoutFragments(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(bytes.NewReader(bytes.Repeat([]byte("data"), 5000)), "data.bin"))
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "tagged"))
+	image := buf.Bytes()
+
+	// a tag as other tools write it, checksumming all but the last 2 sectors and without fragment sums
+	hashed := append([]byte(nil), image[:len(image)-2*int(sectorSize)]...)
+	copy(hashed[isoMD5AppDataOffset:isoMD5AppDataOffset+isoMD5AppDataSize], bytes.Repeat([]byte{' '}, isoMD5AppDataSize))
+	sum := md5.Sum(hashed)
+	tag := fmt.Sprintf("ISO MD5SUM = %x;SKIPSECTORS = 2;", sum)
+	copy(image[isoMD5AppDataOffset:], tag)
+
+	img, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+	ok, err := img.VerifyImplantedMD5(nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// the skipped sectors aren't checksummed
+	image[len(image)-1] ^= 0xFF
+	ok, err = img.VerifyImplantedMD5(nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	image[len(image)-3*int(sectorSize)] ^= 0xFF
+	ok, err = img.VerifyImplantedMD5(nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestImplantMD5(t *testing.T) {
+	build := func() []byte {
+		w, err := NewWriter()
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+		require.NoError(t, w.AddFile(bytes.NewReader(bytes.Repeat([]byte("data"), 100000)), "data.bin"))
+		var buf bytes.Buffer
+		require.NoError(t, w.WriteTo(&buf, "implanted"))
+		return buf.Bytes()
+	}
+	// only the application use area of the image written without a checksum changes
+	original := build()
+	image := &memoryImage{data: append([]byte(nil), original...)}
+	require.NoError(t, ImplantMD5(image, false))
+	assert.Equal(t, original[:isoMD5AppDataOffset], image.data[:isoMD5AppDataOffset])
+	assert.Equal(t, original[isoMD5AppDataOffset+isoMD5AppDataSize:], image.data[isoMD5AppDataOffset+isoMD5AppDataSize:])
+
+	img, err := OpenImage(bytes.NewReader(image.data))
+	require.NoError(t, err)
+	implanted, err := img.ImplantedMD5()
+	require.NoError(t, err)
+	assert.Equal(t, int64(isoMD5SkipSectors), implanted.SkipSectors)
+	assert.Equal(t, int64(isoMD5FragmentCount), implanted.FragmentCount)
+	ok, err := img.VerifyImplantedMD5(nil)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// an existing checksum is only replaced when forced
+	image.data[20*sectorSize] ^= 0xFF
+	assert.ErrorIs(t, ImplantMD5(image, false), ErrMD5AlreadyImplanted)
+	require.NoError(t, ImplantMD5(image, true))
+	img, err = OpenImage(bytes.NewReader(image.data))
+	require.NoError(t, err)
+	ok, err = img.VerifyImplantedMD5(nil)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Error(t, ImplantMD5(&memoryImage{data: make([]byte, 20*sectorSize)}, false), "no primary volume descriptor")
+}
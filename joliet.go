@@ -0,0 +1,205 @@
+package iso9660
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Joliet escape sequences of the UCS-2 levels 1 to 3, recorded in the escape sequences field
+// of a Supplementary Volume Descriptor
+var jolietEscapeSequences = [][]byte{[]byte("%/@"), []byte("%/C"), []byte("%/E")}
+
+// jolietLevel3 is the UCS-2 level written by the ImageWriter, which allows any character of the BMP
+const jolietLevel3 = 3
+
+// maxJolietIdentifierLength is the longest identifier of the Joliet hierarchy in characters, including the version
+const maxJolietIdentifierLength = 64
+
+// jolietReservedCharacters cannot appear in Joliet identifiers
+const jolietReservedCharacters = "*/:;?\\"
+
+// enhancedVolumeDescriptorVersion is the version of the Enhanced Volume Descriptors of ISO 9660:1999,
+// which share the type of Supplementary Volume Descriptors
+const enhancedVolumeDescriptorVersion = 2
+
+// jolietLevel returns the UCS-2 level selected by the escape sequences field of a Supplementary Volume Descriptor,
+// or 0 if it isn't Joliet. The sequence may follow other ISO 2022 designations and be padded with spaces or zeroes.
+func jolietLevel(escapes []byte) int {
+	escapes = bytes.TrimRight(escapes, " \x00")
+	level, at := 0, len(escapes)
+	for n, sequence := range jolietEscapeSequences {
+		if i := bytes.Index(escapes, sequence); i >= 0 && i < at {
+			level, at = n+1, i
+		}
+	}
+	return level
+}
+
+// isJolietDescriptor reports whether the volume descriptor in data is a Joliet Supplementary Volume Descriptor
+func isJolietDescriptor(data []byte) bool {
+	return data[0] == volumeTypeSupplementary && data[6] != enhancedVolumeDescriptorVersion && jolietLevel(data[88:120]) > 0
+}
+
+// checkJolietVolumes records a warning for every Joliet Supplementary Volume Descriptor which cannot be read.
+// Unless ReaderOptions.PreferJoliet is set, the image is read through the Primary Volume Descriptor,
+// so these only concern other readers.
+func (i *Image) checkJolietVolumes() {
+	for n, vd := range i.volumeDescriptors {
+		if vd.Type() != volumeTypeSupplementary || vd.joliet == 0 {
+			continue
+		}
+		problem := vd.problem
+		if problem == "" {
+			problem = primaryVolumeProblem(vd.Primary)
+		}
+		if problem == "" {
+			if _, err := readDotEntry(i.ra, uint32(vd.Primary.RootDirectoryEntry.ExtentLocation), int64(vd.Primary.LogicalBlockSize)); err != nil {
+				problem = fmt.Sprintf("reading the root directory: %v", err)
+			}
+		}
+		if problem != "" {
+			vd.problem = problem
+			i.volumeDescriptors[n] = vd
+			i.warnings.add(ReaderWarning{LBA: i.descriptorSector(n), Reason: fmt.Sprintf(
+				"the Joliet Supplementary Volume Descriptor is invalid, the primary tree is read: %s", problem)})
+		}
+	}
+}
+
+// jolietUnits converts a string to UCS-2, replacing control characters and those outside the BMP by underscores,
+// as well as the reserved characters if identifier is set
+func jolietUnits(s string, identifier bool) []uint16 {
+	units := make([]uint16, 0, len(s))
+	for _, r := range s {
+		if r < 0x20 || r > 0xFFFF || utf16.IsSurrogate(r) || identifier && strings.ContainsRune(jolietReservedCharacters, r) {
+			r = '_'
+		}
+		units = append(units, uint16(r))
+	}
+	return units
+}
+
+// marshalJolietString encodes UCS-2 big endian into a field of the given length, padded with spaces.
+// The string is cut at the end of the field, an odd-sized field ends with a padding byte.
+func marshalJolietString(units []uint16, length int) []byte {
+	data := make([]byte, length)
+	for i := 0; i+1 < len(data); i += 2 {
+		unit := uint16(' ')
+		if i/2 < len(units) {
+			unit = units[i/2]
+		}
+		data[i] = byte(unit >> 8)
+		data[i+1] = byte(unit)
+	}
+	return data
+}
+
+// unmarshalJolietString decodes a UCS-2 big endian field, without its padding
+func unmarshalJolietString(data []byte) string {
+	return strings.TrimRight(decodeJolietIdentifier(string(data)), " \x00")
+}
+
+// decodeJolietIdentifier decodes a UCS-2 big endian identifier, ignoring an odd last byte
+func decodeJolietIdentifier(identifier string) string {
+	units := make([]uint16, len(identifier)/2)
+	for i := range units {
+		units[i] = uint16(identifier[2*i])<<8 | uint16(identifier[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+// newJolietHierarchy returns the hierarchy written with WriterOptions.Joliet.
+// File identifiers get the ";1" version, or that of their names with fileVersions, unless it is omitted from the primary ones.
+func newJolietHierarchy(omitVersion, fileVersions bool) *hierarchy {
+	return &hierarchy{
+		identifier: func(e *stagedEntry, tail string) string {
+			name, version := e.name, ""
+			if !e.isDir() {
+				number := uint16(1)
+				if fileVersions {
+					name, number = splitVersion(name)
+				}
+				if !omitVersion {
+					version = ";" + strconv.Itoa(int(number))
+				}
+			}
+			units := jolietUnits(name, true)
+			if max := maxJolietIdentifierLength - len(tail) - len(version); len(units) > max {
+				units = units[:max]
+			}
+			units = append(units, jolietUnits(tail+version, false)...)
+			return string(marshalJolietString(units, 2*len(units)))
+		},
+		// the identifiers are sorted by their characters rather than their bytes
+		compare: func(a, b string) int {
+			return compareIdentifiers(decodeJolietIdentifier(a), decodeJolietIdentifier(b))
+		},
+	}
+}
+
+// jolietVolumeDescriptor derives the Joliet Supplementary Volume Descriptor from the Primary one
+func (wc *writeContext) jolietVolumeDescriptor(pvd *PrimaryVolumeDescriptorBody) volumeDescriptor {
+	body := wc.hierarchyVolumeDescriptor(wc.joliet, pvd)
+	for _, field := range []struct {
+		value  *string
+		length int
+	}{
+		{&body.SystemIdentifier, 32},
+		{&body.VolumeIdentifier, 32},
+		{&body.VolumeSetIdentifier, 128},
+		{&body.PublisherIdentifier, 128},
+		{&body.DataPreparerIdentifier, 128},
+		{&body.ApplicationIdentifier, 128},
+		{&body.CopyrightFileIdentifier, 37},
+		{&body.AbstractFileIdentifier, 37},
+		{&body.BibliographicFileIdentifier, 37},
+	} {
+		*field.value = string(marshalJolietString(jolietUnits(*field.value, false), field.length))
+	}
+
+	return volumeDescriptor{
+		Header: volumeDescriptorHeader{
+			Type:       volumeTypeSupplementary,
+			Identifier: standardIdentifierBytes,
+			Version:    1,
+		},
+		Primary: body,
+		joliet:  jolietLevel3,
+	}
+}
+
+// decodeJolietFields replaces the descriptive fields of a Joliet descriptor, which are read as bytes,
+// by their UCS-2 values
+func (pvd *PrimaryVolumeDescriptorBody) decodeJolietFields(data []byte) {
+	for _, field := range []struct {
+		value      *string
+		start, end int
+	}{
+		{&pvd.SystemIdentifier, 8, 40},
+		{&pvd.VolumeIdentifier, 40, 72},
+		{&pvd.VolumeSetIdentifier, 190, 318},
+		{&pvd.PublisherIdentifier, 318, 446},
+		{&pvd.DataPreparerIdentifier, 446, 574},
+		{&pvd.ApplicationIdentifier, 574, 702},
+		{&pvd.CopyrightFileIdentifier, 702, 739},
+		{&pvd.AbstractFileIdentifier, 739, 776},
+		{&pvd.BibliographicFileIdentifier, 776, 813},
+	} {
+		*field.value = unmarshalJolietString(data[field.start:field.end])
+	}
+}
+
+// selectJolietVolume selects the first valid Joliet Supplementary Volume Descriptor instead of the Primary one,
+// if there is one
+func (i *Image) selectJolietVolume() {
+	for n, vd := range i.volumeDescriptors {
+		if vd.Type() == volumeTypeSupplementary && vd.joliet > 0 && vd.Primary != nil && vd.problem == "" &&
+			primaryVolumeProblem(vd.Primary) == "" {
+			i.primary = n
+			return
+		}
+	}
+}
@@ -0,0 +1,200 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJolietLevel(t *testing.T) {
+	for escapes, level := range map[string]int{
+		"%/@":            1,
+		"%/C":            2,
+		"%/E":            3,
+		"%/E   ":         3,
+		"%/C\x00\x00":    2,
+		"\x1b(B\x1b%/@":  1,
+		"\x1b(B%/E%/C  ": 3,
+		"":               0,
+		"    ":           0,
+		"%/F":            0,
+		"%/":             0,
+	} {
+		field := make([]byte, 32)
+		copy(field, escapes)
+		assert.Equal(t, level, jolietLevel(field), "%q", escapes)
+	}
+}
+
+// withSupplementaryVolume returns an image with a copy of its Primary Volume Descriptor at sector 17,
+// turned into a Supplementary Volume Descriptor by patch, and the terminator at sector 18.
+// The path tables which were at sector 18 are lost, the tree is read through the root directory records.
+func withSupplementaryVolume(t *testing.T, patch func(svd []byte)) []byte {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("data"), "FILE.TXT"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "joliet"))
+
+	image := buf.Bytes()
+	sector := func(n int) []byte {
+		return image[n*int(sectorSize) : (n+1)*int(sectorSize)]
+	}
+	copy(sector(18), sector(17))
+	svd := sector(17)
+	copy(svd, sector(16))
+	svd[0] = volumeTypeSupplementary
+	patch(svd)
+	return image
+}
+
+func TestJolietVolumeDescriptors(t *testing.T) {
+	for name, c := range map[string]struct {
+		escapes  string
+		version  byte
+		level    int
+		enhanced bool
+	}{
+		"level 1":   {escapes: "%/@", version: 1, level: 1},
+		"level 2":   {escapes: "%/C", version: 1, level: 2},
+		"level 3":   {escapes: "%/E", version: 1, level: 3},
+		"padded":    {escapes: "%/E" + strings.Repeat(" ", 29), version: 1, level: 3},
+		"not UCS-2": {escapes: "", version: 1},
+		// ISO 9660:1999 enhanced descriptors have the type of supplementary ones
+		"enhanced": {escapes: "%/E", version: 2, enhanced: true},
+	} {
+		image := withSupplementaryVolume(t, func(svd []byte) {
+			svd[6] = c.version
+			copy(svd[88:120], c.escapes)
+		})
+		img, err := OpenImage(bytes.NewReader(image))
+		require.NoError(t, err, name)
+
+		vds := img.VolumeDescriptors()
+		require.Len(t, vds, 3, name)
+		assert.Equal(t, volumeTypeSupplementary, vds[1].Type, name)
+		assert.Equal(t, c.level, vds[1].JolietLevel, name)
+		assert.Equal(t, c.enhanced, vds[1].Enhanced, name)
+		assert.Empty(t, vds[1].Problem, name)
+		assert.Empty(t, img.Warnings(), name)
+		assert.Contains(t, filesByPath(t, img), "/FILE.TXT", name)
+
+		// UpdateVolumeMetadata changes the Joliet descriptor only
+		patched := &memoryImage{data: image}
+		require.NoError(t, UpdateVolumeMetadata(patched, VolumeMetadataPatch{VolumeIdentifier: stringPointer("NEW")}), name)
+		assert.Equal(t, c.level > 0, bytes.HasPrefix(patched.data[17*int(sectorSize)+40:], []byte{0, 'N', 0, 'E', 0, 'W'}), name)
+	}
+}
+
+func TestMalformedJolietVolumeDescriptor(t *testing.T) {
+	for name, c := range map[string]struct {
+		patch   func(svd []byte)
+		problem string
+	}{
+		// the root directory record starts at byte 156, its extent location at byte 2 of the record
+		"root beyond the volume": {
+			patch: func(svd []byte) {
+				WriteInt32LSBMSB(svd[158:166], 100000)
+			},
+			problem: "the root directory extent at sector 100000 ends beyond the volume",
+		},
+		"root isn't a directory": {
+			patch: func(svd []byte) {
+				WriteInt32LSBMSB(svd[158:166], 18)
+			},
+			problem: "reading the root directory: ",
+		},
+		"inconsistent volume space size": {
+			patch: func(svd []byte) {
+				svd[80]++
+			},
+			problem: "little-endian and big-endian value mismatch",
+		},
+	} {
+		image := withSupplementaryVolume(t, func(svd []byte) {
+			copy(svd[88:120], "%/E")
+			c.patch(svd)
+		})
+		img, err := OpenImage(bytes.NewReader(image))
+		require.NoError(t, err, name)
+
+		vds := img.VolumeDescriptors()
+		require.Len(t, vds, 3, name)
+		assert.Equal(t, 3, vds[1].JolietLevel, name)
+		assert.Contains(t, vds[1].Problem, c.problem, name)
+
+		warnings := img.Warnings()
+		if assert.Len(t, warnings, 1, name) {
+			assert.Equal(t, uint32(17), warnings[0].LBA)
+			assert.Contains(t, warnings[0].Reason, "the Joliet Supplementary Volume Descriptor is invalid, the primary tree is read: ")
+		}
+		assert.Contains(t, filesByPath(t, img), "/FILE.TXT", name)
+	}
+}
+
+func TestJolietWriter(t *testing.T) {
+	long := strings.Repeat("ünïcödé ", 10) + ".txt"
+	files := map[string]string{
+		"/Mixed Case; with: reserved?.txt": "reserved",
+		"/日本語/ファイル.txt":                    "bmp",
+		"/emoji 🙂.txt":                     "astral",
+		"/" + long:                         "truncated",
+		"/" + strings.Repeat("x", 70):      "first",
+		"/" + strings.Repeat("x", 71):      "second",
+		"/a/b/c/d/e/f/g/h/i/deep":          "deep",
+		"/README":                          "readme",
+	}
+
+	for _, rockRidge := range []bool{false, true} {
+		w, err := NewWriterWithOptions(WriterOptions{Joliet: true, EnhancedVolume: true, EnableRockRidge: rockRidge})
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+		w.SetVolumeMetadata(VolumeMetadata{PublisherIdentifier: "Püblisher"})
+		for name, data := range files {
+			require.NoError(t, w.AddFile(strings.NewReader(data), name))
+		}
+		var buf bytes.Buffer
+		require.NoError(t, w.WriteTo(&buf, "JOLIET"))
+
+		img, err := OpenImageWithOptions(bytes.NewReader(buf.Bytes()), ReaderOptions{PreferJoliet: true})
+		require.NoError(t, err)
+		vds := img.VolumeDescriptors()
+		require.Len(t, vds, 4)
+		assert.Equal(t, 3, vds[1].JolietLevel)
+		assert.True(t, vds[1].Selected)
+		assert.Empty(t, vds[1].Problem)
+		assert.Equal(t, "JOLIET", vds[1].Primary.VolumeIdentifier)
+		assert.Equal(t, "Püblisher", vds[1].Primary.PublisherIdentifier)
+		assert.True(t, vds[2].Enhanced)
+
+		tree := readTree(t, img)
+		assert.Equal(t, "reserved", string(tree["/Mixed Case_ with_ reserved_.txt"]))
+		assert.Equal(t, "bmp", string(tree["/日本語/ファイル.txt"]))
+		assert.Equal(t, "astral", string(tree["/emoji _.txt"]))
+		assert.Equal(t, "readme", string(tree["/README"]))
+		assert.Equal(t, "deep", string(tree["/a/b/c/d/e/f/g/h/i/deep"]))
+		assert.Equal(t, "first", string(tree["/"+strings.Repeat("x", 62)]))
+		assert.Equal(t, "second", string(tree["/"+strings.Repeat("x", 60)+"_1"]))
+		assert.Equal(t, "truncated", string(tree["/"+string([]rune(long)[:62])]))
+		assert.Len(t, tree, len(files)+10)
+
+		// the enhanced hierarchy takes precedence, and the primary one is unchanged
+		img, err = OpenImageWithOptions(bytes.NewReader(buf.Bytes()), ReaderOptions{PreferJoliet: true, PreferEnhanced: true})
+		require.NoError(t, err)
+		assert.True(t, img.VolumeDescriptors()[2].Selected)
+		img, err = OpenImage(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		assert.True(t, img.VolumeDescriptors()[0].Selected)
+		assert.Equal(t, "readme", string(readTree(t, img)["/README"]))
+		report, err := img.Verify()
+		require.NoError(t, err)
+		assert.Empty(t, report.Findings)
+	}
+}
@@ -0,0 +1,352 @@
+package iso9660
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry types of a ManifestEntry
+const (
+	ManifestFile      = "file"
+	ManifestDirectory = "directory"
+	ManifestSymlink   = "symlink"
+)
+
+// Manifest declares the contents of an image for BuildImage
+type Manifest struct {
+	// Volume is written to the Primary Volume Descriptor. If it's empty, the defaults of NewWriter are used.
+	Volume    VolumeMetadata  `json:"volume"`
+	RockRidge bool            `json:"rock_ridge"`
+	Entries   []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry is a file, directory or symlink of a Manifest.
+// Parent directories which aren't listed are created with the default attributes.
+type ManifestEntry struct {
+	ISOPath string `json:"iso_path"`
+	// Type is one of ManifestFile, ManifestDirectory and ManifestSymlink; empty means a file
+	Type string `json:"type,omitempty"`
+	// SourcePath is a local file with the contents of a file, Content holds them inline instead
+	SourcePath string `json:"source_path,omitempty"`
+	Content    []byte `json:"content,omitempty"`
+	// Mode holds the permission bits, defaulting to those of NewWriter if zero
+	Mode          fs.FileMode `json:"mode,omitempty"`
+	UID           uint32      `json:"uid,omitempty"`
+	GID           uint32      `json:"gid,omitempty"`
+	MTime         time.Time   `json:"mtime"`
+	SymlinkTarget string      `json:"symlink_target,omitempty"`
+	Hidden        bool        `json:"hidden,omitempty"`
+
+	// The fields below describe an existing image, see ManifestFromImage and ExportManifest.
+	// BuildImage ignores them, except that inline contents must match SHA256 if it's set.
+
+	// Size is the size of a file, uncompressed in case of zisofs
+	Size int64 `json:"size,omitempty"`
+	// ExtentLBA and ExtentLength locate the data of the entry within the image
+	ExtentLBA    uint32 `json:"extent_lba,omitempty"`
+	ExtentLength uint32 `json:"extent_length,omitempty"`
+	// SHA256 is the hex-encoded SHA-256 digest of the contents of a file
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// ManifestOptions controls how ExportManifest describes an image
+type ManifestOptions struct {
+	// Lines writes every entry as a JSON object on a line of its own instead of a single Manifest document.
+	// The volume metadata is left out then.
+	Lines bool
+	// Hash records the SHA-256 digest of every file, which requires reading all the data
+	Hash bool
+	// Content embeds the contents of the files, so that BuildImage can rebuild the image from the manifest alone
+	Content bool
+}
+
+// ManifestError lists all the problems BuildImage found in a manifest
+type ManifestError struct {
+	Problems []string
+}
+
+func (e *ManifestError) Error() string {
+	return "invalid manifest: " + strings.Join(e.Problems, "; ")
+}
+
+func (e ManifestEntry) entryType() string {
+	if e.Type == "" {
+		return ManifestFile
+	}
+	return e.Type
+}
+
+// validate checks the whole manifest before anything is staged
+func (m Manifest) validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	types := make(map[string]string)
+	var paths []string
+	for i, e := range m.Entries {
+		p := path.Join("/", posixifyPath(e.ISOPath))
+		if p == "/" {
+			problem("entry %d has no path", i)
+			continue
+		}
+		if _, ok := types[p]; ok {
+			problem("%s is listed more than once", p)
+		} else {
+			paths = append(paths, p)
+		}
+		types[p] = e.entryType()
+
+		if e.Mode&^(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) != 0 {
+			problem("%s: mode %s has bits other than the permissions", p, e.Mode)
+		}
+
+		switch e.entryType() {
+		case ManifestFile:
+			switch {
+			case e.SourcePath != "" && e.Content != nil:
+				problem("%s has both a source path and content", p)
+			case e.SourcePath != "":
+				info, err := os.Lstat(e.SourcePath)
+				if err != nil {
+					problem("%s: %s", p, err)
+				} else if !info.Mode().IsRegular() {
+					problem("%s: source %s is not a regular file", p, e.SourcePath)
+				}
+			}
+			if e.SHA256 != "" && e.Content != nil {
+				if sum := sha256.Sum256(e.Content); hex.EncodeToString(sum[:]) != strings.ToLower(e.SHA256) {
+					problem("%s: the content doesn't match the SHA-256 digest %s", p, e.SHA256)
+				}
+			}
+		case ManifestDirectory, ManifestSymlink:
+			if e.SourcePath != "" || e.Content != nil {
+				problem("%s is a %s and cannot have contents", p, e.entryType())
+			}
+			if e.entryType() == ManifestSymlink {
+				if e.SymlinkTarget == "" {
+					problem("%s is a symlink without a target", p)
+				}
+				if !m.RockRidge {
+					problem("%s is a symlink, which requires Rock Ridge", p)
+				}
+			}
+		default:
+			problem("%s has the unknown type %q", p, e.Type)
+		}
+	}
+
+	// parents have to be directories
+	for _, p := range paths {
+		for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+			if t, ok := types[dir]; ok && t != ManifestDirectory {
+				problem("%s is inside of %s, which is a %s", p, dir, t)
+				break
+			}
+		}
+	}
+
+	if problems == nil {
+		return nil
+	}
+	return &ManifestError{Problems: problems}
+}
+
+// BuildImage writes the image declared by the manifest to w.
+// The whole manifest is validated first and all its problems are returned at once as a *ManifestError.
+func BuildImage(w io.Writer, manifest Manifest) error {
+	if err := manifest.validate(); err != nil {
+		return err
+	}
+
+	opts := WriterOptions{EnableRockRidge: manifest.RockRidge}
+	if manifest.Volume != (VolumeMetadata{}) {
+		opts.Volume = &manifest.Volume
+	}
+	iw, err := NewWriterWithOptions(opts)
+	if err != nil {
+		return err
+	}
+	defer iw.Cleanup() // nolint: errcheck
+
+	for _, e := range manifest.Entries {
+		if err := iw.addManifestEntry(e); err != nil {
+			return fmt.Errorf("staging %s: %w", e.ISOPath, err)
+		}
+	}
+
+	return iw.WriteTo(w, "")
+}
+
+func (iw *ImageWriter) addManifestEntry(e ManifestEntry) error {
+	opts := []EntryOption{WithOwner(e.UID, e.GID)}
+	if e.Mode != 0 {
+		opts = append(opts, WithMode(e.Mode))
+	}
+	if !e.MTime.IsZero() {
+		opts = append(opts, WithModTime(e.MTime))
+	}
+
+	var err error
+	switch e.entryType() {
+	case ManifestDirectory:
+		err = iw.addDirectory(e.ISOPath, "the manifest", opts)
+	case ManifestSymlink:
+		entry := newStagedSymlink("", e.SymlinkTarget, iw.now())
+		entry.origin = "the manifest"
+		err = iw.stage(e.ISOPath, entry)
+	case ManifestFile:
+		if e.SourcePath != "" {
+			err = iw.AddLocalFile(e.SourcePath, e.ISOPath)
+		} else {
+			err = iw.addReader(bytes.NewReader(e.Content), e.ISOPath, "the manifest")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = iw.applyOptions(e.ISOPath, opts); err != nil {
+		return err
+	}
+	if e.Hidden {
+		return iw.SetHidden(e.ISOPath, true)
+	}
+	return nil
+}
+
+// ManifestFromImage describes the contents of an image as a Manifest with inline contents,
+// so that building the manifest produces an image with the same files
+func ManifestFromImage(img *Image) (Manifest, error) {
+	return img.describe(ManifestOptions{Content: true})
+}
+
+// ExportManifest writes an inventory of the image to w as JSON, in the schema BuildImage consumes.
+// The entries are ordered by path, parents before their contents, so that the output only depends on the image.
+func (i *Image) ExportManifest(w io.Writer, opts ManifestOptions) error {
+	manifest, err := i.describe(opts)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	if opts.Lines {
+		for _, e := range manifest.Entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	enc.SetIndent("", "  ")
+	return enc.Encode(manifest)
+}
+
+// describe walks the image and lists its entries as a Manifest
+func (i *Image) describe(opts ManifestOptions) (Manifest, error) {
+	volume, err := i.VolumeMetadata()
+	if err != nil {
+		return Manifest{}, err
+	}
+	rockRidge, err := i.HasRockRidge()
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	manifest := Manifest{
+		Volume:    volume,
+		RockRidge: rockRidge,
+		Entries:   []ManifestEntry{},
+	}
+
+	root, err := i.RootDir()
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	var walk func(dir *File, prefix string) error
+	walk = func(dir *File, prefix string) error {
+		children, err := dir.getChildren(true)
+		if err != nil {
+			return err
+		}
+		sort.Slice(children, func(i, j int) bool { return children[i].Name() < children[j].Name() })
+
+		for _, c := range children {
+			e := ManifestEntry{
+				ISOPath:      prefix + c.Name(),
+				Mode:         c.Mode() &^ fs.ModeType,
+				MTime:        c.ModTime(),
+				Hidden:       c.IsHidden(),
+				ExtentLBA:    uint32(c.de.ExtentLocation),
+				ExtentLength: c.de.ExtentLength,
+			}
+			e.UID, e.GID, _ = c.Owner()
+
+			switch {
+			case c.IsDir():
+				e.Type = ManifestDirectory
+			case c.Mode()&fs.ModeSymlink != 0:
+				e.Type = ManifestSymlink
+				e.SymlinkTarget = c.SymlinkTarget()
+			default:
+				e.Size = c.Size()
+				if err := describeContent(&e, c, opts); err != nil {
+					return fmt.Errorf("reading %s: %w", e.ISOPath, err)
+				}
+			}
+			manifest.Entries = append(manifest.Entries, e)
+
+			if c.IsDir() {
+				if err := walk(c, e.ISOPath+"/"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := walk(root, "/"); err != nil {
+		return Manifest{}, err
+	}
+	return manifest, nil
+}
+
+// describeContent embeds or hashes the contents of a file, as the options ask, reading them only once
+func describeContent(e *ManifestEntry, f *File, opts ManifestOptions) error {
+	if !opts.Content && !opts.Hash {
+		return nil
+	}
+
+	h := sha256.New()
+	var writers []io.Writer
+	if opts.Hash {
+		writers = append(writers, h)
+	}
+	var content bytes.Buffer
+	if opts.Content {
+		writers = append(writers, &content)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), f.Reader()); err != nil {
+		return err
+	}
+
+	if opts.Hash {
+		e.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	if opts.Content {
+		e.Content = content.Bytes()
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildImageValidation(t *testing.T) {
+	err := BuildImage(&bytes.Buffer{}, Manifest{Entries: []ManifestEntry{
+		{ISOPath: "/"},
+		{ISOPath: "a.txt", Content: []byte("a")},
+		{ISOPath: "/a.txt", Content: []byte("b")},
+		{ISOPath: "missing.txt", SourcePath: "fixtures/does-not-exist"},
+		{ISOPath: "both.txt", SourcePath: "fixtures/test.iso", Content: []byte("c")},
+		{ISOPath: "fixtures", SourcePath: "fixtures"},
+		{ISOPath: "link", Type: ManifestSymlink, SymlinkTarget: "a.txt"},
+		{ISOPath: "dir", Type: ManifestDirectory, Content: []byte("d")},
+		{ISOPath: "device", Type: "device"},
+		{ISOPath: "setuid", Mode: fs.ModeSetuid | 0755},
+		{ISOPath: "typed", Mode: 0x80000000 | 0755},
+		{ISOPath: "a.txt/nested", Content: []byte("e")},
+		{ISOPath: "hashed.txt", Content: []byte("f"), SHA256: "0000"},
+	}})
+
+	var manifestErr *ManifestError
+	require.True(t, errors.As(err, &manifestErr))
+	assert.Equal(t, []string{
+		"entry 0 has no path",
+		"/a.txt is listed more than once",
+		"/missing.txt: lstat fixtures/does-not-exist: no such file or directory",
+		"/both.txt has both a source path and content",
+		"/fixtures: source fixtures is not a regular file",
+		"/link is a symlink, which requires Rock Ridge",
+		"/dir is a directory and cannot have contents",
+		`/device has the unknown type "device"`,
+		"/typed: mode drwxr-xr-x has bits other than the permissions",
+		"/hashed.txt: the content doesn't match the SHA-256 digest 0000",
+		"/a.txt/nested is inside of /a.txt, which is a file",
+	}, manifestErr.Problems)
+}
+
+func TestBuildImageRoundTrip(t *testing.T) {
+	mtime := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	manifest := Manifest{
+		Volume: VolumeMetadata{
+			SystemIdentifier:      "LINUX",
+			VolumeIdentifier:      "MANIFEST",
+			ApplicationIdentifier: "TEST",
+		},
+		RockRidge: true,
+		Entries: []ManifestEntry{
+			{ISOPath: "/bin", Type: ManifestDirectory, Mode: 0755, MTime: mtime},
+			{ISOPath: "/bin/tool", Content: []byte("#!/bin/sh\n"), Mode: fs.ModeSetuid | 0755, UID: 0, GID: 50, MTime: mtime},
+			{ISOPath: "/bin/alias", Type: ManifestSymlink, SymlinkTarget: "tool", Mode: 0777, MTime: mtime},
+			{ISOPath: "/cicero.txt", SourcePath: "fixtures/test.iso_source/cicero.txt", Mode: 0600, UID: 1000, GID: 1000, MTime: mtime},
+			{ISOPath: "/secret", Content: []byte{}, Mode: 0644, MTime: mtime, Hidden: true},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, BuildImage(&buf, manifest))
+
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	label, err := img.Label()
+	require.NoError(t, err)
+	assert.Equal(t, "MANIFEST", label)
+
+	described, err := ManifestFromImage(img)
+	require.NoError(t, err)
+	assert.Equal(t, manifest.Volume, described.Volume)
+	assert.True(t, described.RockRidge)
+	require.Len(t, described.Entries, len(manifest.Entries))
+
+	byPath := make(map[string]ManifestEntry)
+	for _, e := range described.Entries {
+		byPath[e.ISOPath] = e
+	}
+	for _, expected := range manifest.Entries {
+		e, ok := byPath[expected.ISOPath]
+		require.True(t, ok, expected.ISOPath)
+		assert.Equal(t, expected.entryType(), e.entryType(), expected.ISOPath)
+		assert.Equal(t, expected.Mode, e.Mode, expected.ISOPath)
+		assert.Equal(t, [2]uint32{expected.UID, expected.GID}, [2]uint32{e.UID, e.GID}, expected.ISOPath)
+		assert.True(t, expected.MTime.Equal(e.MTime), expected.ISOPath)
+		assert.Equal(t, expected.SymlinkTarget, e.SymlinkTarget, expected.ISOPath)
+		assert.Equal(t, expected.Hidden, e.Hidden, expected.ISOPath)
+	}
+	assert.Equal(t, "#!/bin/sh\n", string(byPath["/bin/tool"].Content))
+	assert.Contains(t, string(byPath["/cicero.txt"].Content), "At vero eos")
+
+	// the described manifest survives JSON and builds the same image contents
+	data, err := json.Marshal(described)
+	require.NoError(t, err)
+	var decoded Manifest
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	var rebuilt bytes.Buffer
+	require.NoError(t, BuildImage(&rebuilt, decoded))
+	img, err = OpenImage(bytes.NewReader(rebuilt.Bytes()))
+	require.NoError(t, err)
+	redescribed, err := ManifestFromImage(img)
+	require.NoError(t, err)
+	redata, err := json.Marshal(redescribed)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(redata))
+}
+
+func TestExportManifest(t *testing.T) {
+	f, err := os.Open("fixtures/test_rockridge.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	img, err := OpenImage(f)
+	require.NoError(t, err)
+
+	var lines bytes.Buffer
+	require.NoError(t, img.ExportManifest(&lines, ManifestOptions{Lines: true, Hash: true}))
+	var again bytes.Buffer
+	require.NoError(t, img.ExportManifest(&again, ManifestOptions{Lines: true, Hash: true}))
+	assert.Equal(t, lines.String(), again.String())
+
+	var entries []ManifestEntry
+	dec := json.NewDecoder(&lines)
+	for dec.More() {
+		var e ManifestEntry
+		require.NoError(t, dec.Decode(&e))
+		entries = append(entries, e)
+	}
+	require.NotEmpty(t, entries)
+	assert.Equal(t, "/cicero.txt", entries[0].ISOPath)
+	assert.Equal(t, "/dir1", entries[1].ISOPath)
+
+	lorem := entries[2]
+	assert.Equal(t, "/dir1/lorem_ipsum.txt", lorem.ISOPath)
+	assert.Equal(t, int64(446), lorem.Size)
+	assert.Equal(t, uint32(446), lorem.ExtentLength)
+	assert.NotZero(t, lorem.ExtentLBA)
+	assert.Equal(t, [2]uint32{1000, 1000}, [2]uint32{lorem.UID, lorem.GID})
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte(loremIpsum))), lorem.SHA256)
+	assert.Nil(t, lorem.Content)
+
+	// a single document with the contents rebuilds the image, checking the digests on the way
+	var document bytes.Buffer
+	require.NoError(t, img.ExportManifest(&document, ManifestOptions{Hash: true, Content: true}))
+	var manifest Manifest
+	require.NoError(t, json.Unmarshal(document.Bytes(), &manifest))
+	assert.True(t, manifest.RockRidge)
+	assert.Len(t, manifest.Entries, len(entries))
+	require.NoError(t, BuildImage(io.Discard, manifest))
+}
@@ -0,0 +1,161 @@
+package iso9660
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrBootCatalogUnsupported is returned by MasteringConfig.Apply for a configuration with boot images
+// which aren't listed in the directory tree, as the ImageWriter only boots from staged files
+var ErrBootCatalogUnsupported = errors.New("writing an El Torito boot catalog is not supported")
+
+// BootImage is a boot image of an El Torito image, see CloneMasteringConfig
+type BootImage struct {
+	Entry BootEntry
+	// Path is the path of the file in the directory tree holding the image, empty if the image isn't listed
+	Path string
+	// Data holds the contents of the image
+	Data []byte
+}
+
+// MasteringConfig holds the configuration of an existing image which is reproduced by an image built from it
+type MasteringConfig struct {
+	// Options carry the volume metadata, the system area and the Rock Ridge settings of the source
+	Options WriterOptions
+	// Boot lists the boot images of the source in the order of its boot catalog
+	Boot []BootImage
+}
+
+// CloneMasteringConfig extracts the configuration of an image needed to rebuild it with a different payload:
+// its volume metadata, its system area, copied verbatim, its Rock Ridge settings and its El Torito boot images.
+// The returned options can be passed to NewWriterWithOptions, or applied to an existing ImageWriter with Apply.
+//
+// A system area holding a partition table, as on isohybrid images, describes the layout of the source,
+// which isn't necessarily that of the new image.
+func CloneMasteringConfig(img *Image) (MasteringConfig, error) {
+	volume, err := img.VolumeMetadata()
+	if err != nil {
+		return MasteringConfig{}, err
+	}
+	extensions, err := img.Extensions()
+	if err != nil {
+		return MasteringConfig{}, err
+	}
+
+	systemArea := make([]byte, systemAreaSize)
+	if _, err := img.ra.ReadAt(systemArea, 0); err != nil {
+		return MasteringConfig{}, fmt.Errorf("reading the system area: %w", err)
+	}
+
+	config := MasteringConfig{Options: WriterOptions{Volume: &volume, SystemArea: systemArea}}
+	for _, identifier := range extensions {
+		if _, err := rockRidgeExtensionRecord(identifier); err == nil {
+			config.Options.RockRidgeIdentifier = identifier
+		}
+	}
+	if config.Options.EnableRockRidge, err = img.HasRockRidge(); err != nil {
+		return MasteringConfig{}, err
+	}
+	if !config.Options.EnableRockRidge {
+		config.Options.RockRidgeIdentifier = ""
+	}
+
+	images, err := img.BootImages()
+	if err != nil {
+		return MasteringConfig{}, err
+	}
+	for _, boot := range images {
+		data := make([]byte, boot.Size)
+		if _, err := io.ReadFull(boot.Reader, data); err != nil {
+			return MasteringConfig{}, fmt.Errorf("reading the boot image at sector %d: %w", boot.Entry.LBA, err)
+		}
+		config.Boot = append(config.Boot, BootImage{Entry: boot.Entry, Path: boot.Path, Data: data})
+	}
+	return config, nil
+}
+
+// Apply configures an ImageWriter with the volume metadata, the system area and the Rock Ridge settings,
+// and stages the boot images at their paths with their entries in the boot catalog, see AddBootEntry.
+// It fails with ErrBootCatalogUnsupported without changing the ImageWriter if a boot image has no path,
+// the boot images can be dropped from Boot to build a non-bootable image.
+func (c MasteringConfig) Apply(iw *ImageWriter) error {
+	for _, boot := range c.Boot {
+		if boot.Path == "" {
+			return fmt.Errorf("%w, the boot image at sector %d isn't listed in the directory tree", ErrBootCatalogUnsupported, boot.Entry.LBA)
+		}
+	}
+	if c.Options.RockRidgeIdentifier != "" {
+		if _, err := rockRidgeExtensionRecord(c.Options.RockRidgeIdentifier); err != nil {
+			return err
+		}
+	}
+	if err := iw.SetSystemArea(c.Options.SystemArea); err != nil {
+		return err
+	}
+
+	iw.SetRockRidge(c.Options.EnableRockRidge)
+	if c.Options.RockRidgeIdentifier != "" {
+		iw.rrip = c.Options.RockRidgeIdentifier
+	}
+	if c.Options.Volume != nil {
+		iw.SetVolumeMetadata(*c.Options.Volume)
+	}
+
+	for _, boot := range c.Boot {
+		if err := iw.AddFile(bytes.NewReader(boot.Data), boot.Path); err != nil {
+			return err
+		}
+		opts := []BootOption{WithBootLoadSegment(boot.Entry.LoadSegment)}
+		if boot.Entry.Emulation == BootNoEmulation {
+			opts = append(opts, WithBootLoadSize(boot.Entry.SectorCount))
+		}
+		if err := iw.AddBootEntry(boot.Entry.PlatformID, boot.Entry.Emulation, boot.Path, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// locatedFile is a file of the directory tree found by filesByLocation
+type locatedFile struct {
+	path string
+	size int64
+}
+
+// filesByLocation maps the extent locations of the regular files in the directory tree, in sectors as El Torito counts them,
+// to their paths and sizes
+func (i *Image) filesByLocation() (map[uint32]locatedFile, error) {
+	root, err := i.RootDir()
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[uint32]locatedFile{}
+	var walk func(dir *File, prefix string) error
+	walk = func(dir *File, prefix string) error {
+		children, err := dir.getChildren(true)
+		if err != nil {
+			return err
+		}
+		for _, c := range children {
+			if c.IsDir() {
+				if err := walk(c, prefix+c.Name()+"/"); err != nil {
+					return err
+				}
+				continue
+			}
+			location := uint32(int64(c.de.ExtentLocation) * c.logicalBlockSize() / int64(sectorSize))
+			if _, ok := files[location]; !ok && c.Mode().IsRegular() {
+				files[location] = locatedFile{path: prefix + c.Name(), size: c.Size()}
+			}
+		}
+		return nil
+	}
+
+	if err := walk(root, "/"); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
@@ -0,0 +1,155 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bootableImage returns a Rock Ridge image with an El Torito catalog holding
+// a BIOS initial entry for /boot/loader.bin and an EFI section entry for /boot/efi.img
+func bootableImage(t *testing.T) (image []byte, loader, efi []byte) {
+	loader = bytes.Repeat([]byte("loader"), 700)
+	efi = bytes.Repeat([]byte("efi"), 3000)
+
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Volume: &VolumeMetadata{
+		VolumeIdentifier:    "INSTALLER",
+		PublisherIdentifier: "PUBLISHER",
+	}})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(bytes.NewReader(make([]byte, sectorSize)), "boot/boot.cat"))
+	require.NoError(t, w.AddFile(bytes.NewReader(loader), "boot/loader.bin"))
+	require.NoError(t, w.AddFile(bytes.NewReader(efi), "boot/efi.img"))
+	require.NoError(t, w.AddFile(strings.NewReader("payload"), "payload.txt"))
+	mbr := make([]byte, 512)
+	copy(mbr, "isohybrid")
+	mbr[510], mbr[511] = 0x55, 0xAA
+	require.NoError(t, w.SetSystemArea(mbr))
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "INSTALLER"))
+	image = buf.Bytes()
+
+	img, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+	byPath := filesByPath(t, img)
+	catalogLBA := uint32(byPath["/boot/boot.cat"].de.ExtentLocation)
+
+	catalog := image[catalogLBA*sectorSize:]
+	catalog[0], catalog[1] = 1, BootPlatformX86
+	catalog[30], catalog[31] = 0x55, 0xAA
+	initial := catalog[32:64]
+	initial[0] = 0x88
+	binary.LittleEndian.PutUint16(initial[6:8], 4)
+	binary.LittleEndian.PutUint32(initial[8:12], uint32(byPath["/boot/loader.bin"].de.ExtentLocation))
+	header := catalog[64:96]
+	header[0], header[1] = 0x91, BootPlatformEFI
+	binary.LittleEndian.PutUint16(header[2:4], 1)
+	section := catalog[96:128]
+	section[0] = 0x88
+	binary.LittleEndian.PutUint16(section[6:8], 1)
+	binary.LittleEndian.PutUint32(section[8:12], uint32(byPath["/boot/efi.img"].de.ExtentLocation))
+
+	// move the terminator to make room for the boot record
+	copy(image[18*sectorSize:19*sectorSize], image[17*sectorSize:18*sectorSize])
+	record := image[17*sectorSize : 18*sectorSize]
+	for i := range record {
+		record[i] = 0
+	}
+	record[0] = volumeTypeBoot
+	copy(record[1:6], standardIdentifier)
+	record[6] = 1
+	copy(record[7:39], elToritoSystemIdentifier+strings.Repeat(" ", 32-len(elToritoSystemIdentifier)))
+	binary.LittleEndian.PutUint32(record[71:75], catalogLBA)
+	return image, loader, efi
+}
+
+func TestBootEntries(t *testing.T) {
+	image, _, _ := bootableImage(t)
+	img, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+	byPath := filesByPath(t, img)
+
+	entries, err := img.BootEntries()
+	require.NoError(t, err)
+	assert.Equal(t, []BootEntry{
+		{PlatformID: BootPlatformX86, Bootable: true, SectorCount: 4, LBA: uint32(byPath["/boot/loader.bin"].de.ExtentLocation)},
+		{PlatformID: BootPlatformEFI, Bootable: true, SectorCount: 1, LBA: uint32(byPath["/boot/efi.img"].de.ExtentLocation)},
+	}, entries)
+
+	plain, _ := streamImage(t)
+	img, err = OpenImage(bytes.NewReader(plain))
+	require.NoError(t, err)
+	entries, err = img.BootEntries()
+	assert.NoError(t, err)
+	assert.Nil(t, entries)
+}
+
+func TestCloneMasteringConfig(t *testing.T) {
+	image, loader, efi := bootableImage(t)
+	img, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+
+	config, err := CloneMasteringConfig(img)
+	require.NoError(t, err)
+	assert.True(t, config.Options.EnableRockRidge)
+	assert.Equal(t, RockRidgeIdentifier1991A, config.Options.RockRidgeIdentifier)
+	assert.Equal(t, image[:systemAreaSize], config.Options.SystemArea)
+	require.NotNil(t, config.Options.Volume)
+	assert.Equal(t, "INSTALLER", config.Options.Volume.VolumeIdentifier)
+	assert.Equal(t, "PUBLISHER", config.Options.Volume.PublisherIdentifier)
+
+	// no emulation images are as long as the files holding them
+	require.Len(t, config.Boot, 2)
+	assert.Equal(t, "/boot/loader.bin", config.Boot[0].Path)
+	assert.Equal(t, loader, config.Boot[0].Data)
+	assert.Equal(t, BootPlatformEFI, config.Boot[1].Entry.PlatformID)
+	assert.Equal(t, "/boot/efi.img", config.Boot[1].Path)
+	assert.Equal(t, efi, config.Boot[1].Data)
+
+	// boot images which aren't listed in the tree cannot be staged
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	unlisted := config
+	unlisted.Boot = append([]BootImage{{Entry: BootEntry{LBA: 100}, Data: loader}}, config.Boot...)
+	assert.True(t, errors.Is(unlisted.Apply(w), ErrBootCatalogUnsupported))
+	assert.False(t, w.rockRidge)
+
+	require.NoError(t, config.Apply(w))
+	require.NoError(t, w.AddFile(strings.NewReader("replaced"), "payload.txt"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "INSTALLER"))
+
+	rebuilt, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, image[:systemAreaSize], buf.Bytes()[:systemAreaSize])
+	rockRidge, err := rebuilt.HasRockRidge()
+	require.NoError(t, err)
+	assert.True(t, rockRidge)
+	volume, err := rebuilt.VolumeMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, *config.Options.Volume, volume)
+
+	// the boot images are staged at their paths and booted from there
+	byPath := filesByPath(t, rebuilt)
+	entries, err := rebuilt.BootEntries()
+	require.NoError(t, err)
+	assert.Equal(t, []BootEntry{
+		{PlatformID: BootPlatformX86, Bootable: true, SectorCount: 4, LBA: uint32(byPath["/boot/loader.bin"].de.ExtentLocation)},
+		{PlatformID: BootPlatformEFI, Bootable: true, SectorCount: 1, LBA: uint32(byPath["/boot/efi.img"].de.ExtentLocation)},
+	}, entries)
+	data, err := io.ReadAll(byPath["/boot/efi.img"].Reader())
+	require.NoError(t, err)
+	assert.Equal(t, efi, data)
+}
@@ -0,0 +1,174 @@
+package iso9660
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// ReadWriterAt is the interface needed to modify an image in place, implemented by *os.File
+type ReadWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// VolumeMetadataPatch lists the changes to the descriptive fields of the volume descriptors.
+// Fields left nil are not modified.
+type VolumeMetadataPatch struct {
+	SystemIdentifier            *string
+	VolumeIdentifier            *string
+	VolumeSetIdentifier         *string
+	PublisherIdentifier         *string
+	DataPreparerIdentifier      *string
+	ApplicationIdentifier       *string
+	CopyrightFileIdentifier     *string
+	AbstractFileIdentifier      *string
+	BibliographicFileIdentifier *string
+}
+
+// volumeMetadataField is the location of a descriptive field within a volume descriptor, see ECMA-119 8.4
+type volumeMetadataField struct {
+	name       string
+	start, end int
+	// characters allowed in the Primary Volume Descriptor
+	characters string
+	value      *string
+}
+
+const (
+	// ECMA-119 7.4.1, including the space which the aCharacters constant leaves out
+	aCharactersWithSpace = aCharacters + " "
+	// ECMA-119 7.5.1, file identifiers may contain the separators as well
+	fileIdentifierCharacters = dCharacters + ".;"
+
+	maxVolumeDescriptors = 64
+)
+
+func (p *VolumeMetadataPatch) fields() []volumeMetadataField {
+	return []volumeMetadataField{
+		{"system identifier", 8, 40, aCharactersWithSpace, p.SystemIdentifier},
+		{"volume identifier", 40, 72, dCharacters, p.VolumeIdentifier},
+		{"volume set identifier", 190, 318, dCharacters, p.VolumeSetIdentifier},
+		{"publisher identifier", 318, 446, aCharactersWithSpace, p.PublisherIdentifier},
+		{"data preparer identifier", 446, 574, aCharactersWithSpace, p.DataPreparerIdentifier},
+		{"application identifier", 574, 702, aCharactersWithSpace, p.ApplicationIdentifier},
+		{"copyright file identifier", 702, 739, fileIdentifierCharacters, p.CopyrightFileIdentifier},
+		{"abstract file identifier", 739, 776, fileIdentifierCharacters, p.AbstractFileIdentifier},
+		{"bibliographic file identifier", 776, 813, fileIdentifierCharacters, p.BibliographicFileIdentifier},
+	}
+}
+
+// UpdateVolumeMetadata changes the descriptive fields of an existing image in place,
+// without rewriting anything else. The fields are changed in the Primary Volume Descriptor
+// and in the Joliet Supplementary Volume Descriptor, if there is one.
+//
+// The values must fit into the fixed width of the fields and consist of the characters
+// ECMA-119 allows in them: d-characters (A-Z, 0-9 and _) for the volume and volume set identifiers,
+// d-characters and the separators . and ; for the file identifiers and a-characters for the others.
+// Nothing is written if any of the values is invalid.
+func UpdateVolumeMetadata(rw ReadWriterAt, patch VolumeMetadataPatch) error {
+	var primary, joliet []int64
+
+	buffer := make([]byte, sectorSize)
+	for sector := int64(16); ; sector++ {
+		if sector-16 >= maxVolumeDescriptors {
+			return fmt.Errorf("no volume descriptor set terminator within %d sectors", maxVolumeDescriptors)
+		}
+
+		offset := sector * int64(sectorSize)
+		if _, err := rw.ReadAt(buffer, offset); err != nil {
+			return fmt.Errorf("reading volume descriptor at sector %d: %w", sector, err)
+		}
+		if !bytes.Equal(buffer[1:6], standardIdentifierBytes[:]) {
+			return fmt.Errorf("sector %d is not a volume descriptor", sector)
+		}
+
+		switch buffer[0] {
+		case volumeTypePrimary:
+			primary = append(primary, offset)
+		case volumeTypeSupplementary:
+			if isJolietDescriptor(buffer) {
+				joliet = append(joliet, offset)
+			}
+		}
+		if buffer[0] == volumeTypeTerminator {
+			break
+		}
+	}
+
+	if len(primary) == 0 {
+		return fmt.Errorf("no primary volume descriptor found")
+	}
+
+	// encode everything before writing, so that an invalid value doesn't leave the image half updated
+	type fieldWrite struct {
+		offset int64
+		data   []byte
+	}
+	var writes []fieldWrite
+
+	for _, field := range patch.fields() {
+		if field.value == nil {
+			continue
+		}
+
+		data, err := field.encode(*field.value)
+		if err != nil {
+			return err
+		}
+		for _, offset := range primary {
+			writes = append(writes, fieldWrite{offset + int64(field.start), data})
+		}
+
+		if len(joliet) == 0 {
+			continue
+		}
+		data, err = field.encodeJoliet(*field.value)
+		if err != nil {
+			return err
+		}
+		for _, offset := range joliet {
+			writes = append(writes, fieldWrite{offset + int64(field.start), data})
+		}
+	}
+
+	for _, w := range writes {
+		if _, err := rw.WriteAt(w.data, w.offset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encode returns the value as it is recorded in the Primary Volume Descriptor, padded with spaces
+func (f *volumeMetadataField) encode(value string) ([]byte, error) {
+	if len(value) > f.end-f.start {
+		return nil, fmt.Errorf("the %s %q is longer than %d characters", f.name, value, f.end-f.start)
+	}
+	for _, r := range value {
+		if !strings.ContainsRune(f.characters, r) {
+			return nil, fmt.Errorf("the %s %q contains %q, which is not allowed by ECMA-119", f.name, value, r)
+		}
+	}
+
+	return MarshalString(value, f.end-f.start), nil
+}
+
+// encodeJoliet returns the value as it is recorded in a Joliet descriptor: UCS-2 big endian, padded with spaces
+func (f *volumeMetadataField) encodeJoliet(value string) ([]byte, error) {
+	var units []uint16
+	for _, r := range value {
+		if r < 0x20 || r > 0xFFFF || utf16.IsSurrogate(r) {
+			return nil, fmt.Errorf("the %s %q contains %q, which can't be recorded in a Joliet descriptor", f.name, value, r)
+		}
+		units = append(units, uint16(r))
+	}
+	if 2*len(units) > f.end-f.start {
+		return nil, fmt.Errorf("the %s %q is longer than the %d characters of the Joliet descriptor", f.name, value, (f.end-f.start)/2)
+	}
+
+	return marshalJolietString(units, f.end-f.start), nil
+}
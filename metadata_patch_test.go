@@ -0,0 +1,127 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+func TestUpdateVolumeMetadata(t *testing.T) {
+	original, err := os.ReadFile("fixtures/test.iso")
+	require.NoError(t, err)
+
+	imagePath := path.Join(t.TempDir(), "test.iso")
+	require.NoError(t, os.WriteFile(imagePath, original, 0644))
+
+	f, err := os.OpenFile(imagePath, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	require.NoError(t, UpdateVolumeMetadata(f, VolumeMetadataPatch{
+		VolumeIdentifier:        stringPointer("NEW_LABEL"),
+		PublisherIdentifier:     stringPointer("SOMEONE ELSE"),
+		CopyrightFileIdentifier: stringPointer("COPYING.TXT;1"),
+	}))
+
+	img, err := OpenImage(f)
+	require.NoError(t, err)
+	label, err := img.Label()
+	require.NoError(t, err)
+	assert.Equal(t, "NEW_LABEL", label)
+
+	pvd, err := img.primaryVolume()
+	require.NoError(t, err)
+	assert.Equal(t, "SOMEONE ELSE", pvd.PublisherIdentifier)
+	assert.Equal(t, "COPYING.TXT;1", pvd.CopyrightFileIdentifier)
+	assert.Equal(t, "test-volset-id", pvd.VolumeSetIdentifier)
+
+	// only the three fields have changed
+	patched, err := os.ReadFile(imagePath)
+	require.NoError(t, err)
+	pvdOffset := 16 * int(sectorSize)
+	for _, field := range [][2]int{{40, 72}, {318, 446}, {702, 739}} {
+		copy(patched[pvdOffset+field[0]:pvdOffset+field[1]], original[pvdOffset+field[0]:pvdOffset+field[1]])
+	}
+	assert.True(t, bytes.Equal(original, patched))
+
+	// the files are still readable
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	assert.NotEmpty(t, children)
+}
+
+func TestUpdateVolumeMetadataInvalid(t *testing.T) {
+	original, err := os.ReadFile("fixtures/test.iso")
+	require.NoError(t, err)
+
+	for patch, expected := range map[*VolumeMetadataPatch]string{
+		{VolumeIdentifier: stringPointer("lowercase")}:                          `the volume identifier "lowercase" contains 'l', which is not allowed by ECMA-119`,
+		{VolumeIdentifier: stringPointer("A_VOLUME_IDENTIFIER_LONGER_THAN_32")}: `the volume identifier "A_VOLUME_IDENTIFIER_LONGER_THAN_32" is longer than 32 characters`,
+		// nothing is written if a later field is invalid
+		{SystemIdentifier: stringPointer("LINUX"), DataPreparerIdentifier: stringPointer("TAB\t")}: `the data preparer identifier "TAB\t" contains '\t', which is not allowed by ECMA-119`,
+	} {
+		image := &memoryImage{data: append([]byte(nil), original...)}
+		assert.EqualError(t, UpdateVolumeMetadata(image, *patch), expected)
+		assert.True(t, bytes.Equal(original, image.data))
+	}
+
+	assert.Error(t, UpdateVolumeMetadata(&memoryImage{data: make([]byte, 20*sectorSize)}, VolumeMetadataPatch{}))
+}
+
+func TestUpdateVolumeMetadataJoliet(t *testing.T) {
+	image := &memoryImage{data: make([]byte, 19*sectorSize)}
+	for sector, vdType := range map[int]byte{16: volumeTypePrimary, 17: volumeTypeSupplementary, 18: volumeTypeTerminator} {
+		vd := image.data[sector*int(sectorSize):]
+		vd[0] = vdType
+		copy(vd[1:6], standardIdentifier)
+		vd[6] = 1
+	}
+	copy(image.data[17*int(sectorSize)+88:], "%/E")
+
+	require.NoError(t, UpdateVolumeMetadata(image, VolumeMetadataPatch{
+		VolumeIdentifier:       stringPointer("LABEL"),
+		AbstractFileIdentifier: stringPointer("ABSTRACT.TXT;1"),
+	}))
+
+	pvd := image.data[16*int(sectorSize):]
+	assert.Equal(t, "LABEL"+string(bytes.Repeat([]byte{' '}, 27)), string(pvd[40:72]))
+
+	svd := image.data[17*int(sectorSize):]
+	expected := []byte{0, 'L', 0, 'A', 0, 'B', 0, 'E', 0, 'L'}
+	for len(expected) < 32 {
+		expected = append(expected, 0, ' ')
+	}
+	assert.Equal(t, expected, svd[40:72])
+	assert.Equal(t, byte(0), svd[775])
+	assert.Equal(t, []byte{0, 'A', 0, 'B'}, svd[739:743])
+
+	// the Joliet volume identifier holds only 16 characters
+	err := UpdateVolumeMetadata(image, VolumeMetadataPatch{VolumeIdentifier: stringPointer("SEVENTEEN_LETTERS")})
+	assert.EqualError(t, err, `the volume identifier "SEVENTEEN_LETTERS" is longer than the 16 characters of the Joliet descriptor`)
+}
+
+// memoryImage is an image held in memory which can be modified in place
+type memoryImage struct {
+	data []byte
+}
+
+func (m *memoryImage) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(m.data).ReadAt(p, off)
+}
+
+func (m *memoryImage) WriteAt(p []byte, off int64) (int, error) {
+	return copy(m.data[off:], p), nil
+}
@@ -0,0 +1,88 @@
+package iso9660
+
+import (
+	"errors"
+	"io"
+)
+
+// errMmapUnsupported is returned by mmapFile on platforms without memory mapping
+var errMmapUnsupported = errors.New("memory mapping is not supported on this platform")
+
+// mappedFile is an io.ReaderAt over a read-only memory mapping of a file
+type mappedFile struct {
+	data []byte
+}
+
+func (m *mappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("mappedFile.ReadAt: negative offset")
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Size returns the length of the mapping, which readerSize uses as the size of the image
+func (m *mappedFile) Size() int64 {
+	return int64(len(m.data))
+}
+
+// writeRange writes size bytes at off straight from the mapping to w
+func (m *mappedFile) writeRange(w io.Writer, off, size int64) (int64, error) {
+	if off < 0 || off > int64(len(m.data)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	data := m.data[off:]
+	short := int64(len(data)) < size
+	if !short {
+		data = data[:size]
+	}
+
+	n, err := w.Write(data)
+	switch {
+	case err != nil:
+		return int64(n), err
+	case n < len(data):
+		return int64(n), io.ErrShortWrite
+	case short:
+		return int64(n), io.ErrUnexpectedEOF
+	}
+	return int64(n), nil
+}
+
+func (m *mappedFile) Close() error {
+	data := m.data
+	m.data = nil
+	return munmap(data)
+}
+
+// OpenImageMmap opens the image file at the given path for reading through a read-only memory mapping,
+// which saves a system call for every read and leaves caching to the operating system.
+// Where the file cannot be mapped, e.g. on platforms other than Linux and macOS or on some file systems,
+// it is read with normal file I/O instead.
+// Close must be called once the Image and the Files read from it are no longer used.
+func OpenImageMmap(path string) (*Image, error) {
+	return OpenImageMmapWithOptions(path, ReaderOptions{})
+}
+
+// OpenImageMmapWithOptions opens the image file at the given path like OpenImageMmap, with the given options
+func OpenImageMmapWithOptions(path string, opts ReaderOptions) (*Image, error) {
+	return OpenImageFile(path, FileOptions{ReaderOptions: opts, Mode: ReadMmap})
+}
+
+// Close releases the memory mapping or the file opened by OpenImageMmap or OpenImageFile.
+// The Image and its Files must not be used afterwards.
+// For an Image opened with OpenImage, it does nothing, as the caller owns the io.ReaderAt.
+func (i *Image) Close() error {
+	if i.closer == nil {
+		return nil
+	}
+	err := i.closer.Close()
+	i.closer = nil
+	return err
+}
@@ -0,0 +1,67 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenImageMmap(t *testing.T) {
+	f, err := os.Open("fixtures/test_rockridge.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+	img, err := OpenImage(f)
+	require.NoError(t, err)
+	var expected bytes.Buffer
+	require.NoError(t, img.ExportManifest(&expected, ManifestOptions{Lines: true, Hash: true}))
+	assert.NoError(t, img.Close())
+
+	mapped, err := OpenImageMmapWithOptions("fixtures/test_rockridge.iso", ReaderOptions{CacheSize: 1 << 20})
+	require.NoError(t, err)
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+		assert.IsType(t, &mappedFile{}, mapped.closer)
+	}
+	var manifest bytes.Buffer
+	require.NoError(t, mapped.ExportManifest(&manifest, ManifestOptions{Lines: true, Hash: true}))
+	assert.Equal(t, expected.String(), manifest.String())
+	assert.NoError(t, mapped.Close())
+	assert.NoError(t, mapped.Close())
+
+	_, err = OpenImageMmap("fixtures/does-not-exist.iso")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// an empty file cannot be mapped and is found empty by the fallback
+	empty := filepath.Join(t.TempDir(), "empty.iso")
+	require.NoError(t, os.WriteFile(empty, nil, 0644))
+	_, err = OpenImageMmap(empty)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestMappedFileReadAt(t *testing.T) {
+	m := &mappedFile{data: []byte("0123456789")}
+
+	buffer := make([]byte, 4)
+	n, err := m.ReadAt(buffer, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "2345", string(buffer[:n]))
+
+	n, err = m.ReadAt(buffer, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "89", string(buffer[:n]))
+
+	n, err = m.ReadAt(buffer, 10)
+	assert.Equal(t, io.EOF, err)
+	assert.Zero(t, n)
+
+	_, err = m.ReadAt(buffer, -1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package iso9660
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps size bytes of the file read-only
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	if size == 0 {
+		// an empty mapping is invalid, the file is read normally and found empty
+		return nil, syscall.EINVAL
+	}
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	if data == nil {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
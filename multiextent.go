@@ -0,0 +1,150 @@
+package iso9660
+
+import (
+	"io"
+	"math"
+)
+
+// maxExtentLength is the length of the extents a file is split into at interchange level 3
+// if it doesn't fit into one, the largest multiple of the sector size a directory record can describe.
+// The extents of the file follow each other, so its data is contiguous.
+var maxExtentLength = int64(math.MaxUint32) &^ int64(sectorSize-1)
+
+// multiExtentReaderAt reads the extents of a file recorded in several directory records (ECMA-119 6.5.1)
+// as one continuous stream of data
+type multiExtentReaderAt struct {
+	ra io.ReaderAt
+	// offsets are the positions of the extents in the image, lengths the number of bytes in them
+	offsets []int64
+	lengths []int64
+}
+
+func (m *multiExtentReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	start := int64(0)
+	for i, length := range m.lengths {
+		if len(p) == 0 {
+			break
+		}
+		if off >= start+length {
+			start += length
+			continue
+		}
+
+		within := off - start
+		chunk := p
+		if int64(len(chunk)) > length-within {
+			chunk = chunk[:length-within]
+		}
+		n, err := m.ra.ReadAt(chunk, m.offsets[i]+within)
+		read += n
+		if err != nil && !(err == io.EOF && n == len(chunk)) {
+			return read, err
+		}
+		p = p[n:]
+		off += int64(n)
+		start += length
+	}
+	if len(p) > 0 {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// records returns the directory records of the file, more than one if it has multiple extents
+func (f *File) records() []*DirectoryEntry {
+	return append([]*DirectoryEntry{f.de}, f.sections...)
+}
+
+// dataLength returns the number of bytes in the extents of the file
+func (f *File) dataLength() int64 {
+	length := int64(f.de.ExtentLength)
+	for _, s := range f.sections {
+		length += int64(s.ExtentLength)
+	}
+	return length
+}
+
+// dataReaderAt returns a reader of the file's extents, which are joined if there are more than one
+func (f *File) dataReaderAt(ra io.ReaderAt) io.ReaderAt {
+	if len(f.sections) == 0 {
+		return io.NewSectionReader(ra, f.de.dataOffset(f.logicalBlockSize()), int64(f.de.ExtentLength))
+	}
+
+	m := &multiExtentReaderAt{ra: ra}
+	for _, de := range f.records() {
+		m.offsets = append(m.offsets, de.dataOffset(f.logicalBlockSize()))
+		m.lengths = append(m.lengths, int64(de.ExtentLength))
+	}
+	return m
+}
+
+// contiguousLocation returns the first logical block of the file's data if its extents follow each other
+// without gaps or Extended Attribute Records, 0 otherwise
+func (f *File) contiguousLocation() uint32 {
+	records := f.records()
+	blockSize := uint32(f.logicalBlockSize())
+	next := f.de.ExtentLocation
+	for i, de := range records {
+		if de.ExtentLocation != next || de.ExtendedAtributeRecordLength != 0 || (i < len(records)-1 && de.ExtentLength%blockSize != 0) {
+			return 0
+		}
+		next += int32(de.ExtentLength / blockSize)
+	}
+	return uint32(f.de.ExtentLocation)
+}
+
+// IsMultiExtent reports whether the file's data is recorded in more than one extent
+// because it is larger than a directory record can describe, see SetInterchangeLevel
+func (f *File) IsMultiExtent() bool {
+	return len(f.sections) > 0
+}
+
+// fileExtents splits the data of a file of the given length starting at location into extents,
+// which are single unless the file is larger than maxExtentLength at interchange level 3
+func (wc *writeContext) fileExtents(location uint32, length int64) (locations []uint32, lengths []uint32) {
+	if wc.interchangeLevel != 3 || length <= maxExtentLength {
+		return []uint32{location}, []uint32{uint32(length)}
+	}
+	for length > 0 {
+		l := length
+		if l > maxExtentLength {
+			l = maxExtentLength
+		}
+		locations = append(locations, location)
+		lengths = append(lengths, uint32(l))
+		location += uint32(l / int64(sectorSize))
+		length -= l
+	}
+	return locations, lengths
+}
+
+// directoryEntries creates the records describing the node, one per extent of a large file
+// with the multi-extent flag set on all but the last
+func (wc *writeContext) directoryEntries(n *layoutNode, identifier string) []*DirectoryEntry {
+	de := wc.directoryEntry(n, identifier)
+	if n.entry.isDir() {
+		return []*DirectoryEntry{de}
+	}
+
+	locations, lengths := wc.fileExtents(n.location, n.length)
+	records := make([]*DirectoryEntry, len(locations))
+	for i := range locations {
+		// the last record is de itself, which saves a copy for the usual single extent
+		record := de
+		if i < len(locations)-1 {
+			multi := *de
+			multi.FileFlags |= dirFlagMultiExtent
+			record = &multi
+		}
+		record.ExtentLocation = int32(wc.blocks(locations[i]))
+		record.ExtentLength = lengths[i]
+		// the Extended Attribute Record is part of the extent, but not of the data length
+		if n.extendedAttributeSectors > 0 {
+			record.ExtendedAtributeRecordLength = byte(wc.blocks(n.extendedAttributeSectors))
+			record.ExtentLength -= n.extendedAttributeSectors * sectorSize
+		}
+		records[i] = record
+	}
+	return records
+}
@@ -0,0 +1,105 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withMaxExtentLength lowers the length of the extents large files are split into for the duration of a test
+func withMaxExtentLength(t *testing.T, length int64) {
+	previous := maxExtentLength
+	maxExtentLength = length
+	t.Cleanup(func() { maxExtentLength = previous })
+}
+
+func TestWriterMultiExtentFiles(t *testing.T) {
+	withMaxExtentLength(t, 2*int64(sectorSize))
+
+	data := make([]byte, 5*sectorSize+100)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	w, err := NewWriterWithOptions(WriterOptions{InterchangeLevel: 3, EnableRockRidge: true, Joliet: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(bytes.NewReader(data), "large.bin"))
+	require.NoError(t, w.AddFile(bytes.NewReader(data[:2*sectorSize]), "exact.bin"))
+	require.NoError(t, w.AddFile(bytes.NewReader([]byte("small")), "small.txt"))
+
+	img := remaster(t, w)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	all, err := root.GetAllChildren()
+	require.NoError(t, err)
+	// the records of the sections are joined into one entry
+	assert.Len(t, all, 2+3)
+	for _, c := range all {
+		if c.Name() == "large.bin" {
+			assert.Len(t, c.records(), 3)
+		}
+	}
+
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+	sizes := map[string]int64{}
+	for _, c := range children {
+		sizes[c.Name()] = c.Size()
+		assert.Equal(t, c.Name() == "large.bin", c.IsMultiExtent(), c.Name())
+	}
+	assert.Equal(t, map[string]int64{"exact.bin": 2 * int64(sectorSize), "large.bin": int64(len(data)), "small.txt": 5}, sizes)
+
+	large := children[1]
+	require.Equal(t, "large.bin", large.Name())
+	read, err := io.ReadAll(large.Reader())
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	// reads at random cross the extents
+	r, err := large.OpenReader()
+	require.NoError(t, err)
+	chunk := make([]byte, sectorSize)
+	_, err = r.(io.ReaderAt).ReadAt(chunk, int64(sectorSize)+int64(sectorSize)/2)
+	require.NoError(t, err)
+	assert.Equal(t, data[sectorSize+sectorSize/2:2*sectorSize+sectorSize/2], chunk)
+
+	// the Joliet records are split as well
+	joliet, err := OpenImageWithOptions(img.ra, ReaderOptions{PreferJoliet: true})
+	require.NoError(t, err)
+	read, err = joliet.FS().(interface{ ReadFile(string) ([]byte, error) }).ReadFile("large.bin")
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	// re-mastering keeps the whole file
+	w2, err := NewWriterFromImage(img)
+	require.NoError(t, err)
+	defer w2.Cleanup() // nolint: errcheck
+	require.NoError(t, w2.SetInterchangeLevel(3))
+	snapshot := snapshotImage(t, remaster(t, w2))
+	assert.Equal(t, int64(len(data)), snapshot["/large.bin"].Size)
+}
+
+func TestWriterMultiExtentRequiresLevel3(t *testing.T) {
+	withMaxExtentLength(t, 2*int64(sectorSize))
+
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(bytes.NewReader(make([]byte, 3*sectorSize)), "large.bin"))
+
+	// below level 3, files are only split if they don't fit into a directory record
+	root, err := remaster(t, w).RootDir()
+	require.NoError(t, err)
+	children, err := root.GetAllChildren()
+	require.NoError(t, err)
+	assert.Len(t, children, 3)
+	assert.False(t, children[2].IsMultiExtent())
+}
@@ -0,0 +1,347 @@
+package iso9660
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NameTranslation selects how the names of the staged entries become the identifiers
+// of the primary directory hierarchy, see SetNameTranslation
+type NameTranslation int
+
+const (
+	// NamesMangle converts the names like mkisofs does: uppercase, with the characters outside of the d-characters
+	// replaced by underscores, shortened to the limits of the interchange level and keeping the last extension only.
+	// Identifiers which collide get a number at the end of their name.
+	NamesMangle NameTranslation = iota
+	// NamesStrict fails with ErrUntranslatableName instead of changing a name beyond uppercasing it,
+	// and for names which collide once uppercase
+	NamesStrict
+	// NamesRelaxed keeps the names in their case and with all their dots, and only replaces the characters
+	// which aren't printable ASCII, along with the ";" separator of the version, by underscores,
+	// like mkisofs -relaxed-filenames -allow-lowercase -allow-multidot. The lengths are still limited
+	// by the interchange level. Identifiers which collide get a "~" and a number at the end of their name.
+	// Such identifiers don't comply with ECMA-119, but Linux and Windows read them.
+	NamesRelaxed
+)
+
+// ErrUntranslatableName is returned by WriteTo with NamesStrict for names which aren't valid identifiers
+var ErrUntranslatableName = errors.New("the name is not a valid ISO 9660 identifier")
+
+// nameLimits are the maximum lengths of the parts of primary identifiers at an interchange level
+type nameLimits struct {
+	directory int
+	// fileName limits the name part of a file identifier, 0 means it's only limited by fileIdentifier
+	fileName int
+	// fileIdentifier limits the whole file identifier, including the separators and version
+	fileIdentifier int
+	extension      int
+}
+
+var (
+	// ECMA-119 10.1
+	level1Limits = nameLimits{directory: 8, fileName: 8, fileIdentifier: 8 + 1 + 3 + 2, extension: 3}
+	// ECMA-119 10.2, with enough characters for the `.ignition` extension
+	level2Limits = nameLimits{directory: primaryVolumeDirectoryIdentifierMaxLength, fileIdentifier: primaryVolumeFileIdentifierMaxLength, extension: 8}
+)
+
+// limitsForLevel returns the limits of an interchange level. Level 3 has the limits of level 2.
+func limitsForLevel(level int) nameLimits {
+	if level == 1 {
+		return level1Limits
+	}
+	return level2Limits
+}
+
+// mangledName is a primary identifier split into the parts that can be shortened to make it unique
+type mangledName struct {
+	base      string
+	extension string
+	maxBase   int
+	isDir     bool
+	// omitVersion drops the version from file identifiers
+	omitVersion bool
+	// version is the version of a file identifier, 1 if it is 0
+	version uint16
+	// tailSeparator goes before the number telling apart colliding identifiers
+	tailSeparator string
+}
+
+// mangleName converts a name to a primary identifier like mkisofs does:
+// uppercase, with the characters outside of the d-characters replaced by underscores and truncated as needed.
+// File identifiers keep their last extension and get the version 1.
+func mangleName(input string, isDir bool, limits nameLimits) mangledName {
+	return translateName(input, isDir, limits, mangleDString)
+}
+
+// relaxedName converts a name to an identifier of NamesRelaxed
+func relaxedName(input string, isDir bool, limits nameLimits) mangledName {
+	m := translateName(input, isDir, limits, relaxedString)
+	m.tailSeparator = "~"
+	return m
+}
+
+// strictName converts a name to an identifier of NamesStrict, failing if it would have to change
+func strictName(input string, isDir bool, limits nameLimits) (mangledName, error) {
+	m := mangleName(input, isDir, limits)
+	translated := m.base
+	if !isDir && strings.Contains(input, ".") {
+		translated += "." + m.extension
+	}
+	if translated != strings.ToUpper(input) {
+		return m, fmt.Errorf("%w: %q would become %q", ErrUntranslatableName, input, m.identifier())
+	}
+	return m, nil
+}
+
+// translateName splits a name into the parts of an identifier, each converted by translate to a number of characters
+func translateName(input string, isDir bool, limits nameLimits, translate func(string, int) string) mangledName {
+	if isDir {
+		return mangledName{
+			base:    translate(input, limits.directory),
+			maxBase: limits.directory,
+			isDir:   true,
+		}
+	}
+
+	var name, extension string
+	if i := strings.LastIndex(input, "."); i >= 0 {
+		name, extension = input[:i], input[i+1:]
+	} else {
+		name = input
+	}
+	extension = translate(extension, limits.extension)
+
+	// leave room for the "." separator and the ";1" version
+	maxBase := limits.fileIdentifier - 3 - len(extension)
+	if limits.fileName > 0 && limits.fileName < maxBase {
+		maxBase = limits.fileName
+	}
+
+	return mangledName{
+		base:      translate(name, maxBase),
+		extension: extension,
+		maxBase:   maxBase,
+	}
+}
+
+// identifier returns the ECMA-119 7.5 or 7.6 identifier
+func (m mangledName) identifier() string {
+	if m.isDir {
+		return m.base
+	}
+	if m.omitVersion {
+		return m.base + "." + m.extension
+	}
+	if m.version > 1 {
+		return m.base + "." + m.extension + ";" + strconv.Itoa(int(m.version))
+	}
+	return m.base + "." + m.extension + ";1"
+}
+
+// withoutVersion removes the version from a file identifier, e.g. "README.TXT;1" becomes "README.TXT"
+func withoutVersion(identifier string) string {
+	if i := strings.LastIndexByte(identifier, ';'); i >= 0 {
+		return identifier[:i]
+	}
+	return identifier
+}
+
+// withTail replaces the end of the name with the number, to tell apart identifiers which collide.
+// It returns false if the number doesn't fit.
+func (m mangledName) withTail(n int) (mangledName, bool) {
+	tail := m.tailSeparator + strconv.Itoa(n)
+	if len(tail) > m.maxBase {
+		return m, false
+	}
+
+	base := m.base
+	if len(base)+len(tail) > m.maxBase {
+		base = base[:m.maxBase-len(tail)]
+	}
+	m.base = base + tail
+	return m, true
+}
+
+// See ECMA-119 7.5
+func mangleFileName(input string) string {
+	return mangleName(input, false, level2Limits).identifier()
+}
+
+// See ECMA-119 7.6
+func mangleDirectoryName(input string) string {
+	return mangleName(input, true, level2Limits).identifier()
+}
+
+func mangleDString(input string, maxCharacters int) string {
+	input = strings.ToUpper(input)
+
+	var mangledString strings.Builder
+	for i := 0; i < len(input) && i < maxCharacters; i++ {
+		if strings.IndexByte(dCharacters, input[i]) >= 0 {
+			mangledString.WriteByte(input[i])
+		} else {
+			mangledString.WriteByte('_')
+		}
+	}
+
+	return mangledString.String()
+}
+
+// relaxedString replaces the characters of NamesRelaxed which cannot be recorded by underscores
+func relaxedString(input string, maxCharacters int) string {
+	var relaxed strings.Builder
+	for i := 0; i < len(input) && i < maxCharacters; i++ {
+		if c := input[i]; c < 0x20 || c > 0x7E || c == ';' || c == '/' {
+			relaxed.WriteByte('_')
+		} else {
+			relaxed.WriteByte(c)
+		}
+	}
+	return relaxed.String()
+}
+
+// identifierSet tracks the identifiers used within a directory and which entries they belong to
+type identifierSet map[string]*stagedEntry
+
+// add gives the entry a unique identifier, based on the mangled name
+func (set identifierSet) add(e *stagedEntry, m mangledName) (string, error) {
+	identifier := m.identifier()
+	for n := 1; set[identifier] != nil; n++ {
+		candidate, ok := m.withTail(n)
+		if !ok {
+			return "", fmt.Errorf("cannot find a unique identifier for %s, it collides with %s", e.path(), set[identifier].path())
+		}
+		identifier = candidate.identifier()
+	}
+
+	set[identifier] = e
+	return identifier, nil
+}
+
+// assignIdentifiers sets the primary identifiers of a directory's children.
+// Identifiers carried over from a source image are kept, apart from their version if it's omitted.
+// The others are mangled in the order of the original names, so that the result is stable for the same set of names.
+func (wc *writeContext) assignIdentifiers(nodes []*layoutNode) error {
+	set := make(identifierSet)
+
+	for _, n := range nodes {
+		identifier := n.entry.info().identifier
+		if identifier == "" {
+			continue
+		}
+		if wc.omitVersion && !n.entry.isDir() {
+			identifier = withoutVersion(identifier)
+		}
+		if existing := set[identifier]; existing != nil {
+			return fmt.Errorf("%s and %s have the same identifier %q", existing.path(), n.entry.path(), identifier)
+		}
+		set[identifier] = n.entry
+		n.identifier = identifier
+	}
+
+	limits := limitsForLevel(wc.interchangeLevel)
+	for _, n := range nodes {
+		if n.entry.info().identifier != "" {
+			continue
+		}
+		name := n.entry.name
+		var version uint16
+		if wc.fileVersions && !n.entry.isDir() {
+			name, version = splitVersion(name)
+		}
+		if wc.transliterate {
+			name = transliterate(name)
+		}
+		var m mangledName
+		switch wc.names {
+		case NamesStrict:
+			var err error
+			if m, err = strictName(name, n.entry.isDir(), limits); err != nil {
+				return fmt.Errorf("%s: %w", n.entry.path(), err)
+			}
+			m.omitVersion, m.version = wc.omitVersion, version
+			if existing := set[m.identifier()]; existing != nil {
+				return fmt.Errorf("%w: %s and %s have the same identifier %q", ErrUntranslatableName, existing.path(), n.entry.path(), m.identifier())
+			}
+		case NamesRelaxed:
+			m = relaxedName(name, n.entry.isDir(), limits)
+		default:
+			m = mangleName(name, n.entry.isDir(), limits)
+		}
+		m.omitVersion, m.version = wc.omitVersion, version
+		identifier, err := set.add(n.entry, m)
+		if err != nil {
+			return err
+		}
+		n.identifier = identifier
+	}
+
+	return nil
+}
+
+// isoPath returns the path of the node in the primary directory hierarchy
+func (n *layoutNode) isoPath() string {
+	if n.parent == n {
+		return ""
+	}
+	return n.parent.isoPath() + "/" + n.identifier
+}
+
+// nameMap maps the paths of the staged entries to their paths in the primary directory hierarchy
+func (wc *writeContext) nameMap() map[string]string {
+	names := make(map[string]string)
+	for _, dir := range wc.directories {
+		for _, c := range dir.children {
+			// relocated directories are listed at the place where their records are
+			if c.childLink != nil || c.generated {
+				continue
+			}
+			names[c.entry.path()] = c.isoPath()
+		}
+	}
+	return names
+}
+
+// SetInterchangeLevel selects how identifiers of the primary directory hierarchy are shortened.
+// Level 1 allows 8 characters for names and 3 for extensions, level 2 up to 30 characters (ECMA-119 10).
+// Level 3 has the identifiers of level 2, but splits files larger than 4 GiB into several extents,
+// each with a directory record of its own. The default is level 2, which cannot hold such files.
+func (iw *ImageWriter) SetInterchangeLevel(level int) error {
+	if level < 1 || level > 3 {
+		return fmt.Errorf("unsupported interchange level %d", level)
+	}
+
+	iw.interchangeLevel = level
+	return nil
+}
+
+// SetNameTranslation selects how the names of the staged entries are converted to the identifiers
+// of the primary directory hierarchy. The default is NamesMangle. Identifiers carried over from
+// a source image are kept as they are.
+func (iw *ImageWriter) SetNameTranslation(translation NameTranslation) {
+	iw.names = translation
+}
+
+// SetOmitVersionSuffix selects whether the ";1" version is left out of file identifiers
+// in the primary directory hierarchy, for firmware which matches the identifiers exactly.
+// Identifiers without a version are technically out of spec for ECMA-119 7.5.1, so it is disabled by default.
+func (iw *ImageWriter) SetOmitVersionSuffix(enabled bool) {
+	iw.omitVersion = enabled
+}
+
+// NameMap returns the paths of all staged entries mapped to their paths in the primary directory hierarchy,
+// using the identifiers that WriteTo will write. For example, "/docs/README.md" could become "/DOCS/README.MD;1".
+// The mapping is deterministic for a given set of staged entries and options.
+func (iw *ImageWriter) NameMap() (map[string]string, error) {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+
+	wc := iw.newWriteContext(iw.now())
+	if err := wc.buildTree(iw.rootEntry()); err != nil {
+		return nil, err
+	}
+	return wc.nameMap(), nil
+}
@@ -0,0 +1,222 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMangleName(t *testing.T) {
+	tests := []struct {
+		input    string
+		isDir    bool
+		limits   nameLimits
+		expected string
+	}{
+		{"README.md", false, level2Limits, "README.MD;1"},
+		{"my-config-file.yaml", false, level2Limits, "MY_CONFIG_FILE.YAML;1"},
+		{"archive.tar.gz", false, level2Limits, "ARCHIVE_TAR.GZ;1"},
+		{"noext", false, level2Limits, "NOEXT.;1"},
+		{"config.ignition", false, level2Limits, "CONFIG.IGNITION;1"},
+		{"my-config-file.yaml", false, level1Limits, "MY_CONFI.YAM;1"},
+		{"some.directory", true, level2Limits, "SOME_DIRECTORY"},
+		{"some.directory", true, level1Limits, "SOME_DIR"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, mangleName(tt.input, tt.isDir, tt.limits).identifier(), tt.input)
+	}
+}
+
+func TestIdentifierSetCollisions(t *testing.T) {
+	root := newStagedDirectory("", time.Time{})
+	set := make(identifierSet)
+
+	for i, expected := range []string{"MY_CONFI.TXT;1", "MY_CONF1.TXT;1", "MY_CONF2.TXT;1"} {
+		e := newStagedDirectory("entry", time.Time{})
+		e.parent = root
+		identifier, err := set.add(e, mangleName("my-config-file.txt", false, level1Limits))
+		require.NoError(t, err)
+		assert.Equal(t, expected, identifier, i)
+	}
+
+	// a single character leaves room for tails up to 9
+	first := newStagedDirectory("a", time.Time{})
+	first.parent = root
+	m := mangledName{base: "A", maxBase: 1, isDir: true}
+	for i := 0; i < 10; i++ {
+		_, err := set.add(first, m)
+		require.NoError(t, err)
+	}
+	second := newStagedDirectory("a-second", time.Time{})
+	second.parent = root
+	_, err := set.add(second, m)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/a-second")
+	assert.Contains(t, err.Error(), "/a")
+}
+
+func TestWriterNameMap(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	require.NoError(t, w.AddFile(strings.NewReader("a"), "README.md"))
+	require.NoError(t, w.AddFile(strings.NewReader("b"), "etc/my-config-file.yaml"))
+	require.NoError(t, w.AddFile(strings.NewReader("c"), "etc/my-config-file.yml"))
+	require.NoError(t, w.AddFile(strings.NewReader("d"), "etc/my-config-file-a.txt"))
+	require.NoError(t, w.AddFile(strings.NewReader("e"), "etc/my-config-file-b.txt"))
+
+	names, err := w.NameMap()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/README.md":                "/README.MD;1",
+		"/etc":                      "/ETC",
+		"/etc/my-config-file.yaml":  "/ETC/MY_CONFIG_FILE.YAML;1",
+		"/etc/my-config-file.yml":   "/ETC/MY_CONFIG_FILE.YML;1",
+		"/etc/my-config-file-a.txt": "/ETC/MY_CONFIG_FILE_A.TXT;1",
+		"/etc/my-config-file-b.txt": "/ETC/MY_CONFIG_FILE_B.TXT;1",
+	}, names)
+
+	require.NoError(t, w.SetInterchangeLevel(1))
+	names, err = w.NameMap()
+	require.NoError(t, err)
+	assert.Equal(t, "/ETC/MY_CONFI.YAM;1", names["/etc/my-config-file.yaml"])
+	assert.Equal(t, "/ETC/MY_CONFI.YML;1", names["/etc/my-config-file.yml"])
+	// the names sort alphabetically, so the first one keeps its identifier
+	assert.Equal(t, "/ETC/MY_CONFI.TXT;1", names["/etc/my-config-file-a.txt"])
+	assert.Equal(t, "/ETC/MY_CONF1.TXT;1", names["/etc/my-config-file-b.txt"])
+
+	// the mapping doesn't depend on the order the files were staged in
+	w2, err := NewWriter()
+	require.NoError(t, err)
+	defer w2.Cleanup() // nolint: errcheck
+	require.NoError(t, w2.SetInterchangeLevel(1))
+	require.NoError(t, w2.AddFile(strings.NewReader("e"), "etc/my-config-file-b.txt"))
+	require.NoError(t, w2.AddFile(strings.NewReader("d"), "etc/my-config-file-a.txt"))
+	names2, err := w2.NameMap()
+	require.NoError(t, err)
+	assert.Equal(t, names["/etc/my-config-file-a.txt"], names2["/etc/my-config-file-a.txt"])
+	assert.Equal(t, names["/etc/my-config-file-b.txt"], names2["/etc/my-config-file-b.txt"])
+
+	assert.Error(t, w.SetInterchangeLevel(4))
+}
+
+func TestWriterOmitVersionSuffix(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	require.NoError(t, w.AddFile(strings.NewReader("a"), "README.md"))
+	require.NoError(t, w.AddFile(strings.NewReader("b"), "docs/noext"))
+	w.SetTransTable(true)
+
+	identifiers := func() map[string]string {
+		var buf bytes.Buffer
+		require.NoError(t, w.WriteTo(&buf, "versions"))
+		img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+
+		result := make(map[string]string)
+		for p, f := range filesByPath(t, img) {
+			if p != "/" {
+				result[p] = f.Identifier()
+			}
+		}
+		return result
+	}
+
+	assert.Equal(t, map[string]string{
+		"/README.MD":      "README.MD;1",
+		"/DOCS":           "DOCS",
+		"/DOCS/NOEXT":     "NOEXT.;1",
+		"/DOCS/TRANS.TBL": "TRANS.TBL;1",
+		"/TRANS.TBL":      "TRANS.TBL;1",
+	}, identifiers())
+
+	w.SetOmitVersionSuffix(true)
+	assert.Equal(t, map[string]string{
+		"/README.MD":      "README.MD",
+		"/DOCS":           "DOCS",
+		"/DOCS/NOEXT":     "NOEXT.",
+		"/DOCS/TRANS.TBL": "TRANS.TBL",
+		"/TRANS.TBL":      "TRANS.TBL",
+	}, identifiers())
+
+	names, err := w.NameMap()
+	require.NoError(t, err)
+	assert.Equal(t, "/README.MD", names["/README.md"])
+	assert.Equal(t, "/DOCS/NOEXT.", names["/docs/noext"])
+}
+
+func TestWriterNameTranslation(t *testing.T) {
+	stage := func(w *ImageWriter, names ...string) {
+		for _, name := range names {
+			require.NoError(t, w.AddFile(strings.NewReader(name), name))
+		}
+	}
+
+	w, err := NewWriterWithOptions(WriterOptions{Names: NamesRelaxed})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	stage(w, "archive.tar.gz", "My Notes;draft.txt", "a-very-long-file-name-over-the-limit.txt", "a-very-long-file-name-over-the-limit.txt~", "dir.d/file")
+	names, err := w.NameMap()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/archive.tar.gz":                            "/archive.tar.gz;1",
+		"/My Notes;draft.txt":                        "/My Notes_draft.txt;1",
+		"/a-very-long-file-name-over-the-limit.txt":  "/a-very-long-file-name-ov.txt;1",
+		"/a-very-long-file-name-over-the-limit.txt~": "/a-very-long-file-name-o.txt~;1",
+		"/dir.d":      "/dir.d",
+		"/dir.d/file": "/dir.d/file.;1",
+	}, names)
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "relaxed"))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "archive.tar.gz", readPath(t, img, "/archive.tar.gz"))
+
+	// colliding identifiers get a tail after a tilde
+	collisions, err := NewWriterWithOptions(WriterOptions{Names: NamesRelaxed, InterchangeLevel: 1})
+	require.NoError(t, err)
+	defer collisions.Cleanup() // nolint: errcheck
+	stage(collisions, "longname-a.txt", "longname-b.txt")
+	names, err = collisions.NameMap()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/longname-a.txt": "/longname.txt;1", "/longname-b.txt": "/longna~1.txt;1"}, names)
+
+	for name, c := range map[string]struct {
+		level   int
+		names   []string
+		problem string
+	}{
+		"valid":      {level: 1, names: []string{"readme.txt", "BOOT", "setup.exe"}},
+		"too long":   {level: 1, names: []string{"longname-a.txt"}, problem: `"longname-a.txt" would become "LONGNAME.TXT;1"`},
+		"dots":       {level: 2, names: []string{"archive.tar.gz"}, problem: `"archive.tar.gz" would become "ARCHIVE_TAR.GZ;1"`},
+		"characters": {level: 2, names: []string{"my-file.txt"}, problem: `"my-file.txt" would become "MY_FILE.TXT;1"`},
+		"collision":  {level: 2, names: []string{"readme.txt", "README.TXT"}, problem: `have the same identifier "README.TXT;1"`},
+	} {
+		w, err := NewWriterWithOptions(WriterOptions{Names: NamesStrict, InterchangeLevel: c.level})
+		require.NoError(t, err)
+		stage(w, c.names...)
+		_, err = w.NameMap()
+		if c.problem == "" {
+			assert.NoError(t, err, name)
+		} else {
+			assert.ErrorIs(t, err, ErrUntranslatableName, name)
+			assert.ErrorContains(t, err, c.problem, name)
+		}
+		require.NoError(t, w.Cleanup())
+	}
+
+	_, err = NewWriterWithOptions(WriterOptions{Names: NamesRelaxed + 1})
+	assert.ErrorContains(t, err, "unknown name translation")
+}
@@ -0,0 +1,283 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// maxPathTableDirectories is the number of directories a path table can record,
+// as the parent directory numbers are 16 bits wide (ECMA-119 9.4.4)
+const maxPathTableDirectories = math.MaxUint16
+
+// pathTableRecord is a record of a path table as defined in ECMA-119 9.4
+type pathTableRecord struct {
+	identifier string
+	location   uint32
+	parent     uint16
+}
+
+func (r *pathTableRecord) length() int {
+	n := 8 + len(r.identifier)
+	return n + n%2 // padded to an even length
+}
+
+// marshal encodes the record with the given byte order
+func (r *pathTableRecord) marshal(order binary.ByteOrder) []byte {
+	record := make([]byte, r.length())
+	record[0] = byte(len(r.identifier))
+	order.PutUint32(record[2:6], r.location)
+	order.PutUint16(record[6:8], r.parent)
+	copy(record[8:], r.identifier)
+	return record
+}
+
+// buildPathTable lists the directories of the tree in path table order (ECMA-119 6.9.1):
+// by level, then by the number of the parent directory, then by identifier.
+// Both the L and M tables are marshaled from this single list.
+func (wc *writeContext) buildPathTable() {
+	table := []*layoutNode{wc.root}
+	for i := 0; i < len(table); i++ {
+		// the children are already sorted by identifier
+		for _, c := range table[i].children {
+			if c.entry.isDir() && c.childLink == nil {
+				table = append(table, c)
+			}
+		}
+	}
+	wc.pathTable = table
+
+	wc.pathTableSize = 0
+	for _, dir := range table {
+		r := pathTableRecord{identifier: dir.identifier}
+		wc.pathTableSize += uint32(r.length())
+	}
+}
+
+// marshalPathTable encodes the path table with the given byte order, padded to whole sectors
+func (wc *writeContext) marshalPathTable(order binary.ByteOrder) ([]byte, error) {
+	if len(wc.pathTable) > maxPathTableDirectories {
+		return nil, fmt.Errorf("%d directories don't fit into a path table", len(wc.pathTable))
+	}
+
+	numbers := make(map[*layoutNode]uint16, len(wc.pathTable))
+	for i, dir := range wc.pathTable {
+		numbers[dir] = uint16(i + 1)
+	}
+
+	output := make([]byte, 0, fileLengthToSectors(wc.pathTableSize)*sectorSize)
+	for _, dir := range wc.pathTable {
+		// the root is its own parent
+		r := pathTableRecord{identifier: dir.identifier, location: wc.blocks(dir.location), parent: numbers[dir.parent]}
+
+		output = append(output, r.marshal(order)...)
+	}
+	return output[:cap(output)], nil
+}
+
+// unmarshalPathTable decodes the records of a path table with the given byte order
+func unmarshalPathTable(data []byte, order binary.ByteOrder) ([]pathTableRecord, error) {
+	var records []pathTableRecord
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("path table record %d: %w", len(records)+1, io.ErrUnexpectedEOF)
+		}
+		r := pathTableRecord{
+			location: order.Uint32(data[2:6]),
+			parent:   order.Uint16(data[6:8]),
+		}
+		identifierLength := int(data[0])
+		if identifierLength == 0 || len(data) < 8+identifierLength {
+			return nil, fmt.Errorf("path table record %d has an invalid identifier length %d", len(records)+1, identifierLength)
+		}
+		r.identifier = string(data[8 : 8+identifierLength])
+
+		records = append(records, r)
+		data = data[r.length():]
+	}
+	return records, nil
+}
+
+// readPathTable reads the path table of the given size at the given logical block
+func (i *Image) readPathTable(location, size int32, order binary.ByteOrder) ([]pathTableRecord, error) {
+	data := make([]byte, size)
+	if _, err := i.ra.ReadAt(data, int64(location)*i.LogicalBlockSize()); err != nil {
+		return nil, err
+	}
+	return unmarshalPathTable(data, order)
+}
+
+// readDirectoryRecords returns the records of a directory extent as they are, without any extensions applied
+func (i *Image) readDirectoryRecords(location, length uint32) ([]*DirectoryEntry, error) {
+	var records []*DirectoryEntry
+	buffer := make([]byte, sectorSize)
+	for offset := uint32(0); offset < length; offset += sectorSize {
+		if _, err := i.ra.ReadAt(buffer, int64(location)*i.LogicalBlockSize()+int64(offset)); err != nil {
+			return nil, err
+		}
+		for pos := uint32(0); pos < sectorSize && buffer[pos] != 0; pos += uint32(buffer[pos]) {
+			if pos+uint32(buffer[pos]) > sectorSize {
+				return nil, fmt.Errorf("reading directory entries: %w: DE outside of sector boundries", ErrTruncatedRecord)
+			}
+			de := &DirectoryEntry{}
+			if err := de.UnmarshalBinary(buffer[pos : pos+uint32(buffer[pos])]); err != nil {
+				return nil, err
+			}
+			records = append(records, de)
+		}
+	}
+	return records, nil
+}
+
+// VerifyPathTables checks that the L and M path tables of the primary volume agree with each other,
+// are sorted as ECMA-119 6.9.1 requires and list exactly the directories of the directory hierarchy.
+func (i *Image) VerifyPathTables() error {
+	pvd, err := i.primaryVolume()
+	if err != nil {
+		return err
+	}
+
+	lTable, err := i.readPathTable(pvd.TypeLPathTableLoc, pvd.PathTableSize, binary.LittleEndian)
+	if err != nil {
+		return fmt.Errorf("reading the L path table: %w", err)
+	}
+	mTable, err := i.readPathTable(pvd.TypeMPathTableLoc, pvd.PathTableSize, binary.BigEndian)
+	if err != nil {
+		return fmt.Errorf("reading the M path table: %w", err)
+	}
+	if len(lTable) != len(mTable) {
+		return fmt.Errorf("the L path table has %d records, the M path table %d", len(lTable), len(mTable))
+	}
+	for n := range lTable {
+		if lTable[n] != mTable[n] {
+			return fmt.Errorf("record %d differs between the L path table (%+v) and the M path table (%+v)", n+1, lTable[n], mTable[n])
+		}
+	}
+
+	if len(lTable) == 0 || lTable[0].parent != 1 || lTable[0].location != uint32(pvd.RootDirectoryEntry.ExtentLocation) {
+		return fmt.Errorf("the first path table record doesn't describe the root directory")
+	}
+
+	levels := []int{1}
+	for n := 1; n < len(lTable); n++ {
+		r, previous := lTable[n], lTable[n-1]
+		if r.parent == 0 || int(r.parent) > n {
+			return fmt.Errorf("path table record %d %q has the parent %d, which doesn't precede it", n+1, r.identifier, r.parent)
+		}
+		levels = append(levels, levels[r.parent-1]+1)
+
+		switch {
+		case levels[n] < levels[n-1],
+			levels[n] == levels[n-1] && r.parent < previous.parent,
+			levels[n] == levels[n-1] && r.parent == previous.parent && compareIdentifiers(previous.identifier, r.identifier) >= 0:
+			return fmt.Errorf("path table record %d %q is out of order", n+1, r.identifier)
+		}
+	}
+
+	// every directory record of a listed directory has to be listed as well, at the same location
+	listed := make(map[[2]uint32]string)
+	for _, r := range lTable[1:] {
+		listed[[2]uint32{lTable[r.parent-1].location, r.location}] = r.identifier
+	}
+	subdirectories := 0
+	for _, dir := range lTable {
+		dot, err := i.readDirectoryRecords(dir.location, sectorSize)
+		if err != nil || len(dot) == 0 {
+			return fmt.Errorf("reading the directory %q at sector %d: %v", dir.identifier, dir.location, err)
+		}
+		records, err := i.readDirectoryRecords(dir.location, uint32(dot[0].ExtentLength))
+		if err != nil {
+			return fmt.Errorf("reading the directory %q at sector %d: %w", dir.identifier, dir.location, err)
+		}
+		for _, de := range records {
+			if de.FileFlags&dirFlagDir == 0 || de.Identifier == string([]byte{0}) || de.Identifier == string([]byte{1}) {
+				continue
+			}
+			if identifier, ok := listed[[2]uint32{dir.location, uint32(de.ExtentLocation)}]; !ok || identifier != de.Identifier {
+				return fmt.Errorf("the directory %q at sector %d is missing from the path table", de.Identifier, de.ExtentLocation)
+			}
+			subdirectories++
+		}
+	}
+	if subdirectories != len(lTable)-1 {
+		return fmt.Errorf("the path table lists %d directories, the directory hierarchy has %d", len(lTable)-1, subdirectories)
+	}
+
+	return nil
+}
+
+// LookupPath resolves a path made of the identifiers recorded in the selected volume, like "BOOT/ISOLINUX/ISOLINUX.BIN",
+// through its L path table, as some firmware does. Only the directory holding the last element is read,
+// instead of every directory along the path. The identifiers are compared ignoring case, file versions
+// and the "." of empty extensions, and the last element also matches the Rock Ridge name of an entry.
+// Directories relocated by Rock Ridge are found where they are recorded, in the relocation directory.
+// It returns an error wrapping os.ErrNotExist if the path isn't there.
+func (i *Image) LookupPath(isoPath string) (*File, error) {
+	pvd, err := i.primaryVolume()
+	if err != nil {
+		return nil, err
+	}
+	root, err := i.RootDir()
+	if err != nil {
+		return nil, err
+	}
+	segments := splitPath(isoPath)
+	if len(segments) == 0 {
+		return root, nil
+	}
+	// reading the root tells whether the volume uses SUSP and Rock Ridge
+	if _, err = root.GetAllChildren(); err != nil {
+		return nil, err
+	}
+
+	table, err := i.readPathTable(pvd.TypeLPathTableLoc, pvd.PathTableSize, binary.LittleEndian)
+	if err != nil {
+		return nil, fmt.Errorf("reading the L path table: %w", err)
+	}
+
+	dir, number := root, 1
+	for _, segment := range segments[:len(segments)-1] {
+		found := false
+		// the records of a directory's subdirectories follow it, as do their parents' records
+		for n := number; n < len(table) && !found; n++ {
+			r := table[n]
+			identifier := r.identifier
+			if root.joliet {
+				identifier = decodeJolietIdentifier(identifier)
+			}
+			if int(r.parent) == number && strings.EqualFold(identifier, segment) {
+				dot, err := readDotEntry(i.ra, r.location, root.logicalBlockSize())
+				if err != nil {
+					return nil, fmt.Errorf("reading the directory %q: %w", identifier, err)
+				}
+				dot.Identifier = identifier
+				dir = &File{ra: i.ra, de: dot, options: i.options, imageSize: i.size, warnings: i.warnings, joliet: root.joliet, susp: root.susp,
+					blockSize: root.blockSize}
+				number, found = n+1, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("looking up %q: %w", isoPath, os.ErrNotExist)
+		}
+	}
+
+	children, err := dir.GetChildren()
+	if err != nil {
+		return nil, err
+	}
+	last := segments[len(segments)-1]
+	for _, c := range children {
+		if strings.EqualFold(lookupIdentifier(c.de.Identifier), lookupIdentifier(last)) || c.Name() == last {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("looking up %q: %w", isoPath, os.ErrNotExist)
+}
+
+// lookupIdentifier strips the version and the separator of an empty extension from a file identifier
+func lookupIdentifier(identifier string) string {
+	return strings.TrimSuffix(withoutVersion(identifier), ".")
+}
@@ -0,0 +1,160 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyPathTablesFixtures(t *testing.T) {
+	for _, fixture := range []string{"fixtures/test.iso", "fixtures/test_rockridge.iso"} {
+		t.Run(fixture, func(t *testing.T) {
+			f, err := os.Open(fixture)
+			require.NoError(t, err)
+			defer f.Close()
+
+			img, err := OpenImage(f)
+			require.NoError(t, err)
+			assert.NoError(t, img.VerifyPathTables())
+		})
+	}
+}
+
+func TestWriterPathTables(t *testing.T) {
+	iw, err := NewWriter()
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+	iw.SetRockRidge(true)
+
+	// same-named directories at different levels, and a hierarchy deep enough to be relocated
+	for _, dir := range []string{"B/A", "A/A/A", "A/B", "C", "1/2/3/4/5/6/7/8/9"} {
+		require.NoError(t, iw.AddDirectory(dir))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, iw.WriteTo(&buf, "tables"))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.NoError(t, img.VerifyPathTables())
+
+	pvd, err := img.primaryVolume()
+	require.NoError(t, err)
+	records, err := img.readPathTable(pvd.TypeMPathTableLoc, pvd.PathTableSize, binary.BigEndian)
+	require.NoError(t, err)
+
+	var listing []string
+	for _, r := range records {
+		listing = append(listing, fmt.Sprintf("%d:%q", r.parent, r.identifier))
+	}
+	assert.Equal(t, []string{
+		`1:"\x00"`,
+		`1:"1"`, `1:"A"`, `1:"B"`, `1:"C"`, `1:"RR_MOVED"`,
+		`2:"2"`, `3:"A"`, `3:"B"`, `4:"A"`, `6:"8"`,
+		`7:"3"`, `8:"A"`, `11:"9"`,
+		`12:"4"`,
+		`15:"5"`,
+		`16:"6"`,
+		`17:"7"`,
+	}, listing)
+}
+
+func TestVerifyPathTablesMismatch(t *testing.T) {
+	iw, err := NewWriter()
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+	require.NoError(t, iw.AddDirectory("A"))
+	require.NoError(t, iw.AddDirectory("B"))
+
+	var buf bytes.Buffer
+	require.NoError(t, iw.WriteTo(&buf, "tables"))
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	pvd, err := img.primaryVolume()
+	require.NoError(t, err)
+
+	// swap the identifiers of the two directories in the M table only
+	data := buf.Bytes()
+	m := int(pvd.TypeMPathTableLoc) * int(sectorSize)
+	require.Equal(t, byte('A'), data[m+10+8])
+	data[m+10+8], data[m+20+8] = 'B', 'A'
+
+	img, err = OpenImage(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.ErrorContains(t, img.VerifyPathTables(), "record 2 differs between the L path table")
+}
+
+func TestWriterPathTableOverflow(t *testing.T) {
+	iw, err := NewWriter()
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+
+	for i := 0; i < maxPathTableDirectories; i++ {
+		require.NoError(t, iw.AddDirectory(fmt.Sprintf("D%03d/D%03d", i/256, i%256)))
+	}
+
+	report, err := iw.Validate()
+	require.NoError(t, err)
+	message := fmt.Sprintf("the image has %d directories, more than the 65535 a path table can number", maxPathTableDirectories+256+1)
+	assert.Equal(t, []Finding{{Severity: SeverityError, Message: message}}, report.Errors())
+
+	var buf bytes.Buffer
+	assert.EqualError(t, iw.WriteTo(&buf, ""), "invalid image: "+message)
+	assert.Zero(t, buf.Len())
+}
+
+func TestLookupPath(t *testing.T) {
+	for _, joliet := range []bool{false, true} {
+		t.Run(fmt.Sprintf("joliet=%v", joliet), func(t *testing.T) {
+			iw, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Joliet: joliet})
+			require.NoError(t, err)
+			defer iw.Cleanup() // nolint: errcheck
+			require.NoError(t, iw.AddFile(strings.NewReader("kernel"), "boot/isolinux/vmlinuz"))
+			require.NoError(t, iw.AddFile(strings.NewReader("top"), "readme.txt"))
+			require.NoError(t, iw.AddDirectory("boot/empty"))
+
+			var buf bytes.Buffer
+			require.NoError(t, iw.WriteTo(&buf, "lookup"))
+			img, err := OpenImageWithOptions(bytes.NewReader(buf.Bytes()), ReaderOptions{PreferJoliet: joliet})
+			require.NoError(t, err)
+
+			for lookup, name := range map[string]string{
+				"BOOT/ISOLINUX/VMLINUZ.;1": "vmlinuz",
+				"/boot/isolinux/vmlinuz":   "vmlinuz",
+				"README.TXT":               "readme.txt",
+				"boot/empty":               "empty",
+			} {
+				f, err := img.LookupPath(lookup)
+				require.NoError(t, err, lookup)
+				assert.Equal(t, name, f.Name(), lookup)
+			}
+
+			f, err := img.LookupPath("boot/isolinux/vmlinuz")
+			require.NoError(t, err)
+			data, err := io.ReadAll(f.Reader())
+			require.NoError(t, err)
+			assert.Equal(t, "kernel", string(data))
+			// the Joliet hierarchy has no Rock Ridge entries
+			_, _, ok := f.Owner()
+			assert.Equal(t, !joliet, ok)
+
+			root, err := img.LookupPath("/")
+			require.NoError(t, err)
+			assert.True(t, root.IsDir())
+
+			for _, missing := range []string{"boot/missing/vmlinuz", "boot/isolinux/missing", "readme.txt/child"} {
+				_, err = img.LookupPath(missing)
+				assert.ErrorIs(t, err, os.ErrNotExist, missing)
+			}
+		})
+	}
+}
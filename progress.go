@@ -0,0 +1,118 @@
+package iso9660
+
+import (
+	"io"
+	"sort"
+)
+
+// Progress tells how far WriteToContext has got, see SetProgressFunc
+type Progress struct {
+	// Phase is what is being written, one of the Phase constants
+	Phase string
+	// Path is the staged file whose data is being written, if any
+	Path string
+	// Written is the number of bytes of the image produced so far, out of Total
+	Written, Total int64
+	// FilesWritten is the number of files whose data has been written, out of TotalFiles
+	FilesWritten, TotalFiles int
+}
+
+// SetProgressFunc sets a function called as the image is written by WriteTo and WriteToContext,
+// or by ImageEditor.Commit, or removes it if fn is nil. It is called from the goroutine of the write,
+// after every chunk written to the output, so it should return quickly.
+//
+// When an MD5 checksum is implanted, the image is produced twice and the totals count both passes.
+func (iw *ImageWriter) SetProgressFunc(fn func(Progress)) {
+	iw.progress = fn
+}
+
+// writtenFiles returns the files whose data is written, ordered by their locations
+func (wc *writeContext) writtenFiles() []*layoutNode {
+	var written []*layoutNode
+	for _, file := range wc.files {
+		if file.source != nil && !file.inPlace {
+			written = append(written, file)
+		}
+	}
+	// pinned and weighted files aren't allocated in staging order, and the data is written front to back
+	sort.SliceStable(written, func(i, j int) bool {
+		return written[i].location < written[j].location
+	})
+	return written
+}
+
+// progressReporter reports the progress of the writes through it
+type progressReporter struct {
+	wc     *writeContext
+	report func(Progress)
+	files  []*layoutNode
+	// position is the offset in the image of the next byte written in the current pass, and next the index
+	// of the first file whose data isn't complete at it
+	position int64
+	next     int
+	progress Progress
+}
+
+// newProgressReporter creates a reporter of writing the sectors from start to the end of the image
+// in the given number of passes
+func (wc *writeContext) newProgressReporter(report func(Progress), start uint32, passes int) *progressReporter {
+	files := wc.writtenFiles()
+	return &progressReporter{
+		wc:     wc,
+		report: report,
+		files:  files,
+		progress: Progress{
+			Total:      int64(passes) * int64(wc.freeSectorPointer-start) * int64(sectorSize),
+			TotalFiles: passes * len(files),
+		},
+	}
+}
+
+// pass returns the writer of a pass producing the image from the sector start onwards
+func (p *progressReporter) pass(w io.Writer, start uint32) io.Writer {
+	p.position = int64(start) * int64(sectorSize)
+	p.next = 0
+	return &progressPassWriter{w: w, reporter: p}
+}
+
+func (p *progressReporter) wrote(n int) {
+	p.position += int64(n)
+	p.progress.Written += int64(n)
+	for p.next < len(p.files) && p.position >= p.fileEnd(p.files[p.next]) {
+		p.next++
+		p.progress.FilesWritten++
+	}
+
+	sector := uint32(p.position / int64(sectorSize))
+	p.progress.Path = ""
+	switch {
+	case sector < p.wc.lPathTableLocation:
+		p.progress.Phase = PhaseVolumeDescriptor
+	case len(p.wc.directories) > 0 && sector < p.wc.directories[0].location:
+		p.progress.Phase = PhasePathTables
+	case sector < p.wc.dataStart:
+		p.progress.Phase = PhaseDirectories
+	default:
+		p.progress.Phase = PhaseFileData
+		if p.next < len(p.files) && sector >= p.files[p.next].location {
+			p.progress.Path = p.files[p.next].entry.path()
+		}
+	}
+	p.report(p.progress)
+}
+
+// fileEnd returns the offset in the image after the data of the file and its padding
+func (p *progressReporter) fileEnd(file *layoutNode) int64 {
+	return (int64(file.location) + int64(sizeToSectors(file.source.Size()))) * int64(sectorSize)
+}
+
+type progressPassWriter struct {
+	w        io.Writer
+	reporter *progressReporter
+}
+
+func (w *progressPassWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.reporter.wrote(n)
+	return n, err
+}
@@ -0,0 +1,68 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterProgress(t *testing.T) {
+	for _, implantMD5 := range []bool{false, true} {
+		var reports []Progress
+		w, err := NewWriterWithOptions(WriterOptions{
+			ImplantMD5:     implantMD5,
+			DataBufferSize: int(sectorSize),
+			Progress:       func(p Progress) { reports = append(reports, p) },
+		})
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+		require.NoError(t, w.AddFile(bytes.NewReader(make([]byte, 3*sectorSize)), "a.bin"))
+		require.NoError(t, w.AddFile(strings.NewReader("b"), "dir/b.txt"))
+
+		var buf bytes.Buffer
+		require.NoError(t, w.WriteTo(&buf, ""))
+
+		passes := 1
+		if implantMD5 {
+			passes = 2
+		}
+		require.NotEmpty(t, reports)
+		last := reports[len(reports)-1]
+		assert.Equal(t, Progress{Phase: PhaseFileData, Written: int64(passes * buf.Len()), Total: int64(passes * buf.Len()),
+			FilesWritten: 2 * passes, TotalFiles: 2 * passes}, last)
+
+		phases := map[string]bool{}
+		paths := map[string]bool{}
+		for i, p := range reports {
+			phases[p.Phase] = true
+			paths[p.Path] = true
+			if i > 0 {
+				assert.GreaterOrEqual(t, p.Written, reports[i-1].Written)
+			}
+		}
+		assert.Equal(t, map[string]bool{PhaseVolumeDescriptor: true, PhasePathTables: true, PhaseDirectories: true, PhaseFileData: true}, phases)
+		assert.True(t, paths["/a.bin"], "%v", paths)
+	}
+}
+
+func TestImageEditorProgress(t *testing.T) {
+	e, err := OpenImageEditor(copyFixture(t, "fixtures/test.iso"))
+	require.NoError(t, err)
+	defer e.Cleanup() // nolint: errcheck
+
+	var last Progress
+	e.SetProgressFunc(func(p Progress) { last = p })
+	require.NoError(t, e.AddFile(strings.NewReader("new"), "NEW.TXT"))
+	require.NoError(t, e.Commit(""))
+
+	// only the appended sectors and the new file are written
+	assert.Equal(t, last.Total, last.Written)
+	assert.Equal(t, 1, last.TotalFiles)
+	assert.Equal(t, 1, last.FilesWritten)
+}
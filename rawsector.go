@@ -0,0 +1,105 @@
+package iso9660
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// rawSectorSize is the size of a sector as read from a CD, with the sync pattern, the header,
+	// the error detection and correction codes and, in Mode 2, the subheader, see ECMA-130 14
+	rawSectorSize = 2352
+	// the user data starts after the sync pattern and the header in Mode 1,
+	// and after the subheader as well in Mode 2 Form 1
+	rawMode1DataOffset = 16
+	rawMode2DataOffset = 24
+)
+
+// rawSectorSync starts every data sector of a CD
+var rawSectorSync = []byte{0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x00}
+
+// RawSectorReader reads the user data of a dump of raw 2352-byte CD sectors, such as the data track
+// of a BIN/CUE rip, as an image of 2048-byte sectors. The sync pattern, the header, the subheader
+// and the error detection and correction codes of every sector are skipped without being checked.
+// Only Mode 1 and Mode 2 Form 1 sectors are supported, which is what ISO 9660 data is recorded in.
+//
+// OpenImage detects dumps of raw sectors by themselves, so it only needs to be used explicitly
+// for tracks whose mode is known from elsewhere, such as a CUE sheet.
+type RawSectorReader struct {
+	ra io.ReaderAt
+	// dataOffset is the offset of the user data within each raw sector
+	dataOffset int64
+	// size is the number of bytes of user data, 0 if it cannot be told
+	size int64
+}
+
+var _ io.ReaderAt = &RawSectorReader{}
+
+// NewRawSectorReader reads a dump of raw sectors in the given mode, 1 or 2 for Mode 2 Form 1
+func NewRawSectorReader(ra io.ReaderAt, mode int) (*RawSectorReader, error) {
+	r := &RawSectorReader{ra: ra}
+	switch mode {
+	case 1:
+		r.dataOffset = rawMode1DataOffset
+	case 2:
+		r.dataOffset = rawMode2DataOffset
+	default:
+		return nil, fmt.Errorf("unsupported CD sector mode %d", mode)
+	}
+	if size, ok := readerSize(ra); ok {
+		r.size = size / rawSectorSize * int64(sectorSize)
+	}
+	return r, nil
+}
+
+// DetectRawSectors reports whether ra holds raw 2352-byte sectors of ISO 9660 data and returns a reader
+// of their user data if it does. The mode is read from the header of the first volume descriptor.
+func DetectRawSectors(ra io.ReaderAt) (*RawSectorReader, bool) {
+	raw := make([]byte, rawSectorSize)
+	if _, err := ra.ReadAt(raw, 16*rawSectorSize); err != nil {
+		return nil, false
+	}
+	if !bytes.Equal(raw[:len(rawSectorSync)], rawSectorSync) {
+		return nil, false
+	}
+
+	r, err := NewRawSectorReader(ra, int(raw[15]))
+	if err != nil {
+		return nil, false
+	}
+	if !bytes.Equal(raw[r.dataOffset+1:r.dataOffset+6], standardIdentifierBytes[:]) {
+		return nil, false
+	}
+	return r, true
+}
+
+// Size returns the number of bytes of user data in the dump, or 0 if the size of the dump cannot be told
+func (r *RawSectorReader) Size() int64 {
+	return r.size
+}
+
+// ReadAt reads the user data at the given offset, as if the dump held 2048-byte sectors
+func (r *RawSectorReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("RawSectorReader.ReadAt: negative offset")
+	}
+
+	n := 0
+	for n < len(p) {
+		sector, within := off/int64(sectorSize), off%int64(sectorSize)
+		chunk := p[n:]
+		if rest := int64(sectorSize) - within; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+
+		read, err := r.ra.ReadAt(chunk, sector*rawSectorSize+r.dataOffset+within)
+		n += read
+		off += int64(read)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,75 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawTestImage writes an image with a few files and returns it as 2048-byte sectors
+func rawTestImage(t *testing.T) []byte {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader(loremIpsum), "docs/lorem.txt"))
+	require.NoError(t, w.AddFile(bytes.NewReader(bytes.Repeat([]byte{0xAB}, 3*int(sectorSize)+5)), "data.bin"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "RAW"))
+	return buf.Bytes()
+}
+
+// toRawSectors converts an image to raw CD sectors of the given mode, with the error correction codes left empty
+func toRawSectors(image []byte, mode int) []byte {
+	dataOffset := rawMode1DataOffset
+	if mode == 2 {
+		dataOffset = rawMode2DataOffset
+	}
+	var raw bytes.Buffer
+	for sector := 0; sector*int(sectorSize) < len(image); sector++ {
+		s := make([]byte, rawSectorSize)
+		copy(s, rawSectorSync)
+		// the header holds the address in minutes, seconds and frames with the 2 second lead-in, in BCD
+		frame := sector + 150
+		bcd := func(v int) byte { return byte(v/10<<4 | v%10) }
+		s[12], s[13], s[14], s[15] = bcd(frame/75/60), bcd(frame/75%60), bcd(frame%75), byte(mode)
+		copy(s[dataOffset:], image[sector*int(sectorSize):])
+		raw.Write(s)
+	}
+	return raw.Bytes()
+}
+
+func TestRawSectors(t *testing.T) {
+	image := rawTestImage(t)
+	for _, mode := range []int{1, 2} {
+		raw := toRawSectors(image, mode)
+		reader, ok := DetectRawSectors(bytes.NewReader(raw))
+		require.True(t, ok, mode)
+		assert.Equal(t, int64(len(image)), reader.Size())
+
+		// reads spanning several sectors skip the fields between them
+		cooked := make([]byte, 5000)
+		n, err := reader.ReadAt(cooked, 16*int64(sectorSize)-100)
+		require.NoError(t, err)
+		assert.Equal(t, 5000, n)
+		assert.Equal(t, image[16*sectorSize-100:16*sectorSize+4900], cooked)
+
+		img, err := OpenImage(bytes.NewReader(raw))
+		require.NoError(t, err, mode)
+		assert.Equal(t, loremIpsum, readPath(t, img, "/docs/lorem.txt"))
+		assert.Equal(t, string(bytes.Repeat([]byte{0xAB}, 3*int(sectorSize)+5)), readPath(t, img, "/data.bin"))
+		missing, truncated := img.IsTruncated()
+		assert.False(t, truncated, missing)
+	}
+
+	// images of 2048-byte sectors aren't taken for raw ones
+	_, ok := DetectRawSectors(bytes.NewReader(image))
+	assert.False(t, ok)
+	_, err := NewRawSectorReader(bytes.NewReader(image), 3)
+	assert.EqualError(t, err, "unsupported CD sector mode 3")
+}
@@ -0,0 +1,230 @@
+package iso9660
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ReadAheadOptions controls a ReadAheadReaderAt
+type ReadAheadOptions struct {
+	// BlockSize is the unit in which the backend is read and kept in memory, 0 selects 64 KiB
+	BlockSize int64
+	// ReadAhead is the number of blocks fetched along with the ones a read needs, 0 disables read-ahead
+	ReadAhead int
+	// Blocks is the number of blocks kept in memory, 0 selects 256
+	Blocks int
+}
+
+// ReadAheadStats counts the requests a ReadAheadReaderAt made to its backend
+type ReadAheadStats struct {
+	// Hits and Misses count blocks, not read calls
+	Hits   uint64
+	Misses uint64
+	// Fetches is the number of calls to the ReadAt of the backend and BytesFetched the number of bytes they returned
+	Fetches      uint64
+	BytesFetched int64
+}
+
+// ReadAheadReaderAt reads an image from a backend with high latency, such as HTTP range requests or an object store,
+// in large blocks it keeps in memory. A read of missing blocks fetches them along with the following ones
+// in a single request, and the reads waiting for a block being fetched share the request.
+// It is safe for concurrent use, and its Size lets OpenImage tell whether the image is truncated.
+type ReadAheadReaderAt struct {
+	ra        io.ReaderAt
+	size      int64
+	blockSize int64
+	ahead     int64
+	capacity  int
+
+	mu      sync.Mutex
+	lru     *list.List // of *readAheadBlock, the most recently used first
+	blocks  map[int64]*list.Element
+	pending map[int64]*readAheadFetch
+	stats   ReadAheadStats
+}
+
+type readAheadBlock struct {
+	index int64
+	data  []byte
+}
+
+// readAheadFetch is a request to the backend, done is closed once its blocks are stored or it failed
+type readAheadFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewReadAheadReaderAt returns a reader of the given number of bytes of ra, which it reads in blocks.
+// The size must be known in advance, as the blocks are never read beyond it.
+func NewReadAheadReaderAt(ra io.ReaderAt, size int64, opts ReadAheadOptions) (*ReadAheadReaderAt, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid size %d", size)
+	}
+	if opts.BlockSize < 0 || opts.ReadAhead < 0 || opts.Blocks < 0 {
+		return nil, errors.New("negative read-ahead options")
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = 64 * 1024
+	}
+	if opts.Blocks == 0 {
+		opts.Blocks = 256
+	}
+	// a read fetches at most one block more than the read-ahead, which must fit
+	if opts.Blocks <= opts.ReadAhead {
+		return nil, fmt.Errorf("%d blocks cannot hold a read-ahead of %d blocks", opts.Blocks, opts.ReadAhead)
+	}
+
+	return &ReadAheadReaderAt{
+		ra:        ra,
+		size:      size,
+		blockSize: opts.BlockSize,
+		ahead:     int64(opts.ReadAhead),
+		capacity:  opts.Blocks,
+		lru:       list.New(),
+		blocks:    make(map[int64]*list.Element),
+		pending:   make(map[int64]*readAheadFetch),
+	}, nil
+}
+
+// Size returns the number of bytes of the image
+func (r *ReadAheadReaderAt) Size() int64 {
+	return r.size
+}
+
+// Stats returns the hits and misses of the blocks in memory and the requests made to the backend
+func (r *ReadAheadReaderAt) Stats() ReadAheadStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+func (r *ReadAheadReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ReadAheadReaderAt.ReadAt: negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+	last := (end - 1) / r.blockSize
+	n := 0
+	for pos := off; pos < end; pos = off + int64(n) {
+		data, err := r.block(pos/r.blockSize, last)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:end-off], data[pos%r.blockSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block returns the data of the block with the given index. If it has to be fetched, the missing blocks
+// following it up to the last one the read needs and the read-ahead are fetched with it.
+func (r *ReadAheadReaderAt) block(index, last int64) ([]byte, error) {
+	counted := false
+	for {
+		r.mu.Lock()
+		if e, ok := r.blocks[index]; ok {
+			if !counted {
+				r.stats.Hits++
+			}
+			r.lru.MoveToFront(e)
+			r.mu.Unlock()
+			return e.Value.(*readAheadBlock).data, nil
+		}
+		if !counted {
+			r.stats.Misses++
+			counted = true
+		}
+		if fetch, ok := r.pending[index]; ok {
+			r.mu.Unlock()
+			<-fetch.done
+			if fetch.err != nil {
+				return nil, fetch.err
+			}
+			// the block may have been evicted already, in which case it is fetched again
+			continue
+		}
+
+		// the request stops at the end of the image and before the blocks in memory or being fetched
+		count := int64(1)
+		for limit := last + r.ahead; index+count <= limit && index+count <= (r.size-1)/r.blockSize; count++ {
+			if _, ok := r.blocks[index+count]; ok {
+				break
+			}
+			if _, ok := r.pending[index+count]; ok {
+				break
+			}
+		}
+		fetch := &readAheadFetch{done: make(chan struct{})}
+		for b := index; b < index+count; b++ {
+			r.pending[b] = fetch
+		}
+		r.mu.Unlock()
+
+		data, err := r.fetch(index, count)
+
+		r.mu.Lock()
+		for b := index; b < index+count; b++ {
+			delete(r.pending, b)
+		}
+		if err != nil {
+			fetch.err = err
+			r.mu.Unlock()
+			close(fetch.done)
+			return nil, err
+		}
+		// the block asked for goes in last, so that it isn't evicted by those read ahead
+		for b := index + count - 1; b >= index; b-- {
+			start := (b - index) * r.blockSize
+			r.insert(b, data[start:min64(start+r.blockSize, int64(len(data)))])
+		}
+		r.mu.Unlock()
+		close(fetch.done)
+		return data[:min64(r.blockSize, int64(len(data)))], nil
+	}
+}
+
+// fetch reads the given number of blocks from the backend in a single request
+func (r *ReadAheadReaderAt) fetch(index, count int64) ([]byte, error) {
+	start := index * r.blockSize
+	data := make([]byte, min64(count*r.blockSize, r.size-start))
+	n, err := r.ra.ReadAt(data, start)
+
+	r.mu.Lock()
+	r.stats.Fetches++
+	r.stats.BytesFetched += int64(n)
+	r.mu.Unlock()
+
+	if n < len(data) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("reading %d bytes at offset %d: %w", len(data), start, err)
+	}
+	return data, nil
+}
+
+// insert keeps a block in memory, evicting the least recently used ones. It must be called with mu held.
+func (r *ReadAheadReaderAt) insert(index int64, data []byte) {
+	if e, ok := r.blocks[index]; ok {
+		r.lru.MoveToFront(e)
+		return
+	}
+	r.blocks[index] = r.lru.PushFront(&readAheadBlock{index: index, data: data})
+	for r.lru.Len() > r.capacity {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.blocks, oldest.Value.(*readAheadBlock).index)
+	}
+}
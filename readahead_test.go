@@ -0,0 +1,120 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAheadReaderAt(t *testing.T) {
+	data := make([]byte, 10*4096+100)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	backend := &countingReaderAt{ra: bytes.NewReader(data)}
+	r, err := NewReadAheadReaderAt(backend, int64(len(data)), ReadAheadOptions{BlockSize: 4096, ReadAhead: 2, Blocks: 4})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), r.Size())
+
+	read := func(off int64, length int) []byte {
+		buffer := make([]byte, length)
+		n, err := r.ReadAt(buffer, off)
+		require.NoError(t, err)
+		require.Equal(t, length, n)
+		return buffer
+	}
+
+	// the block is fetched along with the two following ones
+	assert.Equal(t, data[10:20], read(10, 10))
+	assert.Equal(t, data[4000:12000], read(4000, 8000))
+	assert.Equal(t, ReadAheadStats{Hits: 3, Misses: 1, Fetches: 1, BytesFetched: 3 * 4096}, r.Stats())
+	assert.Equal(t, 1, backend.reads)
+
+	// the request stops before the block in memory
+	assert.Equal(t, data[20000:30000], read(20000, 10000))
+	assert.Equal(t, data[12000:13000], read(12000, 1000))
+	assert.Equal(t, uint64(3), r.Stats().Fetches)
+	assert.Len(t, r.blocks, 4)
+
+	// the last block is shorter, the read ahead stops at the end
+	buffer := make([]byte, 200)
+	n, err := r.ReadAt(buffer, int64(len(data))-100)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, data[len(data)-100:], buffer[:n])
+	n, err = r.ReadAt(buffer, int64(len(data)))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+	_, err = r.ReadAt(buffer, -1)
+	assert.Error(t, err)
+
+	assert.NoError(t, iotest.TestReader(io.NewSectionReader(r, 0, r.Size()), data))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				off := int64((g*7919 + i*4099) % (len(data) - 5000))
+				buffer := make([]byte, 5000)
+				n, err := r.ReadAt(buffer, off)
+				assert.NoError(t, err)
+				assert.Equal(t, data[off:off+int64(n)], buffer)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// a backend shorter than the size fails the reads beyond it
+	short, err := NewReadAheadReaderAt(bytes.NewReader(data[:5000]), int64(len(data)), ReadAheadOptions{BlockSize: 4096})
+	require.NoError(t, err)
+	_, err = short.ReadAt(buffer, 1000)
+	assert.NoError(t, err)
+	_, err = short.ReadAt(buffer, 6000)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	for _, opts := range []ReadAheadOptions{{BlockSize: -1}, {ReadAhead: 4, Blocks: 4}} {
+		_, err := NewReadAheadReaderAt(backend, int64(len(data)), opts)
+		assert.Error(t, err, "%+v", opts)
+	}
+}
+
+func TestOpenImageReadAhead(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	content := strings.Repeat("remote", 50000)
+	require.NoError(t, w.AddFile(strings.NewReader(content), "data.txt"))
+	require.NoError(t, w.AddFile(strings.NewReader("small"), "dir/small.txt"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "remote"))
+
+	backend := &countingReaderAt{ra: bytes.NewReader(buf.Bytes())}
+	r, err := NewReadAheadReaderAt(backend, int64(buf.Len()), ReadAheadOptions{BlockSize: 32 * 1024, ReadAhead: 4})
+	require.NoError(t, err)
+	img, err := OpenImage(r)
+	require.NoError(t, err)
+	assert.Equal(t, content, readPath(t, img, "/data.txt"))
+	assert.Equal(t, "small", readPath(t, img, "/dir/small.txt"))
+	_, truncated := img.IsTruncated()
+	assert.False(t, truncated)
+	assert.LessOrEqual(t, backend.reads, 3, "the image is read in a few large requests")
+
+	// the size of a reader which cannot tell it is given with the options
+	truncatedImage := &countingReaderAt{ra: bytes.NewReader(buf.Bytes()[:buf.Len()-4096])}
+	img, err = OpenImageWithOptions(truncatedImage, ReaderOptions{Size: int64(buf.Len() - 4096)})
+	require.NoError(t, err)
+	missing, truncated := img.IsTruncated()
+	assert.True(t, truncated)
+	assert.Equal(t, int64(4096), missing)
+}
@@ -0,0 +1,99 @@
+package iso9660
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ReadMode selects how OpenImageFile reads an image file
+type ReadMode int
+
+const (
+	// ReadDirect reads the file with a system call for every read of the image
+	ReadDirect ReadMode = iota
+	// ReadMmap reads the file through a read-only memory mapping, see OpenImageMmap.
+	// Where the file cannot be mapped, it is read like with ReadDirect.
+	ReadMmap
+	// ReadBuffered reads the file in blocks kept in memory, like a ReadAheadReaderAt, so that the reads of
+	// neighbouring small files and directories are served by a single system call. It pays off where every
+	// system call is expensive, like on network and FUSE file systems, and where the file cannot be mapped.
+	ReadBuffered
+)
+
+// FileOptions controls how OpenImageFile reads an image file
+type FileOptions struct {
+	ReaderOptions
+	// Mode selects between system calls for every read, the default, a memory mapping and a buffered reader
+	Mode ReadMode
+	// Buffer tunes the blocks read with ReadBuffered, the defaults being those of NewReadAheadReaderAt.
+	// Extracting many small files benefits from a read-ahead of a few blocks, since they are usually written
+	// in the order they are extracted in.
+	Buffer ReadAheadOptions
+}
+
+// OpenImageFile opens the image file at the given path, read in the selected ReadMode.
+// Reading an image of many small files with a system call for every read spends much of the time in the kernel,
+// even when the file is in the page cache. A memory mapping avoids it and is the fastest way to read local files,
+// where reading all the files of an image of thousands of small files takes about a third of the time with ReadMmap.
+// Close must be called once the Image and the Files read from it are no longer used.
+func OpenImageFile(path string, opts FileOptions) (*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	var source interface {
+		io.ReaderAt
+		io.Closer
+	}
+	// the file stays open unless it is mapped
+	source = f
+	switch opts.Mode {
+	case ReadDirect:
+	case ReadMmap:
+		if info.Size() != int64(int(info.Size())) {
+			f.Close() // nolint: errcheck
+			return nil, fmt.Errorf("%s: the image of %d bytes is too large to be mapped into the address space of this platform", path, info.Size())
+		}
+		if data, err := mmapFile(f, int(info.Size())); err == nil {
+			// the mapping stays valid after the file is closed
+			f.Close() // nolint: errcheck
+			source = &mappedFile{data: data}
+		}
+	case ReadBuffered:
+		buffered, err := NewReadAheadReaderAt(f, info.Size(), opts.Buffer)
+		if err != nil {
+			f.Close() // nolint: errcheck
+			return nil, err
+		}
+		source = &bufferedFile{ReadAheadReaderAt: buffered, f: f}
+	default:
+		f.Close() // nolint: errcheck
+		return nil, fmt.Errorf("invalid read mode %d", opts.Mode)
+	}
+
+	img, err := OpenImageWithOptions(source, opts.ReaderOptions)
+	if err != nil {
+		source.Close() // nolint: errcheck
+		return nil, err
+	}
+	img.closer = source
+	return img, nil
+}
+
+// bufferedFile is an image file read with ReadBuffered, whose Size tells OpenImage the size of the image
+type bufferedFile struct {
+	*ReadAheadReaderAt
+	f *os.File
+}
+
+func (b *bufferedFile) Close() error {
+	return b.f.Close()
+}
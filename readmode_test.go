@@ -0,0 +1,86 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenImageFile(t *testing.T) {
+	f, err := os.Open("fixtures/test_rockridge.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+	img, err := OpenImage(f)
+	require.NoError(t, err)
+	var expected bytes.Buffer
+	require.NoError(t, img.ExportManifest(&expected, ManifestOptions{Lines: true, Hash: true}))
+
+	for _, mode := range []ReadMode{ReadDirect, ReadMmap, ReadBuffered} {
+		img, err := OpenImageFile("fixtures/test_rockridge.iso", FileOptions{Mode: mode, Buffer: ReadAheadOptions{BlockSize: 8192, ReadAhead: 2, Blocks: 16}})
+		require.NoError(t, err, mode)
+		var manifest bytes.Buffer
+		require.NoError(t, img.ExportManifest(&manifest, ManifestOptions{Lines: true, Hash: true}), mode)
+		assert.Equal(t, expected.String(), manifest.String(), mode)
+		_, truncated := img.IsTruncated()
+		assert.False(t, truncated, mode)
+		if mode == ReadBuffered {
+			require.IsType(t, &bufferedFile{}, img.closer)
+			stats := img.closer.(*bufferedFile).Stats()
+			assert.NotZero(t, stats.Hits)
+			assert.NotZero(t, stats.Fetches)
+		}
+		assert.NoError(t, img.Close(), mode)
+	}
+
+	_, err = OpenImageFile("fixtures/test_rockridge.iso", FileOptions{Mode: ReadMode(42)})
+	assert.Error(t, err)
+	_, err = OpenImageFile("fixtures/test_rockridge.iso", FileOptions{Mode: ReadBuffered, Buffer: ReadAheadOptions{ReadAhead: 4, Blocks: 4}})
+	assert.Error(t, err)
+	_, err = OpenImageFile("fixtures/does-not-exist.iso", FileOptions{Mode: ReadBuffered})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func BenchmarkExtractSmallFiles(b *testing.B) {
+	w, err := NewWriter()
+	require.NoError(b, err)
+	defer w.Cleanup() // nolint: errcheck
+	for n := 0; n < 5000; n++ {
+		require.NoError(b, w.AddFile(bytes.NewReader(bytes.Repeat([]byte{byte(n)}, 100+n%3000)), fmt.Sprintf("D%d/F%d.TXT", n/500, n)))
+	}
+	path := filepath.Join(b.TempDir(), "small.iso")
+	out, err := os.Create(path)
+	require.NoError(b, err)
+	require.NoError(b, w.WriteTo(out, "small"))
+	require.NoError(b, out.Close())
+
+	for name, opts := range map[string]FileOptions{
+		"direct":   {Mode: ReadDirect},
+		"mmap":     {Mode: ReadMmap},
+		"buffered": {Mode: ReadBuffered, Buffer: ReadAheadOptions{ReadAhead: 4}},
+	} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				img, err := OpenImageFile(path, opts)
+				require.NoError(b, err)
+				err = img.Walk(func(path string, f *File, attrs RockRidgeAttrs) error {
+					if f.IsDir() {
+						return nil
+					}
+					_, err := io.Copy(io.Discard, f.Reader())
+					return err
+				})
+				require.NoError(b, err)
+				require.NoError(b, img.Close())
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+)
+
+// maxPathTableSize is the size of a path table listing as many directories as it can with the longest identifiers
+const maxPathTableSize = maxPathTableDirectories * (8 + 256)
+
+// volumeTypeDamaged stands for the type of the volume descriptors which OpenImageRecover couldn't decode.
+// ECMA-119 8.1.1 reserves it for future standardization.
+const volumeTypeDamaged byte = 254
+
+// recoveryScanChunk is the number of sectors read at once while scanning a damaged volume for directories
+const recoveryScanChunk = 256
+
+// RecoveryReport tells what OpenImageRecover salvaged from a damaged image
+type RecoveryReport struct {
+	// Directories and Files are the numbers of directories and other entries reachable from the root directory
+	Directories int
+	Files       int
+	// Orphans are the directories which cannot be reached from the root directory, as a directory record
+	// on the way is lost, but were found through the path tables or by scanning the volume.
+	// Their subdirectories aren't listed, they can be reached from the orphans.
+	Orphans []OrphanDirectory
+	// Damaged lists the anomalies worked around, such as unreadable sectors and skipped records, see Image.Warnings
+	Damaged []ReaderWarning
+}
+
+// OrphanDirectory is a directory of a damaged image which cannot be reached from the root directory
+type OrphanDirectory struct {
+	// Dir lists the entries of the directory, which are read like those of any other.
+	// It is named by its identifier in the path table, or after its sector if it was found by scanning, e.g. "LBA1234".
+	Dir *File
+	// Path is the path recorded for the directory in the path table, made of the identifiers of its parents,
+	// or empty if it was found by scanning the volume
+	Path string
+	LBA  uint32
+}
+
+// OpenImageRecover opens a damaged image, such as a partial rip of a scratched disc, with ReaderOptions.Recover set,
+// so that whatever is intact can be extracted. It walks the whole directory hierarchy, then looks for the directories
+// it couldn't reach in the path tables and by scanning the sectors of the volume which aren't known to hold anything else,
+// which reads most of the image. The anomalies worked around are recorded as warnings and listed in the report.
+// It only fails if no Primary Volume Descriptor can be read.
+func OpenImageRecover(ra io.ReaderAt, opts ReaderOptions) (*Image, *RecoveryReport, error) {
+	opts.Recover = true
+	img, err := OpenImageWithOptions(ra, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	pvd, err := img.primaryVolume()
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := img.RootDir()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := &recovery{
+		image:   img,
+		root:    root,
+		report:  &RecoveryReport{},
+		visited: make(map[uint32]int),
+		listed:  make(map[uint32]bool),
+	}
+	r.walk(root, 0)
+	r.crossCheckPathTable(pvd)
+	r.scanVolume(pvd)
+
+	var orphans []OrphanDirectory
+	for n, o := range r.report.Orphans {
+		if !r.absorbed[n+1] {
+			orphans = append(orphans, o)
+		}
+	}
+	r.report.Orphans = orphans
+	r.report.Damaged = img.Warnings()
+	return img, r.report, nil
+}
+
+// recovery salvages the directories of a damaged image
+type recovery struct {
+	image  *Image
+	root   *File
+	report *RecoveryReport
+
+	// visited maps the directories walked to the orphan they were reached from, 0 for the root directory
+	visited map[uint32]int
+	// absorbed marks the orphans reached from another one
+	absorbed map[int]bool
+	// occupied are the extents found, which aren't scanned for directories
+	occupied []sectorRange
+	// listed marks the first sectors of the directories listed in the path table
+	listed map[uint32]bool
+}
+
+// sectorRange is a range of sectors from start up to end, exclusive
+type sectorRange struct {
+	start, end uint32
+}
+
+// warn records an anomaly found while recovering
+func (r *recovery) warn(lba uint32, format string, args ...interface{}) {
+	r.image.warnings.add(ReaderWarning{LBA: lba, Reason: fmt.Sprintf(format, args...)})
+}
+
+// occupy marks the sectors of an extent as used
+func (r *recovery) occupy(location uint32, length int64) {
+	r.occupied = append(r.occupied, sectorRange{start: location, end: location + sizeToSectors(length)})
+}
+
+// walk lists the directory and its subdirectories, counting their entries if they are reachable from the root.
+// Orphans, numbered from 1, absorb the orphans found earlier which turn out to be their subdirectories.
+func (r *recovery) walk(dir *File, orphan int) {
+	pending := []*File{dir}
+	for len(pending) > 0 {
+		dir, pending = pending[0], pending[1:]
+		location := uint32(dir.de.ExtentLocation)
+		if owner, ok := r.visited[location]; ok {
+			if owner != orphan && owner != 0 {
+				r.absorb(owner)
+			}
+			continue
+		}
+		r.visited[location] = orphan
+		r.occupy(location, int64(dir.de.ExtendedAtributeRecordLength)*int64(sectorSize)+int64(dir.de.ExtentLength))
+		if orphan == 0 {
+			r.report.Directories++
+		}
+
+		children, err := dir.GetAllChildren()
+		if err != nil {
+			r.warn(location, "listing the directory %s: %v", dir.recordPath(), err)
+			continue
+		}
+		for _, c := range children {
+			if c.de.Identifier == string([]byte{0}) || c.de.Identifier == string([]byte{1}) {
+				continue
+			}
+			if c.IsDir() {
+				pending = append(pending, c)
+				continue
+			}
+			for _, de := range c.records() {
+				r.occupy(uint32(de.ExtentLocation), int64(de.ExtendedAtributeRecordLength)*int64(sectorSize)+int64(de.ExtentLength))
+			}
+			if orphan == 0 {
+				r.report.Files++
+			}
+		}
+	}
+}
+
+// absorb marks the orphan with the given number as reachable from another one
+func (r *recovery) absorb(orphan int) {
+	if r.absorbed == nil {
+		r.absorbed = make(map[int]bool)
+	}
+	r.absorbed[orphan] = true
+}
+
+// addOrphan records the directory at the given sector, unless it has been reached already, and walks it
+func (r *recovery) addOrphan(location uint32, identifier, dirPath string) {
+	if _, ok := r.visited[location]; ok {
+		return
+	}
+	dot, err := readDotEntry(r.image.ra, location, r.image.LogicalBlockSize())
+	if err != nil {
+		r.warn(location, "reading the directory %s: %v", identifier, err)
+		return
+	}
+	dot.Identifier = identifier
+	dir := &File{ra: r.image.ra, de: dot, options: r.image.options, imageSize: r.image.size, warnings: r.image.warnings,
+		joliet: r.root.joliet, susp: r.root.susp, blockSize: r.root.blockSize}
+	r.report.Orphans = append(r.report.Orphans, OrphanDirectory{Dir: dir, Path: dirPath, LBA: location})
+	r.walk(dir, len(r.report.Orphans))
+}
+
+// crossCheckPathTable adds the directories of the path table which the hierarchy doesn't reach as orphans,
+// and warns about the directories of the hierarchy missing from the path table.
+// The L path table is read, or the M one if it is damaged.
+func (r *recovery) crossCheckPathTable(pvd *PrimaryVolumeDescriptorBody) {
+	if pvd.PathTableSize <= 0 || pvd.PathTableSize > maxPathTableSize {
+		r.warn(0, "the path table size of %d bytes is invalid", pvd.PathTableSize)
+		return
+	}
+	table, err := r.image.readPathTable(pvd.TypeLPathTableLoc, pvd.PathTableSize, binary.LittleEndian)
+	if err != nil {
+		r.warn(uint32(pvd.TypeLPathTableLoc), "reading the L path table: %v", err)
+		if table, err = r.image.readPathTable(pvd.TypeMPathTableLoc, pvd.PathTableSize, binary.BigEndian); err != nil {
+			r.warn(uint32(pvd.TypeMPathTableLoc), "reading the M path table: %v", err)
+			return
+		}
+	}
+	for _, location := range []int32{pvd.TypeLPathTableLoc, pvd.TypeMPathTableLoc} {
+		r.occupy(uint32(location), int64(pvd.PathTableSize))
+	}
+
+	paths := make([]string, len(table))
+	for n, record := range table {
+		identifier := record.identifier
+		if r.root.joliet {
+			identifier = decodeJolietIdentifier(identifier)
+		}
+		switch {
+		case n == 0:
+			paths[n] = "/"
+		case int(record.parent) > n || record.parent == 0:
+			r.warn(record.location, "path table record %d %q has the parent %d, which doesn't precede it", n+1, identifier, record.parent)
+			paths[n] = "/" + identifier
+		default:
+			paths[n] = path.Join(paths[record.parent-1], identifier)
+		}
+		r.listed[record.location] = true
+		if n > 0 {
+			r.addOrphan(record.location, identifier, paths[n])
+		}
+	}
+
+	for location, orphan := range r.visited {
+		if orphan == 0 && !r.listed[location] {
+			r.warn(location, "the directory at sector %d is missing from the path table", location)
+		}
+	}
+}
+
+// scanVolume looks for directories which are neither reachable nor listed in the path table
+// in the sectors of the volume which aren't known to hold anything else
+func (r *recovery) scanVolume(pvd *PrimaryVolumeDescriptorBody) {
+	start := r.image.descriptorSector(len(r.image.volumeDescriptors))
+	end := uint32(pvd.VolumeSpaceSize)
+	if imageSectors := uint32(r.image.size / int64(sectorSize)); r.image.size > 0 && imageSectors < end {
+		end = imageSectors
+	}
+
+	// the sectors are scanned in order, so the extents ending before the current sector are done with
+	sort.Slice(r.occupied, func(a, b int) bool {
+		return r.occupied[a].start < r.occupied[b].start
+	})
+	next := 0
+	occupied := func(location uint32) bool {
+		for next < len(r.occupied) && r.occupied[next].end <= location {
+			next++
+		}
+		return next < len(r.occupied) && r.occupied[next].start <= location
+	}
+
+	ra := bypassCache(r.image.ra)
+	buffer := make([]byte, recoveryScanChunk*sectorSize)
+	for chunk := start; chunk < end; chunk += recoveryScanChunk {
+		sectors := end - chunk
+		if sectors > recoveryScanChunk {
+			sectors = recoveryScanChunk
+		}
+		data := buffer[:sectors*sectorSize]
+		if _, err := ra.ReadAt(data, int64(chunk)*int64(sectorSize)); err != nil {
+			r.warn(chunk, "scanning %d sectors for directories: %v", sectors, err)
+			continue
+		}
+		for n := uint32(0); n < sectors; n++ {
+			location := chunk + n
+			if occupied(location) {
+				continue
+			}
+			if isDirectoryStart(data[n*sectorSize:(n+1)*sectorSize], location) {
+				r.addOrphan(location, fmt.Sprintf("LBA%d", location), "")
+			}
+		}
+	}
+}
+
+// isDirectoryStart reports whether the sector at the given location begins with the "." and ".." records of a directory
+func isDirectoryStart(sector []byte, location uint32) bool {
+	var dot, dotdot DirectoryEntry
+	length := int(sector[0])
+	if length < 34 || int(sector[32]) != 1 || dot.UnmarshalBinary(sector[:length]) != nil {
+		return false
+	}
+	if dot.Identifier != string([]byte{0}) || dot.FileFlags&dirFlagDir == 0 || uint32(dot.ExtentLocation) != location {
+		return false
+	}
+	next := sector[length:]
+	if int(next[0]) < 34 || int(next[0]) > len(next) || int(next[32]) != 1 || dotdot.UnmarshalBinary(next[:next[0]]) != nil {
+		return false
+	}
+	return dotdot.Identifier == string([]byte{1}) && dotdot.FileFlags&dirFlagDir != 0
+}
+
+// recoverDirectoryRecords reads the extent of a damaged directory one sector at a time, like streamDirectoryRecords,
+// but skips the sectors which cannot be read and the rest of a sector from a malformed record on, with a warning
+func (f *File) recoverDirectoryRecords() (directoryRecords, int, error) {
+	sectors := fileLengthToSectors(f.de.ExtentLength)
+	location := f.de.dataOffset(f.logicalBlockSize())
+	first := uint32(location / int64(sectorSize))
+	buffer := make([]byte, sectorSize)
+	warn := func(sector uint32, format string, args ...interface{}) {
+		f.warnings.add(ReaderWarning{Path: f.recordPath(), LBA: first + sector, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	var packed []byte
+	var offsets []int
+	for sector := uint32(0); sector < sectors; sector++ {
+		offset := location + int64(sector)*int64(sectorSize)
+		if f.imageSize > 0 && offset+int64(sectorSize) > f.imageSize {
+			warn(sector, "the directory extent of %d sectors ends beyond the image, %d sectors were read", sectors, sector)
+			break
+		}
+		if _, err := f.ra.ReadAt(buffer, offset); err != nil {
+			warn(sector, "skipping an unreadable directory sector: %v", err)
+			continue
+		}
+		for i := uint32(0); i < sectorSize && buffer[i] != 0; {
+			length := uint32(buffer[i])
+			if problem := recordProblem(buffer[i:]); problem != "" {
+				warn(sector, "skipping the records from offset %d: %s", i, problem)
+				break
+			}
+			offsets = append(offsets, int(sector*sectorSize+i))
+			packed = append(packed, buffer[i:i+length]...)
+			i += length
+		}
+	}
+
+	return func(fn func(offset int, record []byte) error) error {
+		start := 0
+		for _, offset := range offsets {
+			end := start + int(packed[start])
+			if err := fn(offset, packed[start:end]); err != nil {
+				return err
+			}
+			start = end
+		}
+		return nil
+	}, len(offsets), nil
+}
+
+// recordProblem tells why the directory record at the beginning of data is malformed, or returns an empty string
+func recordProblem(data []byte) string {
+	length := int(data[0])
+	switch {
+	case length > len(data):
+		return "the record crosses the sector boundary"
+	case length < 34:
+		return fmt.Sprintf("the record of %d bytes is too short", length)
+	case 33+int(data[32]) > length:
+		return fmt.Sprintf("the identifier of %d bytes exceeds the record", data[32])
+	}
+	return ""
+}
+
+// recovering reports whether the image was opened to recover what is intact, see ReaderOptions.Recover
+func (f *File) recovering() bool {
+	return f.options != nil && f.options.Recover
+}
@@ -0,0 +1,145 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// damagedImage writes an image with /ALPHA/BETA/DATA.TXT and /ROOT.TXT and breaks the record of ALPHA in the root
+func damagedImage(t *testing.T) []byte {
+	iw, err := NewWriter()
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+	require.NoError(t, iw.AddFile(strings.NewReader("deep data"), "ALPHA/BETA/DATA.TXT"))
+	require.NoError(t, iw.AddFile(strings.NewReader("root data"), "ROOT.TXT"))
+	var buf bytes.Buffer
+	require.NoError(t, iw.WriteTo(&buf, "damaged"))
+	image := buf.Bytes()
+
+	rootLocation := binary.LittleEndian.Uint32(image[16*sectorSize+156+2:])
+	root := image[rootLocation*sectorSize : (rootLocation+1)*sectorSize]
+	at := bytes.Index(root, []byte("\x05ALPHA"))
+	require.Greater(t, at, 32)
+	// the identifier no longer fits into the record
+	root[at] = 200
+	return image
+}
+
+func readRecovered(t *testing.T, f *File) string {
+	data, err := io.ReadAll(f.Reader())
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestOpenImageRecoverPathTable(t *testing.T) {
+	img, report, err := OpenImageRecover(bytes.NewReader(damagedImage(t)), ReaderOptions{})
+	require.NoError(t, err)
+
+	// the rest of the root's sector is lost with the record
+	assert.Equal(t, 1, report.Directories)
+	assert.Equal(t, 0, report.Files)
+	require.Len(t, report.Orphans, 1)
+	orphan := report.Orphans[0]
+	assert.Equal(t, "/ALPHA", orphan.Path)
+	assert.Equal(t, "ALPHA", orphan.Dir.Name())
+
+	children, err := orphan.Dir.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	assert.Equal(t, "BETA", children[0].Name())
+	files, err := children[0].GetChildren()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "deep data", readRecovered(t, files[0]))
+
+	require.NotEmpty(t, report.Damaged)
+	assert.Contains(t, report.Damaged[0].Reason, "skipping the records from offset")
+	assert.Equal(t, report.Damaged, img.Warnings())
+}
+
+func TestOpenImageRecoverScan(t *testing.T) {
+	image := damagedImage(t)
+	pvd := image[16*sectorSize:]
+	for _, location := range []uint32{binary.LittleEndian.Uint32(pvd[140:144]), binary.BigEndian.Uint32(pvd[148:152])} {
+		copy(image[location*sectorSize:(location+1)*sectorSize], make([]byte, sectorSize))
+	}
+
+	_, report, err := OpenImageRecover(bytes.NewReader(image), ReaderOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Orphans, 1)
+	orphan := report.Orphans[0]
+	assert.Empty(t, orphan.Path)
+	assert.True(t, strings.HasPrefix(orphan.Dir.Name(), "LBA"))
+	children, err := orphan.Dir.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	assert.Equal(t, "BETA", children[0].Name())
+}
+
+// failingSectorReaderAt fails the reads which touch the given sector
+type failingSectorReaderAt struct {
+	io.ReaderAt
+	sector int64
+}
+
+func (r *failingSectorReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	start, end := r.sector*int64(sectorSize), (r.sector+1)*int64(sectorSize)
+	if off < end && off+int64(len(p)) > start {
+		return 0, errors.New("unreadable sector")
+	}
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+func TestOpenImageRecoverUnreadableSector(t *testing.T) {
+	iw, err := NewWriter()
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+	for n := 0; n < 100; n++ {
+		require.NoError(t, iw.AddFile(strings.NewReader("data"), strings.Repeat("F", 20)+string(rune('A'+n/26))+string(rune('A'+n%26))))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, iw.WriteTo(&buf, "damaged"))
+	image := buf.Bytes()
+
+	img, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	require.Greater(t, root.de.ExtentLength, sectorSize)
+
+	ra := &failingSectorReaderAt{ReaderAt: bytes.NewReader(image), sector: int64(root.de.ExtentLocation) + 1}
+	_, err = OpenImage(ra)
+	require.NoError(t, err)
+	img, err = OpenImage(ra)
+	require.NoError(t, err)
+	root, err = img.RootDir()
+	require.NoError(t, err)
+	_, err = root.GetChildren()
+	assert.Error(t, err)
+
+	img, report, err := OpenImageRecover(ra, ReaderOptions{})
+	require.NoError(t, err)
+	assert.Greater(t, report.Files, 0)
+	assert.Less(t, report.Files, 100)
+	assert.Empty(t, report.Orphans)
+	require.NotEmpty(t, report.Damaged)
+	assert.Contains(t, report.Damaged[0].Reason, "unreadable directory sector")
+	assert.Equal(t, uint32(ra.sector), report.Damaged[0].LBA)
+
+	root, err = img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	assert.Len(t, children, report.Files)
+	assert.Equal(t, "data", readRecovered(t, children[0]))
+}
@@ -43,5 +43,5 @@ func TestRegressionNameWithoutDot(t *testing.T) {
 	assert.NoError(t, err)
 
 	nodotfile := children[0]
-	assert.Equal(t, "nodot", nodotfile.Name())
+	assert.Equal(t, "NODOT", nodotfile.Name())
 }
@@ -0,0 +1,235 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// NewWriterFromImage creates an ImageWriter whose staging area is seeded with the contents of an existing image.
+// The file data is read from the source image during WriteTo, so the source must remain readable until then.
+//
+// The source's settings carry over and can be overridden before writing: the volume metadata with SetVolumeMetadata,
+// the volume set with SetVolumeSet, Rock Ridge and its identifier with SetRockRidge and SetRockRidgeIdentifier,
+// and the El Torito boot entries with ClearBootEntries and AddBootEntry. Boot images missing from the directory tree
+// are staged as hidden files at the root and the file listing the source's boot catalog is dropped.
+func NewWriterFromImage(img *Image) (*ImageWriter, error) {
+	iw, err := newWriterFromImage(img)
+	if err != nil {
+		return nil, err
+	}
+	if err = iw.importBootCatalog(img); err != nil {
+		_ = iw.Cleanup()
+		return nil, err
+	}
+	return iw, nil
+}
+
+// newWriterFromImage creates an ImageWriter seeded with the contents of an existing image, without its boot catalog,
+// for the writers which keep the source's Boot Record
+func newWriterFromImage(img *Image) (*ImageWriter, error) {
+	root, err := img.RootDir()
+	if err != nil {
+		return nil, err
+	}
+
+	volume, err := img.VolumeMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	iw, err := NewWriter()
+	if err != nil {
+		return nil, err
+	}
+
+	iw.volume = volume
+	if set, err := img.VolumeSet(); err == nil && set.validate() == nil {
+		iw.volumeSetSize = set.Size
+		iw.volumeSequence = set.SequenceNumber
+	}
+	// logical blocks larger than a sector aren't written
+	_ = iw.SetLogicalBlockSize(int(img.LogicalBlockSize()))
+
+	// reading the root's children also detects SUSP and Rock Ridge
+	dot, err := root.GetDotEntry()
+	if err != nil {
+		_ = iw.Cleanup()
+		return nil, fmt.Errorf("reading root directory: %w", err)
+	}
+	iw.rockRidge = root.hasRockRidge()
+	if extensions, err := dot.de.SystemUseEntries.GetExtensionRecords(); err == nil {
+		for _, er := range extensions {
+			switch {
+			case er.Identifier == RockRidgeIdentifierP1282:
+				iw.rrip = er.Identifier
+			case !slices.Contains(RockRidgeIdentifiers, er.Identifier) && iw.rockRidge:
+				// the other extensions are announced again, their entries are carried over with the files
+				iw.extensions = append(iw.extensions, *er)
+			}
+		}
+	}
+
+	iw.root = stagedEntryFromFile(dot)
+	iw.root.name = ""
+	iw.root.details.identifier = ""
+
+	if err = importDirectory(iw.root, root); err != nil {
+		_ = iw.Cleanup()
+		return nil, err
+	}
+
+	return iw, nil
+}
+
+// importDirectory stages the children of an image's directory into the given staged directory
+func importDirectory(dst *stagedEntry, src *File) error {
+	// hidden entries are carried over regardless of the reader options
+	children, err := src.getChildren(true)
+	if err != nil {
+		return fmt.Errorf("reading directory %s: %w", dst.path(), err)
+	}
+
+	for _, c := range children {
+		entry := stagedEntryFromFile(c)
+		if dst.child(entry.name) != nil {
+			// the older versions of a file, which follow the latest, are staged with their versions, see SetFileVersions
+			if version, ok := c.Version(); ok {
+				entry.name += ";" + strconv.Itoa(int(version))
+			}
+		}
+		if dst.child(entry.name) != nil {
+			return fmt.Errorf("directory %s contains %q more than once", dst.path(), entry.name)
+		}
+		dst.addChild(entry)
+
+		if entry.isDir() {
+			if err = importDirectory(entry, c); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// stagedEntryFromFile creates a staged entry backed by the file's extent in the source image
+func stagedEntryFromFile(f *File) *stagedEntry {
+	entry := &stagedEntry{
+		name:    f.Name(),
+		mode:    f.Mode(),
+		modTime: f.ModTime(),
+		hidden:  f.IsHidden(),
+		origin:  "the source image",
+		details: &entryDetails{identifier: f.de.Identifier},
+	}
+
+	if f.hasRockRidge() {
+		// the entries of other extensions, which the writer doesn't generate
+		entry.edit().systemUse = append([]SystemUseEntry(nil), f.de.SystemUseEntries.Unknown()...)
+	}
+	if px, err := f.de.SystemUseEntries.getPosixEntry(); f.hasRockRidge() && err == nil {
+		entry.uid = px.uid
+		entry.gid = px.gid
+	} else {
+		// without PX there are no permissions to carry over
+		if entry.mode.IsDir() {
+			entry.mode |= 0755
+		} else {
+			entry.mode |= 0644
+		}
+	}
+
+	switch {
+	case entry.mode&os.ModeSymlink != 0:
+		entry.edit().symlinkTarget = f.de.SystemUseEntries.GetSymlinkTarget()
+	case entry.mode&os.ModeDevice != 0:
+		d := entry.edit()
+		d.devMajor, d.devMinor, _ = f.DeviceNumber()
+	case entry.mode&fs.ModeType == 0:
+		extent := imageExtentSource{
+			ra:       f.dataReaderAt(bypassCache(f.ra)),
+			size:     f.dataLength(),
+			location: f.contiguousLocation(),
+		}
+		// the ZF and SF entries aren't carried over, so compressed data is written decompressed unless compressed again
+		// and the holes of sparse files are written as zeroes
+		if sf := f.sparseInfo(); sf != nil {
+			entry.source = &sparseFileSource{ra: bypassCache(f.ra), root: uint32(f.de.ExtentLocation), info: sf}
+		} else if zf := f.zisofsInfo(); zf != nil {
+			entry.source = &zisofsExtentSource{compressed: extent, info: zf}
+		} else {
+			entry.source = &extent
+		}
+	}
+
+	return entry
+}
+
+// importBootCatalog adds the entries of the image's boot catalog to the ImageWriter seeded with its directory tree
+func (iw *ImageWriter) importBootCatalog(img *Image) error {
+	location, ok := img.BootCatalogLocation()
+	if !ok {
+		return nil
+	}
+	images, err := img.BootImages()
+	if err != nil {
+		return err
+	}
+
+	files, err := img.filesByLocation()
+	if err != nil {
+		return err
+	}
+	if catalog, ok := files[location]; ok {
+		// the written catalog isn't listed in the directory tree
+		if err := iw.Remove(catalog.path); err != nil {
+			return err
+		}
+	}
+
+	for n, boot := range images {
+		isoPath := boot.Path
+		if isoPath == "" {
+			for suffix := n; ; suffix++ {
+				isoPath = fmt.Sprintf("/boot%d.img", suffix)
+				if iw.lookup(isoPath) == nil {
+					break
+				}
+			}
+			if err := iw.AddFile(boot.Reader, isoPath); err != nil {
+				return err
+			}
+			if err := iw.SetHidden(isoPath, true); err != nil {
+				return err
+			}
+		}
+
+		opts := []BootOption{WithBootLoadSegment(boot.Entry.LoadSegment)}
+		if boot.Entry.Emulation == BootNoEmulation {
+			opts = append(opts, WithBootLoadSize(boot.Entry.SectorCount))
+			if hasBootInfoTable(boot.Reader, boot.Entry.LBA) {
+				opts = append(opts, WithBootInfoTable())
+			}
+		}
+		if err := iw.AddBootEntry(boot.Entry.PlatformID, boot.Entry.Emulation, isoPath, opts...); err != nil {
+			return err
+		}
+		iw.bootEntries[len(iw.bootEntries)-1].Bootable = boot.Entry.Bootable
+	}
+	return nil
+}
+
+// hasBootInfoTable reports whether the boot image at the given sector carries a boot info table
+// pointing to the Primary Volume Descriptor and to the image itself
+func hasBootInfoTable(r io.ReaderAt, lba uint32) bool {
+	table := make([]byte, 8)
+	if _, err := r.ReadAt(table, bootInfoTableOffset); err != nil {
+		return false
+	}
+	return binary.LittleEndian.Uint32(table[0:4]) == systemAreaSize/sectorSize && binary.LittleEndian.Uint32(table[4:8]) == lba
+}
@@ -0,0 +1,189 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type snapshotEntry struct {
+	Mode   os.FileMode
+	Size   int64
+	SHA256 string
+	Target string
+}
+
+// snapshotImage walks the whole image and records the observable state of every entry
+func snapshotImage(t *testing.T, img *Image) map[string]snapshotEntry {
+	result := make(map[string]snapshotEntry)
+	for p, f := range filesByPath(t, img) {
+		if p == "/" {
+			continue
+		}
+		e := snapshotEntry{Mode: f.Mode(), Size: f.Size()}
+		if f.IsDir() {
+			e.Size = 0
+		} else if f.Mode()&os.ModeSymlink != 0 {
+			e.Target = f.de.SystemUseEntries.GetSymlinkTarget()
+		} else {
+			h := sha256.New()
+			_, err := io.Copy(h, f.Reader())
+			require.NoError(t, err)
+			e.SHA256 = hex.EncodeToString(h.Sum(nil))
+		}
+		result[p] = e
+	}
+	return result
+}
+
+func remaster(t *testing.T, iw *ImageWriter) *Image {
+	var buf bytes.Buffer
+	require.NoError(t, iw.WriteTo(&buf, ""))
+
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	// every image written by the tests has to pass the structural check
+	report, err := img.Verify(WithFileData())
+	require.NoError(t, err)
+	assert.Empty(t, report.Findings)
+	return img
+}
+
+func TestRemasterRoundTrip(t *testing.T) {
+	for _, fixture := range []string{"fixtures/test.iso", "fixtures/test_rockridge.iso"} {
+		t.Run(fixture, func(t *testing.T) {
+			f, err := os.Open(fixture)
+			require.NoError(t, err)
+			defer f.Close() // nolint: errcheck
+
+			source, err := OpenImage(f)
+			require.NoError(t, err)
+
+			iw, err := NewWriterFromImage(source)
+			require.NoError(t, err)
+			defer iw.Cleanup() // nolint: errcheck
+
+			result := remaster(t, iw)
+
+			label, err := result.Label()
+			assert.NoError(t, err)
+			assert.Equal(t, "my-vol-id", label)
+
+			pvd, err := result.primaryVolume()
+			require.NoError(t, err)
+			assert.Equal(t, "gopher", pvd.PublisherIdentifier)
+			assert.Equal(t, "test-volset-id", pvd.VolumeSetIdentifier)
+
+			assert.Equal(t, snapshotImage(t, source), snapshotImage(t, result))
+		})
+	}
+}
+
+func TestRemasterRockRidgeSymlink(t *testing.T) {
+	f, err := os.Open("fixtures/test_rockridge.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	source, err := OpenImage(f)
+	require.NoError(t, err)
+
+	iw, err := NewWriterFromImage(source)
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+
+	snapshot := snapshotImage(t, remaster(t, iw))
+	assert.Equal(t, "/usr/share/some-random-directory/even-deeper-path/symlink-target", snapshot["/this-is-a-symlink"].Target)
+	assert.Equal(t, os.FileMode(0640), snapshot["/dir1/lorem_ipsum.txt"].Mode)
+}
+
+func TestRemasterWithChanges(t *testing.T) {
+	f, err := os.Open("fixtures/test_rockridge.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	source, err := OpenImage(f)
+	require.NoError(t, err)
+
+	iw, err := NewWriterFromImage(source)
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+
+	assert.NoError(t, iw.Remove("dir4"))
+	assert.NoError(t, iw.Rename("cicero.txt", "docs/Cicero.txt"))
+	assert.NoError(t, iw.AddFile(strings.NewReader("install --text"), "ks.cfg"))
+
+	assert.ErrorIs(t, iw.Remove("does-not-exist"), os.ErrNotExist)
+	assert.Error(t, iw.Rename("dir2", "dir2/dir3/inside"))
+	assert.Error(t, iw.Rename("dir1", "dir2"))
+
+	metadata := iw.VolumeMetadata()
+	metadata.VolumeIdentifier = "remastered"
+	iw.SetVolumeMetadata(metadata)
+
+	result := remaster(t, iw)
+
+	label, err := result.Label()
+	assert.NoError(t, err)
+	assert.Equal(t, "remastered", label)
+
+	expected := snapshotImage(t, source)
+	for p := range expected {
+		if strings.HasPrefix(p, "/dir4") {
+			delete(expected, p)
+		}
+	}
+	expected["/docs/Cicero.txt"] = expected["/cicero.txt"]
+	delete(expected, "/cicero.txt")
+
+	actual := snapshotImage(t, result)
+	assert.Equal(t, "f68fa7354ecdfed64ed216a29013aea3f2141b337a3cf3dcfacad029bacc1e93", actual["/ks.cfg"].SHA256)
+	delete(actual, "/ks.cfg")
+	delete(actual, "/docs")
+
+	assert.Equal(t, expected, actual)
+}
+
+func TestRemasterBootCatalog(t *testing.T) {
+	image, _, _ := bootableImage(t)
+	source, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+
+	w, err := NewWriterFromImage(source)
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.Remove("payload.txt"))
+	require.NoError(t, w.AddFile(strings.NewReader("replaced"), "payload.txt"))
+	img := remaster(t, w)
+
+	// the entries boot from the files at their new locations, and the stale catalog file is gone
+	byPath := filesByPath(t, img)
+	assert.NotContains(t, byPath, "/boot/boot.cat")
+	entries, err := img.BootEntries()
+	require.NoError(t, err)
+	assert.Equal(t, []BootEntry{
+		{PlatformID: BootPlatformX86, Bootable: true, SectorCount: 4, LBA: uint32(byPath["/boot/loader.bin"].de.ExtentLocation)},
+		{PlatformID: BootPlatformEFI, Bootable: true, SectorCount: 1, LBA: uint32(byPath["/boot/efi.img"].de.ExtentLocation)},
+	}, entries)
+	diff, err := DiffBoot(source, img)
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+
+	w, err = NewWriterFromImage(source)
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.ClearBootEntries())
+	entries, err = remaster(t, w).BootEntries()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,78 @@
+package iso9660
+
+import (
+	"fmt"
+	"io"
+)
+
+// RepackOption configures Repack
+type RepackOption func(*repackOptions)
+
+type repackOptions struct {
+	sortWeight func(isoPath string) int
+}
+
+// WithRepackSortWeight places the extents of the files in the order of their weights, see ImageWriter.SetSortWeight.
+// By default they follow the order of their directories.
+func WithRepackSortWeight(fn func(isoPath string) int) RepackOption {
+	return func(o *repackOptions) {
+		o.sortWeight = fn
+	}
+}
+
+// Repack reads the image from src and writes a compacted copy of it to dst. Only the extents reachable
+// from the directory tree are copied, so those of deleted files and previous sessions are dropped,
+// the directories are written anew without the gaps left by editing, and the Rock Ridge entries are
+// generated again in a single consistent form. The file data and metadata, the volume metadata and dates,
+// the Joliet and Enhanced volumes, an implanted MD5 checksum and the El Torito boot catalog are preserved.
+//
+// Boot images which aren't listed in the directory tree are staged as hidden files at the root,
+// a file listing the source's boot catalog is dropped and boot info tables are patched for the new locations.
+// A system area holding an MBR partition table describes the layout of the source and isn't carried over.
+// zisofs-compressed files are written decompressed.
+func Repack(src io.ReaderAt, dst io.Writer, opts ...RepackOption) error {
+	var o repackOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	img, err := OpenImage(src)
+	if err != nil {
+		return err
+	}
+	iw, err := NewWriterFromImage(img)
+	if err != nil {
+		return err
+	}
+	defer iw.Cleanup() // nolint: errcheck
+
+	for _, vd := range img.volumeDescriptors {
+		switch {
+		case vd.problem != "":
+		case vd.joliet > 0:
+			iw.SetJoliet(true)
+		case vd.isEnhanced():
+			iw.SetEnhancedVolume(true)
+		}
+	}
+	if times, err := img.VolumeTimes(); err == nil {
+		// the modification date is that of the repacked image
+		iw.SetVolumeTimes(VolumeTimes{Creation: times.Creation, Expiration: times.Expiration, Effective: times.Effective})
+	}
+	if _, err := img.ImplantedMD5(); err == nil {
+		iw.SetImplantMD5(true)
+	}
+
+	systemArea := make([]byte, systemAreaSize)
+	if _, err := img.ra.ReadAt(systemArea, 0); err != nil {
+		return fmt.Errorf("reading the system area: %w", err)
+	}
+	if systemArea[510] != 0x55 || systemArea[511] != 0xAA {
+		if err := iw.SetSystemArea(systemArea); err != nil {
+			return err
+		}
+	}
+
+	iw.SetSortWeight(o.sortWeight)
+	return iw.WriteTo(dst, "")
+}
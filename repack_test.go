@@ -0,0 +1,142 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeAndRemove writes the image to a file and removes a file from it in place
+func writeAndRemove(t *testing.T, w *ImageWriter, volumeIdentifier, isoPath string) []byte {
+	imagePath := path.Join(t.TempDir(), "source.iso")
+	f, err := os.Create(imagePath)
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+	require.NoError(t, w.WriteTo(f, volumeIdentifier))
+
+	e, err := OpenImageEditor(f)
+	require.NoError(t, err)
+	defer e.Cleanup() // nolint: errcheck
+	require.NoError(t, e.Remove(isoPath))
+	require.NoError(t, e.Commit(""))
+
+	data, err := os.ReadFile(imagePath)
+	require.NoError(t, err)
+	return data
+}
+
+func TestRepack(t *testing.T) {
+	loader := make([]byte, 4096)
+	copy(loader, "isolinux")
+
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Joliet: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(bytes.NewReader(loader), "isolinux/isolinux.bin"))
+	require.NoError(t, w.AddFile(strings.NewReader(strings.Repeat("big", 100000)), "data/big.bin"))
+	require.NoError(t, w.AddFile(strings.NewReader("kept"), "data/kept.txt"))
+	require.NoError(t, w.AddFile(strings.NewReader("a long Rock Ridge name"), "data/"+strings.Repeat("long-", 40)+"name.txt"))
+	require.NoError(t, w.AddBootEntry(BootPlatformX86, BootNoEmulation, "isolinux/isolinux.bin", WithBootLoadSize(4), WithBootInfoTable()))
+
+	// removing a file in place leaves its extent behind
+	source := writeAndRemove(t, w, "REPACK", "data/big.bin")
+	var repacked bytes.Buffer
+	require.NoError(t, Repack(bytes.NewReader(source), &repacked))
+	assert.Less(t, repacked.Len(), len(source)-200000)
+
+	before, err := OpenImage(bytes.NewReader(source))
+	require.NoError(t, err)
+	after, err := OpenImage(bytes.NewReader(repacked.Bytes()))
+	require.NoError(t, err)
+	report, err := after.Verify(WithFileData())
+	require.NoError(t, err)
+	assert.Empty(t, report.Findings)
+
+	// only the boot info table of the loader changes
+	diff, err := Diff(before, after)
+	require.NoError(t, err)
+	require.Len(t, diff, 1)
+	assert.Equal(t, "/isolinux/isolinux.bin", diff[0].Path)
+	assert.Equal(t, DiffContent, diff[0].Changes)
+	bootDiff, err := DiffBoot(before, after)
+	require.NoError(t, err)
+	require.Len(t, bootDiff, 1)
+	assert.Equal(t, DiffContent, bootDiff[0].Changes)
+
+	metadata, err := after.VolumeMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, "REPACK", metadata.VolumeIdentifier)
+	vds := after.VolumeDescriptors()
+	require.Len(t, vds, 4)
+	assert.Equal(t, 3, vds[2].JolietLevel)
+
+	// the boot info table points to the new location of the loader
+	entries, err := after.BootEntries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	data := make([]byte, len(loader))
+	_, err = after.ra.ReadAt(data, int64(entries[0].LBA)*int64(sectorSize))
+	require.NoError(t, err)
+	assert.Equal(t, entries[0].LBA, binary.LittleEndian.Uint32(data[bootInfoTableOffset+4:]))
+	assert.Equal(t, loader[bootInfoTableOffset+bootInfoTableSize:], data[bootInfoTableOffset+bootInfoTableSize:])
+}
+
+func TestRepackSortWeight(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, ImplantMD5: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	for _, p := range []string{"a.txt", "b.txt", "c.txt"} {
+		require.NoError(t, w.AddFile(strings.NewReader(p), p))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, ""))
+
+	var repacked bytes.Buffer
+	require.NoError(t, Repack(bytes.NewReader(buf.Bytes()), &repacked, WithRepackSortWeight(func(isoPath string) int {
+		if isoPath == "/c.txt" {
+			return 1
+		}
+		return 0
+	})))
+	img, err := OpenImage(bytes.NewReader(repacked.Bytes()))
+	require.NoError(t, err)
+	files := filesByPath(t, img)
+	assert.Less(t, files["/c.txt"].de.ExtentLocation, files["/a.txt"].de.ExtentLocation)
+	assert.Less(t, files["/a.txt"].de.ExtentLocation, files["/b.txt"].de.ExtentLocation)
+
+	ok, err := img.VerifyImplantedMD5(nil)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestRepackUnlistedBootImage(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(bytes.NewReader(bytes.Repeat([]byte("efi"), 1000)), "efi.img"))
+	require.NoError(t, w.AddBootEntry(BootPlatformEFI, BootNoEmulation, "efi.img"))
+	// the catalog still points to the extent of the removed file
+	source := writeAndRemove(t, w, "", "efi.img")
+	var repacked bytes.Buffer
+	require.NoError(t, Repack(bytes.NewReader(source), &repacked))
+	before, err := OpenImage(bytes.NewReader(source))
+	require.NoError(t, err)
+	after, err := OpenImage(bytes.NewReader(repacked.Bytes()))
+	require.NoError(t, err)
+
+	bootDiff, err := DiffBoot(before, after)
+	require.NoError(t, err)
+	assert.Empty(t, bootDiff)
+	files := filesByPath(t, after)
+	require.Contains(t, files, "/boot0.img")
+	assert.True(t, files["/boot0.img"].IsHidden())
+}
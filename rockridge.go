@@ -1,33 +1,306 @@
 package iso9660
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"slices"
+	"strings"
+	"time"
 )
 
 /* The following types of Rock Ridge records are being handled in some way:
  * - [X] PX (RR 4.1.1: POSIX file attributes)
- * - [ ] PN (RR 4.1.2: POSIX device number)
- * - [ ] SL (RR 4.1.3: symbolic link)
+ * - [x] PN (RR 4.1.2: POSIX device number)
+ * - [x] SL (RR 4.1.3: symbolic link)
  * - [x] NM (RR 4.1.4: alternate name)
- * - [ ] CL (RR 4.1.5.1: child link)
- * - [ ] PL (RR 4.1.5.2: parent link)
- * - [ ] RE (RR 4.1.5.3: relocated directory)
- * - [ ] TF (RR 4.1.6: time stamp(s) for a file)
- * - [ ] SF (RR 4.1.7: file data in sparse file format)
+ * - [x] CL (RR 4.1.5.1: child link)
+ * - [x] PL (RR 4.1.5.2: parent link)
+ * - [x] RE (RR 4.1.5.3: relocated directory)
+ * - [x] TF (RR 4.1.6: time stamp(s) for a file)
+ * - [x] SF (RR 4.1.7: file data in sparse file format)
  */
 
-var RockRidgeIdentifiers = []string{"IEEE_P1282", "RRIP_1991A"}
+// Extension identifiers of Rock Ridge in the ER entry
+const (
+	// RockRidgeIdentifier1991A is used by RRIP 1.09 and 1.10 and written by mkisofs
+	RockRidgeIdentifier1991A = "RRIP_1991A"
+	// RockRidgeIdentifierP1282 is used by RRIP 1.12
+	RockRidgeIdentifierP1282 = "IEEE_P1282"
+)
+
+var RockRidgeIdentifiers = []string{RockRidgeIdentifierP1282, RockRidgeIdentifier1991A}
+
+// rockRidgeExtensionRecord returns the ER entry content announcing Rock Ridge with the given identifier,
+// with the descriptor and source texts of the respective RRIP version
+func rockRidgeExtensionRecord(identifier string) (*ExtensionRecord, error) {
+	switch identifier {
+	case RockRidgeIdentifier1991A:
+		return &ExtensionRecord{
+			Version:    RockRidgeVersion,
+			Identifier: identifier,
+			Descriptor: "THE ROCK RIDGE INTERCHANGE PROTOCOL PROVIDES SUPPORT FOR POSIX FILE SYSTEM SEMANTICS",
+			Source:     "PLEASE CONTACT DISC PUBLISHER FOR SPECIFICATION SOURCE.  SEE PUBLISHER IDENTIFIER IN PRIMARY VOLUME DESCRIPTOR FOR CONTACT INFORMATION.",
+		}, nil
+	case RockRidgeIdentifierP1282:
+		return &ExtensionRecord{
+			Version:    RockRidgeVersion,
+			Identifier: identifier,
+			Descriptor: "THE IEEE P1282 PROTOCOL PROVIDES SUPPORT FOR POSIX FILE SYSTEM SEMANTICS.",
+			Source:     "PLEASE CONTACT THE IEEE STANDARDS DEPARTMENT, PISCATAWAY, NJ, USA FOR THE P1282 SPECIFICATION.",
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown Rock Ridge identifier %q", identifier)
+	}
+}
 
 const RockRidgeVersion = 1
 
+// RockRidgeRevision is the revision of RRIP an image follows, see Image.RockRidgeRevision
+type RockRidgeRevision int
+
+const (
+	// RockRidgeRevisionUnknown means that the image doesn't use Rock Ridge
+	RockRidgeRevisionUnknown RockRidgeRevision = iota
+	// RockRidge109 is RRIP 1.09 or 1.10, identified as RRIP_1991A. Every record with Rock Ridge entries
+	// starts with an RR entry listing them and the PX entries don't have a file serial number.
+	RockRidge109
+	// RockRidge112 is RRIP 1.12, identified as IEEE_P1282. There are no RR entries,
+	// the PX entries have a file serial number and the SF entries a 64-bit size.
+	RockRidge112
+)
+
+func (r RockRidgeRevision) String() string {
+	switch r {
+	case RockRidge109:
+		return "RRIP 1.09"
+	case RockRidge112:
+		return "RRIP 1.12"
+	}
+	return "unknown"
+}
+
+// Identifier returns the extension identifier the ER entry of the revision has
+func (r RockRidgeRevision) Identifier() string {
+	switch r {
+	case RockRidge109:
+		return RockRidgeIdentifier1991A
+	case RockRidge112:
+		return RockRidgeIdentifierP1282
+	}
+	return ""
+}
+
+// RockRidgeFlags are the flags of the RR entry of RRIP 1.09, which tell the Rock Ridge entries recorded for a file
+type RockRidgeFlags byte
+
+const (
+	RockRidgeFlagPX RockRidgeFlags = 1 << iota
+	RockRidgeFlagPN
+	RockRidgeFlagSL
+	RockRidgeFlagNM
+	RockRidgeFlagCL
+	RockRidgeFlagPL
+	RockRidgeFlagRE
+	RockRidgeFlagTF
+)
+
+// rockRidgeFlagSignatures are the signatures of the entries in the order of their flags
+var rockRidgeFlagSignatures = []string{"PX", "PN", "SL", "NM", "CL", "PL", "RE", "TF"}
+
+func (f RockRidgeFlags) String() string {
+	var signatures []string
+	for bit, signature := range rockRidgeFlagSignatures {
+		if f&(1<<bit) != 0 {
+			signatures = append(signatures, signature)
+		}
+	}
+	return strings.Join(signatures, ",")
+}
+
+// GetRockRidgeFlags decodes the RR entry. The last return value is false if there is none,
+// as in images following RRIP 1.12 or written by tools which leave it out.
+func (s SystemUseEntrySlice) GetRockRidgeFlags() (RockRidgeFlags, bool) {
+	for _, entry := range s {
+		if entry.Type() == "RR" && len(entry.Data()) >= 1 {
+			return RockRidgeFlags(entry.Data()[0]), true
+		}
+	}
+	return 0, false
+}
+
+// rockRidgeFlagsOf returns the flags of the Rock Ridge entries among the given ones
+func rockRidgeFlagsOf(entries []SystemUseEntry) RockRidgeFlags {
+	var flags RockRidgeFlags
+	for _, entry := range entries {
+		if bit := slices.Index(rockRidgeFlagSignatures, entry.Type()); bit >= 0 {
+			flags |= 1 << bit
+		}
+	}
+	return flags
+}
+
+// marshalRockRidgeFlagsEntry encodes the RR entry listing the Rock Ridge entries among the given ones
+func marshalRockRidgeFlagsEntry(entries []SystemUseEntry) SystemUseEntry {
+	return newSystemUseEntry("RR", 1, []byte{byte(rockRidgeFlagsOf(entries))})
+}
+
+// POSIX file type bits as used in the RR PX entry (see POSIX 5.6.1)
+const (
+	posixTypeMask   = 0170000
+	posixSocket     = 0140000
+	posixSymlink    = 0120000
+	posixRegular    = 0100000
+	posixBlockDev   = 0060000
+	posixDirectory  = 0040000
+	posixCharDev    = 0020000
+	posixFIFO       = 0010000
+	posixSetuid     = 0004000
+	posixSetgid     = 0002000
+	posixSticky     = 0001000
+	posixPermission = 0000777
+)
+
 type RockRidgeNameEntry struct {
 	Flags byte
 	Name  string
 }
 
+// RockRidgeDetection tells how Rock Ridge was found to be in use, see Image.RockRidgeDetection
+type RockRidgeDetection int
+
+const (
+	// RockRidgeNotDetected means that the image doesn't use Rock Ridge, or that SUSP isn't in use at all
+	RockRidgeNotDetected RockRidgeDetection = iota
+	// RockRidgeByExtensionRecord means that the root's "." record announces Rock Ridge with an ER entry
+	RockRidgeByExtensionRecord
+	// RockRidgeByRREntry means that there is no ER entry, but a record of the root directory
+	// has an RR entry, which RRIP 1.09 defined and mkisofs writes
+	RockRidgeByRREntry
+	// RockRidgeByEntries means that there is neither, but a record of the root directory
+	// has a well-formed NM or PX entry
+	RockRidgeByEntries
+)
+
+func (d RockRidgeDetection) String() string {
+	switch d {
+	case RockRidgeByExtensionRecord:
+		return "ER entry"
+	case RockRidgeByRREntry:
+		return "RR entry"
+	case RockRidgeByEntries:
+		return "NM and PX entries"
+	}
+	return "not detected"
+}
+
+// detectRockRidge tells whether Rock Ridge is in use from the entries of the root's "." record
+// and of the other records of the root directory, like the Linux kernel does for images without ER entries.
+// The error of an unreadable ER entry is returned along with the result of the other methods.
+func detectRockRidge(dot SystemUseEntrySlice, records []SystemUseEntrySlice) (RockRidgeDetection, error) {
+	hasER, err := suspHasRockRidge(dot)
+	if hasER {
+		return RockRidgeByExtensionRecord, nil
+	}
+
+	all := append([]SystemUseEntrySlice{dot}, records...)
+	for _, entries := range all {
+		for _, entry := range entries {
+			if entry.Type() == "RR" {
+				return RockRidgeByRREntry, err
+			}
+		}
+	}
+	for _, entries := range all {
+		for _, entry := range entries {
+			if wellFormedRockRidgeEntry(entry) {
+				return RockRidgeByEntries, err
+			}
+		}
+	}
+	return RockRidgeNotDetected, err
+}
+
+// wellFormedRockRidgeEntry reports whether the entry is an NM or PX entry which reads correctly
+func wellFormedRockRidgeEntry(entry SystemUseEntry) bool {
+	switch entry.Type() {
+	case "PX":
+		_, err := umarshalRockRidgePosixEntry(entry)
+		return err == nil
+	case "NM":
+		data := entry.Data()
+		if len(data) < 1 || data[0]&^(nmFlagContinue|nmFlagCurrent|nmFlagParent|nmFlagHost) != 0 {
+			return false
+		}
+		return len(data) > 1 || data[0]&(nmFlagCurrent|nmFlagParent) != 0
+	}
+	return false
+}
+
+// detectRockRidgeRevision tells the revision of RRIP from the ER entry of the root's "." record.
+// Without one, an RR entry means RRIP 1.09 and a PX entry with a file serial number RRIP 1.12.
+// Images with neither are taken as RRIP 1.09, which has the shorter entries.
+func detectRockRidgeRevision(dot SystemUseEntrySlice, records []SystemUseEntrySlice) RockRidgeRevision {
+	if extensions, err := dot.GetExtensionRecords(); err == nil {
+		for _, er := range extensions {
+			switch er.Identifier {
+			case RockRidgeIdentifier1991A:
+				return RockRidge109
+			case RockRidgeIdentifierP1282:
+				return RockRidge112
+			}
+		}
+	}
+
+	for _, entries := range append([]SystemUseEntrySlice{dot}, records...) {
+		if _, ok := entries.GetRockRidgeFlags(); ok {
+			return RockRidge109
+		}
+		if px, err := entries.getPosixEntry(); err == nil && px.hasSerial {
+			return RockRidge112
+		}
+	}
+	return RockRidge109
+}
+
+// detectRockRidge sets whether the root directory f uses Rock Ridge, once its records have been read,
+// and resolves the relocated directories among them
+func (f *File) detectRockRidge(records []*File) error {
+	var dot SystemUseEntrySlice
+	others := make([]SystemUseEntrySlice, 0, len(records))
+	for _, r := range records {
+		if r.de.Identifier == string([]byte{0}) {
+			dot = r.de.SystemUseEntries
+		} else {
+			others = append(others, r.de.SystemUseEntries)
+		}
+	}
+
+	detection, err := detectRockRidge(dot, others)
+	if err != nil && len(records) > 0 {
+		if err := f.reportSystemUse(records[0], 0, fmt.Errorf("failed to check for Rock Ridge extension: %w", err)); err != nil {
+			return err
+		}
+	}
+	f.susp.HasRockRidge = detection != RockRidgeNotDetected
+	f.susp.RockRidgeDetection = detection
+
+	if !f.susp.HasRockRidge {
+		return nil
+	}
+	f.susp.RockRidgeRevision = detectRockRidgeRevision(dot, others)
+	for _, r := range records {
+		if r.de.Identifier == string([]byte{0}) {
+			continue
+		}
+		if err := resolveRelocation(r.de, f.ra, f.logicalBlockSize()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func suspHasRockRidge(se SystemUseEntrySlice) (bool, error) {
 	extensions, err := se.GetExtensionRecords()
 	if err != nil {
@@ -43,57 +316,627 @@ func suspHasRockRidge(se SystemUseEntrySlice) (bool, error) {
 	return false, nil
 }
 
+// rockRidgeEntryTypes are the signatures of the entries defined by RRIP
+var rockRidgeEntryTypes = []string{"RR", "PX", "PN", "SL", "NM", "CL", "PL", "RE", "TF", "SF"}
+
+// UnmarshalRockRidge decodes the Rock Ridge entries of a directory record all at once. Unlike the getters
+// of the single entries, which leave out what they cannot decode, it returns an error for the first
+// malformed entry, along with the attributes decoded from the others. A record with Rock Ridge entries
+// must have a PX entry; one without any is returned as the zero RockRidgeAttrs and no error.
+// The recording time and the fallbacks on the directory record, see File.RockRidgeAttrs, are left out.
+func UnmarshalRockRidge(se SystemUseEntrySlice) (RockRidgeAttrs, error) {
+	var attrs RockRidgeAttrs
+	for _, entry := range se {
+		if slices.Contains(rockRidgeEntryTypes, entry.Type()) {
+			attrs.Present = true
+			break
+		}
+	}
+	if !attrs.Present {
+		return attrs, nil
+	}
+
+	var first error
+	fail := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+
+	name, err := se.rockRidgeName()
+	attrs.Name = name
+	if err != nil {
+		fail(err)
+	}
+
+	if px, err := se.getPosixEntry(); err != nil {
+		fail(err)
+	} else {
+		attrs.Mode, attrs.Links, attrs.UID, attrs.GID, attrs.Serial = px.mode, px.nlink, px.uid, px.gid, px.serial
+	}
+
+	if se.hasEntry("TF") {
+		if attrs.Times, err = se.GetTimestamps(); err != nil {
+			fail(err)
+		}
+	}
+	if se.hasEntry("PN") {
+		if attrs.DeviceMajor, attrs.DeviceMinor, err = se.GetDeviceNumber(); err != nil {
+			fail(err)
+		}
+	}
+
+	target, err := se.symlinkTarget()
+	attrs.SymlinkTarget = target
+	if err != nil {
+		fail(err)
+	}
+
+	return attrs, first
+}
+
+// hasEntry reports whether there is an entry with the given signature
+func (s SystemUseEntrySlice) hasEntry(signature string) bool {
+	for _, entry := range s {
+		if entry.Type() == signature {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRockRidgeName assembles the name from all NM entries. It returns an empty string if there are none,
+// and skips the entries which are too short, see UnmarshalRockRidge.
 func (s SystemUseEntrySlice) GetRockRidgeName() string {
-	var name string
+	name, _ := s.rockRidgeName()
+	return name
+}
+
+// rockRidgeName assembles the name from all NM entries, along with the error of the first malformed one
+func (s SystemUseEntrySlice) rockRidgeName() (string, error) {
+	var name strings.Builder
+	var single []byte
+	var malformed error
+	count := 0
 
 	for _, entry := range s {
 		// There is a continuation flag in the record, but we determine continuation
 		// by simply reading all NM entries.
-		if entry.Type() == "NM" {
-			nm := umarshalRockRidgeNameEntry(entry)
-			name += nm.Name
+		if entry.Type() != "NM" {
+			continue
+		}
+		if len(entry.Data()) < 1 {
+			if malformed == nil {
+				malformed = fmt.Errorf("unmarshal RR NM entry: %w", ErrTruncatedRecord)
+			}
+			continue
+		}
+		part := entry.Data()[1:]
+		count++
+		switch count {
+		case 1:
+			single = part
+		case 2:
+			name.Write(single)
+			fallthrough
+		default:
+			name.Write(part)
 		}
 	}
 
-	return name
+	// most names fit into a single entry and need no builder
+	if count == 1 {
+		return string(single), malformed
+	}
+	return name.String(), malformed
 }
 
 func (s SystemUseEntrySlice) GetPosixAttr() (fs.FileMode, error) {
+	px, err := s.getPosixEntry()
+	if err != nil {
+		return 0, err
+	}
+	return px.mode, nil
+}
+
+func (s SystemUseEntrySlice) getPosixEntry() (rockRidgePosixEntry, error) {
 	for _, entry := range s {
 		if entry.Type() == "PX" {
 			// BUG(kdomanski): If there are multiple RR PX entries (which is forbidden by the spec), the reader will use the first one.
-			return umarshalRockRidgeAttrEntry(entry)
+			return umarshalRockRidgePosixEntry(entry)
+		}
+	}
+
+	return rockRidgePosixEntry{}, fmt.Errorf("mandatory entry PX not found")
+}
+
+// GetSymlinkTarget assembles the target of a symbolic link from all SL entries.
+// It returns an empty string if there are no SL entries, and skips the malformed components, see UnmarshalRockRidge.
+func (s SystemUseEntrySlice) GetSymlinkTarget() string {
+	target, _ := s.symlinkTarget()
+	return target
+}
+
+// symlinkTarget assembles the target of a symbolic link from all SL entries, along with the error of the first malformed one
+func (s SystemUseEntrySlice) symlinkTarget() (string, error) {
+	var target strings.Builder
+	var malformed error
+	needSeparator := false
+
+	for _, entry := range s {
+		if entry.Type() != "SL" {
+			continue
+		}
+		if len(entry.Data()) < 1 {
+			if malformed == nil {
+				malformed = fmt.Errorf("unmarshal RR SL entry: %w", ErrTruncatedRecord)
+			}
+			continue
+		}
+
+		components := entry.Data()[1:]
+		for len(components) >= 2 {
+			flags := components[0]
+			length := int(components[1])
+			if len(components) < 2+length {
+				break
+			}
+			content := components[2 : 2+length]
+			components = components[2+length:]
+
+			if flags&slFlagRoot != 0 {
+				target.Reset()
+				target.WriteByte('/')
+				needSeparator = false
+				continue
+			}
+
+			if needSeparator {
+				target.WriteByte('/')
+			}
+
+			switch {
+			case flags&slFlagCurrent != 0:
+				target.WriteString(".")
+			case flags&slFlagParent != 0:
+				target.WriteString("..")
+			default:
+				target.Write(content)
+			}
+
+			// a component flagged with CONTINUE is continued by the next one without a separator
+			needSeparator = flags&slFlagContinue == 0
+		}
+		if len(components) > 0 && malformed == nil {
+			malformed = errors.New("unmarshal RR SL entry: truncated component")
+		}
+	}
+
+	return target.String(), malformed
+}
+
+// rockRidgePosixEntry is the decoded content of a PX entry (RRIP 4.1.1)
+type rockRidgePosixEntry struct {
+	mode  fs.FileMode
+	nlink uint32
+	uid   uint32
+	gid   uint32
+	// serial is the file serial number, which RRIP 1.12 added, if hasSerial is set
+	serial    uint32
+	hasSerial bool
+}
+
+// umarshalRockRidgePosixEntry decodes a PX entry. It returns the entry by value,
+// as it is decoded every time the mode of a file is asked for.
+func umarshalRockRidgePosixEntry(e SystemUseEntry) (rockRidgePosixEntry, error) {
+	data := e.Data()
+	if len(data) < 8 {
+		return rockRidgePosixEntry{}, fmt.Errorf("unmarshall RR PX entry: %w", ErrTruncatedRecord)
+	}
+
+	rrMode, err := UnmarshalUint32LSBMSB(data[0:8])
+	if err != nil {
+		return rockRidgePosixEntry{}, fmt.Errorf("unmarshall RR PX entry: %w", err)
+	}
+
+	px := rockRidgePosixEntry{mode: posixModeToFileMode(rrMode)}
+
+	if len(data) >= 32 {
+		if px.nlink, err = UnmarshalUint32LSBMSB(data[8:16]); err != nil {
+			return rockRidgePosixEntry{}, fmt.Errorf("unmarshall RR PX links: %w", err)
+		}
+		if px.uid, err = UnmarshalUint32LSBMSB(data[16:24]); err != nil {
+			return rockRidgePosixEntry{}, fmt.Errorf("unmarshall RR PX uid: %w", err)
+		}
+		if px.gid, err = UnmarshalUint32LSBMSB(data[24:32]); err != nil {
+			return rockRidgePosixEntry{}, fmt.Errorf("unmarshall RR PX gid: %w", err)
+		}
+	}
+	if len(data) >= 40 {
+		if px.serial, err = UnmarshalUint32LSBMSB(data[32:40]); err != nil {
+			return rockRidgePosixEntry{}, fmt.Errorf("unmarshall RR PX serial number: %w", err)
 		}
+		px.hasSerial = true
 	}
 
-	return 0, fmt.Errorf("mandatory entry PX not found")
+	return px, nil
 }
 
 func umarshalRockRidgeAttrEntry(e SystemUseEntry) (fs.FileMode, error) {
-	rrMode, err := UnmarshalUint32LSBMSB(e.Data()[0:8])
+	px, err := umarshalRockRidgePosixEntry(e)
 	if err != nil {
-		return 0, fmt.Errorf("unmarshall RR PX entry: %w", err)
+		return 0, err
+	}
+	return px.mode, nil
+}
+
+// posixModeToFileMode converts a POSIX st_mode value into an fs.FileMode
+func posixModeToFileMode(rrMode uint32) fs.FileMode {
+	mode := fs.FileMode(rrMode & posixPermission) // UNIX permissions
+
+	switch rrMode & posixTypeMask {
+	case posixSymlink:
+		mode |= os.ModeSymlink
+	case posixDirectory:
+		mode |= os.ModeDir
+	case posixBlockDev:
+		mode |= os.ModeDevice
+	case posixCharDev:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case posixFIFO:
+		mode |= os.ModeNamedPipe
+	case posixSocket:
+		mode |= os.ModeSocket
+	}
+
+	if rrMode&posixSetuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if rrMode&posixSetgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if rrMode&posixSticky != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
+
+// fileModeToPosixMode converts an fs.FileMode into a POSIX st_mode value
+func fileModeToPosixMode(mode fs.FileMode) uint32 {
+	rrMode := uint32(mode.Perm())
+
+	switch {
+	case mode&os.ModeSymlink != 0:
+		rrMode |= posixSymlink
+	case mode&os.ModeDir != 0:
+		rrMode |= posixDirectory
+	case mode&os.ModeCharDevice != 0:
+		rrMode |= posixCharDev
+	case mode&os.ModeDevice != 0:
+		rrMode |= posixBlockDev
+	case mode&os.ModeNamedPipe != 0:
+		rrMode |= posixFIFO
+	case mode&os.ModeSocket != 0:
+		rrMode |= posixSocket
+	default:
+		rrMode |= posixRegular
+	}
+
+	if mode&os.ModeSetuid != 0 {
+		rrMode |= posixSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		rrMode |= posixSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		rrMode |= posixSticky
+	}
+
+	return rrMode
+}
+
+// RRIP 4.1.3.1 component flags of the SL entry
+const (
+	slFlagContinue = 1 << iota
+	slFlagCurrent
+	slFlagParent
+	slFlagRoot
+)
+
+// RRIP 4.1.4 flags of the NM entry
+const (
+	nmFlagContinue = 1 << iota
+	nmFlagCurrent
+	nmFlagParent
+	_
+	_
+	nmFlagHost
+)
+
+// RRIP 4.1.6 flags of the TF entry
+const (
+	tfFlagCreation = 1 << iota
+	tfFlagModify
+	tfFlagAccess
+	tfFlagAttributes
+	tfFlagBackup
+	tfFlagExpiration
+	tfFlagEffective
+	tfFlagLongForm
+)
+
+// maxSystemUseEntryData is the maximum payload of a single SUSP entry,
+// given that the length field is one byte long and includes the 4-byte header
+const maxSystemUseEntryData = 255 - 4
+
+// marshalRockRidgePosixEntry encodes a PX entry as defined in RRIP 4.1.1.
+// The file serial number is recorded if it isn't zero, as RRIP 1.12 requires.
+func marshalRockRidgePosixEntry(mode fs.FileMode, nlink, uid, gid, serial uint32) SystemUseEntry {
+	data := make([]byte, 32, 40)
+	WriteInt32LSBMSB(data[0:8], int32(fileModeToPosixMode(mode)))
+	WriteInt32LSBMSB(data[8:16], int32(nlink))
+	WriteInt32LSBMSB(data[16:24], int32(uid))
+	WriteInt32LSBMSB(data[24:32], int32(gid))
+	if serial != 0 {
+		data = data[:40]
+		WriteInt32LSBMSB(data[32:40], int32(serial))
 	}
+	return newSystemUseEntry("PX", 1, data)
+}
+
+// maxLegacyDeviceMinor is the largest minor number of major 0 a PN entry can record, see marshalRockRidgeDeviceEntry
+const maxLegacyDeviceMinor = 0xfffff
+
+// marshalRockRidgeDeviceEntry encodes a PN entry as defined in RRIP 4.1.2.
+// Like Linux, the high and low parts of the device number hold the major and minor numbers.
+// A high part of zero marks a device number packed into the low part, see GetDeviceNumber,
+// so devices of major 0 are packed like glibc packs a 32-bit dev_t, which leaves small minor numbers as they are.
+func marshalRockRidgeDeviceEntry(major, minor uint32) SystemUseEntry {
+	data := make([]byte, 16)
+	if major == 0 {
+		minor = minor&0xff | (minor&^0xff)<<12
+	}
+	WriteInt32LSBMSB(data[0:8], int32(major))
+	WriteInt32LSBMSB(data[8:16], int32(minor))
+	return newSystemUseEntry("PN", 1, data)
+}
+
+// checkDeviceNumber returns an error for a device number which cannot be recorded in a PN entry
+func checkDeviceNumber(major, minor uint32) error {
+	if major == 0 && minor > maxLegacyDeviceMinor {
+		return fmt.Errorf("the device number 0:%d cannot be recorded, minor numbers of major 0 are limited to %d", minor, maxLegacyDeviceMinor)
+	}
+	return nil
+}
+
+// GetDeviceNumber returns the major and minor numbers of a device from the PN entry.
+// If the high part of the device number is zero, the low part holds a packed device number,
+// as written by mkisofs: a traditional 16-bit one, which is how Linux reads it,
+// or a 32-bit one as packed by glibc, with 12 bits of major and 20 bits of minor number.
+func (s SystemUseEntrySlice) GetDeviceNumber() (major, minor uint32, err error) {
+	for _, entry := range s {
+		if entry.Type() != "PN" {
+			continue
+		}
+		data := entry.Data()
+		if len(data) < 16 {
+			return 0, 0, fmt.Errorf("unmarshal RR PN entry: %w", ErrTruncatedRecord)
+		}
+		high, err := UnmarshalUint32LSBMSB(data[0:8])
+		if err != nil {
+			return 0, 0, fmt.Errorf("unmarshal RR PN entry: %w", err)
+		}
+		low, err := UnmarshalUint32LSBMSB(data[8:16])
+		if err != nil {
+			return 0, 0, fmt.Errorf("unmarshal RR PN entry: %w", err)
+		}
+		if high == 0 {
+			return (low >> 8) & 0xfff, low&0xff | (low>>12)&^0xff, nil
+		}
+		return high, low, nil
+	}
+
+	return 0, 0, fmt.Errorf("entry PN not found")
+}
+
+// marshalRockRidgeNameEntries encodes the alternate name into as many NM entries as needed
+func marshalRockRidgeNameEntries(name string) []SystemUseEntry {
+	var entries []SystemUseEntry
+
+	for {
+		chunk := name
+		var flags byte
+		if len(chunk) > maxSystemUseEntryData-1 {
+			chunk = chunk[:maxSystemUseEntryData-1]
+			flags |= nmFlagContinue
+		}
+		name = name[len(chunk):]
+
+		entries = append(entries, newSystemUseEntry("NM", 1, append([]byte{flags}, chunk...)))
+		if len(name) == 0 {
+			return entries
+		}
+	}
+}
+
+// marshalRockRidgeTimestampEntry encodes a TF entry with the modification time,
+// along with the creation, access and attribute change times if they are set, as defined in RRIP 4.1.6
+func marshalRockRidgeTimestampEntry(rec RecordTimes) SystemUseEntry {
+	data := []byte{0}
+	// the timestamps are recorded in the order of their flags
+	for _, ts := range []struct {
+		flag byte
+		t    time.Time
+	}{
+		{tfFlagCreation, rec.Creation},
+		{tfFlagModify, rec.Modification},
+		{tfFlagAccess, rec.Access},
+		{tfFlagAttributes, rec.Change},
+	} {
+		if ts.t.IsZero() {
+			continue
+		}
+		data[0] |= ts.flag
+		stamp := make([]byte, 7)
+		RecordingTimestamp(ts.t).MarshalBinary(stamp)
+		data = append(data, stamp...)
+	}
+	return newSystemUseEntry("TF", 1, data)
+}
 
-	S_IFLNK := (rrMode & 0170000) == 0120000
-	S_IFDIR := (rrMode & 0170000) == 0040000
+// GetTimestamps decodes the TF entry, in either the short or the long form of RRIP 4.1.6.
+// The backup, expiration and effective times are skipped, and the recording date is left zero.
+func (s SystemUseEntrySlice) GetTimestamps() (RecordTimes, error) {
+	for _, entry := range s {
+		if entry.Type() != "TF" {
+			continue
+		}
+		data := entry.Data()
+		if len(data) < 1 {
+			return RecordTimes{}, fmt.Errorf("unmarshal RR TF entry: %w", ErrTruncatedRecord)
+		}
+		flags := data[0]
+		data = data[1:]
 
-	mode := rrMode & uint32(fs.ModePerm) // UNIX permissions
+		var rec RecordTimes
+		for _, ts := range []struct {
+			flag byte
+			t    *time.Time
+		}{
+			{tfFlagCreation, &rec.Creation},
+			{tfFlagModify, &rec.Modification},
+			{tfFlagAccess, &rec.Access},
+			{tfFlagAttributes, &rec.Change},
+			{tfFlagBackup, nil},
+			{tfFlagExpiration, nil},
+			{tfFlagEffective, nil},
+		} {
+			if flags&ts.flag == 0 {
+				continue
+			}
 
-	if S_IFLNK {
-		mode |= uint32(os.ModeSymlink)
+			var t time.Time
+			var err error
+			size := 7
+			if flags&tfFlagLongForm != 0 {
+				size = 17
+			}
+			if len(data) < size {
+				return RecordTimes{}, fmt.Errorf("unmarshal RR TF entry: %w", ErrTruncatedRecord)
+			}
+			if size == 17 {
+				t, err = unmarshalLongTimestamp(data)
+			} else {
+				var stamp RecordingTimestamp
+				err = stamp.UnmarshalBinary(data)
+				t = time.Time(stamp)
+			}
+			if err != nil {
+				return RecordTimes{}, fmt.Errorf("unmarshal RR TF entry: %w", err)
+			}
+			data = data[size:]
+			if ts.t != nil {
+				*ts.t = t
+			}
+		}
+		return rec, nil
 	}
 
-	if S_IFDIR {
-		mode |= uint32(os.ModeDir)
+	return RecordTimes{}, fmt.Errorf("entry TF not found")
+}
+
+// unmarshalLongTimestamp decodes a timestamp in the 17-byte format of ECMA-119 8.4.26.1
+func unmarshalLongTimestamp(data []byte) (time.Time, error) {
+	var ts VolumeDescriptorTimestamp
+	if err := ts.UnmarshalBinary(data); err != nil {
+		return time.Time{}, err
 	}
+	return time.Date(ts.Year, time.Month(ts.Month), ts.Day, ts.Hour, ts.Minute, ts.Second, ts.Hundredth*int(time.Second/100),
+		recordingZone(ts.Offset)), nil
+}
+
+// marshalRockRidgeSymlinkEntries encodes the target of a symbolic link into SL entries,
+// as defined in RRIP 4.1.3.
+func marshalRockRidgeSymlinkEntries(target string) []SystemUseEntry {
+	var components [][]byte
 
-	return fs.FileMode(mode), nil
+	if strings.HasPrefix(target, "/") {
+		components = append(components, []byte{slFlagRoot, 0})
+	}
+
+	for _, segment := range strings.Split(target, "/") {
+		switch segment {
+		case "":
+			continue
+		case ".":
+			components = append(components, []byte{slFlagCurrent, 0})
+		case "..":
+			components = append(components, []byte{slFlagParent, 0})
+		default:
+			// A component can hold at most 255 bytes, but it also has to fit
+			// into a single SL entry with its headers.
+			maxContent := maxSystemUseEntryData - 1 - 2
+			for len(segment) > maxContent {
+				components = append(components, append([]byte{slFlagContinue, byte(maxContent)}, segment[:maxContent]...))
+				segment = segment[maxContent:]
+			}
+			components = append(components, append([]byte{0, byte(len(segment))}, segment...))
+		}
+	}
+
+	var entries []SystemUseEntry
+	data := []byte{0}
+	for _, c := range components {
+		if len(data)+len(c) > maxSystemUseEntryData {
+			data[0] = slFlagContinue
+			entries = append(entries, newSystemUseEntry("SL", 1, data))
+			data = []byte{0}
+		}
+		data = append(data, c...)
+	}
+
+	return append(entries, newSystemUseEntry("SL", 1, data))
+}
+
+// marshalRockRidgeLocationEntry encodes a CL or PL entry pointing to the given directory extent,
+// as defined in RRIP 4.1.5.1 and 4.1.5.2
+func marshalRockRidgeLocationEntry(signature string, location uint32) SystemUseEntry {
+	data := make([]byte, 8)
+	WriteInt32LSBMSB(data, int32(location))
+	return newSystemUseEntry(signature, 1, data)
+}
+
+// marshalRockRidgeRelocatedEntry encodes the RE entry marking a relocated directory, see RRIP 4.1.5.3
+func marshalRockRidgeRelocatedEntry() SystemUseEntry {
+	return newSystemUseEntry("RE", 1, nil)
+}
+
+// getLocationEntry returns the extent location from the CL or PL entry with the given signature.
+// The second return value is false if there is no such entry.
+func (s SystemUseEntrySlice) getLocationEntry(signature string) (uint32, bool, error) {
+	for _, entry := range s {
+		if entry.Type() != signature {
+			continue
+		}
+		if len(entry.Data()) < 8 {
+			return 0, true, fmt.Errorf("unmarshal %s entry: %w", signature, ErrTruncatedRecord)
+		}
+		location, err := UnmarshalUint32LSBMSB(entry.Data()[:8])
+		if err != nil {
+			return 0, true, fmt.Errorf("unmarshal %s entry: %w", signature, err)
+		}
+		return location, true, nil
+	}
+	return 0, false, nil
 }
 
-func umarshalRockRidgeNameEntry(e SystemUseEntry) *RockRidgeNameEntry {
-	return &RockRidgeNameEntry{
-		Flags: e.Data()[0],
-		Name:  string(e.Data()[1:]),
+// isRelocated reports whether the entry is a relocated directory, which is listed in its original place through a CL entry
+func (s SystemUseEntrySlice) isRelocated() bool {
+	for _, entry := range s {
+		if entry.Type() == "RE" {
+			return true
+		}
 	}
+	return false
 }
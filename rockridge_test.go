@@ -0,0 +1,291 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRockRidgeSymlinkRoundTrip(t *testing.T) {
+	for target, expected := range map[string]string{
+		"/usr/share/some-random-directory/even-deeper-path/symlink-target": "/usr/share/some-random-directory/even-deeper-path/symlink-target",
+		"../relative/./path": "../relative/./path",
+		"/":                  "/",
+		"plain":              "plain",
+		"trailing/slash/":    "trailing/slash",
+		strings.Repeat("x", 300) + "/" + strings.Repeat("y/", 200): strings.Repeat("x", 300) + strings.Repeat("/y", 200),
+	} {
+		entries := SystemUseEntrySlice(marshalRockRidgeSymlinkEntries(target))
+		for _, e := range entries {
+			assert.LessOrEqual(t, len(e), 255)
+			assert.Equal(t, len(e), e.Length())
+		}
+		assert.Equal(t, expected, entries.GetSymlinkTarget())
+	}
+}
+
+func TestRockRidgePosixModeRoundTrip(t *testing.T) {
+	for _, mode := range []uint32{0100644, 0040755, 0120777, 0020620, 0060660, 0010644, 0104755, 0041777} {
+		assert.Equal(t, mode, fileModeToPosixMode(posixModeToFileMode(mode)), "mode %o", mode)
+	}
+}
+
+func TestRockRidgeLongName(t *testing.T) {
+	name := strings.Repeat("n", 600)
+	entries := SystemUseEntrySlice(marshalRockRidgeNameEntries(name))
+	assert.Len(t, entries, 3)
+	assert.Equal(t, name, entries.GetRockRidgeName())
+}
+
+func TestRockRidgeDeviceNumber(t *testing.T) {
+	for _, dev := range [][2]uint32{{8, 1}, {259, 65536}, {4095, 1048575}, {0, 5}, {0, 300}, {0, 1048575}} {
+		entries := SystemUseEntrySlice{marshalRockRidgeDeviceEntry(dev[0], dev[1])}
+		major, minor, err := entries.GetDeviceNumber()
+		assert.NoError(t, err)
+		assert.Equal(t, dev, [2]uint32{major, minor})
+	}
+
+	// a 16-bit device number in the low part, as written by mkisofs with a 32-bit dev_t
+	data := make([]byte, 16)
+	WriteInt32LSBMSB(data[8:16], 0x0801)
+	major, minor, err := SystemUseEntrySlice{newSystemUseEntry("PN", 1, data)}.GetDeviceNumber()
+	assert.NoError(t, err)
+	assert.Equal(t, [2]uint32{8, 1}, [2]uint32{major, minor})
+
+	// a 32-bit device number packed by glibc
+	WriteInt32LSBMSB(data[8:16], 0x12345)
+	major, minor, err = SystemUseEntrySlice{newSystemUseEntry("PN", 1, data)}.GetDeviceNumber()
+	assert.NoError(t, err)
+	assert.Equal(t, [2]uint32{0x123, 0x45}, [2]uint32{major, minor})
+
+	_, _, err = SystemUseEntrySlice{}.GetDeviceNumber()
+	assert.Error(t, err)
+}
+
+func TestWriterDeviceNumberRoundTrip(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	devices := map[string][2]uint32{"/dev/null": {1, 3}, "/dev/unnamed": {0, 300}, "/dev/nvme": {259, 65536}}
+	for p, dev := range devices {
+		require.NoError(t, w.AddDeviceNode(p, fs.ModeDevice|fs.ModeCharDevice|0600, dev[0], dev[1]))
+	}
+	assert.ErrorContains(t, w.AddDeviceNode("/dev/huge", fs.ModeDevice|0600, 0, 1<<20), "cannot be recorded")
+
+	files := filesByPath(t, remaster(t, w))
+	for p, dev := range devices {
+		major, minor, ok := files[p].DeviceNumber()
+		require.True(t, ok, p)
+		assert.Equal(t, dev, [2]uint32{major, minor}, p)
+	}
+}
+
+// withoutExtensionRecord returns a Rock Ridge image whose ER entry is replaced by an entry with the given signature.
+// The image follows RRIP 1.12, which has no RR entries of its own.
+func withoutExtensionRecord(t *testing.T, signature string) []byte {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, RockRidgeIdentifier: RockRidgeIdentifierP1282})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("data"), "Mixed-Case Name.txt"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "no-er"))
+
+	image := buf.Bytes()
+	at := bytes.Index(image, []byte(RockRidgeIdentifierP1282)) - 8
+	require.Greater(t, at, 0)
+	require.Equal(t, "ER", string(image[at:at+2]))
+	copy(image[at:], signature)
+	if signature == "RR" {
+		// the flags of the entries of the root's "." record
+		image[at+4] = byte(RockRidgeFlagPX | RockRidgeFlagTF)
+	}
+	return image
+}
+
+func TestRockRidgeWithoutExtensionRecord(t *testing.T) {
+	for signature, expected := range map[string]RockRidgeDetection{
+		"ER": RockRidgeByExtensionRecord,
+		"RR": RockRidgeByRREntry,
+		"ZZ": RockRidgeByEntries,
+	} {
+		img, err := OpenImage(bytes.NewReader(withoutExtensionRecord(t, signature)))
+		require.NoError(t, err)
+		detection, err := img.RockRidgeDetection()
+		require.NoError(t, err)
+		assert.Equal(t, expected, detection, signature)
+		hasRockRidge, err := img.HasRockRidge()
+		require.NoError(t, err)
+		assert.True(t, hasRockRidge)
+
+		files := filesByPath(t, img)
+		assert.Contains(t, files, "/Mixed-Case Name.txt", signature)
+
+		report, err := img.Verify()
+		require.NoError(t, err)
+		var messages []string
+		for _, f := range report.Findings {
+			messages = append(messages, f.Message)
+		}
+		if expected == RockRidgeByExtensionRecord {
+			assert.Empty(t, messages)
+		} else {
+			assert.Equal(t, []string{"Rock Ridge is used without an ER entry, it was detected by its " + expected.String()}, messages)
+		}
+	}
+
+	// NM and PX entries which don't read correctly don't count
+	for _, entries := range []SystemUseEntrySlice{
+		{newSystemUseEntry("NM", 1, []byte{0x80, 'x'})},
+		{newSystemUseEntry("NM", 1, []byte{0})},
+		{newSystemUseEntry("PX", 1, make([]byte, 4))},
+		{newSystemUseEntry("TF", 1, []byte{0})},
+	} {
+		detection, err := detectRockRidge(nil, []SystemUseEntrySlice{entries})
+		assert.NoError(t, err)
+		assert.Equal(t, RockRidgeNotDetected, detection)
+	}
+}
+
+func TestRockRidgeRevisions(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "dir/a", Mode: 0644, Size: 6}))
+	_, err := tw.Write([]byte("linked"))
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "dir/b", Linkname: "dir/a"}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "dir/a"}))
+	require.NoError(t, tw.Close())
+
+	for identifier, expected := range map[string]RockRidgeRevision{
+		"":                       RockRidge109,
+		RockRidgeIdentifier1991A: RockRidge109,
+		RockRidgeIdentifierP1282: RockRidge112,
+	} {
+		w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, RockRidgeIdentifier: identifier})
+		require.NoError(t, err)
+		defer w.Cleanup() // nolint: errcheck
+		require.NoError(t, w.AddTar(bytes.NewReader(archive.Bytes()), "/"))
+		var buf bytes.Buffer
+		require.NoError(t, w.WriteTo(&buf, "revision"))
+
+		img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		revision, err := img.RockRidgeRevision()
+		require.NoError(t, err)
+		assert.Equal(t, expected, revision, identifier)
+		assert.Equal(t, expected.Identifier(), func() string {
+			extensions, err := img.Extensions()
+			require.NoError(t, err)
+			return extensions[0]
+		}())
+
+		files := filesByPath(t, img)
+		serials := make(map[uint32]string)
+		for name, f := range files {
+			flags, hasFlags := f.SystemUseEntries().GetRockRidgeFlags()
+			serial, hasSerial := f.SerialNumber()
+			if expected == RockRidge112 {
+				assert.False(t, hasFlags, name)
+				require.True(t, hasSerial, name)
+				if other, ok := serials[serial]; ok {
+					assert.ElementsMatch(t, []string{"/dir/a", "/dir/b"}, []string{name, other}, "only hard links share a serial number")
+				}
+				serials[serial] = name
+				continue
+			}
+			assert.False(t, hasSerial, name)
+			require.True(t, hasFlags, name)
+			expectedFlags := RockRidgeFlagPX | RockRidgeFlagNM | RockRidgeFlagTF
+			switch name {
+			case "/":
+				// the "." entry has no name
+				expectedFlags = RockRidgeFlagPX | RockRidgeFlagTF
+			case "/link":
+				expectedFlags |= RockRidgeFlagSL
+			}
+			assert.Equal(t, expectedFlags, flags, name)
+		}
+		if expected == RockRidge112 {
+			assert.Len(t, serials, len(files)-1)
+		}
+		report, err := img.Verify()
+		require.NoError(t, err)
+		assert.Empty(t, report.Findings, identifier)
+
+		// the revision is told by the entries without an ER entry
+		image := buf.Bytes()
+		at := bytes.Index(image, []byte(expected.Identifier())) - 8
+		require.Greater(t, at, 0)
+		copy(image[at:], "ZZ")
+		img, err = OpenImage(bytes.NewReader(image))
+		require.NoError(t, err)
+		revision, err = img.RockRidgeRevision()
+		require.NoError(t, err)
+		assert.Equal(t, expected, revision, identifier)
+	}
+
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("data"), "file"))
+	revision, err := remaster(t, w).RockRidgeRevision()
+	require.NoError(t, err)
+	assert.Equal(t, RockRidgeRevisionUnknown, revision)
+	assert.Equal(t, "PX,NM,TF", (RockRidgeFlagPX | RockRidgeFlagNM | RockRidgeFlagTF).String())
+}
+
+func TestUnmarshalRockRidge(t *testing.T) {
+	modified := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+	entries := SystemUseEntrySlice{marshalRockRidgePosixEntry(0644, 1, 1000, 100, 42)}
+	entries = append(entries, marshalRockRidgeNameEntries(strings.Repeat("n", 300))...)
+	entries = append(entries, marshalRockRidgeSymlinkEntries("../target")...)
+	entries = append(entries, marshalRockRidgeTimestampEntry(RecordTimes{Modification: modified}), marshalRockRidgeDeviceEntry(8, 1))
+
+	attrs, err := UnmarshalRockRidge(entries)
+	require.NoError(t, err)
+	assert.True(t, modified.Equal(attrs.Times.Modification), "modified at %s", attrs.Times.Modification)
+	attrs.Times.Modification = modified
+	assert.Equal(t, RockRidgeAttrs{
+		Present:       true,
+		Name:          strings.Repeat("n", 300),
+		Mode:          0644,
+		UID:           1000,
+		GID:           100,
+		Links:         1,
+		Serial:        42,
+		Times:         RecordTimes{Modification: modified},
+		SymlinkTarget: "../target",
+		DeviceMajor:   8,
+		DeviceMinor:   1,
+	}, attrs)
+
+	attrs, err = UnmarshalRockRidge(nil)
+	assert.NoError(t, err)
+	assert.False(t, attrs.Present)
+
+	// the malformed entries, which the getters skip, are reported along with the attributes decoded from the others
+	name := marshalRockRidgeNameEntries("name")
+	for description, malformed := range map[string]SystemUseEntrySlice{
+		"missing PX":   {name[0]},
+		"empty NM":     {marshalRockRidgePosixEntry(0644, 1, 0, 0, 0), name[0], newSystemUseEntry("NM", 1, nil)},
+		"truncated SL": {marshalRockRidgePosixEntry(0644, 1, 0, 0, 0), name[0], newSystemUseEntry("SL", 1, []byte{0, 0, 5, 'a'})},
+		"truncated TF": {marshalRockRidgePosixEntry(0644, 1, 0, 0, 0), name[0], newSystemUseEntry("TF", 1, []byte{tfFlagModify, 1, 2})},
+		"truncated PN": {marshalRockRidgePosixEntry(0644, 1, 0, 0, 0), name[0], newSystemUseEntry("PN", 1, []byte{1, 2})},
+		"truncated PX": {newSystemUseEntry("PX", 1, []byte{1, 2}), name[0]},
+	} {
+		attrs, err := UnmarshalRockRidge(malformed)
+		assert.Error(t, err, description)
+		assert.True(t, attrs.Present, description)
+		assert.Equal(t, "name", attrs.Name, description)
+		assert.Equal(t, "name", malformed.GetRockRidgeName(), description)
+	}
+}
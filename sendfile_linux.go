@@ -0,0 +1,81 @@
+package iso9660
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// maxSendfileChunk is the most Linux transfers with a single sendfile call
+const maxSendfileChunk = 0x7ffff000
+
+// sendFile copies size bytes at offset from the image to w with sendfile(2), without passing the data through user space.
+// It applies if the image and w are regular files. handled is false if it doesn't apply, so that nothing has been written.
+func sendFile(w io.Writer, ra io.ReaderAt, offset, size int64) (written int64, handled bool, err error) {
+	src, ok := ra.(*os.File)
+	if !ok {
+		return 0, false, nil
+	}
+	dst, ok := w.(*os.File)
+	if !ok {
+		return 0, false, nil
+	}
+	if info, err := dst.Stat(); err != nil || !info.Mode().IsRegular() {
+		return 0, false, nil
+	}
+
+	srcConn, err := src.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	dstConn, err := dst.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+
+	var sendErr, dstErr error
+	srcErr := srcConn.Control(func(srcFd uintptr) {
+		dstErr = dstConn.Control(func(dstFd uintptr) {
+			position := offset
+			for written < size {
+				chunk := size - written
+				if chunk > maxSendfileChunk {
+					chunk = maxSendfileChunk
+				}
+				n, err := syscall.Sendfile(int(dstFd), int(srcFd), &position, int(chunk))
+				if n > 0 {
+					written += int64(n)
+				}
+				switch {
+				case err == syscall.EINTR || err == syscall.EAGAIN:
+					continue
+				case err != nil && written == 0:
+					// e.g. a destination opened with O_APPEND, which sendfile cannot write to
+					sendErr = err
+					return
+				case err != nil:
+					handled = true
+					sendErr = os.NewSyscallError("sendfile", err)
+					return
+				case n == 0:
+					// the image ends early
+					handled = true
+					sendErr = io.ErrUnexpectedEOF
+					return
+				}
+				handled = true
+			}
+		})
+	})
+
+	if !handled {
+		return 0, false, nil
+	}
+	switch {
+	case sendErr != nil:
+		return written, true, sendErr
+	case dstErr != nil:
+		return written, true, dstErr
+	}
+	return written, true, srcErr
+}
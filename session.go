@@ -0,0 +1,159 @@
+package iso9660
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SessionWriter writes a new session of a multisession medium, like mkisofs does with -M and -C.
+// Entries are added, replaced, removed or renamed with the methods of the embedded ImageWriter,
+// whose staging area is seeded with the directory tree of the previous session like with NewWriterFromImage.
+//
+// The new session references the data of the files kept from the previous session where it is,
+// so only the data of the new files is written, along with the new directories, path tables and volume descriptors.
+// The session records the locations of its sectors on the medium, so it must be recorded at the sector it starts at.
+// Reading it back requires ReaderOptions.SessionStart.
+//
+// Joliet and Enhanced Volume Descriptors are written if the previous session has them.
+// Boot entries, hybrid partition tables and implanted MD5 checksums aren't supported, as they describe
+// the first session, which firmware and tools read them from.
+type SessionWriter struct {
+	*ImageWriter
+
+	// start is the first sector of the new session
+	start uint32
+	// inPlace maps the sources of the previous session's files to the first sectors of their data
+	inPlace map[stagedSource]uint32
+}
+
+// NewSessionWriter creates a writer of a new session starting at the sector start of the medium,
+// which follows the previous session read from img, see SessionWriter.
+// The image must remain readable until the session has been written.
+func NewSessionWriter(img *Image, start uint32) (*SessionWriter, error) {
+	if img.primary < 0 || img.volumeDescriptors[img.primary].Type() != volumeTypePrimary {
+		return nil, errors.New("the previous session has no Primary Volume Descriptor")
+	}
+	if size := img.LogicalBlockSize(); size != int64(sectorSize) {
+		return nil, fmt.Errorf("a session cannot follow one with %d-byte logical blocks", size)
+	}
+
+	// the new session must not overwrite any of the previous volumes
+	end := img.descriptorSector(len(img.volumeDescriptors))
+	hasJoliet, hasEnhanced := false, false
+	for _, vd := range img.volumeDescriptors {
+		if vd.Primary == nil {
+			continue
+		}
+		if size := uint32(vd.Primary.VolumeSpaceSize); size > end {
+			end = size
+		}
+		switch {
+		case vd.Type() == volumeTypeSupplementary && vd.joliet > 0 && vd.problem == "":
+			hasJoliet = true
+		case vd.isEnhanced():
+			hasEnhanced = true
+		}
+	}
+	if start < end {
+		return nil, fmt.Errorf("the new session at sector %d overlaps the previous one, which ends at sector %d", start, end)
+	}
+
+	iw, err := newWriterFromImage(img)
+	if err != nil {
+		return nil, err
+	}
+	iw.joliet = hasJoliet
+	iw.enhancedVolume = hasEnhanced
+
+	s := &SessionWriter{ImageWriter: iw, start: start, inPlace: make(map[stagedSource]uint32)}
+	collectInPlaceSources(iw.root, s.inPlace)
+	return s, nil
+}
+
+// Start returns the first sector of the new session on the medium
+func (s *SessionWriter) Start() uint32 {
+	return s.start
+}
+
+// WriteTo writes the new session to w, starting with its system area, which is to be recorded
+// at the sector Start of the medium. If volumeIdentifier is empty, the identifier from the VolumeMetadata is used.
+func (s *SessionWriter) WriteTo(w io.Writer, volumeIdentifier string) error {
+	return s.WriteToContext(context.Background(), w, volumeIdentifier)
+}
+
+// WriteToContext writes the new session like WriteTo, but stops when the context is done,
+// see ImageWriter.WriteToContext
+func (s *SessionWriter) WriteToContext(ctx context.Context, w io.Writer, volumeIdentifier string) error {
+	root, err := s.startWrite()
+	if err != nil {
+		return err
+	}
+	defer s.endWrite()
+
+	switch {
+	case s.implantMD5:
+		return errors.New("the MD5 checksum of the whole medium cannot be implanted in a session")
+	case len(s.bootEntries) > 0:
+		return errors.New("boot entries cannot be added in a new session")
+	case s.hybrid != nil:
+		return errors.New("the partition tables of a hybrid image cannot be written in a new session")
+	case s.blockSize != 0 && s.blockSize != sectorSize:
+		return errors.New("a new session must be written with the logical blocks of the previous one")
+	}
+
+	now := s.now()
+	wc := s.newWriteContext(now)
+	wc.ctx = ctx
+	wc.freeSectorPointer += s.start
+	wc.inPlace = s.inPlace
+	defer wc.removeTemporaryFiles()
+
+	pvd, descriptors, err := s.layoutImage(wc, root, volumeIdentifier, now)
+	if err != nil {
+		return err
+	}
+
+	out := w
+	if s.progress != nil {
+		out = wc.newProgressReporter(s.progress, s.start, 1).pass(out, s.start)
+	}
+	cw := &countingWriter{w: out}
+	err = s.writeSession(wc, cw, append([]volumeDescriptor{pvd}, descriptors...))
+	var interrupted *IncompleteImageError
+	if errors.As(err, &interrupted) {
+		interrupted.Written = cw.written
+	}
+	return err
+}
+
+// writeSession writes the system area and the volume descriptors of the session, followed by everything else
+func (s *SessionWriter) writeSession(wc *writeContext, w io.Writer, descriptors []volumeDescriptor) error {
+	if err := wc.interrupted(PhaseVolumeDescriptor, ""); err != nil {
+		return err
+	}
+	systemArea := make([]byte, systemAreaSize)
+	copy(systemArea, s.systemArea)
+	if _, err := w.Write(systemArea); err != nil {
+		return err
+	}
+	for _, vd := range descriptors {
+		buffer, err := vd.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(buffer); err != nil {
+			return err
+		}
+	}
+
+	if err := wc.writeAll(w); err != nil {
+		var interrupted *IncompleteImageError
+		if errors.As(err, &interrupted) {
+			return err
+		}
+		return fmt.Errorf("writing files: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readPath returns the contents of a file of the image
+func readPath(t *testing.T, img *Image, name string) string {
+	f, err := img.LookupPath(name)
+	require.NoError(t, err, name)
+	reader, err := f.OpenReader()
+	require.NoError(t, err, name)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err, name)
+	return string(data)
+}
+
+func TestSessionWriter(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Joliet: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("old contents"), "docs/kept.txt"))
+	require.NoError(t, w.AddFile(strings.NewReader("removed"), "docs/removed.txt"))
+	var first bytes.Buffer
+	require.NoError(t, w.WriteTo(&first, "FIRST"))
+
+	img, err := OpenImage(bytes.NewReader(first.Bytes()))
+	require.NoError(t, err)
+	firstSectors := uint32(first.Len()) / sectorSize
+
+	_, err = NewSessionWriter(img, firstSectors-1)
+	assert.Error(t, err, "the sessions overlap")
+
+	// the drive leaves a gap between the sessions
+	start := firstSectors + 150
+	s, err := NewSessionWriter(img, start)
+	require.NoError(t, err)
+	defer s.Cleanup() // nolint: errcheck
+	assert.Equal(t, start, s.Start())
+	require.NoError(t, s.Remove("docs/removed.txt"))
+	require.NoError(t, s.AddFile(strings.NewReader("added"), "docs/added.txt"))
+	var second bytes.Buffer
+	require.NoError(t, s.WriteTo(&second, "SECOND"))
+
+	// the data of the kept file isn't written again
+	assert.NotContains(t, second.String(), "old contents")
+
+	medium := append(first.Bytes(), make([]byte, int(start-firstSectors)*int(sectorSize))...)
+	medium = append(medium, second.Bytes()...)
+
+	// the first session still reads as before
+	old, err := OpenImage(bytes.NewReader(medium))
+	require.NoError(t, err)
+	assert.Equal(t, "removed", readPath(t, old, "/docs/removed.txt"))
+
+	for _, joliet := range []bool{false, true} {
+		latest, err := OpenImageWithOptions(bytes.NewReader(medium), ReaderOptions{SessionStart: start, PreferJoliet: joliet})
+		require.NoError(t, err)
+		id, err := latest.Label()
+		require.NoError(t, err)
+		assert.Equal(t, "SECOND", id)
+		assert.Equal(t, start+16, latest.VolumeDescriptors()[0].Sector)
+		assert.Equal(t, "old contents", readPath(t, latest, "/docs/kept.txt"))
+		assert.Equal(t, "added", readPath(t, latest, "/docs/added.txt"))
+		_, err = latest.LookupPath("/docs/removed.txt")
+		assert.Error(t, err)
+
+		report, err := latest.Verify(WithFileData())
+		require.NoError(t, err)
+		assert.Empty(t, report.Errors())
+	}
+}
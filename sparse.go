@@ -0,0 +1,92 @@
+package iso9660
+
+import (
+	"io"
+)
+
+// truncater is implemented by destinations such as *os.File, which can be extended over a hole
+type truncater interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+}
+
+// sparseWriter writes to a file, seeking over sectors which only contain zeroes instead of writing them.
+// The skipped sectors become holes on file systems which support them.
+type sparseWriter struct {
+	w     truncater
+	start int64
+	// offset is the logical position relative to start
+	offset int64
+	// skipped is the number of bytes before offset that have been seeked over but not yet written
+	skipped int64
+}
+
+// newSparseWriter returns a sparseWriter for the destination if it supports seeking and truncation,
+// and there is no data at or after its current position which the holes would leave in place.
+func newSparseWriter(w io.Writer) (*sparseWriter, bool) {
+	t, ok := w.(truncater)
+	if !ok {
+		return nil, false
+	}
+
+	start, err := t.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false
+	}
+	end, err := t.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, false
+	}
+	if _, err = t.Seek(start, io.SeekStart); err != nil || end > start {
+		return nil, false
+	}
+
+	return &sparseWriter{w: t, start: start}, true
+}
+
+func (s *sparseWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		// split the data at sector boundaries, so that only whole sectors are skipped
+		n := int(int64(sectorSize) - s.offset%int64(sectorSize))
+		if n > len(p) {
+			n = len(p)
+		}
+
+		if n == int(sectorSize) && isZero(p[:n]) {
+			s.skipped += int64(n)
+		} else {
+			if s.skipped > 0 {
+				if _, err := s.w.Seek(s.skipped, io.SeekCurrent); err != nil {
+					return written, err
+				}
+				s.skipped = 0
+			}
+			if _, err := s.w.Write(p[:n]); err != nil {
+				return written, err
+			}
+		}
+
+		s.offset += int64(n)
+		written += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Close extends the file over the trailing zero sectors, so that it has the full logical length.
+// The destination itself is not closed.
+func (s *sparseWriter) Close() error {
+	if s.skipped == 0 {
+		return nil
+	}
+
+	if err := s.w.Truncate(s.start + s.offset); err != nil {
+		return err
+	}
+	_, err := s.w.Seek(s.start+s.offset, io.SeekStart)
+	s.skipped = 0
+	return err
+}
@@ -0,0 +1,50 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func allocatedBlocks(t *testing.T, name string) int64 {
+	var st syscall.Stat_t
+	require.NoError(t, syscall.Stat(name, &st))
+	return st.Blocks
+}
+
+func TestWriterSparseOutputAllocation(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	require.NoError(t, w.AddFile(bytes.NewReader(make([]byte, 1024*sectorSize)), "zeroes.bin"))
+
+	writeImage := func(name string) string {
+		imagePath := path.Join(t.TempDir(), name)
+		f, err := os.Create(imagePath)
+		require.NoError(t, err)
+		defer f.Close() // nolint: errcheck
+		require.NoError(t, w.WriteTo(f, "sparse"))
+		return imagePath
+	}
+
+	sparse := writeImage("sparse.iso")
+	w.SetDenseOutput(true)
+	dense := writeImage("dense.iso")
+
+	sparseInfo, err := os.Stat(sparse)
+	require.NoError(t, err)
+	denseInfo, err := os.Stat(dense)
+	require.NoError(t, err)
+	assert.Equal(t, denseInfo.Size(), sparseInfo.Size())
+
+	assert.Less(t, allocatedBlocks(t, sparse), allocatedBlocks(t, dense)/2)
+}
@@ -0,0 +1,92 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSparseWriter(t *testing.T) {
+	f, err := os.Create(path.Join(t.TempDir(), "sparse"))
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+
+	// the writer picks up at the current position
+	_, err = f.Write([]byte("head"))
+	require.NoError(t, err)
+
+	s, ok := newSparseWriter(f)
+	require.True(t, ok)
+
+	var expected bytes.Buffer
+	for _, chunk := range [][]byte{
+		make([]byte, 3*sectorSize),
+		[]byte("data"),
+		make([]byte, 2*sectorSize),
+		bytes.Repeat([]byte{1}, int(sectorSize)),
+		make([]byte, 5*sectorSize),
+	} {
+		n, err := s.Write(chunk)
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+		expected.Write(chunk)
+	}
+	require.NoError(t, s.Close())
+
+	position, err := f.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4+expected.Len()), position)
+
+	written, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte("head"), expected.Bytes()...), written)
+
+	// data after the position would show through the holes
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	_, ok = newSparseWriter(f)
+	assert.False(t, ok)
+
+	_, ok = newSparseWriter(&bytes.Buffer{})
+	assert.False(t, ok)
+}
+
+func TestWriterSparseOutput(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	require.NoError(t, w.AddFile(bytes.NewReader(make([]byte, 64*sectorSize)), "zeroes.bin"))
+	require.NoError(t, w.AddFile(bytes.NewReader([]byte("not empty")), "data.txt"))
+
+	var dense bytes.Buffer
+	require.NoError(t, w.WriteTo(&dense, "sparse"))
+
+	f, err := os.Create(path.Join(t.TempDir(), "sparse.iso"))
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+	require.NoError(t, w.WriteTo(f, "sparse"))
+
+	sparse, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	// the timestamps in the volume descriptor differ between the two images
+	require.Equal(t, dense.Len(), len(sparse))
+	assert.Equal(t, dense.Bytes()[17*sectorSize:], sparse[17*sectorSize:])
+
+	img, err := OpenImage(f)
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	assert.Equal(t, int64(64*sectorSize), children[1].Size())
+}
@@ -0,0 +1,248 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Sparse files are recorded as described by RRIP 1.12 4.1.7: the SF entry holds the virtual size of the file
+// and the depth of a tree of tables, whose root is the first logical block of the extent.
+// A table fills one logical block with little-endian logical block numbers, each covering an equal part
+// of the file: the tables of depth 1 point to the blocks of data, the others to the tables below them.
+// Zero marks a part of the file which isn't recorded and reads as zeroes.
+//
+// The SF entry of RRIP 1.10 only has the low 32 bits of the size, as a both-byte-order number, and one level of tables.
+
+const (
+	// sparseTableEntries is the number of block numbers in a table of one logical block
+	sparseTableEntries = 2048 / 4
+	// sparseMaxDepth is the depth at which the tables cover more than any 64-bit size
+	sparseMaxDepth = 6
+)
+
+var errSparseCorrupted = errors.New("corrupted sparse file tables")
+
+// sparseInfo describes a sparse file, as stored in the RRIP SF entry
+type sparseInfo struct {
+	size  uint64
+	depth byte
+}
+
+func unmarshalSparseEntry(e SystemUseEntry) (*sparseInfo, error) {
+	data := e.Data()
+	switch {
+	case len(data) >= 17:
+		high, err := UnmarshalUint32LSBMSB(data[0:8])
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal SF entry: %w", err)
+		}
+		low, err := UnmarshalUint32LSBMSB(data[8:16])
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal SF entry: %w", err)
+		}
+		return &sparseInfo{size: uint64(high)<<32 | uint64(low), depth: data[16]}, nil
+	case len(data) >= 8:
+		size, err := UnmarshalUint32LSBMSB(data[0:8])
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal SF entry: %w", err)
+		}
+		return &sparseInfo{size: uint64(size), depth: 1}, nil
+	default:
+		return nil, fmt.Errorf("unmarshal SF entry: %w", io.ErrUnexpectedEOF)
+	}
+}
+
+// getSparseInfo returns the decoded SF entry or nil if there is none
+func (s SystemUseEntrySlice) getSparseInfo() (*sparseInfo, error) {
+	for _, entry := range s {
+		if entry.Type() == "SF" {
+			return unmarshalSparseEntry(entry)
+		}
+	}
+	return nil, nil
+}
+
+// sparseInfo returns the parameters of the sparse file format or nil if the file isn't sparse
+func (f *File) sparseInfo() *sparseInfo {
+	if !f.hasRockRidge() || f.IsDir() {
+		return nil
+	}
+	sf, err := f.de.SystemUseEntries.getSparseInfo()
+	if err != nil {
+		return nil
+	}
+	return sf
+}
+
+// IsSparse reports whether the file is recorded in the sparse file format of Rock Ridge,
+// with the parts holding only zeroes left out of the image. Its readers return the zeroes.
+func (f *File) IsSparse() bool {
+	return f.sparseInfo() != nil
+}
+
+// sparseTable is a table of block numbers read from the image
+type sparseTable struct {
+	block   uint32
+	entries []uint32
+}
+
+// sparseReader reads a sparse file through its tables. The last table read at every depth
+// is kept for the following reads, so sequential reads fetch every table once.
+type sparseReader struct {
+	// ra is the whole image, as the tables hold absolute logical block numbers
+	ra    io.ReaderAt
+	root  uint32
+	size  int64
+	depth int
+
+	// mu guards the tables kept, as ReadAt may be called concurrently
+	mu       sync.Mutex
+	tables   []sparseTable
+	position int64
+}
+
+func newSparseReader(ra io.ReaderAt, root uint32, info *sparseInfo) (*sparseReader, error) {
+	if info.depth == 0 || info.depth > sparseMaxDepth {
+		return nil, fmt.Errorf("%w: depth %d", errSparseCorrupted, info.depth)
+	}
+	if info.size > 1<<63-1 {
+		return nil, fmt.Errorf("%w: size %d", errSparseCorrupted, info.size)
+	}
+
+	sr := &sparseReader{ra: ra, root: root, size: int64(info.size), depth: int(info.depth)}
+	if blocks := (info.size + uint64(sectorSize) - 1) / uint64(sectorSize); blocks > sr.span(0)*sparseTableEntries {
+		return nil, fmt.Errorf("%w: %d tables deep cannot hold %d bytes", errSparseCorrupted, info.depth, info.size)
+	}
+	sr.tables = make([]sparseTable, sr.depth)
+	return sr, nil
+}
+
+// span returns the number of data blocks covered by an entry of a table at the given level, 0 being the root
+func (sr *sparseReader) span(level int) uint64 {
+	span := uint64(1)
+	for n := level + 1; n < sr.depth; n++ {
+		span *= sparseTableEntries
+	}
+	return span
+}
+
+// table returns the entries of the table at the given level recorded in the given block
+func (sr *sparseReader) table(level int, block uint32) ([]uint32, error) {
+	if t := sr.tables[level]; t.entries != nil && t.block == block {
+		return t.entries, nil
+	}
+
+	data := make([]byte, sectorSize)
+	if _, err := sr.ra.ReadAt(data, int64(block)*int64(sectorSize)); err != nil {
+		return nil, fmt.Errorf("reading the sparse file table at block %d: %w", block, err)
+	}
+	entries := make([]uint32, sparseTableEntries)
+	for i := range entries {
+		entries[i] = binary.LittleEndian.Uint32(data[4*i : 4*(i+1)])
+	}
+	sr.tables[level] = sparseTable{block: block, entries: entries}
+	return entries, nil
+}
+
+// locate returns the logical block recording the data block with the given index, 0 if it is a hole
+func (sr *sparseReader) locate(index uint64) (uint32, error) {
+	block := sr.root
+	for level := 0; level < sr.depth; level++ {
+		entries, err := sr.table(level, block)
+		if err != nil {
+			return 0, err
+		}
+		span := sr.span(level)
+		block = entries[index/span]
+		if block == 0 {
+			return 0, nil
+		}
+		index %= span
+	}
+	return block, nil
+}
+
+// copyFrom copies the data of the block holding the position to p, which must lie before the end of the file
+func (sr *sparseReader) copyFrom(p []byte, position int64) (int, error) {
+	within := position % int64(sectorSize)
+	if rest := min64(int64(sectorSize)-within, sr.size-position); int64(len(p)) > rest {
+		p = p[:rest]
+	}
+
+	block, err := sr.locate(uint64(position / int64(sectorSize)))
+	if err != nil {
+		return 0, err
+	}
+	if block == 0 {
+		for i := range p {
+			p[i] = 0
+		}
+		return len(p), nil
+	}
+
+	n, err := sr.ra.ReadAt(p, int64(block)*int64(sectorSize)+within)
+	if err != nil && n < len(p) {
+		return n, fmt.Errorf("reading the sparse file data at block %d: %w", block, err)
+	}
+	return n, nil
+}
+
+func (sr *sparseReader) Read(p []byte) (int, error) {
+	if sr.position >= sr.size {
+		return 0, io.EOF
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	n, err := sr.copyFrom(p, sr.position)
+	sr.position += int64(n)
+	return n, err
+}
+
+// ReadAt reads the data at the given offset, with zeroes in the holes
+func (sr *sparseReader) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.New("sparse file: negative offset")
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	read := 0
+	for read < len(p) {
+		if offset+int64(read) >= sr.size {
+			return read, io.EOF
+		}
+		n, err := sr.copyFrom(p[read:], offset+int64(read))
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// Seek sets the position of the next Read within the data
+func (sr *sparseReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += sr.position
+	case io.SeekEnd:
+		offset += sr.size
+	case io.SeekStart:
+	default:
+		return 0, errors.New("sparse file: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("sparse file: negative position")
+	}
+	sr.position = offset
+	return offset, nil
+}
+
+// Size returns the virtual size of the file, including the holes
+func (sr *sparseReader) Size() int64 {
+	return sr.size
+}
@@ -0,0 +1,102 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sparseEntry encodes an SF entry as described by RRIP 1.12
+func sparseEntry(size uint64, depth byte) SystemUseEntry {
+	data := make([]byte, 17)
+	WriteInt32LSBMSB(data[0:8], int32(size>>32))
+	WriteInt32LSBMSB(data[8:16], int32(uint32(size)))
+	data[16] = depth
+	return newSystemUseEntry("SF", 1, data)
+}
+
+// sparseTableBlock returns a table of block numbers with the given entries set
+func sparseTableBlock(entries map[int]uint32) []byte {
+	table := make([]byte, sectorSize)
+	for index, block := range entries {
+		binary.LittleEndian.PutUint32(table[4*index:], block)
+	}
+	return table
+}
+
+func TestSparseFiles(t *testing.T) {
+	blockA := bytes.Repeat([]byte("A"), int(sectorSize))
+	blockB := bytes.Repeat([]byte("B"), int(sectorSize))
+	blockC := bytes.Repeat([]byte("C"), int(sectorSize))
+
+	// one table at block 100, with the data in the blocks following it
+	flat := append(sparseTableBlock(map[int]uint32{0: 101, 3: 102}), append(blockA, blockB...)...)
+	flatSize := 3*int(sectorSize) + 100
+	flatExpected := append(append(append([]byte{}, blockA...), make([]byte, 2*sectorSize)...), blockB[:100]...)
+
+	// two levels of tables at blocks 200 and 201, the second entry of the root covers the holes at the end
+	deep := append(sparseTableBlock(map[int]uint32{0: 201}), append(sparseTableBlock(map[int]uint32{2: 202}), blockC...)...)
+	deepSize := (sparseTableEntries + 2) * int(sectorSize)
+	deepExpected := make([]byte, deepSize)
+	copy(deepExpected[2*sectorSize:], blockC)
+
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(bytes.NewReader(flat), "flat.bin"))
+	require.NoError(t, w.AddFile(bytes.NewReader(deep), "deep.bin"))
+	require.NoError(t, w.AddFile(bytes.NewReader(flat), "broken.bin"))
+	require.NoError(t, w.SetFixedLBA("flat.bin", 100))
+	require.NoError(t, w.SetFixedLBA("deep.bin", 200))
+	w.lookup("flat.bin").edit().systemUse = []SystemUseEntry{sparseEntry(uint64(flatSize), 1)}
+	w.lookup("deep.bin").edit().systemUse = []SystemUseEntry{sparseEntry(uint64(deepSize), 2)}
+	w.lookup("broken.bin").edit().systemUse = []SystemUseEntry{sparseEntry(uint64(sparseTableEntries+1)*uint64(sectorSize), 1)}
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "sparse"))
+
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	for name, expected := range map[string][]byte{"/flat.bin": flatExpected, "/deep.bin": deepExpected} {
+		f, err := img.LookupPath(name)
+		require.NoError(t, err)
+		assert.True(t, f.IsSparse(), name)
+		assert.Equal(t, int64(len(expected)), f.Size(), name)
+
+		r, err := f.OpenReaderAt()
+		require.NoError(t, err, name)
+		assert.Equal(t, int64(len(expected)), r.Size(), name)
+		assert.NoError(t, iotest.TestReader(r, expected), name)
+		assert.Equal(t, string(expected), readPath(t, img, name), name)
+	}
+
+	broken, err := img.LookupPath("/broken.bin")
+	require.NoError(t, err)
+	_, err = broken.OpenReader()
+	assert.ErrorIs(t, err, errSparseCorrupted, "the table cannot cover the size")
+
+	// remastering writes the holes as zeroes
+	remaster, err := NewWriterFromImage(img)
+	require.NoError(t, err)
+	defer remaster.Cleanup() // nolint: errcheck
+	require.NoError(t, remaster.Remove("broken.bin"))
+	var remastered bytes.Buffer
+	require.NoError(t, remaster.WriteTo(&remastered, "expanded"))
+
+	expanded, err := OpenImage(bytes.NewReader(remastered.Bytes()))
+	require.NoError(t, err)
+	f, err := expanded.LookupPath("/deep.bin")
+	require.NoError(t, err)
+	assert.False(t, f.IsSparse())
+	data, err := io.ReadAll(f.Reader())
+	require.NoError(t, err)
+	assert.Equal(t, deepExpected, data)
+}
@@ -0,0 +1,696 @@
+package iso9660
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stagedSource provides the contents of a staged regular file.
+type stagedSource interface {
+	// Open returns a reader positioned at the beginning of the contents
+	Open() (io.ReadCloser, error)
+	// Size returns the length of the contents in bytes
+	Size() int64
+}
+
+// localFileSource is a file in the staging directory or elsewhere on the local filesystem
+type localFileSource struct {
+	path string
+	size int64
+}
+
+func (s *localFileSource) Open() (io.ReadCloser, error) {
+	return os.Open(s.path)
+}
+
+func (s *localFileSource) Size() int64 {
+	return s.size
+}
+
+// packSource is the data of a small file in the pack file of the staging directory, see ImageWriter.appendToPack
+type packSource struct {
+	pack   *os.File
+	offset int64
+	size   int64
+}
+
+func (s *packSource) Open() (io.ReadCloser, error) {
+	return io.NopCloser(io.NewSectionReader(s.pack, s.offset, s.size)), nil
+}
+
+func (s *packSource) Size() int64 {
+	return s.size
+}
+
+// imageExtentSource is an extent of an existing image, read lazily during WriteTo
+type imageExtentSource struct {
+	ra     io.ReaderAt
+	offset int64
+	size   int64
+	// location is the first sector of the data in the image, 0 if its extents aren't contiguous
+	location uint32
+}
+
+func (s *imageExtentSource) Open() (io.ReadCloser, error) {
+	return io.NopCloser(io.NewSectionReader(s.ra, s.offset, s.size)), nil
+}
+
+func (s *imageExtentSource) Size() int64 {
+	return s.size
+}
+
+// zisofsExtentSource is a zisofs compressed file of an existing image, decompressed lazily during WriteTo
+type zisofsExtentSource struct {
+	compressed imageExtentSource
+	info       *zisofsInfo
+}
+
+func (s *zisofsExtentSource) Open() (io.ReadCloser, error) {
+	zr, err := newZisofsReader(io.NewSectionReader(s.compressed.ra, s.compressed.offset, s.compressed.size), s.info)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(zr), nil
+}
+
+func (s *zisofsExtentSource) Size() int64 {
+	return int64(s.info.uncompressedSize)
+}
+
+// sparseFileSource is a sparse file of an existing image, written with its holes filled in during WriteTo
+type sparseFileSource struct {
+	ra   io.ReaderAt
+	root uint32
+	info *sparseInfo
+}
+
+func (s *sparseFileSource) Open() (io.ReadCloser, error) {
+	sr, err := newSparseReader(s.ra, s.root, s.info)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(sr), nil
+}
+
+func (s *sparseFileSource) Size() int64 {
+	return int64(s.info.size)
+}
+
+// memorySource holds the contents of a small staged file or of a file generated while writing
+type memorySource struct {
+	data []byte
+}
+
+func (s *memorySource) Open() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(s.data)), nil
+}
+
+func (s *memorySource) Size() int64 {
+	return int64(len(s.data))
+}
+
+// streamSource is the data of a file added with AddStreamedFile, which is read once, while the image is written
+type streamSource struct {
+	mu       sync.Mutex
+	r        io.Reader
+	size     int64
+	consumed bool
+}
+
+// errStreamedFileConsumed is returned when the data of a streamed file is needed more than once
+var errStreamedFileConsumed = errors.New("the data of a streamed file has already been read")
+
+func (s *streamSource) Open() (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.consumed {
+		return nil, errStreamedFileConsumed
+	}
+	s.consumed = true
+	return &exactReader{r: s.r, remaining: s.size}, nil
+}
+
+func (s *streamSource) Size() int64 {
+	return s.size
+}
+
+// exactReader reads a stream which has to hold exactly the declared number of bytes.
+// Close fails if there is more.
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (r *exactReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		return n, fmt.Errorf("%w: the stream ended %d bytes before its declared size", io.ErrUnexpectedEOF, r.remaining)
+	}
+	return n, err
+}
+
+func (r *exactReader) Close() error {
+	if r.remaining > 0 {
+		return nil
+	}
+	var probe [1]byte
+	n, err := r.r.Read(probe[:])
+	for n == 0 && err == nil {
+		n, err = r.r.Read(probe[:])
+	}
+	if n > 0 {
+		return errors.New("the stream is longer than its declared size")
+	}
+	return nil
+}
+
+// stagedEntry is a node of the tree which the ImageWriter will write out.
+type stagedEntry struct {
+	// name is the original name of the entry, used for the Rock Ridge NM entry
+	name string
+
+	parent *stagedEntry
+	// children holds the entries of a directory sorted by name, which is the order they are laid out in
+	children []*stagedEntry
+
+	mode fs.FileMode
+	uid  uint32
+	gid  uint32
+	// hidden sets the existence flag of the entry's directory record
+	hidden bool
+
+	modTime time.Time
+	// details holds the metadata most entries don't have, it's nil until some is set
+	details *entryDetails
+
+	// source holds the data of regular files
+	source stagedSource
+	// origin describes where the entry was staged from, for error messages
+	origin string
+}
+
+// entryDetails is the metadata of a staged entry which is kept apart, so that a huge tree of plain files takes less memory
+type entryDetails struct {
+	// identifier, if not empty, is used verbatim as the ISO9660 file identifier
+	// instead of mangling the name
+	identifier string
+	// times, if set, overrides the recorded times
+	times         *RecordTimes
+	symlinkTarget string
+	// devMajor and devMinor are the device number of block and character devices
+	devMajor uint32
+	devMinor uint32
+
+	// systemUse holds the System Use entries added with AddSystemUseEntry
+	systemUse []SystemUseEntry
+}
+
+// noDetails is read for the entries without details and is never modified
+var noDetails entryDetails
+
+// info returns the details of the entry for reading
+func (e *stagedEntry) info() *entryDetails {
+	if e.details == nil {
+		return &noDetails
+	}
+	return e.details
+}
+
+// edit returns the details of the entry for changing them, allocating them on first use
+func (e *stagedEntry) edit() *entryDetails {
+	if e.details == nil {
+		e.details = &entryDetails{}
+	}
+	return e.details
+}
+
+func newStagedDirectory(name string, modTime time.Time) *stagedEntry {
+	return &stagedEntry{
+		name:    name,
+		mode:    fs.ModeDir | 0755,
+		modTime: modTime,
+	}
+}
+
+func newStagedFile(name string, source stagedSource, modTime time.Time) *stagedEntry {
+	return &stagedEntry{
+		name:    name,
+		mode:    0644,
+		modTime: modTime,
+		source:  source,
+	}
+}
+
+func newStagedSymlink(name, target string, modTime time.Time) *stagedEntry {
+	return &stagedEntry{
+		name:    name,
+		mode:    fs.ModeSymlink | 0777,
+		modTime: modTime,
+		details: &entryDetails{symlinkTarget: target},
+	}
+}
+
+func newStagedSpecialFile(name string, mode fs.FileMode, modTime time.Time) *stagedEntry {
+	return &stagedEntry{
+		name:    name,
+		mode:    mode,
+		modTime: modTime,
+	}
+}
+
+func (e *stagedEntry) isDir() bool {
+	return e.mode.IsDir()
+}
+
+// kind names the type of the entry for error messages
+func (e *stagedEntry) kind() string {
+	switch {
+	case e.isDir():
+		return "directory"
+	case e.mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case e.mode&fs.ModeDevice != 0:
+		return "device"
+	case e.mode&fs.ModeNamedPipe != 0:
+		return "FIFO"
+	default:
+		return "file"
+	}
+}
+
+// size returns the length of the entry's data. Only regular files have data.
+func (e *stagedEntry) size() int64 {
+	if e.source == nil {
+		return 0
+	}
+	return e.source.Size()
+}
+
+// path returns the slash-separated path of the entry relative to the root
+func (e *stagedEntry) path() string {
+	if e.parent == nil {
+		return "/"
+	}
+	if e.parent.parent == nil {
+		return "/" + e.name
+	}
+	return e.parent.path() + "/" + e.name
+}
+
+// childIndex returns the position of the child with the given name, or the position it would be inserted at
+func (e *stagedEntry) childIndex(name string) (int, bool) {
+	return slices.BinarySearchFunc(e.children, name, func(c *stagedEntry, name string) int {
+		return strings.Compare(c.name, name)
+	})
+}
+
+// child returns the child with the given name or nil
+func (e *stagedEntry) child(name string) *stagedEntry {
+	if i, ok := e.childIndex(name); ok {
+		return e.children[i]
+	}
+	return nil
+}
+
+// addChild inserts the child, replacing one of the same name
+func (e *stagedEntry) addChild(child *stagedEntry) {
+	child.parent = e
+	i, ok := e.childIndex(child.name)
+	if ok {
+		e.children[i] = child
+		return
+	}
+	e.children = slices.Insert(e.children, i, child)
+}
+
+func (e *stagedEntry) removeChild(name string) {
+	if i, ok := e.childIndex(name); ok {
+		e.children[i].parent = nil
+		e.children = slices.Delete(e.children, i, i+1)
+	}
+}
+
+// rootEntry returns the root of the staged tree, creating it if necessary.
+// The tree functions below expect the caller to hold iw.mu.
+func (iw *ImageWriter) rootEntry() *stagedEntry {
+	if iw.root == nil {
+		iw.root = iw.newDirectoryEntry("")
+	}
+	return iw.root
+}
+
+// lookup finds the staged entry at the given path or returns nil
+func (iw *ImageWriter) lookup(isoPath string) *stagedEntry {
+	segments, err := iw.pathSegments(isoPath)
+	if err != nil {
+		return nil
+	}
+	return iw.lookupSegments(segments)
+}
+
+// lookupSegments finds the staged entry with the given path segments, converted by pathSegments, or returns nil
+func (iw *ImageWriter) lookupSegments(segments []string) *stagedEntry {
+	current := iw.rootEntry()
+	for _, segment := range segments {
+		if !current.isDir() {
+			return nil
+		}
+		next := current.child(segment)
+		if next == nil {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
+
+// mkdirAll returns the staged directory at the given path segments, creating any missing ones.
+// The created directories are attributed to the given origin.
+func (iw *ImageWriter) mkdirAll(segments []string, origin string) (*stagedEntry, error) {
+	current := iw.rootEntry()
+	for i, segment := range segments {
+		next := current.child(segment)
+		if next == nil {
+			next = iw.newDirectoryEntry(segment)
+			next.origin = origin
+			current.addChild(next)
+		} else if !next.isDir() {
+			return nil, fmt.Errorf("/%s is not a directory, it is a %s staged from %s",
+				strings.Join(segments[:i+1], "/"), next.kind(), next.origin)
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// lockForModification locks the staged tree, unless the image is being written
+func (iw *ImageWriter) lockForModification() error {
+	iw.mu.Lock()
+	if iw.writing {
+		iw.mu.Unlock()
+		return ErrWriteInProgress
+	}
+	return nil
+}
+
+// stage puts the entry into the staged tree at the given path, creating parent directories as needed.
+// A directory is merged into an existing one. An existing entry of another type is a conflict,
+// as is an existing file or symlink, unless overwriting is allowed.
+func (iw *ImageWriter) stage(isoPath string, entry *stagedEntry) error {
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	segments, err := iw.pathSegments(isoPath)
+	if err != nil {
+		return fmt.Errorf("cannot stage %q from %s: %w", isoPath, entry.origin, err)
+	}
+	if len(segments) == 0 {
+		return fmt.Errorf("cannot stage %q: path is empty", isoPath)
+	}
+	if d := entry.info(); d.symlinkTarget != "" {
+		if d.symlinkTarget, err = iw.decodeName(d.symlinkTarget); err != nil {
+			return fmt.Errorf("cannot stage %q from %s: %w", isoPath, entry.origin, err)
+		}
+	}
+
+	parent, err := iw.mkdirAll(segments[:len(segments)-1], entry.origin)
+	if err != nil {
+		return fmt.Errorf("cannot stage %q from %s: %w", isoPath, entry.origin, err)
+	}
+
+	entry.name = segments[len(segments)-1]
+	if existing := parent.child(entry.name); existing != nil {
+		switch {
+		case existing.isDir() && entry.isDir():
+			existing.mode = entry.mode
+			existing.modTime = entry.modTime
+			if t := entry.info().times; t != nil {
+				existing.edit().times = t
+			}
+			return nil
+		case existing.isDir() || entry.isDir():
+			return fmt.Errorf("cannot stage %s %q from %s: %w as a %s from %s",
+				entry.kind(), isoPath, entry.origin, ErrAlreadyStaged, existing.kind(), existing.origin)
+		case !iw.allowOverwrite:
+			return fmt.Errorf("cannot stage %q from %s: %w from %s", isoPath, entry.origin, ErrAlreadyStaged, existing.origin)
+		}
+		iw.release(existing)
+	}
+
+	parent.addChild(entry)
+	return nil
+}
+
+// addDirectory creates the staged directory at the given path along with its parents,
+// or applies the options to it if it already exists
+func (iw *ImageWriter) addDirectory(isoPath, origin string, opts []EntryOption) error {
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	segments, err := iw.pathSegments(isoPath)
+	if err != nil {
+		return fmt.Errorf("cannot stage %q from %s: %w", isoPath, origin, err)
+	}
+	dir := iw.rootEntry()
+	if len(segments) > 0 {
+		parent, err := iw.mkdirAll(segments[:len(segments)-1], origin)
+		if err != nil {
+			return fmt.Errorf("cannot stage %q from %s: %w", isoPath, origin, err)
+		}
+
+		name := segments[len(segments)-1]
+		existing := parent.child(name)
+		switch {
+		case existing == nil:
+			dir = iw.newDirectoryEntry(name)
+			dir.origin = origin
+			parent.addChild(dir)
+		case !existing.isDir():
+			return fmt.Errorf("cannot stage directory %q from %s: %w as a %s from %s",
+				isoPath, origin, ErrAlreadyStaged, existing.kind(), existing.origin)
+		default:
+			dir = existing
+		}
+	}
+
+	for _, opt := range opts {
+		opt(dir)
+	}
+	return nil
+}
+
+// applyOptions sets metadata of an already staged entry
+func (iw *ImageWriter) applyOptions(isoPath string, opts []EntryOption) error {
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	entry := iw.lookup(isoPath)
+	if entry == nil {
+		return fmt.Errorf("%q: %w", isoPath, os.ErrNotExist)
+	}
+	for _, opt := range opts {
+		opt(entry)
+	}
+	return nil
+}
+
+// Remove deletes a staged file or directory, including all its contents.
+func (iw *ImageWriter) Remove(isoPath string) error {
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	entry := iw.lookup(isoPath)
+	if entry == nil {
+		return fmt.Errorf("removing %q: %w", isoPath, os.ErrNotExist)
+	}
+	if entry.parent == nil {
+		return fmt.Errorf("removing %q: cannot remove the root directory", isoPath)
+	}
+
+	entry.parent.removeChild(entry.name)
+	iw.release(entry)
+	return nil
+}
+
+// release returns the memory held by the contents of an entry and its children, which are no longer staged, to the budget
+func (iw *ImageWriter) release(e *stagedEntry) {
+	if s, ok := e.source.(*memorySource); ok {
+		iw.memoryStaged.Add(-s.Size())
+	}
+	delete(iw.alignments, e)
+	delete(iw.fixedLBAs, e)
+	for _, c := range e.children {
+		iw.release(c)
+	}
+}
+
+// SetHidden sets or clears the existence flag of a staged file or directory,
+// which hides it from directory listings of most operating systems.
+// For directories, it only applies to the directory itself and not to its contents.
+func (iw *ImageWriter) SetHidden(isoPath string, hidden bool) error {
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	entry := iw.lookup(isoPath)
+	if entry == nil {
+		return fmt.Errorf("hiding %q: %w", isoPath, os.ErrNotExist)
+	}
+	if entry.parent == nil {
+		return fmt.Errorf("hiding %q: the root directory cannot be hidden", isoPath)
+	}
+
+	entry.hidden = hidden
+	return nil
+}
+
+// maxAddedSystemUseLength limits the total length of the System Use entries added to a staged entry
+const maxAddedSystemUseLength = 64 * 1024
+
+// AddSystemUseEntry attaches a custom SUSP entry to the directory record of a staged entry,
+// such as a vendor extension with a private signature. Added entries follow the Rock Ridge entries
+// in the order they were added and are moved to a continuation area if they don't fit into the record.
+// Data longer than the 251 bytes an entry can hold is split into consecutive entries with the same signature.
+// The entries of the root directory are recorded in its "." entry.
+//
+// The signature must be two upper case letters or digits, other than those of SUSP, Rock Ridge and zisofs.
+// The extension the entries belong to can be announced with RegisterExtension, they are read back with
+// SystemUseEntrySlice.Unknown and Lookup. System Use entries require Rock Ridge to be enabled when the image is written.
+func (iw *ImageWriter) AddSystemUseEntry(isoPath string, signature string, version byte, data []byte) error {
+	if len(signature) != 2 || !validSignature(signature) {
+		return fmt.Errorf("invalid System Use entry signature %q, it must be two upper case letters or digits", signature)
+	}
+	if suspSignatures[signature] {
+		return fmt.Errorf("the System Use entry signature %s is reserved for SUSP, Rock Ridge or zisofs", signature)
+	}
+
+	var entries []SystemUseEntry
+	for len(data) > maxSystemUseEntryData {
+		entries = append(entries, newSystemUseEntry(signature, version, data[:maxSystemUseEntryData]))
+		data = data[maxSystemUseEntryData:]
+	}
+	entries = append(entries, newSystemUseEntry(signature, version, data))
+
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	entry := iw.lookup(isoPath)
+	if entry == nil {
+		return fmt.Errorf("adding a System Use entry to %q: %w", isoPath, os.ErrNotExist)
+	}
+	if length := systemUseEntriesLength(entry.info().systemUse) + systemUseEntriesLength(entries); length > maxAddedSystemUseLength {
+		return fmt.Errorf("adding a System Use entry to %q: the added entries would take %d bytes, more than the maximum of %d",
+			isoPath, length, maxAddedSystemUseLength)
+	}
+
+	d := entry.edit()
+	d.systemUse = append(d.systemUse, entries...)
+	return nil
+}
+
+// RegisterExtension announces a SUSP extension with an ER entry in the root directory, after the one of Rock Ridge,
+// so that readers can tell which extension the entries added with AddSystemUseEntry belong to.
+// The identifier must be unique and not one of Rock Ridge, which is selected with SetRockRidgeIdentifier,
+// and the identifier, descriptor and source must fit into the 251 bytes of the ER entry.
+// ES entries aren't recorded, the entries of the extensions are told apart by their signatures.
+// Registered extensions require Rock Ridge to be enabled when the image is written.
+func (iw *ImageWriter) RegisterExtension(er ExtensionRecord) error {
+	switch {
+	case er.Identifier == "":
+		return errors.New("the identifier of a SUSP extension cannot be empty")
+	case slices.Contains(RockRidgeIdentifiers, er.Identifier):
+		return fmt.Errorf("the Rock Ridge extension %s is selected with SetRockRidgeIdentifier", er.Identifier)
+	case er.Version < 0 || er.Version > 255:
+		return fmt.Errorf("invalid version %d of the SUSP extension %s", er.Version, er.Identifier)
+	case 4+len(er.Identifier)+len(er.Descriptor)+len(er.Source) > maxSystemUseEntryData:
+		return fmt.Errorf("the identifier, descriptor and source of the SUSP extension %s don't fit into an ER entry", er.Identifier)
+	}
+
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	for _, registered := range iw.extensions {
+		if registered.Identifier == er.Identifier {
+			return fmt.Errorf("the SUSP extension %s is already registered", er.Identifier)
+		}
+	}
+	iw.extensions = append(iw.extensions, er)
+	return nil
+}
+
+// Rename moves a staged file or directory to a new path.
+// The parent directories of the new path are created as needed.
+func (iw *ImageWriter) Rename(oldPath, newPath string) error {
+	if err := iw.lockForModification(); err != nil {
+		return err
+	}
+	defer iw.mu.Unlock()
+
+	entry := iw.lookup(oldPath)
+	if entry == nil {
+		return fmt.Errorf("renaming %q: %w", oldPath, os.ErrNotExist)
+	}
+	if entry.parent == nil {
+		return fmt.Errorf("renaming %q: cannot rename the root directory", oldPath)
+	}
+	if iw.lookup(newPath) != nil {
+		return fmt.Errorf("renaming %q: %q already exists", oldPath, newPath)
+	}
+
+	segments, err := iw.pathSegments(newPath)
+	if err != nil {
+		return fmt.Errorf("renaming %q: %w", oldPath, err)
+	}
+	if len(segments) == 0 {
+		return fmt.Errorf("renaming %q: new path is empty", oldPath)
+	}
+
+	// don't allow moving a directory into itself
+	for parent := iw.lookupSegments(segments[:len(segments)-1]); parent != nil; parent = parent.parent {
+		if parent == entry {
+			return fmt.Errorf("renaming %q: cannot move a directory into itself", oldPath)
+		}
+	}
+
+	newParent, err := iw.mkdirAll(segments[:len(segments)-1], fmt.Sprintf("renaming %q", oldPath))
+	if err != nil {
+		return fmt.Errorf("renaming %q: %w", oldPath, err)
+	}
+
+	entry.parent.removeChild(entry.name)
+	entry.name = segments[len(segments)-1]
+	// the identifier carried over from a source image no longer matches the name
+	if entry.details != nil {
+		entry.details.identifier = ""
+	}
+	newParent.addChild(entry)
+	return nil
+}
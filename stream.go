@@ -0,0 +1,323 @@
+package iso9660
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ErrStreamBufferLimit is returned by ExtractStream when it would have to keep more of the stream in memory
+// than allowed by WithStreamBufferLimit
+var ErrStreamBufferLimit = errors.New("the stream buffer limit was exceeded")
+
+// errStreamConsumed is returned for reads of the stream behind the data ExtractStream still keeps
+var errStreamConsumed = errors.New("reading data of the stream which was already consumed")
+
+// StreamOption configures ExtractStream
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	reader      ReaderOptions
+	bufferLimit int64
+}
+
+// WithStreamReaderOptions sets the options the image is read with
+func WithStreamReaderOptions(opts ReaderOptions) StreamOption {
+	return func(o *streamOptions) {
+		o.reader = opts
+	}
+}
+
+// WithStreamBufferLimit limits the number of bytes of the stream kept in memory.
+// By default there is no limit.
+func WithStreamBufferLimit(limit int64) StreamOption {
+	return func(o *streamOptions) {
+		o.bufferLimit = limit
+	}
+}
+
+// ExtractStream extracts the image read sequentially from r into destDir, like util.ExtractImageToDirectory.
+// It suits images which cannot be read at random, e.g. when they are piped from a download or a decompressor.
+//
+// The stream is kept in memory up to the last directory, so that the whole tree can be listed,
+// and the extents of the files are then copied in the order of their locations as the rest of the stream
+// flows past. The padding and the gaps between the extents are discarded.
+// The sections of multi-extent files are written at their offsets in the same file.
+// The data of zisofs-compressed files, and of files whose extents overlap the next ones, e.g. hard links,
+// is also kept in memory until it has been extracted.
+//
+// Images written by this package and by the usual mastering tools place all the directories before the file data.
+// Where directories follow file data, that data is buffered too, in the worst case the whole image.
+// With WithStreamBufferLimit, ExtractStream fails with ErrStreamBufferLimit instead.
+func ExtractStream(r io.Reader, destDir string, opts ...StreamOption) error {
+	var options streamOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	s := &streamReaderAt{r: r, limit: options.bufferLimit}
+	img, err := OpenImageWithOptions(s, options.reader)
+	if err != nil {
+		return err
+	}
+	root, err := img.RootDir()
+	if err != nil {
+		return err
+	}
+
+	x := &streamExtractor{}
+	if err := x.walk(root, destDir); err != nil {
+		return err
+	}
+	return x.extract(s)
+}
+
+// streamJob is an extent to copy to a local file at the given offset
+type streamJob struct {
+	file *File
+	// record describes the extent, one of several for a multi-extent file
+	record *DirectoryEntry
+	target string
+	offset int64
+}
+
+func (j *streamJob) start() int64 {
+	return j.record.dataOffset(j.file.logicalBlockSize())
+}
+
+type streamExtractor struct {
+	jobs []streamJob
+}
+
+// walk creates the directories and empty files of the tree, and collects the extents to copy
+func (x *streamExtractor) walk(dir *File, target string) error {
+	if info, err := os.Stat(target); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s already exists and is a file", target)
+		}
+	} else if os.IsNotExist(err) {
+		if err := os.Mkdir(target, 0755); err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+
+	children, err := dir.GetChildren()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range children {
+		childTarget := filepath.Join(target, c.Name())
+		if c.IsDir() {
+			if err := x.walk(c, childTarget); err != nil {
+				return err
+			}
+			continue
+		}
+
+		newFile, err := os.Create(childTarget)
+		if err != nil {
+			return err
+		}
+		if err := newFile.Close(); err != nil {
+			return err
+		}
+
+		// the sections of a multi-extent file follow each other in the local file
+		offset := int64(0)
+		for _, record := range c.records() {
+			x.jobs = append(x.jobs, streamJob{file: c, record: record, target: childTarget, offset: offset})
+			offset += int64(record.ExtentLength)
+			if c.zisofsInfo() != nil || c.IsSparse() {
+				// decompressed or read through the tables at once from all of its sections
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// extract copies the extents in the order of their locations
+func (x *streamExtractor) extract(s *streamReaderAt) error {
+	sort.SliceStable(x.jobs, func(a, b int) bool {
+		return x.jobs[a].record.ExtentLocation < x.jobs[b].record.ExtentLocation
+	})
+
+	for n := range x.jobs {
+		job := &x.jobs[n]
+		length := int64(job.record.ExtentLength)
+		if length == 0 {
+			continue
+		}
+
+		start := job.start()
+		if err := s.release(start); err != nil {
+			return fmt.Errorf("%s: %w", job.target, err)
+		}
+
+		var data io.Reader
+		if job.file.zisofsInfo() != nil || job.file.IsSparse() {
+			var err error
+			if data, err = job.file.OpenReader(); err != nil {
+				return fmt.Errorf("%s: %w", job.target, err)
+			}
+		} else if n+1 < len(x.jobs) && x.jobs[n+1].start() < start+length {
+			// read through ReadAt, which keeps the data
+			data = io.NewSectionReader(s, start, length)
+		} else {
+			data = &streamSection{s: s, offset: start, remaining: length}
+		}
+
+		if err := copyToOffset(job.target, job.offset, data); err != nil {
+			return fmt.Errorf("extracting %s: %w", job.target, err)
+		}
+	}
+	return nil
+}
+
+// copyToOffset writes the data to an existing file, starting at the given offset
+func copyToOffset(target string, offset int64, data io.Reader) error {
+	out, err := os.OpenFile(target, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		if _, err := out.Seek(offset, io.SeekStart); err != nil {
+			out.Close() // nolint: errcheck
+			return err
+		}
+	}
+	if _, err := io.Copy(out, data); err != nil {
+		out.Close() // nolint: errcheck
+		return err
+	}
+	return out.Close()
+}
+
+// streamReaderAt serves random reads from a sequential stream, keeping the data it has read in memory
+// until it is released
+type streamReaderAt struct {
+	r io.Reader
+	// buffer holds the data of the stream starting at the offset base
+	buffer []byte
+	base   int64
+	limit  int64
+}
+
+func (s *streamReaderAt) end() int64 {
+	return s.base + int64(len(s.buffer))
+}
+
+// fill reads the stream until the buffer reaches the offset end, or the stream ends
+func (s *streamReaderAt) fill(end int64) error {
+	if end <= s.end() {
+		return nil
+	}
+	if s.limit > 0 && end-s.base > s.limit {
+		return fmt.Errorf("%w: keeping %d bytes from offset %d", ErrStreamBufferLimit, end-s.base, s.base)
+	}
+
+	missing := end - s.end()
+	if missing > math.MaxInt32 {
+		return fmt.Errorf("%w: keeping %d bytes from offset %d", ErrStreamBufferLimit, end-s.base, s.base)
+	}
+	current := len(s.buffer)
+	if cap(s.buffer)-current < int(missing) {
+		grown := make([]byte, current, 2*cap(s.buffer)+int(missing))
+		copy(grown, s.buffer)
+		s.buffer = grown
+	}
+	n, err := io.ReadFull(s.r, s.buffer[current:current+int(missing)])
+	s.buffer = s.buffer[:current+n]
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return err
+}
+
+func (s *streamReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < s.base {
+		return 0, fmt.Errorf("%w: offset %d, the stream is at offset %d", errStreamConsumed, off, s.base)
+	}
+	err := s.fill(off + int64(len(p)))
+	if off >= s.end() {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	n := copy(p, s.buffer[off-s.base:])
+	if n < len(p) && err == nil {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// release drops the data before the offset, and skips the stream up to it
+func (s *streamReaderAt) release(offset int64) error {
+	if offset < s.base {
+		return fmt.Errorf("%w: offset %d, the stream is at offset %d", errStreamConsumed, offset, s.base)
+	}
+	if offset <= s.end() {
+		kept := s.buffer[offset-s.base:]
+		if len(kept) == 0 {
+			kept = nil
+		} else if len(kept) < cap(s.buffer)/2 {
+			kept = append([]byte(nil), kept...)
+		}
+		s.buffer, s.base = kept, offset
+		return nil
+	}
+
+	skip := offset - s.end()
+	s.buffer, s.base = nil, s.end()
+	n, err := io.CopyN(io.Discard, s.r, skip)
+	s.base += n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// streamSection reads a part of the stream, from the kept data and then from the stream itself without keeping it
+type streamSection struct {
+	s         *streamReaderAt
+	offset    int64
+	remaining int64
+}
+
+func (r *streamSection) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	s := r.s
+	var n int
+	var err error
+	if r.offset < s.end() {
+		n = copy(p, s.buffer[r.offset-s.base:])
+	} else {
+		// the section continues right after the kept data, which isn't needed anymore
+		s.buffer, s.base = nil, r.offset
+		n, err = s.r.Read(p)
+		s.base += int64(n)
+	}
+	r.offset += int64(n)
+	r.remaining -= int64(n)
+
+	if err == io.EOF && r.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
@@ -0,0 +1,133 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamImage returns a Rock Ridge image with nested directories, a zisofs-compressed file and a hard link
+func streamImage(t *testing.T) ([]byte, map[string][]byte) {
+	random := make([]byte, 3*sectorSize+17)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	files := map[string][]byte{
+		"a/b/c/deep.bin":  random,
+		"a/small.txt":     []byte("small"),
+		"a/linked.txt":    []byte("linked"),
+		"a/link.txt":      []byte("linked"),
+		"compressed.txt":  []byte(strings.Repeat(loremIpsum, 50)),
+		"empty":           {},
+		"z/last-file.bin": random[:sectorSize],
+	}
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for name, data := range files {
+		if name == "a/link.txt" {
+			continue
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "a/link.txt", Linkname: "a/linked.txt"}))
+	require.NoError(t, tw.Close())
+
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Zisofs: &ZisofsOptions{}})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddTar(&archive, ""))
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "stream"))
+	return buf.Bytes(), files
+}
+
+func assertExtracted(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, expected := range files {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, expected, data, name)
+		}
+	}
+}
+
+func TestExtractStream(t *testing.T) {
+	image, files := streamImage(t)
+
+	img, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+	byPath := filesByPath(t, img)
+	require.NotNil(t, byPath["/compressed.txt"].zisofsInfo())
+	require.Equal(t, byPath["/a/linked.txt"].de.ExtentLocation, byPath["/a/link.txt"].de.ExtentLocation)
+
+	dir := t.TempDir()
+	require.NoError(t, ExtractStream(onlyReader{bytes.NewReader(image)}, dir))
+	assertExtracted(t, dir, files)
+}
+
+func TestExtractStreamMultiExtent(t *testing.T) {
+	first := make([]byte, 2*sectorSize)
+	_, err := rand.Read(first)
+	require.NoError(t, err)
+	second := []byte("the last section")
+
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(bytes.NewReader(first), "PARTA.BIN"))
+	require.NoError(t, w.AddFile(bytes.NewReader(second), "PARTB.BIN"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "multi"))
+	image := buf.Bytes()
+
+	// turn the two files into the sections of one
+	for _, id := range []string{"PARTA.BIN;1", "PARTB.BIN;1"} {
+		at := bytes.Index(image, append([]byte{byte(len(id))}, id...))
+		require.Greater(t, at, 32, id)
+		record := image[at-32:]
+		if id == "PARTA.BIN;1" {
+			record[25] |= dirFlagMultiExtent
+		} else {
+			record[33+4] = 'A'
+		}
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, ExtractStream(bytes.NewReader(image), dir))
+	assertExtracted(t, dir, map[string][]byte{"PARTA.BIN": append(first, second...)})
+}
+
+func TestExtractStreamErrors(t *testing.T) {
+	image, _ := streamImage(t)
+
+	t.Run("buffer limit", func(t *testing.T) {
+		err := ExtractStream(bytes.NewReader(image), t.TempDir(), WithStreamBufferLimit(20*int64(sectorSize)))
+		assert.True(t, errors.Is(err, ErrStreamBufferLimit), err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		err := ExtractStream(bytes.NewReader(image[:len(image)-int(sectorSize)-100]), t.TempDir())
+		assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), err)
+	})
+
+	t.Run("target is a file", func(t *testing.T) {
+		target := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(target, nil, 0644))
+		assert.Error(t, ExtractStream(bytes.NewReader(image), target))
+	})
+}
@@ -15,20 +15,51 @@ import (
  */
 
 // SUSP-112 4.1
+// The accessors return zero values for the fields missing from entries shorter than the 4 bytes of the header.
 type SystemUseEntry []byte
 
 func (e SystemUseEntry) Length() int {
+	if len(e) < 3 {
+		return 0
+	}
 	return int(e[2])
 }
 
 func (e SystemUseEntry) Data() []byte {
+	if len(e) < 4 {
+		return nil
+	}
 	return e[4:]
 }
 
 func (e SystemUseEntry) Type() string {
+	if len(e) < 2 {
+		return ""
+	}
 	return string(e[:2])
 }
 
+func (e SystemUseEntry) Version() byte {
+	if len(e) < 4 {
+		return 0
+	}
+	return e[3]
+}
+
+// check returns an error unless the entry has the given signature and its length, which the data
+// must hold, covers at least minLength bytes
+func (e SystemUseEntry) check(signature string, minLength int) error {
+	switch {
+	case e.Type() != signature:
+		return fmt.Errorf("%w: wrong type of record %q, expected %s", ErrBadSignature, e.Type(), signature)
+	case e.Length() > len(e) || len(e) < 4:
+		return fmt.Errorf("%w: the %s entry of %d bytes is held in %d bytes", ErrTruncatedRecord, signature, e.Length(), len(e))
+	case e.Length() < minLength:
+		return fmt.Errorf("%w: the %s entry of %d bytes is shorter than %d bytes", ErrTruncatedRecord, signature, e.Length(), minLength)
+	}
+	return nil
+}
+
 type ExtensionRecord struct {
 	Version    int
 	Identifier string
@@ -38,26 +69,14 @@ type ExtensionRecord struct {
 
 // See SUSP-112 5.5
 func ExtensionRecordDecode(e SystemUseEntry) (*ExtensionRecord, error) {
-	if e.Type() != "ER" {
-		return nil, fmt.Errorf("wrong type of record, expected ER")
-	}
-	if e.Length() < 8 {
-		return nil, io.ErrUnexpectedEOF
-	}
-
-	identifierLen := int(e[4])
-	if e.Length() < 8+identifierLen {
-		return nil, io.ErrUnexpectedEOF
+	if err := e.check("ER", 8); err != nil {
+		return nil, err
 	}
 
-	descriptorLen := int(e[5])
-	if e.Length() < 8+identifierLen+descriptorLen {
-		return nil, io.ErrUnexpectedEOF
-	}
-
-	sourceLen := int(e[6])
+	identifierLen, descriptorLen, sourceLen := int(e[4]), int(e[5]), int(e[6])
 	if e.Length() < 8+identifierLen+descriptorLen+sourceLen {
-		return nil, io.ErrUnexpectedEOF
+		return nil, fmt.Errorf("%w: the ER entry of %d bytes is shorter than its identifier, descriptor and source",
+			ErrTruncatedRecord, e.Length())
 	}
 
 	return &ExtensionRecord{
@@ -70,18 +89,15 @@ func ExtensionRecordDecode(e SystemUseEntry) (*ExtensionRecord, error) {
 
 // See SUSP-112 5.3
 func SPRecordDecode(e SystemUseEntry) (*SPRecord, error) {
-	if e.Type() != "SP" {
-		return nil, fmt.Errorf("wrong type of record, expected SP")
-	}
-	if e.Length() < 7 {
-		return nil, io.ErrUnexpectedEOF
+	if err := e.check("SP", 7); err != nil {
+		return nil, err
 	}
 
 	if beByte := e[4]; beByte != 0xBE {
-		return nil, fmt.Errorf("invalid control byte, %x != 0xBE", beByte)
+		return nil, fmt.Errorf("%w: invalid control byte, %x != 0xBE", ErrBadSignature, beByte)
 	}
 	if efByte := e[5]; efByte != 0xEF {
-		return nil, fmt.Errorf("invalid control byte, %x != 0xEF", efByte)
+		return nil, fmt.Errorf("%w: invalid control byte, %x != 0xEF", ErrBadSignature, efByte)
 	}
 
 	return &SPRecord{
@@ -110,6 +126,33 @@ func (s SystemUseEntrySlice) GetExtensionRecords() ([]*ExtensionRecord, error) {
 	return results, nil
 }
 
+// Unknown returns the entries whose signatures aren't those of SUSP, Rock Ridge or zisofs,
+// such as those of other extensions and those added with ImageWriter.AddSystemUseEntry
+func (s SystemUseEntrySlice) Unknown() SystemUseEntrySlice {
+	var unknown SystemUseEntrySlice
+	for _, entry := range s {
+		if !suspSignatures[entry.Type()] {
+			unknown = append(unknown, entry)
+		}
+	}
+	return unknown
+}
+
+// Lookup returns the data of the entries with the given signature, joined in their order,
+// which reassembles the data ImageWriter.AddSystemUseEntry split into several entries.
+// The last return value is false if there are none.
+func (s SystemUseEntrySlice) Lookup(signature string) ([]byte, bool) {
+	var data []byte
+	found := false
+	for _, entry := range s {
+		if entry.Type() == signature {
+			data = append(data, entry.Data()...)
+			found = true
+		}
+	}
+	return data, found
+}
+
 // SUSP-112 5.1
 type ContinuationEntry struct {
 	blockLocation uint32
@@ -118,8 +161,11 @@ type ContinuationEntry struct {
 }
 
 func umarshalContinuationEntry(e SystemUseEntry) (*ContinuationEntry, error) {
+	if err := e.check("CE", 28); err != nil {
+		return nil, err
+	}
 	if e.Length() != 28 {
-		return nil, fmt.Errorf("invalid ContinuationArea record with length %d instead of 28", e.Length())
+		return nil, fmt.Errorf("%w: invalid ContinuationArea record with length %d instead of 28", ErrInvalidRecordLength, e.Length())
 	}
 
 	location, err := UnmarshalUint32LSBMSB(e.Data()[0:8])
@@ -151,8 +197,51 @@ const (
 	SUEType_ExtensionSelector         = "ES"
 )
 
+// suspAnomaly is a problem found while splitting a System Use field.
+// Errors left the rest of the field unread, after warnings only the offending entry was skipped.
+type suspAnomaly struct {
+	severity Severity
+	err      error
+}
+
+// suspSignatures are the entries of SUSP, RRIP and zisofs, whose version is 1
+var suspSignatures = map[string]bool{
+	"CE": true, "PD": true, "SP": true, "ST": true, "ER": true, "ES": true,
+	"PX": true, "PN": true, "SL": true, "NM": true, "CL": true, "PL": true, "RE": true, "TF": true, "SF": true, "RR": true,
+	"ZF": true,
+}
+
+// splitSystemUseEntries splits a System Use field into its entries, following CE entries.
+// It fails on the first problem which leaves the rest of the field unread.
 func splitSystemUseEntries(data []byte, ra io.ReaderAt) ([]SystemUseEntry, error) {
-	output := make([]SystemUseEntry, 0)
+	entries, anomalies := parseSystemUse(data, ra, int64(sectorSize))
+	for _, a := range anomalies {
+		if a.severity == SeverityError {
+			return entries, a.err
+		}
+	}
+	return entries, nil
+}
+
+// parseSystemUse splits a System Use field into its entries, following CE entries, and returns the valid ones
+// along with the problems found. Entries with an invalid signature or with another version than 1
+// are skipped, as is everything after an ST entry. The CE entries locate their areas in logical blocks of the given size.
+func parseSystemUse(data []byte, ra io.ReaderAt, blockSize int64) ([]SystemUseEntry, []suspAnomaly) {
+	return parseSystemUseArea(data, ra, blockSize, nil)
+}
+
+// parseSystemUseArea parses a System Use field or a continuation area, the visited areas guard against CE loops
+func parseSystemUseArea(data []byte, ra io.ReaderAt, blockSize int64, visited map[int64]bool) ([]SystemUseEntry, []suspAnomaly) {
+	// count the entries first, every directory record is split
+	count := 0
+	for rest := data; len(rest) >= 4 && int(rest[2]) >= 4 && int(rest[2]) <= len(rest); rest = rest[rest[2]:] {
+		count++
+	}
+	output := make([]SystemUseEntry, 0, count)
+	var anomalies []suspAnomaly
+	fail := func(err error) ([]SystemUseEntry, []suspAnomaly) {
+		return output, append(anomalies, suspAnomaly{severity: SeverityError, err: err})
+	}
 
 	for len(data) > 0 {
 		if len(data) < 4 {
@@ -162,41 +251,81 @@ func splitSystemUseEntries(data []byte, ra io.ReaderAt) ([]SystemUseEntry, error
 		}
 
 		entryLen := int(data[2])
+		if entryLen < 4 {
+			return fail(fmt.Errorf("splitting System Use entries: %w %d", ErrInvalidRecordLength, entryLen))
+		}
 		if len(data) < entryLen {
-			return nil, fmt.Errorf("splitting System Use entries: %w, expected %d bytes but have only %d", io.ErrUnexpectedEOF, entryLen, len(data))
+			return fail(fmt.Errorf("splitting System Use entries: %w, expected %d bytes but have only %d", ErrTruncatedRecord, entryLen, len(data)))
 		}
 
 		entry := SystemUseEntry(data[:entryLen])
+		data = data[entryLen:]
 
-		if entry.Type() == SUEType_ContinuationArea {
+		switch {
+		case !validSignature(entry.Type()):
+			anomalies = append(anomalies, suspAnomaly{severity: SeverityWarning, err: fmt.Errorf("skipping an entry with the invalid signature %q", entry.Type())})
+		case suspSignatures[entry.Type()] && entry[3] != 1:
+			anomalies = append(anomalies, suspAnomaly{severity: SeverityWarning, err: fmt.Errorf("skipping the %s entry with version %d instead of 1", entry.Type(), entry[3])})
+		case entry.Type() == SUEType_SharingProtocolTerminator:
+			// SUSP-112 5.4, the rest of the field is ignored
+			if !isZero(data) {
+				anomalies = append(anomalies, suspAnomaly{severity: SeverityWarning, err: fmt.Errorf("ignoring the %d bytes after the ST entry", len(data))})
+			}
+			return output, anomalies
+		case entry.Type() == SUEType_ContinuationArea:
 			ce, err := umarshalContinuationEntry(entry)
 			if err != nil {
-				return output, fmt.Errorf("unmarshaling ContinuationEntry: %w", err)
+				return fail(fmt.Errorf("unmarshaling ContinuationEntry: %w", err))
 			}
-			continuation := make([]byte, ce.lengthOfArea)
-			finalOffset := (ce.blockLocation * sectorSize) + ce.offset
-			if _, err := ra.ReadAt(continuation, int64(finalOffset)); err != nil {
-				return output, fmt.Errorf("reading Continuation Area: %w", err)
+			// like Linux, only areas within a single sector are read
+			finalOffset := int64(ce.blockLocation)*blockSize + int64(ce.offset)
+			if uint64(ce.offset) >= uint64(sectorSize) || uint64(finalOffset%int64(sectorSize))+uint64(ce.lengthOfArea) > uint64(sectorSize) {
+				return fail(fmt.Errorf("the Continuation Area of %d bytes at offset %d crosses the end of sector %d",
+					ce.lengthOfArea, ce.offset, ce.blockLocation))
 			}
+			if visited[finalOffset] {
+				return fail(fmt.Errorf("the Continuation Area at offset %d of sector %d is chained in a loop", ce.offset, ce.blockLocation))
+			}
+			if visited == nil {
+				visited = make(map[int64]bool)
+			}
+			visited[finalOffset] = true
 
-			continuedEntries, err := splitSystemUseEntries(continuation, ra)
-			if err != nil {
-				return output, fmt.Errorf("splitting Continuation Area: %w", err)
+			continuation := make([]byte, ce.lengthOfArea)
+			if _, err := ra.ReadAt(continuation, finalOffset); err != nil {
+				return fail(fmt.Errorf("reading Continuation Area: %w", err))
 			}
+
+			continuedEntries, continuedAnomalies := parseSystemUseArea(continuation, ra, blockSize, visited)
 			output = append(output, continuedEntries...)
-		} else {
+			for _, a := range continuedAnomalies {
+				anomalies = append(anomalies, suspAnomaly{severity: a.severity, err: fmt.Errorf("splitting Continuation Area: %w", a.err)})
+			}
+		default:
 			output = append(output, entry)
 		}
-
-		data = data[entryLen:]
 	}
 
-	return output, nil
+	return output, anomalies
+}
+
+// validSignature reports whether a signature is made of upper case letters and digits, as all the known ones are
+func validSignature(signature string) bool {
+	for _, c := range []byte(signature) {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
 }
 
 type SUSPMetadata struct {
 	Offset       uint8
 	HasRockRidge bool
+	// RockRidgeDetection tells how HasRockRidge was determined
+	RockRidgeDetection RockRidgeDetection
+	// RockRidgeRevision is the revision of RRIP in use, if HasRockRidge is set
+	RockRidgeRevision RockRidgeRevision
 }
 
 func (sm *SUSPMetadata) Clone() *SUSPMetadata {
@@ -205,7 +334,151 @@ func (sm *SUSPMetadata) Clone() *SUSPMetadata {
 	}
 
 	return &SUSPMetadata{
-		Offset:       sm.Offset,
-		HasRockRidge: sm.HasRockRidge,
+		Offset:             sm.Offset,
+		HasRockRidge:       sm.HasRockRidge,
+		RockRidgeDetection: sm.RockRidgeDetection,
+		RockRidgeRevision:  sm.RockRidgeRevision,
+	}
+}
+
+// newSystemUseEntry builds a System Use entry with the header defined in SUSP-112 4.1.
+// The caller is responsible for keeping the data within 251 bytes.
+func newSystemUseEntry(signature string, version byte, data []byte) SystemUseEntry {
+	e := make(SystemUseEntry, 4+len(data))
+	copy(e[0:2], signature)
+	e[2] = byte(len(e))
+	e[3] = version
+	copy(e[4:], data)
+	return e
+}
+
+// marshalSPEntry encodes the SP entry as defined in SUSP-112 5.3
+func marshalSPEntry(bytesSkipped uint8) SystemUseEntry {
+	return newSystemUseEntry(SUEType_SharingProtocolIndicator, 1, []byte{0xBE, 0xEF, bytesSkipped})
+}
+
+// marshalEREntry encodes the ER entry as defined in SUSP-112 5.5
+func marshalEREntry(er *ExtensionRecord) SystemUseEntry {
+	data := []byte{byte(len(er.Identifier)), byte(len(er.Descriptor)), byte(len(er.Source)), byte(er.Version)}
+	data = append(data, er.Identifier...)
+	data = append(data, er.Descriptor...)
+	data = append(data, er.Source...)
+	return newSystemUseEntry(SUEType_ExtensionsReference, 1, data)
+}
+
+// systemUseEntriesLength returns the total encoded length of the given entries
+func systemUseEntriesLength(entries []SystemUseEntry) int {
+	var total int
+	for _, e := range entries {
+		total += len(e)
+	}
+	return total
+}
+
+// joinSystemUseEntries concatenates the given entries into a System Use field
+func joinSystemUseEntries(entries []SystemUseEntry) []byte {
+	data := make([]byte, 0, systemUseEntriesLength(entries))
+	for _, e := range entries {
+		data = append(data, e...)
+	}
+	return data
+}
+
+// continuationEntryLength is the length of an encoded CE entry
+const continuationEntryLength = 28
+
+// marshalContinuationEntry encodes a CE entry as defined in SUSP-112 5.1
+func marshalContinuationEntry(ce *ContinuationEntry) SystemUseEntry {
+	data := make([]byte, 24)
+	WriteInt32LSBMSB(data[0:8], int32(ce.blockLocation))
+	WriteInt32LSBMSB(data[8:16], int32(ce.offset))
+	WriteInt32LSBMSB(data[16:24], int32(ce.lengthOfArea))
+	return newSystemUseEntry(SUEType_ContinuationArea, 1, data)
+}
+
+// continuationArea collects the System Use entries which don't fit into the records of a directory.
+// It occupies whole sectors starting at location. Each area referenced by a CE entry
+// is kept within a single sector, as readers like Linux don't accept areas crossing sector boundaries.
+type continuationArea struct {
+	location uint32
+	// blockSize is the logical block size the CE entries locate the areas in, 0 for the size of a sector
+	blockSize uint32
+	data      []byte
+}
+
+// fitSystemUse returns the entries to record in a System Use field with the given space.
+// As many entries as possible are kept in the field, the rest is moved into the continuation area
+// and referenced by a CE entry at the end of the field.
+func (ca *continuationArea) fitSystemUse(entries []SystemUseEntry, space int) []SystemUseEntry {
+	if systemUseEntriesLength(entries) <= space {
+		return entries
+	}
+
+	used := 0
+	inline := 0
+	for inline < len(entries) && used+len(entries[inline])+continuationEntryLength <= space {
+		used += len(entries[inline])
+		inline++
+	}
+
+	fitted := append([]SystemUseEntry{}, entries[:inline]...)
+	return append(fitted, ca.add(entries[inline:]))
+}
+
+// add appends the entries to the continuation area and returns the CE entry referencing them.
+// If they don't fit into the rest of the sector, a CE entry at the end chains them to an area in the next sector.
+func (ca *continuationArea) add(entries []SystemUseEntry) SystemUseEntry {
+	if ca.roomInSector() < ca.needed(entries) {
+		ca.data = append(ca.data, make([]byte, ca.roomInSector())...)
+	}
+
+	start := len(ca.data)
+	for i, e := range entries {
+		if ca.roomInSector() < ca.needed(entries[i:]) {
+			ceOffset := len(ca.data)
+			ca.data = append(ca.data, make([]byte, continuationEntryLength)...)
+			length := len(ca.data) - start
+			// the recursion may reallocate the data, so it must run before the destination is taken
+			next := ca.add(entries[i:])
+			copy(ca.data[ceOffset:], next)
+			return ca.entry(start, length)
+		}
+		ca.data = append(ca.data, e...)
+	}
+
+	return ca.entry(start, len(ca.data)-start)
+}
+
+// needed returns the room needed for the first of the entries, including a CE entry if more follow
+func (ca *continuationArea) needed(entries []SystemUseEntry) int {
+	if len(entries) > 1 {
+		return len(entries[0]) + continuationEntryLength
+	}
+	return len(entries[0])
+}
+
+func (ca *continuationArea) roomInSector() int {
+	return int(sectorSize) - len(ca.data)%int(sectorSize)
+}
+
+func (ca *continuationArea) entry(start, length int) SystemUseEntry {
+	blockSize := ca.blockSize
+	if blockSize == 0 {
+		blockSize = sectorSize
 	}
+	return marshalContinuationEntry(&ContinuationEntry{
+		blockLocation: ca.location*(sectorSize/blockSize) + uint32(start)/blockSize,
+		offset:        uint32(start) % blockSize,
+		lengthOfArea:  uint32(length),
+	})
+}
+
+// sectors returns the number of sectors occupied by the continuation area
+func (ca *continuationArea) sectors() uint32 {
+	return (uint32(len(ca.data)) + sectorSize - 1) / sectorSize
+}
+
+// bytes returns the content of the continuation area, padded to whole sectors
+func (ca *continuationArea) bytes() []byte {
+	return append(ca.data, make([]byte, int(ca.sectors()*sectorSize)-len(ca.data))...)
 }
@@ -1,6 +1,7 @@
 package iso9660
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"testing"
@@ -53,7 +54,7 @@ func TestSUCEGarbledData(t *testing.T) {
 	// ContinuationEntry too short
 	suArea := []byte{'C', 'E', 7, 1, 0, 0, 0}
 	_, err := splitSystemUseEntries(suArea, ra)
-	assert.EqualError(t, err, "unmarshaling ContinuationEntry: invalid ContinuationArea record with length 7 instead of 28")
+	assert.EqualError(t, err, "unmarshaling ContinuationEntry: truncated record: unexpected EOF: the CE entry of 7 bytes is shorter than 28 bytes")
 
 	// ContinuationEntry has garbled block location
 	suArea = []byte{'C', 'E', 28, 1, 100, 0, 0, 0, 0, 0, 0, 99, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
@@ -79,7 +80,7 @@ func TestSUCEGarbledData(t *testing.T) {
 	suArea = []byte{'C', 'E', 28, 1, 100, 0, 0, 0, 0, 0, 0, 100, 12, 0, 0, 0, 0, 0, 0, 12, 64, 0, 0, 0, 0, 0, 0, 64}
 	fr := &fakeReaderAt{}
 	_, err = splitSystemUseEntries(suArea, fr)
-	assert.EqualError(t, err, "splitting Continuation Area: splitting System Use entries: unexpected EOF, expected 120 bytes but have only 64")
+	assert.EqualError(t, err, "splitting Continuation Area: splitting System Use entries: truncated record: unexpected EOF, expected 120 bytes but have only 64")
 }
 
 func TestDecodeInvalidSUSPER(t *testing.T) {
@@ -125,3 +126,90 @@ func TestDecodeInvalidSUSPSP(t *testing.T) {
 		assert.Error(t, err)
 	}
 }
+
+func TestContinuationAreaChaining(t *testing.T) {
+	ca := &continuationArea{location: 100}
+
+	var entries []SystemUseEntry
+	for i := 0; i < 12; i++ {
+		entries = append(entries, newSystemUseEntry("XX", 1, bytes.Repeat([]byte{byte(i)}, 246)))
+	}
+	fitted := ca.fitSystemUse(entries, 200)
+	assert.Len(t, fitted, 1)
+	assert.Equal(t, SUEType_ContinuationArea, fitted[0].Type())
+	assert.Equal(t, uint32(2), ca.sectors())
+
+	// every area stays within a sector
+	data := ca.bytes()
+	ra := bytes.NewReader(append(make([]byte, 100*sectorSize), data...))
+	for entry := fitted[0]; ; {
+		ce, err := umarshalContinuationEntry(entry)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, ce.offset+ce.lengthOfArea, sectorSize)
+		area := data[(ce.blockLocation-100)*sectorSize+ce.offset:][:ce.lengthOfArea]
+		entry = area[len(area)-continuationEntryLength:]
+		if SystemUseEntry(entry).Type() != SUEType_ContinuationArea {
+			break
+		}
+	}
+
+	joined, err := splitSystemUseEntries(joinSystemUseEntries(fitted), ra)
+	assert.NoError(t, err)
+	assert.Equal(t, SystemUseEntrySlice(entries), SystemUseEntrySlice(joined))
+}
+
+func TestParseSystemUseAnomalies(t *testing.T) {
+	ra := &noopReaderAt{}
+	nm := SystemUseEntry{'N', 'M', 7, 1, 0, 'a', 'b'}
+	px := SystemUseEntry{'P', 'X', 4, 1}
+
+	for _, c := range []struct {
+		data     []byte
+		entries  []SystemUseEntry
+		messages []string
+		severity Severity
+	}{
+		// the entry with the wrong version is skipped, the following ones are kept
+		{joinSystemUseEntries([]SystemUseEntry{{'N', 'M', 5, 2, 0}, px}), []SystemUseEntry{px},
+			[]string{"skipping the NM entry with version 2 instead of 1"}, SeverityWarning},
+		// unknown signatures are kept, if they look like one
+		{joinSystemUseEntries([]SystemUseEntry{{'A', 'A', 4, 2}, {0xFF, 'x', 4, 1}, nm}), []SystemUseEntry{{'A', 'A', 4, 2}, nm},
+			[]string{"skipping an entry with the invalid signature \"\\xffx\""}, SeverityWarning},
+		// everything after ST is ignored
+		{joinSystemUseEntries([]SystemUseEntry{nm, {'S', 'T', 4, 1}, px}), []SystemUseEntry{nm},
+			[]string{"ignoring the 4 bytes after the ST entry"}, SeverityWarning},
+		{joinSystemUseEntries([]SystemUseEntry{nm, {'S', 'T', 4, 1}, {0, 0, 0, 0}}), []SystemUseEntry{nm}, nil, SeverityWarning},
+		// the entries before a broken one are kept
+		{append(joinSystemUseEntries([]SystemUseEntry{px, nm}), 'T', 'F', 2, 1), []SystemUseEntry{px, nm},
+			[]string{"splitting System Use entries: invalid record length 2"}, SeverityError},
+	} {
+		entries, anomalies := parseSystemUse(c.data, ra, int64(sectorSize))
+		assert.Equal(t, c.entries, entries)
+		var messages []string
+		for _, a := range anomalies {
+			messages = append(messages, a.err.Error())
+			assert.Equal(t, c.severity, a.severity)
+		}
+		assert.Equal(t, c.messages, messages)
+
+		// only the errors fail the split
+		_, err := splitSystemUseEntries(c.data, ra)
+		assert.Equal(t, c.severity == SeverityError, err != nil)
+	}
+}
+
+func TestContinuationAreaLoops(t *testing.T) {
+	nm := SystemUseEntry{'N', 'M', 7, 1, 0, 'a', 'b'}
+	// the area at sector 1 continues into itself
+	loop := marshalContinuationEntry(&ContinuationEntry{blockLocation: 1, lengthOfArea: uint32(len(nm)) + continuationEntryLength})
+	image := make([]byte, 2*sectorSize)
+	copy(image[sectorSize:], joinSystemUseEntries([]SystemUseEntry{nm, loop}))
+
+	entries, err := splitSystemUseEntries(loop, bytes.NewReader(image))
+	assert.EqualError(t, err, "splitting Continuation Area: the Continuation Area at offset 0 of sector 1 is chained in a loop")
+	assert.Equal(t, []SystemUseEntry{nm}, entries)
+
+	crossing := marshalContinuationEntry(&ContinuationEntry{blockLocation: 1, offset: 2000, lengthOfArea: 100})
+	_, err = splitSystemUseEntries(crossing, bytes.NewReader(image))
+	assert.EqualError(t, err, "the Continuation Area of 100 bytes at offset 2000 crosses the end of sector 1")
+}
@@ -0,0 +1,251 @@
+package iso9660
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// TarOption configures AddTar
+type TarOption func(*tarOptions)
+
+type tarOptions struct {
+	strict bool
+	warn   func(name string, err error)
+}
+
+// WithTarWarnings sets a function which is called for every entry of the archive that AddTar skips,
+// with the reason why it was skipped
+func WithTarWarnings(fn func(name string, err error)) TarOption {
+	return func(o *tarOptions) {
+		o.warn = fn
+	}
+}
+
+// WithStrictTar makes AddTar fail on entries it cannot stage instead of skipping them
+func WithStrictTar() TarOption {
+	return func(o *tarOptions) {
+		o.strict = true
+	}
+}
+
+// hardLinkSource is the source of a file hard linked to an earlier staged file.
+// WriteTo places both files in the same extent.
+type hardLinkSource struct {
+	stagedSource
+}
+
+// sourceIdentity returns the source that hard linked files share
+func sourceIdentity(source stagedSource) stagedSource {
+	if link, ok := source.(*hardLinkSource); ok {
+		return link.stagedSource
+	}
+	return source
+}
+
+// AddTar stages the contents of a tar archive under isoRoot, as read from r.
+// Regular files, directories, symlinks, hard links, FIFOs and device nodes are staged like AddFS does,
+// with the mode, ownership and modification time from the headers, including PAX extended headers.
+// Hard links share the data of the file they link to. Rock Ridge must be enabled to keep
+// anything but the names and data of regular files and directories.
+//
+// Entries of other types, and hard links to files which haven't been staged, are skipped
+// and reported to the function set with WithTarWarnings, unless WithStrictTar is given.
+func (iw *ImageWriter) AddTar(r io.Reader, isoRoot string, opts ...TarOption) error {
+	var options tarOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading tar archive: %w", err)
+		}
+
+		err = iw.addTarEntry(tr, hdr, isoRoot)
+		var skipped *skippedTarEntryError
+		if errors.As(err, &skipped) && !options.strict {
+			if options.warn != nil {
+				options.warn(hdr.Name, skipped.err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("adding %s: %w", hdr.Name, err)
+		}
+	}
+}
+
+// skippedTarEntryError is returned for entries which AddTar skips unless it's strict
+type skippedTarEntryError struct {
+	err error
+}
+
+func (e *skippedTarEntryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *skippedTarEntryError) Unwrap() error {
+	return e.err
+}
+
+// tarPath returns the path in the image of a name from the archive.
+// Leading ".." elements are dropped like GNU tar does, so that names can't point outside of isoRoot.
+func tarPath(isoRoot, name string) string {
+	return path.Join(isoRoot, path.Clean("/"+name))
+}
+
+func (iw *ImageWriter) addTarEntry(tr *tar.Reader, hdr *tar.Header, isoRoot string) error {
+	target := tarPath(isoRoot, hdr.Name)
+	origin := fmt.Sprintf("%q in the tar archive", hdr.Name)
+	mode := hdr.FileInfo().Mode()
+
+	var entry *stagedEntry
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if path.Join("/", posixifyPath(target)) == "/" {
+			// the root of the image keeps its own attributes
+			return nil
+		}
+		return iw.addDirectory(target, origin, []EntryOption{
+			WithMode(mode),
+			WithModTime(hdr.ModTime),
+			WithOwner(uint32(hdr.Uid), uint32(hdr.Gid)),
+		})
+	case tar.TypeReg, tar.TypeRegA: // nolint: staticcheck
+		source, err := iw.copyToStaging(tr)
+		if err != nil {
+			return err
+		}
+		entry = newStagedFile("", source, hdr.ModTime)
+	case tar.TypeLink:
+		linked := tarPath(isoRoot, hdr.Linkname)
+		iw.mu.Lock()
+		existing := iw.lookup(linked)
+		iw.mu.Unlock()
+		if existing == nil || existing.source == nil {
+			return &skippedTarEntryError{fmt.Errorf("hard link to %s, which isn't a staged file", hdr.Linkname)}
+		}
+		entry = newStagedFile("", &hardLinkSource{sourceIdentity(existing.source)}, existing.modTime)
+		entry.mode = existing.mode
+		entry.uid = existing.uid
+		entry.gid = existing.gid
+		entry.origin = origin
+		return iw.stage(target, entry)
+	case tar.TypeSymlink:
+		entry = newStagedSymlink("", hdr.Linkname, hdr.ModTime)
+	case tar.TypeFifo, tar.TypeChar, tar.TypeBlock:
+		entry = newStagedSpecialFile("", mode, hdr.ModTime)
+		major, minor := uint32(hdr.Devmajor), uint32(hdr.Devminor)
+		if err := checkDeviceNumber(major, minor); err != nil {
+			return &skippedTarEntryError{err}
+		}
+		d := entry.edit()
+		d.devMajor, d.devMinor = major, minor
+	case tar.TypeXGlobalHeader:
+		// the reader applies the global PAX records it understands to the headers that follow
+		return nil
+	default:
+		return &skippedTarEntryError{fmt.Errorf("unsupported tar entry type %q", hdr.Typeflag)}
+	}
+
+	entry.mode = mode
+	entry.uid = uint32(hdr.Uid)
+	entry.gid = uint32(hdr.Gid)
+	entry.origin = origin
+	if err := iw.stage(target, entry); err != nil {
+		if entry.source != nil {
+			iw.discard(entry.source)
+		}
+		return err
+	}
+	return nil
+}
+
+// TarFrom writes the selected hierarchy of the image to w as a tar archive, with the names, modes, ownership,
+// timestamps, symlink targets and device numbers of Rock Ridge where the image has them, see Image.Walk.
+// Regular files whose PX entry records the same serial number and more than one link are archived as hard links
+// to the first of them. The access and attribute change times are kept in PAX extended headers.
+// The root directory isn't archived and the names are relative to it. w isn't closed.
+func TarFrom(img *Image, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	linked := make(map[uint32]string)
+
+	err := img.Walk(func(name string, f *File, attrs RockRidgeAttrs) error {
+		if name == "/" {
+			return nil
+		}
+		hdr := &tar.Header{
+			Name:    strings.TrimPrefix(name, "/"),
+			Mode:    int64(fileModeToPosixMode(attrs.Mode) & 07777),
+			Uid:     int(attrs.UID),
+			Gid:     int(attrs.GID),
+			ModTime: attrs.Times.Modification,
+		}
+		if !attrs.Times.Access.IsZero() || !attrs.Times.Change.IsZero() {
+			hdr.Format = tar.FormatPAX
+			hdr.AccessTime = attrs.Times.Access
+			hdr.ChangeTime = attrs.Times.Change
+		}
+
+		switch attrs.Mode.Type() {
+		case fs.ModeDir:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case fs.ModeSymlink:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = attrs.SymlinkTarget
+		case fs.ModeNamedPipe:
+			hdr.Typeflag = tar.TypeFifo
+		case fs.ModeDevice:
+			hdr.Typeflag = tar.TypeBlock
+		case fs.ModeDevice | fs.ModeCharDevice:
+			hdr.Typeflag = tar.TypeChar
+		case 0:
+			if attrs.Links > 1 && attrs.Serial != 0 {
+				if first, ok := linked[attrs.Serial]; ok {
+					hdr.Typeflag = tar.TypeLink
+					hdr.Linkname = first
+					break
+				}
+				linked[attrs.Serial] = hdr.Name
+			}
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = f.Size()
+		default:
+			return fmt.Errorf("%s: cannot archive a file of mode %s", name, attrs.Mode)
+		}
+		if hdr.Typeflag == tar.TypeBlock || hdr.Typeflag == tar.TypeChar {
+			hdr.Devmajor = int64(attrs.DeviceMajor)
+			hdr.Devminor = int64(attrs.DeviceMinor)
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		r, err := f.OpenReader()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if _, err := io.Copy(tw, r); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
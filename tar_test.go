@@ -0,0 +1,210 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterAddTar(t *testing.T) {
+	modTime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	longName := "usr/share/" + strings.Repeat("long-directory-name/", 6) + "file.txt"
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, entry := range []struct {
+		hdr  tar.Header
+		data string
+	}{
+		{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755}},
+		{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "./etc/", Mode: 0700, Uid: 10, Gid: 20, ModTime: modTime}},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "./etc/passwd", Mode: 0640, Uid: 1000, Gid: 1001, ModTime: modTime}, data: "root:x:0:0"},
+		{hdr: tar.Header{Typeflag: tar.TypeLink, Name: "./etc/passwd.link", Linkname: "./etc/passwd"}},
+		{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "./etc/localtime", Linkname: "/usr/share/zoneinfo/UTC", Mode: 0777}},
+		{hdr: tar.Header{Typeflag: tar.TypeFifo, Name: "./run/fifo", Mode: 0600}},
+		{hdr: tar.Header{Typeflag: tar.TypeChar, Name: "./dev/null", Mode: 0666, Devmajor: 1, Devminor: 3}},
+		// the name is too long for the ustar header and becomes a PAX record
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: longName, Mode: 0644, Format: tar.FormatPAX}, data: "pax"},
+		// and a GNU long name entry here
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: longName + ".gnu", Mode: 0644, Format: tar.FormatGNU}, data: "gnu"},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "../../escaped", Mode: 0644}, data: "inside"},
+		{hdr: tar.Header{Typeflag: tar.TypeLink, Name: "dangling", Linkname: "missing"}},
+	} {
+		hdr := entry.hdr
+		hdr.Size = int64(len(entry.data))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(entry.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	var warnings []string
+	require.NoError(t, w.AddTar(bytes.NewReader(archive.Bytes()), "", WithTarWarnings(func(name string, err error) {
+		warnings = append(warnings, name+": "+err.Error())
+	})))
+	assert.Equal(t, []string{"dangling: hard link to missing, which isn't a staged file"}, warnings)
+
+	files := filesByPath(t, remaster(t, w))
+
+	etc := files["/etc"]
+	require.NotNil(t, etc)
+	assert.Equal(t, fs.ModeDir|0700, etc.Mode())
+	assert.True(t, modTime.Equal(etc.ModTime()))
+	posix, err := etc.de.SystemUseEntries.getPosixEntry()
+	require.NoError(t, err)
+	assert.Equal(t, [2]uint32{10, 20}, [2]uint32{posix.uid, posix.gid})
+
+	passwd, link := files["/etc/passwd"], files["/etc/passwd.link"]
+	require.NotNil(t, passwd)
+	require.NotNil(t, link)
+	assert.Equal(t, fs.FileMode(0640), link.Mode())
+	assert.Equal(t, passwd.de.ExtentLocation, link.de.ExtentLocation)
+	for _, f := range []*File{passwd, link} {
+		data, err := io.ReadAll(f.Reader())
+		require.NoError(t, err)
+		assert.Equal(t, "root:x:0:0", string(data))
+		posix, err := f.de.SystemUseEntries.getPosixEntry()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(2), posix.nlink)
+		assert.Equal(t, [2]uint32{1000, 1001}, [2]uint32{posix.uid, posix.gid})
+	}
+
+	require.NotNil(t, files["/etc/localtime"])
+	assert.Equal(t, "/usr/share/zoneinfo/UTC", files["/etc/localtime"].de.SystemUseEntries.GetSymlinkTarget())
+
+	require.NotNil(t, files["/run/fifo"])
+	assert.Equal(t, fs.ModeNamedPipe|0600, files["/run/fifo"].Mode())
+
+	require.NotNil(t, files["/dev/null"])
+	major, minor, ok := files["/dev/null"].DeviceNumber()
+	assert.True(t, ok)
+	assert.Equal(t, [2]uint32{1, 3}, [2]uint32{major, minor})
+
+	require.NotNil(t, files["/"+longName])
+	data, err := io.ReadAll(files["/"+longName].Reader())
+	require.NoError(t, err)
+	assert.Equal(t, "pax", string(data))
+
+	require.NotNil(t, files["/"+longName+".gnu"])
+	data, err = io.ReadAll(files["/"+longName+".gnu"].Reader())
+	require.NoError(t, err)
+	assert.Equal(t, "gnu", string(data))
+
+	require.NotNil(t, files["/escaped"])
+}
+
+func TestWriterAddTarStrict(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "link", Linkname: "missing"}))
+	require.NoError(t, tw.Close())
+
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	err = w.AddTar(bytes.NewReader(archive.Bytes()), "sub", WithStrictTar())
+	assert.EqualError(t, err, "adding link: hard link to missing, which isn't a staged file")
+
+	// without the option the entry is skipped
+	require.NoError(t, w.AddTar(bytes.NewReader(archive.Bytes()), "sub"))
+
+	assert.Error(t, w.AddTar(strings.NewReader("not a tar archive"), ""))
+}
+
+func TestTarFrom(t *testing.T) {
+	modTime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, entry := range []struct {
+		hdr  tar.Header
+		data string
+	}{
+		{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0700, Uid: 10, Gid: 20, ModTime: modTime}},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd", Mode: 0640, Uid: 1000, Gid: 1001, ModTime: modTime}, data: "root:x:0:0"},
+		{hdr: tar.Header{Typeflag: tar.TypeLink, Name: "etc/passwd.link", Linkname: "etc/passwd"}},
+		{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/localtime", Linkname: "/usr/share/zoneinfo/UTC", Mode: 0777, ModTime: modTime}},
+		{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "dev/", Mode: 0755, ModTime: modTime}},
+		{hdr: tar.Header{Typeflag: tar.TypeChar, Name: "dev/null", Mode: 0666, Devmajor: 1, Devminor: 3, ModTime: modTime}},
+		{hdr: tar.Header{Typeflag: tar.TypeBlock, Name: "dev/sda1", Mode: 0660, Devmajor: 8, Devminor: 1, ModTime: modTime}},
+		{hdr: tar.Header{Typeflag: tar.TypeFifo, Name: "dev/fifo", Mode: 0600, ModTime: modTime}},
+	} {
+		hdr := entry.hdr
+		hdr.Size = int64(len(entry.data))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(entry.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, RockRidgeIdentifier: RockRidgeIdentifierP1282})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddTar(bytes.NewReader(archive.Bytes()), ""))
+	img := remaster(t, w)
+
+	var exported bytes.Buffer
+	require.NoError(t, TarFrom(img, &exported))
+
+	headers := make(map[string]*tar.Header)
+	contents := make(map[string]string)
+	tr := tar.NewReader(&exported)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		headers[hdr.Name] = hdr
+		contents[hdr.Name] = string(data)
+	}
+	assert.Len(t, headers, 8)
+
+	etc := headers["etc/"]
+	require.NotNil(t, etc)
+	assert.Equal(t, byte(tar.TypeDir), etc.Typeflag)
+	assert.Equal(t, [3]int64{0700, 10, 20}, [3]int64{etc.Mode, int64(etc.Uid), int64(etc.Gid)})
+	assert.True(t, modTime.Equal(etc.ModTime))
+
+	passwd := headers["etc/passwd"]
+	require.NotNil(t, passwd)
+	assert.Equal(t, byte(tar.TypeReg), passwd.Typeflag)
+	assert.Equal(t, [3]int64{0640, 1000, 1001}, [3]int64{passwd.Mode, int64(passwd.Uid), int64(passwd.Gid)})
+	assert.Equal(t, "root:x:0:0", contents["etc/passwd"])
+
+	link := headers["etc/passwd.link"]
+	require.NotNil(t, link)
+	assert.Equal(t, byte(tar.TypeLink), link.Typeflag)
+	assert.Equal(t, "etc/passwd", link.Linkname)
+
+	require.NotNil(t, headers["etc/localtime"])
+	assert.Equal(t, byte(tar.TypeSymlink), headers["etc/localtime"].Typeflag)
+	assert.Equal(t, "/usr/share/zoneinfo/UTC", headers["etc/localtime"].Linkname)
+
+	for name, expected := range map[string][3]int64{
+		"dev/null": {tar.TypeChar, 1, 3},
+		"dev/sda1": {tar.TypeBlock, 8, 1},
+		"dev/fifo": {tar.TypeFifo, 0, 0},
+	} {
+		hdr := headers[name]
+		require.NotNil(t, hdr, name)
+		assert.Equal(t, expected, [3]int64{int64(hdr.Typeflag), hdr.Devmajor, hdr.Devminor}, name)
+	}
+}
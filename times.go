@@ -0,0 +1,122 @@
+package iso9660
+
+import (
+	"time"
+)
+
+// RecordTimes overrides the times recorded for an entry. Zero fields are left to the writer's policy.
+type RecordTimes struct {
+	// Recording is the recording date of the ECMA-119 directory record, defaulting to the modification time
+	Recording time.Time
+	// Modification, Access, Change and Creation are recorded in the Rock Ridge TF entry,
+	// Change being the time the attributes were last changed, like the ctime of POSIX
+	Modification time.Time
+	Access       time.Time
+	Change       time.Time
+	Creation     time.Time
+}
+
+// DirectoryTimePolicy selects the modification time recorded for directories without a RecordTimes override
+type DirectoryTimePolicy int
+
+const (
+	// DirectoryTimesStaged records the time a directory was staged with, like any other entry
+	DirectoryTimesStaged DirectoryTimePolicy = iota
+	// DirectoryTimesNewestChild records the newest modification time of the directory's children,
+	// which includes the times chosen for subdirectories. Empty directories keep their staged time.
+	DirectoryTimesNewestChild
+	// DirectoryTimesFixed records the time given to SetDirectoryTimePolicy
+	DirectoryTimesFixed
+	// DirectoryTimesNow records the time the image is written, or the fixed timestamp of reproducible output
+	DirectoryTimesNow
+)
+
+// WithRecordTimes overrides the times recorded for a staged entry, see SetTimes
+func WithRecordTimes(rec RecordTimes) EntryOption {
+	return func(e *stagedEntry) {
+		times := rec
+		e.edit().times = &times
+	}
+}
+
+// SetTimes overrides the times recorded for an already staged entry.
+// The non-zero fields of rec take precedence over the staged modification time,
+// the directory time policy and the fixed timestamp of reproducible output.
+func (iw *ImageWriter) SetTimes(isoPath string, rec RecordTimes) error {
+	return iw.applyOptions(isoPath, []EntryOption{WithRecordTimes(rec)})
+}
+
+// SetDirectoryTimePolicy selects the modification time recorded for directories.
+// The fixed time is only used by DirectoryTimesFixed; if it is zero, the time the image is written is used.
+// The default is DirectoryTimesStaged.
+func (iw *ImageWriter) SetDirectoryTimePolicy(policy DirectoryTimePolicy, fixed time.Time) {
+	iw.dirTimes = policy
+	iw.dirTime = fixed
+}
+
+// modificationTime returns the modification time recorded for the entry.
+// Explicit overrides win, then the directory policy; with a fixed timestamp every other entry gets it,
+// otherwise entries keep the time they were staged with.
+func (wc *writeContext) modificationTime(e *stagedEntry) time.Time {
+	if t := e.info().times; t != nil && !t.Modification.IsZero() {
+		return t.Modification
+	}
+
+	if e.isDir() {
+		switch wc.dirTimes {
+		case DirectoryTimesNewestChild:
+			if t, ok := wc.newestChildTime(e); ok {
+				return t
+			}
+		case DirectoryTimesFixed:
+			if !wc.dirTime.IsZero() {
+				return wc.dirTime
+			}
+			return wc.timestamp
+		case DirectoryTimesNow:
+			return wc.timestamp
+		}
+	}
+
+	if wc.fixedTime || e.modTime.IsZero() {
+		return wc.timestamp
+	}
+	return e.modTime
+}
+
+// newestChildTime returns the newest modification time of the directory's children, if it has any.
+// The result is cached, since the time of every directory is needed for its own record, "." and "..".
+func (wc *writeContext) newestChildTime(dir *stagedEntry) (time.Time, bool) {
+	if t, ok := wc.newestChildTimes[dir]; ok {
+		return t, true
+	}
+	if len(dir.children) == 0 {
+		return time.Time{}, false
+	}
+
+	var newest time.Time
+	for _, c := range dir.children {
+		if t := wc.modificationTime(c); t.After(newest) {
+			newest = t
+		}
+	}
+
+	if wc.newestChildTimes == nil {
+		wc.newestChildTimes = make(map[*stagedEntry]time.Time)
+	}
+	wc.newestChildTimes[dir] = newest
+	return newest, true
+}
+
+// recordTimes returns all times recorded for the entry, with the recording date and modification time resolved
+func (wc *writeContext) recordTimes(e *stagedEntry) RecordTimes {
+	var rec RecordTimes
+	if t := e.info().times; t != nil {
+		rec = *t
+	}
+	rec.Modification = wc.modificationTime(e)
+	if rec.Recording.IsZero() {
+		rec.Recording = rec.Modification
+	}
+	return rec
+}
@@ -0,0 +1,192 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rockRidgeTimes decodes the TF entry of a file, keyed by its flags
+func rockRidgeTimes(t *testing.T, f *File) map[byte]time.Time {
+	for _, e := range f.de.SystemUseEntries {
+		if e.Type() != "TF" {
+			continue
+		}
+		data := e.Data()
+		require.NotEmpty(t, data)
+
+		result := make(map[byte]time.Time)
+		offset := 1
+		for _, flag := range []byte{tfFlagCreation, tfFlagModify, tfFlagAccess} {
+			if data[0]&flag == 0 {
+				continue
+			}
+			var ts RecordingTimestamp
+			require.NoError(t, ts.UnmarshalBinary(data[offset:]))
+			result[flag] = time.Time(ts)
+			offset += 7
+		}
+		assert.Equal(t, len(data), offset)
+		return result
+	}
+	t.Fatalf("%s has no TF entry", f.Name())
+	return nil
+}
+
+func TestWriterSetTimes(t *testing.T) {
+	rec := RecordTimes{
+		Recording:    time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		Modification: time.Date(2021, 2, 2, 0, 0, 0, 0, time.UTC),
+		Access:       time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC),
+		Creation:     time.Date(2021, 4, 4, 0, 0, 0, 0, time.UTC),
+	}
+	staged := time.Date(2020, 5, 5, 0, 0, 0, 0, time.UTC)
+
+	iw, err := NewWriter()
+	require.NoError(t, err)
+	defer iw.Cleanup()
+	iw.SetRockRidge(true)
+
+	require.NoError(t, iw.AddFile(strings.NewReader("all"), "all.txt"))
+	require.NoError(t, iw.SetTimes("all.txt", rec))
+	require.NoError(t, iw.AddFile(strings.NewReader("modified"), "modified.txt"))
+	require.NoError(t, iw.SetTimes("modified.txt", RecordTimes{Modification: rec.Modification}))
+	require.NoError(t, iw.AddDirectory("plain", WithModTime(staged)))
+
+	err = iw.SetTimes("missing.txt", rec)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	files := filesByPath(t, remaster(t, iw))
+
+	all := files["/all.txt"]
+	assert.True(t, rec.Recording.Equal(all.ModTime()))
+	times := rockRidgeTimes(t, all)
+	assert.True(t, rec.Modification.Equal(times[tfFlagModify]))
+	assert.True(t, rec.Access.Equal(times[tfFlagAccess]))
+	assert.True(t, rec.Creation.Equal(times[tfFlagCreation]))
+	decoded, err := all.de.SystemUseEntries.GetTimestamps()
+	require.NoError(t, err)
+	assert.True(t, rec.Access.Equal(decoded.Access))
+	assert.True(t, rec.Creation.Equal(decoded.Creation))
+
+	// the recording date follows the modification time unless it is set
+	modified := files["/modified.txt"]
+	assert.True(t, rec.Modification.Equal(modified.ModTime()))
+	assert.Equal(t, []byte{tfFlagModify}, timeFlags(rockRidgeTimes(t, modified)))
+
+	plain := files["/plain"]
+	assert.True(t, staged.Equal(plain.ModTime()))
+	assert.True(t, staged.Equal(rockRidgeTimes(t, plain)[tfFlagModify]))
+}
+
+func TestGetTimestamps(t *testing.T) {
+	rec := RecordTimes{
+		Modification: time.Date(2021, 2, 2, 10, 20, 30, 0, time.UTC),
+		Access:       time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC),
+		Change:       time.Date(2021, 4, 4, 0, 0, 0, 0, time.UTC),
+	}
+	decoded, err := SystemUseEntrySlice{marshalRockRidgeTimestampEntry(rec)}.GetTimestamps()
+	require.NoError(t, err)
+	assert.True(t, rec.Modification.Equal(decoded.Modification))
+	assert.True(t, rec.Access.Equal(decoded.Access))
+	assert.True(t, rec.Change.Equal(decoded.Change))
+	assert.True(t, decoded.Creation.IsZero())
+
+	// the long form records hundredths of a second, and the expiration time is skipped
+	data := []byte{tfFlagModify | tfFlagExpiration | tfFlagLongForm}
+	for _, stamp := range []VolumeDescriptorTimestamp{
+		{Year: 2022, Month: 5, Day: 6, Hour: 7, Minute: 8, Second: 9, Hundredth: 50, Offset: 4},
+		{Year: 2030, Month: 1, Day: 1},
+	} {
+		b, err := stamp.MarshalBinary()
+		require.NoError(t, err)
+		data = append(data, b...)
+	}
+	decoded, err = SystemUseEntrySlice{newSystemUseEntry("TF", 1, data)}.GetTimestamps()
+	require.NoError(t, err)
+	assert.True(t, time.Date(2022, 5, 6, 6, 8, 9, 500000000, time.UTC).Equal(decoded.Modification))
+	assert.True(t, decoded.Access.IsZero())
+
+	_, err = SystemUseEntrySlice{newSystemUseEntry("TF", 1, []byte{tfFlagModify, 121, 1})}.GetTimestamps()
+	assert.Error(t, err)
+	_, err = SystemUseEntrySlice{}.GetTimestamps()
+	assert.Error(t, err)
+}
+
+func timeFlags(m map[byte]time.Time) []byte {
+	var result []byte
+	for k := range m {
+		result = append(result, k)
+	}
+	return result
+}
+
+func TestWriterDirectoryTimePolicy(t *testing.T) {
+	older := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	newest := time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)
+	fixed := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	reproducible := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	build := func(t *testing.T, opts WriterOptions) map[string]*File {
+		opts.EnableRockRidge = true
+		iw, err := NewWriterWithOptions(opts)
+		require.NoError(t, err)
+		defer iw.Cleanup()
+
+		require.NoError(t, iw.AddDirectory("dir/sub"))
+		require.NoError(t, iw.AddDirectory("dir/empty", WithModTime(older)))
+		require.NoError(t, iw.AddFile(strings.NewReader("a"), "dir/a.txt"))
+		require.NoError(t, iw.applyOptions("dir/a.txt", []EntryOption{WithModTime(older)}))
+		require.NoError(t, iw.AddFile(strings.NewReader("b"), "dir/sub/b.txt"))
+		require.NoError(t, iw.applyOptions("dir/sub/b.txt", []EntryOption{WithModTime(newer)}))
+		require.NoError(t, iw.AddFile(strings.NewReader("c"), "dir/sub/c.txt"))
+		require.NoError(t, iw.SetTimes("dir/sub/c.txt", RecordTimes{Modification: newest}))
+
+		return filesByPath(t, remaster(t, iw))
+	}
+
+	t.Run("newest child", func(t *testing.T) {
+		files := build(t, WriterOptions{DirectoryTimes: DirectoryTimesNewestChild})
+		assert.True(t, newest.Equal(files["/dir/sub"].ModTime()))
+		assert.True(t, newest.Equal(files["/dir"].ModTime()))
+		assert.True(t, newest.Equal(files["/"].ModTime()))
+		assert.True(t, older.Equal(files["/dir/empty"].ModTime()))
+		assert.True(t, newer.Equal(files["/dir/sub/b.txt"].ModTime()))
+	})
+
+	t.Run("fixed", func(t *testing.T) {
+		files := build(t, WriterOptions{DirectoryTimes: DirectoryTimesFixed, DirectoryTime: fixed})
+		for _, p := range []string{"/", "/dir", "/dir/sub", "/dir/empty"} {
+			assert.True(t, fixed.Equal(files[p].ModTime()), p)
+			assert.True(t, fixed.Equal(rockRidgeTimes(t, files[p])[tfFlagModify]), p)
+		}
+		assert.True(t, older.Equal(files["/dir/a.txt"].ModTime()))
+	})
+
+	t.Run("reproducible", func(t *testing.T) {
+		files := build(t, WriterOptions{FixedTimestamp: reproducible})
+		for _, p := range []string{"/", "/dir", "/dir/sub", "/dir/empty", "/dir/a.txt", "/dir/sub/b.txt"} {
+			assert.True(t, reproducible.Equal(files[p].ModTime()), p)
+		}
+		// explicit overrides survive reproducible output
+		assert.True(t, newest.Equal(files["/dir/sub/c.txt"].ModTime()))
+	})
+
+	t.Run("reproducible newest child", func(t *testing.T) {
+		files := build(t, WriterOptions{FixedTimestamp: reproducible, DirectoryTimes: DirectoryTimesNewestChild})
+		assert.True(t, newest.Equal(files["/dir"].ModTime()))
+		assert.True(t, reproducible.Equal(files["/dir/empty"].ModTime()))
+	})
+
+	_, err := NewWriterWithOptions(WriterOptions{DirectoryTimes: DirectoryTimesNow + 1})
+	assert.EqualError(t, err, "unknown directory time policy 4")
+}
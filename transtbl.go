@@ -0,0 +1,95 @@
+package iso9660
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+const (
+	transTableIdentifier = "TRANS.TBL;1"
+	transTableName       = "TRANS.TBL"
+)
+
+// SetTransTable enables or disables generating a TRANS.TBL file in every directory
+// whose entries were renamed by mangling. The tables map the primary identifiers
+// back to the original names for systems without Rock Ridge support. It is disabled by default.
+func (iw *ImageWriter) SetTransTable(enabled bool) {
+	iw.transTables = enabled
+}
+
+// isRenamed reports whether the node's identifier doesn't match its name,
+// disregarding the version and the separator of files without an extension
+func (n *layoutNode) isRenamed() bool {
+	identifier := n.identifier
+	if !n.entry.isDir() {
+		identifier = strings.TrimSuffix(strings.TrimSuffix(identifier, ";1"), ".")
+	}
+	return identifier != n.entry.name
+}
+
+// transTableIdentifier returns the identifier of the TRANS.TBL files, with a version unless it's omitted
+func (wc *writeContext) transTableIdentifier() string {
+	if wc.omitVersion {
+		return transTableName
+	}
+	return transTableIdentifier
+}
+
+// addTransTable adds a TRANS.TBL node to the directory if any of its children were renamed.
+// Its content is filled in by fillTransTables, once the children are sorted.
+func (wc *writeContext) addTransTable(dir *layoutNode) error {
+	renamed := false
+	for _, c := range dir.children {
+		if c.identifier == wc.transTableIdentifier() {
+			return fmt.Errorf("cannot generate %s in %s: %s has the same identifier", transTableName, dir.entry.path(), c.entry.path())
+		}
+		renamed = renamed || c.isRenamed()
+	}
+	if !renamed {
+		return nil
+	}
+
+	entry := newStagedFile(transTableName, nil, wc.timestamp)
+	entry.edit().identifier = wc.transTableIdentifier()
+	entry.origin = "TRANS.TBL generation"
+	entry.parent = dir.entry
+
+	table := &layoutNode{
+		entry:      entry,
+		parent:     dir,
+		identifier: wc.transTableIdentifier(),
+		depth:      dir.depth + 1,
+		generated:  true,
+	}
+	dir.children = append(dir.children, table)
+	wc.files = append(wc.files, table)
+	wc.transTables = append(wc.transTables, table)
+	return nil
+}
+
+// fillTransTables sets the contents of the TRANS.TBL files,
+// in the format of mkisofs: the type, the identifier padded to 34 characters and the name
+func (wc *writeContext) fillTransTables() {
+	for _, table := range wc.transTables {
+		var buf bytes.Buffer
+		for _, c := range table.parent.children {
+			if c.generated {
+				continue
+			}
+
+			switch {
+			case c.entry.isDir():
+				fmt.Fprintf(&buf, "D %-34s%s\n", c.identifier, c.entry.name)
+			case c.entry.mode&fs.ModeSymlink != 0:
+				fmt.Fprintf(&buf, "L %-34s%s\t%s\n", c.identifier, c.entry.name, c.entry.info().symlinkTarget)
+			default:
+				fmt.Fprintf(&buf, "F %-34s%s\n", c.identifier, c.entry.name)
+			}
+		}
+
+		table.source = &memorySource{data: buf.Bytes()}
+		table.entry.source = table.source
+	}
+}
@@ -0,0 +1,83 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterTransTable(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetTransTable(true)
+
+	require.NoError(t, w.AddFile(strings.NewReader("a"), "docs/README.md"))
+	require.NoError(t, w.AddFile(strings.NewReader("b"), "docs/my-config-file.yaml"))
+	require.NoError(t, w.AddDirectory("docs/Sub Dir"))
+	// nothing is renamed in here
+	require.NoError(t, w.AddFile(strings.NewReader("c"), "PLAIN/FILE.TXT"))
+	require.NoError(t, w.AddFile(strings.NewReader("d"), "PLAIN/NOEXT"))
+
+	img := remaster(t, w)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	dirs, err := root.GetChildren()
+	require.NoError(t, err)
+
+	contents := make(map[string]string)
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		children, err := dir.GetChildren()
+		require.NoError(t, err)
+		for _, c := range children {
+			if c.de.Identifier == transTableIdentifier {
+				data, err := io.ReadAll(c.Reader())
+				require.NoError(t, err)
+				contents[dir.Name()] = string(data)
+			}
+		}
+	}
+
+	assert.Equal(t, map[string]string{
+		"DOCS": "" +
+			"F MY_CONFIG_FILE.YAML;1             my-config-file.yaml\n" +
+			"F README.MD;1                       README.md\n" +
+			"D SUB_DIR                           Sub Dir\n",
+	}, contents)
+	// the root has a renamed directory as well
+	var rootTable bool
+	for _, c := range dirs {
+		rootTable = rootTable || c.de.Identifier == transTableIdentifier
+	}
+	assert.True(t, rootTable)
+
+	// the tables aren't part of the name map
+	names, err := w.NameMap()
+	require.NoError(t, err)
+	assert.NotContains(t, names, "/docs/TRANS.TBL")
+	assert.Len(t, names, 7)
+}
+
+func TestWriterTransTableCollision(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	w.SetTransTable(true)
+	w.SetRockRidge(true)
+
+	require.NoError(t, w.AddFile(strings.NewReader("a"), "trans.tbl"))
+	err = w.WriteTo(io.Discard, "")
+	assert.ErrorContains(t, err, "cannot generate TRANS.TBL in /: /trans.tbl has the same identifier")
+
+	w.SetTransTable(false)
+	assert.NoError(t, w.WriteTo(io.Discard, ""))
+}
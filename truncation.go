@@ -0,0 +1,88 @@
+package iso9660
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ExtentOutOfRangeError is returned by File.OpenReader, and by the reads of File.Reader,
+// for a file whose extent lies wholly or partly beyond the end of a truncated image
+type ExtentOutOfRangeError struct {
+	// Path is the name of the file as returned by File.Name. Extraction replaces it with the path within the image.
+	Path   string
+	LBA    uint32
+	Length uint32
+	// End is the position in bytes of the end of the extent
+	End int64
+	// Available is the number of bytes in the image
+	Available int64
+}
+
+func (e *ExtentOutOfRangeError) Error() string {
+	return fmt.Sprintf("%s: the extent at sector %d with %d bytes ends at byte %d, beyond the end of the image at byte %d",
+		e.Path, e.LBA, e.Length, e.End, e.Available)
+}
+
+// readerSize returns the number of bytes available from ra, if it can tell.
+// Block devices are sized by seeking to their end, the offset of the file is restored afterwards.
+func readerSize(ra io.ReaderAt) (int64, bool) {
+	switch r := ra.(type) {
+	case interface{ Size() int64 }:
+		return r.Size(), true
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil {
+			return 0, false
+		}
+		if info.Mode().IsRegular() {
+			return info.Size(), true
+		}
+		current, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if _, restoreErr := r.Seek(current, io.SeekStart); err != nil || restoreErr != nil || end <= 0 {
+			return 0, false
+		}
+		return end, true
+	}
+	return 0, false
+}
+
+// IsTruncated compares the volume space size of the Primary Volume Descriptor with the size of the image.
+// It returns the number of missing bytes and true if the image is shorter than its volume,
+// and false if it is complete or its size cannot be told, e.g. because it isn't read from a file.
+func (i *Image) IsTruncated() (missingBytes int64, ok bool) {
+	pvd, err := i.primaryVolume()
+	if err != nil || i.size == 0 {
+		return 0, false
+	}
+	expected := int64(pvd.VolumeSpaceSize) * i.LogicalBlockSize()
+	if i.size >= expected {
+		return 0, false
+	}
+	return expected - i.size, true
+}
+
+// checkExtent returns an *ExtentOutOfRangeError if the file's extent doesn't fit in the image
+func (f *File) checkExtent() error {
+	if f.imageSize == 0 {
+		return nil
+	}
+	for _, de := range f.records() {
+		end := de.dataOffset(f.logicalBlockSize()) + int64(de.ExtentLength)
+		if de.ExtentLength == 0 || end <= f.imageSize {
+			continue
+		}
+		return &ExtentOutOfRangeError{
+			Path:      f.Name(),
+			LBA:       uint32(de.ExtentLocation),
+			Length:    de.ExtentLength,
+			End:       end,
+			Available: f.imageSize,
+		}
+	}
+	return nil
+}
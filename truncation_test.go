@@ -0,0 +1,105 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// truncatedImage returns an image whose last file, LAST.BIN, lies partly beyond the end,
+// along with the sector of its extent
+func truncatedImage(t *testing.T) ([]byte, uint32) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("first"), "FIRST.TXT"))
+	require.NoError(t, w.AddFile(bytes.NewReader(make([]byte, 3*sectorSize)), "LAST.BIN"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "truncated"))
+
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	_, truncated := img.IsTruncated()
+	assert.False(t, truncated)
+	last := filesByPath(t, img)["/LAST.BIN"]
+	require.NotNil(t, last)
+
+	return buf.Bytes()[:buf.Len()-int(sectorSize)-100], uint32(last.de.ExtentLocation)
+}
+
+func TestTruncatedImage(t *testing.T) {
+	data, lba := truncatedImage(t)
+
+	img, err := OpenImage(bytes.NewReader(data))
+	require.NoError(t, err)
+	missing, truncated := img.IsTruncated()
+	assert.True(t, truncated)
+	assert.Equal(t, int64(sectorSize)+100, missing)
+
+	// the listing and the files which are complete still work
+	files := filesByPath(t, img)
+	first, err := io.ReadAll(files["/FIRST.TXT"].Reader())
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(first))
+
+	expected := &ExtentOutOfRangeError{Path: "LAST.BIN", LBA: lba, Length: 3 * sectorSize, End: int64(lba+3) * int64(sectorSize), Available: int64(len(data))}
+	r, err := files["/LAST.BIN"].OpenReader()
+	assert.Nil(t, r)
+	var outOfRange *ExtentOutOfRangeError
+	require.True(t, errors.As(err, &outOfRange))
+	assert.Equal(t, expected, outOfRange)
+	assert.Contains(t, err.Error(), "LAST.BIN: the extent at sector")
+
+	// the reads fail before anything is copied
+	n, err := io.Copy(io.Discard, files["/LAST.BIN"].Reader())
+	assert.Zero(t, n)
+	assert.True(t, errors.As(err, &outOfRange))
+
+	report, err := img.Verify()
+	require.NoError(t, err)
+	assert.Contains(t, report.Findings, VerifyFinding{Severity: SeverityError, Message: "the image is truncated, 2148 bytes of the volume are missing"})
+
+	// the size of a file is taken from the file system
+	path := filepath.Join(t.TempDir(), "truncated.iso")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+	img, err = OpenImage(f)
+	require.NoError(t, err)
+	missing, truncated = img.IsTruncated()
+	assert.True(t, truncated)
+	assert.Equal(t, int64(sectorSize)+100, missing)
+
+	// and that of a memory mapping from its length
+	mapped, err := OpenImageMmap(path)
+	require.NoError(t, err)
+	defer mapped.Close() // nolint: errcheck
+	missing, truncated = mapped.IsTruncated()
+	assert.True(t, truncated)
+	assert.Equal(t, int64(sectorSize)+100, missing)
+}
+
+func TestTruncationUnknownSize(t *testing.T) {
+	data, _ := truncatedImage(t)
+
+	// the size of a plain io.ReaderAt cannot be told, so the reads fail as they reach the end
+	img, err := OpenImage(struct{ io.ReaderAt }{bytes.NewReader(data)})
+	require.NoError(t, err)
+	_, truncated := img.IsTruncated()
+	assert.False(t, truncated)
+
+	n, err := io.Copy(io.Discard, filesByPath(t, img)["/LAST.BIN"].Reader())
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, 2*int64(sectorSize)-100, n)
+}
@@ -0,0 +1,737 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// UDF, as defined by ECMA-167 and restricted by the OSTA UDF specification, is the file system of DVDs,
+// Blu-ray discs and the install images of Windows. A UDF bridge image records its files in an ISO 9660 volume
+// as well, which is sometimes only a stub telling that UDF is needed. The ISO 9660 volume descriptors are followed
+// by the volume recognition sequence, BEA01, NSR02 or NSR03 and TEA01, and the descriptors of the UDF volume
+// are found through the Anchor Volume Descriptor Pointer in sector 256.
+//
+// Only what is needed to list and read the files is decoded: the logical volume, its type 1 partition maps,
+// the file set, and the file entries with their short, long, extended or embedded allocation descriptors.
+// The virtual, sparable and metadata partitions of the later revisions, found on recordable media
+// and Blu-ray discs, are not supported, nor are multi-session discs and named streams.
+
+const (
+	// udfAnchorSector holds the Anchor Volume Descriptor Pointer, which the other descriptors are found through
+	udfAnchorSector = 256
+	// udfMaxRecognitionSectors bounds the volume recognition sequence, which doesn't need to be terminated
+	// by the time a non-conforming image ends it
+	udfMaxRecognitionSectors = 64
+	// udfMaxAllocationExtents bounds the chains of allocation extent descriptors, which could loop in a corrupted image
+	udfMaxAllocationExtents = 1024
+
+	udfTagAnchor            = 2
+	udfTagPartition         = 5
+	udfTagLogicalVolume     = 6
+	udfTagTerminating       = 8
+	udfTagFileSet           = 256
+	udfTagFileIdentifier    = 257
+	udfTagAllocationExtent  = 258
+	udfTagFileEntry         = 261
+	udfTagExtendedFileEntry = 266
+)
+
+// the identifiers of the volume structure descriptors of ECMA-167 2/9, next to those of ECMA-119
+var udfRecognitionIdentifiers = map[string]bool{
+	standardIdentifier: true, "CDW02": true, "BOOT2": true, udfIdentifier: true, "NSR02": true, "NSR03": true, "TEA01": true,
+}
+
+// the file types of the ICB tag of ECMA-167 4/14.6.6
+const (
+	udfFileTypeDirectory = 4
+	udfFileTypeRegular   = 5
+	udfFileTypeBlock     = 6
+	udfFileTypeCharacter = 7
+	udfFileTypeFIFO      = 9
+	udfFileTypeSocket    = 10
+	udfFileTypeSymlink   = 12
+)
+
+// the characteristics of a File Identifier Descriptor of ECMA-167 4/14.4.3
+const (
+	udfFileHidden  = 1 << 0
+	udfFileDeleted = 1 << 2
+	udfFileParent  = 1 << 3
+)
+
+var errUDFCorrupted = errors.New("corrupted UDF volume")
+
+// UDFVolume is the logical volume of a UDF or UDF bridge image, see OpenUDF and Image.UDF
+type UDFVolume struct {
+	ra        io.ReaderAt
+	blockSize uint32
+	// partitions are the starting sectors of the partitions, by partition reference number
+	partitions []uint32
+	root       udfLongAD
+
+	// Revision is the identifier of the NSR descriptor of the volume recognition sequence,
+	// NSR02 for UDF 1.02 to 1.50 and NSR03 for UDF 2.00 and later
+	Revision string
+	// Identifier is the logical volume identifier, which is usually the label of the disc
+	Identifier string
+}
+
+// udfLongAD is a long_ad of ECMA-167 4/14.14.2, locating an extent within a partition
+type udfLongAD struct {
+	length    uint32
+	block     uint32
+	partition uint16
+}
+
+func unmarshalUDFLongAD(data []byte) udfLongAD {
+	return udfLongAD{
+		length:    binary.LittleEndian.Uint32(data[0:4]),
+		block:     binary.LittleEndian.Uint32(data[4:8]),
+		partition: binary.LittleEndian.Uint16(data[8:10]),
+	}
+}
+
+// DetectUDF returns the identifier of the NSR descriptor if the volume recognition sequence of the image
+// announces a UDF volume, NSR02 or NSR03, or an empty string if it doesn't
+func DetectUDF(ra io.ReaderAt) (string, error) {
+	buffer := make([]byte, sectorSize)
+	extended := false
+	for n := int64(16); n < 16+udfMaxRecognitionSectors; n++ {
+		if _, err := ra.ReadAt(buffer, n*int64(sectorSize)); err != nil {
+			if err == io.EOF {
+				return "", nil
+			}
+			return "", fmt.Errorf("reading the volume recognition sequence: %w", err)
+		}
+		id := string(buffer[1:6])
+		if !udfRecognitionIdentifiers[id] {
+			return "", nil
+		}
+		switch id {
+		case udfIdentifier:
+			extended = true
+		case "TEA01":
+			extended = false
+		case "NSR02", "NSR03":
+			if extended {
+				return id, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// UDF returns the UDF volume of a UDF bridge image, or nil if the image has none, see DetectUDF and OpenUDF
+func (i *Image) UDF() (*UDFVolume, error) {
+	revision, err := DetectUDF(i.ra)
+	if err != nil || revision == "" {
+		return nil, err
+	}
+	return openUDF(i.ra, revision)
+}
+
+// OpenUDF opens the UDF volume of an image, which doesn't need an ISO 9660 volume.
+// OpenImage fails with ErrUDFNotSupported for images without one. Dumps of raw sectors are detected like OpenImage does.
+func OpenUDF(ra io.ReaderAt) (*UDFVolume, error) {
+	if raw, ok := DetectRawSectors(ra); ok {
+		ra = raw
+	}
+	revision, err := DetectUDF(ra)
+	if err != nil {
+		return nil, err
+	}
+	if revision == "" {
+		return nil, errors.New("the image has no UDF volume recognition sequence")
+	}
+	return openUDF(ra, revision)
+}
+
+func openUDF(ra io.ReaderAt, revision string) (*UDFVolume, error) {
+	anchor := make([]byte, sectorSize)
+	if _, err := ra.ReadAt(anchor, udfAnchorSector*int64(sectorSize)); err != nil {
+		return nil, fmt.Errorf("reading the UDF anchor volume descriptor pointer: %w", err)
+	}
+	if err := checkUDFTag(anchor, udfTagAnchor); err != nil {
+		return nil, fmt.Errorf("the UDF anchor volume descriptor pointer: %w", err)
+	}
+
+	// the reserve sequence is a copy of the main one, read if the main one is damaged
+	var err error
+	for _, extent := range [][]byte{anchor[16:24], anchor[24:32]} {
+		v := &UDFVolume{ra: ra, Revision: revision}
+		length, location := binary.LittleEndian.Uint32(extent[0:4]), binary.LittleEndian.Uint32(extent[4:8])
+		if err = v.readDescriptorSequence(location, length); err == nil {
+			return v, nil
+		}
+	}
+	return nil, err
+}
+
+// checkUDFTag checks the descriptor tag of ECMA-167 3/7.2 at the start of the data
+func checkUDFTag(data []byte, identifier uint16) error {
+	if len(data) < 16 {
+		return fmt.Errorf("%w: descriptor tag too short", errUDFCorrupted)
+	}
+	var checksum byte
+	for n, b := range data[:16] {
+		if n != 4 {
+			checksum += b
+		}
+	}
+	if checksum != data[4] {
+		return fmt.Errorf("%w: invalid descriptor tag checksum", errUDFCorrupted)
+	}
+	if tag := binary.LittleEndian.Uint16(data[0:2]); tag != identifier {
+		return fmt.Errorf("%w: descriptor tag %d instead of %d", errUDFCorrupted, tag, identifier)
+	}
+	return nil
+}
+
+// readDescriptorSequence reads the partitions and the logical volume from a volume descriptor sequence
+func (v *UDFVolume) readDescriptorSequence(location, length uint32) error {
+	partitions := make(map[uint16]uint32)
+	var lvd []byte
+
+	buffer := make([]byte, sectorSize)
+	for n := uint32(0); n < length/sectorSize; n++ {
+		if _, err := v.ra.ReadAt(buffer, int64(location+n)*int64(sectorSize)); err != nil {
+			return fmt.Errorf("reading the UDF volume descriptor sequence: %w", err)
+		}
+		tag := binary.LittleEndian.Uint16(buffer[0:2])
+		if err := checkUDFTag(buffer, tag); err != nil {
+			return fmt.Errorf("the UDF volume descriptor at sector %d: %w", location+n, err)
+		}
+		if tag == udfTagTerminating {
+			break
+		}
+		switch tag {
+		case udfTagPartition:
+			partitions[binary.LittleEndian.Uint16(buffer[22:24])] = binary.LittleEndian.Uint32(buffer[188:192])
+		case udfTagLogicalVolume:
+			lvd = append([]byte(nil), buffer...)
+		}
+	}
+	if lvd == nil {
+		return fmt.Errorf("%w: no logical volume descriptor", errUDFCorrupted)
+	}
+
+	v.Identifier = udfDString(lvd[84:212])
+	v.blockSize = binary.LittleEndian.Uint32(lvd[212:216])
+	if v.blockSize == 0 || v.blockSize%512 != 0 {
+		return fmt.Errorf("%w: logical block size %d", errUDFCorrupted, v.blockSize)
+	}
+
+	maps := binary.LittleEndian.Uint32(lvd[268:272])
+	table := lvd[440:]
+	if tableLength := binary.LittleEndian.Uint32(lvd[264:268]); int(tableLength) < len(table) {
+		table = table[:tableLength]
+	}
+	for n := uint32(0); n < maps; n++ {
+		if len(table) < 2 || int(table[1]) > len(table) || table[1] < 2 {
+			return fmt.Errorf("%w: partition map %d", errUDFCorrupted, n)
+		}
+		if table[0] != 1 || table[1] != 6 {
+			identifier := ""
+			if table[0] == 2 && len(table) >= 28 {
+				identifier = ": " + strings.TrimRight(string(table[5:28]), "\x00")
+			}
+			return fmt.Errorf("unsupported UDF partition map of type %d%s", table[0], identifier)
+		}
+		start, ok := partitions[binary.LittleEndian.Uint16(table[4:6])]
+		if !ok {
+			return fmt.Errorf("%w: no partition descriptor for partition %d", errUDFCorrupted, binary.LittleEndian.Uint16(table[4:6]))
+		}
+		v.partitions = append(v.partitions, start)
+		table = table[table[1]:]
+	}
+
+	// the logical volume contents use holds the location of the file set descriptor
+	fsd, err := v.readBlock(unmarshalUDFLongAD(lvd[248:264]))
+	if err != nil {
+		return fmt.Errorf("reading the UDF file set descriptor: %w", err)
+	}
+	if err := checkUDFTag(fsd, udfTagFileSet); err != nil {
+		return fmt.Errorf("the UDF file set descriptor: %w", err)
+	}
+	v.root = unmarshalUDFLongAD(fsd[400:416])
+	return nil
+}
+
+// offset returns the position in the image of a logical block of a partition
+func (v *UDFVolume) offset(partition uint16, block uint32) (int64, error) {
+	if int(partition) >= len(v.partitions) {
+		return 0, fmt.Errorf("%w: partition reference %d", errUDFCorrupted, partition)
+	}
+	return (int64(v.partitions[partition]) + int64(block)) * int64(v.blockSize), nil
+}
+
+// readBlock reads the logical block an extent starts at
+func (v *UDFVolume) readBlock(ad udfLongAD) ([]byte, error) {
+	offset, err := v.offset(ad.partition, ad.block)
+	if err != nil {
+		return nil, err
+	}
+	block := make([]byte, v.blockSize)
+	if _, err := v.ra.ReadAt(block, offset); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// udfDString decodes a dstring of ECMA-167 1/7.2.12, whose last byte is the length of the recorded part
+func udfDString(field []byte) string {
+	length := int(field[len(field)-1])
+	if length == 0 || length >= len(field) {
+		return ""
+	}
+	return udfCompressedUnicode(field[:length])
+}
+
+// udfCompressedUnicode decodes the OSTA Compressed Unicode of UDF 2.1.1: a compression ID of 8 is followed by
+// one byte per character, one of 16 by big endian UTF-16 code units. UDF 2.50 adds 254 and 255 for the same.
+func udfCompressedUnicode(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	switch data[0] {
+	case 8, 254:
+		runes := make([]rune, len(data)-1)
+		for n, b := range data[1:] {
+			runes[n] = rune(b)
+		}
+		return string(runes)
+	case 16, 255:
+		units := make([]uint16, (len(data)-1)/2)
+		for n := range units {
+			units[n] = binary.BigEndian.Uint16(data[1+2*n:])
+		}
+		return string(utf16.Decode(units))
+	}
+	return ""
+}
+
+// udfTimestamp decodes a timestamp of ECMA-167 1/7.3, the zero time if the year is 0
+func udfTimestamp(data []byte) time.Time {
+	typeAndZone := binary.LittleEndian.Uint16(data[0:2])
+	year := int(int16(binary.LittleEndian.Uint16(data[2:4])))
+	if year == 0 {
+		return time.Time{}
+	}
+	location := time.UTC
+	// the offset from UTC is a signed 12-bit number of minutes, -2047 if it isn't specified
+	if typeAndZone>>12 == 1 {
+		if minutes := int(int16(typeAndZone<<4) >> 4); minutes != -2047 {
+			location = time.FixedZone("", minutes*60)
+		}
+	}
+	microseconds := int(data[9])*10000 + int(data[10])*100 + int(data[11])
+	return time.Date(year, time.Month(data[4]), int(data[5]), int(data[6]), int(data[7]), int(data[8]), microseconds*1000, location)
+}
+
+// udfExtent is an extent of the data of a file, in the partition of its file entry unless a long_ad says otherwise
+type udfExtent struct {
+	length    uint32
+	block     uint32
+	partition uint16
+	// recorded is false for the extents which aren't recorded and read as zeroes
+	recorded bool
+}
+
+// UDFFile is a file or a directory of a UDF volume
+type UDFFile struct {
+	v       *UDFVolume
+	name    string
+	hidden  bool
+	icb     udfLongAD
+	entry   []byte
+	extents []udfExtent
+	// embedded holds the data recorded in the file entry itself, if the allocation descriptors say so
+	embedded []byte
+	// the fields which differ in place between File Entries and Extended File Entries
+	size                  uint64
+	modTime, accessTime   time.Time
+	creationTime, changed time.Time
+}
+
+// RootDir returns the root directory of the file set
+func (v *UDFVolume) RootDir() (*UDFFile, error) {
+	return v.readFile(v.root, "", false)
+}
+
+// readFile reads the file entry of ECMA-167 4/14.9, or the extended file entry of 4/14.17, at the given ICB
+func (v *UDFVolume) readFile(icb udfLongAD, name string, hidden bool) (*UDFFile, error) {
+	entry, err := v.readBlock(icb)
+	if err != nil {
+		return nil, fmt.Errorf("reading the UDF file entry of %q: %w", name, err)
+	}
+	f := &UDFFile{v: v, name: name, hidden: hidden, icb: icb, entry: entry}
+
+	tag := binary.LittleEndian.Uint16(entry[0:2])
+	if tag != udfTagFileEntry && tag != udfTagExtendedFileEntry {
+		return nil, fmt.Errorf("the UDF file entry of %q: %w: descriptor tag %d", name, errUDFCorrupted, tag)
+	}
+	if err := checkUDFTag(entry, tag); err != nil {
+		return nil, fmt.Errorf("the UDF file entry of %q: %w", name, err)
+	}
+
+	var descriptors int
+	switch tag {
+	case udfTagFileEntry:
+		f.size = binary.LittleEndian.Uint64(entry[56:64])
+		f.accessTime = udfTimestamp(entry[72:84])
+		f.modTime = udfTimestamp(entry[84:96])
+		f.changed = udfTimestamp(entry[96:108])
+		descriptors = 176 + int(binary.LittleEndian.Uint32(entry[168:172]))
+	case udfTagExtendedFileEntry:
+		f.size = binary.LittleEndian.Uint64(entry[56:64])
+		f.accessTime = udfTimestamp(entry[80:92])
+		f.modTime = udfTimestamp(entry[92:104])
+		f.creationTime = udfTimestamp(entry[104:116])
+		f.changed = udfTimestamp(entry[116:128])
+		descriptors = 216 + int(binary.LittleEndian.Uint32(entry[208:212]))
+	}
+	if descriptors > len(entry) {
+		return nil, fmt.Errorf("the UDF file entry of %q: %w: extended attributes beyond the entry", name, errUDFCorrupted)
+	}
+
+	length := int(binary.LittleEndian.Uint32(entry[descriptors-4 : descriptors]))
+	if descriptors+length > len(entry) {
+		return nil, fmt.Errorf("the UDF file entry of %q: %w: allocation descriptors beyond the entry", name, errUDFCorrupted)
+	}
+	if err := f.readAllocationDescriptors(entry[descriptors : descriptors+length]); err != nil {
+		return nil, fmt.Errorf("the UDF file entry of %q: %w", name, err)
+	}
+	return f, nil
+}
+
+// flags returns the flags of the ICB tag of the file entry
+func (f *UDFFile) flags() uint16 {
+	return binary.LittleEndian.Uint16(f.entry[34:36])
+}
+
+// readAllocationDescriptors decodes the allocation descriptors of the file entry, following the chain
+// of allocation extent descriptors
+func (f *UDFFile) readAllocationDescriptors(data []byte) error {
+	kind := f.flags() & 7
+	if kind == 3 {
+		f.embedded = data
+		return nil
+	}
+
+	for chained := 0; ; chained++ {
+		var next *udfLongAD
+		for len(data) > 0 {
+			var extent udfExtent
+			switch kind {
+			case 0:
+				if len(data) < 8 {
+					return fmt.Errorf("%w: short allocation descriptor", errUDFCorrupted)
+				}
+				extent = udfExtent{length: binary.LittleEndian.Uint32(data[0:4]), block: binary.LittleEndian.Uint32(data[4:8]), partition: f.icb.partition}
+				data = data[8:]
+			case 1:
+				if len(data) < 16 {
+					return fmt.Errorf("%w: long allocation descriptor", errUDFCorrupted)
+				}
+				ad := unmarshalUDFLongAD(data)
+				extent = udfExtent{length: ad.length, block: ad.block, partition: ad.partition}
+				data = data[16:]
+			case 2:
+				if len(data) < 20 {
+					return fmt.Errorf("%w: extended allocation descriptor", errUDFCorrupted)
+				}
+				ad := unmarshalUDFLongAD(append(append([]byte(nil), data[0:4]...), data[12:18]...))
+				extent = udfExtent{length: ad.length, block: ad.block, partition: ad.partition}
+				data = data[20:]
+			default:
+				return fmt.Errorf("%w: allocation descriptors of type %d", errUDFCorrupted, kind)
+			}
+
+			// the two high bits of the length tell the type of the extent
+			extentType := extent.length >> 30
+			extent.length &= 1<<30 - 1
+			if extent.length == 0 {
+				break
+			}
+			if extentType == 3 {
+				next = &udfLongAD{length: extent.length, block: extent.block, partition: extent.partition}
+				break
+			}
+			extent.recorded = extentType == 0
+			f.extents = append(f.extents, extent)
+		}
+
+		if next == nil {
+			return nil
+		}
+		if chained == udfMaxAllocationExtents {
+			return fmt.Errorf("%w: too many allocation extent descriptors", errUDFCorrupted)
+		}
+		aed, err := f.v.readBlock(*next)
+		if err != nil {
+			return fmt.Errorf("reading an allocation extent descriptor: %w", err)
+		}
+		if err := checkUDFTag(aed, udfTagAllocationExtent); err != nil {
+			return err
+		}
+		length := int(binary.LittleEndian.Uint32(aed[20:24]))
+		if 24+length > len(aed) {
+			return fmt.Errorf("%w: allocation descriptors beyond the allocation extent descriptor", errUDFCorrupted)
+		}
+		data = aed[24 : 24+length]
+	}
+}
+
+// Name returns the file identifier, which is empty for the root directory
+func (f *UDFFile) Name() string {
+	return f.name
+}
+
+// fileType returns the file type of the ICB tag
+func (f *UDFFile) fileType() byte {
+	return f.entry[27]
+}
+
+// IsDir reports whether the entry is a directory
+func (f *UDFFile) IsDir() bool {
+	return f.fileType() == udfFileTypeDirectory
+}
+
+// IsHidden reports whether the File Identifier Descriptor of the entry has the existence (hidden) bit set
+func (f *UDFFile) IsHidden() bool {
+	return f.hidden
+}
+
+// Size returns the information length of the file, which is its size in bytes
+func (f *UDFFile) Size() int64 {
+	return int64(f.size)
+}
+
+// ModTime returns the modification time of the file
+func (f *UDFFile) ModTime() time.Time {
+	return f.modTime
+}
+
+// Times returns the timestamps of the file entry. The creation time is only recorded by extended file entries.
+func (f *UDFFile) Times() RecordTimes {
+	return RecordTimes{Modification: f.modTime, Access: f.accessTime, Creation: f.creationTime, Change: f.changed}
+}
+
+// Owner returns the user and group IDs. UDF records 0xFFFFFFFF for IDs it doesn't know.
+func (f *UDFFile) Owner() (uid, gid uint32) {
+	return binary.LittleEndian.Uint32(f.entry[36:40]), binary.LittleEndian.Uint32(f.entry[40:44])
+}
+
+// Links returns the number of File Identifier Descriptors pointing at the file entry
+func (f *UDFFile) Links() uint32 {
+	return uint32(binary.LittleEndian.Uint16(f.entry[48:50]))
+}
+
+// Mode returns the type and the permissions of the file. The read, write and execute permissions of UDF
+// map to those of POSIX, the change attribute and delete permissions are left out.
+func (f *UDFFile) Mode() fs.FileMode {
+	permissions := binary.LittleEndian.Uint32(f.entry[44:48])
+	mode := fs.FileMode((permissions>>10&7)<<6 | (permissions>>5&7)<<3 | permissions&7)
+
+	switch f.fileType() {
+	case udfFileTypeDirectory:
+		mode |= fs.ModeDir
+	case udfFileTypeSymlink:
+		mode |= fs.ModeSymlink
+	case udfFileTypeBlock:
+		mode |= fs.ModeDevice
+	case udfFileTypeCharacter:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case udfFileTypeFIFO:
+		mode |= fs.ModeNamedPipe
+	case udfFileTypeSocket:
+		mode |= fs.ModeSocket
+	}
+
+	// the flags of the ICB tag hold the setuid, setgid and sticky bits
+	flags := f.flags()
+	if flags&0x40 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if flags&0x80 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if flags&0x100 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// OpenReader returns a reader of the data of the file. The extents which aren't recorded read as zeroes.
+func (f *UDFFile) OpenReader() (io.Reader, error) {
+	if f.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", f.name)
+	}
+	return f.reader()
+}
+
+func (f *UDFFile) reader() (io.Reader, error) {
+	if f.embedded != nil {
+		if uint64(len(f.embedded)) < f.size {
+			return nil, fmt.Errorf("%w: %d bytes embedded in the file entry of %q, which has %d", errUDFCorrupted, len(f.embedded), f.name, f.size)
+		}
+		return strings.NewReader(string(f.embedded[:f.size])), nil
+	}
+
+	var readers []io.Reader
+	remaining := int64(f.size)
+	for _, extent := range f.extents {
+		if remaining == 0 {
+			break
+		}
+		length := min64(int64(extent.length), remaining)
+		remaining -= length
+		if !extent.recorded {
+			readers = append(readers, io.LimitReader(udfZeroes{}, length))
+			continue
+		}
+		offset, err := f.v.offset(extent.partition, extent.block)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, io.NewSectionReader(f.v.ra, offset, length))
+	}
+	if remaining > 0 {
+		return nil, fmt.Errorf("%w: the extents of %q are shorter than its %d bytes", errUDFCorrupted, f.name, f.size)
+	}
+	return io.MultiReader(readers...), nil
+}
+
+// udfZeroes reads the extents which aren't recorded
+type udfZeroes struct{}
+
+func (udfZeroes) Read(p []byte) (int, error) {
+	for n := range p {
+		p[n] = 0
+	}
+	return len(p), nil
+}
+
+// SymlinkTarget decodes the path components of ECMA-167 4/14.16 recorded as the data of a symbolic link
+func (f *UDFFile) SymlinkTarget() (string, error) {
+	if f.fileType() != udfFileTypeSymlink {
+		return "", fmt.Errorf("%s is not a symbolic link", f.name)
+	}
+	r, err := f.reader()
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	var components []string
+	absolute := false
+	for len(data) > 0 {
+		if len(data) < 4 || len(data) < 4+int(data[1]) {
+			return "", fmt.Errorf("%w: path component of %q", errUDFCorrupted, f.name)
+		}
+		identifier := data[4 : 4+int(data[1])]
+		switch data[0] {
+		case 1, 2:
+			absolute, components = true, nil
+		case 3:
+			components = append(components, "..")
+		case 4:
+			components = append(components, ".")
+		case 5:
+			components = append(components, udfCompressedUnicode(identifier))
+		default:
+			return "", fmt.Errorf("%w: path component of type %d in %q", errUDFCorrupted, data[0], f.name)
+		}
+		data = data[4+int(data[1]):]
+	}
+	target := strings.Join(components, "/")
+	if absolute {
+		target = "/" + target
+	}
+	return target, nil
+}
+
+// GetChildren returns the entries of the directory, in the order of their File Identifier Descriptors.
+// The deleted entries and the parent directory are left out.
+func (f *UDFFile) GetChildren() ([]*UDFFile, error) {
+	if !f.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", f.name)
+	}
+	r, err := f.reader()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading the UDF directory %q: %w", f.name, err)
+	}
+
+	var children []*UDFFile
+	for len(data) >= 38 {
+		if err := checkUDFTag(data, udfTagFileIdentifier); err != nil {
+			return nil, fmt.Errorf("the UDF directory %q: %w", f.name, err)
+		}
+		characteristics := data[18]
+		identifierLength := int(data[19])
+		implementationUse := int(binary.LittleEndian.Uint16(data[36:38]))
+		// the descriptors are padded to a multiple of 4 bytes
+		length := (38 + implementationUse + identifierLength + 3) &^ 3
+		if length > len(data) {
+			return nil, fmt.Errorf("the UDF directory %q: %w: truncated file identifier descriptor", f.name, errUDFCorrupted)
+		}
+
+		if characteristics&(udfFileDeleted|udfFileParent) == 0 {
+			name := udfCompressedUnicode(data[38+implementationUse : 38+implementationUse+identifierLength])
+			child, err := f.v.readFile(unmarshalUDFLongAD(data[20:36]), name, characteristics&udfFileHidden != 0)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, child)
+		}
+		data = data[length:]
+	}
+	return children, nil
+}
+
+// LookupPath returns the entry at a slash-separated path made of the file identifiers,
+// following no symbolic links. It returns an error wrapping fs.ErrNotExist if there is none.
+func (v *UDFVolume) LookupPath(name string) (*UDFFile, error) {
+	f, err := v.RootDir()
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		if !f.IsDir() {
+			return nil, &fs.PathError{Op: "lookup", Path: name, Err: os.ErrNotExist}
+		}
+		children, err := f.GetChildren()
+		if err != nil {
+			return nil, err
+		}
+		f = nil
+		for _, c := range children {
+			if c.name == segment {
+				f = c
+				break
+			}
+		}
+		if f == nil {
+			return nil, &fs.PathError{Op: "lookup", Path: name, Err: os.ErrNotExist}
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,307 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// the sectors of the UDF structures written by writeUDFVolume, which leave room for a small ISO 9660 volume
+	udfTestDescriptors = 270
+	udfTestPartition   = 300
+	udfTestSectors     = 320
+)
+
+var udfTestTime = time.Date(2020, time.May, 6, 10, 20, 30, 0, time.FixedZone("", 60*60))
+
+// udfTag fills in the descriptor tag of a descriptor
+func udfTag(descriptor []byte, identifier uint16, location uint32) {
+	binary.LittleEndian.PutUint16(descriptor[0:2], identifier)
+	binary.LittleEndian.PutUint16(descriptor[2:4], 2)
+	binary.LittleEndian.PutUint32(descriptor[12:16], location)
+	var checksum byte
+	for n, b := range descriptor[:16] {
+		if n != 4 {
+			checksum += b
+		}
+	}
+	descriptor[4] = checksum
+}
+
+// udfTestTimestamp encodes udfTestTime
+func udfTestTimestamp(dst []byte) {
+	binary.LittleEndian.PutUint16(dst[0:2], 1<<12|60)
+	binary.LittleEndian.PutUint16(dst[2:4], uint16(udfTestTime.Year()))
+	dst[4], dst[5], dst[6], dst[7], dst[8] = byte(udfTestTime.Month()), byte(udfTestTime.Day()), byte(udfTestTime.Hour()), byte(udfTestTime.Minute()), byte(udfTestTime.Second())
+}
+
+// udfShortAD encodes a short_ad of an extent of the given type
+func udfShortAD(extentType, length, block uint32) []byte {
+	ad := make([]byte, 8)
+	binary.LittleEndian.PutUint32(ad[0:4], extentType<<30|length)
+	binary.LittleEndian.PutUint32(ad[4:8], block)
+	return ad
+}
+
+// udfLongADBytes encodes a long_ad of a recorded extent
+func udfLongADBytes(length, block uint32) []byte {
+	ad := make([]byte, 16)
+	binary.LittleEndian.PutUint32(ad[0:4], length)
+	binary.LittleEndian.PutUint32(ad[4:8], block)
+	return ad
+}
+
+// udfFileEntry encodes a file entry, or an extended one, with the given allocation descriptors
+func udfFileEntry(block uint32, extended bool, fileType byte, flags uint16, permissions uint32, size uint64, ads []byte) []byte {
+	fe := make([]byte, sectorSize)
+	fe[27] = fileType
+	binary.LittleEndian.PutUint16(fe[34:36], flags)
+	binary.LittleEndian.PutUint32(fe[36:40], 1000)
+	binary.LittleEndian.PutUint32(fe[40:44], 100)
+	binary.LittleEndian.PutUint32(fe[44:48], permissions)
+	binary.LittleEndian.PutUint16(fe[48:50], 1)
+	binary.LittleEndian.PutUint64(fe[56:64], size)
+	if extended {
+		udfTestTimestamp(fe[92:104])
+		binary.LittleEndian.PutUint32(fe[212:216], uint32(len(ads)))
+		copy(fe[216:], ads)
+		udfTag(fe, udfTagExtendedFileEntry, block)
+	} else {
+		udfTestTimestamp(fe[84:96])
+		binary.LittleEndian.PutUint32(fe[172:176], uint32(len(ads)))
+		copy(fe[176:], ads)
+		udfTag(fe, udfTagFileEntry, block)
+	}
+	return fe
+}
+
+// udfFID encodes a File Identifier Descriptor with an identifier already in compressed unicode
+func udfFID(characteristics byte, identifier []byte, icb uint32) []byte {
+	fid := make([]byte, (38+len(identifier)+3)&^3)
+	binary.LittleEndian.PutUint16(fid[16:18], 1)
+	fid[18] = characteristics
+	fid[19] = byte(len(identifier))
+	copy(fid[20:36], udfLongADBytes(sectorSize, icb))
+	copy(fid[38:], identifier)
+	udfTag(fid, udfTagFileIdentifier, 0)
+	return fid
+}
+
+// writeUDFVolume writes the volume recognition sequence from the given sector and a UDF volume with these files:
+//
+//	/hello.txt       a regular file of short_ad
+//	/chained.txt     a regular file whose short_ad continue in an allocation extent descriptor
+//	/.secret         a hidden file embedded in its file entry
+//	/link            a symlink to /usr/bin
+//	/dir/            a directory of an extended file entry and long_ad
+//	/dir/sparse.bin  a file starting with an extent which isn't recorded
+//	/dir/ünï         a file of a 16-bit identifier
+func writeUDFVolume(image []byte, recognition int) {
+	sector := func(n int) []byte {
+		return image[n*int(sectorSize) : (n+1)*int(sectorSize)]
+	}
+	block := func(n int) []byte {
+		return sector(udfTestPartition + n)
+	}
+	for n, id := range []string{udfIdentifier, "NSR02", "TEA01"} {
+		copy(sector(recognition + n)[1:6], id)
+		sector(recognition + n)[6] = 1
+	}
+
+	anchor := sector(udfAnchorSector)
+	binary.LittleEndian.PutUint32(anchor[16:20], 16*sectorSize)
+	binary.LittleEndian.PutUint32(anchor[20:24], udfTestDescriptors)
+	binary.LittleEndian.PutUint32(anchor[24:28], 16*sectorSize)
+	binary.LittleEndian.PutUint32(anchor[28:32], udfTestDescriptors)
+	udfTag(anchor, udfTagAnchor, udfAnchorSector)
+
+	pd := sector(udfTestDescriptors)
+	binary.LittleEndian.PutUint32(pd[188:192], udfTestPartition)
+	binary.LittleEndian.PutUint32(pd[192:196], udfTestSectors-udfTestPartition)
+	udfTag(pd, udfTagPartition, udfTestDescriptors)
+
+	lvd := sector(udfTestDescriptors + 1)
+	copy(lvd[84:], "\x08TESTDISC")
+	lvd[211] = 9
+	binary.LittleEndian.PutUint32(lvd[212:216], sectorSize)
+	copy(lvd[248:264], udfLongADBytes(sectorSize, 0))
+	binary.LittleEndian.PutUint32(lvd[264:268], 6)
+	binary.LittleEndian.PutUint32(lvd[268:272], 1)
+	copy(lvd[440:], []byte{1, 6, 1, 0, 0, 0})
+	udfTag(lvd, udfTagLogicalVolume, udfTestDescriptors+1)
+	udfTag(sector(udfTestDescriptors+2), udfTagTerminating, udfTestDescriptors+2)
+
+	// 0644 and 0755 as the permissions of UDF
+	const rw, rwx = 0x1800 | 0x80 | 0x4, 0x1C00 | 0xA0 | 0x5
+
+	fsd := block(0)
+	copy(fsd[400:416], udfLongADBytes(sectorSize, 1))
+	udfTag(fsd, udfTagFileSet, 0)
+
+	var root []byte
+	for _, fid := range [][]byte{
+		udfFID(udfFileParent|0x02, nil, 1),
+		udfFID(0, []byte("\x08hello.txt"), 3),
+		udfFID(0, []byte("\x08chained.txt"), 12),
+		udfFID(udfFileHidden, []byte("\x08.secret"), 6),
+		udfFID(0, []byte("\x08link"), 7),
+		udfFID(udfFileDeleted, []byte("\x08gone"), 3),
+		udfFID(0x02, []byte("\x08dir"), 4),
+	} {
+		root = append(root, fid...)
+	}
+	copy(block(2), root)
+	copy(block(1), udfFileEntry(1, false, udfFileTypeDirectory, 0, rwx, uint64(len(root)), udfShortAD(0, uint32(len(root)), 2)))
+
+	copy(block(3), udfFileEntry(3, false, udfFileTypeRegular, 0, rw, 11, udfShortAD(0, 11, 10)))
+	copy(block(10), "hello world")
+
+	copy(block(12), udfFileEntry(12, false, udfFileTypeRegular, 0, rw, uint64(2*sectorSize+3), append(udfShortAD(0, sectorSize, 13), udfShortAD(3, sectorSize, 14)...)))
+	copy(block(13), bytes.Repeat([]byte("a"), int(sectorSize)))
+	aed := block(14)
+	binary.LittleEndian.PutUint32(aed[20:24], 16)
+	copy(aed[24:], append(udfShortAD(0, sectorSize, 15), udfShortAD(0, 3, 16)...))
+	udfTag(aed, udfTagAllocationExtent, 14)
+	copy(block(15), bytes.Repeat([]byte("b"), int(sectorSize)))
+	copy(block(16), "end")
+
+	copy(block(6), udfFileEntry(6, false, udfFileTypeRegular, 3, rw, 6, []byte("secret")))
+
+	target := []byte{2, 0, 0, 0, 5, 4, 0, 0, 8, 'u', 's', 'r', 5, 4, 0, 0, 8, 'b', 'i', 'n'}
+	copy(block(7), udfFileEntry(7, false, udfFileTypeSymlink, 3, rwx, uint64(len(target)), target))
+
+	var dir []byte
+	for _, fid := range [][]byte{
+		udfFID(udfFileParent|0x02, nil, 1),
+		udfFID(0, []byte("\x08sparse.bin"), 8),
+		udfFID(0, []byte{16, 0, 0xFC, 0, 'n', 0, 0xEF}, 9),
+	} {
+		dir = append(dir, fid...)
+	}
+	copy(block(5), dir)
+	copy(block(4), udfFileEntry(4, true, udfFileTypeDirectory, 1, rwx, uint64(len(dir)), udfLongADBytes(uint32(len(dir)), 5)))
+
+	copy(block(8), udfFileEntry(8, true, udfFileTypeRegular, 0x40, rwx, uint64(sectorSize+4), append(udfShortAD(1, sectorSize, 0), udfShortAD(0, 4, 11)...)))
+	copy(block(11), "tail")
+	copy(block(9), udfFileEntry(9, false, udfFileTypeRegular, 3, rw, 0, nil))
+}
+
+func readUDFFile(t *testing.T, f *UDFFile) string {
+	r, err := f.OpenReader()
+	require.NoError(t, err, f.Name())
+	data, err := io.ReadAll(r)
+	require.NoError(t, err, f.Name())
+	return string(data)
+}
+
+func TestOpenUDF(t *testing.T) {
+	image := make([]byte, udfTestSectors*sectorSize)
+	writeUDFVolume(image, 16)
+
+	_, err := OpenImage(bytes.NewReader(image))
+	assert.ErrorIs(t, err, ErrUDFNotSupported)
+
+	revision, err := DetectUDF(bytes.NewReader(image))
+	require.NoError(t, err)
+	assert.Equal(t, "NSR02", revision)
+
+	v, err := OpenUDF(bytes.NewReader(image))
+	require.NoError(t, err)
+	assert.Equal(t, "TESTDISC", v.Identifier)
+	assert.Equal(t, "NSR02", v.Revision)
+
+	root, err := v.RootDir()
+	require.NoError(t, err)
+	assert.True(t, root.IsDir())
+	assert.Equal(t, "", root.Name())
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	var names []string
+	for _, c := range children {
+		names = append(names, c.Name())
+	}
+	assert.Equal(t, []string{"hello.txt", "chained.txt", ".secret", "link", "dir"}, names)
+
+	hello := children[0]
+	assert.Equal(t, fs.FileMode(0644), hello.Mode())
+	assert.Equal(t, int64(11), hello.Size())
+	assert.True(t, udfTestTime.Equal(hello.ModTime()))
+	uid, gid := hello.Owner()
+	assert.Equal(t, [2]uint32{1000, 100}, [2]uint32{uid, gid})
+	assert.Equal(t, "hello world", readUDFFile(t, hello))
+
+	chained := readUDFFile(t, children[1])
+	assert.Equal(t, string(bytes.Repeat([]byte("a"), int(sectorSize)))+string(bytes.Repeat([]byte("b"), int(sectorSize)))+"end", chained)
+
+	assert.True(t, children[2].IsHidden())
+	assert.Equal(t, "secret", readUDFFile(t, children[2]))
+
+	assert.Equal(t, fs.ModeSymlink|0755, children[3].Mode())
+	target, err := children[3].SymlinkTarget()
+	require.NoError(t, err)
+	assert.Equal(t, "/usr/bin", target)
+
+	sparse, err := v.LookupPath("/dir/sparse.bin")
+	require.NoError(t, err)
+	assert.Equal(t, fs.ModeSetuid|0755, sparse.Mode())
+	assert.True(t, udfTestTime.Equal(sparse.ModTime()), "extended file entries record the times elsewhere")
+	assert.Equal(t, string(make([]byte, sectorSize))+"tail", readUDFFile(t, sparse))
+
+	unicode, err := v.LookupPath("dir/ünï")
+	require.NoError(t, err)
+	assert.Equal(t, "", readUDFFile(t, unicode))
+
+	_, err = v.LookupPath("/dir/missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = v.LookupPath("/hello.txt/below")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// a damaged file entry is reported
+	image[(udfTestPartition+3)*int(sectorSize)+4]++
+	_, err = v.LookupPath("/hello.txt")
+	assert.ErrorIs(t, err, errUDFCorrupted)
+}
+
+func TestImageUDFBridge(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(bytes.NewReader([]byte("stub")), "README.TXT"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "bridge"))
+
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	v, err := img.UDF()
+	require.NoError(t, err)
+	assert.Nil(t, v, "the plain image has no UDF volume")
+
+	// the volume recognition sequence follows the terminator of the ISO 9660 descriptors,
+	// overwriting the directories of the stub, which a real bridge image would have placed elsewhere
+	image := make([]byte, udfTestSectors*sectorSize)
+	require.Less(t, buf.Len(), udfAnchorSector*int(sectorSize))
+	copy(image, buf.Bytes())
+	writeUDFVolume(image, 16+len(img.volumeDescriptors))
+
+	img, err = OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+	metadata, err := img.VolumeMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, "bridge", metadata.VolumeIdentifier)
+	v, err = img.UDF()
+	require.NoError(t, err)
+	require.NotNil(t, v)
+	f, err := v.LookupPath("/hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", readUDFFile(t, f))
+}
@@ -1,74 +1,556 @@
 package util
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"sync"
+	"time"
 
 	"github.com/kdomanski/iso9660"
 )
 
+// ErrTargetExists is returned with OverwriteError for a file which already exists in the destination
+var ErrTargetExists = errors.New("the target already exists")
+
+// OverwritePolicy selects what happens to the files which already exist in the destination, see ExtractOptions.Overwrite.
+// Existing directories are always merged with those of the image.
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways replaces the existing files
+	OverwriteAlways OverwritePolicy = iota
+	// OverwriteSkip leaves the existing files alone, so that an interrupted extraction can be resumed
+	OverwriteSkip
+	// OverwriteError fails with ErrTargetExists at the first file which already exists
+	OverwriteError
+	// OverwriteIfNewer replaces the existing files which were modified before the time recorded in the image,
+	// that of the Rock Ridge TF entry or the recording time of the directory record, and leaves the others alone
+	OverwriteIfNewer
+)
+
+// ExtractOptions controls ExtractImageToDirectoryWithOptions
+type ExtractOptions struct {
+	// Overwrite selects what happens to the files which already exist in the destination.
+	// The default is OverwriteAlways.
+	Overwrite OverwritePolicy
+	// DryRun only reports the entries which would be written, in ExtractReport.Planned, and leaves the destination as it is
+	DryRun bool
+	// SkipTruncated skips the files whose data lies beyond the end of a truncated image,
+	// recording them in the report, instead of failing on the first one
+	SkipTruncated bool
+	// PreserveHardLinks recreates the hard links between the files of the image, see iso9660.File.HardLinkID,
+	// instead of extracting a copy of the data for each link. Files are copied where linking fails.
+	PreserveHardLinks bool
+	// PreserveOwnership sets the owners recorded in Rock Ridge PX entries when running as root,
+	// otherwise the entries are owned by the current user
+	PreserveOwnership bool
+	// PreservePermissions sets the permissions recorded in PX entries, including the setuid, setgid and sticky bits,
+	// instead of 0755 for directories and the default permissions for files
+	PreservePermissions bool
+	// PreserveTimes sets the modification and access times recorded in Rock Ridge TF entries,
+	// or the recording times of the directory records, instead of the time of the extraction
+	PreserveTimes bool
+	// Symlinks creates the symbolic links recorded in Rock Ridge SL entries, which are extracted as empty files otherwise
+	Symlinks bool
+	// SpecialFiles creates the device nodes, with the numbers of their PN entries, and the FIFOs recorded with Rock Ridge,
+	// which are extracted as empty files otherwise. It is only supported on Linux and requires root for device nodes.
+	SpecialFiles bool
+	// Sparse seeks over the blocks of the files which only hold zeroes instead of writing them, so that they become holes
+	// on file systems which support them. This includes the holes of Rock Ridge sparse files, see iso9660.File.IsSparse.
+	Sparse bool
+	// Workers is the number of files extracted concurrently, which speeds up extracting many small files
+	// from fast media. Zero or one extracts the files one after the other.
+	Workers int
+	// Progress, if not nil, is called before every file and after every chunk of data extracted.
+	// With several Workers, the calls don't overlap, but come from the goroutines of the workers.
+	Progress func(ExtractProgress)
+}
+
+// ExtractProgress tells how far the extraction has got
+type ExtractProgress struct {
+	// Path is the path within the image of the file being extracted
+	Path string
+	// Extracted is the number of bytes of file data extracted so far, out of Total
+	Extracted, Total int64
+	// FilesExtracted is the number of files extracted so far, out of TotalFiles
+	FilesExtracted, TotalFiles int
+}
+
+// ExtractReport lists the files which weren't extracted
+type ExtractReport struct {
+	// Truncated holds the files whose data lies beyond the end of the image, with their paths within the image
+	Truncated []*iso9660.ExtentOutOfRangeError
+	// Skipped holds the paths within the image of the files left alone because they already exist, see ExtractOptions.Overwrite
+	Skipped []string
+	// Planned holds the paths within the image of the entries a dry run would write, see ExtractOptions.DryRun.
+	// The directories which already exist are left out.
+	Planned []string
+}
+
 func ExtractImageToDirectory(image io.ReaderAt, destination string) error {
+	_, err := ExtractImageToDirectoryWithOptions(image, destination, ExtractOptions{})
+	return err
+}
+
+// ExtractImageToDirectoryWithOptions extracts the image like ExtractImageToDirectory and reports the files it skipped
+func ExtractImageToDirectoryWithOptions(image io.ReaderAt, destination string, opts ExtractOptions) (*ExtractReport, error) {
+	return ExtractImageContext(context.Background(), image, destination, opts)
+}
+
+// ExtractImageContext extracts the image like ExtractImageToDirectoryWithOptions, but stops with the error
+// of the context when it is done. The context is checked before every file and every chunk of data copied.
+// The entries extracted until then are left in place, the files in progress may be incomplete.
+func ExtractImageContext(ctx context.Context, image io.ReaderAt, destination string, opts ExtractOptions) (*ExtractReport, error) {
 	img, err := iso9660.OpenImage(image)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	root, err := img.RootDir()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	e := &extractor{ctx: ctx, cancel: cancel, options: opts, report: &ExtractReport{}, links: make(map[uint32]string)}
+	if opts.Progress != nil {
+		// the totals take a walk over the directories first
+		if err := e.count(root); err != nil {
+			return e.report, err
+		}
+	}
+
+	if opts.Workers > 1 {
+		e.jobs = make(chan extractJob)
+		for n := 0; n < opts.Workers; n++ {
+			e.workers.Add(1)
+			go e.work()
+		}
+	}
+	err = e.extract(root, "/", destination)
+	if e.jobs != nil {
+		close(e.jobs)
+		e.workers.Wait()
+	}
+	if err == nil {
+		err = e.err
+	}
+	if err != nil {
+		return e.report, err
+	}
+
+	// the permissions may forbid adding the children, and adding them changes the times,
+	// so the directories get their metadata last, the deepest first
+	for n := len(e.directories) - 1; n >= 0; n-- {
+		if err := e.restoreMetadata(e.directories[n].file, e.directories[n].targetPath); err != nil {
+			return e.report, err
+		}
+	}
+	return e.report, nil
+}
+
+type extractor struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	options ExtractOptions
+
+	// jobs passes the files to the workers if there are any, see ExtractOptions.Workers
+	jobs    chan extractJob
+	workers sync.WaitGroup
+
+	// mu guards the rest, which the workers share
+	mu       sync.Mutex
+	report   *ExtractReport
+	progress ExtractProgress
+	// links maps the hard link IDs of the files extracted to their paths
+	links map[uint32]string
+	// directories are the directories created, whose metadata is restored at the end
+	directories []extractJob
+	// err is the first error of the workers
+	err error
+}
+
+// extractJob is an entry to extract to the target path
+type extractJob struct {
+	file                *iso9660.File
+	isoPath, targetPath string
+}
+
+// work extracts the files passed to the worker until there are no more
+func (e *extractor) work() {
+	defer e.workers.Done()
+	for job := range e.jobs {
+		if err := e.extractFile(job.file, job.isoPath, job.targetPath); err != nil {
+			e.mu.Lock()
+			if e.err == nil {
+				e.err = err
+				// the other workers stop extracting as well
+				e.cancel()
+			}
+			e.mu.Unlock()
+		}
+	}
+}
+
+// count adds the files within the directory to the totals of the progress
+func (e *extractor) count(dir *iso9660.File) error {
+	children, err := dir.GetChildren()
 	if err != nil {
 		return err
 	}
+	for _, c := range children {
+		if c.IsDir() {
+			if err = e.count(c); err != nil {
+				return err
+			}
+			continue
+		}
+		e.progress.Total += c.Size()
+		e.progress.TotalFiles++
+	}
+	return nil
+}
 
-	return extract(root, destination)
+// update changes the progress and passes it to the callback of the options, if there is one
+func (e *extractor) update(fn func(p *ExtractProgress)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fn(&e.progress)
+	if e.options.Progress != nil {
+		e.options.Progress(e.progress)
+	}
+}
 
+// Write counts the data extracted and stops the copy once the context is done
+func (e *extractor) Write(p []byte) (int, error) {
+	if err := e.ctx.Err(); err != nil {
+		return 0, err
+	}
+	e.update(func(progress *ExtractProgress) { progress.Extracted += int64(len(p)) })
+	return len(p), nil
 }
 
-func extract(f *iso9660.File, targetPath string) error {
+func (e *extractor) extract(f *iso9660.File, isoPath, targetPath string) error {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
 	// if f.Name() != string([]byte{0}) {
 	// 	targetPath = path.Join(targetPath, f.Name())
 	// }
 
-	if f.IsDir() {
-		existing, err := os.Open(targetPath)
-		if err == nil {
-			defer existing.Close()
-			s, err := existing.Stat()
-			if err != nil {
-				return err
+	if !f.IsDir() {
+		if e.jobs != nil {
+			select {
+			case e.jobs <- extractJob{file: f, isoPath: isoPath, targetPath: targetPath}:
+				return nil
+			case <-e.ctx.Done():
+				return e.ctx.Err()
 			}
+		}
+		return e.extractFile(f, isoPath, targetPath)
+	}
+
+	existing, err := os.Open(targetPath)
+	if err == nil {
+		defer existing.Close()
+		s, err := existing.Stat()
+		if err != nil {
+			return err
+		}
+
+		if !s.IsDir() {
+			return fmt.Errorf("%s already exists and is a file", targetPath)
+		}
+	} else if os.IsNotExist(err) {
+		if e.options.DryRun {
+			e.plan(isoPath)
+		} else if err = os.Mkdir(targetPath, 0755); err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+	if !e.options.DryRun {
+		e.mu.Lock()
+		e.directories = append(e.directories, extractJob{file: f, isoPath: isoPath, targetPath: targetPath})
+		e.mu.Unlock()
+	}
 
-			if !s.IsDir() {
-				return fmt.Errorf("%s already exists and is a file", targetPath)
+	children, err := f.GetChildren()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range children {
+		if err = e.extract(c, path.Join(isoPath, c.Name()), path.Join(targetPath, c.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile extracts an entry which isn't a directory
+func (e *extractor) extractFile(f *iso9660.File, isoPath, targetPath string) error {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	e.update(func(progress *ExtractProgress) { progress.Path = isoPath })
+
+	if write, err := e.prepareTarget(f, isoPath, targetPath); err != nil || !write {
+		return err
+	}
+	if e.options.DryRun {
+		// the data is opened all the same, to report the files of a truncated image
+		if _, err := e.openData(f, isoPath); err != nil && !errors.Is(err, errSkipped) {
+			return err
+		}
+		e.plan(isoPath)
+		return nil
+	}
+
+	mode := f.Mode()
+	switch {
+	case mode&os.ModeSymlink != 0 && e.options.Symlinks:
+		if err := os.Symlink(f.SymlinkTarget(), targetPath); err != nil {
+			return err
+		}
+		return e.extracted(f, targetPath)
+	case mode&(os.ModeDevice|os.ModeNamedPipe) != 0 && e.options.SpecialFiles:
+		if err := mknod(f, targetPath); err != nil {
+			return err
+		}
+		return e.extracted(f, targetPath)
+	}
+
+	linkID, linked := f.HardLinkID()
+	if linked && e.options.PreserveHardLinks {
+		e.mu.Lock()
+		existing, ok := e.links[linkID]
+		e.mu.Unlock()
+		if ok {
+			if err := os.Link(existing, targetPath); err == nil {
+				e.update(func(progress *ExtractProgress) {
+					progress.Extracted += f.Size()
+					progress.FilesExtracted++
+				})
+				return nil
 			}
-		} else if os.IsNotExist(err) {
-			if err = os.Mkdir(targetPath, 0755); err != nil {
+		}
+	}
+
+	data, err := e.openData(f, isoPath)
+	if errors.Is(err, errSkipped) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	newFile, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+	defer newFile.Close()
+	if e.options.Sparse {
+		err = copySparse(newFile, data, e)
+	} else {
+		_, err = io.Copy(io.MultiWriter(e, newFile), data)
+	}
+	if err != nil {
+		return err
+	}
+	if linked {
+		e.mu.Lock()
+		if _, ok := e.links[linkID]; !ok {
+			e.links[linkID] = targetPath
+		}
+		e.mu.Unlock()
+	}
+	return e.extracted(f, targetPath)
+}
+
+// errSkipped is returned by openData for the files which are skipped
+var errSkipped = errors.New("skipped")
+
+// openData opens the data of a file, or returns errSkipped if it lies beyond the end of the image and SkipTruncated is set
+func (e *extractor) openData(f *iso9660.File, isoPath string) (io.Reader, error) {
+	data, err := f.OpenReader()
+	var outOfRange *iso9660.ExtentOutOfRangeError
+	if errors.As(err, &outOfRange) {
+		outOfRange.Path = isoPath
+		if e.options.SkipTruncated {
+			e.mu.Lock()
+			e.report.Truncated = append(e.report.Truncated, outOfRange)
+			e.mu.Unlock()
+			return nil, errSkipped
+		}
+	}
+	return data, err
+}
+
+// prepareTarget applies the overwrite policy to the entry at the target path, if there is one.
+// It returns false if the file isn't to be written.
+func (e *extractor) prepareTarget(f *iso9660.File, isoPath, targetPath string) (bool, error) {
+	info, err := os.Lstat(targetPath)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() {
+		return false, fmt.Errorf("%s already exists and is a directory", targetPath)
+	}
+
+	switch e.options.Overwrite {
+	case OverwriteSkip:
+		e.skip(isoPath)
+		return false, nil
+	case OverwriteError:
+		return false, fmt.Errorf("%s: %w", targetPath, ErrTargetExists)
+	case OverwriteIfNewer:
+		if !modificationTime(f).After(info.ModTime()) {
+			e.skip(isoPath)
+			return false, nil
+		}
+	}
+	if e.options.DryRun {
+		return true, nil
+	}
+	// the entry is replaced rather than written through, in case it is a symbolic link or a hard link
+	return true, os.Remove(targetPath)
+}
+
+// skip records a file left alone because it already exists
+func (e *extractor) skip(isoPath string) {
+	e.mu.Lock()
+	e.report.Skipped = append(e.report.Skipped, isoPath)
+	e.mu.Unlock()
+}
+
+// plan records an entry a dry run would write
+func (e *extractor) plan(isoPath string) {
+	e.mu.Lock()
+	e.report.Planned = append(e.report.Planned, isoPath)
+	e.mu.Unlock()
+}
+
+// modificationTime returns the modification time of the Rock Ridge TF entry, or the recording time of the directory record
+func modificationTime(f *iso9660.File) time.Time {
+	if times, err := f.SystemUseEntries().GetTimestamps(); err == nil && !times.Modification.IsZero() {
+		return times.Modification
+	}
+	return f.ModTime()
+}
+
+const (
+	// sparseBlockSize is the size of the blocks of zeroes skipped by copySparse, the usual block size of file systems
+	sparseBlockSize = 4096
+	// sparseChunkSize is the amount of data read at once by copySparse
+	sparseChunkSize = 256 * sparseBlockSize
+)
+
+// copySparse copies the data to a new file, seeking over the blocks of zeroes, and passes it to the counter as well
+func copySparse(dst *os.File, src io.Reader, counter io.Writer) error {
+	chunk := make([]byte, sparseChunkSize)
+	var offset, skipped int64
+	for {
+		n, err := io.ReadFull(src, chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if _, err := counter.Write(chunk[:n]); err != nil {
+			return err
+		}
+
+		for data := chunk[:n]; len(data) > 0; {
+			block := data
+			if len(block) > sparseBlockSize {
+				block = block[:sparseBlockSize]
+			}
+			data = data[len(block):]
+			offset += int64(len(block))
+
+			if len(block) == sparseBlockSize && isZero(block) {
+				skipped += int64(len(block))
+				continue
+			}
+			if skipped > 0 {
+				if _, err := dst.Seek(skipped, io.SeekCurrent); err != nil {
+					return err
+				}
+				skipped = 0
+			}
+			if _, err := dst.Write(block); err != nil {
 				return err
 			}
-		} else {
-			return err
 		}
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	if skipped > 0 {
+		// the file is extended over the trailing holes
+		return dst.Truncate(offset)
+	}
+	return nil
+}
 
-		children, err := f.GetChildren()
-		if err != nil {
-			return err
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
 		}
+	}
+	return true
+}
+
+// extracted restores the metadata of a file once it has been created and counts it
+func (e *extractor) extracted(f *iso9660.File, targetPath string) error {
+	if err := e.restoreMetadata(f, targetPath); err != nil {
+		return err
+	}
+	e.update(func(progress *ExtractProgress) { progress.FilesExtracted++ })
+	return nil
+}
 
-		for _, c := range children {
-			if err = extract(c, path.Join(targetPath, c.Name())); err != nil {
+// restoreMetadata sets the owner, permissions and times of an extracted entry as the options select
+func (e *extractor) restoreMetadata(f *iso9660.File, targetPath string) error {
+	isSymlink := f.Mode()&os.ModeSymlink != 0 && e.options.Symlinks
+	// changing the owner clears the setuid and setgid bits, so it comes first
+	if e.options.PreserveOwnership && os.Geteuid() == 0 {
+		if uid, gid, ok := f.Owner(); ok {
+			if err := os.Lchown(targetPath, int(uid), int(gid)); err != nil {
 				return err
 			}
 		}
-	} else { // it's a file
-		newFile, err := os.Create(targetPath)
-		if err != nil {
+	}
+	// the permissions and times of symbolic links are those of their targets
+	if isSymlink {
+		return nil
+	}
+	if e.options.PreservePermissions && f.Mode().Perm() != 0 {
+		if err := os.Chmod(targetPath, f.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
 			return err
 		}
-		defer newFile.Close()
-		if _, err = io.Copy(newFile, f.Reader()); err != nil {
+	}
+	if e.options.PreserveTimes {
+		modified := modificationTime(f)
+		accessed := modified
+		if times, err := f.SystemUseEntries().GetTimestamps(); err == nil && !times.Access.IsZero() {
+			accessed = times.Access
+		}
+		if err := os.Chtimes(targetPath, accessed, modified); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
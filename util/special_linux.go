@@ -0,0 +1,28 @@
+package util
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/kdomanski/iso9660"
+)
+
+// mknod creates the device node or FIFO recorded by the entry
+func mknod(f *iso9660.File, targetPath string) error {
+	mode := uint32(f.Mode().Perm())
+	switch {
+	case f.Mode()&os.ModeNamedPipe != 0:
+		mode |= syscall.S_IFIFO
+	case f.Mode()&os.ModeCharDevice != 0:
+		mode |= syscall.S_IFCHR
+	default:
+		mode |= syscall.S_IFBLK
+	}
+
+	var dev uint64
+	if major, minor, ok := f.DeviceNumber(); ok {
+		// the encoding of dev_t used by glibc, see gnu_dev_makedev
+		dev = uint64(major&0xfff)<<8 | uint64(major&^0xfff)<<32 | uint64(minor&0xff) | uint64(minor&^0xff)<<12
+	}
+	return syscall.Mknod(targetPath, mode, int(dev))
+}
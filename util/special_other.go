@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package util
+
+import (
+	"fmt"
+
+	"github.com/kdomanski/iso9660"
+)
+
+// mknod creates the device node or FIFO recorded by the entry, which is only supported on Linux
+func mknod(f *iso9660.File, targetPath string) error {
+	return fmt.Errorf("creating %s: special files are only supported on Linux", targetPath)
+}
@@ -0,0 +1,210 @@
+package iso9660
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// maxPathLength is the longest path of a file or directory allowed by ECMA-119 6.8.2.1
+	maxPathLength = 255
+)
+
+// Severity tells whether a Finding prevents writing the image, or whether a VerifyFinding violates the standards
+type Severity int
+
+const (
+	// SeverityWarning marks something which is written, but may be unexpected or unreadable by some systems
+	SeverityWarning Severity = iota
+	// SeverityError marks something which WriteTo cannot write, or which an image must not contain
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Finding is a single problem found by Validate
+type Finding struct {
+	Severity Severity
+	// Path is the staged path of the affected entry, or empty if the finding concerns the whole volume
+	Path    string
+	Message string
+
+	// err is the sentinel error the finding corresponds to, if there is one
+	err error
+}
+
+func (f Finding) String() string {
+	if f.Path == "" {
+		return fmt.Sprintf("%s: %s", f.Severity, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Path, f.Message)
+}
+
+// Report lists everything questionable about the staged contents, in the order of the directory hierarchy
+type Report struct {
+	Findings []Finding
+}
+
+// Errors returns the findings which prevent the image from being written
+func (r Report) Errors() []Finding {
+	var errs []Finding
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			errs = append(errs, f)
+		}
+	}
+	return errs
+}
+
+// ValidationError is returned by WriteTo if the staged contents cannot be written
+type ValidationError struct {
+	Findings []Finding
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Findings))
+	for i, f := range e.Findings {
+		if f.Path == "" {
+			messages[i] = f.Message
+		} else {
+			messages[i] = f.Path + ": " + f.Message
+		}
+	}
+	return "invalid image: " + strings.Join(messages, "; ")
+}
+
+// Is reports whether any of the findings corresponds to the target, such as ErrFileTooLarge
+func (e *ValidationError) Is(target error) bool {
+	for _, f := range e.Findings {
+		if f.err != nil && f.err == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the staged contents without writing anything. The findings of error severity are
+// the ones which make WriteTo fail, with the same messages. The returned error is set if the layout
+// of the image cannot be determined at all, in which case WriteTo fails the same way.
+func (iw *ImageWriter) Validate() (Report, error) {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+
+	wc := iw.newWriteContext(iw.now())
+	if err := wc.buildTree(iw.rootEntry()); err != nil {
+		return Report{}, err
+	}
+
+	findings := wc.validate(iw.volume, SeverityWarning)
+	sizes, err := wc.validateDirectorySizes()
+	if err != nil {
+		return Report{}, err
+	}
+	return Report{Findings: append(findings, sizes...)}, nil
+}
+
+// validate checks the tree built by buildTree and the volume metadata and returns the findings of at least
+// the given severity. WriteTo only asks for the errors, as a huge tree can have a warning for every file.
+func (wc *writeContext) validate(volume VolumeMetadata, minimum Severity) []Finding {
+	var findings []Finding
+	add := func(f Finding) {
+		if f.Severity >= minimum {
+			findings = append(findings, f)
+		}
+	}
+	report := func(severity Severity, e *stagedEntry, err error, format string, args ...interface{}) {
+		if severity >= minimum {
+			findings = append(findings, Finding{Severity: severity, Path: e.path(), Message: fmt.Sprintf(format, args...), err: err})
+		}
+	}
+
+	patch := VolumeMetadataPatch{
+		SystemIdentifier:            &volume.SystemIdentifier,
+		VolumeIdentifier:            &volume.VolumeIdentifier,
+		VolumeSetIdentifier:         &volume.VolumeSetIdentifier,
+		PublisherIdentifier:         &volume.PublisherIdentifier,
+		DataPreparerIdentifier:      &volume.DataPreparerIdentifier,
+		ApplicationIdentifier:       &volume.ApplicationIdentifier,
+		CopyrightFileIdentifier:     &volume.CopyrightFileIdentifier,
+		AbstractFileIdentifier:      &volume.AbstractFileIdentifier,
+		BibliographicFileIdentifier: &volume.BibliographicFileIdentifier,
+	}
+	for _, field := range patch.fields() {
+		if length := field.end - field.start; len(*field.value) > length {
+			add(Finding{
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("the %s %q is truncated to %d characters", field.name, *field.value, length),
+			})
+		}
+	}
+
+	if len(wc.directories) > maxPathTableDirectories {
+		add(Finding{
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("the image has %d directories, more than the %d a path table can number", len(wc.directories), maxPathTableDirectories),
+		})
+	}
+
+	for _, dir := range wc.directories {
+		dirPathLength := len(dir.isoPath())
+		for _, n := range dir.children {
+			if n.generated || n.childLink != nil {
+				// relocated directories are checked in RR_MOVED, where their records are
+				continue
+			}
+			e := n.entry
+
+			if identifier := strings.TrimSuffix(strings.TrimSuffix(n.identifier, ";1"), "."); identifier != e.name {
+				report(SeverityWarning, e, nil, "the name is recorded as %q in the primary directory hierarchy", n.identifier)
+			}
+			if length := dirPathLength + 1 + len(n.identifier); length > maxPathLength && dirPathLength <= maxPathLength {
+				report(SeverityWarning, e, nil, "the path is %d bytes long in the primary directory hierarchy, more than the %d bytes ECMA-119 allows", length, maxPathLength)
+			}
+			if e.isDir() && n.depth == maxDirectoryDepth+1 {
+				report(SeverityWarning, e, nil, "the directory is nested deeper than the %d levels ECMA-119 allows, see SetDeepDirectoryPolicy", maxDirectoryDepth)
+			}
+			if !e.isDir() && e.size() > int64(math.MaxUint32) && wc.interchangeLevel != 3 {
+				report(SeverityError, e, ErrFileTooLarge, "%s", ErrFileTooLarge)
+			}
+		}
+	}
+
+	return findings
+}
+
+// validateDirectorySizes checks that the records of every directory fit into a single extent.
+// WriteTo does the same check when it allocates the directories.
+func (wc *writeContext) validateDirectorySizes() ([]Finding, error) {
+	var findings []Finding
+	for _, dir := range wc.directories {
+		var extent directoryExtent
+		if _, err := wc.directoryRecords(dir, func(record []byte) error {
+			extent.place(uint32(len(record)))
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("processing %s: %w", dir.entry.path(), err)
+		}
+		if f := directorySizeFinding(dir, extent.sectorCount()); f != nil {
+			findings = append(findings, *f)
+		}
+	}
+	return findings, nil
+}
+
+// directorySizeFinding returns an error finding if the directory extent of the given size cannot be recorded
+func directorySizeFinding(dir *layoutNode, sectors uint32) *Finding {
+	if uint64(sectors)*uint64(sectorSize) <= math.MaxUint32 {
+		return nil
+	}
+	return &Finding{
+		Severity: SeverityError,
+		Path:     dir.entry.path(),
+		Message:  fmt.Sprintf("the directory records take %d sectors, more than a single extent can hold", sectors),
+	}
+}
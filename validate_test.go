@@ -0,0 +1,95 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hugeSource pretends to be a file too large for a single extent
+type hugeSource struct{}
+
+func (hugeSource) Open() (io.ReadCloser, error) {
+	return nil, errors.New("hugeSource cannot be read")
+}
+
+func (hugeSource) Size() int64 {
+	return math.MaxUint32 + 1
+}
+
+func TestWriterValidate(t *testing.T) {
+	iw, err := NewWriter()
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+
+	longName := strings.Repeat("L", 30)
+	longDir := strings.Repeat("/"+longName, 7)
+	volume := iw.volume
+	volume.VolumeIdentifier = strings.Repeat("V", 40)
+	iw.SetVolumeMetadata(volume)
+
+	require.NoError(t, iw.AddFile(strings.NewReader("plain"), "PLAIN.TXT"))
+	require.NoError(t, iw.AddFile(strings.NewReader("readme"), "README.md"))
+	require.NoError(t, iw.AddFile(strings.NewReader("long"), longDir+"/"+longName+"/"+longName))
+	require.NoError(t, iw.AddDirectory("A/B/C/D/E/F/G/H/I"))
+
+	report, err := iw.Validate()
+	require.NoError(t, err)
+	assert.Empty(t, report.Errors())
+
+	var findings []string
+	for _, f := range report.Findings {
+		findings = append(findings, f.String())
+	}
+	assert.Equal(t, []string{
+		`warning: the volume identifier "` + volume.VolumeIdentifier + `" is truncated to 32 characters`,
+		`warning: /README.md: the name is recorded as "README.MD;1" in the primary directory hierarchy`,
+		"warning: /A/B/C/D/E/F/G/H: the directory is nested deeper than the 8 levels ECMA-119 allows, see SetDeepDirectoryPolicy",
+		"warning: " + longDir + "/" + longName + ": the directory is nested deeper than the 8 levels ECMA-119 allows, see SetDeepDirectoryPolicy",
+		"warning: " + longDir + "/" + longName + "/" + longName + `: the name is recorded as "` + longName[:27] + `.;1" in the primary directory hierarchy`,
+		"warning: " + longDir + "/" + longName + "/" + longName + ": the path is 279 bytes long in the primary directory hierarchy, more than the 255 bytes ECMA-119 allows",
+	}, findings)
+
+	// warnings don't prevent writing
+	require.NoError(t, iw.WriteTo(io.Discard, ""))
+}
+
+func TestWriterValidateErrors(t *testing.T) {
+	iw, err := NewWriter()
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+
+	require.NoError(t, iw.AddFile(strings.NewReader("small"), "SMALL.TXT"))
+	entry := iw.newFileEntry(hugeSource{})
+	entry.origin = "the test"
+	require.NoError(t, iw.stage("HUGE.BIN", entry))
+
+	report, err := iw.Validate()
+	require.NoError(t, err)
+	require.Len(t, report.Errors(), 1)
+	assert.Equal(t, Finding{
+		Severity: SeverityError,
+		Path:     "/HUGE.BIN",
+		Message:  ErrFileTooLarge.Error(),
+		err:      ErrFileTooLarge,
+	}, report.Errors()[0])
+
+	// WriteTo fails with the same message, before writing anything
+	var buf bytes.Buffer
+	err = iw.WriteTo(&buf, "")
+	var invalid *ValidationError
+	require.True(t, errors.As(err, &invalid))
+	assert.Equal(t, report.Errors(), invalid.Findings)
+	assert.EqualError(t, err, "invalid image: /HUGE.BIN: "+ErrFileTooLarge.Error())
+	assert.True(t, errors.Is(err, ErrFileTooLarge))
+	assert.Zero(t, buf.Len())
+}
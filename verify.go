@@ -0,0 +1,643 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	elToritoSystemIdentifier = "EL TORITO SPECIFICATION"
+	// elToritoEntrySize is the size of the entries of the boot catalog
+	elToritoEntrySize = 32
+)
+
+// VerifyOption configures Verify
+type VerifyOption func(*verifyOptions)
+
+type verifyOptions struct {
+	maxFindings      int
+	fileData         bool
+	interchangeLevel int
+}
+
+// WithMaxFindings makes Verify stop after the given number of findings
+func WithMaxFindings(n int) VerifyOption {
+	return func(o *verifyOptions) {
+		o.maxFindings = n
+	}
+}
+
+// WithFileData makes Verify read the data of every file, decompressing zisofs-compressed files,
+// and report the files which cannot be read
+func WithFileData() VerifyOption {
+	return func(o *verifyOptions) {
+		o.fileData = true
+	}
+}
+
+// WithInterchangeLevel makes Verify check the identifiers of the primary hierarchy against the character set
+// and the lengths of an interchange level, 1 to 3, and that only level 3 records files with several extents
+func WithInterchangeLevel(level int) VerifyOption {
+	return func(o *verifyOptions) {
+		o.interchangeLevel = level
+	}
+}
+
+// VerifyFinding is a single problem found by Verify
+type VerifyFinding struct {
+	Severity Severity
+	// Path is the path of the affected directory record, made of the identifiers as they are recorded,
+	// or empty if the finding concerns the volume
+	Path string
+	// LBA is the sector where the problem lies, or 0 if it isn't tied to a sector
+	LBA     uint32
+	Message string
+}
+
+func (f VerifyFinding) String() string {
+	location := f.Path
+	if f.LBA != 0 {
+		if location != "" {
+			location += " "
+		}
+		location += fmt.Sprintf("(sector %d)", f.LBA)
+	}
+	if location == "" {
+		return fmt.Sprintf("%s: %s", f.Severity, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", f.Severity, location, f.Message)
+}
+
+// VerifyReport lists the problems Verify found in an image
+type VerifyReport struct {
+	Findings []VerifyFinding
+}
+
+// Errors returns the findings which violate ECMA-119 or its extensions
+func (r VerifyReport) Errors() []VerifyFinding {
+	var errs []VerifyFinding
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			errs = append(errs, f)
+		}
+	}
+	return errs
+}
+
+// verifiedExtent is an extent of the volume, checked for overlaps with the others.
+// Its location and size are counted in logical blocks, which are sectors in almost all images.
+type verifiedExtent struct {
+	location uint32
+	sectors  uint32
+	path     string
+	// file is set for the extents of files, which hard links and deduplication let share an extent
+	file bool
+}
+
+type verifier struct {
+	image   *Image
+	options verifyOptions
+	report  VerifyReport
+
+	volumeSpaceSize uint32
+	blockSize       int64
+	// volume limits the reads of System Use entries to the volume space
+	volume  io.ReaderAt
+	extents []verifiedExtent
+	susp    *SUSPMetadata
+}
+
+func (v *verifier) add(severity Severity, path string, lba uint32, format string, args ...interface{}) {
+	if v.full() {
+		return
+	}
+	v.report.Findings = append(v.report.Findings, VerifyFinding{Severity: severity, Path: path, LBA: lba, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *verifier) full() bool {
+	return v.options.maxFindings > 0 && len(v.report.Findings) >= v.options.maxFindings
+}
+
+// Verify audits the structure of the primary volume: the volume descriptor set, the path tables,
+// the directory hierarchy with its "." and ".." records and their order, the SUSP and Rock Ridge entries,
+// the location of every extent within the volume space and the El Torito boot catalog.
+// Extents may only be shared by files, as hard links and deduplicated files do.
+// The returned error is set if the image cannot be read, problems of the image are reported as findings.
+func (i *Image) Verify(opts ...VerifyOption) (VerifyReport, error) {
+	v := &verifier{image: i, blockSize: i.LogicalBlockSize()}
+	for _, o := range opts {
+		o(&v.options)
+	}
+
+	pvd, err := v.verifyVolumeDescriptors()
+	if err != nil || pvd == nil {
+		return v.report, err
+	}
+	v.volume = io.NewSectionReader(i.ra, 0, int64(v.volumeSpaceSize)*v.blockSize)
+
+	v.verifyPathTables(pvd)
+	if err := v.verifyHierarchy(pvd.RootDirectoryEntry); err != nil {
+		return v.report, err
+	}
+	v.verifyOverlaps()
+
+	return v.report, nil
+}
+
+// verifyVolumeDescriptors checks the volume descriptor set and returns the Primary Volume Descriptor,
+// which is nil if there is none
+func (v *verifier) verifyVolumeDescriptors() (*PrimaryVolumeDescriptorBody, error) {
+	var pvd *PrimaryVolumeDescriptorBody
+	var boots []uint32
+	sector := v.image.descriptorSector(0)
+	for _, vd := range v.image.volumeDescriptors {
+		switch vd.Type() {
+		case volumeTypePrimary:
+			if vd.Header.Version != 1 {
+				v.add(SeverityError, "", sector, "the Primary Volume Descriptor has version %d instead of 1", vd.Header.Version)
+			}
+		case volumeTypeBoot:
+			if vd.Boot.BootSystemIdentifier == elToritoSystemIdentifier {
+				boots = append(boots, binary.LittleEndian.Uint32(vd.Boot.BootSystemUse[0:4]))
+			}
+		case volumeTypePartition:
+			if vd.Header.Version != 1 {
+				v.add(SeverityError, "", sector, "the Volume Partition Descriptor has version %d instead of 1", vd.Header.Version)
+			}
+			if vd.Partition.Size > 0 {
+				path := fmt.Sprintf("the volume partition %q", vd.Partition.VolumePartitionIdentifier)
+				v.extents = append(v.extents, verifiedExtent{location: vd.Partition.Location, sectors: vd.Partition.Size, path: path})
+			}
+		case volumeTypeTerminator:
+			if vd.Header.Version != 1 {
+				v.add(SeverityError, "", sector, "the Volume Descriptor Set Terminator has version %d instead of 1", vd.Header.Version)
+			}
+		}
+		sector++
+	}
+	start := v.image.options.SessionStart
+	first, last := v.sectorBlocks(start, sector-start)
+	v.extents = append(v.extents, verifiedExtent{location: first, sectors: last, path: "the system area and volume descriptors"})
+	// the anomalies of the directories are found again below
+	for _, warning := range v.image.Warnings() {
+		if warning.Path == "" {
+			v.add(SeverityWarning, "", warning.LBA, "%s", warning.Reason)
+		}
+	}
+	pvd, _ = v.image.primaryVolume()
+	if missing, truncated := v.image.IsTruncated(); truncated {
+		v.add(SeverityError, "", 0, "the image is truncated, %d bytes of the volume are missing", missing)
+	}
+
+	if pvd == nil {
+		v.add(SeverityError, "", 0, "the volume descriptor set has no Primary Volume Descriptor")
+		return nil, nil
+	}
+
+	if !validLogicalBlockSize(pvd.LogicalBlockSize) {
+		v.add(SeverityError, "", 16, "the logical block size of %d bytes isn't a power of two of at least %d", pvd.LogicalBlockSize, minLogicalBlockSize)
+	} else if pvd.LogicalBlockSize > int16(sectorSize) {
+		v.add(SeverityWarning, "", 16, "the logical block size of %d bytes is larger than a sector, which ECMA-119 6.2.2 doesn't allow", pvd.LogicalBlockSize)
+	}
+	if pvd.FileStructureVersion != 1 {
+		v.add(SeverityError, "", 16, "the file structure version is %d instead of 1", pvd.FileStructureVersion)
+	}
+	if pvd.VolumeSetSize < 1 || pvd.VolumeSequenceNumber < 1 || pvd.VolumeSequenceNumber > pvd.VolumeSetSize {
+		v.add(SeverityError, "", 16, "volume %d of a volume set of %d volumes is invalid", pvd.VolumeSequenceNumber, pvd.VolumeSetSize)
+	}
+
+	if int64(pvd.VolumeSpaceSize)*v.blockSize < int64(sector)*int64(sectorSize) {
+		v.add(SeverityError, "", 16, "the volume space of %d sectors doesn't hold the volume descriptors", pvd.VolumeSpaceSize)
+		return nil, nil
+	}
+	v.volumeSpaceSize = uint32(pvd.VolumeSpaceSize)
+	buffer := make([]byte, v.blockSize)
+	if _, err := v.image.ra.ReadAt(buffer, int64(v.volumeSpaceSize-1)*v.blockSize); err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		v.add(SeverityError, "", 0, "the image ends before the last of the %d sectors of the volume space", v.volumeSpaceSize)
+	}
+
+	root := pvd.RootDirectoryEntry
+	if root.Identifier != string([]byte{0}) || root.FileFlags&dirFlagDir == 0 {
+		v.add(SeverityError, "/", uint32(root.ExtentLocation), "the root directory record of the Primary Volume Descriptor doesn't describe a directory")
+	}
+
+	for _, catalog := range boots {
+		v.verifyBootCatalog(catalog)
+	}
+
+	return pvd, nil
+}
+
+// verifyBootCatalog checks the validation entry and the initial entry of an El Torito boot catalog
+func (v *verifier) verifyBootCatalog(location uint32) {
+	first, blocks := v.sectorBlocks(location, 1)
+	if !v.inVolume(first, blocks) {
+		v.add(SeverityError, "", location, "the boot catalog lies outside of the volume space")
+		return
+	}
+
+	catalog := make([]byte, sectorSize)
+	if _, err := v.image.ra.ReadAt(catalog, int64(location)*int64(sectorSize)); err != nil {
+		v.add(SeverityError, "", location, "reading the boot catalog: %v", err)
+		return
+	}
+
+	validation := catalog[:elToritoEntrySize]
+	if validation[0] != 1 || validation[30] != 0x55 || validation[31] != 0xAA {
+		v.add(SeverityError, "", location, "the boot catalog doesn't start with a validation entry")
+		return
+	}
+	var sum uint16
+	for n := 0; n < elToritoEntrySize; n += 2 {
+		sum += binary.LittleEndian.Uint16(validation[n:])
+	}
+	if sum != 0 {
+		v.add(SeverityError, "", location, "the checksum of the boot catalog's validation entry is invalid")
+	}
+
+	initial := catalog[elToritoEntrySize : 2*elToritoEntrySize]
+	if initial[0] != 0x88 && initial[0] != 0 {
+		v.add(SeverityError, "", location, "the initial entry of the boot catalog has an invalid boot indicator 0x%02X", initial[0])
+	}
+	if image := binary.LittleEndian.Uint32(initial[8:12]); initial[0] == 0x88 && !v.inVolume(v.sectorBlocks(image, 1)) {
+		v.add(SeverityError, "", location, "the boot image at sector %d lies outside of the volume space", image)
+	}
+	v.extents = append(v.extents, verifiedExtent{location: first, sectors: blocks, path: "the boot catalog", file: true})
+}
+
+// verifyPathTables checks the mandatory path tables against the directory hierarchy and the optional ones against them
+func (v *verifier) verifyPathTables(pvd *PrimaryVolumeDescriptorBody) {
+	sectors := uint32(lengthToBlocks(uint32(pvd.PathTableSize), v.blockSize))
+	tables := []struct {
+		name     string
+		location int32
+		order    binary.ByteOrder
+	}{
+		{"L path table", pvd.TypeLPathTableLoc, binary.LittleEndian},
+		{"M path table", pvd.TypeMPathTableLoc, binary.BigEndian},
+		{"optional L path table", pvd.OptTypeLPathTableLoc, binary.LittleEndian},
+		{"optional M path table", pvd.OptTypeMPathTableLoc, binary.BigEndian},
+	}
+
+	valid := true
+	for n, table := range tables {
+		if n >= 2 && table.location == 0 {
+			continue
+		}
+		if !v.inVolume(uint32(table.location), sectors) {
+			v.add(SeverityError, "", uint32(table.location), "the %s lies outside of the volume space", table.name)
+			valid = false
+			continue
+		}
+		v.extents = append(v.extents, verifiedExtent{location: uint32(table.location), sectors: sectors, path: "the " + table.name})
+	}
+	if !valid {
+		return
+	}
+
+	if err := v.image.VerifyPathTables(); err != nil {
+		v.add(SeverityError, "", uint32(pvd.TypeLPathTableLoc), "%v", err)
+		return
+	}
+
+	mandatory, err := v.image.readPathTable(pvd.TypeLPathTableLoc, pvd.PathTableSize, binary.LittleEndian)
+	if err != nil {
+		v.add(SeverityError, "", uint32(pvd.TypeLPathTableLoc), "reading the L path table: %v", err)
+		return
+	}
+	for _, table := range tables[2:] {
+		if table.location == 0 {
+			continue
+		}
+		records, err := v.image.readPathTable(table.location, pvd.PathTableSize, table.order)
+		if err != nil {
+			v.add(SeverityError, "", uint32(table.location), "reading the %s: %v", table.name, err)
+			continue
+		}
+		if len(records) != len(mandatory) {
+			v.add(SeverityError, "", uint32(table.location), "the %s has %d records, the L path table %d", table.name, len(records), len(mandatory))
+			continue
+		}
+		for n := range records {
+			if records[n] != mandatory[n] {
+				v.add(SeverityError, "", uint32(table.location), "record %d of the %s differs from the L path table", n+1, table.name)
+				break
+			}
+		}
+	}
+}
+
+// verifiedDirectory is a directory of the hierarchy waiting to be checked
+type verifiedDirectory struct {
+	record *DirectoryEntry
+	parent *DirectoryEntry
+	path   string
+}
+
+// verifyHierarchy walks the directory hierarchy breadth-first, following the records as they are,
+// so that the directories relocated by Rock Ridge are checked in RR_MOVED
+func (v *verifier) verifyHierarchy(root *DirectoryEntry) error {
+	visited := map[int32]string{root.ExtentLocation: "/"}
+	queue := []verifiedDirectory{{record: root, parent: root, path: "/"}}
+	for len(queue) > 0 && !v.full() {
+		dir := queue[0]
+		queue = queue[1:]
+
+		subdirectories, err := v.verifyDirectory(dir)
+		if err != nil {
+			return err
+		}
+		for _, sub := range subdirectories {
+			if first, ok := visited[sub.record.ExtentLocation]; ok {
+				v.add(SeverityError, sub.path, uint32(sub.record.ExtentLocation), "the directory is also recorded as %s", first)
+				continue
+			}
+			visited[sub.record.ExtentLocation] = sub.path
+			queue = append(queue, sub)
+		}
+	}
+	return nil
+}
+
+// verifyDirectory checks the records of a directory and returns its subdirectories
+func (v *verifier) verifyDirectory(dir verifiedDirectory) ([]verifiedDirectory, error) {
+	location, length := uint32(dir.record.ExtentLocation), dir.record.ExtentLength
+	blocks := uint32(lengthToBlocks(length, v.blockSize))
+	if !v.inVolume(location, blocks) {
+		v.add(SeverityError, dir.path, location, "the directory extent of %d bytes lies outside of the volume space", length)
+		return nil, nil
+	}
+	if length == 0 || length%sectorSize != 0 {
+		v.add(SeverityWarning, dir.path, location, "the directory extent of %d bytes doesn't fill whole sectors", length)
+	}
+	v.extents = append(v.extents, verifiedExtent{location: location, sectors: blocks, path: dir.path})
+
+	records, err := v.image.readDirectoryRecords(location, length)
+	if err != nil {
+		v.add(SeverityError, dir.path, location, "reading the directory records: %v", err)
+		return nil, nil
+	}
+
+	if len(records) < 2 || records[0].Identifier != string([]byte{0}) || records[1].Identifier != string([]byte{1}) {
+		v.add(SeverityError, dir.path, location, "the directory doesn't start with the \".\" and \"..\" records")
+		return nil, nil
+	}
+	if records[0].ExtentLocation != dir.record.ExtentLocation || records[0].ExtentLength != length {
+		v.add(SeverityError, dir.path, location, "the \".\" record points to sector %d instead of the directory", records[0].ExtentLocation)
+	}
+	if records[1].ExtentLocation != dir.parent.ExtentLocation || records[1].ExtentLength != dir.parent.ExtentLength {
+		v.add(SeverityError, dir.path, location, "the \"..\" record points to sector %d instead of the parent directory", records[1].ExtentLocation)
+	}
+
+	if dir.record == dir.parent {
+		v.verifySUSPIndicator(records)
+	}
+	for n, de := range records {
+		if err := v.verifySystemUse(dir, de, n == 0 && dir.record == dir.parent); err != nil {
+			return nil, err
+		}
+	}
+
+	var subdirectories []verifiedDirectory
+	for n, de := range records[2:] {
+		path := path.Join(dir.path, de.Identifier)
+		if de.Identifier == string([]byte{0}) || de.Identifier == string([]byte{1}) {
+			v.add(SeverityError, dir.path, location, "record %d of the directory is another \".\" or \"..\" record", n+3)
+			continue
+		}
+		if n > 0 {
+			previous := records[n+1]
+			c := compareIdentifiers(previous.Identifier, de.Identifier)
+			if c > 0 || c == 0 && previous.FileFlags&dirFlagMultiExtent == 0 {
+				v.add(SeverityError, path, location, "the record doesn't follow %q in the order ECMA-119 9.3 requires", previous.Identifier)
+			}
+		}
+		if level := v.options.interchangeLevel; level > 0 {
+			if problem := identifierProblem(de.Identifier, de.FileFlags&dirFlagDir != 0, level); problem != "" {
+				v.add(SeverityError, path, location, "the identifier isn't valid at interchange level %d: %s", level, problem)
+			}
+			if level < 3 && de.FileFlags&dirFlagMultiExtent != 0 {
+				v.add(SeverityError, path, location, "files with several extents require interchange level 3, not %d", level)
+			}
+		}
+
+		if de.FileFlags&dirFlagDir != 0 {
+			subdirectories = append(subdirectories, verifiedDirectory{record: de, parent: dir.record, path: path})
+			continue
+		}
+		if de.ExtentLength == 0 {
+			continue
+		}
+		sectors := uint32(lengthToBlocks(de.ExtentLength, v.blockSize)) + uint32(de.ExtendedAtributeRecordLength)
+		if !v.inVolume(uint32(de.ExtentLocation), sectors) {
+			v.add(SeverityError, path, uint32(de.ExtentLocation), "the extent of %d bytes lies outside of the volume space", de.ExtentLength)
+			continue
+		}
+		v.extents = append(v.extents, verifiedExtent{location: uint32(de.ExtentLocation), sectors: sectors, path: path, file: true})
+
+		if v.options.fileData {
+			f := &File{ra: v.image.ra, de: de, susp: v.susp.Clone(), options: v.image.options, imageSize: v.image.size, blockSize: v.blockSize}
+			if _, err := io.Copy(io.Discard, f.Reader()); err != nil {
+				v.add(SeverityError, path, uint32(de.ExtentLocation), "reading the data: %v", err)
+			}
+		}
+	}
+	return subdirectories, nil
+}
+
+// verifySUSPIndicator checks the SP entry and the extension records of the root's "." record,
+// and detects Rock Ridge from the records of the root directory like the reader does
+func (v *verifier) verifySUSPIndicator(records []*DirectoryEntry) {
+	dot := records[0]
+	entries, anomalies := parseSystemUse(dot.SystemUse, v.volume, v.blockSize)
+	for _, a := range anomalies {
+		v.add(a.severity, "/", uint32(dot.ExtentLocation), "the System Use entries of the \".\" record: %v", a.err)
+	}
+	if len(entries) == 0 || entries[0].Type() != SUEType_SharingProtocolIndicator {
+		return
+	}
+
+	sp, err := SPRecordDecode(entries[0])
+	if err != nil {
+		v.add(SeverityError, "/", uint32(dot.ExtentLocation), "invalid SP entry: %v", err)
+		return
+	}
+
+	var others []SystemUseEntrySlice
+	for _, de := range records[1:] {
+		if int(sp.BytesSkipped) <= len(de.SystemUse) {
+			// the anomalies are reported with each record
+			other, _ := parseSystemUse(de.SystemUse[sp.BytesSkipped:], v.volume, v.blockSize)
+			others = append(others, other)
+		}
+	}
+	detection, err := detectRockRidge(entries, others)
+	if err != nil {
+		v.add(SeverityError, "/", uint32(dot.ExtentLocation), "invalid ER entry: %v", err)
+	}
+	if detection == RockRidgeByRREntry || detection == RockRidgeByEntries {
+		v.add(SeverityWarning, "/", uint32(dot.ExtentLocation), "Rock Ridge is used without an ER entry, it was detected by its %s", detection)
+	}
+	v.susp = &SUSPMetadata{Offset: sp.BytesSkipped, HasRockRidge: detection != RockRidgeNotDetected, RockRidgeDetection: detection}
+}
+
+// verifySystemUse checks that the SUSP entries of a record can be read and the mandatory Rock Ridge entries are there
+func (v *verifier) verifySystemUse(dir verifiedDirectory, de *DirectoryEntry, rootDot bool) error {
+	if v.susp == nil {
+		return nil
+	}
+
+	recordPath := dir.path
+	if de.Identifier != string([]byte{0}) && de.Identifier != string([]byte{1}) {
+		recordPath = path.Join(dir.path, de.Identifier)
+	}
+	location := uint32(dir.record.ExtentLocation)
+
+	systemUse := de.SystemUse
+	if !rootDot {
+		if int(v.susp.Offset) > len(systemUse) {
+			v.add(SeverityError, recordPath, location, "the System Use field is shorter than the %d bytes skipped by SUSP", v.susp.Offset)
+			return nil
+		}
+		systemUse = systemUse[v.susp.Offset:]
+	}
+	entries, anomalies := parseSystemUse(systemUse, v.volume, v.blockSize)
+	unreadable := false
+	for _, a := range anomalies {
+		v.add(a.severity, recordPath, location, "the System Use entries: %v", a.err)
+		unreadable = unreadable || a.severity == SeverityError
+	}
+	if unreadable || !v.susp.HasRockRidge {
+		return nil
+	}
+
+	// RRIP 4.1 allows these entries only once per record
+	counts := make(map[string]int)
+	for _, entry := range entries {
+		counts[entry.Type()]++
+	}
+	for _, signature := range []string{"PX", "PN", "CL", "PL", "RE"} {
+		if counts[signature] > 1 {
+			v.add(SeverityError, recordPath, location, "the record has %d %s entries instead of one", counts[signature], signature)
+		}
+	}
+
+	// the RR entry of RRIP 1.09 lists the entries, Linux ignores those of a record whose RR entry lacks PX, TF, SL and CL
+	if flags, ok := SystemUseEntrySlice(entries).GetRockRidgeFlags(); ok {
+		if recorded := rockRidgeFlagsOf(entries); flags != recorded {
+			v.add(SeverityWarning, recordPath, location, "the RR entry lists the entries %q, but the record has %q", flags, recorded)
+		}
+	}
+
+	// RRIP 4.1.1 requires a PX entry in every record
+	if _, err := SystemUseEntrySlice(entries).getPosixEntry(); err != nil {
+		v.add(SeverityError, recordPath, location, "invalid Rock Ridge entries: %v", err)
+	}
+	target, found, err := SystemUseEntrySlice(entries).getLocationEntry("CL")
+	if err != nil {
+		v.add(SeverityError, recordPath, location, "invalid Rock Ridge entries: %v", err)
+	} else if found {
+		if !v.inVolume(target, 1) {
+			v.add(SeverityError, recordPath, location, "the CL entry points to sector %d outside of the volume space", target)
+		} else if _, err := readDotEntry(v.image.ra, target, v.blockSize); err != nil {
+			v.add(SeverityError, recordPath, location, "the CL entry doesn't point to a directory: %v", err)
+		}
+	}
+	return nil
+}
+
+// verifyOverlaps reports the extents which overlap, except for files sharing the same extent
+func (v *verifier) verifyOverlaps() {
+	sort.SliceStable(v.extents, func(a, b int) bool {
+		return v.extents[a].location < v.extents[b].location
+	})
+
+	var last *verifiedExtent
+	for n := range v.extents {
+		e := &v.extents[n]
+		if e.sectors == 0 {
+			continue
+		}
+		if last != nil && e.location < last.location+last.sectors {
+			if !(e.file && last.file && e.location == last.location && e.sectors == last.sectors) {
+				v.add(SeverityError, e.path, e.location, "the extent overlaps %s at sector %d", last.path, last.location)
+			}
+		}
+		if last == nil || e.location+e.sectors > last.location+last.sectors {
+			last = e
+		}
+	}
+}
+
+// identifierProblem describes how a directory or file identifier violates ECMA-119 7.5 and 7.6 at an interchange level,
+// or returns an empty string if it doesn't. The version of file identifiers is optional.
+func identifierProblem(identifier string, isDir bool, level int) string {
+	limits := nameLimits{directory: 8, fileName: 8, extension: 3}
+	if level > 1 {
+		limits = nameLimits{directory: primaryVolumeDirectoryIdentifierMaxLength, fileIdentifier: primaryVolumeFileIdentifierMaxLength}
+	}
+	invalid := func(part string) string {
+		for _, c := range []byte(part) {
+			if strings.IndexByte(dCharacters, c) < 0 {
+				return fmt.Sprintf("%q isn't a d-character", c)
+			}
+		}
+		return ""
+	}
+
+	if isDir {
+		if len(identifier) > limits.directory {
+			return fmt.Sprintf("the directory identifier is longer than %d characters", limits.directory)
+		}
+		return invalid(identifier)
+	}
+
+	if i := strings.LastIndexByte(identifier, ';'); i >= 0 {
+		version, err := strconv.Atoi(identifier[i+1:])
+		if err != nil || version < 1 || version > maxFileVersion {
+			return fmt.Sprintf("the version %q isn't a number from 1 to 32767", identifier[i+1:])
+		}
+		identifier = identifier[:i]
+	}
+	dot := strings.IndexByte(identifier, '.')
+	if dot < 0 {
+		return "the file identifier has no \".\" separator"
+	}
+	name, extension := identifier[:dot], identifier[dot+1:]
+	switch {
+	case name == "" && extension == "":
+		return "the file name and extension are both empty"
+	case limits.fileName > 0 && len(name) > limits.fileName:
+		return fmt.Sprintf("the file name is longer than %d characters", limits.fileName)
+	case limits.extension > 0 && len(extension) > limits.extension:
+		return fmt.Sprintf("the file name extension is longer than %d characters", limits.extension)
+	case limits.fileIdentifier > 0 && len(name)+len(extension) > limits.fileIdentifier:
+		return fmt.Sprintf("the file name and extension are longer than %d characters", limits.fileIdentifier)
+	}
+	if problem := invalid(name); problem != "" {
+		return problem
+	}
+	return invalid(extension)
+}
+
+// inVolume reports whether the given logical blocks lie within the volume space
+func (v *verifier) inVolume(location, sectors uint32) bool {
+	return uint64(location)+uint64(sectors) <= uint64(v.volumeSpaceSize)
+}
+
+// sectorBlocks returns the first logical block and the number of blocks covering the given sectors,
+// which the volume descriptors and El Torito count in
+func (v *verifier) sectorBlocks(location, sectors uint32) (uint32, uint32) {
+	start := int64(location) * int64(sectorSize)
+	end := start + int64(sectors)*int64(sectorSize)
+	first := start / v.blockSize
+	return uint32(first), uint32((end+v.blockSize-1)/v.blockSize - first)
+}
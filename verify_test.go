@@ -0,0 +1,240 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyFixtures(t *testing.T) {
+	for _, fixture := range []string{"fixtures/test.iso", "fixtures/test_rockridge.iso"} {
+		t.Run(fixture, func(t *testing.T) {
+			f, err := os.Open(fixture)
+			require.NoError(t, err)
+			defer f.Close() // nolint: errcheck
+
+			img, err := OpenImage(f)
+			require.NoError(t, err)
+			report, err := img.Verify(WithFileData())
+			require.NoError(t, err)
+			assert.Empty(t, report.Findings)
+		})
+	}
+}
+
+// verifiedImage writes a small Rock Ridge image for Verify to check
+func verifiedImage(t *testing.T) []byte {
+	iw, err := NewWriter()
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+
+	iw.SetRockRidge(true)
+	require.NoError(t, iw.AddFile(strings.NewReader("alpha"), "A.TXT"))
+	require.NoError(t, iw.AddFile(strings.NewReader(strings.Repeat("B", 3*int(sectorSize))), "B.TXT"))
+	require.NoError(t, iw.AddFile(strings.NewReader("nested"), "DIR/C.TXT"))
+
+	var buf bytes.Buffer
+	require.NoError(t, iw.WriteTo(&buf, "verified"))
+	return buf.Bytes()
+}
+
+// directoryRecordAt returns the raw directory record with the given identifier
+func directoryRecordAt(t *testing.T, image []byte, identifier string) []byte {
+	needle := append([]byte{byte(len(identifier))}, identifier...)
+	n := bytes.Index(image, needle)
+	require.True(t, n >= 32, "record %q not found", identifier)
+	start := n - 32
+	return image[start : start+int(image[start])]
+}
+
+func verifyBytes(t *testing.T, image []byte, opts ...VerifyOption) VerifyReport {
+	img, err := OpenImage(bytes.NewReader(image))
+	require.NoError(t, err)
+	report, err := img.Verify(opts...)
+	require.NoError(t, err)
+	return report
+}
+
+func findingMessages(report VerifyReport) []string {
+	var messages []string
+	for _, f := range report.Findings {
+		messages = append(messages, f.String())
+	}
+	return messages
+}
+
+func TestVerifyCorruptedImages(t *testing.T) {
+	assert.Empty(t, verifyBytes(t, verifiedImage(t)).Findings)
+
+	for _, testcase := range []struct {
+		name    string
+		corrupt func(image []byte)
+		finding string
+	}{
+		{
+			name: "overlapping extents",
+			corrupt: func(image []byte) {
+				b := directoryRecordAt(t, image, "B.TXT;1")
+				a := directoryRecordAt(t, image, "A.TXT;1")
+				WriteInt32LSBMSB(a[2:10], int32(binary.LittleEndian.Uint32(b[2:6]))+1)
+			},
+			finding: "/A.TXT;1 (sector 25): the extent overlaps /B.TXT;1 at sector 24",
+		},
+		{
+			name: "extent outside of the volume",
+			corrupt: func(image []byte) {
+				WriteInt32LSBMSB(directoryRecordAt(t, image, "B.TXT;1")[2:10], int32(len(image)/int(sectorSize)-1))
+			},
+			finding: "the extent of 6144 bytes lies outside of the volume space",
+		},
+		{
+			name: "unsorted records",
+			corrupt: func(image []byte) {
+				copy(directoryRecordAt(t, image, "A.TXT;1")[33:], "C")
+			},
+			finding: `/B.TXT;1 (sector 20): the record doesn't follow "C.TXT;1" in the order ECMA-119 9.3 requires`,
+		},
+		{
+			name: "M path table",
+			corrupt: func(image []byte) {
+				pvd := image[16*sectorSize:]
+				mPathTable := binary.BigEndian.Uint32(pvd[148:152])
+				image[mPathTable*sectorSize+2]++
+			},
+			finding: "differs between the L path table",
+		},
+		{
+			name: "dot record",
+			corrupt: func(image []byte) {
+				dir := directoryRecordAt(t, image, "DIR")
+				location := binary.LittleEndian.Uint32(dir[2:6])
+				WriteInt32LSBMSB(image[location*sectorSize+2:], int32(location)+1)
+			},
+			finding: `/DIR (sector 22): the "." record points to sector 23 instead of the directory`,
+		},
+		{
+			name: "Rock Ridge entries",
+			corrupt: func(image []byte) {
+				record := directoryRecordAt(t, image, "A.TXT;1")
+				px := bytes.Index(record, []byte("PX"))
+				require.True(t, px > 0)
+				copy(record[px:], "XX")
+			},
+			finding: "/A.TXT;1 (sector 20): invalid Rock Ridge entries: mandatory entry PX not found",
+		},
+		{
+			name: "duplicate PX entries",
+			corrupt: func(image []byte) {
+				record := directoryRecordAt(t, image, "A.TXT;1")
+				tf := bytes.Index(record, []byte("TF"))
+				require.True(t, tf > 0)
+				copy(record[tf:], "PX")
+			},
+			finding: "/A.TXT;1 (sector 20): the record has 2 PX entries instead of one",
+		},
+		{
+			name: "volume space",
+			corrupt: func(image []byte) {
+				WriteInt32LSBMSB(image[16*sectorSize+80:], int32(len(image)/int(sectorSize)+10))
+			},
+			finding: "the image ends before the last of",
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			image := verifiedImage(t)
+			testcase.corrupt(image)
+
+			report := verifyBytes(t, image)
+			require.NotEmpty(t, report.Errors())
+			found := false
+			for _, message := range findingMessages(report) {
+				found = found || strings.Contains(message, testcase.finding)
+			}
+			assert.True(t, found, "%q not among %q", testcase.finding, findingMessages(report))
+		})
+	}
+}
+
+func TestVerifyInterchangeLevel(t *testing.T) {
+	iw, err := NewWriterWithOptions(WriterOptions{InterchangeLevel: 2})
+	require.NoError(t, err)
+	defer iw.Cleanup() // nolint: errcheck
+	require.NoError(t, iw.AddFile(strings.NewReader("long"), "a_long_file_name.text"))
+	require.NoError(t, iw.AddFile(strings.NewReader("nested"), "a_long_directory/C.TXT"))
+	var buf bytes.Buffer
+	require.NoError(t, iw.WriteTo(&buf, "levels"))
+
+	assert.Empty(t, verifyBytes(t, buf.Bytes(), WithInterchangeLevel(2)).Findings)
+	assert.Equal(t, []string{
+		"error: /A_LONG_DIRECTORY (sector 20): the identifier isn't valid at interchange level 1: the directory identifier is longer than 8 characters",
+		"error: /A_LONG_FILE_NAME.TEXT;1 (sector 20): the identifier isn't valid at interchange level 1: the file name is longer than 8 characters",
+	}, findingMessages(verifyBytes(t, buf.Bytes(), WithInterchangeLevel(1))))
+
+	for identifier, problem := range map[string]string{
+		"README.TXT;1":  "",
+		"README.TXT":    "",
+		".PRO;1":        "",
+		"lower.txt;1":   `'l' isn't a d-character`,
+		"README;1":      `the file identifier has no "." separator`,
+		".;1":           "the file name and extension are both empty",
+		"README.TXT;0":  `the version "0" isn't a number from 1 to 32767`,
+		"README.TEXT;1": "the file name extension is longer than 3 characters",
+		"A.B.C;1":       `'.' isn't a d-character`,
+	} {
+		assert.Equal(t, problem, identifierProblem(identifier, false, 1), identifier)
+	}
+	assert.Equal(t, "", identifierProblem(strings.Repeat("D", 31), true, 3))
+	assert.Equal(t, "the directory identifier is longer than 31 characters", identifierProblem(strings.Repeat("D", 32), true, 3))
+	assert.Equal(t, "the file name and extension are longer than 30 characters", identifierProblem(strings.Repeat("F", 28)+".TXT;1", false, 2))
+}
+
+func TestVerifyMaxFindings(t *testing.T) {
+	image := verifiedImage(t)
+	for _, identifier := range []string{"A.TXT;1", "B.TXT;1", "C.TXT;1"} {
+		WriteInt32LSBMSB(directoryRecordAt(t, image, identifier)[2:10], int32(len(image)/int(sectorSize)))
+	}
+
+	assert.Len(t, verifyBytes(t, image).Findings, 3)
+	assert.Len(t, verifyBytes(t, image, WithMaxFindings(2)).Findings, 2)
+}
+
+func TestVerifyBootCatalog(t *testing.T) {
+	catalog := make([]byte, sectorSize)
+	catalog[0] = 1
+	copy(catalog[4:], "gopher")
+	catalog[30], catalog[31] = 0x55, 0xAA
+	var sum uint16
+	for n := 0; n < elToritoEntrySize; n += 2 {
+		sum += binary.LittleEndian.Uint16(catalog[n:])
+	}
+	binary.LittleEndian.PutUint16(catalog[28:], -sum)
+	catalog[elToritoEntrySize] = 0x88
+	binary.LittleEndian.PutUint32(catalog[elToritoEntrySize+8:], 21)
+
+	verify := func(catalog []byte) []string {
+		image := append(make([]byte, 20*sectorSize), catalog...)
+		v := &verifier{image: &Image{ra: bytes.NewReader(image)}, volumeSpaceSize: 22, blockSize: int64(sectorSize)}
+		v.verifyBootCatalog(20)
+		return findingMessages(v.report)
+	}
+
+	assert.Empty(t, verify(catalog))
+
+	corrupted := append([]byte{}, catalog...)
+	corrupted[4] = 'G'
+	assert.Equal(t, []string{"error: (sector 20): the checksum of the boot catalog's validation entry is invalid"}, verify(corrupted))
+
+	corrupted = append([]byte{}, catalog...)
+	binary.LittleEndian.PutUint32(corrupted[elToritoEntrySize+8:], 22)
+	assert.Equal(t, []string{"error: (sector 20): the boot image at sector 22 lies outside of the volume space"}, verify(corrupted))
+
+	assert.Equal(t, []string{"error: (sector 20): the boot catalog doesn't start with a validation entry"}, verify(make([]byte, sectorSize)))
+}
@@ -0,0 +1,56 @@
+package iso9660
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxFileVersion is the highest version of a file identifier, see ECMA-119 7.5.2
+const maxFileVersion = 32767
+
+// parseVersion returns the version of a file identifier, or false if the part after its last ";" isn't a valid one
+func parseVersion(identifier string) (uint16, bool) {
+	i := strings.LastIndexByte(identifier, ';')
+	if i < 0 {
+		return 0, false
+	}
+	version, err := strconv.Atoi(identifier[i+1:])
+	if err != nil || version < 1 || version > maxFileVersion {
+		return 0, false
+	}
+	return uint16(version), true
+}
+
+// splitVersion splits a file name ending with a version, e.g. "README.TXT;2", into the name and the version.
+// Names without a valid version are returned as they are, with the version 1.
+func splitVersion(name string) (string, uint16) {
+	version, ok := parseVersion(name)
+	if !ok {
+		return name, 1
+	}
+	return name[:strings.LastIndexByte(name, ';')], version
+}
+
+// Version returns the version of the entry's file identifier, e.g. 2 for "README.TXT;2".
+// It returns false for directories and for identifiers recorded without a valid version.
+//
+// A directory can hold several versions of a file, which share the same name unless Rock Ridge tells them apart.
+// GetChildren lists them all, with the highest version first as ECMA-119 9.3 orders them,
+// so that looking up a name finds the latest version. The associated file of a file, see IsAssociated,
+// has the same identifier as well.
+func (f *File) Version() (uint16, bool) {
+	if f.IsDir() {
+		return 0, false
+	}
+	return parseVersion(f.de.Identifier)
+}
+
+// SetFileVersions selects whether a file name ending with ";" and a number from 1 to 32767, e.g. "README.TXT;2",
+// is written as that version of the identifier of the rest of the name, "README.TXT;2" rather than "README.TXT_2;1".
+// Several versions of a file can then be staged next to each other, which some old software discs expect.
+// The Joliet identifiers get the same versions, while the Rock Ridge names keep the whole names,
+// so that the versions stay apart. Other files get the version 1, and SetOmitVersionSuffix leaves out all versions.
+// It is disabled by default.
+func (iw *ImageWriter) SetFileVersions(enabled bool) {
+	iw.fileVersions = enabled
+}
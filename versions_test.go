@@ -0,0 +1,106 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fileVersion is what a test expects of an entry of a directory
+type fileVersion struct {
+	name, identifier string
+	version          uint16
+	data             string
+}
+
+func listVersions(t *testing.T, dir *File) []fileVersion {
+	children, err := dir.GetChildren()
+	require.NoError(t, err)
+	var result []fileVersion
+	for _, c := range children {
+		version, ok := c.Version()
+		assert.Equal(t, !c.IsDir(), ok, c.Name())
+		data, err := io.ReadAll(c.Reader())
+		require.NoError(t, err)
+		result = append(result, fileVersion{c.Name(), c.Identifier(), version, string(data)})
+	}
+	return result
+}
+
+func TestWriterFileVersions(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true, Joliet: true, FileVersions: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	for _, name := range []string{"setup.exe", "setup.exe;9", "setup.exe;10", "notes;draft", "old;0"} {
+		require.NoError(t, w.AddFile(strings.NewReader(name), name))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "versions"))
+
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	// the versions are in descending order, Rock Ridge keeps the names apart
+	assert.Equal(t, []fileVersion{
+		{"notes;draft", "NOTES_DRAFT.;1", 1, "notes;draft"},
+		{"old;0", "OLD_0.;1", 1, "old;0"},
+		{"setup.exe;10", "SETUP.EXE;10", 10, "setup.exe;10"},
+		{"setup.exe;9", "SETUP.EXE;9", 9, "setup.exe;9"},
+		{"setup.exe", "SETUP.EXE;1", 1, "setup.exe"},
+	}, listVersions(t, root))
+
+	img, err = OpenImageWithOptions(bytes.NewReader(buf.Bytes()), ReaderOptions{PreferJoliet: true})
+	require.NoError(t, err)
+	root, err = img.RootDir()
+	require.NoError(t, err)
+	assert.Equal(t, []fileVersion{
+		{"notes_draft", "notes_draft;1", 1, "notes;draft"},
+		{"old_0", "old_0;1", 1, "old;0"},
+		{"setup.exe", "setup.exe;10", 10, "setup.exe;10"},
+		{"setup.exe", "setup.exe;9", 9, "setup.exe;9"},
+		{"setup.exe", "setup.exe;1", 1, "setup.exe"},
+	}, listVersions(t, root))
+
+	// without versions in names, the suffix is part of the name
+	w, err = NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("a"), "setup.exe;2"))
+	names, err := w.NameMap()
+	require.NoError(t, err)
+	assert.Equal(t, "/SETUP.EXE_2;1", names["/setup.exe;2"])
+}
+
+func TestRemasterFileVersions(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{FileVersions: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("first"), "DATA.BIN"))
+	require.NoError(t, w.AddFile(strings.NewReader("second"), "DATA.BIN;2"))
+
+	// without Rock Ridge, both versions have the same name
+	img := remaster(t, w)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	expected := []fileVersion{
+		{"DATA.BIN", "DATA.BIN;2", 2, "second"},
+		{"DATA.BIN", "DATA.BIN;1", 1, "first"},
+	}
+	assert.Equal(t, expected, listVersions(t, root))
+
+	copied, err := NewWriterFromImage(img)
+	require.NoError(t, err)
+	defer copied.Cleanup() // nolint: errcheck
+	img = remaster(t, copied)
+	root, err = img.RootDir()
+	require.NoError(t, err)
+	assert.Equal(t, expected, listVersions(t, root))
+}
@@ -0,0 +1,139 @@
+package iso9660
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VolumeDescriptorInfo describes one of the volume descriptors of an image, see Image.VolumeDescriptors
+type VolumeDescriptorInfo struct {
+	// Index is the position in the volume descriptor set, counting from 0 at sector 16 of the session read
+	Index  int
+	Sector uint32
+	// Type is the volume descriptor type of ECMA-119 8.1.1:
+	// 0 for a Boot Record, 1 for a Primary, 2 for a Supplementary and 3 for a Volume Partition Descriptor, 255 for the terminator.
+	// Descriptors which couldn't be decoded while recovering a damaged image have the type 254, see OpenImageRecover.
+	Type byte
+	// Primary is set for Primary and Supplementary Volume Descriptors, unless they are malformed, Boot for Boot Records
+	Primary *PrimaryVolumeDescriptorBody
+	Boot    *BootVolumeDescriptorBody
+	// Partition is set for Volume Partition Descriptors
+	Partition *PartitionVolumeDescriptorBody
+	// Selected marks the Primary Volume Descriptor the Image reads, see ReaderOptions.VolumeDescriptorIndex
+	Selected bool
+	// Problem tells why a Primary or a Supplementary Volume Descriptor isn't valid, it is empty otherwise
+	Problem string
+	// JolietLevel is the UCS-2 level from 1 to 3 of a Joliet Supplementary Volume Descriptor, 0 for other descriptors
+	JolietLevel int
+	// Enhanced marks an Enhanced Volume Descriptor of ISO 9660:1999, which has the type of a Supplementary one
+	Enhanced bool
+}
+
+// VolumeDescriptors lists the volume descriptor set of the image, up to and including the terminator
+func (i *Image) VolumeDescriptors() []VolumeDescriptorInfo {
+	infos := make([]VolumeDescriptorInfo, len(i.volumeDescriptors))
+	for index, vd := range i.volumeDescriptors {
+		infos[index] = VolumeDescriptorInfo{
+			Index:     index,
+			Sector:    i.descriptorSector(index),
+			Type:      vd.Type(),
+			Primary:   vd.Primary,
+			Boot:      vd.Boot,
+			Partition: vd.Partition,
+			Selected:  index == i.primary,
+		}
+		switch vd.Type() {
+		case volumeTypePrimary:
+			infos[index].Problem = primaryVolumeProblem(vd.Primary)
+		case volumeTypeSupplementary, volumeTypeDamaged:
+			infos[index].Problem = vd.problem
+			infos[index].JolietLevel = vd.joliet
+			infos[index].Enhanced = vd.isEnhanced()
+		}
+	}
+	return infos
+}
+
+// primaryVolumeProblem tells why a Primary Volume Descriptor cannot be read, or returns an empty string
+func primaryVolumeProblem(pvd *PrimaryVolumeDescriptorBody) string {
+	root := pvd.RootDirectoryEntry
+	blockSize := int64(pvd.LogicalBlockSize)
+	switch {
+	case !validLogicalBlockSize(pvd.LogicalBlockSize):
+		return fmt.Sprintf("the logical block size of %d bytes isn't a power of two of at least %d", pvd.LogicalBlockSize, minLogicalBlockSize)
+	case root == nil || root.FileFlags&dirFlagDir == 0:
+		return "the root directory record isn't a directory"
+	case int64(root.ExtentLocation)*blockSize <= 16*int64(sectorSize) || root.ExtentLength == 0:
+		return fmt.Sprintf("the root directory extent at sector %d with %d bytes is invalid", root.ExtentLocation, root.ExtentLength)
+	case pvd.VolumeSpaceSize > 0 && int64(root.ExtentLocation)+lengthToBlocks(root.ExtentLength, blockSize) > int64(pvd.VolumeSpaceSize):
+		return fmt.Sprintf("the root directory extent at sector %d ends beyond the volume of %d sectors", root.ExtentLocation, pvd.VolumeSpaceSize)
+	}
+	return ""
+}
+
+// isEnhanced reports whether the descriptor is an Enhanced Volume Descriptor of ISO 9660:1999
+func (vd volumeDescriptor) isEnhanced() bool {
+	return vd.Type() == volumeTypeSupplementary && vd.Header.Version == enhancedVolumeDescriptorVersion
+}
+
+// selectEnhancedVolume selects the first valid Enhanced Volume Descriptor instead of the Primary one, if there is one
+func (i *Image) selectEnhancedVolume() {
+	for n, vd := range i.volumeDescriptors {
+		if vd.isEnhanced() && vd.Primary != nil && vd.problem == "" && primaryVolumeProblem(vd.Primary) == "" {
+			i.primary = n
+			return
+		}
+	}
+}
+
+// selectPrimaryVolume picks the Primary Volume Descriptor to read: the one at the given index if it isn't 0,
+// the first valid one otherwise. More than one Primary Volume Descriptor is recorded as a warning.
+func (i *Image) selectPrimaryVolume(index int) error {
+	i.primary = -1
+
+	var sectors []string
+	roots := make(map[int32]bool)
+	firstValid := -1
+	for n, vd := range i.volumeDescriptors {
+		if vd.Type() != volumeTypePrimary {
+			continue
+		}
+		sectors = append(sectors, fmt.Sprint(i.descriptorSector(n)))
+		if vd.Primary.RootDirectoryEntry != nil {
+			roots[vd.Primary.RootDirectoryEntry.ExtentLocation] = true
+		}
+		if problem := primaryVolumeProblem(vd.Primary); problem != "" {
+			i.warnings.add(ReaderWarning{LBA: i.descriptorSector(n), Reason: "the Primary Volume Descriptor is invalid: " + problem})
+		} else if firstValid < 0 {
+			firstValid = n
+		}
+	}
+
+	switch {
+	case index != 0:
+		if index < 0 || index >= len(i.volumeDescriptors) || i.volumeDescriptors[index].Type() != volumeTypePrimary {
+			return fmt.Errorf("volume descriptor %d is not a Primary Volume Descriptor", index)
+		}
+		i.primary = index
+	case firstValid >= 0:
+		i.primary = firstValid
+	case len(sectors) > 0:
+		// read the first one anyway, the reads fail where it is broken
+		for n, vd := range i.volumeDescriptors {
+			if vd.Type() == volumeTypePrimary {
+				i.primary = n
+				break
+			}
+		}
+	}
+
+	if len(sectors) > 1 {
+		divergent := ""
+		if len(roots) > 1 {
+			divergent = " with different root directories"
+		}
+		i.warnings.add(ReaderWarning{Reason: fmt.Sprintf("found %d Primary Volume Descriptors%s at sectors %s, reading the one at sector %d",
+			len(sectors), divergent, strings.Join(sectors, ", "), i.descriptorSector(i.primary))})
+	}
+	return nil
+}
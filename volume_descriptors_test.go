@@ -0,0 +1,122 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// twoPrimaryVolumes returns an image with a second Primary Volume Descriptor at sector 17,
+// whose root directory is the directory SUB of the first one. edit can change the first one.
+func twoPrimaryVolumes(t *testing.T, edit func(pvd *PrimaryVolumeDescriptorBody)) []byte {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("top"), "TOP.TXT"))
+	require.NoError(t, w.AddFile(strings.NewReader("nested"), "SUB/NESTED.TXT"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "twice"))
+	data := buf.Bytes()
+
+	img, err := OpenImage(bytes.NewReader(data))
+	require.NoError(t, err)
+	sub := filesByPath(t, img)["/SUB"]
+	require.NotNil(t, sub)
+
+	first := img.volumeDescriptors[0]
+	second := volumeDescriptor{Header: first.Header, Primary: &PrimaryVolumeDescriptorBody{}}
+	*second.Primary = *first.Primary
+	root := *first.Primary.RootDirectoryEntry
+	root.ExtentLocation, root.ExtentLength = sub.de.ExtentLocation, sub.de.ExtentLength
+	second.Primary.RootDirectoryEntry = &root
+	second.Primary.VolumeIdentifier = "second"
+	if edit != nil {
+		edit(first.Primary)
+	}
+
+	// the terminator replaces the L path table, which the reader doesn't need
+	terminator := img.volumeDescriptors[1]
+	for sector, vd := range []volumeDescriptor{first, second, terminator} {
+		encoded, err := vd.MarshalBinary()
+		require.NoError(t, err)
+		copy(data[(16+sector)*int(sectorSize):], encoded)
+	}
+	return data
+}
+
+func TestMultiplePrimaryVolumes(t *testing.T) {
+	data := twoPrimaryVolumes(t, nil)
+
+	img, err := OpenImage(bytes.NewReader(data))
+	require.NoError(t, err)
+	label, err := img.Label()
+	require.NoError(t, err)
+	assert.Equal(t, "twice", label)
+	assert.Contains(t, filesByPath(t, img), "/TOP.TXT")
+	assert.Equal(t, []ReaderWarning{{Reason: "found 2 Primary Volume Descriptors with different root directories at sectors 16, 17, reading the one at sector 16"}}, img.Warnings())
+
+	descriptors := img.VolumeDescriptors()
+	require.Len(t, descriptors, 3)
+	assert.Equal(t, uint32(17), descriptors[1].Sector)
+	assert.Equal(t, volumeTypePrimary, descriptors[1].Type)
+	assert.Equal(t, "second", descriptors[1].Primary.VolumeIdentifier)
+	assert.True(t, descriptors[0].Selected)
+	assert.False(t, descriptors[1].Selected)
+	assert.Equal(t, volumeTypeTerminator, descriptors[2].Type)
+
+	report, err := img.Verify()
+	require.NoError(t, err)
+	assert.Contains(t, report.Findings, VerifyFinding{Severity: SeverityWarning, Message: img.Warnings()[0].Reason})
+
+	// the other one can be selected
+	img, err = OpenImageWithOptions(bytes.NewReader(data), ReaderOptions{VolumeDescriptorIndex: 1})
+	require.NoError(t, err)
+	label, err = img.Label()
+	require.NoError(t, err)
+	assert.Equal(t, "second", label)
+	files := filesByPath(t, img)
+	assert.Contains(t, files, "/NESTED.TXT")
+	assert.NotContains(t, files, "/TOP.TXT")
+	assert.True(t, img.VolumeDescriptors()[1].Selected)
+	assert.Equal(t, []ReaderWarning{{Reason: "found 2 Primary Volume Descriptors with different root directories at sectors 16, 17, reading the one at sector 17"}}, img.Warnings())
+
+	for _, index := range []int{-1, 2, 3} {
+		_, err = OpenImageWithOptions(bytes.NewReader(data), ReaderOptions{VolumeDescriptorIndex: index})
+		assert.Error(t, err, index)
+	}
+
+	// an image with a single one has no warnings
+	f, err := os.Open("fixtures/test.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+	img, err = OpenImage(f)
+	require.NoError(t, err)
+	assert.Empty(t, img.Warnings())
+	assert.True(t, img.VolumeDescriptors()[0].Selected)
+}
+
+func TestInvalidPrimaryVolume(t *testing.T) {
+	data := twoPrimaryVolumes(t, func(pvd *PrimaryVolumeDescriptorBody) {
+		pvd.LogicalBlockSize = 1000
+	})
+
+	// the first valid one is read by default
+	img, err := OpenImage(bytes.NewReader(data))
+	require.NoError(t, err)
+	label, err := img.Label()
+	require.NoError(t, err)
+	assert.Equal(t, "second", label)
+	assert.Equal(t, []ReaderWarning{
+		{LBA: 16, Reason: "the Primary Volume Descriptor is invalid: the logical block size of 1000 bytes isn't a power of two of at least 512"},
+		{Reason: "found 2 Primary Volume Descriptors with different root directories at sectors 16, 17, reading the one at sector 17"},
+	}, img.Warnings())
+	assert.Equal(t, "the logical block size of 1000 bytes isn't a power of two of at least 512", img.VolumeDescriptors()[0].Problem)
+	assert.Empty(t, img.VolumeDescriptors()[1].Problem)
+}
@@ -0,0 +1,71 @@
+package iso9660
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// VolumeSet identifies the volumes of a set recorded on several discs, see ECMA-119 6.6.
+// The volumes of a set share its identifier, and each of them has its own sequence number from 1 to the size of the set.
+type VolumeSet struct {
+	Identifier     string `json:"identifier,omitempty"`
+	Size           uint16 `json:"size"`
+	SequenceNumber uint16 `json:"sequence_number"`
+}
+
+// validate checks that the sequence number is within the set
+func (s VolumeSet) validate() error {
+	if s.Size == 0 || s.Size > math.MaxInt16 || s.SequenceNumber == 0 || s.SequenceNumber > s.Size {
+		return fmt.Errorf("volume %d of a volume set of %d volumes is invalid", s.SequenceNumber, s.Size)
+	}
+	if len(s.Identifier) > 128 {
+		return errors.New("the volume set identifier is longer than 128 characters")
+	}
+	return nil
+}
+
+// VolumeSet returns the volume set the selected Primary Volume Descriptor belongs to.
+// Single images are volume 1 of a set of 1 volume.
+func (i *Image) VolumeSet() (VolumeSet, error) {
+	pvd, err := i.primaryVolume()
+	if err != nil {
+		return VolumeSet{}, err
+	}
+
+	return VolumeSet{
+		Identifier:     pvd.VolumeSetIdentifier,
+		Size:           uint16(pvd.VolumeSetSize),
+		SequenceNumber: uint16(pvd.VolumeSequenceNumber),
+	}, nil
+}
+
+// VolumePartitions returns the Volume Partition Descriptors of the volume descriptor set, see ECMA-119 8.6
+func (i *Image) VolumePartitions() []*PartitionVolumeDescriptorBody {
+	var partitions []*PartitionVolumeDescriptorBody
+	for _, vd := range i.volumeDescriptors {
+		if vd.Partition != nil {
+			partitions = append(partitions, vd.Partition)
+		}
+	}
+	return partitions
+}
+
+// VolumeSet returns the volume set the image will belong to
+func (iw *ImageWriter) VolumeSet() VolumeSet {
+	return VolumeSet{Identifier: iw.volume.VolumeSetIdentifier, Size: iw.volumeSetSize, SequenceNumber: iw.volumeSequence}
+}
+
+// SetVolumeSet makes the image the volume with the given sequence number of a volume set, to build the discs
+// of a set one after the other. The identifier replaces the volume set identifier of the VolumeMetadata,
+// and the sequence number is recorded in the Primary and Supplementary Volume Descriptors and the directory records.
+// Every volume holds its own directory hierarchy. By default, the image is volume 1 of a set of 1 volume.
+func (iw *ImageWriter) SetVolumeSet(set VolumeSet) error {
+	if err := set.validate(); err != nil {
+		return err
+	}
+	iw.volume.VolumeSetIdentifier = set.Identifier
+	iw.volumeSetSize = set.Size
+	iw.volumeSequence = set.SequenceNumber
+	return nil
+}
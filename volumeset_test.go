@@ -0,0 +1,132 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionVolumeDescriptor(t *testing.T) {
+	vd := volumeDescriptor{
+		Header: volumeDescriptorHeader{Type: volumeTypePartition, Identifier: standardIdentifierBytes, Version: 1},
+		Partition: &PartitionVolumeDescriptorBody{
+			SystemIdentifier:          "SYSTEM",
+			VolumePartitionIdentifier: "PARTITION",
+			Location:                  100,
+			Size:                      20,
+		},
+	}
+	vd.Partition.SystemUse[0] = 0x42
+	data, err := vd.MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, data, int(sectorSize))
+
+	var decoded volumeDescriptor
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, vd, decoded)
+
+	vd.Partition.VolumePartitionIdentifier = strings.Repeat("X", 33)
+	_, err = vd.MarshalBinary()
+	assert.Error(t, err)
+}
+
+func TestImageVolumePartitions(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{PadSectors: 4})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("data"), "DATA.TXT"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "partitioned"))
+	data := buf.Bytes()
+
+	img, err := OpenImage(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Empty(t, img.VolumePartitions())
+
+	// the partition is recorded over the padding, and the terminator replaces the L path table
+	sectors := uint32(len(data)) / sectorSize
+	partition := volumeDescriptor{
+		Header:    volumeDescriptorHeader{Type: volumeTypePartition, Identifier: standardIdentifierBytes, Version: 1},
+		Partition: &PartitionVolumeDescriptorBody{SystemIdentifier: "LOADER", VolumePartitionIdentifier: "SPARE", Location: sectors - 4, Size: 4},
+	}
+	for sector, vd := range []volumeDescriptor{img.volumeDescriptors[0], partition, img.volumeDescriptors[1]} {
+		encoded, err := vd.MarshalBinary()
+		require.NoError(t, err)
+		copy(data[(16+sector)*int(sectorSize):], encoded)
+	}
+
+	img, err = OpenImage(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, []*PartitionVolumeDescriptorBody{partition.Partition}, img.VolumePartitions())
+	descriptors := img.VolumeDescriptors()
+	require.Len(t, descriptors, 3)
+	assert.Equal(t, volumeTypePartition, descriptors[1].Type)
+	assert.Equal(t, partition.Partition, descriptors[1].Partition)
+	assert.Contains(t, filesByPath(t, img), "/DATA.TXT")
+
+	// the missing L path table is reported, the partition doesn't overlap anything
+	report, err := img.Verify()
+	require.NoError(t, err)
+	for _, finding := range report.Findings {
+		assert.NotContains(t, finding.Message, "partition")
+	}
+}
+
+func TestWriterVolumeSet(t *testing.T) {
+	w, err := NewWriterWithOptions(WriterOptions{Joliet: true, VolumeSet: &VolumeSet{Identifier: "ARCHIVE", Size: 3, SequenceNumber: 2}})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	assert.Equal(t, VolumeSet{Identifier: "ARCHIVE", Size: 3, SequenceNumber: 2}, w.VolumeSet())
+	assert.Equal(t, "ARCHIVE", w.VolumeMetadata().VolumeSetIdentifier)
+	require.NoError(t, w.AddFile(strings.NewReader("part two"), "PART2/DATA.BIN"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "disc2"))
+
+	for _, preferJoliet := range []bool{false, true} {
+		img, err := OpenImageWithOptions(bytes.NewReader(buf.Bytes()), ReaderOptions{PreferJoliet: preferJoliet})
+		require.NoError(t, err)
+		set, err := img.VolumeSet()
+		require.NoError(t, err)
+		assert.Equal(t, VolumeSet{Identifier: "ARCHIVE", Size: 3, SequenceNumber: 2}, set)
+		for path, f := range filesByPath(t, img) {
+			assert.Equal(t, int16(2), f.de.VolumeSequenceNumber, path)
+		}
+		report, err := img.Verify()
+		require.NoError(t, err)
+		assert.Empty(t, report.Findings)
+	}
+
+	// remastering keeps the place of the image in the set
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	copied, err := NewWriterFromImage(img)
+	require.NoError(t, err)
+	defer copied.Cleanup() // nolint: errcheck
+	assert.Equal(t, VolumeSet{Identifier: "ARCHIVE", Size: 3, SequenceNumber: 2}, copied.VolumeSet())
+
+	// a single image is the first volume of a set of one
+	w, err = NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	assert.Equal(t, VolumeSet{Size: 1, SequenceNumber: 1}, w.VolumeSet())
+	buf.Reset()
+	require.NoError(t, w.WriteTo(&buf, "single"))
+	img, err = OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	set, err := img.VolumeSet()
+	require.NoError(t, err)
+	assert.Equal(t, VolumeSet{Size: 1, SequenceNumber: 1}, set)
+
+	for _, invalid := range []VolumeSet{{}, {Size: 2}, {Size: 2, SequenceNumber: 3}, {Size: 40000, SequenceNumber: 1}, {Identifier: strings.Repeat("X", 129), Size: 1, SequenceNumber: 1}} {
+		assert.Error(t, w.SetVolumeSet(invalid), invalid)
+	}
+	assert.Equal(t, VolumeSet{Size: 1, SequenceNumber: 1}, w.VolumeSet())
+	_, err = NewWriterWithOptions(WriterOptions{VolumeSet: &VolumeSet{Size: 1, SequenceNumber: 2}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package iso9660
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// RockRidgeAttrs are the POSIX attributes of an entry assembled from its Rock Ridge entries, see File.RockRidgeAttrs
+// and UnmarshalRockRidge.
+// Without Rock Ridge, they hold what the ECMA-119 directory record tells: the name, the type, one link
+// and the recording time as the modification time.
+type RockRidgeAttrs struct {
+	// Present is set if the entry has Rock Ridge entries
+	Present bool
+	// Name is the name of the NM entries, or the identifier without its version, see File.Name.
+	// It is empty for the root directory.
+	Name string
+	// Mode holds the type and the permissions of the PX entry
+	Mode     fs.FileMode
+	UID, GID uint32
+	Links    uint32
+	// Serial is the file serial number of RRIP 1.12, 0 if it isn't recorded
+	Serial uint32
+	// Times are those of the TF entry along with the recording date of the directory record
+	Times RecordTimes
+	// SymlinkTarget is the target of the SL entries of a symbolic link
+	SymlinkTarget string
+	// DeviceMajor and DeviceMinor are the numbers of the PN entry of a device
+	DeviceMajor, DeviceMinor uint32
+}
+
+// RockRidgeAttrs returns the POSIX attributes of the entry. The entries which cannot be decoded are left out.
+func (f *File) RockRidgeAttrs() RockRidgeAttrs {
+	attrs := RockRidgeAttrs{
+		Present: f.hasRockRidge(),
+		Name:    f.Name(),
+		Mode:    f.Mode(),
+		Links:   f.Links(),
+		Times:   RecordTimes{Recording: f.ModTime(), Modification: f.ModTime()},
+	}
+	if f.isRootDir {
+		attrs.Name = ""
+	}
+	if !attrs.Present {
+		return attrs
+	}
+
+	attrs.UID, attrs.GID, _ = f.Owner()
+	attrs.Serial, _ = f.SerialNumber()
+	if times, err := f.de.SystemUseEntries.GetTimestamps(); err == nil {
+		times.Recording = attrs.Times.Recording
+		if times.Modification.IsZero() {
+			times.Modification = attrs.Times.Recording
+		}
+		attrs.Times = times
+	}
+	attrs.SymlinkTarget = f.SymlinkTarget()
+	attrs.DeviceMajor, attrs.DeviceMinor, _ = f.DeviceNumber()
+	return attrs
+}
+
+// Walk calls fn for every entry of the selected hierarchy in a single pass, with its slash-separated path
+// made of the names returned by File.Name, starting with "/" for the root directory. A directory comes
+// before its children, which are visited in the order of their directory records. Symbolic links aren't followed,
+// and hidden entries are skipped if the image was opened with ReaderOptions.SkipHidden.
+//
+// As with fs.WalkDir, fn returning fs.SkipDir skips the directory it was called for, or the rest of
+// the directory holding a file, and fs.SkipAll stops the walk without an error. Walk stops at any
+// other error, which it returns, as well as at the first directory which cannot be listed.
+func (i *Image) Walk(fn func(path string, f *File, attrs RockRidgeAttrs) error) error {
+	root, err := i.RootDir()
+	if err != nil {
+		return err
+	}
+	// listing the root tells whether the volume uses Rock Ridge
+	if _, err := root.GetChildren(); err != nil {
+		return err
+	}
+
+	err = walkFile("/", root, fn, map[int32]bool{})
+	if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// walkFile calls fn for the entry and, if it's a directory, for everything in it.
+// The extents of the directories above guard against directory records pointing back at them.
+func walkFile(name string, f *File, fn func(path string, f *File, attrs RockRidgeAttrs) error, ancestors map[int32]bool) error {
+	if err := fn(name, f, f.RockRidgeAttrs()); err != nil || !f.IsDir() {
+		return err
+	}
+	location := f.de.ExtentLocation
+	if ancestors[location] {
+		return fmt.Errorf("walking %s: the directory at sector %d is inside itself", name, location)
+	}
+	ancestors[location] = true
+	defer delete(ancestors, location)
+
+	children, err := f.GetChildren()
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		if err := walkFile(path.Join(name, c.Name()), c, fn, ancestors); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				if c.IsDir() {
+					continue
+				}
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
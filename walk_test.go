@@ -0,0 +1,131 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// filesByPath walks the whole image with Image.Walk and indexes its entries by path.
+// The tests derive their views of an image from it rather than walking the directories themselves.
+func filesByPath(t *testing.T, img *Image) map[string]*File {
+	result := map[string]*File{}
+	require.NoError(t, img.Walk(func(path string, f *File, _ RockRidgeAttrs) error {
+		result[path] = f
+		return nil
+	}))
+
+	// the root record of the volume descriptor has no System Use entries, unlike its "." entry
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	result["/"], err = root.GetDotEntry()
+	require.NoError(t, err)
+	return result
+}
+
+func TestImageWalk(t *testing.T) {
+	modTime := time.Date(2020, time.May, 4, 12, 30, 0, 0, time.UTC)
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddStreamedFile(strings.NewReader("data"), 4, "dir/file.txt", WithMode(0600), WithOwner(1000, 100), WithModTime(modTime)))
+	require.NoError(t, w.AddSymlink("file.txt", "dir/link"))
+	require.NoError(t, w.AddFile(strings.NewReader("skipped"), "skip/inner.txt"))
+	require.NoError(t, w.AddFile(strings.NewReader("other"), "zzz.txt"))
+	img := remaster(t, w)
+
+	visited := map[string]RockRidgeAttrs{}
+	var order []string
+	require.NoError(t, img.Walk(func(path string, f *File, attrs RockRidgeAttrs) error {
+		order = append(order, path)
+		visited[path] = attrs
+		if path == "/skip" {
+			return fs.SkipDir
+		}
+		return nil
+	}))
+	assert.Equal(t, []string{"/", "/dir", "/dir/file.txt", "/dir/link", "/skip", "/zzz.txt"}, order)
+
+	file := visited["/dir/file.txt"]
+	assert.True(t, file.Present)
+	assert.Equal(t, "file.txt", file.Name)
+	assert.Equal(t, fs.FileMode(0600), file.Mode)
+	assert.Equal(t, [2]uint32{1000, 100}, [2]uint32{file.UID, file.GID})
+	assert.Equal(t, uint32(1), file.Links)
+	assert.True(t, modTime.Equal(file.Times.Modification), file.Times.Modification)
+	assert.Equal(t, "file.txt", visited["/dir/link"].SymlinkTarget)
+	assert.Equal(t, fs.ModeSymlink, visited["/dir/link"].Mode.Type())
+	assert.True(t, visited["/"].Mode.IsDir())
+	assert.Equal(t, "", visited["/"].Name)
+
+	// a file returning SkipDir skips the rest of its directory
+	order = nil
+	require.NoError(t, img.Walk(func(path string, f *File, attrs RockRidgeAttrs) error {
+		order = append(order, path)
+		if path == "/dir/file.txt" {
+			return fs.SkipDir
+		}
+		if path == "/skip/inner.txt" {
+			return fs.SkipAll
+		}
+		return nil
+	}))
+	assert.Equal(t, []string{"/", "/dir", "/dir/file.txt", "/skip", "/skip/inner.txt"}, order)
+
+	// without Rock Ridge, the attributes come from the directory records
+	f, err := os.Open("fixtures/test.iso")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+	plain, err := OpenImage(f)
+	require.NoError(t, err)
+	require.NoError(t, plain.Walk(func(path string, f *File, attrs RockRidgeAttrs) error {
+		assert.False(t, attrs.Present, path)
+		assert.Equal(t, f.ModTime(), attrs.Times.Modification, path)
+		if path == "/DIR1/LOREM_IP.TXT" {
+			assert.Equal(t, "LOREM_IP.TXT", attrs.Name)
+			return fs.SkipAll
+		}
+		return nil
+	}))
+}
+
+func TestImageWalkDirectoryLoop(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	require.NoError(t, w.AddFile(strings.NewReader("data"), "DIR/SUB/FILE.TXT"))
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "loop"))
+	data := buf.Bytes()
+
+	img, err := OpenImage(bytes.NewReader(data))
+	require.NoError(t, err)
+	dir := filesByPath(t, img)["/DIR"]
+	require.NotNil(t, dir)
+	location := dir.de.ExtentLocation
+
+	// point the record of SUB back at DIR
+	sector := data[uint32(location)*sectorSize:][:sectorSize]
+	i := bytes.Index(sector, []byte("SUB"))
+	require.Positive(t, i)
+	record := sector[i-33:]
+	binary.LittleEndian.PutUint32(record[2:], uint32(location))
+	binary.BigEndian.PutUint32(record[6:], uint32(location))
+
+	img, err = OpenImage(bytes.NewReader(data))
+	require.NoError(t, err)
+	err = img.Walk(func(path string, f *File, attrs RockRidgeAttrs) error {
+		return nil
+	})
+	assert.ErrorContains(t, err, "walking /DIR/SUB: the directory at sector")
+}
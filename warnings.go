@@ -0,0 +1,122 @@
+package iso9660
+
+import (
+	"fmt"
+	"path"
+	"sync"
+)
+
+const defaultMaxWarnings = 1000
+
+// ReaderWarning is an anomaly of the image the reader worked around, see Image.Warnings.
+// With ReaderOptions.StrictSUSP, the anomalies of System Use fields are returned as errors instead.
+type ReaderWarning struct {
+	// Path is the path of the affected directory record, made of the identifiers as they are recorded,
+	// or empty if the warning concerns the volume
+	Path string `json:"path,omitempty"`
+	// LBA is the sector where the anomaly lies
+	LBA    uint32 `json:"lba,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (w ReaderWarning) String() string {
+	switch {
+	case w.Path != "" && w.LBA != 0:
+		return fmt.Sprintf("%s (sector %d): %s", w.Path, w.LBA, w.Reason)
+	case w.Path != "":
+		return fmt.Sprintf("%s: %s", w.Path, w.Reason)
+	case w.LBA != 0:
+		return fmt.Sprintf("sector %d: %s", w.LBA, w.Reason)
+	}
+	return w.Reason
+}
+
+func (w ReaderWarning) Error() string {
+	return w.String()
+}
+
+// warningLog collects the warnings of an image, each of them once, up to a limit.
+// It is shared by all the Files of the image and safe for concurrent use.
+type warningLog struct {
+	limit int
+
+	mu       sync.Mutex
+	warnings []ReaderWarning
+	seen     map[ReaderWarning]bool
+	dropped  int
+}
+
+// newWarningLog creates a log keeping up to limit warnings, defaultMaxWarnings if limit is 0, none if it is negative
+func newWarningLog(limit int) *warningLog {
+	if limit == 0 {
+		limit = defaultMaxWarnings
+	}
+	return &warningLog{limit: limit, seen: make(map[ReaderWarning]bool)}
+}
+
+func (l *warningLog) add(w ReaderWarning) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the same directory is parsed again by every File referring to it
+	if l.seen[w] {
+		return
+	}
+	if len(l.warnings) >= l.limit {
+		l.dropped++
+		return
+	}
+	l.seen[w] = true
+	l.warnings = append(l.warnings, w)
+}
+
+func (l *warningLog) list() []ReaderWarning {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	warnings := append([]ReaderWarning(nil), l.warnings...)
+	if l.dropped > 0 {
+		warnings = append(warnings, ReaderWarning{Reason: fmt.Sprintf("%d more warnings were dropped", l.dropped)})
+	}
+	return warnings
+}
+
+// Warnings returns the anomalies found so far, such as more than one Primary Volume Descriptor when opening the image,
+// or unreadable System Use entries when listing directories. They are kept up to ReaderOptions.MaxWarnings.
+func (i *Image) Warnings() []ReaderWarning {
+	return i.warnings.list()
+}
+
+// recordPath returns the path of a directory record made of the identifiers of the directories leading to it,
+// as Verify reports it. The "." and ".." records have the path of the directory containing them.
+func (f *File) recordPath() string {
+	if f.isRootDir || f.parent == nil {
+		return "/"
+	}
+	dir := f.parent.recordPath()
+	if f.de.Identifier == string([]byte{0}) || f.de.Identifier == string([]byte{1}) {
+		return dir
+	}
+	return path.Join(dir, f.de.Identifier)
+}
+
+// reportSystemUse reports an anomaly of the System Use field of a record in the directory f, at the given offset
+// of its extent. It is recorded as a warning, or returned as an error with ReaderOptions.StrictSUSP.
+func (f *File) reportSystemUse(record *File, offset int, err error) error {
+	w := ReaderWarning{
+		Path:   record.recordPath(),
+		LBA:    uint32(f.de.ExtentLocation) + uint32(int64(offset)/f.logicalBlockSize()),
+		Reason: err.Error(),
+	}
+	if f.options != nil && f.options.StrictSUSP {
+		return w
+	}
+	f.warnings.add(w)
+	return nil
+}
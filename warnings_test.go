@@ -0,0 +1,107 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// junkSUSPImage returns a Rock Ridge image in which the NM entries of the given files have an invalid version
+func junkSUSPImage(t *testing.T, files int, junk ...string) []byte {
+	w, err := NewWriterWithOptions(WriterOptions{EnableRockRidge: true})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	for i := 0; i < files; i++ {
+		require.NoError(t, w.AddFile(strings.NewReader("data"), fmt.Sprintf("dir/file-%d.txt", i)))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "junk"))
+
+	data := buf.Bytes()
+	for _, name := range junk {
+		nm := append([]byte{'N', 'M', byte(5 + len(name)), 1, 0}, name...)
+		at := bytes.Index(data, nm)
+		require.NotEqual(t, -1, at, name)
+		data[at+3] = 2
+	}
+	return data
+}
+
+func TestLenientSUSP(t *testing.T) {
+	data := junkSUSPImage(t, 3, "file-1.txt")
+
+	img, err := OpenImage(bytes.NewReader(data))
+	require.NoError(t, err)
+	files := filesByPath(t, img)
+	// the name is taken from the identifier instead
+	assert.Contains(t, files, "/dir/file-0.txt")
+	assert.Contains(t, files, "/dir/FILE_1.TXT")
+
+	warnings := img.Warnings()
+	require.Len(t, warnings, 1)
+	dir := files["/dir"]
+	assert.Equal(t, "/DIR/FILE_1.TXT;1", warnings[0].Path)
+	assert.Equal(t, uint32(dir.de.ExtentLocation), warnings[0].LBA)
+	assert.Equal(t, "skipping the NM entry with version 2 instead of 1", warnings[0].Reason)
+	assert.Equal(t, fmt.Sprintf("/DIR/FILE_1.TXT;1 (sector %d): skipping the NM entry with version 2 instead of 1", dir.de.ExtentLocation), warnings[0].String())
+
+	// the directory is listed once more, but the warning is kept once
+	filesByPath(t, img)
+	assert.Len(t, img.Warnings(), 1)
+
+	// Verify classifies it the same way
+	report, err := img.Verify()
+	require.NoError(t, err)
+	assert.Contains(t, report.Findings, VerifyFinding{
+		Severity: SeverityWarning,
+		Path:     "/DIR/FILE_1.TXT;1",
+		LBA:      uint32(dir.de.ExtentLocation),
+		Message:  "the System Use entries: skipping the NM entry with version 2 instead of 1",
+	})
+	assert.Empty(t, report.Errors())
+}
+
+func TestStrictSUSP(t *testing.T) {
+	data := junkSUSPImage(t, 3, "file-1.txt")
+
+	img, err := OpenImageWithOptions(bytes.NewReader(data), ReaderOptions{StrictSUSP: true})
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+
+	_, err = children[0].GetChildren()
+	var warning ReaderWarning
+	require.True(t, errors.As(err, &warning))
+	assert.Equal(t, "/DIR/FILE_1.TXT;1", warning.Path)
+	assert.Empty(t, img.Warnings())
+}
+
+func TestWarningsLimit(t *testing.T) {
+	data := junkSUSPImage(t, 5, "file-0.txt", "file-1.txt", "file-2.txt", "file-3.txt")
+
+	img, err := OpenImageWithOptions(bytes.NewReader(data), ReaderOptions{MaxWarnings: 2})
+	require.NoError(t, err)
+	filesByPath(t, img)
+
+	warnings := img.Warnings()
+	require.Len(t, warnings, 3)
+	assert.Equal(t, "/DIR/FILE_0.TXT;1", warnings[0].Path)
+	assert.Equal(t, "/DIR/FILE_1.TXT;1", warnings[1].Path)
+	assert.Equal(t, ReaderWarning{Reason: "2 more warnings were dropped"}, warnings[2])
+
+	img, err = OpenImageWithOptions(bytes.NewReader(data), ReaderOptions{MaxWarnings: -1})
+	require.NoError(t, err)
+	filesByPath(t, img)
+	assert.Equal(t, []ReaderWarning{{Reason: "4 more warnings were dropped"}}, img.Warnings())
+}
@@ -0,0 +1,340 @@
+package iso9660
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMemoryStagingThreshold = 256 * 1024
+	defaultMemoryStagingBudget    = 64 * 1024 * 1024
+)
+
+// WriterOptions configure an ImageWriter created by NewWriterWithOptions.
+// The zero value selects the same defaults as NewWriter.
+type WriterOptions struct {
+	// EnableRockRidge writes Rock Ridge entries, see SetRockRidge
+	EnableRockRidge bool
+	// RockRidgeIdentifier selects the extension identifier of the ER entry and the revision of RRIP,
+	// see SetRockRidgeIdentifier. It requires EnableRockRidge. If empty, RockRidgeIdentifier1991A is used.
+	RockRidgeIdentifier string
+	// Extensions are the SUSP extensions announced next to Rock Ridge, see RegisterExtension. They require EnableRockRidge.
+	Extensions []ExtensionRecord
+	// Zisofs enables zisofs compression, see SetZisofs. It requires EnableRockRidge.
+	Zisofs *ZisofsOptions
+	// DeepDirectories selects how directories nested deeper than 8 levels are written, see SetDeepDirectoryPolicy
+	DeepDirectories DeepDirectoryPolicy
+
+	// InterchangeLevel selects how identifiers are shortened and whether files larger than 4 GiB are split
+	// into several extents, see SetInterchangeLevel. 0 selects level 2.
+	InterchangeLevel int
+	// Names selects how the names are converted to identifiers, see SetNameTranslation
+	Names NameTranslation
+	// InputCharset converts the names of the staged entries to UTF-8, see SetInputCharset
+	InputCharset func(name string) (string, error)
+	// Transliterate replaces Latin letters with diacritics by their base letters in the identifiers,
+	// see SetTransliteration
+	Transliterate bool
+	// OmitVersionSuffix leaves the ";1" version out of file identifiers, see SetOmitVersionSuffix
+	OmitVersionSuffix bool
+	// FileVersions records the ";N" suffixes of file names as the versions of their identifiers, see SetFileVersions
+	FileVersions bool
+	// TransTables generates TRANS.TBL files in directories with renamed entries, see SetTransTable
+	TransTables bool
+	// EnhancedVolume records a second hierarchy with long names, described by an Enhanced Volume Descriptor
+	// of ISO 9660:1999, see SetEnhancedVolume
+	EnhancedVolume bool
+	// Joliet records a second hierarchy with Unicode names, described by a Joliet Supplementary Volume Descriptor,
+	// see SetJoliet
+	Joliet bool
+
+	// AllowOverwrite lets the Add methods replace staged files, see SetAllowOverwrite
+	AllowOverwrite bool
+	// PreserveDeviceNodes stages device nodes and FIFOs found by AddLocalDirectory, see SetPreserveDeviceNodes.
+	// It requires EnableRockRidge, as they are written as regular files otherwise.
+	PreserveDeviceNodes bool
+	// DefaultFileMode holds the permissions of files whose mode isn't taken from their source, 0644 if zero
+	DefaultFileMode fs.FileMode
+	// DefaultDirMode holds the permissions of directories whose mode isn't taken from their source, 0755 if zero
+	DefaultDirMode fs.FileMode
+
+	// MemoryStagingThreshold is the size up to which the contents added with AddFile and AddFS are kept in memory
+	// instead of being copied to the staging directory. 0 selects 256 KiB, a negative value stages everything on disk.
+	MemoryStagingThreshold int64
+	// MemoryStagingBudget limits the total size of the contents kept in memory, 0 selects 64 MiB.
+	// Once it is used up, further files are staged on disk.
+	MemoryStagingBudget int64
+
+	// DataBufferSize is the size of the buffers the file data is read into and written from, rounded up
+	// to whole sectors, so that the sources are read and the image is written in large chunks.
+	// Small files are written together. 0 selects 4 MiB.
+	DataBufferSize int
+	// Readahead reads the file data in a separate goroutine, ahead of the writes,
+	// into up to DataBuffers buffers. 0 selects 4.
+	Readahead   bool
+	DataBuffers int
+
+	// Volume replaces the metadata of the Primary Volume Descriptor, see SetVolumeMetadata. If nil, the defaults are kept.
+	Volume *VolumeMetadata
+	// VolumeSet makes the image a volume of a volume set, see SetVolumeSet. Its identifier replaces
+	// the volume set identifier of Volume. If nil, the image is volume 1 of a set of 1 volume.
+	VolumeSet *VolumeSet
+	// VolumeTimes sets the dates of the volume descriptors, see SetVolumeTimes
+	VolumeTimes VolumeTimes
+	// LogicalBlockSize is 512 or 1024 to record the locations in logical blocks smaller than a sector,
+	// see SetLogicalBlockSize. 0 selects 2048.
+	LogicalBlockSize int
+	// SystemArea is written at the beginning of the image, see SetSystemArea
+	SystemArea []byte
+	// Progress is called as the image is written, see SetProgressFunc
+	Progress func(Progress)
+	// Hybrid writes partition tables to the system area, so the image can be booted from a disk, see SetHybrid.
+	// It excludes SystemArea.
+	Hybrid *HybridOptions
+	// ImplantMD5 implants an MD5 checksum of the image, see SetImplantMD5
+	ImplantMD5 bool
+	// DenseOutput writes every sector, even to files which support holes, see SetDenseOutput
+	DenseOutput bool
+	// PadSectors is the number of zero sectors appended to the image, for drives which fail to read
+	// the last sectors of a disc. mkisofs -pad appends 150 sectors.
+	PadSectors uint32
+	// Deduplicate stores files with identical contents only once, with their directory records pointing to the same extent,
+	// see SetDeduplicate
+	Deduplicate bool
+	// ExtendedAttributeRecords records the owner, permissions and times of files in Extended Attribute Records,
+	// see SetExtendedAttributeRecords
+	ExtendedAttributeRecords bool
+	// NormalizeLocalMetadata, PreserveOwnership and PreserveTimes select the metadata recorded for local files,
+	// see SetNormalizeLocalMetadata, SetPreserveOwnership and SetPreserveTimes
+	NormalizeLocalMetadata bool
+	PreserveOwnership      bool
+	PreserveTimes          bool
+	// OwnerMap maps the owners recorded for all entries, see SetOwnerMap
+	OwnerMap func(isoPath string, uid, gid uint32) (uint32, uint32)
+
+	// DefaultAlignment aligns the extents of all files to a multiple of this number of sectors,
+	// see SetDefaultAlignment
+	DefaultAlignment int
+	// SortWeight orders the extents of the files by weight, the heaviest first, see SetSortWeight
+	SortWeight func(isoPath string) int
+
+	// FixedTimestamp, if not zero, replaces the current time in the volume descriptor and the times
+	// recorded for all entries, except those set with SetTimes, so that the output only depends on
+	// the staged contents. Unless DirectoryTimes says otherwise, directories get it as well.
+	FixedTimestamp time.Time
+	// Reproducible makes the image depend only on the staged contents and the options, so that staging the same
+	// entries again, in any order, produces the same bytes. Without a FixedTimestamp, the time comes from
+	// the SOURCE_DATE_EPOCH environment variable, see SourceDateEpoch, or is the Unix epoch if it isn't set.
+	// It cannot be combined with PreserveTimes, which records the access times of the local files.
+	// The default system identifier is still the operating system the image is written on.
+	Reproducible bool
+
+	// DirectoryTimes and DirectoryTime select the times recorded for directories, see SetDirectoryTimePolicy
+	DirectoryTimes DirectoryTimePolicy
+	DirectoryTime  time.Time
+}
+
+// validate rejects options which are invalid or contradict each other
+func (opts WriterOptions) validate() error {
+	if !opts.EnableRockRidge {
+		switch {
+		case opts.RockRidgeIdentifier != "":
+			return errors.New("a Rock Ridge identifier requires Rock Ridge to be enabled")
+		case len(opts.Extensions) > 0:
+			return errors.New("SUSP extensions require Rock Ridge to be enabled")
+		case opts.Zisofs != nil:
+			return errors.New("zisofs compression requires Rock Ridge to be enabled")
+		case opts.DeepDirectories == DeepDirectoriesRelocate:
+			return errors.New("relocating deep directories requires Rock Ridge to be enabled")
+		case opts.PreserveDeviceNodes:
+			return errors.New("preserving device nodes requires Rock Ridge to be enabled")
+		}
+	}
+
+	if opts.InterchangeLevel < 0 || opts.InterchangeLevel > 3 {
+		return fmt.Errorf("unsupported interchange level %d", opts.InterchangeLevel)
+	}
+	if opts.Names < NamesMangle || opts.Names > NamesRelaxed {
+		return fmt.Errorf("unknown name translation %d", opts.Names)
+	}
+	if opts.DefaultFileMode&^fs.ModePerm != 0 {
+		return fmt.Errorf("default file mode %s has bits other than the permissions", opts.DefaultFileMode)
+	}
+	if opts.DefaultDirMode&^fs.ModePerm != 0 {
+		return fmt.Errorf("default directory mode %s has bits other than the permissions", opts.DefaultDirMode)
+	}
+	if opts.DirectoryTimes < DirectoryTimesStaged || opts.DirectoryTimes > DirectoryTimesNow {
+		return fmt.Errorf("unknown directory time policy %d", opts.DirectoryTimes)
+	}
+	if opts.DefaultAlignment < 0 || int64(opts.DefaultAlignment) > math.MaxUint32 {
+		return fmt.Errorf("invalid alignment of %d sectors", opts.DefaultAlignment)
+	}
+	if opts.DataBufferSize < 0 || opts.DataBufferSize > math.MaxInt32 {
+		return fmt.Errorf("invalid data buffer size %d", opts.DataBufferSize)
+	}
+	if opts.DataBuffers < 0 {
+		return fmt.Errorf("negative number of data buffers %d", opts.DataBuffers)
+	}
+	if opts.MemoryStagingBudget < 0 {
+		return fmt.Errorf("negative memory staging budget %d", opts.MemoryStagingBudget)
+	}
+	if opts.Reproducible && opts.PreserveTimes {
+		return errors.New("reproducible output cannot preserve the access times of local files")
+	}
+	if opts.Hybrid != nil && len(opts.SystemArea) > 0 {
+		return errors.New("a hybrid partition table cannot be combined with a custom system area")
+	}
+
+	return nil
+}
+
+// NewWriterWithOptions creates a new ImageWriter configured with the given options.
+// It fails if the options are invalid or contradict each other.
+// Cleanup should be called after the ImageWriter is no longer needed.
+func NewWriterWithOptions(opts WriterOptions) (*ImageWriter, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Reproducible && opts.FixedTimestamp.IsZero() {
+		epoch, ok, err := SourceDateEpoch()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			epoch = time.Unix(0, 0).UTC()
+		}
+		opts.FixedTimestamp = epoch
+	}
+
+	iw, err := newWriter()
+	if err != nil {
+		return nil, err
+	}
+
+	iw.rockRidge = opts.EnableRockRidge
+	if opts.RockRidgeIdentifier != "" {
+		err = iw.SetRockRidgeIdentifier(opts.RockRidgeIdentifier)
+	}
+	for n := 0; err == nil && n < len(opts.Extensions); n++ {
+		err = iw.RegisterExtension(opts.Extensions[n])
+	}
+	if err == nil {
+		err = iw.SetZisofs(opts.Zisofs)
+	}
+	if err == nil {
+		err = iw.SetSystemArea(opts.SystemArea)
+	}
+	if err == nil {
+		err = iw.SetHybrid(opts.Hybrid)
+	}
+	if err != nil {
+		_ = iw.Cleanup()
+		return nil, err
+	}
+
+	iw.deepDirs = opts.DeepDirectories
+	iw.progress = opts.Progress
+	iw.interchangeLevel = opts.InterchangeLevel
+	iw.names = opts.Names
+	iw.inputCharset = opts.InputCharset
+	iw.transliterate = opts.Transliterate
+	iw.omitVersion = opts.OmitVersionSuffix
+	iw.fileVersions = opts.FileVersions
+	iw.transTables = opts.TransTables
+	iw.enhancedVolume = opts.EnhancedVolume
+	iw.joliet = opts.Joliet
+	iw.allowOverwrite = opts.AllowOverwrite
+	iw.deviceNodes = opts.PreserveDeviceNodes
+	iw.normalizeLocal = opts.NormalizeLocalMetadata
+	iw.preserveOwnership = opts.PreserveOwnership
+	iw.preserveTimes = opts.PreserveTimes
+	iw.ownerMap = opts.OwnerMap
+	iw.fileMode = opts.DefaultFileMode
+	iw.dirMode = opts.DefaultDirMode
+	if opts.Volume != nil {
+		iw.volume = *opts.Volume
+	}
+	if opts.VolumeSet != nil {
+		if err = iw.SetVolumeSet(*opts.VolumeSet); err != nil {
+			_ = iw.Cleanup()
+			return nil, err
+		}
+	}
+	iw.volumeTimes = opts.VolumeTimes
+	if opts.LogicalBlockSize != 0 {
+		if err = iw.SetLogicalBlockSize(opts.LogicalBlockSize); err != nil {
+			_ = iw.Cleanup()
+			return nil, err
+		}
+	}
+	iw.implantMD5 = opts.ImplantMD5
+	iw.dense = opts.DenseOutput
+	iw.padSectors = opts.PadSectors
+	iw.deduplicate = opts.Deduplicate
+	iw.extendedAttributes = opts.ExtendedAttributeRecords
+	iw.fixedTime = opts.FixedTimestamp
+	iw.dirTimes = opts.DirectoryTimes
+	iw.defaultAlignment = uint32(opts.DefaultAlignment)
+	iw.sortWeight = opts.SortWeight
+	iw.dirTime = opts.DirectoryTime
+	if opts.MemoryStagingThreshold != 0 {
+		iw.memoryThreshold = opts.MemoryStagingThreshold
+	}
+	if opts.MemoryStagingBudget != 0 {
+		iw.memoryBudget = opts.MemoryStagingBudget
+	}
+	if opts.DataBufferSize != 0 {
+		iw.dataBufferSize = int(fileLengthToSectors(uint32(opts.DataBufferSize))) * int(sectorSize)
+	}
+	if opts.DataBuffers != 0 {
+		iw.dataBuffers = opts.DataBuffers
+	}
+	iw.readahead = opts.Readahead
+
+	return iw, nil
+}
+
+// SourceDateEpoch returns the time given by the SOURCE_DATE_EPOCH environment variable in seconds since
+// the Unix epoch, see https://reproducible-builds.org/specs/source-date-epoch/. ok is false if it isn't set.
+func SourceDateEpoch() (t time.Time, ok bool, err error) {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", value, err)
+	}
+	return time.Unix(seconds, 0).UTC(), true, nil
+}
+
+// now returns the time used for entries without a modification time of their own
+func (iw *ImageWriter) now() time.Time {
+	if !iw.fixedTime.IsZero() {
+		return iw.fixedTime
+	}
+	return time.Now()
+}
+
+// newFileEntry creates a staged file with the default permissions
+func (iw *ImageWriter) newFileEntry(source stagedSource) *stagedEntry {
+	entry := newStagedFile("", source, iw.now())
+	if iw.fileMode != 0 {
+		entry.mode = iw.fileMode
+	}
+	return entry
+}
+
+// newDirectoryEntry creates a staged directory with the default permissions
+func (iw *ImageWriter) newDirectoryEntry(name string) *stagedEntry {
+	entry := newStagedDirectory(name, iw.now())
+	if iw.dirMode != 0 {
+		entry.mode = fs.ModeDir | iw.dirMode
+	}
+	return entry
+}
@@ -0,0 +1,175 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWriterWithOptionsValidation(t *testing.T) {
+	for opts, expected := range map[*WriterOptions]string{
+		{RockRidgeIdentifier: RockRidgeIdentifierP1282}:                   "a Rock Ridge identifier requires Rock Ridge to be enabled",
+		{Zisofs: &ZisofsOptions{}}:                                        "zisofs compression requires Rock Ridge to be enabled",
+		{DeepDirectories: DeepDirectoriesRelocate}:                        "relocating deep directories requires Rock Ridge to be enabled",
+		{PreserveDeviceNodes: true}:                                       "preserving device nodes requires Rock Ridge to be enabled",
+		{InterchangeLevel: 4}:                                             "unsupported interchange level 4",
+		{DefaultFileMode: os.ModeSetuid | 0755}:                           "default file mode urwxr-xr-x has bits other than the permissions",
+		{DefaultDirMode: os.ModeDir | 0755}:                               "default directory mode drwxr-xr-x has bits other than the permissions",
+		{EnableRockRidge: true, RockRidgeIdentifier: "RRIP_INVALID"}:      `unknown Rock Ridge identifier "RRIP_INVALID"`,
+		{SystemArea: make([]byte, systemAreaSize+1)}:                      "system area of 32769 bytes exceeds the maximum of 32768 bytes",
+		{EnableRockRidge: true, Zisofs: &ZisofsOptions{BlockSize: 12345}}: "invalid zisofs block size 12345, must be 32, 64 or 128 KiB",
+		{DataBufferSize: -1}:                                              "invalid data buffer size -1",
+		{DataBuffers: -2}:                                                 "negative number of data buffers -2",
+		{Reproducible: true, PreserveTimes: true}:                         "reproducible output cannot preserve the access times of local files",
+		{Extensions: []ExtensionRecord{{Identifier: "ACME_1"}}}:           "SUSP extensions require Rock Ridge to be enabled",
+		{EnableRockRidge: true, Extensions: []ExtensionRecord{{}}}:        "the identifier of a SUSP extension cannot be empty",
+	} {
+		w, err := NewWriterWithOptions(*opts)
+		assert.EqualError(t, err, expected)
+		assert.Nil(t, w)
+	}
+
+	// level 1 names without Rock Ridge don't contradict each other
+	w, err := NewWriterWithOptions(WriterOptions{InterchangeLevel: 1})
+	require.NoError(t, err)
+	require.NoError(t, w.Cleanup())
+
+	// the zero value matches NewWriter
+	w, err = NewWriterWithOptions(WriterOptions{})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+	defaults, err := NewWriter()
+	require.NoError(t, err)
+	defer defaults.Cleanup() // nolint: errcheck
+	assert.Equal(t, defaults.VolumeMetadata(), w.VolumeMetadata())
+	assert.False(t, w.rockRidge)
+}
+
+func writeWithOptions(t *testing.T, opts WriterOptions, files map[string]string) []byte {
+	w, err := NewWriterWithOptions(opts)
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	for name, content := range files {
+		require.NoError(t, w.AddFile(strings.NewReader(content), name))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "options"))
+	return buf.Bytes()
+}
+
+func TestWriterOptionsFixedTimestamp(t *testing.T) {
+	timestamp := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+	opts := WriterOptions{EnableRockRidge: true, FixedTimestamp: timestamp}
+	files := map[string]string{"dir/a.txt": "a", "b.txt": "b"}
+
+	first := writeWithOptions(t, opts, files)
+	assert.Equal(t, first, writeWithOptions(t, opts, files))
+
+	img, err := OpenImage(bytes.NewReader(first))
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	for _, c := range children {
+		assert.True(t, timestamp.Equal(c.ModTime()), c.Name())
+	}
+}
+
+func TestWriterOptionsReproducible(t *testing.T) {
+	opts := WriterOptions{EnableRockRidge: true, Joliet: true, Reproducible: true}
+	files := map[string]string{"dir/a.txt": "a", "dir/b.txt": "a", "c.txt": "c"}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+	first := writeWithOptions(t, opts, files)
+	// the map is iterated in a different order every time
+	for n := 0; n < 5; n++ {
+		assert.Equal(t, first, writeWithOptions(t, opts, files))
+	}
+	img, err := OpenImage(bytes.NewReader(first))
+	require.NoError(t, err)
+	times, err := img.VolumeTimes()
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1600000000, 0).UTC(), times.Creation)
+
+	// without SOURCE_DATE_EPOCH, the Unix epoch is used
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	img, err = OpenImage(bytes.NewReader(writeWithOptions(t, opts, files)))
+	require.NoError(t, err)
+	times, err = img.VolumeTimes()
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(0, 0).UTC(), times.Modification)
+
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	_, err = NewWriterWithOptions(opts)
+	assert.EqualError(t, err, `invalid SOURCE_DATE_EPOCH "yesterday": strconv.ParseInt: parsing "yesterday": invalid syntax`)
+}
+
+func TestWriterOptionsPadSectors(t *testing.T) {
+	files := map[string]string{"a.txt": "a"}
+	unpadded := writeWithOptions(t, WriterOptions{}, files)
+	padded := writeWithOptions(t, WriterOptions{PadSectors: 150}, files)
+	require.Equal(t, len(unpadded)+150*int(sectorSize), len(padded))
+	assert.True(t, isZero(padded[len(unpadded):]))
+
+	img, err := OpenImage(bytes.NewReader(padded))
+	require.NoError(t, err)
+	pvd, err := img.primaryVolume()
+	require.NoError(t, err)
+	assert.Equal(t, int32(len(padded)/int(sectorSize)), pvd.VolumeSpaceSize)
+}
+
+func TestWriterOptionsDeduplicate(t *testing.T) {
+	content := strings.Repeat("same", int(sectorSize))
+	files := map[string]string{"a.txt": content, "b.txt": content, "c.txt": "different", "d.txt": ""}
+
+	duplicated := writeWithOptions(t, WriterOptions{}, files)
+	deduplicated := writeWithOptions(t, WriterOptions{Deduplicate: true}, files)
+	assert.Equal(t, len(duplicated)-len(content), len(deduplicated))
+
+	img, err := OpenImage(bytes.NewReader(deduplicated))
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 4)
+
+	assert.Equal(t, children[0].de.ExtentLocation, children[1].de.ExtentLocation)
+	assert.NotEqual(t, children[0].de.ExtentLocation, children[2].de.ExtentLocation)
+	for i, expected := range []string{content, content, "different", ""} {
+		data, err := io.ReadAll(children[i].Reader())
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(data), children[i].Name())
+	}
+}
+
+func TestWriterOptionsDefaultModes(t *testing.T) {
+	data := writeWithOptions(t, WriterOptions{EnableRockRidge: true, DefaultFileMode: 0600, DefaultDirMode: 0700}, map[string]string{"dir/a.txt": "a"})
+
+	img, err := OpenImage(bytes.NewReader(data))
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	dirs, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, dirs, 1)
+	assert.Equal(t, os.ModeDir|0700, dirs[0].Mode())
+
+	files, err := dirs[0].GetChildren()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, os.FileMode(0600), files[0].Mode())
+}
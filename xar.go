@@ -0,0 +1,291 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// extendedAttributeRecordMinLength is the length of an Extended Attribute Record without its
+// Application Use field and escape sequences, see ECMA-119 9.5
+const extendedAttributeRecordMinLength = 250
+
+// ExtendedAttributeRecord holds the attributes recorded in front of the data of a file, as defined in ECMA-119 9.5.
+// Images which predate Rock Ridge, such as those of VMS and older UNIX systems, record the owner,
+// the permissions and the dates of files there. The record takes the number of logical blocks
+// given by the ExtendedAtributeRecordLength of the directory record, and the data follows it.
+type ExtendedAttributeRecord struct {
+	OwnerID uint16
+	GroupID uint16
+	// Permissions holds the bits of ECMA-119 9.5.3, where a zero bit grants the permission, see Mode
+	Permissions      uint16
+	Creation         VolumeDescriptorTimestamp
+	Modification     VolumeDescriptorTimestamp
+	Expiration       VolumeDescriptorTimestamp
+	Effective        VolumeDescriptorTimestamp
+	RecordFormat     byte
+	RecordAttributes byte
+	RecordLength     uint16
+	SystemIdentifier string
+	SystemUse        [64]byte
+	Version          byte
+	ApplicationUse   []byte
+	EscapeSequences  []byte
+}
+
+// the read and execute permissions of the classes of users in ECMA-119 9.5.3,
+// the bits in between are always set
+const (
+	xarSystemRead = 1 << (2 * iota)
+	xarSystemExecute
+	xarOwnerRead
+	xarOwnerExecute
+	xarGroupRead
+	xarGroupExecute
+	xarOtherRead
+	xarOtherExecute
+
+	xarReservedPermissions = 0xAAAA
+)
+
+// xarPermissionModes maps the permission bits of an Extended Attribute Record to those of a file mode
+var xarPermissionModes = []struct {
+	bit  uint16
+	mode fs.FileMode
+}{
+	{xarOwnerRead, 0400},
+	{xarOwnerExecute, 0100},
+	{xarGroupRead, 0040},
+	{xarGroupExecute, 0010},
+	{xarOtherRead, 0004},
+	{xarOtherExecute, 0001},
+}
+
+// Mode returns the permissions granted by the record as those of a file mode.
+// ISO 9660 files cannot be written, so the write bits are never set.
+func (xar *ExtendedAttributeRecord) Mode() fs.FileMode {
+	var mode fs.FileMode
+	for _, p := range xarPermissionModes {
+		if xar.Permissions&p.bit == 0 {
+			mode |= p.mode
+		}
+	}
+	return mode
+}
+
+// xarPermissions returns the permission bits of an Extended Attribute Record granting the read and execute
+// permissions of a file mode. The System class gets the permissions of the owner.
+func xarPermissions(mode fs.FileMode) uint16 {
+	permissions := uint16(0xFFFF)
+	for _, p := range xarPermissionModes {
+		if mode&p.mode != 0 {
+			permissions &^= p.bit
+		}
+	}
+	if mode&0400 != 0 {
+		permissions &^= xarSystemRead
+	}
+	if mode&0100 != 0 {
+		permissions &^= xarSystemExecute
+	}
+	return permissions
+}
+
+// UnmarshalBinary decodes an ExtendedAttributeRecord from binary form.
+// Dates which cannot be decoded, such as those left zero-filled, are read as unspecified.
+func (xar *ExtendedAttributeRecord) UnmarshalBinary(data []byte) error {
+	if len(data) < extendedAttributeRecordMinLength {
+		return io.ErrUnexpectedEOF
+	}
+
+	owner, err := UnmarshalInt16LSBMSB(data[0:4])
+	if err != nil {
+		return fmt.Errorf("owner identification: %w", err)
+	}
+	group, err := UnmarshalInt16LSBMSB(data[4:8])
+	if err != nil {
+		return fmt.Errorf("group identification: %w", err)
+	}
+	recordLength, err := UnmarshalInt16LSBMSB(data[80:84])
+	if err != nil {
+		return fmt.Errorf("record length: %w", err)
+	}
+	applicationUseLength, err := UnmarshalInt16LSBMSB(data[246:250])
+	if err != nil {
+		return fmt.Errorf("length of application use: %w", err)
+	}
+
+	applicationUseEnd := extendedAttributeRecordMinLength + int(uint16(applicationUseLength))
+	escapeSequencesEnd := applicationUseEnd + int(data[181])
+	if escapeSequencesEnd > len(data) {
+		return fmt.Errorf("the application use and escape sequences of %d bytes exceed the record", escapeSequencesEnd-extendedAttributeRecordMinLength)
+	}
+
+	*xar = ExtendedAttributeRecord{
+		OwnerID:          uint16(owner),
+		GroupID:          uint16(group),
+		Permissions:      binary.BigEndian.Uint16(data[8:10]),
+		Creation:         xarTimestamp(data[10:27]),
+		Modification:     xarTimestamp(data[27:44]),
+		Expiration:       xarTimestamp(data[44:61]),
+		Effective:        xarTimestamp(data[61:78]),
+		RecordFormat:     data[78],
+		RecordAttributes: data[79],
+		RecordLength:     uint16(recordLength),
+		SystemIdentifier: string(bytes.TrimRight(data[84:116], " \x00")),
+		Version:          data[180],
+		ApplicationUse:   append([]byte(nil), data[extendedAttributeRecordMinLength:applicationUseEnd]...),
+		EscapeSequences:  append([]byte(nil), data[applicationUseEnd:escapeSequencesEnd]...),
+	}
+	copy(xar.SystemUse[:], data[116:180])
+	return nil
+}
+
+// xarTimestamp decodes a date of an Extended Attribute Record, or returns an unspecified one if it is invalid
+func xarTimestamp(data []byte) VolumeDescriptorTimestamp {
+	var ts VolumeDescriptorTimestamp
+	if ts.UnmarshalBinary(data) != nil {
+		return VolumeDescriptorTimestamp{}
+	}
+	return ts
+}
+
+// MarshalBinary encodes the ExtendedAttributeRecord to its binary form, padded to whole sectors
+func (xar *ExtendedAttributeRecord) MarshalBinary() ([]byte, error) {
+	if len(xar.ApplicationUse) > 0xFFFF {
+		return nil, fmt.Errorf("the application use of %d bytes is too long", len(xar.ApplicationUse))
+	}
+	if len(xar.EscapeSequences) > 0xFF {
+		return nil, fmt.Errorf("the escape sequences of %d bytes are too long", len(xar.EscapeSequences))
+	}
+
+	length := extendedAttributeRecordMinLength + len(xar.ApplicationUse) + len(xar.EscapeSequences)
+	output := make([]byte, int(fileLengthToSectors(uint32(length)))*int(sectorSize))
+
+	WriteInt16LSBMSB(output[0:4], int16(xar.OwnerID))
+	WriteInt16LSBMSB(output[4:8], int16(xar.GroupID))
+	binary.BigEndian.PutUint16(output[8:10], xar.Permissions|xarReservedPermissions)
+	for n, ts := range []VolumeDescriptorTimestamp{xar.Creation, xar.Modification, xar.Expiration, xar.Effective} {
+		d, err := ts.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		copy(output[10+17*n:27+17*n], d)
+	}
+	output[78] = xar.RecordFormat
+	output[79] = xar.RecordAttributes
+	WriteInt16LSBMSB(output[80:84], int16(xar.RecordLength))
+	copy(output[84:116], MarshalString(xar.SystemIdentifier, 32))
+	copy(output[116:180], xar.SystemUse[:])
+	output[180] = xar.Version
+	output[181] = byte(len(xar.EscapeSequences))
+	WriteInt16LSBMSB(output[246:250], int16(len(xar.ApplicationUse)))
+	copy(output[extendedAttributeRecordMinLength:], xar.ApplicationUse)
+	copy(output[extendedAttributeRecordMinLength+len(xar.ApplicationUse):], xar.EscapeSequences)
+	return output, nil
+}
+
+// dataOffset returns the position in bytes of the data of the record's extent, which follows its Extended Attribute Record,
+// with the location and the length of the record counted in logical blocks of the given size
+func (de *DirectoryEntry) dataOffset(blockSize int64) int64 {
+	return (int64(de.ExtentLocation) + int64(de.ExtendedAtributeRecordLength)) * blockSize
+}
+
+// ExtendedAttributes returns the Extended Attribute Record of the entry, or nil if it has none.
+// It is read from the image once, when it is first needed.
+func (f *File) ExtendedAttributes() (*ExtendedAttributeRecord, error) {
+	if f.de.ExtendedAtributeRecordLength == 0 {
+		return nil, nil
+	}
+	f.xarOnce.Do(func() {
+		data := make([]byte, int64(f.de.ExtendedAtributeRecordLength)*f.logicalBlockSize())
+		if _, err := f.ra.ReadAt(data, int64(f.de.ExtentLocation)*f.logicalBlockSize()); err != nil {
+			f.xarErr = fmt.Errorf("reading the extended attribute record of %s: %w", f.Name(), err)
+			return
+		}
+		xar := &ExtendedAttributeRecord{}
+		if err := xar.UnmarshalBinary(data); err != nil {
+			f.xarErr = fmt.Errorf("the extended attribute record of %s: %w", f.Name(), err)
+			return
+		}
+		f.xar = xar
+	})
+	return f.xar, f.xarErr
+}
+
+// extendedAttributes returns the Extended Attribute Record of an entry without Rock Ridge attributes,
+// which take precedence, or nil if it has none or it cannot be read
+func (f *File) extendedAttributes() *ExtendedAttributeRecord {
+	if f.hasRockRidge() || f.de.ExtendedAtributeRecordLength == 0 {
+		return nil
+	}
+	xar, err := f.ExtendedAttributes()
+	if err != nil {
+		return nil
+	}
+	return xar
+}
+
+// extendedAttributeRecord returns the Extended Attribute Record written in front of the data of a file
+func (wc *writeContext) extendedAttributeRecord(n *layoutNode) ([]byte, error) {
+	e := n.entry
+	uid, gid := e.uid, e.gid
+	if wc.ownerMap != nil {
+		uid, gid = wc.ownerMap(e.path(), uid, gid)
+	}
+	times := wc.recordTimes(e)
+	xar := ExtendedAttributeRecord{
+		OwnerID:      uint16(uid),
+		GroupID:      uint16(gid),
+		Permissions:  xarPermissions(e.mode),
+		Creation:     volumeTimestamp(times.Creation, time.Time{}),
+		Modification: volumeTimestamp(times.Modification, time.Time{}),
+		Effective:    volumeTimestamp(times.Modification, time.Time{}),
+		Version:      1,
+	}
+	return xar.MarshalBinary()
+}
+
+// addExtendedAttributeRecords puts an Extended Attribute Record in front of the data of every file,
+// except those left in place in an edited image and those too large for a single extent
+func (wc *writeContext) addExtendedAttributeRecords() error {
+	for _, file := range wc.files {
+		if file.source == nil || file.source.Size() > maxExtentLength-int64(sectorSize) {
+			continue
+		}
+		if _, ok := wc.inPlace[sourceIdentity(file.source)]; ok {
+			continue
+		}
+		record, err := wc.extendedAttributeRecord(file)
+		if err != nil {
+			return fmt.Errorf("processing %s: %w", file.entry.path(), err)
+		}
+		file.source = &extendedAttributeSource{stagedSource: file.source, record: record}
+		file.extendedAttributeSectors = uint32(len(record)) / sectorSize
+	}
+	return nil
+}
+
+// extendedAttributeSource prepends an Extended Attribute Record to the data of a file
+type extendedAttributeSource struct {
+	stagedSource
+	record []byte
+}
+
+func (s *extendedAttributeSource) Size() int64 {
+	return int64(len(s.record)) + s.stagedSource.Size()
+}
+
+func (s *extendedAttributeSource) Open() (io.ReadCloser, error) {
+	r, err := s.stagedSource.Open()
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(s.record), r), r}, nil
+}
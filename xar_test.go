@@ -0,0 +1,106 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtendedAttributeRecordRoundTrip(t *testing.T) {
+	xar := ExtendedAttributeRecord{
+		OwnerID:          1000,
+		GroupID:          100,
+		Permissions:      xarPermissions(0754),
+		Creation:         VolumeDescriptorTimestampFromTime(time.Date(1994, 3, 1, 12, 0, 0, 0, time.UTC)),
+		Modification:     VolumeDescriptorTimestampFromTime(time.Date(1995, 4, 2, 13, 30, 0, 0, time.UTC)),
+		RecordFormat:     1,
+		RecordAttributes: 2,
+		RecordLength:     512,
+		SystemIdentifier: "VMS",
+		Version:          1,
+		ApplicationUse:   []byte("application"),
+		EscapeSequences:  []byte{0x25, 0x2F, 0x40},
+	}
+	xar.SystemUse[0] = 0x42
+
+	data, err := xar.MarshalBinary()
+	require.NoError(t, err)
+	assert.Len(t, data, int(sectorSize))
+
+	var decoded ExtendedAttributeRecord
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	// the reserved bits are always set
+	assert.Equal(t, xar.Permissions|xarReservedPermissions, decoded.Permissions)
+	decoded.Permissions = xar.Permissions
+	assert.Equal(t, xar, decoded)
+	assert.Equal(t, fs.FileMode(0554), decoded.Mode())
+
+	assert.ErrorIs(t, decoded.UnmarshalBinary(data[:100]), io.ErrUnexpectedEOF)
+	data[247] = 0xFF
+	data[248] = 0xFF
+	assert.Error(t, decoded.UnmarshalBinary(data))
+}
+
+func TestExtendedAttributeRecordsWritten(t *testing.T) {
+	for _, rockRidge := range []bool{false, true} {
+		iw, err := NewWriterWithOptions(WriterOptions{ExtendedAttributeRecords: true, EnableRockRidge: rockRidge})
+		require.NoError(t, err)
+		defer iw.Cleanup() // nolint: errcheck
+		require.NoError(t, iw.addReader(strings.NewReader("owned"), "OWNED.TXT", "reader", WithOwner(42, 7), WithMode(0750)))
+		require.NoError(t, iw.AddFile(strings.NewReader(""), "EMPTY.TXT"))
+		require.NoError(t, iw.AddDirectory("DIR"))
+
+		var buf bytes.Buffer
+		require.NoError(t, iw.WriteTo(&buf, "xar"))
+		img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		root, err := img.RootDir()
+		require.NoError(t, err)
+		children, err := root.GetChildren()
+		require.NoError(t, err)
+		require.Len(t, children, 3)
+
+		dir, empty, owned := children[0], children[1], children[2]
+		assert.Equal(t, byte(1), owned.de.ExtendedAtributeRecordLength)
+		assert.Equal(t, uint32(5), owned.de.ExtentLength)
+		data, err := io.ReadAll(owned.Reader())
+		require.NoError(t, err)
+		assert.Equal(t, "owned", string(data))
+
+		xar, err := owned.ExtendedAttributes()
+		require.NoError(t, err)
+		require.NotNil(t, xar)
+		assert.Equal(t, uint16(42), xar.OwnerID)
+		assert.Equal(t, uint16(7), xar.GroupID)
+		assert.Equal(t, fs.FileMode(0550), xar.Mode())
+
+		// Rock Ridge takes precedence over the record
+		uid, gid, ok := owned.Owner()
+		assert.True(t, ok)
+		assert.Equal(t, [2]uint32{42, 7}, [2]uint32{uid, gid})
+		if rockRidge {
+			assert.Equal(t, fs.FileMode(0750), owned.Mode())
+		} else {
+			assert.Equal(t, fs.FileMode(0550), owned.Mode())
+		}
+
+		assert.Equal(t, byte(1), empty.de.ExtendedAtributeRecordLength)
+		assert.Equal(t, int64(0), empty.Size())
+		xar, err = dir.ExtendedAttributes()
+		assert.NoError(t, err)
+		assert.Nil(t, xar)
+
+		report, err := img.Verify(WithFileData())
+		require.NoError(t, err)
+		assert.Empty(t, report.Errors())
+	}
+}
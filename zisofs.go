@@ -0,0 +1,399 @@
+package iso9660
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+// zisofs is the compressed file format used by the Linux kernel,
+// see https://github.com/torvalds/linux/blob/v6.6/fs/isofs/compress.c
+//
+// A compressed file starts with a 16-byte header followed by a table of
+// little-endian block pointers and the zlib-compressed blocks.
+// A block whose start and end pointers are equal consists only of zeros.
+
+var zisofsMagic = [8]byte{0x37, 0xE4, 0x53, 0x96, 0xC9, 0xDB, 0xD6, 0x07}
+
+const (
+	zisofsHeaderSize = 16
+	// the header size is stored in units of 4 bytes, in the file and the ZF entry
+	zisofsHeaderSizeDiv4 = zisofsHeaderSize / 4
+
+	zisofsMinBlockSizeLog2 = 15
+	zisofsMaxBlockSizeLog2 = 17
+
+	// zisofsMaxSize is the largest uncompressed size the 32-bit fields of the header and the ZF entry hold
+	zisofsMaxSize = math.MaxUint32
+)
+
+// ZisofsOptions controls the zisofs compression of file data
+type ZisofsOptions struct {
+	// BlockSize is the size of the independently compressed blocks
+	// and must be 32 KiB, 64 KiB or 128 KiB. Zero means 32 KiB.
+	BlockSize uint32
+	// Level is the zlib compression level. Zero means zlib.DefaultCompression.
+	Level int
+	// MinSize is the size below which files are stored uncompressed,
+	// as the header and block pointers outweigh the savings on small files. Zero compresses files of any size.
+	MinSize int64
+	// MaxRatio is the largest ratio of the compressed to the uncompressed size for which a file is stored compressed,
+	// between 0 and 1. Zero stores it compressed whenever that makes it smaller.
+	// Readers decompress whole blocks, so lower ratios trade space for cheaper reads of barely compressible files.
+	MaxRatio float64
+}
+
+func (opts *ZisofsOptions) validate() error {
+	if _, err := opts.blockSizeLog2(); err != nil {
+		return err
+	}
+	if opts.MinSize < 0 {
+		return fmt.Errorf("invalid zisofs minimum size %d", opts.MinSize)
+	}
+	if opts.MaxRatio < 0 || opts.MaxRatio > 1 {
+		return fmt.Errorf("invalid zisofs compression ratio %g, must be between 0 and 1", opts.MaxRatio)
+	}
+	return nil
+}
+
+// worthwhile reports whether a file of the given size is stored compressed if it shrinks to compressedSize
+func (opts *ZisofsOptions) worthwhile(size, compressedSize int64) bool {
+	if compressedSize >= size {
+		return false
+	}
+	return opts.MaxRatio == 0 || float64(compressedSize) <= opts.MaxRatio*float64(size)
+}
+
+func (opts *ZisofsOptions) blockSizeLog2() (byte, error) {
+	if opts.BlockSize == 0 {
+		return zisofsMinBlockSizeLog2, nil
+	}
+
+	for log2 := byte(zisofsMinBlockSizeLog2); log2 <= zisofsMaxBlockSizeLog2; log2++ {
+		if opts.BlockSize == 1<<log2 {
+			return log2, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid zisofs block size %d, must be 32, 64 or 128 KiB", opts.BlockSize)
+}
+
+func (opts *ZisofsOptions) level() int {
+	if opts.Level == 0 {
+		return zlib.DefaultCompression
+	}
+	return opts.Level
+}
+
+// zisofsInfo describes the compressed form of a file, as stored in the RRIP ZF entry
+type zisofsInfo struct {
+	headerSizeDiv4   byte
+	blockSizeLog2    byte
+	uncompressedSize uint32
+}
+
+// marshalZisofsEntry encodes the ZF entry as written by mkzftree and understood by Linux
+func marshalZisofsEntry(info *zisofsInfo) SystemUseEntry {
+	data := make([]byte, 12)
+	copy(data[0:2], "pz")
+	data[2] = info.headerSizeDiv4
+	data[3] = info.blockSizeLog2
+	WriteInt32LSBMSB(data[4:12], int32(info.uncompressedSize))
+	return newSystemUseEntry("ZF", 1, data)
+}
+
+func umarshalZisofsEntry(e SystemUseEntry) (*zisofsInfo, error) {
+	data := e.Data()
+	if len(data) < 12 {
+		return nil, fmt.Errorf("unmarshal ZF entry: %w", io.ErrUnexpectedEOF)
+	}
+	if string(data[0:2]) != "pz" {
+		return nil, fmt.Errorf("unmarshal ZF entry: unknown algorithm %q", data[0:2])
+	}
+
+	size, err := UnmarshalUint32LSBMSB(data[4:12])
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal ZF entry: %w", err)
+	}
+
+	return &zisofsInfo{
+		headerSizeDiv4:   data[2],
+		blockSizeLog2:    data[3],
+		uncompressedSize: size,
+	}, nil
+}
+
+// getZisofsInfo returns the decoded ZF entry or nil if there is none
+func (s SystemUseEntrySlice) getZisofsInfo() (*zisofsInfo, error) {
+	for _, entry := range s {
+		if entry.Type() == "ZF" {
+			return umarshalZisofsEntry(entry)
+		}
+	}
+	return nil, nil
+}
+
+// compressZisofs compresses the source into dst.
+// It returns the compressed size, which can be larger than the input for incompressible data.
+func compressZisofs(dst io.WriterAt, src io.Reader, size int64, opts *ZisofsOptions) (int64, error) {
+	if size > zisofsMaxSize {
+		return 0, fmt.Errorf("%w: zisofs records uncompressed sizes of at most %d bytes, not %d", ErrFileTooLarge, int64(zisofsMaxSize), size)
+	}
+	blockSizeLog2, err := opts.blockSizeLog2()
+	if err != nil {
+		return 0, err
+	}
+	blockSize := int64(1) << blockSizeLog2
+	blocks := (size + blockSize - 1) / blockSize
+
+	header := make([]byte, zisofsHeaderSize)
+	copy(header[0:8], zisofsMagic[:])
+	binary.LittleEndian.PutUint32(header[8:12], uint32(size))
+	header[12] = zisofsHeaderSizeDiv4
+	header[13] = blockSizeLog2
+	if _, err = dst.WriteAt(header, 0); err != nil {
+		return 0, err
+	}
+
+	pointers := make([]byte, 4*(blocks+1))
+	offset := int64(zisofsHeaderSize + len(pointers))
+	binary.LittleEndian.PutUint32(pointers[0:4], uint32(offset))
+
+	block := make([]byte, blockSize)
+	var compressed bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&compressed, opts.level())
+	if err != nil {
+		return 0, err
+	}
+
+	for i := int64(0); i < blocks; i++ {
+		n, err := io.ReadFull(src, block[:min64(blockSize, size-i*blockSize)])
+		if err != nil {
+			return 0, err
+		}
+
+		if !isZero(block[:n]) {
+			compressed.Reset()
+			zw.Reset(&compressed)
+			if _, err = zw.Write(block[:n]); err != nil {
+				return 0, err
+			}
+			if err = zw.Close(); err != nil {
+				return 0, err
+			}
+			if _, err = dst.WriteAt(compressed.Bytes(), offset); err != nil {
+				return 0, err
+			}
+			offset += int64(compressed.Len())
+		}
+
+		if offset > int64(^uint32(0)) {
+			return 0, ErrFileTooLarge
+		}
+		binary.LittleEndian.PutUint32(pointers[4*(i+1):4*(i+2)], uint32(offset))
+	}
+
+	if _, err = dst.WriteAt(pointers, zisofsHeaderSize); err != nil {
+		return 0, err
+	}
+
+	return offset, nil
+}
+
+// compressToStaging compresses the source into a new file in the staging directory.
+// It returns nil if compression doesn't make the file small enough, see ZisofsOptions.MaxRatio.
+func compressToStaging(stagingPath string, source stagedSource, opts *ZisofsOptions) (stagedSource, *zisofsInfo, error) {
+	r, err := source.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	f, err := os.OpenFile(stagingPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	compressedSize, err := compressZisofs(f, r, source.Size(), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !opts.worthwhile(source.Size(), compressedSize) {
+		return nil, nil, os.Remove(stagingPath)
+	}
+
+	blockSizeLog2, _ := opts.blockSizeLog2()
+	info := &zisofsInfo{
+		headerSizeDiv4:   zisofsHeaderSizeDiv4,
+		blockSizeLog2:    blockSizeLog2,
+		uncompressedSize: uint32(source.Size()),
+	}
+	return &localFileSource{path: stagingPath, size: compressedSize}, info, nil
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// zisofsReader decompresses a zisofs file on the fly. Reads at random decompress the blocks they touch,
+// the last block decompressed is kept for the following reads.
+type zisofsReader struct {
+	ra        io.ReaderAt
+	size      int64
+	blockSize int64
+	pointers  []uint32
+
+	// mu guards the block kept, as ReadAt may be called concurrently
+	mu       sync.Mutex
+	block    int64
+	current  []byte
+	position int64
+}
+
+var errZisofsCorrupted = errors.New("corrupted zisofs data")
+
+func newZisofsReader(ra io.ReaderAt, info *zisofsInfo) (*zisofsReader, error) {
+	if info.blockSizeLog2 < zisofsMinBlockSizeLog2 || info.blockSizeLog2 > zisofsMaxBlockSizeLog2 {
+		return nil, fmt.Errorf("%w: block size 2^%d", errZisofsCorrupted, info.blockSizeLog2)
+	}
+
+	zr := &zisofsReader{
+		ra:        ra,
+		size:      int64(info.uncompressedSize),
+		blockSize: int64(1) << info.blockSizeLog2,
+	}
+
+	blocks := (zr.size + zr.blockSize - 1) / zr.blockSize
+	table := make([]byte, 4*(blocks+1))
+	if _, err := ra.ReadAt(table, int64(info.headerSizeDiv4)*4); err != nil {
+		return nil, fmt.Errorf("reading zisofs block pointers: %w", err)
+	}
+
+	zr.pointers = make([]uint32, blocks+1)
+	for i := range zr.pointers {
+		zr.pointers[i] = binary.LittleEndian.Uint32(table[4*i : 4*(i+1)])
+		if i > 0 && zr.pointers[i] < zr.pointers[i-1] {
+			return nil, fmt.Errorf("%w: block pointers are not ascending", errZisofsCorrupted)
+		}
+	}
+
+	return zr, nil
+}
+
+// loadBlock decompresses the block with the given index
+func (zr *zisofsReader) loadBlock(index int64) error {
+	length := min64(zr.blockSize, zr.size-index*zr.blockSize)
+	start, end := zr.pointers[index], zr.pointers[index+1]
+
+	if start == end {
+		zr.current = make([]byte, length)
+		zr.block = index
+		return nil
+	}
+
+	compressed := make([]byte, end-start)
+	if _, err := zr.ra.ReadAt(compressed, int64(start)); err != nil {
+		return fmt.Errorf("reading zisofs block %d: %w", index, err)
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return fmt.Errorf("%w: block %d: %s", errZisofsCorrupted, index, err)
+	}
+	defer r.Close()
+
+	zr.current = make([]byte, length)
+	if _, err = io.ReadFull(r, zr.current); err != nil {
+		return fmt.Errorf("%w: block %d: %s", errZisofsCorrupted, index, err)
+	}
+	zr.block = index
+	return nil
+}
+
+func (zr *zisofsReader) Read(p []byte) (int, error) {
+	if zr.position >= zr.size {
+		return 0, io.EOF
+	}
+
+	zr.mu.Lock()
+	defer zr.mu.Unlock()
+	n, err := zr.copyFrom(p, zr.position)
+	zr.position += int64(n)
+	return n, err
+}
+
+// ReadAt reads the uncompressed data at the given offset
+func (zr *zisofsReader) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.New("zisofs: negative offset")
+	}
+
+	zr.mu.Lock()
+	defer zr.mu.Unlock()
+	read := 0
+	for read < len(p) {
+		if offset+int64(read) >= zr.size {
+			return read, io.EOF
+		}
+		n, err := zr.copyFrom(p[read:], offset+int64(read))
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// copyFrom copies the data of the block holding the position to p, which must lie before the end of the file
+func (zr *zisofsReader) copyFrom(p []byte, position int64) (int, error) {
+	index := position / zr.blockSize
+	if zr.current == nil || zr.block != index {
+		if err := zr.loadBlock(index); err != nil {
+			return 0, err
+		}
+	}
+	return copy(p, zr.current[position-index*zr.blockSize:]), nil
+}
+
+// Seek sets the position of the next Read within the uncompressed data
+func (zr *zisofsReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += zr.position
+	case io.SeekEnd:
+		offset += zr.size
+	case io.SeekStart:
+	default:
+		return 0, errors.New("zisofs: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("zisofs: negative position")
+	}
+	zr.position = offset
+	return offset, nil
+}
+
+// Size returns the size of the uncompressed data
+func (zr *zisofsReader) Size() int64 {
+	return zr.size
+}
@@ -0,0 +1,197 @@
+//go:build !integration
+// +build !integration
+
+package iso9660
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZisofsRoundTrip(t *testing.T) {
+	random := make([]byte, 100000)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	for name, input := range map[string][]byte{
+		"text":      []byte(strings.Repeat(loremIpsum, 1000)),
+		"zeros":     make([]byte, 300000),
+		"random":    random,
+		"one block": []byte("tiny"),
+	} {
+		for _, blockSize := range []uint32{0, 64 * 1024, 128 * 1024} {
+			f, err := os.CreateTemp("", "iso9660_zisofs")
+			require.NoError(t, err)
+			defer os.Remove(f.Name())
+
+			opts := &ZisofsOptions{BlockSize: blockSize}
+			compressedSize, err := compressZisofs(f, bytes.NewReader(input), int64(len(input)), opts)
+			require.NoError(t, err, name)
+
+			log2, err := opts.blockSizeLog2()
+			require.NoError(t, err)
+
+			zr, err := newZisofsReader(io.NewSectionReader(f, 0, compressedSize), &zisofsInfo{
+				headerSizeDiv4:   zisofsHeaderSizeDiv4,
+				blockSizeLog2:    log2,
+				uncompressedSize: uint32(len(input)),
+			})
+			require.NoError(t, err, name)
+
+			output, err := io.ReadAll(zr)
+			assert.NoError(t, err, name)
+			assert.Equal(t, input, output, name)
+
+			// reads at random and seeks
+			_, err = zr.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+			assert.NoError(t, iotest.TestReader(zr, input), name)
+			f.Close() // nolint: errcheck
+		}
+	}
+}
+
+func TestZisofsInvalidBlockSize(t *testing.T) {
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	assert.Error(t, w.SetZisofs(&ZisofsOptions{BlockSize: 4096}))
+	assert.NoError(t, w.SetZisofs(&ZisofsOptions{BlockSize: 65536}))
+
+	// ZF entries are only recognized alongside Rock Ridge
+	assert.Error(t, w.WriteTo(io.Discard, "test"))
+}
+
+func TestWriterZisofs(t *testing.T) {
+	random := make([]byte, 50000)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	compressible := strings.Repeat(loremIpsum, 500)
+
+	// staged on disk, so that the compressed copies can be told apart in the staging directory
+	w, err := NewWriterWithOptions(WriterOptions{MemoryStagingThreshold: -1})
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	w.SetRockRidge(true)
+	require.NoError(t, w.SetZisofs(&ZisofsOptions{}))
+	require.NoError(t, w.AddFile(strings.NewReader(compressible), "compressible.txt"))
+	require.NoError(t, w.AddFile(bytes.NewReader(random), "random.bin"))
+	require.NoError(t, w.AddFile(strings.NewReader(""), "empty"))
+
+	var buf bytes.Buffer
+	require.NoError(t, w.WriteTo(&buf, "zisofs"))
+
+	img, err := OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+
+	byName := make(map[string]*File)
+	for _, c := range children {
+		byName[c.Name()] = c
+	}
+
+	text := byName["compressible.txt"]
+	if assert.NotNil(t, text.zisofsInfo()) {
+		assert.Less(t, text.de.ExtentLength, uint32(len(compressible)))
+	}
+	assert.Equal(t, int64(len(compressible)), text.Size())
+	data, err := io.ReadAll(text.Reader())
+	assert.NoError(t, err)
+	assert.Equal(t, compressible, string(data))
+
+	// incompressible data is stored as-is
+	bin := byName["random.bin"]
+	assert.Nil(t, bin.zisofsInfo())
+	data, err = io.ReadAll(bin.Reader())
+	assert.NoError(t, err)
+	assert.Equal(t, random, data)
+
+	assert.Nil(t, byName["empty"].zisofsInfo())
+
+	// both kinds of data are read at random
+	for name, expected := range map[string][]byte{"compressible.txt": []byte(compressible), "random.bin": random} {
+		r, err := byName[name].OpenReaderAt()
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(expected)), r.Size(), name)
+		assert.NoError(t, iotest.TestReader(r, expected), name)
+	}
+
+	// the compressed copies don't outlive WriteTo
+	staged, err := os.ReadDir(w.stagingDir)
+	assert.NoError(t, err)
+	assert.Len(t, staged, 3)
+}
+
+func TestWriterZisofsThresholds(t *testing.T) {
+	compressible := strings.Repeat(loremIpsum, 500)
+	// compresses, but barely
+	mixed := make([]byte, 100000)
+	_, err := rand.Read(mixed[:90000])
+	require.NoError(t, err)
+
+	w, err := NewWriter()
+	require.NoError(t, err)
+	defer w.Cleanup() // nolint: errcheck
+
+	w.SetRockRidge(true)
+	assert.Error(t, w.SetZisofs(&ZisofsOptions{MaxRatio: 1.5}))
+	assert.Error(t, w.SetZisofs(&ZisofsOptions{MinSize: -1}))
+	require.NoError(t, w.SetZisofs(&ZisofsOptions{MinSize: 1000, MaxRatio: 0.5}))
+	require.NoError(t, w.AddFile(strings.NewReader(compressible), "compressible.txt"))
+	require.NoError(t, w.AddFile(strings.NewReader(loremIpsum), "small.txt"))
+	require.NoError(t, w.AddFile(bytes.NewReader(mixed), "mixed.bin"))
+
+	img := remaster(t, w)
+	root, err := img.RootDir()
+	require.NoError(t, err)
+	children, err := root.GetChildren()
+	require.NoError(t, err)
+	compressed := make(map[string]bool)
+	for _, c := range children {
+		compressed[c.Name()] = c.zisofsInfo() != nil
+	}
+	assert.Equal(t, map[string]bool{"compressible.txt": true, "small.txt": false, "mixed.bin": false}, compressed)
+
+	// re-mastering decompresses the files, unless they are compressed again
+	for _, zisofs := range []*ZisofsOptions{nil, {}} {
+		w2, err := NewWriterFromImage(img)
+		require.NoError(t, err)
+		defer w2.Cleanup() // nolint: errcheck
+		require.NoError(t, w2.SetZisofs(zisofs))
+
+		again := remaster(t, w2)
+		snapshot := snapshotImage(t, again)
+		assert.Equal(t, int64(len(compressible)), snapshot["/compressible.txt"].Size)
+		assert.Equal(t, sha256String(compressible), snapshot["/compressible.txt"].SHA256)
+		f, err := resolvePath(mustRoot(t, again), "compressible.txt", false, false)
+		require.NoError(t, err)
+		assert.Equal(t, zisofs != nil, f.zisofsInfo() != nil)
+	}
+}
+
+func TestZisofsSizeLimit(t *testing.T) {
+	// the uncompressed size of a file of 4 GiB or more doesn't fit into the ZF entry
+	file := &layoutNode{source: hugeSource{}}
+	wc := &writeContext{zisofs: &ZisofsOptions{}, files: []*layoutNode{file}}
+	require.NoError(t, wc.compressFiles())
+	assert.Equal(t, hugeSource{}, file.source)
+	assert.Nil(t, file.zisofs)
+
+	_, err := compressZisofs(nil, nil, math.MaxUint32+1, &ZisofsOptions{})
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+}